@@ -0,0 +1,45 @@
+// Command openapi writes the API's OpenAPI document, for generating the Swift and TypeScript clients.
+//
+// Usage:
+//
+//	go run ./cmd/openapi -o openapi.json
+//
+// The document is the same one the server serves at /api/schema/openapi.json, and its hash is what
+// /api/schema/version reports.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/apischema"
+)
+
+func main() {
+	output := flag.String("o", "", "file to write the document to (default stdout)")
+	printHash := flag.Bool("hash", false, "print the schema hash instead of the document")
+	flag.Parse()
+
+	if *printHash {
+		fmt.Println(apischema.Hash())
+		return
+	}
+
+	var doc bytes.Buffer
+	if err := json.Indent(&doc, apischema.Document(), "", "  "); err != nil {
+		log.Fatalf("Failed to format OpenAPI document: %v", err)
+	}
+	doc.WriteByte('\n')
+
+	if *output == "" {
+		os.Stdout.Write(doc.Bytes())
+		return
+	}
+	if err := os.WriteFile(*output, doc.Bytes(), 0o644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+}
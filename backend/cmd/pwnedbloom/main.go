@@ -0,0 +1,100 @@
+// Command pwnedbloom builds the offline Bloom filter used to screen passwords
+// against the Have I Been Pwned list when its range API can't be reached.
+//
+// Usage:
+//
+//	go run ./cmd/pwnedbloom -in pwnedpasswords.txt -out pwned.bloom
+//
+// The input is the SHA-1 list from the Pwned Passwords downloader, one
+// "HASH:COUNT" line per password. Passwords seen fewer than -min-count times
+// can be left out to keep the filter small; at the default false positive rate
+// each password kept costs about 1.8 bytes.
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/pwned"
+)
+
+func main() {
+	in := flag.String("in", "", "Pwned Passwords SHA-1 list (HASH:COUNT per line)")
+	out := flag.String("out", "pwned.bloom", "file to write the filter to")
+	rate := flag.Float64("p", 0.001, "false positive rate")
+	minCount := flag.Int("min-count", 1, "leave out passwords seen fewer times than this")
+	flag.Parse()
+
+	if *in == "" {
+		log.Fatal("-in is required")
+	}
+	if *rate <= 0 || *rate >= 1 {
+		log.Fatal("-p must be between 0 and 1")
+	}
+
+	// The filter is sized from the number of hashes, so the list is read twice
+	var count uint64
+	if err := scan(*in, *minCount, func([sha1.Size]byte) { count++ }); err != nil {
+		log.Fatalf("Failed to read %s: %v", *in, err)
+	}
+	if count == 0 {
+		log.Fatalf("No hashes in %s with a count of at least %d", *in, *minCount)
+	}
+
+	bloom := pwned.NewBloom(count, *rate)
+	if err := scan(*in, *minCount, bloom.Add); err != nil {
+		log.Fatalf("Failed to read %s: %v", *in, err)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", *out, err)
+	}
+	size, err := bloom.WriteTo(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Failed to write %s: %v", *out, err)
+	}
+	log.Printf("Wrote %d hashes to %s (%d bytes)", count, *out, size)
+}
+
+// scan calls add with each hash in the list seen at least minCount times
+func scan(path string, minCount int, add func([sha1.Size]byte)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		hash, countText, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" {
+			continue
+		}
+		if countText != "" {
+			if n, err := strconv.Atoi(countText); err == nil && n < minCount {
+				continue
+			}
+		}
+		var sum [sha1.Size]byte
+		if len(hash) != hex.EncodedLen(sha1.Size) {
+			log.Printf("Skipping line %d: not a SHA-1 hash", line)
+			continue
+		}
+		if _, err := hex.Decode(sum[:], []byte(hash)); err != nil {
+			log.Printf("Skipping line %d: %v", line, err)
+			continue
+		}
+		add(sum)
+	}
+	return scanner.Err()
+}
@@ -2,29 +2,56 @@ package main
 
 import (
 	"context"
-	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/apischema"
+	"github.com/hamishgilbert/notes-app/backend/internal/captcha"
 	"github.com/hamishgilbert/notes-app/backend/internal/config"
 	"github.com/hamishgilbert/notes-app/backend/internal/database"
+	"github.com/hamishgilbert/notes-app/backend/internal/demo"
 	"github.com/hamishgilbert/notes-app/backend/internal/handlers"
+	"github.com/hamishgilbert/notes-app/backend/internal/jwtkeys"
+	"github.com/hamishgilbert/notes-app/backend/internal/lifecycle"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/mail"
 	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
 	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/nats"
+	"github.com/hamishgilbert/notes-app/backend/internal/oauth"
+	"github.com/hamishgilbert/notes-app/backend/internal/passwordhash"
+	"github.com/hamishgilbert/notes-app/backend/internal/push"
+	"github.com/hamishgilbert/notes-app/backend/internal/pwned"
+	"github.com/hamishgilbert/notes-app/backend/internal/redis"
 	"github.com/hamishgilbert/notes-app/backend/internal/repository"
 	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/internal/storage"
+	"github.com/hamishgilbert/notes-app/backend/internal/tracing"
+	"github.com/hamishgilbert/notes-app/backend/internal/validation"
+	"github.com/hamishgilbert/notes-app/backend/internal/webauthn"
 	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
 	"github.com/joho/godotenv"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// application is the running server. Its components are started in order (database, migrations,
+// WebSocket hub, background jobs, HTTP server) and stopped in reverse, each within its own timeout.
+type application struct {
+	cfg       *config.Config
+	lifecycle *lifecycle.Manager
+
+	db      *database.DB
+	replica *database.Replica // nil without DATABASE_REPLICA_URL
+	hub     *websocket.Hub
+	server  *http.Server
+}
+
 func main() {
 	// Load .env file if it exists
 	_ = godotenv.Load()
@@ -32,79 +59,688 @@ func main() {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		slog.Error("Failed to load configuration", "error", err)
+		os.Exit(1)
 	}
 
+	// Log structured entries, JSON in production, carrying each request's ID, user and trace
+	logLevel, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		slog.Error("Failed to configure logging", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logging.New(os.Stderr, logging.Options{Level: logLevel, JSON: cfg.LogFormat == "json"}))
+
 	// Set Gin mode based on environment
 	if cfg.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Connect to database
-	db, err := database.New(cfg.DatabaseURL)
+	app := &application{cfg: cfg, lifecycle: lifecycle.New()}
+	if err := app.start(context.Background()); err != nil {
+		slog.Error("Failed to start server", "error", err)
+		_ = app.lifecycle.Stop()
+		os.Exit(1)
+	}
+
+	// Run until interrupted, or until the HTTP server fails
+	err = app.lifecycle.Wait(syscall.SIGINT, syscall.SIGTERM)
+	if err != nil {
+		slog.Error("Server failed", "error", err)
+	}
+	slog.Info("Shutting down server")
+	if stopErr := app.lifecycle.Stop(); stopErr != nil || err != nil {
+		os.Exit(1)
+	}
+	slog.Info("Server exited")
+}
+
+// start wires up the server, starting each component once what it depends on is running. On error,
+// the components already started are left for the caller to stop.
+func (app *application) start(ctx context.Context) error {
+	cfg := app.cfg
+
+	// Export traces when an OTLP endpoint is set. Tracing stops last, flushing the spans of shutdown.
+	if cfg.TracingEnabled {
+		var shutdownTracing func(context.Context) error
+		err := app.lifecycle.Start(ctx, lifecycle.Component{
+			Name: "tracing",
+			Start: func(ctx context.Context) error {
+				shutdown, err := tracing.Setup(ctx, cfg.Environment)
+				shutdownTracing = shutdown
+				return err
+			},
+			Stop: func(ctx context.Context) error {
+				return shutdownTracing(ctx)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Connect to database; it is closed last, once nothing else is using it
+	dbOptions := database.Options{
+		RowLevelSecurity: cfg.RowLevelSecurity,
+		MaxConns:         int32(cfg.DBMaxConns),
+		MinConns:         int32(cfg.DBMinConns),
+		MaxConnLifetime:  time.Duration(cfg.DBMaxConnLifetimeMinutes) * time.Minute,
+		MaxConnIdleTime:  time.Duration(cfg.DBMaxConnIdleMinutes) * time.Minute,
+		ConnectTimeout:   time.Duration(cfg.DBConnectTimeoutSeconds) * time.Second,
+	}
+	err := app.lifecycle.Start(ctx, lifecycle.Component{
+		Name: "database",
+		Start: func(context.Context) error {
+			db, err := database.New(cfg.DatabaseURL, dbOptions)
+			if err != nil {
+				return err
+			}
+			app.db = db
+			return nil
+		},
+		Stop: func(context.Context) error {
+			app.db.Close()
+			return nil
+		},
+	})
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		return err
+	}
+	db := app.db
+
+	// Reads of users' notes go to the read replica while it's reachable and caught up
+	if cfg.DatabaseReplicaURL != "" {
+		err := app.lifecycle.Start(ctx, lifecycle.Component{
+			Name: "database replica",
+			Start: func(context.Context) error {
+				replica, err := database.NewReplica(cfg.DatabaseReplicaURL, dbOptions, time.Duration(cfg.DBReplicaMaxLagSeconds)*time.Second)
+				if err != nil {
+					return err
+				}
+				app.replica = replica
+				return nil
+			},
+			Stop: func(context.Context) error {
+				app.replica.Close()
+				return nil
+			},
+		})
+		if err != nil {
+			return err
+		}
 	}
-	defer db.Close()
 
 	// Run migrations
-	if err := db.RunMigrations(context.Background()); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	if err := app.lifecycle.Start(ctx, lifecycle.Component{Name: "database migrations", Start: db.RunMigrations}); err != nil {
+		return err
 	}
-	log.Println("Database migrations completed")
+
+	// New passwords are hashed with Argon2id; bcrypt hashes are upgraded as users log in
+	hasher := passwordhash.New(passwordhash.Params{
+		Memory:      uint32(cfg.PasswordHashMemoryKB),
+		Iterations:  uint32(cfg.PasswordHashIterations),
+		Parallelism: uint8(cfg.PasswordHashParallelism),
+	})
+
+	// New passwords can be screened against the Have I Been Pwned breach list
+	var breached pwned.Checker
+	if cfg.PwnedPasswordsCheck {
+		if breached, err = pwned.New(pwned.Config{APIURL: cfg.PwnedPasswordsAPIURL, BloomFile: cfg.PwnedPasswordsBloomFile}); err != nil {
+			return fmt.Errorf("load pwned passwords filter: %w", err)
+		}
+	}
+
+	// JWTs are signed with JWT_SECRET, the private key in JWT_PRIVATE_KEY_FILE, or the keys in
+	// JWT_KEYS_FILE on their rotation schedule
+	jwtKeys := jwtkeys.Single(cfg.JWTSecret)
+	switch {
+	case cfg.JWTKeysFile != "":
+		if jwtKeys, err = jwtkeys.Load(cfg.JWTKeysFile); err != nil {
+			return fmt.Errorf("load JWT keys: %w", err)
+		}
+	case cfg.JWTPrivateKeyFile != "":
+		if jwtKeys, err = jwtkeys.WithPrivateKey(cfg.JWTSecret, cfg.JWTPrivateKeyFile); err != nil {
+			return fmt.Errorf("load JWT private key: %w", err)
+		}
+	}
+	signingKey, err := jwtKeys.Signing(time.Now())
+	if err != nil {
+		return fmt.Errorf("load JWT keys: %w", err)
+	}
+	slog.Info("Signing JWTs", "algorithm", signingKey.Algorithm, "key_id", signingKey.ID)
 
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db.Pool)
 	noteRepo := repository.NewNoteRepository(db.Pool)
+	if app.replica != nil {
+		noteRepo = noteRepo.WithReplica(app.replica.Pool, app.replica)
+	}
 
-	// Seed demo account
-	if err := seedDemoAccount(context.Background(), userRepo, noteRepo); err != nil {
-		log.Printf("[WARN] Failed to seed demo account: %v", err)
+	// Promote the configured administrators
+	if len(cfg.AdminUsernames) > 0 {
+		count, err := userRepo.SetAdmins(context.Background(), cfg.AdminUsernames)
+		if err != nil {
+			slog.Warn("Failed to promote administrators", "error", err)
+		} else if count > 0 {
+			logging.Security(ctx, "Promoted users to administrator from ADMIN_USERNAMES", "count", count)
+		}
 	}
 	tokenBlacklistRepo := repository.NewTokenBlacklistRepository(db.Pool)
+	refreshTokenRepo := repository.NewRefreshTokenRepository(db.Pool)
+	sessionRepo := repository.NewSessionRepository(db.Pool)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(db.Pool)
+	emailVerificationRepo := repository.NewEmailVerificationRepository(db.Pool)
+	magicLinkRepo := repository.NewMagicLinkRepository(db.Pool)
+	webAuthnRepo := repository.NewWebAuthnRepository(db.Pool)
+	identityRepo := repository.NewIdentityRepository(db.Pool)
+	settingsRepo := repository.NewSettingsRepository(db.Pool)
+	linkPreviewRepo := repository.NewLinkPreviewRepository(db.Pool)
+	shareRepo := repository.NewShareRepository(db.Pool)
+	mentionRepo := repository.NewMentionRepository(db.Pool)
+	notificationRepo := repository.NewNotificationRepository(db.Pool)
+	attachmentRepo := repository.NewAttachmentRepository(db.Pool)
+	orderingRepo := repository.NewOrderingRepository(db.Pool)
+	revisionRepo := repository.NewRevisionRepository(db.Pool)
+	noteOpRepo := repository.NewNoteOpRepository(db.Pool)
+	syncBatchRepo := repository.NewSyncBatchRepository(db.Pool)
+	idempotencyRepo := repository.NewIdempotencyRepository(db.Pool)
+	deviceRepo := repository.NewDeviceRepository(db.Pool)
+	activityRepo := repository.NewActivityRepository(db.Pool)
+	instanceRepo := repository.NewInstanceRepository(db.Pool)
+	coldStorageRepo := repository.NewColdStorageRepository(db.Pool, noteRepo)
+	archiveRepo := repository.NewArchiveRepository(db.Pool)
+	exportJobRepo := repository.NewExportJobRepository(db.Pool)
+	integrityRepo := repository.NewIntegrityRepository(db.Pool)
+	backupRepo := repository.NewBackupRepository(db.Pool)
+	positionRepo := repository.NewPositionRepository(db.Pool)
 
-	// Initialize services
-	authService := services.NewAuthService(userRepo, tokenBlacklistRepo, cfg.JWTSecret, cfg.JWTExpiry, cfg.RefreshExpiry)
-	syncService := services.NewSyncService(noteRepo)
+	// Attachment files are stored on disk, outside the database
+	attachmentStore, err := storage.NewFileStore(cfg.AttachmentsDir)
+	if err != nil {
+		return fmt.Errorf("create attachments directory: %w", err)
+	}
+	exportStore, err := storage.NewFileStore(cfg.ExportsDir)
+	if err != nil {
+		return fmt.Errorf("create exports directory: %w", err)
+	}
+
+	// Initialize mailer (logs messages when SMTP_HOST is not set)
+	mailer := mail.New(mail.Config{
+		Host:      cfg.SMTPHost,
+		Port:      cfg.SMTPPort,
+		Username:  cfg.SMTPUsername,
+		Password:  cfg.SMTPPassword,
+		From:      cfg.SMTPFrom,
+		LogBodies: cfg.IsDevelopment(),
+	})
 
 	// Initialize WebSocket hub
-	wsHub := websocket.NewHub()
-	go wsHub.Run()
-	log.Println("WebSocket hub started")
-
-	// Start token blacklist cleanup goroutine (runs every hour)
-	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
-		for range ticker.C {
-			count, err := authService.CleanupExpiredTokens(context.Background())
-			if err != nil {
-				log.Printf("[ERROR] Failed to cleanup expired tokens: %v", err)
-			} else if count > 0 {
-				log.Printf("[INFO] Cleaned up %d expired tokens from blacklist", count)
-			}
+	app.hub = websocket.NewHub(websocket.Config{
+		WriteWait:      time.Duration(cfg.WSWriteWait) * time.Second,
+		PongWait:       time.Duration(cfg.WSPongWait) * time.Second,
+		PingPeriod:     time.Duration(cfg.WSPingPeriod) * time.Second,
+		MaxMessageSize: cfg.WSMaxMessageSize,
+
+		Compression:          cfg.WSCompression,
+		CompressionThreshold: cfg.WSCompressionThreshold,
+
+		ReconnectDelay:  time.Duration(cfg.WSReconnectDelay) * time.Second,
+		ReconnectJitter: time.Duration(cfg.WSReconnectJitter) * time.Second,
+		AlternateURL:    cfg.WSAlternateURL,
+
+		MinProtocolVersion: cfg.WSMinProtocol,
+
+		ResumeBufferSize: cfg.WSResumeBufferSize,
+		ResumeWindow:     time.Duration(cfg.WSResumeWindowMinutes) * time.Minute,
+
+		MessagesPerSecond: cfg.WSRateMessagesPerSecond,
+		MessageBurst:      cfg.WSRateMessageBurst,
+		BytesPerSecond:    cfg.WSRateBytesPerSecond,
+		ByteBurst:         cfg.WSRateByteBurst,
+
+		MaxDroppedMessages: cfg.WSMaxDroppedMessages,
+
+		CoalesceWindow: time.Duration(cfg.WSCoalesceMs) * time.Millisecond,
+	})
+	// With more than one instance, broadcasts go through a broker so every instance's clients get them
+	switch cfg.WSBroker {
+	case "redis":
+		redisClient, err := redis.New(cfg.RedisURL)
+		if err != nil {
+			return err
+		}
+		if err := app.lifecycle.Start(ctx, redisComponent(redisClient)); err != nil {
+			return err
+		}
+		app.hub.SetBroker(websocket.NewRedisBroker(redisClient, cfg.WSBrokerChannel))
+	case "nats":
+		natsClient, err := nats.New(cfg.NATSURL)
+		if err != nil {
+			return err
+		}
+		if err := app.lifecycle.Start(ctx, natsComponent(natsClient)); err != nil {
+			return err
+		}
+		app.hub.SetBroker(websocket.NewNATSBroker(natsClient, cfg.WSBrokerChannel))
+	case "postgres":
+		app.hub.SetBroker(websocket.NewPostgresBroker(db.Pool, cfg.WSBrokerChannel))
+	}
+	if err := app.lifecycle.Start(ctx, app.hubComponent()); err != nil {
+		return err
+	}
+	wsHub := app.hub
+
+	// Notifications go out in-app, by email and by push, per each user's preferences
+	notificationDispatcher := services.NewNotificationDispatcher(notificationRepo, settingsRepo, userRepo, mailer, push.New(cfg.PushGatewayURL), wsHub, cfg.AppBaseURL)
+
+	// Initialize services
+	passwordPolicy := validation.PasswordRequirements{
+		MinLength:        cfg.PasswordMinLength,
+		MaxLength:        128,
+		RequireUppercase: cfg.PasswordRequireUppercase,
+		RequireLowercase: cfg.PasswordRequireLowercase,
+		RequireDigit:     cfg.PasswordRequireDigit,
+		RequireSpecial:   cfg.PasswordRequireSpecial,
+	}
+	slog.Info("Password policy", "requirements", validation.PasswordRequirementsMessage(passwordPolicy))
+	authService := services.NewAuthService(userRepo, tokenBlacklistRepo, refreshTokenRepo, sessionRepo, loginAttemptRepo, hasher, breached, passwordPolicy, jwtKeys, cfg.JWTExpiry, cfg.RefreshExpiry, cfg.RequireEmailVerification, cfg.BindTokensToDevice, notificationDispatcher)
+	syncService := services.NewSyncService(noteRepo, revisionRepo, noteOpRepo, syncBatchRepo, positionRepo, cfg.SyncPageSize)
+
+	// Storage quotas apply to notes saved through the REST and WebSocket APIs and through sync
+	quotas := services.Quotas{
+		MaxNotes:          int64(cfg.QuotaMaxNotes),
+		MaxNoteBytes:      cfg.QuotaMaxNoteBytes,
+		MaxTotalBytes:     cfg.QuotaMaxTotalBytes,
+		MaxChecklistItems: int64(cfg.QuotaMaxChecklistItems),
+	}
+	quotaService := services.NewQuotaService(noteRepo, quotas)
+	syncService.SetQuotas(quotas)
+	idempotencyService := services.NewIdempotencyService(idempotencyRepo)
+	instanceService := services.NewInstanceService(instanceRepo, authService)
+	emailVerificationService := services.NewEmailVerificationService(userRepo, emailVerificationRepo, mailer, cfg.AppBaseURL)
+	magicLinkService := services.NewMagicLinkService(userRepo, magicLinkRepo, authService, mailer, cfg.AppBaseURL)
+	webAuthnService := services.NewWebAuthnService(webAuthnRepo, userRepo, authService, &webauthn.RelyingParty{
+		ID:      cfg.WebAuthnRPID,
+		Name:    cfg.WebAuthnRPName,
+		Origins: cfg.WebAuthnOrigins,
+	})
+
+	// A new server prints a one-time token for creating the first administrator
+	if setupToken, err := instanceService.Bootstrap(context.Background()); err != nil {
+		slog.Warn("Failed to check setup status", "error", err)
+	} else if setupToken != "" {
+		slog.Warn("No administrator exists yet. Create one with POST /api/setup using the setup token", "setup_token", setupToken)
+	}
+
+	// Maintenance mode refuses changes on every instance; connected clients are told when it changes
+	maintenanceService := services.NewMaintenanceService(instanceRepo)
+	maintenanceService.OnChange(func(m *models.Maintenance) {
+		wsHub.SetMaintenance(services.MaintenanceToDTO(m))
+	})
+	if cfg.MaintenanceMode {
+		err = maintenanceService.Set(ctx, &models.Maintenance{Enabled: true, Message: cfg.MaintenanceMessage})
+	} else {
+		err = maintenanceService.Refresh(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("load maintenance mode: %w", err)
+	}
+	if maintenanceService.Current().Enabled {
+		slog.Warn("Maintenance mode is on; changes are refused until it's turned off with PUT /api/admin/maintenance")
+	}
+
+	// Sign in with Apple and Google, for whichever are configured
+	var oauthProviders []*oauth.Provider
+	if cfg.AppleServiceID != "" {
+		apple, err := oauth.NewApple(oauth.AppleConfig{
+			ServiceID:  cfg.AppleServiceID,
+			TeamID:     cfg.AppleTeamID,
+			KeyID:      cfg.AppleKeyID,
+			PrivateKey: cfg.ApplePrivateKey,
+			BundleIDs:  cfg.AppleBundleIDs,
+		})
+		if err != nil {
+			return fmt.Errorf("configure Sign in with Apple: %w", err)
+		}
+		oauthProviders = append(oauthProviders, apple)
+	}
+	if cfg.GoogleClientID != "" {
+		oauthProviders = append(oauthProviders, oauth.NewGoogle(oauth.GoogleConfig{
+			ClientID:     cfg.GoogleClientID,
+			ClientSecret: cfg.GoogleClientSecret,
+			AppClientIDs: cfg.GoogleAppClientIDs,
+		}))
+	}
+	oauthService := services.NewOAuthService(identityRepo, userRepo, webAuthnRepo, authService, instanceService, oauthProviders, cfg.OAuthCallbackBaseURL, cfg.OAuthReturnURLs)
+	shareService := services.NewShareService(shareRepo, noteRepo, userRepo, mailer, cfg.JWTSecret, cfg.AppBaseURL, cfg.InviteExpiryHours, notificationDispatcher)
+	// Editing indicators reach everyone a note is shared with
+	wsHub.SetNoteAudience(shareService.NoteAudience)
+	orderingService := services.NewOrderingService(orderingRepo, noteRepo)
+	revisionService := services.NewRevisionService(revisionRepo)
+	deviceService := services.NewDeviceService(deviceRepo)
+	activitySummaryService := services.NewActivitySummaryService(activityRepo, mailer, cfg.AppBaseURL)
+	telemetryService := services.NewTelemetryService(instanceRepo, cfg.TelemetryEnabled, cfg.TelemetryURL, apischema.APIVersion)
+	positionService := services.NewPositionService(positionRepo)
+	archiveService := services.NewArchiveService(archiveRepo, noteRepo, revisionRepo, cfg.ArchiveSigningKey)
+	attachmentService := services.NewAttachmentService(attachmentRepo, noteRepo, quotaService, attachmentStore, int64(cfg.MaxAttachmentMB)<<20)
+	exportService := services.NewExportService(exportJobRepo, noteRepo, exportStore)
+	integrityService := services.NewIntegrityService(integrityRepo, attachmentStore)
+	backupService := services.NewBackupService(backupRepo)
+
+	// Link previews are fetched in the background; nil disables them
+	var linkPreviewService *services.LinkPreviewService
+	if cfg.LinkPreviewsEnabled {
+		linkPreviewService = services.NewLinkPreviewService(linkPreviewRepo, wsHub, cfg.LinkPreviewAllowedHosts)
+	}
+
+	// Mentions in shared notes notify collaborators
+	mentionService := services.NewMentionService(mentionRepo, notificationRepo, shareRepo, noteRepo, userRepo, notificationDispatcher)
+
+	// Demo accounts: the shared one, seeded now, or locked if it was seeded before with the public
+	// password, and visitors' own
+	demoNotes, err := demo.LoadNotes(cfg.DemoNotesFile)
+	if err != nil {
+		return fmt.Errorf("load demo notes: %w", err)
+	}
+	demoConfig := demo.Config{
+		Notes:       demoNotes,
+		MaxAccounts: cfg.DemoMaxAccounts,
+		AccountTTL:  time.Duration(cfg.DemoAccountTTLMinutes) * time.Minute,
+	}
+	if cfg.DemoAccountEnabled {
+		demoConfig.Username = cfg.DemoUsername
+		demoConfig.Password = cfg.DemoPassword
+		demoConfig.ReadOnly = cfg.DemoReadOnly
+		demoConfig.ResetInterval = time.Duration(cfg.DemoResetIntervalMinutes) * time.Minute
+	}
+	demoService := demo.New(userRepo, noteRepo, hasher, authService, demoConfig)
+	if cfg.DemoAccountEnabled {
+		if err := demoService.Reset(context.Background()); err != nil {
+			slog.Warn("Failed to seed demo account", "error", err)
+		}
+	} else if err := demo.Lock(context.Background(), userRepo, hasher, cfg.DemoUsername, config.DefaultDemoPassword); err != nil {
+		slog.Warn("Failed to lock demo account", "error", err)
+	}
+
+	// Background jobs; each is stopped, cancelling a run in progress, before the hub and database
+	jobs := []lifecycle.Job{
+		{
+			// Follow maintenance mode being switched through other instances
+			Name:     "maintenance mode",
+			Interval: 5 * time.Second,
+			Run: func(ctx context.Context) {
+				if err := maintenanceService.Refresh(ctx); err != nil {
+					slog.ErrorContext(ctx, "Failed to refresh maintenance mode", "error", err)
+				}
+			},
+		},
+		{
+			// Remove expired tokens, lockouts, verification tokens, sign-in links, passkey challenges and
+			// sign-in states
+			Name:     "credential cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) {
+				count, err := authService.CleanupExpiredTokens(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to cleanup expired tokens", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Cleaned up expired tokens", "count", count)
+				}
+				count, err = authService.CleanupLoginAttempts(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to cleanup login attempts", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Cleaned up login attempt records", "count", count)
+				}
+				count, err = emailVerificationService.CleanupExpired(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to cleanup email verification tokens", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Cleaned up email verification tokens", "count", count)
+				}
+				count, err = magicLinkService.CleanupExpired(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to cleanup sign-in links", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Cleaned up sign-in links", "count", count)
+				}
+				count, err = webAuthnService.CleanupExpired(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to cleanup passkey challenges", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Cleaned up passkey challenges", "count", count)
+				}
+				count, err = oauthService.CleanupExpired(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to cleanup sign-in states", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Cleaned up sign-in states and codes", "count", count)
+				}
+			},
+		},
+		{
+			// Remove sync batches too old to revert
+			Name:     "sync batch cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) {
+				count, err := syncService.CleanupBatches(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to cleanup sync batches", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Cleaned up expired sync batches", "count", count)
+				}
+			},
+		},
+		{
+			// Remove expired batch exports and fail interrupted ones
+			Name:     "export cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) {
+				count, err := exportService.Cleanup(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to cleanup exports", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Cleaned up expired exports", "count", count)
+				}
+			},
+		},
+		{
+			// Remove expired idempotency keys
+			Name:     "idempotency key cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) {
+				count, err := idempotencyService.Cleanup(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to cleanup idempotency keys", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Cleaned up expired idempotency keys", "count", count)
+				}
+			},
+		},
+		{
+			// Send monthly activity summaries to opted-in users once the month is over
+			Name:     "activity summaries",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) {
+				count, err := activitySummaryService.SendDue(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to send activity summaries", "error", err)
+				}
+				if count > 0 {
+					slog.InfoContext(ctx, "Sent activity summaries", "count", count)
+				}
+			},
+		},
+	}
+
+	// Check referential integrity, repairing what it finds if INTEGRITY_AUTO_REPAIR is set
+	if cfg.IntegrityCheckIntervalHours > 0 {
+		jobs = append(jobs, lifecycle.Job{
+			Name:     "integrity check",
+			Interval: time.Duration(cfg.IntegrityCheckIntervalHours) * time.Hour,
+			Run: func(ctx context.Context) {
+				if _, err := integrityService.Run(ctx, cfg.IntegrityAutoRepair); err != nil {
+					slog.ErrorContext(ctx, "Integrity check failed", "error", err)
+				}
+			},
+		})
+	}
+
+	// Send anonymous usage reports if the operator opted in (at startup, then daily)
+	if cfg.TelemetryEnabled && !telemetryService.Enabled() {
+		slog.Warn("TELEMETRY_ENABLED is set but TELEMETRY_URL is empty; no reports will be sent")
+	}
+	if telemetryService.Enabled() {
+		jobs = append(jobs, lifecycle.Job{
+			Name:       "telemetry",
+			Interval:   services.TelemetryInterval,
+			RunAtStart: true,
+			Run: func(ctx context.Context) {
+				if err := telemetryService.Send(ctx); err != nil {
+					slog.WarnContext(ctx, "Failed to send telemetry report", "error", err)
+				}
+			},
+		})
+	}
+
+	// Put the shared demo account back to the samples, undoing what visitors did to it
+	if demoConfig.ResetInterval > 0 {
+		jobs = append(jobs, lifecycle.Job{
+			Name:     "demo reset",
+			Interval: demoConfig.ResetInterval,
+			Run: func(ctx context.Context) {
+				if err := demoService.Reset(ctx); err != nil {
+					slog.ErrorContext(ctx, "Failed to reset demo account", "error", err)
+				}
+			},
+		})
+	}
+
+	// Delete visitors' demo accounts once they've expired and their last access tokens have too
+	if cfg.DemoMaxAccounts > 0 {
+		jobs = append(jobs, lifecycle.Job{
+			Name:     "demo account cleanup",
+			Interval: 5 * time.Minute,
+			Run: func(ctx context.Context) {
+				count, err := demoService.CleanupExpired(ctx, time.Duration(cfg.JWTExpiry)*time.Minute)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to delete expired demo accounts", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Deleted expired demo accounts", "count", count)
+				}
+			},
+		})
+	}
+
+	// Move long-archived notes to cold storage
+	coldStorageService := services.NewColdStorageService(coldStorageRepo, noteRepo, cfg.ColdStorageAfterMonths)
+	if cfg.ColdStorageAfterMonths > 0 {
+		jobs = append(jobs, lifecycle.Job{
+			Name:     "cold storage",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) {
+				count, err := coldStorageService.MoveArchived(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to move archived notes to cold storage", "error", err)
+				} else if count > 0 {
+					slog.InfoContext(ctx, "Moved archived notes to cold storage", "count", count)
+				}
+			},
+		})
+	}
+
+	for _, job := range jobs {
+		if err := app.lifecycle.Start(ctx, job.Component()); err != nil {
+			return err
+		}
+	}
+
+	// Send sign-in links and unfurl URLs in the background. Both stop after the HTTP server, so no
+	// more work is queued once they do.
+	if err := app.lifecycle.Start(ctx, magicLinkComponent(magicLinkService)); err != nil {
+		return err
+	}
+	if linkPreviewService != nil {
+		if err := app.lifecycle.Start(ctx, linkPreviewComponent(linkPreviewService)); err != nil {
+			return err
 		}
-	}()
+	}
 
-	// Initialize rate limiters
-	generalRateLimiter := middleware.NewRateLimiter(cfg.RateLimitRequests, time.Minute, cfg.RateLimitBurst)
-	authRateLimiter := middleware.NewAuthRateLimiter()
+	// Initialize rate limiters, counting in Redis when instances should share their limits and
+	// lockouts. They get their own connection so a busy broker doesn't hold up requests.
+	var generalRateLimiter middleware.Limiter
+	var authRateLimiter *middleware.AuthRateLimiter
+	userRateLimits := &middleware.UserRateLimits{SyncRoutes: []string{"/api/notes/sync"}}
+	switch cfg.RateLimitStore {
+	case "redis":
+		redisClient, err := redis.New(cfg.RedisURL)
+		if err != nil {
+			return err
+		}
+		if err := app.lifecycle.Start(ctx, redisComponent(redisClient)); err != nil {
+			return err
+		}
+		generalRateLimiter = middleware.NewRedisRateLimiter(redisClient, "general", cfg.RateLimitRequests, time.Minute, cfg.RateLimitBurst)
+		authRateLimiter = middleware.NewRedisAuthRateLimiter(redisClient)
+		userRateLimits.Requests = middleware.NewRedisRateLimiter(redisClient, "user", cfg.RateLimitUserRequests, time.Minute, cfg.RateLimitUserBurst)
+		userRateLimits.Sync = middleware.NewRedisRateLimiter(redisClient, "sync", cfg.RateLimitSyncRequests, time.Minute, cfg.RateLimitSyncBurst)
+	default:
+		generalRateLimiter = middleware.NewRateLimiter(cfg.RateLimitRequests, time.Minute, cfg.RateLimitBurst)
+		authRateLimiter = middleware.NewAuthRateLimiter()
+		userRateLimits.Requests = middleware.NewRateLimiter(cfg.RateLimitUserRequests, time.Minute, cfg.RateLimitUserBurst)
+		userRateLimits.Sync = middleware.NewRateLimiter(cfg.RateLimitSyncRequests, time.Minute, cfg.RateLimitSyncBurst)
+	}
+
+	// CAPTCHA_PROVIDER puts a CAPTCHA in front of registration, and of logging in after repeated failures
+	var captchaVerifier captcha.Verifier
+	captchaConfig := models.CaptchaConfigResponse{LoginAfterFailures: cfg.CaptchaLoginAfterFailures}
+	if cfg.CaptchaProvider != "" {
+		if captchaVerifier, err = captcha.New(cfg.CaptchaProvider, cfg.CaptchaSecret); err != nil {
+			return fmt.Errorf("configure CAPTCHA: %w", err)
+		}
+		captchaConfig.Enabled = true
+		captchaConfig.Provider = cfg.CaptchaProvider
+		captchaConfig.SiteKey = cfg.CaptchaSiteKey
+	}
 
 	// Initialize CSRF middleware
 	csrfConfig := middleware.DefaultCSRFConfig(cfg.IsProduction())
 	csrfMiddleware := middleware.NewCSRFMiddleware(csrfConfig)
 
 	// Initialize audit logger
-	auditLogger := middleware.NewAuditLogger(true) // Enable audit logging
+	auditLogger := middleware.NewAuditLogger(slog.Default(), true) // Enable audit logging
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authService)
-	notesHandler := handlers.NewNotesHandler(noteRepo, syncService, wsHub)
-	syncHandler := handlers.NewSyncHandler(syncService, wsHub)
-	wsHandler := handlers.NewWebSocketHandler(wsHub, authService, cfg.AllowedOrigins)
+	authHandler := handlers.NewAuthHandler(authService, shareService, emailVerificationService, instanceService)
+	webAuthnHandler := handlers.NewWebAuthnHandler(webAuthnService)
+	magicLinkHandler := handlers.NewMagicLinkHandler(magicLinkService, auditLogger)
+	oauthHandler := handlers.NewOAuthHandler(oauthService)
+	captchaHandler := handlers.NewCaptchaHandler(captchaConfig)
+	demoHandler := handlers.NewDemoHandler(demoService)
+	notesHandler := handlers.NewNotesHandler(noteRepo, revisionRepo, syncService, linkPreviewService, mentionService, quotaService, wsHub)
+	wsHub.SetNoteWriter(handlers.NewWebSocketNoteWriter(notesHandler, auditLogger))
+	syncHandler := handlers.NewSyncHandler(syncService, linkPreviewService, mentionService, deviceService, wsHub)
+	shareHandler := handlers.NewShareHandler(shareService, syncService)
+	notificationHandler := handlers.NewNotificationHandler(mentionService, notificationDispatcher)
+	attachmentHandler := handlers.NewAttachmentHandler(attachmentService, noteRepo, syncService, wsHub)
+	schemaHandler := handlers.NewSchemaHandler()
+	orderingHandler := handlers.NewOrderingHandler(orderingService, wsHub)
+	revisionHandler := handlers.NewRevisionHandler(revisionService)
+	deviceHandler := handlers.NewDeviceHandler(deviceService)
+	presenceHandler := handlers.NewPresenceHandler(wsHub)
+	usageHandler := handlers.NewUsageHandler(quotaService)
+	activitySummaryHandler := handlers.NewActivitySummaryHandler(activitySummaryService)
+	telemetryHandler := handlers.NewTelemetryHandler(telemetryService)
+	coldStorageHandler := handlers.NewColdStorageHandler(coldStorageService, syncService, wsHub)
+	archiveHandler := handlers.NewArchiveHandler(archiveService)
+	exportHandler := handlers.NewExportHandler(exportService)
+	adminHandler := handlers.NewAdminHandler(integrityService, backupService, instanceService, maintenanceService, authService, wsHub)
+	setupHandler := handlers.NewSetupHandler(instanceService)
+	positionHandler := handlers.NewPositionHandler(positionService, wsHub)
+	wsHandler := handlers.NewWebSocketHandler(wsHub, authService, deviceService, cfg.WSAllowedOrigins)
 
 	// Setup router
-	router := gin.Default()
+	router := gin.New()
 
 	// Configure trusted proxies for accurate client IP detection
 	// In production behind a load balancer/reverse proxy, set TRUSTED_PROXIES env var
@@ -118,214 +754,427 @@ func main() {
 		}
 		if len(proxies) > 0 {
 			if err := router.SetTrustedProxies(proxies); err != nil {
-				log.Printf("[WARN] Failed to set trusted proxies: %v", err)
+				slog.Warn("Failed to set trusted proxies", "error", err)
 			} else {
-				log.Printf("[INFO] Configured trusted proxies: %v", proxies)
+				slog.Info("Configured trusted proxies", "proxies", proxies)
 			}
 		}
 	} else if cfg.IsProduction() {
 		// In production without explicit config, trust no proxies (use direct connection IP)
 		router.SetTrustedProxies(nil)
-		log.Println("[WARN] No TRUSTED_PROXIES configured - using direct connection IP only")
+		slog.Warn("No TRUSTED_PROXIES configured - using direct connection IP only")
 	}
 
-	// Set max request body size
+	// Multipart forms past this size are spooled to disk; BodyLimitMiddleware limits bodies themselves
 	router.MaxMultipartMemory = int64(cfg.MaxRequestBodyMB) << 20
 
 	// Global middleware
+	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.TracingMiddleware())
+	router.Use(middleware.RequestLogger())
+	router.Use(middleware.Recovery())
+	router.Use(middleware.ClientInfoMiddleware())
 	router.Use(middleware.SecurityHeaders())
 	router.Use(middleware.CORSMiddleware(cfg.AllowedOrigins))
-	router.Use(middleware.RateLimitMiddleware(generalRateLimiter))
+	userRateLimits.Identify = authService.AccessTokenSubject
+	router.Use(middleware.RateLimitMiddleware(generalRateLimiter, userRateLimits))
+	router.Use(middleware.BodyLimitMiddleware(int64(cfg.MaxRequestBodyMB)<<20, map[string]int64{
+		"/api/exports/verify":        int64(cfg.MaxArchiveBodyMB) << 20,
+		"/api/notes/:id/attachments": 0, // Streamed to storage, up to the attachment size limit
+		"/api/admin/restore":         0, // Streamed into the database
+	}))
 	router.Use(csrfMiddleware.Handler())
+	router.Use(middleware.ReadRoutes("/api/notes/sync", "/api/exports/verify")) // POST routes that may only read
+	router.Use(middleware.MaintenanceMiddleware(maintenanceService, []string{
+		"/api/admin/", // So administrators can turn it off
+		"/api/auth/login",
+		"/api/auth/refresh",
+		"/api/auth/logout",
+		"/api/auth/webauthn/login/",
+		"/api/auth/oauth/",
+		"/api/auth/magic-link",
+	}))
+	router.Use(middleware.TimeoutMiddleware(time.Duration(cfg.RequestTimeout)*time.Second, map[string]time.Duration{
+		"/api/auth/":                 time.Duration(cfg.AuthRequestTimeout) * time.Second,
+		"/api/setup":                 time.Duration(cfg.AuthRequestTimeout) * time.Second,
+		"/api/notes/sync":            time.Duration(cfg.SyncRequestTimeout) * time.Second,
+		"/api/notes/:id/attachments": 0, // Uploads take as long as the client's connection needs
+		"/api/attachments/:id":       0, // So do downloads
+		"/api/exports":               0, // Archives are streamed as they're built
+		"/api/export/:id/download":   0,
+		"/api/admin/backup":          0,
+		"/api/admin/restore":         0,
+		"/api/ws":                    0, // Connections last as long as the client stays
+	}, handlers.StreamsNotes)) // Streamed note listings take as long as the account's notes do
 
 	// Health check (no rate limit)
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok", "version": "1.0.2"})
-	})
+	healthHandler := handlers.NewHealthHandler(db)
+	router.GET("/health", healthHandler.Live)
+	router.GET("/health/ready", healthHandler.Ready) // Pings the database; 503 when it doesn't answer
+
+	// Prometheus metrics, for scrapers holding the metrics token
+	if cfg.MetricsToken != "" {
+		router.GET("/metrics", handlers.NewMetricsHandler(wsHub, db, cfg.MetricsToken).Serve)
+	}
+
+	// Public keys for verifying tokens, when they're signed with RSA or Ed25519
+	router.GET("/.well-known/jwks.json", authHandler.JWKS)
 
 	// API routes
 	api := router.Group("/api")
+	api.Use(middleware.APIVersionMiddleware())
 	{
 		// Auth routes with stricter rate limiting
 		auth := api.Group("/auth")
 		auth.Use(middleware.AuthRateLimitMiddleware(authRateLimiter))
 		{
-			auth.POST("/register", authHandler.Register)
-			auth.POST("/login", authHandler.Login)
+			auth.GET("/captcha", captchaHandler.Config)
+			auth.POST("/register", middleware.RequireCaptcha(captchaVerifier, nil), authHandler.Register)
+			auth.POST("/login", middleware.RequireCaptcha(captchaVerifier, middleware.CaptchaAfterFailures(authRateLimiter, cfg.CaptchaLoginAfterFailures)), authHandler.Login)
 			auth.POST("/refresh", authHandler.Refresh) // Uses refresh token, not access token
 			auth.POST("/logout", authHandler.Logout)   // Revokes current tokens
 			auth.POST("/logout-all", middleware.AuthMiddleware(authService), authHandler.LogoutAll) // Requires auth, revokes all user tokens
 			auth.POST("/change-password", middleware.AuthMiddleware(authService), authHandler.ChangePassword) // Requires auth
 			auth.GET("/me", middleware.AuthMiddleware(authService), authHandler.Me)
+			auth.GET("/token-info", middleware.AuthMiddleware(authService, models.TokenScopeNotesRead), authHandler.TokenInfo) // When the access token expires, for refreshing ahead of time
+			auth.POST("/scoped-tokens", middleware.AuthMiddleware(authService), authHandler.CreateScopedToken) // Read-only tokens for widgets and displays
+			auth.POST("/verify-email", authHandler.VerifyEmail)
+			auth.POST("/resend-verification", authHandler.ResendVerification)
+			auth.POST("/demo", middleware.RequireCaptcha(captchaVerifier, nil), demoHandler.Create) // A demo account of the visitor's own
+			auth.POST("/magic-link", magicLinkHandler.Send)
+			auth.POST("/magic-link/exchange", magicLinkHandler.Exchange)
+			auth.PUT("/email", middleware.AuthMiddleware(authService), authHandler.ChangeEmail) // Requires auth; the new address must be verified
+			auth.GET("/sessions", middleware.AuthMiddleware(authService), authHandler.ListSessions)
+			auth.DELETE("/sessions/:id", middleware.AuthMiddleware(authService), authHandler.RevokeSession) // Signs one device out
+
+			// Passkeys: registering one requires auth, signing in with one doesn't
+			auth.POST("/webauthn/register/begin", middleware.AuthMiddleware(authService), webAuthnHandler.BeginRegistration)
+			auth.POST("/webauthn/register/finish", middleware.AuthMiddleware(authService), webAuthnHandler.FinishRegistration)
+			auth.POST("/webauthn/login/begin", webAuthnHandler.BeginLogin)
+			auth.POST("/webauthn/login/finish", webAuthnHandler.FinishLogin)
+			auth.GET("/webauthn/credentials", middleware.AuthMiddleware(authService), webAuthnHandler.ListCredentials)
+			auth.DELETE("/webauthn/credentials/:id", middleware.AuthMiddleware(authService), webAuthnHandler.DeleteCredential)
+
+			// Sign in with Apple and Google; linking a provider to an existing account requires auth
+			auth.GET("/oauth/providers", oauthHandler.Providers)
+			auth.GET("/oauth/:provider/start", oauthHandler.Start)
+			auth.GET("/oauth/:provider/callback", oauthHandler.Callback)
+			auth.POST("/oauth/:provider/callback", oauthHandler.Callback)
+			auth.POST("/oauth/:provider/token", oauthHandler.Token)
+			auth.POST("/oauth/:provider/link", middleware.AuthMiddleware(authService), oauthHandler.Link)
+			auth.POST("/oauth/exchange", oauthHandler.Exchange)
+			auth.GET("/identities", middleware.AuthMiddleware(authService), oauthHandler.ListIdentities)
+			auth.DELETE("/identities/:id", middleware.AuthMiddleware(authService), oauthHandler.DeleteIdentity)
+		}
+
+		// First-run setup: the setup token from the server log creates the first administrator
+		setup := api.Group("/setup")
+		setup.Use(middleware.AuthRateLimitMiddleware(authRateLimiter))
+		{
+			setup.GET("", setupHandler.Status)
+			setup.POST("", setupHandler.Setup)
 		}
 
-		// Notes routes (protected with audit logging)
+		// Notes routes (protected with audit logging); read-only tokens can use the GET routes
 		notes := api.Group("/notes")
-		notes.Use(middleware.AuthMiddleware(authService))
+		notes.Use(middleware.AuthMiddleware(authService, models.TokenScopeNotesRead))
 		notes.Use(middleware.AuditMiddleware(auditLogger, "notes"))
+		idempotent := middleware.IdempotencyMiddleware(idempotencyService)
+		compressed := middleware.CompressionMiddleware()
 		{
-			notes.GET("", notesHandler.List)
-			notes.POST("", notesHandler.Create)
+			notes.GET("", compressed, notesHandler.List)
+			notes.POST("", compressed, idempotent, notesHandler.Create)
+			notes.GET("/search", notesHandler.Search)
 			notes.GET("/:id", notesHandler.Get)
 			notes.PUT("/:id", notesHandler.Update)
 			notes.DELETE("/:id", notesHandler.Delete)
-			notes.POST("/sync", syncHandler.Sync)
+			notes.GET("/:id/pdf", notesHandler.ExportPDF)
+			notes.GET("/:id/revisions", revisionHandler.List)
+			notes.GET("/:id/revisions/:rev/diff", revisionHandler.Diff)
+			notes.GET("/:id/position", positionHandler.Get)
+			notes.PUT("/:id/position", positionHandler.Set)
+			notes.POST("/sync", compressed, idempotent, syncHandler.Sync)
+			notes.GET("/order", orderingHandler.Get)
+			notes.PUT("/order", orderingHandler.Set)
+			notes.GET("/:id/invites", shareHandler.ListInvites)
+			notes.POST("/:id/invites", shareHandler.CreateInvite)
+			notes.POST("/:id/invites/:inviteId/resend", shareHandler.ResendInvite)
+			notes.DELETE("/:id/invites/:inviteId", shareHandler.RevokeInvite)
+			notes.GET("/:id/attachments", attachmentHandler.List)
+			notes.POST("/:id/attachments", attachmentHandler.Upload)
 		}
 
-		// WebSocket route (authentication handled in handler)
-		api.GET("/ws", wsHandler.HandleWebSocket)
-	}
+		// Attachment downloads are also available to collaborators on shared notes
+		attachments := api.Group("/attachments")
+		attachments.Use(middleware.AuthMiddleware(authService, models.TokenScopeNotesRead))
+		attachments.Use(middleware.AuditMiddleware(auditLogger, "attachments"))
+		{
+			attachments.GET("/:id", attachmentHandler.Download)
+			attachments.DELETE("/:id", attachmentHandler.Delete)
+		}
 
-	// Create server
-	srv := &http.Server{
-		Addr:    ":" + cfg.Port,
-		Handler: router,
-	}
+		// Notes shared with the current user
+		shared := api.Group("/shared")
+		shared.Use(middleware.AuthMiddleware(authService, models.TokenScopeNotesRead))
+		shared.Use(middleware.AuditMiddleware(auditLogger, "shared_notes"))
+		{
+			shared.GET("/notes", shareHandler.ListShared)
+			shared.GET("/notes/:id", shareHandler.GetShared)
+		}
+
+		// Applied syncs, for undoing a bad client's changes
+		syncBatches := api.Group("/sync")
+		syncBatches.Use(middleware.AuthMiddleware(authService))
+		syncBatches.Use(middleware.AuditMiddleware(auditLogger, "sync"))
+		{
+			syncBatches.GET("/batches", syncHandler.ListBatches)
+			syncBatches.POST("/:batchId/revert", syncHandler.Revert)
+		}
 
-	// Start server in goroutine
-	go func() {
-		log.Printf("Server starting on port %s", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+		// The user's devices and how far each has synced
+		devices := api.Group("/devices")
+		devices.Use(middleware.AuthMiddleware(authService))
+		devices.Use(middleware.AuditMiddleware(auditLogger, "devices"))
+		{
+			devices.GET("", deviceHandler.List)
+			devices.POST("", deviceHandler.Register)
+			devices.DELETE("/:id", deviceHandler.Revoke)
 		}
-	}()
 
-	// Wait for interrupt signal
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	log.Println("Shutting down server...")
+		// Which of the user's devices are connected for real-time sync
+		api.GET("/presence", middleware.AuthMiddleware(authService), presenceHandler.Get)
 
-	// Graceful shutdown with 5 second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+		// How much the user stores in notes, against the storage quotas
+		api.GET("/usage", middleware.AuthMiddleware(authService), usageHandler.Get)
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
-	}
+		// Opt-in monthly activity summary email
+		activitySummary := api.Group("/activity-summary")
+		activitySummary.Use(middleware.AuthMiddleware(authService))
+		activitySummary.Use(middleware.AuditMiddleware(auditLogger, "activity_summary"))
+		{
+			activitySummary.GET("", activitySummaryHandler.Get)
+			activitySummary.PUT("", activitySummaryHandler.Subscribe)
+			activitySummary.DELETE("", activitySummaryHandler.Unsubscribe)
+		}
 
-	log.Println("Server exited")
-}
+		// Preview of the anonymous telemetry report, sent only if TELEMETRY_ENABLED
+		api.GET("/telemetry", middleware.AuthMiddleware(authService), telemetryHandler.Preview)
 
-// splitAndTrim splits a string by separator and trims whitespace from each part
-func splitAndTrim(s, sep string) []string {
-	parts := []string{}
-	for _, part := range strings.Split(s, sep) {
-		trimmed := strings.TrimSpace(part)
-		if trimmed != "" {
-			parts = append(parts, trimmed)
+		// Notes moved to cold storage after being archived for a long time
+		archive := api.Group("/archive")
+		archive.Use(middleware.AuthMiddleware(authService))
+		archive.Use(middleware.AuditMiddleware(auditLogger, "archive"))
+		{
+			archive.GET("/notes", coldStorageHandler.List)
+			archive.GET("/notes/:id", coldStorageHandler.Get)
+			archive.POST("/notes/:id/restore", coldStorageHandler.Restore)
+		}
+
+		// Signed, hash-chained archives of a user's notes and history
+		api.GET("/exports/public-key", archiveHandler.PublicKey)
+		exports := api.Group("/exports")
+		exports.Use(middleware.AuthMiddleware(authService))
+		exports.Use(middleware.AuditMiddleware(auditLogger, "export"))
+		{
+			exports.GET("", archiveHandler.List)
+			exports.POST("", archiveHandler.Export)
+			exports.POST("/verify", archiveHandler.Verify)
+		}
+
+		// Batch exports of the notes matching a filter, as one PDF, Markdown document or zip
+		export := api.Group("/export")
+		export.Use(middleware.AuthMiddleware(authService))
+		export.Use(middleware.AuditMiddleware(auditLogger, "export"))
+		{
+			export.GET("", exportHandler.List)
+			export.POST("", exportHandler.Create)
+			export.GET("/:id", exportHandler.Get)
+			export.GET("/:id/download", exportHandler.Download)
 		}
-	}
-	return parts
-}
 
-// seedDemoAccount creates a demo user with sample notes if it doesn't exist
-func seedDemoAccount(ctx context.Context, userRepo *repository.UserRepository, noteRepo *repository.NoteRepository) error {
-	demoPassword := "DemoPassword123!"
+		api.POST("/invites/accept", middleware.AuthMiddleware(authService), shareHandler.AcceptInvite)
 
-	// Check if demo user already exists
-	existingUser, err := userRepo.GetByUsername(ctx, "demo")
-	if err == nil {
-		// Demo user exists - ensure password is correct and reset notes
-		hashedPassword, hashErr := bcrypt.GenerateFromPassword([]byte(demoPassword), bcrypt.DefaultCost)
-		if hashErr != nil {
-			return hashErr
+		// Admin API (administrators only)
+		admin := api.Group("/admin")
+		admin.Use(middleware.AuthMiddleware(authService))
+		admin.Use(middleware.AdminMiddleware(authService))
+		{
+			admin.GET("/integrity", adminHandler.IntegrityReports)
+			admin.POST("/integrity", adminHandler.RunIntegrityCheck)
+			admin.POST("/backup", adminHandler.Backup)
+			admin.POST("/restore", adminHandler.Restore)
+			admin.GET("/websocket", adminHandler.WebSocketStats)
+			admin.GET("/settings", adminHandler.Settings)
+			admin.PUT("/settings", adminHandler.UpdateSettings)
+			admin.GET("/maintenance", adminHandler.Maintenance)
+			admin.PUT("/maintenance", adminHandler.SetMaintenance)
+			admin.GET("/lockouts", adminHandler.Lockouts)
+			admin.DELETE("/lockouts/:username", adminHandler.Unlock)
 		}
-		if updateErr := userRepo.UpdatePassword(ctx, existingUser.ID, string(hashedPassword)); updateErr != nil {
-			log.Printf("[WARN] Failed to update demo password: %v", updateErr)
-		} else {
-			log.Println("Demo account password updated")
+
+		// In-app notifications
+		notifications := api.Group("/notifications")
+		notifications.Use(middleware.AuthMiddleware(authService))
+		{
+			notifications.GET("", notificationHandler.List)
+			notifications.POST("/read-all", notificationHandler.MarkAllRead)
+			notifications.POST("/:id/read", notificationHandler.MarkRead)
+		}
+
+		// Per-user settings
+		settings := api.Group("/settings")
+		settings.Use(middleware.AuthMiddleware(authService))
+		{
+			settings.GET("/notifications", notificationHandler.GetPreferences)
+			settings.PUT("/notifications", notificationHandler.UpdatePreferences)
 		}
 
-		// Reset demo notes
-		if deleteErr := noteRepo.HardDeleteAllByUserID(ctx, existingUser.ID); deleteErr != nil {
-			log.Printf("[WARN] Failed to delete demo notes: %v", deleteErr)
+		// API schema for client generation (public)
+		schema := api.Group("/schema")
+		{
+			schema.GET("/openapi.json", schemaHandler.OpenAPI)
+			schema.GET("/version", schemaHandler.Version)
 		}
-		createDemoNotes(ctx, noteRepo, existingUser.ID)
-		return nil
+
+		// WebSocket route (authentication handled in handler)
+		api.GET("/ws", wsHandler.HandleWebSocket)
 	}
-	if !errors.Is(err, repository.ErrUserNotFound) {
-		return err
+
+	// Generated clients are only as complete as the OpenAPI document
+	for _, route := range router.Routes() {
+		if !apischema.Documented(route.Method, route.Path) {
+			slog.Warn("Route is missing from the OpenAPI document (internal/apischema/operations.go)", "method", route.Method, "path", route.Path)
+		}
 	}
 
-	// Create demo user
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(demoPassword), bcrypt.DefaultCost)
-	if err != nil {
-		return err
+	// Create server
+	// Timeouts and header limit guard against slowloris-style connection exhaustion
+	app.server = &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           router,
+		ReadHeaderTimeout: time.Duration(cfg.HTTPReadHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(cfg.HTTPReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(cfg.HTTPWriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(cfg.HTTPIdleTimeout) * time.Second,
+		MaxHeaderBytes:    cfg.HTTPMaxHeaderBytes,
 	}
+	return app.lifecycle.Start(ctx, app.serverComponent())
+}
 
-	now := time.Now()
-	demoUser := &models.User{
-		ID:           uuid.New(),
-		Username:     "demo",
-		PasswordHash: string(hashedPassword),
-		CreatedAt:    now,
-		UpdatedAt:    now,
+// redisComponent checks Redis can be reached at startup and closes the connection at shutdown
+func redisComponent(client *redis.Client) lifecycle.Component {
+	return lifecycle.Component{
+		Name:  "Redis",
+		Start: client.Ping,
+		Stop: func(context.Context) error {
+			return client.Close()
+		},
 	}
+}
 
-	if err := userRepo.Create(ctx, demoUser); err != nil {
-		return err
+// natsComponent connects to NATS at startup and closes the connection at shutdown
+func natsComponent(client *nats.Client) lifecycle.Component {
+	return lifecycle.Component{
+		Name:  "NATS",
+		Start: client.Connect,
+		Stop: func(context.Context) error {
+			return client.Close()
+		},
 	}
-	log.Println("Created demo user account")
+}
 
-	createDemoNotes(ctx, noteRepo, demoUser.ID)
-	return nil
+// magicLinkComponent runs the workers that email sign-in links. Stopping it gives the links queued
+// as long as sending one may take.
+func magicLinkComponent(service *services.MagicLinkService) lifecycle.Component {
+	return lifecycle.Component{
+		Name: "magic links",
+		Start: func(context.Context) error {
+			service.Start()
+			return nil
+		},
+		Stop:        service.Stop,
+		StopTimeout: 30 * time.Second,
+	}
 }
 
-// createDemoNotes creates sample notes for the demo account
-func createDemoNotes(ctx context.Context, noteRepo *repository.NoteRepository, userID uuid.UUID) {
-	now := time.Now()
-
-	// Note 1: Welcome note (pinned)
-	welcomeNote := &models.Note{
-		ID:        uuid.New(),
-		UserID:    userID,
-		Title:     "Welcome to Notes!",
-		Content:   "This is your personal notes app. Create text notes or checklists, and they'll sync across all your devices in real-time.\n\nFeel free to explore - create, edit, and delete notes to see how it works!",
-		NoteType:  models.NoteTypeNote,
-		IsPinned:  true,
-		SortOrder: 0,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
-	if err := noteRepo.Create(ctx, welcomeNote); err != nil {
-		log.Printf("[WARN] Failed to create welcome note: %v", err)
-	}
-
-	// Note 2: Features note
-	featuresNote := &models.Note{
-		ID:        uuid.New(),
-		UserID:    userID,
-		Title:     "Features",
-		Content:   "• Real-time sync across devices\n• Text notes and checklists\n• Pin important notes to the top\n• Archive notes you're done with\n• Secure authentication",
-		NoteType:  models.NoteTypeNote,
-		SortOrder: 1,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
-	if err := noteRepo.Create(ctx, featuresNote); err != nil {
-		log.Printf("[WARN] Failed to create features note: %v", err)
-	}
-
-	// Note 3: Getting Started checklist
-	checklistNote := &models.Note{
-		ID:        uuid.New(),
-		UserID:    userID,
-		Title:     "Getting Started",
-		NoteType:  models.NoteTypeChecklist,
-		SortOrder: 2,
-		CreatedAt: now,
-		UpdatedAt: now,
-		ChecklistItems: []models.ChecklistItem{
-			{ID: uuid.New(), Text: "Try creating a new note", IsCompleted: false, SortOrder: 0, CreatedAt: now, UpdatedAt: now},
-			{ID: uuid.New(), Text: "Pin an important note", IsCompleted: false, SortOrder: 1, CreatedAt: now, UpdatedAt: now},
-			{ID: uuid.New(), Text: "Archive a note you're done with", IsCompleted: false, SortOrder: 2, CreatedAt: now, UpdatedAt: now},
-			{ID: uuid.New(), Text: "Check out the settings", IsCompleted: false, SortOrder: 3, CreatedAt: now, UpdatedAt: now},
+// linkPreviewComponent runs the workers that unfurl URLs in notes
+func linkPreviewComponent(service *services.LinkPreviewService) lifecycle.Component {
+	return lifecycle.Component{
+		Name: "link previews",
+		Start: func(context.Context) error {
+			service.Start()
+			return nil
 		},
+		Stop: service.Stop,
 	}
-	if err := noteRepo.Create(ctx, checklistNote); err != nil {
-		log.Printf("[WARN] Failed to create checklist note: %v", err)
+}
+
+// hubComponent runs the WebSocket hub. Stopping it tells clients when to come back, so they don't
+// all reconnect at once, then ends its event loop.
+func (app *application) hubComponent() lifecycle.Component {
+	var (
+		cancel  context.CancelFunc
+		stopped = make(chan struct{})
+	)
+	return lifecycle.Component{
+		Name: "WebSocket hub",
+		Start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			go func() {
+				defer close(stopped)
+				app.hub.Run(ctx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			var maintenanceUntil time.Time
+			if app.cfg.WSRestartWindowSec > 0 {
+				maintenanceUntil = time.Now().Add(time.Duration(app.cfg.WSRestartWindowSec) * time.Second)
+			}
+			app.hub.Drain("server restarting", maintenanceUntil)
+			cancel()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
 	}
+}
 
-	log.Println("Created sample notes for demo account")
+// serverComponent serves HTTP. The port is bound before Start returns, so a port already in use
+// fails startup; should the server fail later, the application shuts down. Stopping it finishes the
+// requests in flight.
+func (app *application) serverComponent() lifecycle.Component {
+	return lifecycle.Component{
+		Name: "HTTP server",
+		Start: func(context.Context) error {
+			ln, err := net.Listen("tcp", app.server.Addr)
+			if err != nil {
+				return err
+			}
+			slog.Info("Server starting", "port", app.cfg.Port)
+			go func() {
+				if err := app.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+					app.lifecycle.Fail(fmt.Errorf("HTTP server failed: %w", err))
+				}
+			}()
+			return nil
+		},
+		Stop: app.server.Shutdown,
+	}
+}
+
+// splitAndTrim splits a string by separator and trims whitespace from each part
+func splitAndTrim(s, sep string) []string {
+	parts := []string{}
+	for _, part := range strings.Split(s, sep) {
+		trimmed := strings.TrimSpace(part)
+		if trimmed != "" {
+			parts = append(parts, trimmed)
+		}
+	}
+	return parts
 }
@@ -0,0 +1,425 @@
+package apischema
+
+import (
+	"net/http"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/audio"
+	"github.com/hamishgilbert/notes-app/backend/internal/captcha"
+	"github.com/hamishgilbert/notes-app/backend/internal/crdt"
+	"github.com/hamishgilbert/notes-app/backend/internal/diff"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/oauth"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// errorResponse is the body of every non-2xx JSON response
+var errorResponse response.ErrorResponse
+
+// fieldEnums lists the allowed values of string fields that clients should model as enums,
+// keyed by "<Type>.<json name>"
+var fieldEnums = map[string][]string{
+	"NoteDTO.noteType":               {string(models.NoteTypeNote), string(models.NoteTypeChecklist), string(models.NoteTypeCode)},
+	"ConflictDTO.keptVersion":        {"client", "server"},
+	"SyncPreflightDTO.outcome":       {"apply", "merge", "conflict", "resend", "reject"},
+	"SyncPreflightDTO.keptVersion":   {"client", "server"},
+	"SyncFailureDTO.field":           {"changes", "deletedIDs", "contentOps", "positions"},
+	"ArchiveEntry.kind":              {models.ArchiveEntryRevision, models.ArchiveEntryNote},
+	"ArchivePublicKeyDTO.algorithm":  {models.ArchiveSignatureAlgorithm},
+	"TextOpDTO.type":                 {string(crdt.OpInsert), string(crdt.OpDelete)},
+	"RegisterDeviceRequest.platform": {string(models.DevicePlatformIOS), string(models.DevicePlatformMacOS), string(models.DevicePlatformAndroid), string(models.DevicePlatformWeb), string(models.DevicePlatformOther)},
+	"DeviceDTO.platform":             {string(models.DevicePlatformIOS), string(models.DevicePlatformMacOS), string(models.DevicePlatformAndroid), string(models.DevicePlatformWeb), string(models.DevicePlatformOther)},
+	"DiffLineDTO.op":                 {string(diff.OpEqual), string(diff.OpInsert), string(diff.OpDelete)},
+	"DiffSpanDTO.op":                 {string(diff.OpEqual), string(diff.OpInsert), string(diff.OpDelete)},
+	"CreateExportRequest.format":     {string(models.ExportFormatPDF), string(models.ExportFormatMarkdown), string(models.ExportFormatZip)},
+	"CreateExportRequest.paper":      {"a4", "letter"},
+	"ExportJobDTO.format":            {string(models.ExportFormatPDF), string(models.ExportFormatMarkdown), string(models.ExportFormatZip)},
+	"ExportJobDTO.status":            {string(models.ExportJobPending), string(models.ExportJobRunning), string(models.ExportJobCompleted), string(models.ExportJobFailed)},
+	"NotificationDTO.type":           {string(models.NotificationTypeMention), string(models.NotificationTypeShareAccepted), string(models.NotificationTypePasswordChanged), string(models.NotificationTypeTokenReused), string(models.NotificationTypeReminder)},
+	"IntegrityFinding.check":         {models.IntegrityOrphanedChecklistItem, models.IntegrityNoteWithoutUser, models.IntegrityAttachmentWithoutBlob},
+	"UserIdentityDTO.provider":       {oauth.ProviderApple, oauth.ProviderGoogle},
+	"AttachmentDTO.format":           {string(audio.FormatM4A), string(audio.FormatCAF), string(audio.FormatWAV)},
+	"HealthResponse.status":          {"ok"},
+	"AuthResponse.token_type":        {"Bearer"},
+	"CaptchaConfigResponse.provider": {captcha.HCaptcha, captcha.Turnstile},
+	"ScopedTokenResponse.token_type": {"Bearer"},
+	"ScopedTokenResponse.scope":      {models.TokenScopeNotesRead},
+	"CreateScopedTokenRequest.scope": {models.TokenScopeNotesRead},
+	"SessionDTO.scope":               {models.TokenScopeNotesRead},
+	"TokenInfoResponse.scope":        {models.TokenScopeNotesRead},
+
+	"WebAuthnCredentialParamDTO.type":                    {"public-key"},
+	"WebAuthnCredentialDescriptorDTO.type":               {"public-key"},
+	"WebAuthnAuthenticatorSelectionDTO.residentKey":      {"discouraged", "preferred", "required"},
+	"WebAuthnAuthenticatorSelectionDTO.userVerification": {"discouraged", "preferred", "required"},
+	"WebAuthnRequestOptionsDTO.userVerification":         {"discouraged", "preferred", "required"},
+	"WebAuthnCreationOptionsDTO.attestation":             {"none"},
+}
+
+// pathParamEnums lists the allowed values of path parameters that aren't UUIDs
+var pathParamEnums = map[string][]string{
+	"provider": {oauth.ProviderApple, oauth.ProviderGoogle},
+}
+
+// pathParamStrings lists the path parameters that are free-form strings rather than UUIDs
+var pathParamStrings = map[string]bool{
+	"username": true,
+}
+
+// operations lists every endpoint registered in cmd/server. Keep it in step with the router:
+// it is the source of the OpenAPI document clients are generated from.
+var operations = []Operation{
+	// Health
+	{Method: http.MethodGet, Path: "/health", ID: "getHealth", Tag: "health", Summary: "Health check", Public: true,
+		Response: models.HealthResponse{}},
+	{Method: http.MethodGet, Path: "/health/ready", ID: "getReadiness", Tag: "health", Summary: "Readiness check", Public: true,
+		Description: "Pings the database and reports its latency and connection pool. Returns 503 with status unavailable when the database doesn't answer.",
+		Response:    models.ReadinessResponse{}},
+	{Method: http.MethodGet, Path: "/metrics", ID: "getMetrics", Tag: "health", Summary: "Prometheus metrics", Public: true,
+		Description: "Only served when METRICS_TOKEN is set; authenticate with Authorization: Bearer <METRICS_TOKEN>. WebSocket connections, traffic, dropped messages, the database connection pool and goroutines in the Prometheus text format.",
+		Response:    Binary{ContentType: "text/plain"}},
+	{Method: http.MethodGet, Path: "/.well-known/jwks.json", ID: "getJWKS", Tag: "auth", Summary: "Public keys access tokens are signed with", Public: true,
+		Description: "A JSON Web Key Set for services that verify tokens. Empty when tokens are signed with a shared secret. Keys appear here before they start signing; match a token to its key by kid.",
+		Response:    models.JWKSResponse{}},
+
+	// Schema
+	{Method: http.MethodGet, Path: "/api/schema/openapi.json", ID: "getOpenAPIDocument", Tag: "schema", Summary: "OpenAPI document for generating clients", Public: true,
+		Response: Binary{ContentType: "application/json"}},
+	{Method: http.MethodGet, Path: "/api/schema/version", ID: "getSchemaVersion", Tag: "schema", Summary: "API version and schema hash", Public: true,
+		Description: "Generated clients embed the hash they were built from; a different hash means the client may be out of date.",
+		Response:    models.SchemaVersionResponse{}},
+
+	// Auth
+	{Method: http.MethodGet, Path: "/api/auth/captcha", ID: "getCaptchaConfig", Tag: "auth", Summary: "Which CAPTCHA registering and logging in need, if any", Public: true,
+		Response: models.CaptchaConfigResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/register", ID: "register", Tag: "auth", Summary: "Create an account", Public: true,
+		Description: "When email verification is required, email must be given and a RegistrationPendingResponse is returned instead of tokens. When a CAPTCHA is configured, send its token in the X-Captcha-Token header; without one the response is 403 captcha_required.",
+		Request:     models.AuthRequest{}, Status: http.StatusCreated, Response: models.AuthResponse{}, AltResponse: models.RegistrationPendingResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/login", ID: "login", Tag: "auth", Summary: "Log in", Public: true,
+		Description: "Returns 403 if email verification is required and the user's address isn't verified yet, and 429 with Retry-After while the account is locked after repeated failed logins. After loginAfterFailures failed logins from the client's IP, returns 403 captcha_required unless a CAPTCHA token is sent in the X-Captcha-Token header.",
+		Request:     models.AuthRequest{}, Response: models.AuthResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/refresh", ID: "refreshToken", Tag: "auth", Summary: "Exchange a refresh token for new tokens", Public: true,
+		Description: "Refresh tokens are single-use; store the returned refresh_token. Presenting a used refresh token revokes every token from the same login.",
+		Request:     models.RefreshRequest{}, Response: models.AuthResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/logout", ID: "logout", Tag: "auth", Summary: "Revoke the current tokens", Public: true,
+		Request: models.LogoutRequest{}, Response: models.MessageResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/logout-all", ID: "logoutAll", Tag: "auth", Summary: "Revoke all tokens for the current user",
+		Response: models.MessageResponse{}},
+	{Method: http.MethodGet, Path: "/api/auth/sessions", ID: "listSessions", Tag: "auth", Summary: "List the devices the current user is signed in on",
+		Description: "Most recently used first. lastSeenAt is updated on sign-in and on each token refresh; current marks the session making the request.",
+		Response:    []models.SessionDTO{}},
+	{Method: http.MethodDelete, Path: "/api/auth/sessions/{id}", ID: "revokeSession", Tag: "auth", Summary: "Sign the current user out on one device",
+		Description: "The session's refresh token stops working and its access tokens are rejected immediately. Scoped tokens are revoked the same way.",
+		Status:      http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/auth/scoped-tokens", ID: "createScopedToken", Tag: "auth", Summary: "Issue a token limited to a scope, such as read-only",
+		Description: "For widgets and kiosk displays. A notes:read token can make GET requests to /api/notes, /api/shared and /api/attachments and nothing else; other requests get 403. It lasts expiresInDays (default 90), can't be refreshed, and is listed among the user's sessions, where deleting it revokes it. Requires a token without a scope.",
+		Request:     models.CreateScopedTokenRequest{}, Status: http.StatusCreated, Response: models.ScopedTokenResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/change-password", ID: "changePassword", Tag: "auth", Summary: "Change password",
+		Request: models.ChangePasswordRequest{}, Response: models.MessageResponse{}},
+	{Method: http.MethodGet, Path: "/api/auth/me", ID: "getCurrentUser", Tag: "auth", Summary: "Current user",
+		Response: models.UserDTO{}},
+	{Method: http.MethodGet, Path: "/api/auth/token-info", ID: "getTokenInfo", Tag: "auth", Summary: "When the request's access token was issued and expires",
+		Description: "server_time lets clients allow for their clock being off when deciding when to refresh.",
+		Response:    models.TokenInfoResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/verify-email", ID: "verifyEmail", Tag: "auth", Summary: "Verify an email address with the emailed token", Public: true,
+		Request: models.VerifyEmailRequest{}, Response: models.UserDTO{}},
+	{Method: http.MethodPost, Path: "/api/auth/resend-verification", ID: "resendVerification", Tag: "auth", Summary: "Send a new verification link", Public: true,
+		Description: "Always succeeds, whether or not the address belongs to an account.",
+		Request:     models.EmailRequest{}, Response: models.MessageResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/demo", ID: "createDemoAccount", Tag: "auth", Summary: "Create and sign into a demo account of your own", Public: true, Status: http.StatusCreated,
+		Description: "The account starts with the sample notes and is deleted DEMO_ACCOUNT_TTL_MINUTES after creation. Returns 404 unless DEMO_MAX_ACCOUNTS is set, and 429 while that many are in use. Needs a CAPTCHA when one is configured.",
+		Response:    models.AuthResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/magic-link", ID: "sendMagicLink", Tag: "auth", Summary: "Email a single-use sign-in link", Public: true,
+		Description: "Succeeds whether or not the address has an account. The link opens <APP_BASE_URL>/magic-link?token=..., expires after 15 minutes, and replaces any link sent before.",
+		Request:     models.EmailRequest{}, Response: models.MessageResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/magic-link/exchange", ID: "exchangeMagicLink", Tag: "auth", Summary: "Sign in with the token from an emailed link", Public: true,
+		Description: "Each link works once. Returns 401 if the token is unknown, used or expired.",
+		Request:     models.MagicLinkExchangeRequest{}, Response: models.AuthResponse{}},
+	{Method: http.MethodPut, Path: "/api/auth/email", ID: "changeEmail", Tag: "auth", Summary: "Change email address",
+		Description: "The new address is unverified until the emailed link is used.",
+		Request:     models.EmailRequest{}, Response: models.UserDTO{}},
+
+	// Passkeys
+	{Method: http.MethodPost, Path: "/api/auth/webauthn/register/begin", ID: "beginPasskeyRegistration", Tag: "auth", Summary: "Start adding a passkey",
+		Description: "Pass publicKey to navigator.credentials.create (decoding its base64url fields) within 5 minutes, then finish with sessionId.",
+		Response:    models.WebAuthnRegistrationOptionsResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/webauthn/register/finish", ID: "finishPasskeyRegistration", Tag: "auth", Summary: "Save the passkey the authenticator created",
+		Description: "Binary fields of the credential are base64url-encoded. Returns 409 if the passkey is already registered.",
+		Request:     models.WebAuthnRegisterFinishRequest{}, Status: http.StatusCreated, Response: models.WebAuthnCredentialDTO{}},
+	{Method: http.MethodPost, Path: "/api/auth/webauthn/login/begin", ID: "beginPasskeyLogin", Tag: "auth", Summary: "Start signing in with a passkey", Public: true,
+		Description: "Pass publicKey to navigator.credentials.get within 5 minutes, then finish with sessionId. Without a username any passkey for this site can be used.",
+		Request:     models.WebAuthnLoginBeginRequest{}, Response: models.WebAuthnLoginOptionsResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/webauthn/login/finish", ID: "finishPasskeyLogin", Tag: "auth", Summary: "Sign in with the passkey's signature", Public: true,
+		Description: "Binary fields of the credential are base64url-encoded. Returns 403 if email verification is required and the user's address isn't verified yet.",
+		Request:     models.WebAuthnLoginFinishRequest{}, Response: models.AuthResponse{}},
+	{Method: http.MethodGet, Path: "/api/auth/webauthn/credentials", ID: "listPasskeys", Tag: "auth", Summary: "List the current user's passkeys",
+		Response: []models.WebAuthnCredentialDTO{}},
+	{Method: http.MethodDelete, Path: "/api/auth/webauthn/credentials/{id}", ID: "deletePasskey", Tag: "auth", Summary: "Remove a passkey",
+		Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/auth/oauth/providers", ID: "listSignInProviders", Tag: "auth", Summary: "List the providers users can sign in with", Public: true,
+		Response: []string{}},
+	{Method: http.MethodGet, Path: "/api/auth/oauth/{provider}/start", ID: "startProviderSignIn", Tag: "auth", Summary: "Redirect to the provider to sign in", Public: true,
+		Description: "Open in a browser. The provider sends the user back to the callback, which redirects to returnTo with a code to exchange for tokens, or an error.",
+		Query: []Param{
+			{Name: "returnTo", Type: "string", Description: "Where to send the user afterwards; one of OAUTH_RETURN_URLS, default the first"},
+		},
+		Status: http.StatusFound},
+	{Method: http.MethodGet, Path: "/api/auth/oauth/{provider}/callback", ID: "providerSignInCallback", Tag: "auth", Summary: "Where the provider sends the user back to", Public: true,
+		Description: "Redirects to the return URL with code, linked=<provider>, or error=access_denied, expired, failed, already_linked or registration_closed.",
+		Status:      http.StatusSeeOther},
+	{Method: http.MethodPost, Path: "/api/auth/oauth/{provider}/callback", ID: "providerSignInFormCallback", Tag: "auth", Summary: "Where the provider posts the user back to", Public: true,
+		Description: "Sign in with Apple posts a form here instead of redirecting. Responds as the GET callback does.",
+		Status:      http.StatusSeeOther},
+	{Method: http.MethodPost, Path: "/api/auth/oauth/exchange", ID: "exchangeSignInCode", Tag: "auth", Summary: "Trade the code from the return URL for tokens", Public: true,
+		Description: "Codes are single-use and expire after a minute. Returns 403 if email verification is required and the user's address isn't verified yet.",
+		Request:     models.OAuthExchangeRequest{}, Response: models.AuthResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/oauth/{provider}/token", ID: "signInWithIDToken", Tag: "auth", Summary: "Sign in with an ID token from the provider's native SDK", Public: true,
+		Description: "The token's nonce must be the request's nonce or its hex SHA-256. A new account is created, or one with the same verified email linked, if the provider account isn't linked yet.",
+		Request:     models.OAuthTokenRequest{}, Response: models.AuthResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/oauth/{provider}/link", ID: "linkProvider", Tag: "auth", Summary: "Start linking a provider account to the current user",
+		Description: "Open the returned URL in a browser; the callback redirects to the return URL with linked=<provider> or an error.",
+		Request:     models.OAuthLinkRequest{}, Response: models.OAuthURLResponse{}},
+	{Method: http.MethodGet, Path: "/api/auth/identities", ID: "listLinkedAccounts", Tag: "auth", Summary: "List the provider accounts linked to the current user",
+		Response: []models.UserIdentityDTO{}},
+	{Method: http.MethodDelete, Path: "/api/auth/identities/{id}", ID: "unlinkAccount", Tag: "auth", Summary: "Unlink a provider account",
+		Description: "Refused if it's the user's only way to sign in.",
+		Status:      http.StatusNoContent},
+
+	// Setup
+	{Method: http.MethodGet, Path: "/api/setup", ID: "getSetupStatus", Tag: "setup", Summary: "Whether the server still needs its first administrator", Public: true,
+		Response: models.SetupStatusResponse{}},
+	{Method: http.MethodPost, Path: "/api/setup", ID: "completeSetup", Tag: "setup", Summary: "Create the first administrator and choose server-wide settings", Public: true,
+		Description: "Needs the one-time setup token the server logs at startup while no administrator exists. Returns 403 if the token is wrong or setup is done. Logs the administrator in.",
+		Request:     models.SetupRequest{}, Status: http.StatusCreated, Response: models.AuthResponse{}},
+
+	// Notes
+	{Method: http.MethodGet, Path: "/api/notes", ID: "listNotes", Tag: "notes", Summary: "List notes",
+		Description: "With asOf, returns the notes as they were at that time (read-only), built from each note's last 50 revisions.",
+		Query: []Param{
+			{Name: "since", Type: "string", Description: "Only return notes changed after this ISO 8601 time"},
+			{Name: "asOf", Type: "string", Description: "Return notes as they existed at this ISO 8601 time"},
+			{Name: "lite", Type: "boolean", Description: "Return content previews and only changed checklist items (isPartial)"},
+			{Name: "include", Type: "string", Description: "Checklist items in full (default), counts only in checklistSummary, or neither (isPartial unless items)",
+				Enum: []string{models.IncludeItems, models.IncludeCounts, models.IncludeNone}},
+			{Name: "stream", Type: "boolean", Description: "Stream the notes as they're read instead of building the response first; Accept: application/x-ndjson streams NoteStreamLines"},
+		},
+		Response: models.SyncResponse{}},
+	{Method: http.MethodPost, Path: "/api/notes", ID: "createNote", Tag: "notes", Summary: "Create a note",
+		Description: "Send an Idempotency-Key header to make retries safe: a repeat with the same key returns the original response.",
+		Request:     models.NoteDTO{}, Status: http.StatusCreated, Response: models.NoteDTO{}},
+	{Method: http.MethodGet, Path: "/api/notes/search", ID: "searchNotes", Tag: "notes", Summary: "Search notes",
+		Description: "Matches notes whose title and content contain every word of q, best matches first. With fuzzy, matches titles similar to q instead, tolerating typos and partial words.",
+		Query: []Param{
+			{Name: "q", Type: "string", Description: "What to search for (required, up to 200 bytes)"},
+			{Name: "fuzzy", Type: "boolean", Description: "Match titles by trigram similarity"},
+			{Name: "limit", Type: "integer", Description: "Most notes to return, 1-100 (default 50)"},
+		},
+		Response: []models.NoteDTO{}},
+	{Method: http.MethodGet, Path: "/api/notes/{id}", ID: "getNote", Tag: "notes", Summary: "Get a note",
+		Response: models.NoteDTO{}},
+	{Method: http.MethodPut, Path: "/api/notes/{id}", ID: "updateNote", Tag: "notes", Summary: "Update a note",
+		Description: "Send the version of the note being saved over (required from API version 3). If the note has changed since, the response is 409 with error version_conflict and the current note in note.",
+		Request:     models.NoteDTO{}, Response: models.NoteDTO{}},
+	{Method: http.MethodDelete, Path: "/api/notes/{id}", ID: "deleteNote", Tag: "notes", Summary: "Delete a note",
+		Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/notes/{id}/pdf", ID: "exportNotePDF", Tag: "notes", Summary: "Download a note as PDF",
+		Query: []Param{
+			{Name: "paper", Type: "string", Description: "Paper size", Enum: []string{"a4", "letter"}},
+			{Name: "metadata", Type: "boolean", Description: "Include timestamps and metadata"},
+		},
+		Response: Binary{ContentType: "application/pdf"}},
+	{Method: http.MethodGet, Path: "/api/notes/{id}/revisions", ID: "listNoteRevisions", Tag: "notes", Summary: "List a note's saved revisions, newest first",
+		Response: []models.RevisionDTO{}},
+	{Method: http.MethodGet, Path: "/api/notes/{id}/revisions/{rev}/diff", ID: "diffNoteRevision", Tag: "notes", Summary: "Line- and word-level diff between two revisions",
+		Description: "Compares the revision with the one given by against, or with the revision before it. Changed lines carry word spans.",
+		Query:       []Param{{Name: "against", Type: "string", Description: "Revision ID to compare with (default: the previous revision)"}},
+		Response:    models.NoteDiffDTO{}},
+	{Method: http.MethodGet, Path: "/api/notes/{id}/position", ID: "getNotePosition", Tag: "notes", Summary: "Get the reading position saved for a note",
+		Response: models.NotePositionDTO{}},
+	{Method: http.MethodPut, Path: "/api/notes/{id}/position", ID: "setNotePosition", Tag: "notes", Summary: "Save the reading position in a note",
+		Description: "Saved separately from the note, so it doesn't change the note's updatedAt or revisions. Sent to the user's other connections as note_position_updated.",
+		Request:     models.NotePositionDTO{}, Response: models.NotePositionDTO{}},
+	{Method: http.MethodPost, Path: "/api/notes/sync", ID: "syncNotes", Tag: "sync", Summary: "Send local changes and fetch changes since lastSync",
+		Description: "All changes are applied in one transaction. If any item is invalid nothing is applied and the response is 422 with a list of failures. Honors the Idempotency-Key header. Send Content-Type and Accept application/msgpack to use MessagePack instead of JSON.",
+		Query: []Param{
+			{Name: "lite", Type: "boolean", Description: "Same as the lite body field"},
+			{Name: "dryRun", Type: "boolean", Description: "Validate the request and report what each item would do, without applying anything. The response is a SyncPreflightResponse."},
+		},
+		Request: models.SyncRequest{}, Response: models.SyncResponse{}, AltResponse: models.SyncPreflightResponse{}},
+	{Method: http.MethodGet, Path: "/api/sync/batches", ID: "listSyncBatches", Tag: "sync", Summary: "List recent syncs that can be reverted",
+		Query:    []Param{{Name: "limit", Type: "integer", Description: "Maximum number to return (1-200, default 50)"}},
+		Response: []models.SyncBatchDTO{}},
+	{Method: http.MethodPost, Path: "/api/sync/{batchId}/revert", ID: "revertSyncBatch", Tag: "sync", Summary: "Undo every change a sync made",
+		Description: "Restores each note the sync changed to its previous state, overwriting later edits. The revert is itself a batch (batchId) that can be reverted.",
+		Response:    models.SyncResponse{}},
+	{Method: http.MethodGet, Path: "/api/notes/order", ID: "getNoteOrder", Tag: "notes", Summary: "Get the manual note order of a view",
+		Query:    []Param{{Name: "context", Type: "string", Description: "'all' (default), 'folder:<id>' or 'tag:<name>'"}},
+		Response: models.NoteOrderDTO{}},
+	{Method: http.MethodPut, Path: "/api/notes/order", ID: "setNoteOrder", Tag: "notes", Summary: "Reorder notes within a view",
+		Description: "Replaces the order of one view without affecting others. Reordering 'all' updates the notes' sortOrder.",
+		Request:     models.NoteOrderDTO{}, Response: models.NoteOrderDTO{}},
+
+	// Attachments
+	{Method: http.MethodGet, Path: "/api/notes/{id}/attachments", ID: "listAttachments", Tag: "attachments", Summary: "List a note's attachments",
+		Response: []models.AttachmentDTO{}},
+	{Method: http.MethodPost, Path: "/api/notes/{id}/attachments", ID: "uploadAttachment", Tag: "attachments", Summary: "Upload a voice memo (m4a, caf or wav)",
+		Description: "Uploads that would take the user past the total storage quota are refused with 413 and error quota_exceeded.",
+		Request:     Binary{ContentType: "multipart/form-data"}, Status: http.StatusCreated, Response: models.AttachmentDTO{}},
+	{Method: http.MethodGet, Path: "/api/attachments/{id}", ID: "downloadAttachment", Tag: "attachments", Summary: "Stream an attachment",
+		Description: "Supports Range requests for seeking; partial responses use status 206.",
+		Response:    Binary{ContentType: "audio/*"}},
+	{Method: http.MethodDelete, Path: "/api/attachments/{id}", ID: "deleteAttachment", Tag: "attachments", Summary: "Delete an attachment",
+		Status: http.StatusNoContent},
+
+	// Devices
+	{Method: http.MethodGet, Path: "/api/devices", ID: "listDevices", Tag: "devices", Summary: "List the user's devices and when each last synced",
+		Response: []models.DeviceDTO{}},
+	{Method: http.MethodPost, Path: "/api/devices", ID: "registerDevice", Tag: "devices", Summary: "Register the calling device",
+		Description: "Send the deviceId in the X-Device-ID header on sync requests so the server tracks the device's sync cursor.",
+		Request:     models.RegisterDeviceRequest{}, Response: models.DeviceDTO{}},
+	{Method: http.MethodDelete, Path: "/api/devices/{id}", ID: "revokeDevice", Tag: "devices", Summary: "Revoke a device so it can no longer sync",
+		Response: models.DeviceDTO{}},
+
+	// Presence
+	{Method: http.MethodGet, Path: "/api/presence", ID: "getPresence", Tag: "realtime", Summary: "List the user's devices connected for real-time sync",
+		Description: "The same list is pushed to open WebSocket connections as presence messages whenever a device connects or disconnects.",
+		Response:    models.PresenceDTO{}},
+
+	// Activity summary
+	{Method: http.MethodGet, Path: "/api/activity-summary", ID: "getActivitySummary", Tag: "activity", Summary: "Get the monthly summary subscription and a preview of last month",
+		Response: models.ActivitySummaryDTO{}},
+	{Method: http.MethodPut, Path: "/api/activity-summary", ID: "subscribeActivitySummary", Tag: "activity", Summary: "Turn on the monthly summary email, or change its address",
+		Description: "Summaries cover a calendar month (UTC) and are sent after it ends; the first is for the current month. Months with no activity are skipped.",
+		Request:     models.ActivitySummaryRequest{}, Response: models.ActivitySummaryDTO{}},
+	{Method: http.MethodDelete, Path: "/api/activity-summary", ID: "unsubscribeActivitySummary", Tag: "activity", Summary: "Turn off the monthly summary email",
+		Status: http.StatusNoContent},
+
+	// Usage
+	{Method: http.MethodGet, Path: "/api/usage", ID: "getUsage", Tag: "notes", Summary: "How much the user stores in notes, and the storage quotas",
+		Description: "Bytes count note titles, content, checklist item text and attachments, with attachmentBytes the attachments' part. Limits of 0 are unlimited. Writes past a quota are refused with 413 and error quota_exceeded.",
+		Response:    models.UsageDTO{}},
+
+	// Telemetry
+	{Method: http.MethodGet, Path: "/api/telemetry", ID: "previewTelemetry", Tag: "telemetry", Summary: "Preview the anonymous usage report this instance sends",
+		Description: "Reports are only sent when the operator sets TELEMETRY_ENABLED=true and TELEMETRY_URL. The preview shows the exact report either way.",
+		Response:    models.TelemetryPreviewDTO{}},
+
+	// Cold storage
+	{Method: http.MethodGet, Path: "/api/archive/notes", ID: "listColdNotes", Tag: "archive", Summary: "List notes moved to cold storage",
+		Description: "Notes archived and untouched for COLD_STORAGE_AFTER_MONTHS are moved out of sync; they are only available here.",
+		Query: []Param{
+			{Name: "limit", Type: "integer", Description: "Maximum number to return (1-200, default 50)"},
+			{Name: "offset", Type: "integer", Description: "Number of notes to skip"},
+		},
+		Response: []models.NoteDTO{}},
+	{Method: http.MethodGet, Path: "/api/archive/notes/{id}", ID: "getColdNote", Tag: "archive", Summary: "Get a cold-stored note",
+		Response: models.NoteDTO{}},
+	{Method: http.MethodPost, Path: "/api/archive/notes/{id}/restore", ID: "restoreColdNote", Tag: "archive", Summary: "Move a note out of cold storage so it syncs again",
+		Response: models.NoteDTO{}},
+
+	// Archive exports
+	{Method: http.MethodGet, Path: "/api/exports", ID: "listArchiveExports", Tag: "exports", Summary: "List the archives exported so far, newest first",
+		Response: []models.ArchiveExportDTO{}},
+	{Method: http.MethodPost, Path: "/api/exports", ID: "exportArchive", Tag: "exports", Summary: "Export a signed, hash-chained archive of all notes and their history",
+		Description: "Each export is chained onto the previous one and recorded, so exports can be added to but never replaced. Returns 409 if another export is made at the same time.",
+		Query: []Param{
+			{Name: "stream", Type: "boolean", Description: "Write the archive as it's built, for very large accounts; an archive cut short has no signature"},
+		},
+		Status: http.StatusCreated, Response: models.Archive{}},
+	{Method: http.MethodPost, Path: "/api/exports/verify", ID: "verifyArchive", Tag: "exports", Summary: "Check an exported archive for tampering",
+		Request: models.Archive{}, Response: models.ArchiveVerificationDTO{}},
+	{Method: http.MethodGet, Path: "/api/exports/public-key", ID: "getArchivePublicKey", Tag: "exports", Summary: "Key archives are signed with, for verifying them offline", Public: true,
+		Response: models.ArchivePublicKeyDTO{}},
+
+	// Batch export
+	{Method: http.MethodPost, Path: "/api/export", ID: "createExport", Tag: "export", Summary: "Export the notes matching a filter as one file",
+		Description: "Runs in the background: poll getExport until status is completed, then download it. Export a folder or tag by listing its noteIds.",
+		Request:     models.CreateExportRequest{}, Status: http.StatusAccepted, Response: models.ExportJobDTO{}},
+	{Method: http.MethodGet, Path: "/api/export", ID: "listExports", Tag: "export", Summary: "List batch exports, newest first",
+		Response: []models.ExportJobDTO{}},
+	{Method: http.MethodGet, Path: "/api/export/{id}", ID: "getExport", Tag: "export", Summary: "Progress of a batch export",
+		Response: models.ExportJobDTO{}},
+	{Method: http.MethodGet, Path: "/api/export/{id}/download", ID: "downloadExport", Tag: "export", Summary: "Download a completed batch export",
+		Description: "Returns 409 until the export has completed.",
+		Response:    Binary{ContentType: "application/octet-stream"}},
+
+	// Admin
+	{Method: http.MethodGet, Path: "/api/admin/integrity", ID: "listIntegrityReports", Tag: "admin", Summary: "Recent referential integrity reports, newest first",
+		Description: "Administrators only.",
+		Query:       []Param{{Name: "limit", Type: "integer", Description: "Maximum number to return (1-100, default 10)"}},
+		Response:    []models.IntegrityReportDTO{}},
+	{Method: http.MethodPost, Path: "/api/admin/integrity", ID: "runIntegrityCheck", Tag: "admin", Summary: "Check referential integrity now, optionally repairing what is found",
+		Description: "Administrators only. Returns 409 if a check is already running.",
+		Request:     models.RunIntegrityCheckRequest{}, Response: models.IntegrityReportDTO{}},
+	{Method: http.MethodPost, Path: "/api/admin/backup", ID: "backup", Tag: "admin", Summary: "Download a backup of one user or the whole instance",
+		Description: "Administrators only. Without a userId every user and the server-wide settings are backed up. The backup is newline-delimited JSON read from one consistent snapshot: a header, one line per row and a closing line with the row count. Attachment files, sign-ins and tokens are not included. Returns 404 if the user doesn't exist.",
+		Request:     models.BackupRequest{}, Response: Binary{ContentType: "application/x-ndjson"}},
+	{Method: http.MethodPost, Path: "/api/admin/restore", ID: "restore", Tag: "admin", Summary: "Replace the instance's or a user's data with a backup",
+		Description: "Administrators only. The body is a backup from POST /api/admin/backup. A whole-instance backup replaces every user, signing everyone out; a user's backup replaces that user. The restore runs in one transaction, and returns 400 naming the first problem, leaving everything as it was, if the backup is damaged or a row doesn't fit. Returns 409 if a restore is already running.",
+		Query:       []Param{{Name: "dryRun", Type: "boolean", Description: "Check the backup by restoring it in a transaction that is rolled back"}},
+		Request:     Binary{ContentType: "application/x-ndjson"}, Response: models.RestoreResultDTO{}},
+	{Method: http.MethodGet, Path: "/api/admin/websocket", ID: "getWebSocketStats", Tag: "admin", Summary: "Real-time connections, traffic, recovered panics and slow clients",
+		Description: "Administrators only. connectionsPerUser lists the 100 users with the most connections; broadcast, message and byte counts are totals since the server started, with rates per second over the last minute. Panics counts panics recovered in the WebSocket hub and client connections; loopRestarts counts restarts of the hub's event loop. droppedMessages counts messages skipped because a client's send buffer was full, slowDisconnects the clients closed for it, and laggingClients lists up to 20 open connections with dropped messages or a half-full buffer. rateLimitDisconnects counts clients closed for sending messages too fast.",
+		Response:    models.WebSocketStatsDTO{}},
+	{Method: http.MethodGet, Path: "/api/admin/settings", ID: "getInstanceSettings", Tag: "admin", Summary: "Server-wide settings",
+		Description: "Administrators only.",
+		Response:    models.InstanceSettingsDTO{}},
+	{Method: http.MethodPut, Path: "/api/admin/settings", ID: "updateInstanceSettings", Tag: "admin", Summary: "Change server-wide settings",
+		Description: "Administrators only. Omitted fields are left unchanged. With registration closed, new accounts can't be created by registering or signing in with a provider.",
+		Request:     models.UpdateInstanceSettingsRequest{}, Response: models.InstanceSettingsDTO{}},
+	{Method: http.MethodGet, Path: "/api/admin/maintenance", ID: "getMaintenance", Tag: "admin", Summary: "Whether the server is in maintenance mode",
+		Description: "Administrators only.",
+		Response:    models.MaintenanceDTO{}},
+	{Method: http.MethodPut, Path: "/api/admin/maintenance", ID: "setMaintenance", Tag: "admin", Summary: "Turn maintenance mode on or off",
+		Description: "Administrators only. Applies to every instance within a few seconds. While it's on, requests that change anything, other than signing in and out and the admin routes, get 503 with error maintenance and a Retry-After header counting down to until (5 minutes when until isn't given); reads carry on. WebSocket clients get a maintenance message with the new state, and their note writes are refused with error maintenance.",
+		Request:     models.UpdateMaintenanceRequest{}, Response: models.MaintenanceDTO{}},
+	{Method: http.MethodGet, Path: "/api/admin/lockouts", ID: "listLoginLockouts", Tag: "admin", Summary: "Usernames locked out after repeated failed logins",
+		Description: "Administrators only. Longest-locked first.",
+		Response:    []models.LoginLockoutDTO{}},
+	{Method: http.MethodDelete, Path: "/api/admin/lockouts/{username}", ID: "unlockLogin", Tag: "admin", Summary: "End a username's lockout early",
+		Description: "Administrators only. Also clears its failed logins. Returns 404 if it isn't locked.",
+		Status:      http.StatusNoContent},
+
+	// Sharing
+	{Method: http.MethodGet, Path: "/api/notes/{id}/invites", ID: "listInvites", Tag: "sharing", Summary: "List invitations for a note",
+		Response: []models.InviteDTO{}},
+	{Method: http.MethodPost, Path: "/api/notes/{id}/invites", ID: "createInvite", Tag: "sharing", Summary: "Invite someone to a note by email",
+		Request: models.InviteRequest{}, Status: http.StatusCreated, Response: models.InviteDTO{}},
+	{Method: http.MethodPost, Path: "/api/notes/{id}/invites/{inviteId}/resend", ID: "resendInvite", Tag: "sharing", Summary: "Resend an invitation with a fresh link",
+		Response: models.InviteDTO{}},
+	{Method: http.MethodDelete, Path: "/api/notes/{id}/invites/{inviteId}", ID: "revokeInvite", Tag: "sharing", Summary: "Revoke an invitation",
+		Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/invites/accept", ID: "acceptInvite", Tag: "sharing", Summary: "Accept an invitation",
+		Request: models.AcceptInviteRequest{}, Response: models.NoteDTO{}},
+	{Method: http.MethodGet, Path: "/api/shared/notes", ID: "listSharedNotes", Tag: "sharing", Summary: "List notes shared with you",
+		Response: []models.NoteDTO{}},
+	{Method: http.MethodGet, Path: "/api/shared/notes/{id}", ID: "getSharedNote", Tag: "sharing", Summary: "Get a note shared with you",
+		Response: models.NoteDTO{}},
+
+	// Notifications
+	{Method: http.MethodGet, Path: "/api/notifications", ID: "listNotifications", Tag: "notifications", Summary: "List notifications",
+		Query: []Param{
+			{Name: "unread", Type: "boolean", Description: "Only return unread notifications"},
+			{Name: "limit", Type: "integer", Description: "Maximum number to return (1-200, default 50)"},
+		},
+		Response: []models.NotificationDTO{}},
+	{Method: http.MethodPost, Path: "/api/notifications/read-all", ID: "markAllNotificationsRead", Tag: "notifications", Summary: "Mark all notifications as read",
+		Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/notifications/{id}/read", ID: "markNotificationRead", Tag: "notifications", Summary: "Mark a notification as read",
+		Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/settings/notifications", ID: "getNotificationPreferences", Tag: "notifications", Summary: "Channels each category of notification is delivered on",
+		Response: models.NotificationPreferencesDTO{}},
+	{Method: http.MethodPut, Path: "/api/settings/notifications", ID: "updateNotificationPreferences", Tag: "notifications", Summary: "Change notification channels",
+		Description: "Categories left out are unchanged.",
+		Request:     models.NotificationPreferencesDTO{}, Response: models.NotificationPreferencesDTO{}},
+
+	// WebSocket
+	{Method: http.MethodGet, Path: "/api/ws", ID: "connectWebSocket", Tag: "realtime", Summary: "Open the real-time sync WebSocket", Public: true,
+		Description: `Authenticate with the Sec-WebSocket-Protocol header: ["access_token", "<token>"].`,
+		Query: []Param{
+			{Name: "v", Type: "integer", Description: "Protocol version to speak (default: 1)"},
+			{Name: "deviceId", Type: "string", Description: "The device's ID, for clients that can't send X-Device-ID"},
+			{Name: "resume_from", Type: "string", Description: "<epoch>:<seq> of the last event received, to replay the ones missed (protocol version 5 and later)"},
+			{Name: "encoding", Type: "string", Description: "json (default) or msgpack for MessagePack in binary frames (protocol version 9 and later)"},
+		},
+		Status: http.StatusSwitchingProtocols},
+}
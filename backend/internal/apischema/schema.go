@@ -0,0 +1,146 @@
+// Package apischema builds the OpenAPI document for the REST API from the DTO types the handlers use,
+// so generated Swift and TypeScript clients stay in step with the server.
+package apischema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// generator converts Go types to OpenAPI schemas, collecting named structs as components
+type generator struct {
+	components map[string]any
+}
+
+func newGenerator() *generator {
+	return &generator{components: make(map[string]any)}
+}
+
+// schema returns the schema for t; named structs are registered as components and referenced
+func (g *generator) schema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	}
+
+	// Raw JSON can hold any value
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return map[string]any{}
+	}
+
+	// UUIDs marshal as strings
+	if t == reflect.TypeOf(uuid.UUID{}) {
+		return map[string]any{"type": "string", "format": "uuid"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Uint16, reflect.Uint8:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.ref(t)
+	default:
+		return map[string]any{}
+	}
+}
+
+// ref registers a struct as a component schema and returns a reference to it
+func (g *generator) ref(t reflect.Type) map[string]any {
+	name := t.Name()
+	ref := map[string]any{"$ref": "#/components/schemas/" + name}
+	if _, ok := g.components[name]; ok {
+		return ref
+	}
+
+	// Reserve the name first so self-referencing types terminate
+	g.components[name] = nil
+
+	properties := make(map[string]any)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, omitEmpty := jsonName(field)
+		if name == "" {
+			continue
+		}
+
+		s := g.schema(field.Type)
+		applyBinding(s, field.Tag.Get("binding"))
+		if values, ok := fieldEnums[t.Name()+"."+name]; ok {
+			s["enum"] = values
+		}
+		properties[name] = s
+
+		if !omitEmpty && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	component := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		component["required"] = required
+	}
+	g.components[t.Name()] = component
+	return ref
+}
+
+// jsonName returns a field's JSON name ("" if it isn't serialized) and whether it is omitempty
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(","+opts+",", ",omitempty,")
+}
+
+// applyBinding translates gin binding rules into schema constraints
+func applyBinding(s map[string]any, binding string) {
+	if binding == "" {
+		return
+	}
+	isString := s["type"] == "string"
+	for _, rule := range strings.Split(binding, ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		n, _ := strconv.Atoi(value)
+		switch {
+		case key == "min" && isString:
+			s["minLength"] = n
+		case key == "max" && isString:
+			s["maxLength"] = n
+		case key == "email":
+			s["format"] = "email"
+		case key == "alphanum":
+			s["pattern"] = "^[A-Za-z0-9]+$"
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package apischema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+)
+
+// APIVersion is the version reported in the OpenAPI document and health check.
+// Bump it when making a breaking change to an endpoint or DTO.
+const APIVersion = "1.1.0"
+
+// Binary describes a non-JSON request or response body
+type Binary struct {
+	ContentType string
+}
+
+// Param is a query parameter
+type Param struct {
+	Name        string
+	Type        string // "string", "integer" or "boolean"
+	Description string
+	Enum        []string
+}
+
+// Operation describes one endpoint. Path parameters are written {name} and are UUIDs.
+type Operation struct {
+	Method      string
+	Path        string
+	ID          string // operationId, used as the method name in generated clients
+	Tag         string
+	Summary     string
+	Public      bool // no bearer token required
+	Query       []Param
+	Request     any // zero value of the request DTO, Binary for uploads, or nil
+	Status      int // success status; defaults to 200
+	Response    any // zero value of the response DTO, Binary for downloads, or nil for no body
+	AltResponse any // a JSON response DTO returned instead of Response in some cases, e.g. when the query asks for it
+	Description string
+}
+
+var (
+	buildOnce sync.Once
+	document  []byte
+	docHash   string
+)
+
+// Document returns the OpenAPI 3.0 document as JSON
+func Document() []byte {
+	build()
+	return document
+}
+
+// Hash returns the hex SHA-256 of the OpenAPI document
+func Hash() string {
+	build()
+	return docHash
+}
+
+func build() {
+	buildOnce.Do(func() {
+		// encoding/json sorts map keys, so the output (and its hash) is stable
+		data, err := json.Marshal(newDocument(operations))
+		if err != nil {
+			panic("apischema: " + err.Error())
+		}
+		sum := sha256.Sum256(data)
+		document = data
+		docHash = hex.EncodeToString(sum[:])
+	})
+}
+
+func newDocument(ops []Operation) map[string]any {
+	g := newGenerator()
+	errorRef := g.schema(reflect.TypeOf(errorResponse))
+
+	paths := make(map[string]any)
+	for _, op := range ops {
+		item, _ := paths[op.Path].(map[string]any)
+		if item == nil {
+			item = make(map[string]any)
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = g.operation(op, errorRef)
+	}
+
+	var tags []any
+	for _, name := range tagNames(ops) {
+		tags = append(tags, map[string]any{"name": name})
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Notes API",
+			"version": APIVersion,
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		"security": []any{map[string]any{"bearerAuth": []any{}}},
+	}
+}
+
+func (g *generator) operation(op Operation, errorRef map[string]any) map[string]any {
+	o := map[string]any{
+		"operationId": op.ID,
+		"summary":     op.Summary,
+		"tags":        []any{op.Tag},
+	}
+	if op.Description != "" {
+		o["description"] = op.Description
+	}
+	if op.Public {
+		o["security"] = []any{}
+	}
+
+	var params []any
+	for _, name := range pathParams(op.Path) {
+		params = append(params, map[string]any{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   pathParamSchema(name),
+		})
+	}
+	params = append(params, map[string]any{
+		"name":        "X-API-Version",
+		"in":          "header",
+		"description": fmt.Sprintf("API version the client was built against (1-%d, default 1); responses use that version's field names", services.LatestAPIVersion),
+		"schema":      map[string]any{"type": "integer", "minimum": services.APIVersion1},
+	})
+	for _, p := range op.Query {
+		s := map[string]any{"type": p.Type}
+		if len(p.Enum) > 0 {
+			s["enum"] = p.Enum
+		}
+		params = append(params, map[string]any{
+			"name":        p.Name,
+			"in":          "query",
+			"description": p.Description,
+			"schema":      s,
+		})
+	}
+	if len(params) > 0 {
+		o["parameters"] = params
+	}
+
+	switch body := op.Request.(type) {
+	case nil:
+	case Binary:
+		o["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				body.ContentType: map[string]any{"schema": map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"file": map[string]any{"type": "string", "format": "binary"}},
+					"required":   []any{"file"},
+				}},
+			},
+		}
+	default:
+		o["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(body))}},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	switch body := op.Response.(type) {
+	case nil:
+	case Binary:
+		success["content"] = map[string]any{body.ContentType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	default:
+		schema := g.schema(reflect.TypeOf(body))
+		if op.AltResponse != nil {
+			schema = map[string]any{"oneOf": []any{schema, g.schema(reflect.TypeOf(op.AltResponse))}}
+		}
+		success["content"] = map[string]any{"application/json": map[string]any{"schema": schema}}
+	}
+
+	errorBody := func(status int) map[string]any {
+		return map[string]any{
+			"description": http.StatusText(status),
+			"content":     map[string]any{"application/json": map[string]any{"schema": errorRef}},
+		}
+	}
+	responses := map[string]any{
+		strconv.Itoa(status): success,
+		"400":                errorBody(http.StatusBadRequest),
+		"500":                errorBody(http.StatusInternalServerError),
+	}
+	if !op.Public {
+		responses["401"] = errorBody(http.StatusUnauthorized)
+	}
+	if len(pathParams(op.Path)) > 0 {
+		responses["404"] = errorBody(http.StatusNotFound)
+	}
+	o["responses"] = responses
+
+	return o
+}
+
+// pathParamSchema returns the schema of a path parameter: one of pathParamEnums, a string if it's
+// in pathParamStrings, else a UUID
+func pathParamSchema(name string) map[string]any {
+	if pathParamStrings[name] {
+		return map[string]any{"type": "string"}
+	}
+	if values, ok := pathParamEnums[name]; ok {
+		enum := make([]any, len(values))
+		for i, value := range values {
+			enum[i] = value
+		}
+		return map[string]any{"type": "string", "enum": enum}
+	}
+	return map[string]any{"type": "string", "format": "uuid"}
+}
+
+func pathParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, segment[1:len(segment)-1])
+		}
+	}
+	return names
+}
+
+func tagNames(ops []Operation) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, op := range ops {
+		if !seen[op.Tag] {
+			seen[op.Tag] = true
+			names = append(names, op.Tag)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Documented reports whether a router path (using :name parameters) has an operation in the document
+func Documented(method, path string) bool {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	path = strings.Join(segments, "/")
+
+	for _, op := range operations {
+		if op.Method == method && op.Path == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+// Package archive builds and verifies tamper-evident note archives. Each entry's hash covers the
+// previous entry's hash, so changing, removing or reordering any entry breaks every hash after it,
+// and the final hash is signed with Ed25519 so the whole archive can be checked against the
+// server's public key without trusting whoever holds the file.
+package archive
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+// GenesisHash is the previous hash of a user's first archive
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+var (
+	ErrUnknownFormat     = errors.New("unknown archive format")
+	ErrBrokenChain       = errors.New("an entry's hash doesn't match its contents or the entry before it")
+	ErrInvalidSignature  = errors.New("the signature doesn't match the archive")
+	ErrUnexpectedKey     = errors.New("the archive was signed with a different key")
+	ErrInvalidPublicKey  = errors.New("invalid public key")
+	ErrInvalidEntryCount = errors.New("the entry count doesn't match the entries")
+)
+
+// Signer signs archives with an Ed25519 key
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner creates a signer from a 32-byte Ed25519 seed
+func NewSigner(seed []byte) *Signer {
+	return &Signer{key: ed25519.NewKeyFromSeed(seed)}
+}
+
+// PublicKey returns the base64-encoded public key archives are verified against
+func (s *Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// Seal chains the archive's entries onto its PrevHash, then sets HeadHash and signs it
+func (s *Signer) Seal(a *models.Archive) error {
+	chain := NewChain(a.PrevHash)
+	for i := range a.Entries {
+		if err := chain.Add(&a.Entries[i]); err != nil {
+			return err
+		}
+	}
+	s.Sign(a, chain)
+	return nil
+}
+
+// Sign sets the archive's format, entry count and head hash from a finished chain, and signs it.
+// The archive's entries aren't looked at, so they can have been written out already.
+func (s *Signer) Sign(a *models.Archive, chain *Chain) {
+	a.Format = models.ArchiveFormat
+	a.EntryCount = chain.count
+	a.HeadHash = chain.prev
+	a.SignatureAlgorithm = models.ArchiveSignatureAlgorithm
+	a.PublicKey = s.PublicKey()
+	a.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, signedHeader(a)))
+}
+
+// Chain links entries into an archive's hash chain one at a time, so large archives can be written
+// out as they're built
+type Chain struct {
+	prev  string
+	count int
+}
+
+// NewChain starts a chain onto the previous export's head hash
+func NewChain(prevHash string) *Chain {
+	return &Chain{prev: prevHash}
+}
+
+// Add numbers the entry and links it onto the chain
+func (c *Chain) Add(entry *models.ArchiveEntry) error {
+	entry.Seq = c.count + 1
+	entry.PrevHash = c.prev
+	hash, err := entryHash(entry)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	c.prev = hash
+	c.count++
+	return nil
+}
+
+// Verify checks the archive's hash chain and signature. If publicKey is set, the archive must also
+// have been signed with it. On a broken chain it also returns the sequence number of the first bad
+// entry.
+func Verify(a *models.Archive, publicKey string) (int, error) {
+	if a.Format != models.ArchiveFormat || a.SignatureAlgorithm != models.ArchiveSignatureAlgorithm {
+		return 0, ErrUnknownFormat
+	}
+	if publicKey != "" && a.PublicKey != publicKey {
+		return 0, ErrUnexpectedKey
+	}
+	if a.EntryCount != len(a.Entries) {
+		return 0, ErrInvalidEntryCount
+	}
+
+	prev := a.PrevHash
+	for i := range a.Entries {
+		entry := &a.Entries[i]
+		hash, err := entryHash(entry)
+		if err != nil || entry.Seq != i+1 || entry.PrevHash != prev || entry.Hash != hash {
+			return i + 1, ErrBrokenChain
+		}
+		prev = hash
+	}
+	if a.HeadHash != prev {
+		return len(a.Entries) + 1, ErrBrokenChain
+	}
+
+	key, err := base64.StdEncoding.DecodeString(a.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return 0, ErrInvalidPublicKey
+	}
+	signature, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil || !ed25519.Verify(key, signedHeader(a), signature) {
+		return 0, ErrInvalidSignature
+	}
+	return 0, nil
+}
+
+// entryHash is the hex SHA-256 of the entry's fields, one per line, ending with its data as compact
+// JSON so reformatting the file doesn't change it
+func entryHash(entry *models.ArchiveEntry) (string, error) {
+	var data bytes.Buffer
+	if err := json.Compact(&data, entry.Data); err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, field := range []string{entry.PrevHash, strconv.Itoa(entry.Seq), entry.Kind, entry.NoteID, entry.RecordedAt} {
+		h.Write([]byte(field))
+		h.Write([]byte("\n"))
+	}
+	h.Write(data.Bytes())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// signedHeader is what the signature covers: the archive's identity and its head hash, which
+// covers every entry in turn
+func signedHeader(a *models.Archive) []byte {
+	return []byte(strings.Join([]string{
+		a.Format,
+		a.ExportID,
+		a.UserID,
+		a.CreatedAt,
+		a.PrevHash,
+		strconv.Itoa(a.EntryCount),
+		a.HeadHash,
+	}, "\n"))
+}
@@ -0,0 +1,113 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+// StreamWriter writes an archive as it's built, one entry at a time, instead of holding every entry
+// in memory. The output decodes to the same models.Archive as a sealed one; only the order of its
+// fields differs, since the entry count, head hash and signature can only be written last. An
+// archive whose writing stopped before Close is invalid JSON, so it can't be mistaken for a whole one.
+type StreamWriter struct {
+	w       io.Writer
+	signer  *Signer
+	archive *models.Archive
+	chain   *Chain
+	started bool
+}
+
+// NewStreamWriter starts writing an archive with a's identity and PrevHash; a.Entries is ignored
+func (s *Signer) NewStreamWriter(w io.Writer, a *models.Archive) *StreamWriter {
+	return &StreamWriter{w: w, signer: s, archive: a, chain: NewChain(a.PrevHash)}
+}
+
+// WriteEntry links the entry onto the chain and writes it
+func (sw *StreamWriter) WriteEntry(entry *models.ArchiveEntry) error {
+	if err := sw.chain.Add(entry); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if !sw.started {
+		header, err := sw.header()
+		if err != nil {
+			return err
+		}
+		buf.Write(header)
+		sw.started = true
+	} else {
+		buf.WriteByte(',')
+	}
+	buf.Write(data)
+	_, err = sw.w.Write(buf.Bytes())
+	return err
+}
+
+// Sign finishes the chain and signs the archive without writing anything, so the export can be
+// recorded before the signature is sent
+func (sw *StreamWriter) Sign() *models.Archive {
+	sw.signer.Sign(sw.archive, sw.chain)
+	return sw.archive
+}
+
+// Close writes the end of the archive, including the signature from Sign
+func (sw *StreamWriter) Close() error {
+	var buf bytes.Buffer
+	if !sw.started {
+		header, err := sw.header()
+		if err != nil {
+			return err
+		}
+		buf.Write(header)
+	}
+
+	trailer, err := json.Marshal(struct {
+		EntryCount         int    `json:"entryCount"`
+		HeadHash           string `json:"headHash"`
+		SignatureAlgorithm string `json:"signatureAlgorithm"`
+		PublicKey          string `json:"publicKey"`
+		Signature          string `json:"signature"`
+	}{
+		EntryCount:         sw.archive.EntryCount,
+		HeadHash:           sw.archive.HeadHash,
+		SignatureAlgorithm: sw.archive.SignatureAlgorithm,
+		PublicKey:          sw.archive.PublicKey,
+		Signature:          sw.archive.Signature,
+	})
+	if err != nil {
+		return err
+	}
+	buf.WriteString("],")
+	buf.Write(trailer[1:]) // the trailer's fields continue the archive object
+	_, err = sw.w.Write(buf.Bytes())
+	return err
+}
+
+// header is the start of the archive up to the opening of its entries
+func (sw *StreamWriter) header() ([]byte, error) {
+	header, err := json.Marshal(struct {
+		Format    string `json:"format"`
+		ExportID  string `json:"exportId"`
+		UserID    string `json:"userId"`
+		CreatedAt string `json:"createdAt"`
+		PrevHash  string `json:"prevHash"`
+	}{
+		Format:    models.ArchiveFormat,
+		ExportID:  sw.archive.ExportID,
+		UserID:    sw.archive.UserID,
+		CreatedAt: sw.archive.CreatedAt,
+		PrevHash:  sw.archive.PrevHash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(header[:len(header)-1], `,"entries":[`...), nil
+}
@@ -0,0 +1,312 @@
+// Package audio identifies audio files and reads their duration from container headers.
+// It supports the formats iOS records voice memos in (M4A and CAF) plus WAV, without decoding audio.
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported audio format")
+	ErrMalformed         = errors.New("malformed audio file")
+)
+
+// Format identifies a supported audio container
+type Format string
+
+const (
+	FormatM4A Format = "m4a"
+	FormatWAV Format = "wav"
+	FormatCAF Format = "caf"
+)
+
+// ContentType returns the MIME type served for the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatM4A:
+		return "audio/mp4"
+	case FormatWAV:
+		return "audio/wav"
+	case FormatCAF:
+		return "audio/x-caf"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Info describes an audio file
+type Info struct {
+	Format   Format
+	Duration time.Duration
+}
+
+// Probe detects the format of r and reads its duration.
+// Returns ErrUnsupportedFormat if r isn't a supported audio file.
+func Probe(r io.ReadSeeker) (*Info, error) {
+	var magic [12]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case string(magic[4:8]) == "ftyp":
+		return probeMP4(r)
+	case string(magic[0:4]) == "RIFF" && string(magic[8:12]) == "WAVE":
+		return probeWAV(r)
+	case string(magic[0:4]) == "caff":
+		return probeCAF(r)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// probeMP4 reads the duration from the movie header (moov/mvhd) of an MP4/M4A file
+func probeMP4(r io.ReadSeeker) (*Info, error) {
+	moovSize, err := findMP4Box(r, "moov", -1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := findMP4Box(r, "mvhd", moovSize); err != nil {
+		return nil, err
+	}
+
+	var version [4]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return nil, ErrMalformed
+	}
+
+	var timescale uint32
+	var duration uint64
+	if version[0] == 1 {
+		var header struct {
+			Created, Modified uint64
+			Timescale         uint32
+			Duration          uint64
+		}
+		if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+			return nil, ErrMalformed
+		}
+		timescale, duration = header.Timescale, header.Duration
+	} else {
+		var header struct {
+			Created, Modified uint32
+			Timescale         uint32
+			Duration          uint32
+		}
+		if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+			return nil, ErrMalformed
+		}
+		timescale, duration = header.Timescale, uint64(header.Duration)
+	}
+
+	if timescale == 0 {
+		return nil, ErrMalformed
+	}
+	return &Info{Format: FormatM4A, Duration: scaleDuration(float64(duration) / float64(timescale))}, nil
+}
+
+// findMP4Box advances r to the payload of the first box of the given type within limit bytes
+// (-1 for the rest of the file) and returns the payload size (-1 if it runs to the end of the file)
+func findMP4Box(r io.ReadSeeker, boxType string, limit int64) (int64, error) {
+	for limit < 0 || limit >= 8 {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return 0, ErrMalformed
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+
+		switch size {
+		case 0:
+			// Box runs to the end of the file
+			size = -1
+		case 1:
+			var large uint64
+			if err := binary.Read(r, binary.BigEndian, &large); err != nil {
+				return 0, ErrMalformed
+			}
+			if large > math.MaxInt64 {
+				return 0, ErrMalformed
+			}
+			size = int64(large)
+			headerSize = 16
+		}
+
+		payload := int64(-1)
+		if size >= 0 {
+			if size < headerSize {
+				return 0, ErrMalformed
+			}
+			payload = size - headerSize
+		}
+
+		if string(header[4:8]) == boxType {
+			return payload, nil
+		}
+		if payload < 0 {
+			break
+		}
+		if _, err := r.Seek(payload, io.SeekCurrent); err != nil {
+			return 0, ErrMalformed
+		}
+		if limit >= 0 {
+			limit -= size
+		}
+	}
+	return 0, ErrMalformed
+}
+
+// probeWAV computes the duration of a RIFF/WAVE file from its byte rate and data size
+func probeWAV(r io.ReadSeeker) (*Info, error) {
+	if _, err := r.Seek(12, io.SeekStart); err != nil {
+		return nil, ErrMalformed
+	}
+
+	var byteRate uint32
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, ErrMalformed
+		}
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+		switch string(header[0:4]) {
+		case "fmt ":
+			var format struct {
+				AudioFormat   uint16
+				Channels      uint16
+				SampleRate    uint32
+				ByteRate      uint32
+				BlockAlign    uint16
+				BitsPerSample uint16
+			}
+			if size < 16 {
+				return nil, ErrMalformed
+			}
+			if err := binary.Read(r, binary.LittleEndian, &format); err != nil {
+				return nil, ErrMalformed
+			}
+			byteRate = format.ByteRate
+			size -= 16
+		case "data":
+			if byteRate == 0 {
+				return nil, ErrMalformed
+			}
+			return &Info{Format: FormatWAV, Duration: scaleDuration(float64(size) / float64(byteRate))}, nil
+		}
+
+		// Chunks are padded to an even length
+		if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+			return nil, ErrMalformed
+		}
+	}
+}
+
+// probeCAF computes the duration of a Core Audio Format file from its description and packet table
+func probeCAF(r io.ReadSeeker) (*Info, error) {
+	if _, err := r.Seek(8, io.SeekStart); err != nil {
+		return nil, ErrMalformed
+	}
+
+	var desc struct {
+		SampleRate       float64
+		FormatID         [4]byte
+		FormatFlags      uint32
+		BytesPerPacket   uint32
+		FramesPerPacket  uint32
+		ChannelsPerFrame uint32
+		BitsPerChannel   uint32
+	}
+	var haveDesc bool
+	var validFrames int64 = -1
+	var dataSize int64 = -1
+
+	for {
+		var header [12]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, ErrMalformed
+		}
+		size := int64(binary.BigEndian.Uint64(header[4:12]))
+
+		switch string(header[0:4]) {
+		case "desc":
+			if err := binary.Read(r, binary.BigEndian, &desc); err != nil {
+				return nil, ErrMalformed
+			}
+			haveDesc = true
+			size -= int64(binary.Size(desc))
+		case "pakt":
+			var pakt struct {
+				Packets         int64
+				ValidFrames     int64
+				PrimingFrames   int32
+				RemainderFrames int32
+			}
+			if err := binary.Read(r, binary.BigEndian, &pakt); err != nil {
+				return nil, ErrMalformed
+			}
+			validFrames = pakt.ValidFrames
+			size -= int64(binary.Size(pakt))
+		case "data":
+			if size < 0 {
+				// Size -1 means the data chunk runs to the end of the file
+				pos, err := r.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return nil, ErrMalformed
+				}
+				end, err := r.Seek(0, io.SeekEnd)
+				if err != nil {
+					return nil, ErrMalformed
+				}
+				dataSize = end - pos - 4 // minus the edit count
+			} else {
+				dataSize = size - 4
+			}
+		}
+
+		if string(header[0:4]) == "data" && size < 0 {
+			break
+		}
+		if size < 0 {
+			return nil, ErrMalformed
+		}
+		if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+			return nil, ErrMalformed
+		}
+	}
+
+	if !haveDesc || desc.SampleRate <= 0 {
+		return nil, ErrMalformed
+	}
+
+	var frames float64
+	switch {
+	case validFrames >= 0:
+		frames = float64(validFrames)
+	case dataSize >= 0 && desc.BytesPerPacket > 0:
+		// Constant bit rate (e.g. linear PCM) files have no packet table
+		frames = float64(dataSize/int64(desc.BytesPerPacket)) * float64(desc.FramesPerPacket)
+	default:
+		return nil, ErrMalformed
+	}
+
+	return &Info{Format: FormatCAF, Duration: scaleDuration(frames / desc.SampleRate)}, nil
+}
+
+// scaleDuration converts seconds to a Duration, rounding to the millisecond
+func scaleDuration(seconds float64) time.Duration {
+	if seconds < 0 || math.IsNaN(seconds) || seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return 0
+	}
+	return time.Duration(math.Round(seconds*1000)) * time.Millisecond
+}
@@ -0,0 +1,26 @@
+// Package clientinfo carries what the server knows about the client that made an
+// HTTP request, so services that start a login session can record where it came
+// from without every call taking it as arguments.
+package clientinfo
+
+import "context"
+
+// Info describes the client behind a request
+type Info struct {
+	IP        string
+	UserAgent string
+	DeviceID  string // the X-Device-ID the client registered, if it sent one
+}
+
+type contextKey struct{}
+
+// WithContext returns a copy of ctx carrying the client's info
+func WithContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the client info stored in ctx, or the zero Info
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(contextKey{}).(Info)
+	return info
+}
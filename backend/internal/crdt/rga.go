@@ -0,0 +1,204 @@
+// Package crdt implements a Replicated Growable Array (RGA) for note text, so edits made concurrently
+// on several devices converge to the same content regardless of the order the server receives them.
+//
+// Each character is an element with a unique ID: a Lamport counter plus the ID of the site (device)
+// that created it. Clients must give each new element a counter greater than any counter they have
+// seen in the note, which is what orders concurrent inserts at the same position.
+package crdt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidOp = errors.New("invalid text operation")
+
+// MaxSiteLength limits site IDs, which clients typically set to a device UUID
+const MaxSiteLength = 64
+
+// ID identifies an element. The zero ID is the start of the text.
+type ID struct {
+	Counter int64
+	Site    string
+}
+
+// IsZero reports whether id refers to the start of the text
+func (id ID) IsZero() bool {
+	return id.Counter == 0 && id.Site == ""
+}
+
+// String formats id as "<counter>@<site>", or "" for the zero ID
+func (id ID) String() string {
+	if id.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(id.Counter, 10) + "@" + id.Site
+}
+
+// after orders sibling elements: the higher counter, then the higher site, comes first
+func (id ID) after(other ID) bool {
+	if id.Counter != other.Counter {
+		return id.Counter > other.Counter
+	}
+	return id.Site > other.Site
+}
+
+// ParseID parses "<counter>@<site>"; "" is the zero ID
+func ParseID(s string) (ID, error) {
+	if s == "" {
+		return ID{}, nil
+	}
+	counterStr, site, ok := strings.Cut(s, "@")
+	if !ok || site == "" || len(site) > MaxSiteLength {
+		return ID{}, fmt.Errorf("%w: bad id %q", ErrInvalidOp, s)
+	}
+	counter, err := strconv.ParseInt(counterStr, 10, 64)
+	if err != nil || counter <= 0 {
+		return ID{}, fmt.Errorf("%w: bad id %q", ErrInvalidOp, s)
+	}
+	return ID{Counter: counter, Site: site}, nil
+}
+
+// OpType is the kind of an operation
+type OpType string
+
+const (
+	OpInsert OpType = "insert"
+	OpDelete OpType = "delete"
+)
+
+// Op is one edit. An insert adds the single character Value with the given ID after the element Ref
+// (the zero ID inserts at the start). A delete removes the element Ref; its own ID only identifies the op.
+type Op struct {
+	ID    ID
+	Type  OpType
+	Ref   ID
+	Value string
+}
+
+// Validate checks that an op is well formed
+func (op Op) Validate() error {
+	if op.ID.IsZero() {
+		return fmt.Errorf("%w: missing id", ErrInvalidOp)
+	}
+	switch op.Type {
+	case OpInsert:
+		if utf8.RuneCountInString(op.Value) != 1 || !utf8.ValidString(op.Value) {
+			return fmt.Errorf("%w: insert value must be one character", ErrInvalidOp)
+		}
+	case OpDelete:
+		if op.Ref.IsZero() {
+			return fmt.Errorf("%w: delete needs an element", ErrInvalidOp)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidOp, op.Type)
+	}
+	return nil
+}
+
+type element struct {
+	id      ID
+	value   string
+	deleted bool
+	next    *element
+}
+
+// Text is the state of a note's content built from its ops
+type Text struct {
+	head    element // sentinel before the first character
+	byID    map[ID]*element
+	pending []Op
+}
+
+// NewText returns empty text
+func NewText() *Text {
+	return &Text{byID: make(map[ID]*element)}
+}
+
+// Apply applies an op. Ops are idempotent. An op that refers to an element not yet seen is held back
+// and applied once that element arrives, so ops may be applied in any order.
+func (t *Text) Apply(op Op) error {
+	if err := op.Validate(); err != nil {
+		return err
+	}
+	if !t.apply(op) {
+		t.pending = append(t.pending, op)
+		return nil
+	}
+
+	// Retry held-back ops until none can make progress
+	for progress := true; progress && len(t.pending) > 0; {
+		progress = false
+		remaining := t.pending[:0]
+		for _, p := range t.pending {
+			if t.apply(p) {
+				progress = true
+			} else {
+				remaining = append(remaining, p)
+			}
+		}
+		t.pending = remaining
+	}
+	return nil
+}
+
+// apply returns false if the op's reference hasn't been seen yet
+func (t *Text) apply(op Op) bool {
+	switch op.Type {
+	case OpInsert:
+		if _, exists := t.byID[op.ID]; exists {
+			return true
+		}
+		ref := &t.head
+		if !op.Ref.IsZero() {
+			var ok bool
+			if ref, ok = t.byID[op.Ref]; !ok {
+				return false
+			}
+		}
+		// Skip past elements inserted concurrently after ref that take precedence
+		for ref.next != nil && ref.next.id.after(op.ID) {
+			ref = ref.next
+		}
+		e := &element{id: op.ID, value: op.Value, next: ref.next}
+		ref.next = e
+		t.byID[op.ID] = e
+	case OpDelete:
+		e, ok := t.byID[op.Ref]
+		if !ok {
+			return false
+		}
+		e.deleted = true
+	}
+	return true
+}
+
+// Pending returns how many ops are waiting for an element they refer to
+func (t *Text) Pending() int {
+	return len(t.pending)
+}
+
+// String returns the visible text
+func (t *Text) String() string {
+	var b strings.Builder
+	for e := t.head.next; e != nil; e = e.next {
+		if !e.deleted {
+			b.WriteString(e.value)
+		}
+	}
+	return b.String()
+}
+
+// Len returns the length of the visible text in bytes
+func (t *Text) Len() int {
+	n := 0
+	for e := t.head.next; e != nil; e = e.next {
+		if !e.deleted {
+			n += len(e.value)
+		}
+	}
+	return n
+}
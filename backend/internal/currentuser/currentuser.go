@@ -0,0 +1,27 @@
+// Package currentuser carries the authenticated user through a request's context, so the database
+// layer can scope its connections to them when row-level security is enabled.
+package currentuser
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type contextKey struct{}
+
+// WithContext returns a copy of ctx carrying the user ID
+func WithContext(ctx context.Context, userID uuid.UUID) context.Context {
+	return context.WithValue(ctx, contextKey{}, userID)
+}
+
+// FromContext returns the user ID stored in ctx, if any
+func FromContext(ctx context.Context) (uuid.UUID, bool) {
+	userID, ok := ctx.Value(contextKey{}).(uuid.UUID)
+	return userID, ok
+}
+
+// Without returns a copy of ctx carrying no user, for work an admin does across every user's data
+func Without(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, nil)
+}
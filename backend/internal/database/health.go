@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// PoolStats is a snapshot of the connection pool
+type PoolStats struct {
+	// Connections now in use, idle, being opened, and in total, and the most the pool will open
+	Acquired     int32
+	Idle         int32
+	Constructing int32
+	Total        int32
+	Max          int32
+
+	// Totals since the server started: connections handed out, how many of those had to wait for
+	// one to free up or be opened, and for how long altogether, and waits given up on
+	Acquires         int64
+	EmptyAcquires    int64
+	AcquireWait      time.Duration
+	CanceledAcquires int64
+
+	// Connections opened, and closed for being too old or idle too long
+	NewConns           int64
+	MaxLifetimeDestroy int64
+	MaxIdleDestroy     int64
+}
+
+// Stats returns a snapshot of the connection pool
+func (db *DB) Stats() PoolStats {
+	s := db.Pool.Stat()
+	return PoolStats{
+		Acquired:     s.AcquiredConns(),
+		Idle:         s.IdleConns(),
+		Constructing: s.ConstructingConns(),
+		Total:        s.TotalConns(),
+		Max:          s.MaxConns(),
+
+		Acquires:         s.AcquireCount(),
+		EmptyAcquires:    s.EmptyAcquireCount(),
+		AcquireWait:      s.AcquireDuration(),
+		CanceledAcquires: s.CanceledAcquireCount(),
+
+		NewConns:           s.NewConnsCount(),
+		MaxLifetimeDestroy: s.MaxLifetimeDestroyCount(),
+		MaxIdleDestroy:     s.MaxIdleDestroyCount(),
+	}
+}
+
+// Ping checks the database answers, returning how long the round trip took
+func (db *DB) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	err := db.Pool.Ping(ctx)
+	return time.Since(start), err
+}
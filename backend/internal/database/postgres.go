@@ -3,18 +3,37 @@ package database
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type DB struct {
 	Pool *pgxpool.Pool
+
+	rowLevelSecurity bool
 }
 
-func New(databaseURL string) (*DB, error) {
-	config, err := pgxpool.ParseConfig(databaseURL)
+// Options configures the connection pool. Zero values leave pgx's defaults, or the pool_* settings
+// in the database URL, in place.
+type Options struct {
+	// Scope connections to the user in the context they're acquired with (see rls.go)
+	RowLevelSecurity bool
+
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+
+	// How long to wait for a new connection to be established
+	ConnectTimeout time.Duration
+}
+
+// New connects to the database
+func New(databaseURL string, opts Options) (*DB, error) {
+	config, err := poolConfig(databaseURL, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse database URL: %w", err)
+		return nil, err
 	}
 
 	pool, err := pgxpool.NewWithConfig(context.Background(), config)
@@ -27,7 +46,35 @@ func New(databaseURL string) (*DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &DB{Pool: pool}, nil
+	return &DB{Pool: pool, rowLevelSecurity: opts.RowLevelSecurity}, nil
+}
+
+// poolConfig parses a database URL and applies opts to it
+func poolConfig(databaseURL string, opts Options) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse database URL: %w", err)
+	}
+	if opts.RowLevelSecurity {
+		config.PrepareConn = setCurrentUser
+	}
+	config.ConnConfig.Tracer = queryTracer{host: config.ConnConfig.Host}
+	if opts.MaxConns > 0 {
+		config.MaxConns = opts.MaxConns
+	}
+	if opts.MinConns > 0 {
+		config.MinConns = min(opts.MinConns, config.MaxConns)
+	}
+	if opts.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = opts.MaxConnIdleTime
+	}
+	if opts.ConnectTimeout > 0 {
+		config.ConnConfig.ConnectTimeout = opts.ConnectTimeout
+	}
+	return config, nil
 }
 
 func (db *DB) Close() {
@@ -88,13 +135,474 @@ func (db *DB) RunMigrations(ctx context.Context) error {
 
 		`CREATE INDEX IF NOT EXISTS idx_token_blacklist_user_id ON token_blacklist(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_token_blacklist_expires_at ON token_blacklist(expires_at)`,
+
+		// Free-form metadata for integrations (e.g. imported source IDs)
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'::jsonb`,
+
+		// Code snippet notes
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS language VARCHAR(50) NOT NULL DEFAULT ''`,
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS is_monospace BOOLEAN NOT NULL DEFAULT FALSE`,
+		// Databases created from 001_initial_schema.sql restrict note_type; the API validates it instead
+		`ALTER TABLE notes DROP CONSTRAINT IF EXISTS notes_note_type_check`,
+
+		// Note sharing: collaborators and pending email invitations
+		`CREATE TABLE IF NOT EXISTS note_shares (
+			note_id UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			granted_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (note_id, user_id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_note_shares_user_id ON note_shares(user_id)`,
+
+		`CREATE TABLE IF NOT EXISTS note_invites (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			note_id UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			invited_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(254) NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			accepted_at TIMESTAMP WITH TIME ZONE,
+			accepted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			sent_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_note_invites_note_id ON note_invites(note_id)`,
+
+		// Unfurled metadata for URLs found in note content
+		`CREATE TABLE IF NOT EXISTS link_previews (
+			note_id UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			image_url TEXT NOT NULL DEFAULT '',
+			fetched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (note_id, url)
+		)`,
+
+		// @username mentions of collaborators in shared notes
+		`CREATE TABLE IF NOT EXISTS note_mentions (
+			note_id UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			mentioned_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (note_id, user_id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS notifications (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			type VARCHAR(20) NOT NULL,
+			note_id UUID REFERENCES notes(id) ON DELETE CASCADE,
+			actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			read_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_notifications_user_created ON notifications(user_id, created_at DESC)`,
+
+		// Audio attachments; file contents live in the attachment store
+		`CREATE TABLE IF NOT EXISTS attachments (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			note_id UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			filename VARCHAR(255) NOT NULL DEFAULT '',
+			content_type VARCHAR(100) NOT NULL,
+			format VARCHAR(20) NOT NULL,
+			size_bytes BIGINT NOT NULL,
+			duration_ms BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_attachments_note_id ON attachments(note_id)`,
+
+		// Manual note order per folder/tag view; the main list keeps using notes.sort_order
+		`CREATE TABLE IF NOT EXISTS note_orderings (
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			context VARCHAR(100) NOT NULL,
+			note_id UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (user_id, context, note_id)
+		)`,
+
+		// Recent snapshots of each note, used as the common ancestor when merging sync conflicts
+		`CREATE TABLE IF NOT EXISTS note_revisions (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			note_id UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			snapshot JSONB NOT NULL,
+			recorded_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_note_revisions_note_recorded ON note_revisions(note_id, recorded_at DESC)`,
+
+		// Hash of each revision's content, so clients can send a delta against a version they have
+		`ALTER TABLE note_revisions ADD COLUMN IF NOT EXISTS content_hash CHAR(64)`,
+		`CREATE INDEX IF NOT EXISTS idx_note_revisions_content_hash ON note_revisions(note_id, content_hash)`,
+
+		// Cold storage for notes archived long ago; kept out of the notes table so sync queries stay fast
+		`CREATE TABLE IF NOT EXISTS cold_notes (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			snapshot JSONB NOT NULL,
+			archived_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			moved_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_cold_notes_user_archived ON cold_notes(user_id, archived_at DESC)`,
+
+		// Text operations for notes edited in CRDT mode; notes.content holds the resulting text
+		`CREATE TABLE IF NOT EXISTS note_ops (
+			seq BIGSERIAL PRIMARY KEY,
+			note_id UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			site_id VARCHAR(64) NOT NULL,
+			counter BIGINT NOT NULL,
+			op_type VARCHAR(10) NOT NULL,
+			ref VARCHAR(100) NOT NULL DEFAULT '',
+			value TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE (note_id, site_id, counter)
+		)`,
+
+		// Applied sync requests and the state of each note before them, so a batch can be reverted
+		`CREATE TABLE IF NOT EXISTS sync_batches (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			request_id VARCHAR(100) NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			reverted_at TIMESTAMP WITH TIME ZONE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_sync_batches_user_created ON sync_batches(user_id, created_at DESC)`,
+
+		`CREATE TABLE IF NOT EXISTS sync_batch_notes (
+			batch_id UUID NOT NULL REFERENCES sync_batches(id) ON DELETE CASCADE,
+			note_id UUID NOT NULL,
+			pre_image JSONB,
+			PRIMARY KEY (batch_id, note_id)
+		)`,
+
+		// Responses to requests sent with an Idempotency-Key, replayed when the request is retried.
+		// status_code is NULL while the first request is still running.
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			key VARCHAR(255) NOT NULL,
+			route VARCHAR(100) NOT NULL,
+			request_hash CHAR(64) NOT NULL,
+			status_code INT,
+			response BYTEA,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (user_id, key)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys(created_at)`,
+
+		// Stored responses may be JSON or MessagePack, so replays need their content type
+		`ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS content_type VARCHAR(100) NOT NULL DEFAULT 'application/json; charset=utf-8'`,
+
+		// Devices registered by a user's clients, with the cursor each last synced to
+		`CREATE TABLE IF NOT EXISTS devices (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			device_id VARCHAR(100) NOT NULL,
+			name VARCHAR(100) NOT NULL DEFAULT '',
+			platform VARCHAR(20) NOT NULL DEFAULT 'other',
+			last_sync_at TIMESTAMP WITH TIME ZONE,
+			last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			UNIQUE (user_id, device_id)
+		)`,
+
+		// Users who opted in to the monthly activity summary email. last_period is the first day of
+		// the last month a summary was sent for.
+		`CREATE TABLE IF NOT EXISTS activity_summary_subscriptions (
+			user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(254) NOT NULL,
+			last_period DATE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		// A random ID for this instance, so telemetry reports can be counted without identifying anyone
+		`CREATE TABLE IF NOT EXISTS instance_info (
+			singleton BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (singleton),
+			id UUID NOT NULL DEFAULT uuid_generate_v4(),
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		// Hybrid logical clock of each note's last edit; empty for notes last saved without one
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS hlc VARCHAR(128) NOT NULL DEFAULT ''`,
+
+		// Record of each signed archive export. Exports chain onto the previous one by hash, so each
+		// previous hash is used once per user.
+		`CREATE TABLE IF NOT EXISTS archive_exports (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			prev_hash CHAR(64) NOT NULL,
+			head_hash CHAR(64) NOT NULL,
+			entry_count INTEGER NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE (user_id, prev_hash)
+		)`,
+
+		// Where each user last was in a note, so other devices can resume there
+		`CREATE TABLE IF NOT EXISTS note_positions (
+			note_id UUID NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			caret INTEGER NOT NULL DEFAULT 0,
+			scroll DOUBLE PRECISION NOT NULL DEFAULT 0,
+			device_id VARCHAR(100) NOT NULL DEFAULT '',
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (note_id, user_id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_note_positions_user_updated ON note_positions(user_id, updated_at)`,
+
+		// Issued refresh tokens, each usable once. A login starts a family; reusing a token that was
+		// already rotated revokes the whole family.
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id UUID PRIMARY KEY,
+			family_id UUID NOT NULL,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires ON refresh_tokens(expires_at)`,
+
+		// Email addresses, so the service can contact users. An address only counts once verified.
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR(254)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(LOWER(email)) WHERE email IS NOT NULL`,
+
+		// Single-use email verification tokens; only a hash of each token is stored
+		`CREATE TABLE IF NOT EXISTS email_verification_tokens (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(254) NOT NULL,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user ON email_verification_tokens(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_expires ON email_verification_tokens(expires_at)`,
+
+		// Per-user settings. notification_preferences maps each category to its channels; categories
+		// not listed use the defaults.
+		`CREATE TABLE IF NOT EXISTS user_settings (
+			user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			notification_preferences JSONB NOT NULL DEFAULT '{}',
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		// Batch exports of notes, produced in the background; the files live in the export store
+		`CREATE TABLE IF NOT EXISTS export_jobs (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			status VARCHAR(20) NOT NULL,
+			format VARCHAR(20) NOT NULL,
+			filter JSONB NOT NULL DEFAULT '{}',
+			paper VARCHAR(20) NOT NULL DEFAULT '',
+			include_metadata BOOLEAN NOT NULL DEFAULT FALSE,
+			filename VARCHAR(255) NOT NULL DEFAULT '',
+			size_bytes BIGINT NOT NULL DEFAULT 0,
+			note_count INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			completed_at TIMESTAMP WITH TIME ZONE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_export_jobs_user_created ON export_jobs(user_id, created_at DESC)`,
+
+		// Administrators can use the admin API
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE`,
+
+		// Results of the referential integrity check, for the admin API
+		`CREATE TABLE IF NOT EXISTS integrity_reports (
+			id UUID PRIMARY KEY,
+			repair BOOLEAN NOT NULL DEFAULT FALSE,
+			counts JSONB NOT NULL DEFAULT '{}',
+			findings JSONB NOT NULL DEFAULT '[]',
+			started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			finished_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_integrity_reports_started ON integrity_reports(started_at DESC)`,
+
+		// Passkeys (WebAuthn credentials) users can sign in with instead of a password
+		`CREATE TABLE IF NOT EXISTS webauthn_credentials (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			credential_id BYTEA NOT NULL UNIQUE,
+			public_key BYTEA NOT NULL,
+			sign_count BIGINT NOT NULL DEFAULT 0,
+			aaguid BYTEA NOT NULL DEFAULT '',
+			transports TEXT[] NOT NULL DEFAULT '{}',
+			backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+			name VARCHAR(100) NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			last_used_at TIMESTAMP WITH TIME ZONE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user ON webauthn_credentials(user_id)`,
+
+		// Challenges issued to start passkey registration or sign-in, each usable once. Sign-in
+		// challenges may have no user, since the passkey identifies them.
+		`CREATE TABLE IF NOT EXISTS webauthn_sessions (
+			id UUID PRIMARY KEY,
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			ceremony VARCHAR(20) NOT NULL,
+			challenge BYTEA NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_webauthn_sessions_expires ON webauthn_sessions(expires_at)`,
+
+		// Accounts at sign-in providers (Apple, Google) linked to users
+		`CREATE TABLE IF NOT EXISTS user_identities (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider VARCHAR(20) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			email VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			last_used_at TIMESTAMP WITH TIME ZONE,
+			UNIQUE(provider, subject)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id)`,
+
+		// Sign-ins with a provider in progress, looked up by the hash of the state parameter
+		`CREATE TABLE IF NOT EXISTS oauth_states (
+			state_hash CHAR(64) PRIMARY KEY,
+			provider VARCHAR(20) NOT NULL,
+			nonce VARCHAR(64) NOT NULL,
+			code_verifier VARCHAR(64) NOT NULL DEFAULT '',
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			return_to TEXT NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_oauth_states_expires ON oauth_states(expires_at)`,
+
+		// Single-use codes the app exchanges for tokens after a provider sign-in, so tokens never
+		// appear in redirect URLs
+		`CREATE TABLE IF NOT EXISTS oauth_login_codes (
+			code_hash CHAR(64) PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider VARCHAR(20) NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)`,
+
+		// Instance-wide settings, chosen when the first administrator sets the server up. The setup
+		// token is stored hashed until setup completes.
+		`ALTER TABLE instance_info ADD COLUMN IF NOT EXISTS name VARCHAR(100) NOT NULL DEFAULT 'Notes'`,
+		`ALTER TABLE instance_info ADD COLUMN IF NOT EXISTS registration_open BOOLEAN NOT NULL DEFAULT TRUE`,
+		`ALTER TABLE instance_info ADD COLUMN IF NOT EXISTS setup_token_hash CHAR(64)`,
+		`ALTER TABLE instance_info ADD COLUMN IF NOT EXISTS setup_completed_at TIMESTAMP WITH TIME ZONE`,
+
+		// Login sessions: one per refresh token family, with where it signed in from. Access and
+		// refresh tokens carry the session ID, so revoking a session signs out just that device.
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			user_agent VARCHAR(512) NOT NULL DEFAULT '',
+			device_id VARCHAR(100) NOT NULL DEFAULT '',
+			ip_address VARCHAR(45) NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			revoked_at TIMESTAMP WITH TIME ZONE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_last_seen ON sessions(last_seen_at)`,
+
+		// Failed password logins per username, so lockouts survive restarts and apply whichever
+		// address the attempts come from. Unknown usernames are counted too, so lockouts don't
+		// reveal which accounts exist.
+		`CREATE TABLE IF NOT EXISTS login_attempts (
+			username VARCHAR(255) PRIMARY KEY,
+			failed_attempts INTEGER NOT NULL DEFAULT 0,
+			last_failed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			locked_until TIMESTAMP WITH TIME ZONE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_last_failed ON login_attempts(last_failed_at)`,
+
+		// The algorithm each password hash was made with. Existing hashes are bcrypt; new ones are
+		// Argon2id, and bcrypt hashes are replaced as their owners log in.
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_algorithm VARCHAR(20) NOT NULL DEFAULT 'bcrypt'`,
+
+		// Scoped tokens, such as read-only ones for a display, are sessions too, so they're listed and
+		// revoked the same way. They have no refresh token and last until expires_at.
+		`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS scope VARCHAR(50) NOT NULL DEFAULT ''`,
+		`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS name VARCHAR(100) NOT NULL DEFAULT ''`,
+		`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE`,
+
+		// Single-use links emailed for signing in without a password; only a hash of each token is stored
+		`CREATE TABLE IF NOT EXISTS magic_link_tokens (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			email VARCHAR(254) NOT NULL,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			requested_ip VARCHAR(45) NOT NULL DEFAULT '',
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_magic_link_tokens_user ON magic_link_tokens(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_magic_link_tokens_expires ON magic_link_tokens(expires_at)`,
+
+		// Read-only accounts, such as a shared demo account, can't change anything. Demo accounts
+		// created for one visitor are deleted some time after demo_expires_at.
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS read_only BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS demo_expires_at TIMESTAMP WITH TIME ZONE`,
+		`CREATE INDEX IF NOT EXISTS idx_users_demo_expires ON users(demo_expires_at) WHERE demo_expires_at IS NOT NULL`,
+
+		// Incremented on every update, so a REST client saving a note it loaded at an older version
+		// gets a conflict instead of overwriting the change in between
+		`ALTER TABLE notes ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+
+		// Trigrams of note titles, for fuzzy search that tolerates typos and partial words
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_title_trgm ON notes USING GIN (title gin_trgm_ops)`,
+
+		// Deleted notes are kept as tombstones for sync, and archived ones until they move to cold
+		// storage, so large accounts pile up rows most queries skip. Live notes, tombstones and
+		// archived notes get their own partial indexes, which stay small and only hold what each
+		// query reads. These replace indexes over every note.
+		`CREATE INDEX IF NOT EXISTS idx_notes_live_user_sort ON notes(user_id, sort_order) WHERE deleted_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_live_user_updated ON notes(user_id, updated_at, id) WHERE deleted_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_deleted_user ON notes(user_id, deleted_at) WHERE deleted_at IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_archived_updated ON notes(updated_at) WHERE is_archived = true AND deleted_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_notes_live_title_trgm ON notes USING GIN (title gin_trgm_ops) WHERE deleted_at IS NULL`,
+		`DROP INDEX IF EXISTS idx_notes_user_updated`,
+		`DROP INDEX IF EXISTS idx_notes_updated_at`,
+		`DROP INDEX IF EXISTS idx_notes_title_trgm`,
+
+		// Maintenance mode, kept with the instance so every server instance follows it
+		`ALTER TABLE instance_info ADD COLUMN IF NOT EXISTS maintenance_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE instance_info ADD COLUMN IF NOT EXISTS maintenance_message VARCHAR(500) NOT NULL DEFAULT ''`,
+		`ALTER TABLE instance_info ADD COLUMN IF NOT EXISTS maintenance_until TIMESTAMP WITH TIME ZONE`,
 	}
 
+	migrations = append(migrations, rlsMigrations()...)
+
 	for _, migration := range migrations {
 		if _, err := db.Pool.Exec(ctx, migration); err != nil {
 			return fmt.Errorf("failed to run migration: %w", err)
 		}
 	}
 
-	return nil
+	return db.applyRowLevelSecurity(ctx)
 }
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// replicaCheckInterval is how long a replica's health is trusted before it's checked again
+const replicaCheckInterval = 5 * time.Second
+
+// replicaCheckTimeout bounds the query that checks the replica, which runs during a request
+const replicaCheckTimeout = time.Second
+
+// replicaLagQuery returns how far the replica's replay trails the primary, in seconds. A replica
+// that has replayed everything it received is caught up however long ago the last write was, and a
+// server that isn't a standby has no lag.
+const replicaLagQuery = `
+	SELECT CASE
+		WHEN NOT pg_is_in_recovery() THEN 0
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END
+`
+
+// Replica is a read replica that reads may be sent to while it answers and is no more than MaxLag
+// behind the primary. Otherwise reads go to the primary until the next check finds it usable again.
+type Replica struct {
+	*DB
+
+	maxLag time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	usable    bool
+}
+
+// NewReplica opens a pool on a read replica. The replica doesn't have to be reachable yet: until it
+// is, reads go to the primary.
+func NewReplica(databaseURL string, opts Options, maxLag time.Duration) (*Replica, error) {
+	config, err := poolConfig(databaseURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create replica connection pool: %w", err)
+	}
+
+	replica := &Replica{DB: &DB{Pool: pool, rowLevelSecurity: opts.RowLevelSecurity}, maxLag: maxLag}
+	if err := pool.Ping(context.Background()); err != nil {
+		slog.Warn("Read replica unavailable, reading from the primary until it is", "error", err)
+		replica.checkedAt = time.Now()
+	}
+	return replica, nil
+}
+
+// Usable reports whether reads may go to the replica, checking its lag if the last check is stale
+func (r *Replica) Usable(ctx context.Context) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) < replicaCheckInterval {
+		return r.usable
+	}
+	r.checkedAt = time.Now()
+
+	checkCtx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+	defer cancel()
+
+	var lagSeconds float64
+	if err := r.Pool.QueryRow(checkCtx, replicaLagQuery).Scan(&lagSeconds); err != nil {
+		r.setUsable(false, "it can't be reached: "+err.Error())
+		return false
+	}
+	lag := time.Duration(lagSeconds * float64(time.Second))
+	if r.maxLag > 0 && lag > r.maxLag {
+		r.setUsable(false, "it is "+lag.Round(time.Millisecond).String()+" behind")
+		return false
+	}
+	r.setUsable(true, "")
+	return true
+}
+
+// MaxStaleness returns how far behind the primary a read from the replica can be, or 0 if there's no
+// limit. Its lag was at most the maximum when last checked, and can't have grown by more than the
+// time since.
+func (r *Replica) MaxStaleness() time.Duration {
+	if r.maxLag <= 0 {
+		return 0
+	}
+	return r.maxLag + replicaCheckInterval
+}
+
+// Failed stops reads going to the replica after one failed there, until the next check
+func (r *Replica) Failed(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checkedAt = time.Now()
+	r.setUsable(false, "a read failed: "+err.Error())
+}
+
+// setUsable records the replica's state, logging when it changes. r.mu must be held.
+func (r *Replica) setUsable(usable bool, reason string) {
+	if usable == r.usable {
+		return
+	}
+	r.usable = usable
+	if usable {
+		slog.Info("Read replica available, sending reads to it")
+	} else {
+		slog.Warn("Reading from the primary instead of the read replica", "reason", reason)
+	}
+}
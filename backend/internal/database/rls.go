@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/currentuser"
+	"github.com/jackc/pgx/v5"
+)
+
+// Row-level security is a second line of defense against queries that forget a user_id predicate.
+// When enabled, every connection taken from the pool for a request records the authenticated user in
+// app.current_user_id, and policies hide other users' rows from it. Work done without a user (logins,
+// background jobs) leaves the setting empty and is not restricted.
+
+// setCurrentUser scopes a connection to the user in ctx, or clears the scope if there is none
+func setCurrentUser(ctx context.Context, conn *pgx.Conn) (bool, error) {
+	userID := ""
+	if id, ok := currentuser.FromContext(ctx); ok {
+		userID = id.String()
+	}
+	if _, err := conn.Exec(ctx, `SELECT set_config('app.current_user_id', $1, false)`, userID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// userPolicy hides rows owned by other users
+const userPolicy = `app_current_user_id() IS NULL OR user_id = app_current_user_id()`
+
+// notePolicy hides rows belonging to notes the user can't see
+const notePolicy = `EXISTS (SELECT 1 FROM notes WHERE notes.id = note_id)`
+
+// rlsPolicies maps each protected table to the rows the current user may use
+var rlsPolicies = []struct {
+	table     string
+	using     string
+	withCheck string // defaults to using
+}{
+	// Owners and collaborators can use a note
+	{table: "notes", using: `app_current_user_id() IS NULL OR user_id = app_current_user_id() OR EXISTS (
+		SELECT 1 FROM note_shares s WHERE s.note_id = notes.id AND s.user_id = app_current_user_id())`},
+	{table: "checklist_items", using: notePolicy},
+	{table: "note_revisions", using: notePolicy},
+	{table: "note_ops", using: notePolicy},
+	{table: "attachments", using: notePolicy},
+	{table: "note_orderings", using: userPolicy},
+	{table: "cold_notes", using: userPolicy},
+	{table: "sync_batches", using: userPolicy},
+	{table: "sync_batch_notes", using: `EXISTS (SELECT 1 FROM sync_batches b WHERE b.id = batch_id)`},
+	{table: "devices", using: userPolicy},
+	{table: "idempotency_keys", using: userPolicy},
+	{table: "activity_summary_subscriptions", using: userPolicy},
+	{table: "archive_exports", using: userPolicy},
+	{table: "note_positions", using: userPolicy},
+	{table: "refresh_tokens", using: userPolicy},
+	{table: "email_verification_tokens", using: userPolicy},
+	{table: "user_settings", using: userPolicy},
+	{table: "export_jobs", using: userPolicy},
+	{table: "webauthn_credentials", using: userPolicy},
+	{table: "user_identities", using: userPolicy},
+	{table: "sessions", using: userPolicy},
+	// Actions notify other users, so anyone can create a notification but only read their own
+	{table: "notifications", using: userPolicy, withCheck: "TRUE"},
+}
+
+// rlsMigrations creates the policies. They only take effect on tables with row-level security enabled.
+func rlsMigrations() []string {
+	migrations := []string{
+		`CREATE OR REPLACE FUNCTION app_current_user_id() RETURNS UUID AS $$
+			SELECT NULLIF(current_setting('app.current_user_id', TRUE), '')::UUID
+		$$ LANGUAGE SQL STABLE`,
+	}
+	for _, p := range rlsPolicies {
+		withCheck := p.withCheck
+		if withCheck == "" {
+			withCheck = p.using
+		}
+		migrations = append(migrations,
+			fmt.Sprintf(`DROP POLICY IF EXISTS user_isolation ON %s`, p.table),
+			fmt.Sprintf(`CREATE POLICY user_isolation ON %s USING (%s) WITH CHECK (%s)`, p.table, p.using, withCheck),
+		)
+	}
+	return migrations
+}
+
+// applyRowLevelSecurity turns row-level security on or off for the protected tables. FORCE applies it
+// to the tables' owner, which is usually the role the server connects as.
+func (db *DB) applyRowLevelSecurity(ctx context.Context) error {
+	for _, p := range rlsPolicies {
+		statement := fmt.Sprintf(`ALTER TABLE %s ENABLE ROW LEVEL SECURITY, FORCE ROW LEVEL SECURITY`, p.table)
+		if !db.rowLevelSecurity {
+			statement = fmt.Sprintf(`ALTER TABLE %s DISABLE ROW LEVEL SECURITY, NO FORCE ROW LEVEL SECURITY`, p.table)
+		}
+		if _, err := db.Pool.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("failed to configure row-level security on %s: %w", p.table, err)
+		}
+	}
+
+	if db.rowLevelSecurity {
+		// Superusers and BYPASSRLS roles ignore policies, even forced ones
+		var bypasses bool
+		err := db.Pool.QueryRow(ctx, `SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user`).Scan(&bypasses)
+		if err != nil {
+			return err
+		}
+		if bypasses {
+			slog.WarnContext(ctx, "DB_ROW_LEVEL_SECURITY is enabled but the database role bypasses row-level security; connect as a non-superuser role for it to take effect")
+		}
+	}
+	return nil
+}
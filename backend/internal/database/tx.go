@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TxBeginner starts transactions: a pool, a repository, or a transaction, in which Begin starts a
+// savepoint
+type TxBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// WithTx runs fn in a transaction begun on db, committing it if fn returns nil and rolling it back
+// otherwise, or if fn panics. Repositories bound to tx with their WithTx methods make one unit of
+// work of calls across several of them.
+func WithTx(ctx context.Context, db TxBeginner, fn func(tx pgx.Tx) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rolling back after a commit does nothing
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
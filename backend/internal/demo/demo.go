@@ -0,0 +1,195 @@
+// Package demo runs the accounts visitors can try the app with. There is a shared
+// demo account, signed into with a well-known password, whose notes are put back
+// to the samples at startup and on a timer, and which can be made read-only so
+// visitors can't change what others see. Visitors can also be given a demo account
+// of their own, which is deleted when it expires.
+package demo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/passwordhash"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+)
+
+var (
+	ErrDisabled        = errors.New("demo accounts are disabled")
+	ErrTooManyAccounts = errors.New("too many demo accounts in use")
+)
+
+// Config describes the demo accounts
+type Config struct {
+	Username      string        // shared account; empty = none
+	Password      string        // shared account's password
+	ReadOnly      bool          // the shared account can't change anything
+	ResetInterval time.Duration // how often the shared account is put back to the samples; 0 = only at startup
+	Notes         []Note        // samples every demo account starts with
+
+	MaxAccounts int           // visitors' own accounts that may exist at once; 0 = visitors don't get their own
+	AccountTTL  time.Duration // how long a visitor's own account works
+}
+
+// Service seeds, resets and hands out demo accounts
+type Service struct {
+	userRepo    *repository.UserRepository
+	noteRepo    *repository.NoteRepository
+	hasher      *passwordhash.Hasher
+	authService *services.AuthService
+	config      Config
+}
+
+func New(userRepo *repository.UserRepository, noteRepo *repository.NoteRepository, hasher *passwordhash.Hasher, authService *services.AuthService, config Config) *Service {
+	return &Service{
+		userRepo:    userRepo,
+		noteRepo:    noteRepo,
+		hasher:      hasher,
+		authService: authService,
+		config:      config,
+	}
+}
+
+// Reset creates the shared account with the sample notes. If it already exists its password, notes
+// and read-only setting are put back, undoing whatever visitors did to it.
+func (s *Service) Reset(ctx context.Context) error {
+	if s.config.Username == "" {
+		return nil
+	}
+	hashedPassword, err := s.hasher.Hash(s.config.Password)
+	if err != nil {
+		return err
+	}
+
+	existingUser, err := s.userRepo.GetByUsername(ctx, s.config.Username)
+	if err == nil {
+		if err := s.userRepo.UpdatePassword(ctx, existingUser.ID, hashedPassword, passwordhash.Argon2id); err != nil {
+			return err
+		}
+		if err := s.userRepo.SetReadOnly(ctx, existingUser.ID, s.config.ReadOnly); err != nil {
+			return err
+		}
+		if err := s.noteRepo.HardDeleteAllByUserID(ctx, existingUser.ID); err != nil {
+			return err
+		}
+		createNotes(ctx, s.noteRepo, existingUser.ID, s.config.Notes)
+		slog.InfoContext(ctx, "Reset demo account", "username", s.config.Username)
+		return nil
+	}
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		return err
+	}
+
+	now := time.Now()
+	user := &models.User{
+		ID:                uuid.New(),
+		Username:          s.config.Username,
+		PasswordHash:      hashedPassword,
+		PasswordAlgorithm: passwordhash.Argon2id,
+		ReadOnly:          s.config.ReadOnly,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		return err
+	}
+	createNotes(ctx, s.noteRepo, user.ID, s.config.Notes)
+	slog.InfoContext(ctx, "Created demo account", "username", s.config.Username)
+	return nil
+}
+
+// CreateAccount gives a visitor a demo account of their own, seeded with the sample notes, and logs
+// them into it. It can't be logged into any other way, and stops working after AccountTTL.
+func (s *Service) CreateAccount(ctx context.Context, clientIP string) (*models.User, *services.TokenPair, error) {
+	if s.config.MaxAccounts <= 0 {
+		return nil, nil, ErrDisabled
+	}
+	count, err := s.userRepo.CountDemos(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if count >= s.config.MaxAccounts {
+		slog.WarnContext(ctx, "Demo account refused - all in use", "ip", clientIP, "in_use", count, "max", s.config.MaxAccounts)
+		return nil, nil, ErrTooManyAccounts
+	}
+
+	// Nobody knows the password; the visitor only ever has the tokens
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, nil, err
+	}
+	hashedPassword, err := s.hasher.Hash(base64.RawURLEncoding.EncodeToString(raw))
+	if err != nil {
+		return nil, nil, err
+	}
+	suffix := make([]byte, 5)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.config.AccountTTL)
+	user := &models.User{
+		ID:                uuid.New(),
+		Username:          "demo" + hex.EncodeToString(suffix),
+		PasswordHash:      hashedPassword,
+		PasswordAlgorithm: passwordhash.Argon2id,
+		DemoExpiresAt:     &expiresAt,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		return nil, nil, err
+	}
+	createNotes(ctx, s.noteRepo, user.ID, s.config.Notes)
+
+	tokens, err := s.authService.LoginVerified(ctx, user, "demo", clientIP)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, tokens, nil
+}
+
+// CleanupExpired deletes visitors' demo accounts that expired at least grace ago, leaving their last
+// access tokens time to run out first
+func (s *Service) CleanupExpired(ctx context.Context, grace time.Duration) (int64, error) {
+	return s.userRepo.DeleteDemosExpiredBefore(ctx, time.Now().Add(-grace))
+}
+
+// Lock gives a shared demo account left over from when it was enabled a random password, if it still
+// has the public default one. Its notes are kept.
+func Lock(ctx context.Context, userRepo *repository.UserRepository, hasher *passwordhash.Hasher, username, defaultPassword string) error {
+	user, err := userRepo.GetByUsername(ctx, username)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !hasher.Verify(defaultPassword, user.PasswordHash, user.PasswordAlgorithm) {
+		return nil
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	hashedPassword, err := hasher.Hash(base64.RawURLEncoding.EncodeToString(raw))
+	if err != nil {
+		return err
+	}
+	if err := userRepo.UpdatePassword(ctx, user.ID, hashedPassword, passwordhash.Argon2id); err != nil {
+		return err
+	}
+
+	logging.Security(ctx, "Demo account locked: DEMO_ACCOUNT_ENABLED is off and it had the default password", "username", username)
+	return nil
+}
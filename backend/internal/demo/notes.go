@@ -0,0 +1,100 @@
+package demo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+// Note is a note demo accounts are seeded with. DEMO_NOTES_FILE holds a JSON array of them.
+type Note struct {
+	Title     string   `json:"title"`
+	Content   string   `json:"content"`
+	NoteType  string   `json:"noteType"` // note (default), checklist or code
+	IsPinned  bool     `json:"isPinned"`
+	Checklist []string `json:"checklist"` // unchecked items, for checklists
+}
+
+// DefaultNotes are seeded when DEMO_NOTES_FILE isn't set
+var DefaultNotes = []Note{
+	{
+		Title:    "Welcome to Notes!",
+		Content:  "This is your personal notes app. Create text notes or checklists, and they'll sync across all your devices in real-time.\n\nFeel free to explore - create, edit, and delete notes to see how it works!",
+		IsPinned: true,
+	},
+	{
+		Title:   "Features",
+		Content: "• Real-time sync across devices\n• Text notes and checklists\n• Pin important notes to the top\n• Archive notes you're done with\n• Secure authentication",
+	},
+	{
+		Title:    "Getting Started",
+		NoteType: string(models.NoteTypeChecklist),
+		Checklist: []string{
+			"Try creating a new note",
+			"Pin an important note",
+			"Archive a note you're done with",
+			"Check out the settings",
+		},
+	},
+}
+
+// LoadNotes reads the notes to seed demo accounts with from a JSON file, or returns DefaultNotes
+// when no file is given
+func LoadNotes(path string) ([]Note, error) {
+	if path == "" {
+		return DefaultNotes, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var notes []Note
+	if err := json.Unmarshal(data, &notes); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, note := range notes {
+		if note.NoteType != "" && !models.IsValidNoteType(note.NoteType) {
+			return nil, fmt.Errorf("%s: note %d has invalid noteType %q", path, i+1, note.NoteType)
+		}
+	}
+	return notes, nil
+}
+
+// createNotes creates the sample notes for a demo account
+func createNotes(ctx context.Context, noteRepo *repository.NoteRepository, userID uuid.UUID, notes []Note) {
+	now := time.Now()
+
+	for i, demo := range notes {
+		note := &models.Note{
+			ID:        uuid.New(),
+			UserID:    userID,
+			Title:     demo.Title,
+			Content:   demo.Content,
+			NoteType:  models.NoteType(demo.NoteType),
+			IsPinned:  demo.IsPinned,
+			SortOrder: i,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if note.NoteType == "" {
+			note.NoteType = models.NoteTypeNote
+		}
+		for j, text := range demo.Checklist {
+			note.ChecklistItems = append(note.ChecklistItems, models.ChecklistItem{
+				ID: uuid.New(), Text: text, IsCompleted: false, SortOrder: j, CreatedAt: now, UpdatedAt: now,
+			})
+		}
+
+		if err := noteRepo.Create(ctx, note); err != nil {
+			slog.WarnContext(ctx, "Failed to create demo note", "title", demo.Title, "error", err)
+		}
+	}
+}
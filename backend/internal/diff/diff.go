@@ -0,0 +1,273 @@
+// Package diff computes line- and word-level differences between two texts using Myers' algorithm,
+// for showing what changed between note revisions.
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Op is the kind of a diff element
+type Op string
+
+const (
+	OpEqual  Op = "equal"
+	OpInsert Op = "insert"
+	OpDelete Op = "delete"
+)
+
+// maxEditDistance bounds the work done on very different texts; past it the differing middle is
+// reported as deleted and reinserted as a whole
+const maxEditDistance = 1000
+
+// Span is a run of text within a line
+type Span struct {
+	Op   Op
+	Text string
+}
+
+// Line is one line of a line diff. Deleted lines that were replaced by an inserted line carry word
+// spans showing what changed within them: equal and deleted spans on the deleted line, equal and
+// inserted spans on the inserted one.
+type Line struct {
+	Op    Op
+	Text  string
+	Spans []Span
+}
+
+// Lines diffs a and b line by line
+func Lines(a, b string) []Line {
+	return LineSlices(splitLines(a), splitLines(b))
+}
+
+// LineSlices diffs two texts already split into lines
+func LineSlices(a, b []string) []Line {
+	edits := myers(a, b)
+
+	var lines []Line
+	for i := 0; i < len(edits); {
+		if edits[i].op == OpEqual {
+			lines = append(lines, Line{Op: OpEqual, Text: a[edits[i].a]})
+			i++
+			continue
+		}
+
+		// Gather a run of changes and pair its deleted and inserted lines for word diffs
+		var deleted, inserted []string
+		for ; i < len(edits) && edits[i].op != OpEqual; i++ {
+			if edits[i].op == OpDelete {
+				deleted = append(deleted, a[edits[i].a])
+			} else {
+				inserted = append(inserted, b[edits[i].b])
+			}
+		}
+
+		deletedLines := make([]Line, len(deleted))
+		insertedLines := make([]Line, len(inserted))
+		for j, text := range deleted {
+			deletedLines[j] = Line{Op: OpDelete, Text: text}
+		}
+		for j, text := range inserted {
+			insertedLines[j] = Line{Op: OpInsert, Text: text}
+		}
+		for j := 0; j < len(deleted) && j < len(inserted); j++ {
+			words := Words(deleted[j], inserted[j])
+			deletedLines[j].Spans = filterSpans(words, OpInsert)
+			insertedLines[j].Spans = filterSpans(words, OpDelete)
+		}
+		lines = append(lines, deletedLines...)
+		lines = append(lines, insertedLines...)
+	}
+	return lines
+}
+
+// Words diffs a and b word by word. Whitespace and punctuation are separate tokens, so the spans
+// joined back together reproduce both texts.
+func Words(a, b string) []Span {
+	at, bt := tokenize(a), tokenize(b)
+	var spans []Span
+	for _, e := range myers(at, bt) {
+		text := ""
+		if e.op == OpInsert {
+			text = bt[e.b]
+		} else {
+			text = at[e.a]
+		}
+		if n := len(spans); n > 0 && spans[n-1].Op == e.op {
+			spans[n-1].Text += text
+		} else {
+			spans = append(spans, Span{Op: e.op, Text: text})
+		}
+	}
+	return spans
+}
+
+// filterSpans drops spans of one op, merging the neighbours that become adjacent
+func filterSpans(spans []Span, drop Op) []Span {
+	var out []Span
+	for _, s := range spans {
+		if s.Op == drop {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].Op == s.Op {
+			out[n-1].Text += s.Text
+		} else {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// tokenize splits s into runs of letters and digits, runs of whitespace, and single other characters
+func tokenize(s string) []string {
+	var tokens []string
+	start := -1
+	class := 0
+	classOf := func(r rune) int {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			return 1
+		case unicode.IsSpace(r):
+			return 2
+		default:
+			return 3
+		}
+	}
+	for i, r := range s {
+		c := classOf(r)
+		if start >= 0 && (c != class || c == 3) {
+			tokens = append(tokens, s[start:i])
+			start = -1
+		}
+		if start < 0 {
+			start, class = i, c
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+// edit is one step of a diff: a[a] kept or deleted, or b[b] inserted
+type edit struct {
+	op   Op
+	a, b int
+}
+
+// myers returns the shortest edit script turning a into b
+func myers(a, b []string) []edit {
+	// Common prefix and suffix don't need the search
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, edit{op: OpEqual, a: i, b: i})
+	}
+	edits = append(edits, myersMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix)...)
+	for i := 0; i < suffix; i++ {
+		edits = append(edits, edit{op: OpEqual, a: len(a) - suffix + i, b: len(b) - suffix + i})
+	}
+	return edits
+}
+
+// myersMiddle diffs a and b, which start at offset in the original slices
+func myersMiddle(a, b []string, offset int) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	// v[k+max] is the furthest x reached on diagonal k; trace[d] keeps v[-d..d] after step d
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	found := -1
+	for d := 0; d <= max && d <= maxEditDistance; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+max] < v[k+1+max]) {
+				x = v[k+1+max]
+			} else {
+				x = v[k-1+max] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+max] = x
+		}
+		trace = append(trace, append([]int(nil), v[max-d:max+d+1]...))
+		if v[n-m+max] >= n && (n-m >= -d && n-m <= d) {
+			found = d
+			break
+		}
+	}
+
+	if found < 0 {
+		// Too different to be worth aligning
+		edits := make([]edit, 0, n+m)
+		for i := 0; i < n; i++ {
+			edits = append(edits, edit{op: OpDelete, a: offset + i})
+		}
+		for j := 0; j < m; j++ {
+			edits = append(edits, edit{op: OpInsert, b: offset + j})
+		}
+		return edits
+	}
+
+	at := func(d, k int) int { return trace[d][k+d] }
+
+	// Walk back from (n, m), collecting edits in reverse
+	var reversed []edit
+	x, y := n, m
+	for d := found; d > 0; d-- {
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(d-1, k-1) < at(d-1, k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(d-1, prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, edit{op: OpEqual, a: offset + x, b: offset + y})
+		}
+		if prevK == k+1 {
+			reversed = append(reversed, edit{op: OpInsert, b: offset + prevY})
+		} else {
+			reversed = append(reversed, edit{op: OpDelete, a: offset + prevX})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		reversed = append(reversed, edit{op: OpEqual, a: offset + x, b: offset + y})
+	}
+
+	edits := make([]edit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
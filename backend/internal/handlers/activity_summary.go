@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type ActivitySummaryHandler struct {
+	summaryService *services.ActivitySummaryService
+}
+
+func NewActivitySummaryHandler(summaryService *services.ActivitySummaryService) *ActivitySummaryHandler {
+	return &ActivitySummaryHandler{summaryService: summaryService}
+}
+
+// Get returns the user's activity summary subscription and a preview of last month's summary
+func (h *ActivitySummaryHandler) Get(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	summary, err := h.summaryService.Get(c.Request.Context(), userID)
+	if err != nil {
+		response.InternalError(c, "failed to fetch activity summary")
+		return
+	}
+
+	response.Success(c, summary)
+}
+
+// Subscribe opts in to the monthly summary email, or changes where it's sent
+func (h *ActivitySummaryHandler) Subscribe(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.ActivitySummaryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "a valid email is required")
+		return
+	}
+
+	summary, err := h.summaryService.Subscribe(c.Request.Context(), userID, req.Email)
+	if err != nil {
+		response.InternalError(c, "failed to subscribe to activity summary")
+		return
+	}
+
+	response.Success(c, summary)
+}
+
+// Unsubscribe stops the monthly summary email
+func (h *ActivitySummaryHandler) Unsubscribe(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	if err := h.summaryService.Unsubscribe(c.Request.Context(), userID); err != nil {
+		if errors.Is(err, repository.ErrActivitySubscriptionNotFound) {
+			response.NotFound(c, "not subscribed to the activity summary")
+			return
+		}
+		response.InternalError(c, "failed to unsubscribe from activity summary")
+		return
+	}
+
+	response.NoContent(c)
+}
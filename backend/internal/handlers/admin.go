@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+const (
+	defaultIntegrityReportLimit = 10
+	maxIntegrityReportLimit     = 100
+)
+
+// AdminHandler serves the admin API
+type AdminHandler struct {
+	integrityService   *services.IntegrityService
+	backupService      *services.BackupService
+	instanceService    *services.InstanceService
+	maintenanceService *services.MaintenanceService
+	authService        *services.AuthService
+	hub                *websocket.Hub
+}
+
+func NewAdminHandler(integrityService *services.IntegrityService, backupService *services.BackupService, instanceService *services.InstanceService, maintenanceService *services.MaintenanceService, authService *services.AuthService, hub *websocket.Hub) *AdminHandler {
+	return &AdminHandler{integrityService: integrityService, backupService: backupService, instanceService: instanceService, maintenanceService: maintenanceService, authService: authService, hub: hub}
+}
+
+// IntegrityReports returns the most recent integrity check reports, newest first.
+// Query parameters: limit (default 10, max 100).
+func (h *AdminHandler) IntegrityReports(c *gin.Context) {
+	limit := defaultIntegrityReportLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxIntegrityReportLimit {
+			response.BadRequest(c, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	reports, err := h.integrityService.Reports(c.Request.Context(), limit)
+	if err != nil {
+		response.InternalError(c, "failed to fetch integrity reports")
+		return
+	}
+
+	dtos := make([]models.IntegrityReportDTO, len(reports))
+	for i := range reports {
+		dtos[i] = services.IntegrityReportToDTO(&reports[i])
+	}
+	response.Success(c, dtos)
+}
+
+// RunIntegrityCheck runs the integrity check now, optionally repairing what it finds
+func (h *AdminHandler) RunIntegrityCheck(c *gin.Context) {
+	var req models.RunIntegrityCheckRequest
+	_ = c.ShouldBindJSON(&req) // Optional body
+
+	report, err := h.integrityService.Run(c.Request.Context(), req.Repair)
+	if err != nil {
+		if errors.Is(err, services.ErrIntegrityCheckRunning) {
+			response.Conflict(c, "an integrity check is already running")
+			return
+		}
+		response.InternalError(c, "failed to run integrity check")
+		return
+	}
+
+	logging.Audit(c.Request.Context(), "Admin ran integrity check", "report_id", report.ID.String(), "repair", req.Repair)
+	response.Success(c, services.IntegrityReportToDTO(report))
+}
+
+// Backup streams a backup of one user, or of the whole instance if no userId is given, as
+// newline-delimited JSON that Restore accepts
+func (h *AdminHandler) Backup(c *gin.Context) {
+	var req models.BackupRequest
+	_ = c.ShouldBindJSON(&req) // Optional body
+
+	userID := uuid.Nil
+	filename := "notes-backup-" + time.Now().UTC().Format("2006-01-02") + ".ndjson"
+	if req.UserID != "" {
+		id, err := uuid.Parse(req.UserID)
+		if err != nil {
+			response.BadRequest(c, "invalid user ID")
+			return
+		}
+		userID = id
+		filename = "notes-backup-" + id.String() + "-" + time.Now().UTC().Format("2006-01-02") + ".ndjson"
+	}
+
+	// Large backups take longer than the server's write timeout allows
+	clearDeadlines(c)
+
+	c.Header("Content-Type", MIMENDJSON)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	if err := h.backupService.Backup(c.Request.Context(), userID, newFlushWriter(c.Writer)); err != nil {
+		if c.Writer.Written() {
+			slog.ErrorContext(c.Request.Context(), "Backup was cut short", "error", err)
+			return
+		}
+		c.Header("Content-Type", "")
+		c.Header("Content-Disposition", "")
+		if errors.Is(err, repository.ErrUserNotFound) {
+			response.NotFound(c, "user not found")
+			return
+		}
+		response.InternalError(c, "failed to back up")
+		return
+	}
+
+	if userID != uuid.Nil {
+		logging.Audit(c.Request.Context(), "Admin backed up a user", "target_user_id", userID.String())
+	} else {
+		logging.Audit(c.Request.Context(), "Admin backed up the instance")
+	}
+}
+
+// Restore replaces the instance's data, or one user's, with the backup in the request body. With
+// dryRun=true the backup is checked by restoring it in a transaction that's rolled back.
+func (h *AdminHandler) Restore(c *gin.Context) {
+	dryRun := c.Query("dryRun") == "true"
+
+	// Large backups take longer to upload and restore than the server's timeouts allow
+	clearDeadlines(c)
+
+	result, err := h.backupService.Restore(c.Request.Context(), c.Request.Body, dryRun)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidBackup):
+			response.BadRequest(c, err.Error())
+		case errors.Is(err, services.ErrRestoreRunning):
+			response.Conflict(c, "a restore is already running")
+		default:
+			response.InternalError(c, "failed to restore backup")
+		}
+		return
+	}
+
+	if !dryRun {
+		if result.UserID != "" {
+			logging.Audit(c.Request.Context(), "Admin restored a user from a backup", "target_user_id", result.UserID, "rows", result.Rows)
+		} else {
+			logging.Audit(c.Request.Context(), "Admin restored the instance from a backup", "rows", result.Rows)
+		}
+	}
+	response.Success(c, result)
+}
+
+// WebSocketStats reports the real-time hub's connections, traffic, recovered panics and slow clients
+func (h *AdminHandler) WebSocketStats(c *gin.Context) {
+	stats := h.hub.Stats()
+	perUser := make([]models.WebSocketUserConnectionsDTO, len(stats.ConnectionsPerUser))
+	for i, user := range stats.ConnectionsPerUser {
+		perUser[i] = models.WebSocketUserConnectionsDTO{UserID: user.UserID.String(), Connections: user.Connections}
+	}
+	byVersion := make(map[string]int, len(stats.ConnectionsByVersion))
+	for version, count := range stats.ConnectionsByVersion {
+		byVersion[strconv.Itoa(version)] = count
+	}
+	lagging := make([]models.WebSocketClientLagDTO, len(stats.LaggingClients))
+	for i, lag := range stats.LaggingClients {
+		lagging[i] = models.WebSocketClientLagDTO{
+			ConnectionID:    lag.ConnectionID,
+			UserID:          lag.UserID.String(),
+			QueuedMessages:  lag.Queued,
+			DroppedMessages: lag.Dropped,
+		}
+	}
+	response.Success(c, models.WebSocketStatsDTO{
+		Connections:          stats.Connections,
+		Users:                stats.Users,
+		ConnectionsPerUser:   perUser,
+		ConnectionsByVersion: byVersion,
+		BinaryConnections:    stats.BinaryConnections,
+
+		Broadcasts:          stats.Broadcasts,
+		MessagesDelivered:   stats.MessagesDelivered,
+		BytesDelivered:      stats.BytesDelivered,
+		BroadcastsPerSecond: stats.BroadcastsPerSecond,
+		MessagesPerSecond:   stats.MessagesPerSecond,
+		BytesPerSecond:      stats.BytesPerSecond,
+		EventsCoalesced:     stats.EventsCoalesced,
+
+		Panics:          stats.Panics,
+		LoopRestarts:    stats.LoopRestarts,
+		DroppedMessages: stats.DroppedMessages,
+		SlowDisconnects: stats.SlowDisconnects,
+		LaggingClients:  lagging,
+
+		RateLimitDisconnects: stats.RateLimitDisconnects,
+
+		Goroutines:    stats.Goroutines,
+		UptimeSeconds: int64(stats.Uptime.Seconds()),
+	})
+}
+
+// Settings returns the server-wide settings
+func (h *AdminHandler) Settings(c *gin.Context) {
+	settings, err := h.instanceService.Settings(c.Request.Context())
+	if err != nil {
+		response.InternalError(c, "failed to fetch settings")
+		return
+	}
+
+	response.Success(c, services.InstanceSettingsToDTO(settings))
+}
+
+// UpdateSettings changes the server-wide settings
+func (h *AdminHandler) UpdateSettings(c *gin.Context) {
+	var req models.UpdateInstanceSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request: instanceName must be 1-100 characters")
+		return
+	}
+
+	settings, err := h.instanceService.UpdateSettings(c.Request.Context(), &req)
+	if err != nil {
+		response.InternalError(c, "failed to update settings")
+		return
+	}
+
+	logging.Audit(c.Request.Context(), "Admin updated instance settings", "registration_open", settings.RegistrationOpen)
+	response.Success(c, services.InstanceSettingsToDTO(settings))
+}
+
+// Maintenance returns whether the server is in maintenance mode
+func (h *AdminHandler) Maintenance(c *gin.Context) {
+	response.Success(c, services.MaintenanceToDTO(h.maintenanceService.Current()))
+}
+
+// SetMaintenance turns maintenance mode on or off, for every instance
+func (h *AdminHandler) SetMaintenance(c *gin.Context) {
+	var req models.UpdateMaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request: enabled is required and message must be at most 500 characters")
+		return
+	}
+
+	m := &models.Maintenance{Enabled: *req.Enabled, Message: req.Message}
+	if req.Until != nil {
+		until, err := time.Parse(time.RFC3339, *req.Until)
+		if err != nil {
+			response.BadRequest(c, "until must be an RFC 3339 time")
+			return
+		}
+		m.Until = &until
+	}
+	if err := h.maintenanceService.Set(c.Request.Context(), m); err != nil {
+		response.InternalError(c, "failed to set maintenance mode")
+		return
+	}
+
+	logging.Audit(c.Request.Context(), "Admin set maintenance mode", "enabled", m.Enabled)
+	response.Success(c, services.MaintenanceToDTO(h.maintenanceService.Current()))
+}
+
+// Lockouts lists the usernames locked out after too many failed logins
+func (h *AdminHandler) Lockouts(c *gin.Context) {
+	lockouts, err := h.authService.Lockouts(c.Request.Context())
+	if err != nil {
+		response.InternalError(c, "failed to fetch lockouts")
+		return
+	}
+
+	dtos := make([]models.LoginLockoutDTO, len(lockouts))
+	for i := range lockouts {
+		dtos[i] = services.LoginLockoutToDTO(&lockouts[i])
+	}
+	response.Success(c, dtos)
+}
+
+// Unlock ends a username's lockout early
+func (h *AdminHandler) Unlock(c *gin.Context) {
+	username := c.Param("username")
+	if err := h.authService.Unlock(c.Request.Context(), username); err != nil {
+		if errors.Is(err, repository.ErrLockoutNotFound) {
+			response.NotFound(c, "username is not locked out")
+			return
+		}
+		response.InternalError(c, "failed to unlock")
+		return
+	}
+
+	logging.Audit(c.Request.Context(), "Admin unlocked login", "username", username)
+	response.NoContent(c)
+}
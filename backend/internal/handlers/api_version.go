@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// successVersioned responds with v translated to the request's API version, as MessagePack if the
+// client asked for it
+func successVersioned(c *gin.Context, v any) {
+	translated, err := services.TranslateDTO(v, middleware.GetAPIVersion(c))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to translate response to API version", "version", middleware.GetAPIVersion(c), "error", err)
+		response.InternalError(c, "failed to encode response")
+		return
+	}
+	if wantsMsgPack(c) {
+		if err := renderMsgPack(c, translated); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to encode MessagePack response", "error", err)
+			response.InternalError(c, "failed to encode response")
+		}
+		return
+	}
+	response.Success(c, translated)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type ArchiveHandler struct {
+	archiveService *services.ArchiveService
+}
+
+func NewArchiveHandler(archiveService *services.ArchiveService) *ArchiveHandler {
+	return &ArchiveHandler{archiveService: archiveService}
+}
+
+// Export creates a new signed archive and downloads it. The file is served exactly as signed, so it
+// can be kept as is and verified later.
+func (h *ArchiveHandler) Export(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	// Large accounts take longer to archive and download than the server's write timeout allows
+	clearDeadlines(c)
+
+	if c.Query("stream") == "true" {
+		h.exportStream(c, userID)
+		return
+	}
+
+	a, err := h.archiveService.Export(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrArchiveExportConflict) {
+			response.Conflict(c, "another export is in progress; try again")
+			return
+		}
+		response.InternalError(c, "failed to export archive")
+		return
+	}
+
+	logging.Audit(c.Request.Context(), "User exported archive", "export_id", a.ExportID, "entries", a.EntryCount, "head", a.HeadHash)
+
+	c.Header("Content-Disposition", `attachment; filename="notes-archive-`+a.CreatedAt[:10]+`.json"`)
+	c.JSON(http.StatusCreated, a)
+}
+
+// exportStream writes the archive as it's built, for accounts too large to archive in memory. Once
+// the first entry is sent an error can only cut the archive short, leaving it without a signature.
+func (h *ArchiveHandler) exportStream(c *gin.Context, userID uuid.UUID) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="notes-archive-`+time.Now().UTC().Format("2006-01-02")+`.json"`)
+	c.Status(http.StatusCreated)
+
+	a, err := h.archiveService.ExportTo(c.Request.Context(), userID, newFlushWriter(c.Writer))
+	if err != nil {
+		if c.Writer.Written() {
+			slog.ErrorContext(c.Request.Context(), "Archive export was cut short", "error", err)
+			return
+		}
+		c.Header("Content-Disposition", "")
+		if errors.Is(err, repository.ErrArchiveExportConflict) {
+			response.Conflict(c, "another export is in progress; try again")
+			return
+		}
+		response.InternalError(c, "failed to export archive")
+		return
+	}
+
+	logging.Audit(c.Request.Context(), "User exported archive", "export_id", a.ExportID, "entries", a.EntryCount, "head", a.HeadHash)
+}
+
+// List returns the user's archive exports, newest first
+func (h *ArchiveHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	exports, err := h.archiveService.List(c.Request.Context(), userID)
+	if err != nil {
+		response.InternalError(c, "failed to fetch archive exports")
+		return
+	}
+
+	response.Success(c, exports)
+}
+
+// Verify checks an uploaded archive for tampering
+func (h *ArchiveHandler) Verify(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var a models.Archive
+	if err := c.ShouldBindJSON(&a); err != nil {
+		response.BadRequest(c, "invalid archive")
+		return
+	}
+
+	result, err := h.archiveService.Verify(c.Request.Context(), userID, &a)
+	if err != nil {
+		response.InternalError(c, "failed to verify archive")
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// PublicKey returns the key archives are signed with, for verifying them offline
+func (h *ArchiveHandler) PublicKey(c *gin.Context) {
+	response.Success(c, h.archiveService.PublicKey())
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// multipartOverhead allows for multipart headers and boundaries on top of the file itself
+const multipartOverhead = 64 << 10
+
+type AttachmentHandler struct {
+	attachmentService *services.AttachmentService
+	noteRepo          *repository.NoteRepository
+	syncService       *services.SyncService
+	wsHub             *websocket.Hub
+}
+
+func NewAttachmentHandler(attachmentService *services.AttachmentService, noteRepo *repository.NoteRepository, syncService *services.SyncService, wsHub *websocket.Hub) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentService: attachmentService,
+		noteRepo:          noteRepo,
+		syncService:       syncService,
+		wsHub:             wsHub,
+	}
+}
+
+// Upload attaches an audio file, sent as the "file" field of a multipart form, to a note
+func (h *AttachmentHandler) Upload(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+
+	// Large recordings take longer to upload on slow connections than the server's timeouts allow
+	clearDeadlines(c)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.attachmentService.MaxBytes()+multipartOverhead)
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		response.BadRequest(c, "expected a multipart/form-data upload")
+		return
+	}
+
+	// Stream the file part straight to storage rather than buffering the form
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			response.BadRequest(c, "missing file field")
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		attachment, err := h.attachmentService.Upload(c.Request.Context(), userID, noteID, part.FileName(), part)
+		part.Close()
+		if err != nil {
+			if quotaExceeded(c, err) {
+				return
+			}
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.Is(err, repository.ErrNoteNotFound):
+				response.NotFound(c, "note not found")
+			case errors.Is(err, services.ErrAttachmentTooLarge), errors.As(err, &maxBytesErr):
+				response.PayloadTooLarge(c, "attachment too large")
+			case errors.Is(err, services.ErrUnsupportedAttachment):
+				response.UnsupportedMediaType(c, err.Error())
+			default:
+				response.InternalError(c, "failed to store attachment")
+			}
+			return
+		}
+
+		h.broadcastNote(c, userID, noteID)
+		response.Created(c, services.AttachmentToDTO(attachment))
+		return
+	}
+}
+
+// List returns a note's attachments
+func (h *AttachmentHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+
+	attachments, err := h.attachmentService.List(c.Request.Context(), userID, noteID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			response.NotFound(c, "note not found")
+			return
+		}
+		response.InternalError(c, "failed to fetch attachments")
+		return
+	}
+
+	attachmentDTOs := make([]models.AttachmentDTO, len(attachments))
+	for i, attachment := range attachments {
+		attachmentDTOs[i] = services.AttachmentToDTO(&attachment)
+	}
+
+	response.Success(c, attachmentDTOs)
+}
+
+// Download streams an attachment. Range requests are supported so clients can seek within audio.
+func (h *AttachmentHandler) Download(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	attachmentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid attachment ID")
+		return
+	}
+
+	attachment, f, err := h.attachmentService.Open(c.Request.Context(), userID, attachmentID)
+	if err != nil {
+		if errors.Is(err, repository.ErrAttachmentNotFound) {
+			response.NotFound(c, "attachment not found")
+			return
+		}
+		response.InternalError(c, "failed to open attachment")
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Type", attachment.ContentType)
+	c.Header("Content-Disposition", "inline; filename*=UTF-8''"+url.PathEscape(attachment.Filename))
+	c.Header("Cache-Control", "private, max-age=3600")
+
+	// ServeContent handles Range, If-Range and If-Modified-Since
+	clearDeadlines(c)
+	http.ServeContent(c.Writer, c.Request, attachment.Filename, attachment.CreatedAt, f)
+}
+
+// Delete removes an attachment
+func (h *AttachmentHandler) Delete(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	attachmentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid attachment ID")
+		return
+	}
+
+	attachment, err := h.attachmentService.Delete(c.Request.Context(), userID, attachmentID)
+	if err != nil {
+		if errors.Is(err, repository.ErrAttachmentNotFound) {
+			response.NotFound(c, "attachment not found")
+			return
+		}
+		response.InternalError(c, "failed to delete attachment")
+		return
+	}
+
+	h.broadcastNote(c, userID, attachment.NoteID)
+	response.NoContent(c)
+}
+
+// broadcastNote sends the note with its current attachments to the user's other connections
+func (h *AttachmentHandler) broadcastNote(c *gin.Context, userID, noteID uuid.UUID) {
+	if h.wsHub == nil {
+		return
+	}
+
+	note, err := h.noteRepo.GetByID(c.Request.Context(), noteID, userID)
+	if err != nil {
+		return
+	}
+
+	h.wsHub.BroadcastEvent(c.Request.Context(), userID, websocket.MessageTypeNoteUpdated, websocket.NoteChangePayload{
+		Note: h.syncService.NoteToDTO(note),
+	}, middleware.GetConnectionID(c), middleware.GetRequestID(c))
+}
@@ -2,58 +2,127 @@ package handlers
 
 import (
 	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
 	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
 	"github.com/hamishgilbert/notes-app/backend/internal/services"
 	"github.com/hamishgilbert/notes-app/backend/pkg/response"
 )
 
 type AuthHandler struct {
-	authService *services.AuthService
+	authService         *services.AuthService
+	shareService        *services.ShareService
+	verificationService *services.EmailVerificationService
+	instanceService     *services.InstanceService
 }
 
-func NewAuthHandler(authService *services.AuthService) *AuthHandler {
-	return &AuthHandler{authService: authService}
+func NewAuthHandler(authService *services.AuthService, shareService *services.ShareService, verificationService *services.EmailVerificationService, instanceService *services.InstanceService) *AuthHandler {
+	return &AuthHandler{
+		authService:         authService,
+		shareService:        shareService,
+		verificationService: verificationService,
+		instanceService:     instanceService,
+	}
+}
+
+// authResponse is what every way of logging in or refreshing returns
+func authResponse(tokens *services.TokenPair, user *models.User) models.AuthResponse {
+	return models.AuthResponse{
+		AccessToken:           tokens.AccessToken,
+		RefreshToken:          tokens.RefreshToken,
+		ExpiresIn:             tokens.ExpiresIn,
+		AccessTokenExpiresAt:  tokens.AccessExpiresAt.UTC().Format(services.ISO8601Format),
+		RefreshTokenExpiresAt: tokens.RefreshExpiresAt.UTC().Format(services.ISO8601Format),
+		TokenType:             "Bearer",
+		User:                  userToDTO(user),
+	}
+}
+
+func userToDTO(user *models.User) models.UserDTO {
+	return models.UserDTO{
+		ID:            user.ID.String(),
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.VerifiedAt != nil,
+	}
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req models.AuthRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		response.BadRequest(c, "invalid request: username must be 3-50 alphanumeric characters, password must be 12-128 characters")
+		response.BadRequest(c, "invalid request: username must be 3-50 alphanumeric characters. "+h.authService.PasswordRequirements())
+		return
+	}
+
+	if err := h.instanceService.CheckRegistrationOpen(c.Request.Context()); err != nil {
+		if errors.Is(err, services.ErrRegistrationClosed) {
+			response.Forbidden(c, "registration is closed")
+			return
+		}
+		if errors.Is(err, services.ErrBreachedPassword) {
+			response.BadRequest(c, "this password has appeared in a data breach; choose a different one")
+			return
+		}
+		response.InternalError(c, "failed to register user")
 		return
 	}
 
 	clientIP := c.ClientIP()
-	user, tokens, err := h.authService.Register(c.Request.Context(), req.Username, req.Password, clientIP)
+	user, tokens, err := h.authService.Register(c.Request.Context(), req.Username, req.Email, req.Password, clientIP)
 	if err != nil {
 		if errors.Is(err, services.ErrUserExists) {
 			// Record failed attempt for rate limiting
 			if al, exists := c.Get("authRateLimiter"); exists {
-				al.(*middleware.AuthRateLimiter).RecordFailedAttempt(clientIP)
+				al.(*middleware.AuthRateLimiter).RecordFailedAttempt(c.Request.Context(), clientIP)
 			}
 			response.Conflict(c, "username already exists")
 			return
 		}
+		if errors.Is(err, services.ErrEmailExists) {
+			response.Conflict(c, "email already in use")
+			return
+		}
+		if errors.Is(err, services.ErrEmailRequired) {
+			response.BadRequest(c, "an email address is required to register")
+			return
+		}
 		if errors.Is(err, services.ErrWeakPassword) {
-			response.BadRequest(c, "password does not meet complexity requirements: must be 12-128 characters with at least one uppercase letter, one lowercase letter, one digit, and one special character")
+			response.BadRequest(c, err.Error())
 			return
 		}
 		response.InternalError(c, "failed to register user")
 		return
 	}
 
-	response.Created(c, models.AuthResponse{
-		AccessToken:  tokens.AccessToken,
-		RefreshToken: tokens.RefreshToken,
-		ExpiresIn:    tokens.ExpiresIn,
-		TokenType:    "Bearer",
-		User: models.UserDTO{
-			ID:       user.ID.String(),
-			Username: user.Username,
-		},
-	})
+	// Registering from an invite link grants access to the shared note.
+	// A bad or expired invite shouldn't fail the registration itself.
+	if req.InviteToken != "" && h.shareService != nil {
+		if _, err := h.shareService.AcceptInvite(c.Request.Context(), req.InviteToken, user.ID); err != nil {
+			slog.WarnContext(c.Request.Context(), "Failed to accept invite during registration", "user_id", user.ID.String(), "error", err)
+		}
+	}
+
+	// A failed email can be retried with resend-verification, so it doesn't fail the registration
+	if err := h.verificationService.SendVerification(c.Request.Context(), user); err != nil {
+		slog.WarnContext(c.Request.Context(), "Failed to send verification email during registration", "user_id", user.ID.String(), "error", err)
+	}
+
+	if tokens == nil {
+		response.Created(c, models.RegistrationPendingResponse{
+			Message: "check your email for a link to verify your address, then log in",
+			User:    userToDTO(user),
+		})
+		return
+	}
+
+	response.Created(c, authResponse(tokens, user))
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -69,30 +138,31 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		if errors.Is(err, services.ErrInvalidCredentials) {
 			// Record failed attempt for rate limiting
 			if al, exists := c.Get("authRateLimiter"); exists {
-				al.(*middleware.AuthRateLimiter).RecordFailedAttempt(clientIP)
+				al.(*middleware.AuthRateLimiter).RecordFailedAttempt(c.Request.Context(), clientIP)
 			}
 			response.Unauthorized(c, "invalid username or password")
 			return
 		}
+		var locked *services.AccountLockedError
+		if errors.As(err, &locked) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(locked.Until).Seconds()))))
+			response.TooManyRequests(c, "account temporarily locked after too many failed logins; try again later")
+			return
+		}
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			response.Forbidden(c, "email address not verified; check your email for the verification link")
+			return
+		}
 		response.InternalError(c, "failed to login")
 		return
 	}
 
 	// Reset failed attempts on successful login
 	if al, exists := c.Get("authRateLimiter"); exists {
-		al.(*middleware.AuthRateLimiter).ResetFailedAttempts(clientIP)
-	}
-
-	response.Success(c, models.AuthResponse{
-		AccessToken:  tokens.AccessToken,
-		RefreshToken: tokens.RefreshToken,
-		ExpiresIn:    tokens.ExpiresIn,
-		TokenType:    "Bearer",
-		User: models.UserDTO{
-			ID:       user.ID.String(),
-			Username: user.Username,
-		},
-	})
+		al.(*middleware.AuthRateLimiter).ResetFailedAttempts(c.Request.Context(), clientIP)
+	}
+
+	response.Success(c, authResponse(tokens, user))
 }
 
 func (h *AuthHandler) Me(c *gin.Context) {
@@ -104,10 +174,7 @@ func (h *AuthHandler) Me(c *gin.Context) {
 		return
 	}
 
-	response.Success(c, models.UserDTO{
-		ID:       user.ID.String(),
-		Username: user.Username,
-	})
+	response.Success(c, userToDTO(user))
 }
 
 func (h *AuthHandler) Refresh(c *gin.Context) {
@@ -120,32 +187,31 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 	clientIP := c.ClientIP()
 	tokens, err := h.authService.RefreshTokenPair(c.Request.Context(), req.RefreshToken, clientIP)
 	if err != nil {
+		if errors.Is(err, services.ErrTokenReused) {
+			response.Unauthorized(c, "refresh token was already used; all sessions from this login have been signed out")
+			return
+		}
 		if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrTokenExpired) || errors.Is(err, services.ErrTokenRevoked) {
 			response.Unauthorized(c, "invalid or expired refresh token")
 			return
 		}
+		if errors.Is(err, services.ErrDeviceMismatch) {
+			response.Unauthorized(c, "refresh token was issued to another device")
+			return
+		}
 		response.InternalError(c, "failed to refresh token")
 		return
 	}
 
 	// Get user info for the response
-	userID, _ := h.authService.ValidateToken(tokens.AccessToken)
+	userID, _ := h.authService.ValidateTokenWithContext(c.Request.Context(), tokens.AccessToken)
 	user, err := h.authService.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
 		response.InternalError(c, "failed to get user info")
 		return
 	}
 
-	response.Success(c, models.AuthResponse{
-		AccessToken:  tokens.AccessToken,
-		RefreshToken: tokens.RefreshToken,
-		ExpiresIn:    tokens.ExpiresIn,
-		TokenType:    "Bearer",
-		User: models.UserDTO{
-			ID:       user.ID.String(),
-			Username: user.Username,
-		},
-	})
+	response.Success(c, authResponse(tokens, user))
 }
 
 // Logout revokes the current tokens
@@ -166,7 +232,7 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 		return
 	}
 
-	response.Success(c, gin.H{"message": "logged out successfully"})
+	response.Success(c, models.MessageResponse{Message: "logged out successfully"})
 }
 
 // LogoutAll revokes all tokens for the current user (logout everywhere)
@@ -179,14 +245,109 @@ func (h *AuthHandler) LogoutAll(c *gin.Context) {
 		return
 	}
 
-	response.Success(c, gin.H{"message": "logged out from all devices successfully"})
+	response.Success(c, models.MessageResponse{Message: "logged out from all devices successfully"})
+}
+
+// TokenInfo describes the access token the request was made with
+func (h *AuthHandler) TokenInfo(c *gin.Context) {
+	info := middleware.GetTokenInfo(c)
+	if info == nil {
+		response.Unauthorized(c, "invalid or expired token")
+		return
+	}
+
+	now := time.Now()
+	dto := models.TokenInfoResponse{
+		UserID:     info.UserID.String(),
+		IssuedAt:   info.IssuedAt.UTC().Format(services.ISO8601Format),
+		ExpiresAt:  info.ExpiresAt.UTC().Format(services.ISO8601Format),
+		ExpiresIn:  max(int(info.ExpiresAt.Sub(now).Seconds()), 0),
+		ServerTime: now.UTC().Format(services.ISO8601Format),
+		Scope:      info.Scope,
+	}
+	if info.SessionID != uuid.Nil {
+		dto.SessionID = info.SessionID.String()
+	}
+	response.Success(c, dto)
+}
+
+// JWKS publishes the public keys tokens may be signed with, for services that verify them. Verifiers
+// may cache it for a few minutes; keys are published before they start signing.
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	response.Success(c, h.authService.JWKS())
+}
+
+// ListSessions returns the devices the current user is signed in on
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	sessions, err := h.authService.Sessions(c.Request.Context(), middleware.GetUserID(c))
+	if err != nil {
+		response.InternalError(c, "failed to fetch sessions")
+		return
+	}
+
+	current := middleware.GetSessionID(c)
+	dtos := make([]models.SessionDTO, len(sessions))
+	for i := range sessions {
+		dtos[i] = services.SessionToDTO(&sessions[i], sessions[i].ID == current)
+	}
+	response.Success(c, dtos)
+}
+
+// RevokeSession signs the current user out on one device
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid session ID")
+		return
+	}
+
+	if err := h.authService.RevokeSession(c.Request.Context(), middleware.GetUserID(c), id, c.ClientIP()); err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			response.NotFound(c, "session not found")
+			return
+		}
+		response.InternalError(c, "failed to revoke session")
+		return
+	}
+
+	response.NoContent(c)
+}
+
+// CreateScopedToken issues an access token limited to a scope, such as a read-only one for a widget
+// or kiosk display. It shows up among the user's sessions, and is revoked by deleting that session.
+func (h *AuthHandler) CreateScopedToken(c *gin.Context) {
+	var req models.CreateScopedTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request: scope must be notes:read, name is required (max 100 characters) and expiresInDays must be 1-365")
+		return
+	}
+
+	lifetime := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	token, session, err := h.authService.IssueScopedToken(c.Request.Context(), middleware.GetUserID(c), req.Scope, req.Name, lifetime, c.ClientIP())
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidScope) {
+			response.BadRequest(c, "invalid scope")
+			return
+		}
+		response.InternalError(c, "failed to issue token")
+		return
+	}
+
+	response.Created(c, models.ScopedTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		Scope:       session.Scope,
+		SessionID:   session.ID.String(),
+		ExpiresAt:   session.ExpiresAt.UTC().Format(services.ISO8601Format),
+	})
 }
 
 // ChangePassword changes the current user's password
 func (h *AuthHandler) ChangePassword(c *gin.Context) {
 	var req models.ChangePasswordRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		response.BadRequest(c, "invalid request: current_password and new_password are required (new password must be 12-128 characters)")
+		response.BadRequest(c, "invalid request: current_password and new_password are required. "+h.authService.PasswordRequirements())
 		return
 	}
 
@@ -199,12 +360,81 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 			return
 		}
 		if errors.Is(err, services.ErrWeakPassword) {
-			response.BadRequest(c, "new password does not meet complexity requirements: must be 12-128 characters with at least one uppercase letter, one lowercase letter, one digit, and one special character")
+			response.BadRequest(c, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrBreachedPassword) {
+			response.BadRequest(c, "this password has appeared in a data breach; choose a different one")
 			return
 		}
 		response.InternalError(c, "failed to change password")
 		return
 	}
 
-	response.Success(c, gin.H{"message": "password changed successfully"})
+	response.Success(c, models.MessageResponse{Message: "password changed successfully"})
+}
+
+// VerifyEmail verifies an email address using the token from the emailed link
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "token is required")
+		return
+	}
+
+	user, err := h.verificationService.Verify(c.Request.Context(), req.Token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			response.BadRequest(c, "invalid or expired verification link")
+			return
+		}
+		response.InternalError(c, "failed to verify email")
+		return
+	}
+
+	response.Success(c, userToDTO(user))
+}
+
+// ResendVerification sends a new verification link. It always reports success, so it can't be used
+// to find out which addresses have accounts.
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	var req models.EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "a valid email is required")
+		return
+	}
+
+	if err := h.verificationService.Resend(c.Request.Context(), req.Email); err != nil {
+		response.InternalError(c, "failed to resend verification email")
+		return
+	}
+
+	response.Success(c, models.MessageResponse{Message: "if that address needs verifying, a new link has been sent"})
+}
+
+// ChangeEmail sets the current user's email address and sends a link to verify it
+func (h *AuthHandler) ChangeEmail(c *gin.Context) {
+	var req models.EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "a valid email is required")
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+	user, err := h.verificationService.ChangeEmail(c.Request.Context(), userID, req.Email)
+	if err != nil {
+		if errors.Is(err, services.ErrEmailExists) {
+			response.Conflict(c, "email already in use")
+			return
+		}
+		if errors.Is(err, services.ErrVerificationDelivery) {
+			// The address was changed; the user can ask for the link again
+			slog.WarnContext(c.Request.Context(), "Failed to send verification email", "error", err)
+		} else {
+			response.InternalError(c, "failed to change email")
+			return
+		}
+	}
+
+	response.Success(c, userToDTO(user))
 }
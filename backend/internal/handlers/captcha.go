@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type CaptchaHandler struct {
+	config models.CaptchaConfigResponse
+}
+
+func NewCaptchaHandler(config models.CaptchaConfigResponse) *CaptchaHandler {
+	return &CaptchaHandler{config: config}
+}
+
+// Config tells clients which CAPTCHA widget to load and when registering or logging in needs it
+func (h *CaptchaHandler) Config(c *gin.Context) {
+	response.Success(c, h.config)
+}
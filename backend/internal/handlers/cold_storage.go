@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+const (
+	defaultColdNoteLimit = 50
+	maxColdNoteLimit     = 200
+)
+
+type ColdStorageHandler struct {
+	coldStorage *services.ColdStorageService
+	syncService *services.SyncService
+	wsHub       *websocket.Hub
+}
+
+func NewColdStorageHandler(coldStorage *services.ColdStorageService, syncService *services.SyncService, wsHub *websocket.Hub) *ColdStorageHandler {
+	return &ColdStorageHandler{
+		coldStorage: coldStorage,
+		syncService: syncService,
+		wsHub:       wsHub,
+	}
+}
+
+// List returns the user's cold-stored notes, most recently archived first.
+// Query parameters: limit (default 50, max 200), offset.
+func (h *ColdStorageHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	limit := defaultColdNoteLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxColdNoteLimit {
+			response.BadRequest(c, "limit must be between 1 and 200")
+			return
+		}
+		limit = n
+	}
+
+	offset := 0
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		n, err := strconv.Atoi(offsetStr)
+		if err != nil || n < 0 {
+			response.BadRequest(c, "offset must be a non-negative integer")
+			return
+		}
+		offset = n
+	}
+
+	notes, err := h.coldStorage.List(c.Request.Context(), userID, limit, offset)
+	if err != nil {
+		response.InternalError(c, "failed to fetch archived notes")
+		return
+	}
+
+	noteDTOs := make([]models.NoteDTO, len(notes))
+	for i, note := range notes {
+		noteDTOs[i] = h.syncService.NoteToDTO(&note)
+	}
+
+	response.Success(c, noteDTOs)
+}
+
+// Get returns a cold-stored note without restoring it
+func (h *ColdStorageHandler) Get(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+
+	note, err := h.coldStorage.Get(c.Request.Context(), userID, noteID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			response.NotFound(c, "note not found")
+			return
+		}
+		response.InternalError(c, "failed to fetch note")
+		return
+	}
+
+	response.Success(c, h.syncService.NoteToDTO(note))
+}
+
+// Restore moves a note out of cold storage so it syncs and can be edited again
+func (h *ColdStorageHandler) Restore(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+
+	note, err := h.coldStorage.Restore(c.Request.Context(), userID, noteID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			response.NotFound(c, "note not found")
+			return
+		}
+		response.InternalError(c, "failed to restore note")
+		return
+	}
+
+	noteDTO := h.syncService.NoteToDTO(note)
+
+	if h.wsHub != nil {
+		h.wsHub.BroadcastEvent(c.Request.Context(), userID, websocket.MessageTypeNoteCreated, websocket.NoteChangePayload{Note: noteDTO},
+			middleware.GetConnectionID(c), middleware.GetRequestID(c))
+	}
+
+	response.Success(c, noteDTO)
+}
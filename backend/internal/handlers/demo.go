@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/demo"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// DemoHandler hands out demo accounts
+type DemoHandler struct {
+	demoService *demo.Service
+}
+
+func NewDemoHandler(demoService *demo.Service) *DemoHandler {
+	return &DemoHandler{demoService: demoService}
+}
+
+// Create gives the visitor a demo account of their own, seeded with the sample notes, and logs them in
+func (h *DemoHandler) Create(c *gin.Context) {
+	user, tokens, err := h.demoService.CreateAccount(c.Request.Context(), c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, demo.ErrDisabled):
+			response.NotFound(c, "demo accounts are not available")
+		case errors.Is(err, demo.ErrTooManyAccounts):
+			response.TooManyRequests(c, "too many demo accounts in use; try again later")
+		default:
+			response.InternalError(c, "failed to create demo account")
+		}
+		return
+	}
+
+	response.Created(c, authResponse(tokens, user))
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type DeviceHandler struct {
+	deviceService *services.DeviceService
+}
+
+func NewDeviceHandler(deviceService *services.DeviceService) *DeviceHandler {
+	return &DeviceHandler{deviceService: deviceService}
+}
+
+// List returns the user's devices with their last sync time
+func (h *DeviceHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	devices, err := h.deviceService.List(c.Request.Context(), userID)
+	if err != nil {
+		response.InternalError(c, "failed to fetch devices")
+		return
+	}
+
+	deviceDTOs := make([]models.DeviceDTO, len(devices))
+	for i, device := range devices {
+		deviceDTOs[i] = services.DeviceToDTO(&device)
+	}
+
+	response.Success(c, deviceDTOs)
+}
+
+// Register adds the calling device, or updates its name and platform
+func (h *DeviceHandler) Register(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.RegisterDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request body")
+		return
+	}
+
+	device, err := h.deviceService.Register(c.Request.Context(), userID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidDevicePlatform):
+			response.BadRequest(c, err.Error())
+		case errors.Is(err, repository.ErrDeviceRevoked):
+			response.Forbidden(c, "device has been revoked")
+		default:
+			response.InternalError(c, "failed to register device")
+		}
+		return
+	}
+
+	response.Success(c, services.DeviceToDTO(device))
+}
+
+// Revoke stops a device from syncing
+func (h *DeviceHandler) Revoke(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid device ID")
+		return
+	}
+
+	device, err := h.deviceService.Revoke(c.Request.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrDeviceNotFound) {
+			response.NotFound(c, "device not found")
+			return
+		}
+		response.InternalError(c, "failed to revoke device")
+		return
+	}
+
+	response.Success(c, services.DeviceToDTO(device))
+}
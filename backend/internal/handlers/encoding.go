@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+)
+
+// Sync requests and responses can be exchanged as MessagePack instead of JSON. Clients opt in with
+// Content-Type and Accept; field names are the same as in JSON. Errors are always JSON.
+
+func isMsgPack(mime string) bool {
+	return mime == binding.MIMEMSGPACK || mime == binding.MIMEMSGPACK2
+}
+
+// bindBody decodes the request body as MessagePack or JSON, depending on its Content-Type
+func bindBody(c *gin.Context, obj any) error {
+	if isMsgPack(c.ContentType()) {
+		return c.ShouldBindWith(obj, binding.MsgPack)
+	}
+	return c.ShouldBindJSON(obj)
+}
+
+// wantsMsgPack reports whether the client prefers a MessagePack response
+func wantsMsgPack(c *gin.Context) bool {
+	return isMsgPack(c.NegotiateFormat(binding.MIMEJSON, binding.MIMEMSGPACK2, binding.MIMEMSGPACK))
+}
+
+// renderMsgPack writes v as a MessagePack response. Pre-encoded JSON is decoded first, keeping
+// whole numbers as integers.
+func renderMsgPack(c *gin.Context, v any) error {
+	if raw, ok := v.(json.RawMessage); ok {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var decoded any
+		if err := decoder.Decode(&decoded); err != nil {
+			return err
+		}
+		v = fromJSONNumbers(decoded)
+	}
+	c.Render(http.StatusOK, render.MsgPack{Data: v})
+	return nil
+}
+
+func fromJSONNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, value := range v {
+			v[key] = fromJSONNumbers(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = fromJSONNumbers(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type ExportHandler struct {
+	exportService *services.ExportService
+}
+
+func NewExportHandler(exportService *services.ExportService) *ExportHandler {
+	return &ExportHandler{exportService: exportService}
+}
+
+// Create starts exporting the notes matching a filter as one file. The export runs in the
+// background; poll Get until it completes, then download it.
+func (h *ExportHandler) Create(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.CreateExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request: format must be 'pdf', 'markdown' or 'zip', paper 'a4' or 'letter', at most 5000 noteIds")
+		return
+	}
+
+	job, err := h.exportService.Start(c.Request.Context(), userID, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidExportID) {
+			response.BadRequest(c, "invalid note ID")
+			return
+		}
+		response.InternalError(c, "failed to start export")
+		return
+	}
+
+	logging.Audit(c.Request.Context(), "User started export", "format", job.Format, "export_id", job.ID.String())
+
+	c.Header("Location", "/api/export/"+job.ID.String())
+	response.Accepted(c, services.ExportJobToDTO(job))
+}
+
+// List returns the user's exports, newest first
+func (h *ExportHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	jobs, err := h.exportService.List(c.Request.Context(), userID)
+	if err != nil {
+		response.InternalError(c, "failed to fetch exports")
+		return
+	}
+
+	dtos := make([]models.ExportJobDTO, len(jobs))
+	for i := range jobs {
+		dtos[i] = services.ExportJobToDTO(&jobs[i])
+	}
+	response.Success(c, dtos)
+}
+
+// Get returns an export's progress
+func (h *ExportHandler) Get(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	jobID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid export ID")
+		return
+	}
+
+	job, err := h.exportService.Get(c.Request.Context(), userID, jobID)
+	if err != nil {
+		if errors.Is(err, repository.ErrExportJobNotFound) {
+			response.NotFound(c, "export not found")
+			return
+		}
+		response.InternalError(c, "failed to fetch export")
+		return
+	}
+
+	response.Success(c, services.ExportJobToDTO(job))
+}
+
+// Download serves a completed export's file
+func (h *ExportHandler) Download(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	jobID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid export ID")
+		return
+	}
+
+	job, f, err := h.exportService.Open(c.Request.Context(), userID, jobID)
+	if err != nil {
+		if errors.Is(err, repository.ErrExportJobNotFound) {
+			response.NotFound(c, "export not found")
+			return
+		}
+		if errors.Is(err, services.ErrExportNotReady) {
+			response.Conflict(c, "export has not completed")
+			return
+		}
+		response.InternalError(c, "failed to open export")
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Type", exportContentType(job.Format))
+	c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(job.Filename))
+	c.Header("Cache-Control", "private, no-store")
+
+	// Large exports take longer to download than the server's write timeout allows
+	clearDeadlines(c)
+	http.ServeContent(c.Writer, c.Request, job.Filename, *job.CompletedAt, f)
+}
+
+func exportContentType(format models.ExportFormat) string {
+	switch format {
+	case models.ExportFormatPDF:
+		return "application/pdf"
+	case models.ExportFormatZip:
+		return "application/zip"
+	default:
+		return "text/markdown; charset=utf-8"
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/apischema"
+	"github.com/hamishgilbert/notes-app/backend/internal/database"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+// readinessPingTimeout bounds the database ping of a readiness check
+const readinessPingTimeout = 2 * time.Second
+
+// HealthHandler answers liveness and readiness checks from load balancers and orchestrators
+type HealthHandler struct {
+	db *database.DB
+}
+
+func NewHealthHandler(db *database.DB) *HealthHandler {
+	return &HealthHandler{db: db}
+}
+
+// Live reports that the process is up, without touching the database
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, models.HealthResponse{Status: "ok", Version: apischema.APIVersion})
+}
+
+// Ready pings the database and reports its latency and connection pool, with status 503 if it
+// doesn't answer, so traffic is only sent to instances that can serve it
+func (h *HealthHandler) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessPingTimeout)
+	defer cancel()
+	latency, err := h.db.Ping(ctx)
+
+	resp := models.ReadinessResponse{
+		Status:  "ok",
+		Version: apischema.APIVersion,
+		Database: models.DatabaseHealthDTO{
+			Status:    "ok",
+			LatencyMs: float64(latency.Microseconds()) / 1000,
+			Pool:      poolToDTO(h.db.Stats()),
+		},
+	}
+	status := http.StatusOK
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Readiness check failed: database ping", "error", err)
+		resp.Status = "unavailable"
+		resp.Database.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, resp)
+}
+
+func poolToDTO(stats database.PoolStats) models.DatabasePoolDTO {
+	return models.DatabasePoolDTO{
+		Acquired:         stats.Acquired,
+		Idle:             stats.Idle,
+		Total:            stats.Total,
+		Max:              stats.Max,
+		Acquires:         stats.Acquires,
+		WaitedAcquires:   stats.EmptyAcquires,
+		AcquireWaitMs:    stats.AcquireWait.Milliseconds(),
+		CanceledAcquires: stats.CanceledAcquires,
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// MagicLinkHandler serves passwordless sign-in with emailed links
+type MagicLinkHandler struct {
+	magicLinkService *services.MagicLinkService
+	auditLogger      *middleware.AuditLogger
+}
+
+func NewMagicLinkHandler(magicLinkService *services.MagicLinkService, auditLogger *middleware.AuditLogger) *MagicLinkHandler {
+	return &MagicLinkHandler{magicLinkService: magicLinkService, auditLogger: auditLogger}
+}
+
+// Send emails a sign-in link in the background. It always reports success, at once, so it can't be
+// used to find out which addresses have accounts.
+func (h *MagicLinkHandler) Send(c *gin.Context) {
+	var req models.EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "a valid email is required")
+		return
+	}
+
+	clientIP := c.ClientIP()
+	h.magicLinkService.Send(c.Request.Context(), req.Email, clientIP)
+	h.auditLogger.LogAuthEvent(c.Request.Context(), "", "magic_link_request", clientIP, c.Request.UserAgent(), "", true)
+
+	response.Success(c, models.MessageResponse{Message: "if an account uses that address, a sign-in link has been sent"})
+}
+
+// Exchange trades the token from a sign-in link for tokens
+func (h *MagicLinkHandler) Exchange(c *gin.Context) {
+	var req models.MagicLinkExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "token is required")
+		return
+	}
+
+	clientIP := c.ClientIP()
+	user, tokens, err := h.magicLinkService.Exchange(c.Request.Context(), req.Token, clientIP)
+	if err != nil {
+		h.auditLogger.LogAuthEvent(c.Request.Context(), "", "magic_link_login", clientIP, c.Request.UserAgent(), err.Error(), false)
+		switch {
+		case errors.Is(err, services.ErrInvalidMagicLink):
+			// Record failed attempt for rate limiting
+			if al, exists := c.Get("authRateLimiter"); exists {
+				al.(*middleware.AuthRateLimiter).RecordFailedAttempt(c.Request.Context(), clientIP)
+			}
+			response.Unauthorized(c, "invalid, used or expired sign-in link")
+		case errors.Is(err, services.ErrEmailNotVerified):
+			response.Forbidden(c, "email address not verified; check your email for the verification link")
+		default:
+			response.InternalError(c, "failed to login")
+		}
+		return
+	}
+	h.auditLogger.LogAuthEvent(c.Request.Context(), user.ID.String(), "magic_link_login", clientIP, c.Request.UserAgent(), "", true)
+
+	response.Success(c, authResponse(tokens, user))
+}
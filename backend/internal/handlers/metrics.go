@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/database"
+	"github.com/hamishgilbert/notes-app/backend/internal/metrics"
+	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// MetricsHandler serves gauges and counters for Prometheus to scrape, to holders of the metrics token
+type MetricsHandler struct {
+	hub   *websocket.Hub
+	db    *database.DB
+	token string
+}
+
+func NewMetricsHandler(hub *websocket.Hub, db *database.DB, token string) *MetricsHandler {
+	return &MetricsHandler{hub: hub, db: db, token: token}
+}
+
+// Serve writes the metrics in the Prometheus text format. Scrapers authenticate with
+// "Authorization: Bearer <METRICS_TOKEN>".
+func (h *MetricsHandler) Serve(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		response.Unauthorized(c, "invalid metrics token")
+		return
+	}
+
+	stats := h.hub.Stats()
+	var w metrics.Writer
+
+	w.Gauge("notes_ws_connections", "Open WebSocket connections.", float64(stats.Connections))
+	w.Gauge("notes_ws_users", "Users with at least one open WebSocket connection.", float64(stats.Users))
+	maxPerUser := 0
+	if len(stats.ConnectionsPerUser) > 0 {
+		maxPerUser = stats.ConnectionsPerUser[0].Connections
+	}
+	w.Gauge("notes_ws_max_connections_per_user", "Most WebSocket connections any one user has open.", float64(maxPerUser))
+
+	w.Family("notes_ws_connections_by_version", "gauge", "Open WebSocket connections by protocol version.")
+	versions := make([]int, 0, len(stats.ConnectionsByVersion))
+	for version := range stats.ConnectionsByVersion {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	for _, version := range versions {
+		w.Sample("notes_ws_connections_by_version", float64(stats.ConnectionsByVersion[version]),
+			metrics.Label{Name: "version", Value: strconv.Itoa(version)})
+	}
+	w.Gauge("notes_ws_binary_connections", "Open WebSocket connections using MessagePack.", float64(stats.BinaryConnections))
+
+	w.Counter("notes_ws_broadcasts_total", "Broadcasts delivered to this instance's connections.", float64(stats.Broadcasts))
+	w.Counter("notes_ws_messages_delivered_total", "Messages queued for WebSocket clients by broadcasts.", float64(stats.MessagesDelivered))
+	w.Counter("notes_ws_bytes_delivered_total", "Bytes queued for WebSocket clients by broadcasts, before compression.", float64(stats.BytesDelivered))
+	w.Counter("notes_ws_events_coalesced_total", "Events replaced by a later change to the same note before being sent.", float64(stats.EventsCoalesced))
+	w.Counter("notes_ws_messages_dropped_total", "Messages dropped because a client's send buffer was full.", float64(stats.DroppedMessages))
+	w.Counter("notes_ws_slow_disconnects_total", "Clients closed for falling too far behind.", float64(stats.SlowDisconnects))
+	w.Counter("notes_ws_rate_limit_disconnects_total", "Clients closed for sending messages too fast.", float64(stats.RateLimitDisconnects))
+	w.Counter("notes_ws_panics_total", "Panics recovered in the WebSocket hub and client connections.", float64(stats.Panics))
+	w.Counter("notes_ws_loop_restarts_total", "Restarts of the WebSocket hub's event loop.", float64(stats.LoopRestarts))
+
+	h.writeDatabase(c.Request.Context(), &w)
+
+	w.Gauge("go_goroutines", "Number of goroutines that currently exist.", float64(stats.Goroutines))
+
+	c.Data(http.StatusOK, metrics.ContentType, w.Bytes())
+}
+
+// writeDatabase adds the connection pool and a ping of the database
+func (h *MetricsHandler) writeDatabase(ctx context.Context, w *metrics.Writer) {
+	ctx, cancel := context.WithTimeout(ctx, readinessPingTimeout)
+	defer cancel()
+	latency, err := h.db.Ping(ctx)
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+	w.Gauge("notes_db_up", "Whether the database answered a ping.", up)
+	w.Gauge("notes_db_ping_seconds", "How long the database took to answer a ping.", latency.Seconds())
+
+	pool := h.db.Stats()
+	w.Gauge("notes_db_pool_acquired_connections", "Database connections in use.", float64(pool.Acquired))
+	w.Gauge("notes_db_pool_idle_connections", "Idle database connections.", float64(pool.Idle))
+	w.Gauge("notes_db_pool_total_connections", "Open database connections, including those being opened.", float64(pool.Total))
+	w.Gauge("notes_db_pool_max_connections", "Most database connections the pool will open.", float64(pool.Max))
+	w.Counter("notes_db_pool_acquires_total", "Database connections handed out.", float64(pool.Acquires))
+	w.Counter("notes_db_pool_empty_acquires_total", "Connections handed out after waiting for one to free up or open.", float64(pool.EmptyAcquires))
+	w.Counter("notes_db_pool_acquire_wait_seconds_total", "Time spent waiting for database connections.", pool.AcquireWait.Seconds())
+	w.Counter("notes_db_pool_canceled_acquires_total", "Waits for a database connection given up on.", float64(pool.CanceledAcquires))
+	w.Counter("notes_db_pool_new_connections_total", "Database connections opened.", float64(pool.NewConns))
+}
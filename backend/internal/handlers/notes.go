@@ -1,14 +1,18 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
 	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/pdf"
 	"github.com/hamishgilbert/notes-app/backend/internal/repository"
 	"github.com/hamishgilbert/notes-app/backend/internal/services"
 	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
@@ -16,22 +20,43 @@ import (
 )
 
 type NotesHandler struct {
-	noteRepo    *repository.NoteRepository
-	syncService *services.SyncService
-	wsHub       *websocket.Hub
+	noteRepo     *repository.NoteRepository
+	revisionRepo *repository.RevisionRepository
+	syncService  *services.SyncService
+	linkPreviews *services.LinkPreviewService
+	mentions     *services.MentionService
+	quotas       *services.QuotaService
+	wsHub        *websocket.Hub
 }
 
-func NewNotesHandler(noteRepo *repository.NoteRepository, syncService *services.SyncService, wsHub *websocket.Hub) *NotesHandler {
+func NewNotesHandler(noteRepo *repository.NoteRepository, revisionRepo *repository.RevisionRepository, syncService *services.SyncService, linkPreviews *services.LinkPreviewService, mentions *services.MentionService, quotas *services.QuotaService, wsHub *websocket.Hub) *NotesHandler {
 	return &NotesHandler{
-		noteRepo:    noteRepo,
-		syncService: syncService,
-		wsHub:       wsHub,
+		noteRepo:     noteRepo,
+		revisionRepo: revisionRepo,
+		syncService:  syncService,
+		linkPreviews: linkPreviews,
+		mentions:     mentions,
+		quotas:       quotas,
+		wsHub:        wsHub,
 	}
 }
 
 func (h *NotesHandler) List(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 
+	include := c.DefaultQuery("include", models.IncludeItems)
+	switch include {
+	case models.IncludeItems, models.IncludeCounts, models.IncludeNone:
+	default:
+		response.BadRequest(c, "include must be items, counts or none")
+		return
+	}
+
+	if asOfStr := c.Query("asOf"); asOfStr != "" {
+		h.listAsOf(c, userID, asOfStr, include)
+		return
+	}
+
 	var since *time.Time
 	if sinceStr := c.Query("since"); sinceStr != "" {
 		t, err := time.Parse(services.ISO8601Format, sinceStr)
@@ -40,7 +65,22 @@ func (h *NotesHandler) List(c *gin.Context) {
 		}
 	}
 
-	notes, err := h.noteRepo.GetAllByUserID(c.Request.Context(), userID, since)
+	lite := c.Query("lite") == "true"
+	if StreamsNotes(c) {
+		h.streamNotes(c, userID, since, include, lite)
+		return
+	}
+
+	ctx, staleness := h.noteRepo.CursorReads(c.Request.Context())
+	serverTimestamp := time.Now().Add(-staleness).UTC().Format(services.ISO8601Format)
+
+	var notes []models.Note
+	var err error
+	if include == models.IncludeItems {
+		notes, err = h.noteRepo.GetAllByUserID(ctx, userID, since)
+	} else {
+		notes, err = h.noteRepo.GetSummariesByUserID(ctx, userID, since)
+	}
 	if err != nil {
 		response.InternalError(c, "failed to fetch notes")
 		return
@@ -55,7 +95,12 @@ func (h *NotesHandler) List(c *gin.Context) {
 
 	noteDTOs := make([]models.NoteDTO, len(notes))
 	for i, note := range notes {
-		noteDTOs[i] = h.syncService.NoteToDTO(&note)
+		if lite {
+			noteDTOs[i] = h.syncService.NoteToLiteDTO(&note, since)
+		} else {
+			noteDTOs[i] = h.syncService.NoteToDTO(&note)
+		}
+		projectChecklist(&noteDTOs[i], include)
 	}
 
 	deletedIDStrings := make([]string, len(deletedIDs))
@@ -63,13 +108,81 @@ func (h *NotesHandler) List(c *gin.Context) {
 		deletedIDStrings[i] = id.String()
 	}
 
-	response.Success(c, models.SyncResponse{
+	successVersioned(c, models.SyncResponse{
 		Notes:           noteDTOs,
 		DeletedNoteIDs:  deletedIDStrings,
+		ServerTimestamp: serverTimestamp,
+	})
+}
+
+// listAsOf returns the user's notes as they were at a point in time, for recovering from accidental
+// edits. The result is read-only and built from note revisions, so it only reaches back as far as
+// the revisions kept for each note.
+func (h *NotesHandler) listAsOf(c *gin.Context, userID uuid.UUID, asOfStr, include string) {
+	asOf, err := time.Parse(services.ISO8601Format, asOfStr)
+	if err != nil {
+		if asOf, err = time.Parse(time.RFC3339, asOfStr); err != nil {
+			response.BadRequest(c, "asOf must be an ISO 8601 timestamp")
+			return
+		}
+	}
+	if c.Query("since") != "" {
+		response.BadRequest(c, "asOf can't be combined with since")
+		return
+	}
+	if asOf.After(time.Now()) {
+		response.BadRequest(c, "asOf can't be in the future")
+		return
+	}
+
+	notes, err := h.revisionRepo.GetAllAsOf(c.Request.Context(), userID, asOf)
+	if err != nil {
+		response.InternalError(c, "failed to fetch notes")
+		return
+	}
+
+	noteDTOs := make([]models.NoteDTO, len(notes))
+	for i, note := range notes {
+		if include == models.IncludeCounts {
+			note.ChecklistSummary = checklistSummary(note.ChecklistItems)
+		}
+		noteDTOs[i] = h.syncService.NoteToDTO(&note)
+		projectChecklist(&noteDTOs[i], include)
+	}
+
+	successVersioned(c, models.SyncResponse{
+		Notes:           noteDTOs,
+		DeletedNoteIDs:  []string{},
+		AsOf:            asOf.UTC().Format(services.ISO8601Format),
 		ServerTimestamp: time.Now().UTC().Format(services.ISO8601Format),
 	})
 }
 
+// projectChecklist leaves a listed note's checklist out as the include query asks. Notes listed
+// without their items are partial: saving one back as-is would delete them.
+func projectChecklist(dto *models.NoteDTO, include string) {
+	switch include {
+	case models.IncludeCounts:
+		dto.ChecklistItems = nil
+		dto.IsPartial = true
+	case models.IncludeNone:
+		dto.ChecklistItems = nil
+		dto.ChecklistSummary = nil
+		dto.IsPartial = true
+	}
+}
+
+// checklistSummary counts checklist items that were loaded in full
+func checklistSummary(items []models.ChecklistItem) *models.ChecklistSummary {
+	summary := &models.ChecklistSummary{Total: len(items)}
+	for _, item := range items {
+		if item.IsCompleted {
+			summary.Completed++
+		}
+	}
+	return summary
+}
+
 func (h *NotesHandler) Create(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 
@@ -80,7 +193,7 @@ func (h *NotesHandler) Create(c *gin.Context) {
 	}
 
 	// Validate input
-	if err := validateNoteDTO(&dto); err != nil {
+	if err := models.ValidateNoteDTO(&dto); err != nil {
 		response.BadRequest(c, err.Error())
 		return
 	}
@@ -104,6 +217,14 @@ func (h *NotesHandler) Create(c *gin.Context) {
 		response.BadRequest(c, "invalid note data")
 		return
 	}
+	h.syncService.StampNote(note)
+
+	if err := h.quotas.CheckSave(c.Request.Context(), note); err != nil {
+		if !quotaExceeded(c, err) {
+			response.InternalError(c, "failed to create note")
+		}
+		return
+	}
 
 	if err := h.noteRepo.Create(c.Request.Context(), note); err != nil {
 		response.InternalError(c, "failed to create note")
@@ -112,8 +233,14 @@ func (h *NotesHandler) Create(c *gin.Context) {
 
 	noteDTO := h.syncService.NoteToDTO(note)
 
+	// Unfurl any links in the background
+	h.linkPreviews.Enqueue(c.Request.Context(), userID, note.ID, note.Content)
+
+	// Notify newly mentioned collaborators in the background
+	h.mentions.Enqueue(c.Request.Context(), userID, note.ID)
+
 	// Broadcast to other connections
-	h.broadcastNoteChange(userID, websocket.MessageTypeNoteCreated, noteDTO)
+	h.broadcastNoteChange(c.Request.Context(), userID, websocket.MessageTypeNoteCreated, noteDTO, middleware.GetConnectionID(c), middleware.GetRequestID(c))
 
 	response.Created(c, noteDTO)
 }
@@ -156,38 +283,91 @@ func (h *NotesHandler) Update(c *gin.Context) {
 	}
 
 	// Validate input
-	if err := validateNoteDTO(&dto); err != nil {
+	if err := models.ValidateNoteDTO(&dto); err != nil {
 		response.BadRequest(c, err.Error())
 		return
 	}
 
+	// Older apps may leave the version out and save over whatever is there
+	if dto.Version == 0 && middleware.GetAPIVersion(c) >= services.APIVersionNoteVersions {
+		response.BadRequest(c, "version is required")
+		return
+	}
+
 	// Ensure ID matches URL
 	dto.ID = noteID.String()
 
+	noteDTO, err := h.saveNote(c.Request.Context(), userID, dto, middleware.GetConnectionID(c), middleware.GetRequestID(c))
+	if err != nil {
+		if errors.Is(err, errInvalidNoteData) {
+			response.BadRequest(c, "invalid note data")
+			return
+		}
+		if errors.Is(err, repository.ErrVersionConflict) {
+			c.JSON(http.StatusConflict, models.NoteConflictResponse{
+				Error:     "version_conflict",
+				Message:   "note was changed by another request",
+				Note:      noteDTO,
+				RequestID: middleware.GetRequestID(c),
+			})
+			return
+		}
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			response.NotFound(c, "note not found")
+			return
+		}
+		if quotaExceeded(c, err) {
+			return
+		}
+		response.InternalError(c, "failed to update note")
+		return
+	}
+
+	response.Success(c, noteDTO)
+}
+
+// errInvalidNoteData is returned by saveNote when a validated note still can't be converted
+var errInvalidNoteData = errors.New("invalid note data")
+
+// saveNote stores a validated update to an existing note, then unfurls its links, notifies mentioned
+// collaborators and broadcasts it to the user's connections other than excludeConnID. The note must
+// still be at dto.Version, if given; when it isn't, the current note is returned with
+// repository.ErrVersionConflict. A save past a storage quota gives a *services.QuotaExceededError.
+func (h *NotesHandler) saveNote(ctx context.Context, userID uuid.UUID, dto models.NoteDTO, excludeConnID, requestID string) (models.NoteDTO, error) {
 	// Update timestamp
 	dto.UpdatedAt = time.Now().UTC().Format(services.ISO8601Format)
 
 	note, err := h.syncService.DTOToNote(dto, userID)
 	if err != nil {
-		response.BadRequest(c, "invalid note data")
-		return
+		return models.NoteDTO{}, errInvalidNoteData
 	}
+	h.syncService.StampNote(note)
 
-	if err := h.noteRepo.Update(c.Request.Context(), note); err != nil {
-		if errors.Is(err, repository.ErrNoteNotFound) {
-			response.NotFound(c, "note not found")
-			return
+	if err := h.quotas.CheckSave(ctx, note); err != nil {
+		return models.NoteDTO{}, err
+	}
+
+	if err := h.noteRepo.Update(ctx, note, dto.Version); err != nil {
+		if errors.Is(err, repository.ErrVersionConflict) {
+			if current, getErr := h.noteRepo.GetByID(repository.ReadPrimary(ctx), note.ID, userID); getErr == nil {
+				return h.syncService.NoteToDTO(current), err
+			}
 		}
-		response.InternalError(c, "failed to update note")
-		return
+		return models.NoteDTO{}, err
 	}
 
 	noteDTO := h.syncService.NoteToDTO(note)
 
+	// Unfurl any links in the background
+	h.linkPreviews.Enqueue(ctx, userID, note.ID, note.Content)
+
+	// Notify newly mentioned collaborators in the background
+	h.mentions.Enqueue(ctx, userID, note.ID)
+
 	// Broadcast to other connections
-	h.broadcastNoteChange(userID, websocket.MessageTypeNoteUpdated, noteDTO)
+	h.broadcastNoteChange(ctx, userID, websocket.MessageTypeNoteUpdated, noteDTO, excludeConnID, requestID)
 
-	response.Success(c, noteDTO)
+	return noteDTO, nil
 }
 
 func (h *NotesHandler) Delete(c *gin.Context) {
@@ -199,7 +379,7 @@ func (h *NotesHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	if err := h.noteRepo.SoftDelete(c.Request.Context(), noteID, userID); err != nil {
+	if err := h.deleteNote(c.Request.Context(), userID, noteID, middleware.GetConnectionID(c), middleware.GetRequestID(c)); err != nil {
 		if errors.Is(err, repository.ErrNoteNotFound) {
 			response.NotFound(c, "note not found")
 			return
@@ -208,77 +388,91 @@ func (h *NotesHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	// Broadcast deletion to other connections
-	h.broadcastNoteDelete(userID, noteID.String())
-
 	response.NoContent(c)
 }
 
-// broadcastNoteChange sends a note created/updated message to all user's WebSocket connections
-func (h *NotesHandler) broadcastNoteChange(userID uuid.UUID, msgType websocket.MessageType, note models.NoteDTO) {
-	if h.wsHub == nil {
-		return
-	}
-
-	msg := websocket.WSMessage{
-		Type: msgType,
-		Payload: websocket.NoteChangePayload{
-			Note: note,
-		},
+// deleteNote moves a note to the trash and broadcasts the deletion to the user's connections other
+// than excludeConnID
+func (h *NotesHandler) deleteNote(ctx context.Context, userID, noteID uuid.UUID, excludeConnID, requestID string) error {
+	if err := h.noteRepo.SoftDelete(ctx, noteID, userID); err != nil {
+		return err
 	}
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return
-	}
-
-	h.wsHub.BroadcastToUser(userID, data, "")
+	// Broadcast deletion to other connections
+	h.broadcastNoteDelete(ctx, userID, noteID.String(), excludeConnID, requestID)
+	return nil
 }
 
-// broadcastNoteDelete sends a note deleted message to all user's WebSocket connections
-func (h *NotesHandler) broadcastNoteDelete(userID uuid.UUID, noteID string) {
-	if h.wsHub == nil {
+// ExportPDF renders a note as a PDF document.
+// Query parameters: paper=a4|letter (default a4), metadata=true to include timestamps and metadata.
+func (h *NotesHandler) ExportPDF(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
 		return
 	}
 
-	msg := websocket.WSMessage{
-		Type: websocket.MessageTypeNoteDeleted,
-		Payload: websocket.NoteDeletePayload{
-			NoteID: noteID,
-		},
+	pageSize := pdf.PageSizeA4
+	if paper := c.Query("paper"); paper != "" {
+		size, ok := pdf.ParsePageSize(paper)
+		if !ok {
+			response.BadRequest(c, "invalid paper size: must be 'a4' or 'letter'")
+			return
+		}
+		pageSize = size
 	}
 
-	data, err := json.Marshal(msg)
+	note, err := h.noteRepo.GetByID(c.Request.Context(), noteID, userID)
 	if err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			response.NotFound(c, "note not found")
+			return
+		}
+		response.InternalError(c, "failed to fetch note")
 		return
 	}
 
-	h.wsHub.BroadcastToUser(userID, data, "")
+	data := services.RenderNotePDF(note, services.NotePDFOptions{
+		PageSize:        pageSize,
+		IncludeMetadata: c.Query("metadata") == "true",
+	})
+
+	c.Header("Content-Disposition", `attachment; filename="`+pdfFilename(note)+`"`)
+	c.Data(http.StatusOK, "application/pdf", data)
 }
 
-// validateNoteDTO validates the note DTO fields for security
-func validateNoteDTO(dto *models.NoteDTO) error {
-	// Validate note type
-	if dto.NoteType != "" && !models.IsValidNoteType(dto.NoteType) {
-		return errors.New("invalid note type: must be 'text' or 'checklist'")
-	}
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._ -]+`)
 
-	// Validate title length
-	if len(dto.Title) > models.MaxTitleLength {
-		return errors.New("title exceeds maximum length of 500 characters")
+// pdfFilename builds a header-safe file name from the note title
+func pdfFilename(note *models.Note) string {
+	name := strings.TrimSpace(unsafeFilenameChars.ReplaceAllString(note.Title, ""))
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	if name == "" {
+		name = "note-" + note.ID.String()
 	}
+	return name + ".pdf"
+}
 
-	// Validate content length
-	if len(dto.Content) > models.MaxContentLength {
-		return errors.New("content exceeds maximum length of 100000 characters")
+// broadcastNoteChange sends a note created/updated message to all user's WebSocket connections except the sender,
+// tagged with the originating request ID
+func (h *NotesHandler) broadcastNoteChange(ctx context.Context, userID uuid.UUID, msgType websocket.MessageType, note models.NoteDTO, excludeConnID, requestID string) {
+	if h.wsHub == nil {
+		return
 	}
 
-	// Validate checklist items
-	for _, item := range dto.ChecklistItems {
-		if len(item.Text) > models.MaxItemTextLength {
-			return errors.New("checklist item text exceeds maximum length of 1000 characters")
-		}
+	h.wsHub.BroadcastEvent(ctx, userID, msgType, websocket.NoteChangePayload{Note: note}, excludeConnID, requestID)
+}
+
+// broadcastNoteDelete sends a note deleted message to all user's WebSocket connections except the sender,
+// tagged with the originating request ID
+func (h *NotesHandler) broadcastNoteDelete(ctx context.Context, userID uuid.UUID, noteID, excludeConnID, requestID string) {
+	if h.wsHub == nil {
+		return
 	}
 
-	return nil
+	h.wsHub.BroadcastEvent(ctx, userID, websocket.MessageTypeNoteDeleted, websocket.NoteDeletePayload{NoteID: noteID}, excludeConnID, requestID)
 }
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+const (
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+type NotificationHandler struct {
+	mentionService *services.MentionService
+	dispatcher     *services.NotificationDispatcher
+}
+
+func NewNotificationHandler(mentionService *services.MentionService, dispatcher *services.NotificationDispatcher) *NotificationHandler {
+	return &NotificationHandler{
+		mentionService: mentionService,
+		dispatcher:     dispatcher,
+	}
+}
+
+// List returns the current user's notifications, newest first.
+// Query parameters: unread=true to only return unread notifications, limit (default 50, max 200).
+func (h *NotificationHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	limit := defaultNotificationLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxNotificationLimit {
+			response.BadRequest(c, "limit must be between 1 and 200")
+			return
+		}
+		limit = n
+	}
+
+	notifications, err := h.mentionService.Notifications(c.Request.Context(), userID, c.Query("unread") == "true", limit)
+	if err != nil {
+		response.InternalError(c, "failed to fetch notifications")
+		return
+	}
+
+	notificationDTOs := make([]models.NotificationDTO, len(notifications))
+	for i, notification := range notifications {
+		notificationDTOs[i] = h.dispatcher.NotificationToDTO(&notification)
+	}
+
+	response.Success(c, notificationDTOs)
+}
+
+// MarkRead marks a notification as read
+func (h *NotificationHandler) MarkRead(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	notificationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid notification ID")
+		return
+	}
+
+	if err := h.mentionService.MarkRead(c.Request.Context(), userID, notificationID); err != nil {
+		if errors.Is(err, repository.ErrNotificationNotFound) {
+			response.NotFound(c, "notification not found")
+			return
+		}
+		response.InternalError(c, "failed to update notification")
+		return
+	}
+
+	response.NoContent(c)
+}
+
+// MarkAllRead marks all of the current user's notifications as read
+func (h *NotificationHandler) MarkAllRead(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	if err := h.mentionService.MarkAllRead(c.Request.Context(), userID); err != nil {
+		response.InternalError(c, "failed to update notifications")
+		return
+	}
+
+	response.NoContent(c)
+}
+
+// GetPreferences returns the channels each category of notification is delivered on
+func (h *NotificationHandler) GetPreferences(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	prefs, err := h.dispatcher.Preferences(c.Request.Context(), userID)
+	if err != nil {
+		response.InternalError(c, "failed to fetch notification preferences")
+		return
+	}
+
+	response.Success(c, prefs)
+}
+
+// UpdatePreferences changes the channels for the categories in the request
+func (h *NotificationHandler) UpdatePreferences(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.NotificationPreferencesDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request body")
+		return
+	}
+
+	prefs, err := h.dispatcher.UpdatePreferences(c.Request.Context(), userID, &req)
+	if err != nil {
+		response.InternalError(c, "failed to update notification preferences")
+		return
+	}
+
+	response.Success(c, prefs)
+}
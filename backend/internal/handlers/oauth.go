@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// OAuthHandler serves Sign in with Apple and Google, and the accounts linked with them
+type OAuthHandler struct {
+	oauthService *services.OAuthService
+}
+
+func NewOAuthHandler(oauthService *services.OAuthService) *OAuthHandler {
+	return &OAuthHandler{oauthService: oauthService}
+}
+
+// Providers lists the providers users can sign in with
+func (h *OAuthHandler) Providers(c *gin.Context) {
+	response.Success(c, h.oauthService.Providers())
+}
+
+// Start sends the user to the provider to sign in
+func (h *OAuthHandler) Start(c *gin.Context) {
+	url, err := h.oauthService.Start(c.Request.Context(), c.Param("provider"), c.Query("returnTo"), nil)
+	if err != nil {
+		h.startError(c, err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, url)
+}
+
+// Link returns where to send the current user to link a provider account to theirs
+func (h *OAuthHandler) Link(c *gin.Context) {
+	var req models.OAuthLinkRequest
+	_ = c.ShouldBindJSON(&req) // Optional body
+
+	userID := middleware.GetUserID(c)
+	url, err := h.oauthService.Start(c.Request.Context(), c.Param("provider"), req.ReturnTo, &userID)
+	if err != nil {
+		h.startError(c, err)
+		return
+	}
+
+	response.Success(c, models.OAuthURLResponse{URL: url})
+}
+
+func (h *OAuthHandler) startError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownProvider):
+		response.NotFound(c, "sign-in provider not configured")
+	case errors.Is(err, services.ErrReturnURLNotAllowed):
+		response.BadRequest(c, "returnTo is not an allowed return URL")
+	default:
+		response.InternalError(c, "failed to start sign-in")
+	}
+}
+
+// Callback is where the provider sends the user back to. Apple posts a form; Google redirects.
+func (h *OAuthHandler) Callback(c *gin.Context) {
+	param := func(key string) string {
+		if value := c.PostForm(key); value != "" {
+			return value
+		}
+		return c.Query(key)
+	}
+
+	url, err := h.oauthService.Callback(c.Request.Context(), c.Param("provider"), param("state"), param("code"), param("error"), c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownProvider):
+			response.NotFound(c, "sign-in provider not configured")
+		case errors.Is(err, services.ErrOAuthStateExpired):
+			c.Redirect(http.StatusFound, h.oauthService.ExpiredReturnURL())
+		default:
+			response.InternalError(c, "failed to sign in")
+		}
+		return
+	}
+
+	// 303 turns Apple's form post into a GET of the return URL
+	c.Redirect(http.StatusSeeOther, url)
+}
+
+// Exchange trades the code the app was sent back with for tokens
+func (h *OAuthHandler) Exchange(c *gin.Context) {
+	var req models.OAuthExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request: code is required")
+		return
+	}
+
+	clientIP := c.ClientIP()
+	user, tokens, err := h.oauthService.Exchange(c.Request.Context(), req.Code, clientIP)
+	h.loginResult(c, clientIP, user, tokens, err)
+}
+
+// Token signs in with an ID token a native app got from the provider's SDK
+func (h *OAuthHandler) Token(c *gin.Context) {
+	var req models.OAuthTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request: idToken and nonce are required")
+		return
+	}
+
+	clientIP := c.ClientIP()
+	user, tokens, err := h.oauthService.LoginWithIDToken(c.Request.Context(), c.Param("provider"), req.IDToken, req.Nonce, clientIP)
+	h.loginResult(c, clientIP, user, tokens, err)
+}
+
+func (h *OAuthHandler) loginResult(c *gin.Context, clientIP string, user *models.User, tokens *services.TokenPair, err error) {
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownProvider):
+			response.NotFound(c, "sign-in provider not configured")
+		case errors.Is(err, services.ErrOAuthFailed), errors.Is(err, services.ErrOAuthLoginCodeExpired):
+			// Record failed attempt for rate limiting
+			if al, exists := c.Get("authRateLimiter"); exists {
+				al.(*middleware.AuthRateLimiter).RecordFailedAttempt(c.Request.Context(), clientIP)
+			}
+			response.Unauthorized(c, err.Error())
+		case errors.Is(err, services.ErrIdentityLinked):
+			response.Conflict(c, "provider account is linked to another user")
+		case errors.Is(err, services.ErrRegistrationClosed):
+			response.Forbidden(c, "registration is closed")
+		case errors.Is(err, services.ErrEmailNotVerified):
+			response.Forbidden(c, "email address not verified; check your email for the verification link")
+		default:
+			response.InternalError(c, "failed to login")
+		}
+		return
+	}
+
+	// Reset failed attempts on successful login
+	if al, exists := c.Get("authRateLimiter"); exists {
+		al.(*middleware.AuthRateLimiter).ResetFailedAttempts(c.Request.Context(), clientIP)
+	}
+
+	response.Success(c, authResponse(tokens, user))
+}
+
+// ListIdentities returns the provider accounts linked to the current user
+func (h *OAuthHandler) ListIdentities(c *gin.Context) {
+	identities, err := h.oauthService.Identities(c.Request.Context(), middleware.GetUserID(c))
+	if err != nil {
+		response.InternalError(c, "failed to fetch linked accounts")
+		return
+	}
+
+	dtos := make([]models.UserIdentityDTO, len(identities))
+	for i := range identities {
+		dtos[i] = services.UserIdentityToDTO(&identities[i])
+	}
+	response.Success(c, dtos)
+}
+
+// DeleteIdentity unlinks one of the current user's provider accounts
+func (h *OAuthHandler) DeleteIdentity(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid linked account ID")
+		return
+	}
+
+	if err := h.oauthService.Unlink(c.Request.Context(), middleware.GetUserID(c), id, c.ClientIP()); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrIdentityNotFound):
+			response.NotFound(c, "linked account not found")
+		case errors.Is(err, services.ErrLastSignInMethod):
+			response.BadRequest(c, "set a password or add a passkey before unlinking your only sign-in method")
+		default:
+			response.InternalError(c, "failed to unlink account")
+		}
+		return
+	}
+
+	response.NoContent(c)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type OrderingHandler struct {
+	orderingService *services.OrderingService
+	wsHub           *websocket.Hub
+}
+
+func NewOrderingHandler(orderingService *services.OrderingService, wsHub *websocket.Hub) *OrderingHandler {
+	return &OrderingHandler{
+		orderingService: orderingService,
+		wsHub:           wsHub,
+	}
+}
+
+// Get returns the manual note order of the context given by the "context" query parameter (default "all")
+func (h *OrderingHandler) Get(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	orderingContext := c.DefaultQuery("context", models.OrderingContextAll)
+
+	ids, err := h.orderingService.GetOrder(c.Request.Context(), userID, orderingContext)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidOrderingContext) {
+			response.BadRequest(c, err.Error())
+			return
+		}
+		response.InternalError(c, "failed to fetch note order")
+		return
+	}
+
+	response.Success(c, newNoteOrderDTO(orderingContext, ids))
+}
+
+// Set replaces the manual note order of a context
+func (h *OrderingHandler) Set(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.NoteOrderDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request body")
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(req.NoteIDs))
+	seen := make(map[uuid.UUID]bool, len(req.NoteIDs))
+	for _, idStr := range req.NoteIDs {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			response.BadRequest(c, "invalid note ID: "+idStr)
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if err := h.orderingService.SetOrder(c.Request.Context(), userID, req.Context, ids); err != nil {
+		if errors.Is(err, services.ErrInvalidOrderingContext) {
+			response.BadRequest(c, err.Error())
+			return
+		}
+		response.InternalError(c, "failed to update note order")
+		return
+	}
+
+	// Return the stored order, which drops IDs that aren't the user's notes
+	stored, err := h.orderingService.GetOrder(c.Request.Context(), userID, req.Context)
+	if err != nil {
+		response.InternalError(c, "failed to fetch note order")
+		return
+	}
+	order := newNoteOrderDTO(req.Context, stored)
+
+	h.broadcastOrder(c.Request.Context(), userID, order, middleware.GetConnectionID(c), middleware.GetRequestID(c))
+
+	response.Success(c, order)
+}
+
+// broadcastOrder sends the new order of a context to the user's other connections
+func (h *OrderingHandler) broadcastOrder(ctx context.Context, userID uuid.UUID, order models.NoteOrderDTO, excludeConnID, requestID string) {
+	if h.wsHub == nil {
+		return
+	}
+
+	h.wsHub.BroadcastEvent(ctx, userID, websocket.MessageTypeNoteOrder, websocket.NoteOrderPayload{Order: order}, excludeConnID, requestID)
+}
+
+func newNoteOrderDTO(orderingContext string, ids []uuid.UUID) models.NoteOrderDTO {
+	noteIDs := make([]string, len(ids))
+	for i, id := range ids {
+		noteIDs[i] = id.String()
+	}
+	return models.NoteOrderDTO{Context: orderingContext, NoteIDs: noteIDs}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type PositionHandler struct {
+	positionService *services.PositionService
+	wsHub           *websocket.Hub
+}
+
+func NewPositionHandler(positionService *services.PositionService, wsHub *websocket.Hub) *PositionHandler {
+	return &PositionHandler{
+		positionService: positionService,
+		wsHub:           wsHub,
+	}
+}
+
+// Get returns the user's reading position in a note
+func (h *PositionHandler) Get(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+
+	position, err := h.positionService.Get(c.Request.Context(), noteID, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrPositionNotFound) {
+			response.NotFound(c, err.Error())
+			return
+		}
+		response.InternalError(c, "failed to fetch reading position")
+		return
+	}
+
+	response.Success(c, position)
+}
+
+// Set saves the user's reading position in a note and sends it to their other connections
+func (h *PositionHandler) Set(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+
+	var req models.NotePositionDTO
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request body")
+		return
+	}
+	if err := models.ValidateNotePositionDTO(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	position, err := h.positionService.Set(c.Request.Context(), noteID, userID, middleware.GetDeviceID(c), &req)
+	if err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			response.NotFound(c, "note not found")
+			return
+		}
+		response.InternalError(c, "failed to save reading position")
+		return
+	}
+
+	h.broadcastPosition(c.Request.Context(), userID, *position, middleware.GetConnectionID(c), middleware.GetRequestID(c))
+
+	response.Success(c, position)
+}
+
+// broadcastPosition sends a new reading position to the user's other connections
+func (h *PositionHandler) broadcastPosition(ctx context.Context, userID uuid.UUID, position models.NotePositionDTO, excludeConnID, requestID string) {
+	if h.wsHub == nil {
+		return
+	}
+
+	h.wsHub.BroadcastEvent(ctx, userID, websocket.MessageTypeNotePosition, websocket.NotePositionPayload{Position: position}, excludeConnID, requestID)
+}
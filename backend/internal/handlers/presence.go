@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	ws "github.com/hamishgilbert/notes-app/backend/internal/websocket"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// PresenceHandler reports which of the user's devices are connected
+type PresenceHandler struct {
+	hub *ws.Hub
+}
+
+func NewPresenceHandler(hub *ws.Hub) *PresenceHandler {
+	return &PresenceHandler{hub: hub}
+}
+
+// Get lists the user's open WebSocket connections and how many devices they come from
+func (h *PresenceHandler) Get(c *gin.Context) {
+	response.Success(c, h.hub.Presence(middleware.GetUserID(c)))
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type RevisionHandler struct {
+	revisionService *services.RevisionService
+}
+
+func NewRevisionHandler(revisionService *services.RevisionService) *RevisionHandler {
+	return &RevisionHandler{revisionService: revisionService}
+}
+
+// List returns a note's saved revisions, newest first
+func (h *RevisionHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+
+	revisions, err := h.revisionService.List(c.Request.Context(), userID, noteID)
+	if err != nil {
+		response.InternalError(c, "failed to fetch revisions")
+		return
+	}
+
+	response.Success(c, revisions)
+}
+
+// Diff returns a line- and word-level diff between a revision and the one given by "against"
+// (default: the revision before it)
+func (h *RevisionHandler) Diff(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+	revID, err := uuid.Parse(c.Param("rev"))
+	if err != nil {
+		response.BadRequest(c, "invalid revision ID")
+		return
+	}
+	var againstID *uuid.UUID
+	if againstStr := c.Query("against"); againstStr != "" {
+		id, err := uuid.Parse(againstStr)
+		if err != nil {
+			response.BadRequest(c, "invalid against revision ID")
+			return
+		}
+		againstID = &id
+	}
+
+	result, err := h.revisionService.Diff(c.Request.Context(), userID, noteID, revID, againstID)
+	if err != nil {
+		if errors.Is(err, repository.ErrRevisionNotFound) {
+			response.NotFound(c, "revision not found")
+			return
+		}
+		response.InternalError(c, "failed to diff revisions")
+		return
+	}
+
+	response.Success(c, result)
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/apischema"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type SchemaHandler struct{}
+
+func NewSchemaHandler() *SchemaHandler {
+	return &SchemaHandler{}
+}
+
+// OpenAPI serves the OpenAPI document used to generate the Swift and TypeScript clients
+func (h *SchemaHandler) OpenAPI(c *gin.Context) {
+	c.Header("ETag", `"`+apischema.Hash()+`"`)
+	c.Data(http.StatusOK, "application/json", apischema.Document())
+}
+
+// Version reports the API version and schema hash so clients can detect that they were generated
+// from a different schema than the server is running
+func (h *SchemaHandler) Version(c *gin.Context) {
+	response.Success(c, models.SchemaVersionResponse{
+		Version: apischema.APIVersion,
+		Hash:    apischema.Hash(),
+	})
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 100
+
+	// maxSearchQueryLength is the longest query accepted, in bytes
+	maxSearchQueryLength = 200
+)
+
+// Search finds the user's notes matching q, best matches first. With fuzzy=true titles are matched
+// by similarity, so typos and partial words still find them; otherwise every word of q must appear
+// in the title or content.
+func (h *NotesHandler) Search(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		response.BadRequest(c, "q is required")
+		return
+	}
+	if len(query) > maxSearchQueryLength {
+		response.BadRequest(c, "q must be at most 200 bytes")
+		return
+	}
+
+	limit := defaultSearchLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			response.BadRequest(c, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	notes, err := h.noteRepo.Search(c.Request.Context(), userID, query, c.Query("fuzzy") == "true", limit)
+	if err != nil {
+		response.InternalError(c, "failed to search notes")
+		return
+	}
+
+	noteDTOs := make([]models.NoteDTO, len(notes))
+	for i := range notes {
+		noteDTOs[i] = h.syncService.NoteToDTO(&notes[i])
+	}
+	successVersioned(c, noteDTOs)
+}
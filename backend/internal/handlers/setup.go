@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// SetupHandler serves the first-run setup of a new server
+type SetupHandler struct {
+	instanceService *services.InstanceService
+}
+
+func NewSetupHandler(instanceService *services.InstanceService) *SetupHandler {
+	return &SetupHandler{instanceService: instanceService}
+}
+
+// Status reports whether the server still needs setting up
+func (h *SetupHandler) Status(c *gin.Context) {
+	status, err := h.instanceService.Status(c.Request.Context())
+	if err != nil {
+		response.InternalError(c, "failed to fetch setup status")
+		return
+	}
+
+	response.Success(c, status)
+}
+
+// Setup creates the first administrator with the setup token from the server log
+func (h *SetupHandler) Setup(c *gin.Context) {
+	var req models.SetupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request: token is required, username must be 3-50 alphanumeric characters. "+h.instanceService.PasswordRequirements())
+		return
+	}
+
+	clientIP := c.ClientIP()
+	user, tokens, err := h.instanceService.CompleteSetup(c.Request.Context(), &req, clientIP)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSetupTokenInvalid):
+			// Record failed attempt for rate limiting
+			if al, exists := c.Get("authRateLimiter"); exists {
+				al.(*middleware.AuthRateLimiter).RecordFailedAttempt(c.Request.Context(), clientIP)
+			}
+			response.Forbidden(c, "setup token invalid or setup already completed")
+		case errors.Is(err, services.ErrWeakPassword):
+			response.BadRequest(c, err.Error())
+		case errors.Is(err, services.ErrBreachedPassword):
+			response.BadRequest(c, "this password has appeared in a data breach; choose a different one")
+		case errors.Is(err, services.ErrUserExists):
+			response.Conflict(c, "username already exists")
+		case errors.Is(err, services.ErrEmailExists):
+			response.Conflict(c, "email already in use")
+		default:
+			response.InternalError(c, "failed to complete setup")
+		}
+		return
+	}
+
+	response.Created(c, authResponse(tokens, user))
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type ShareHandler struct {
+	shareService *services.ShareService
+	syncService  *services.SyncService
+}
+
+func NewShareHandler(shareService *services.ShareService, syncService *services.SyncService) *ShareHandler {
+	return &ShareHandler{
+		shareService: shareService,
+		syncService:  syncService,
+	}
+}
+
+// CreateInvite emails an invitation to collaborate on a note
+func (h *ShareHandler) CreateInvite(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+
+	var req models.InviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "a valid email address is required")
+		return
+	}
+
+	invite, err := h.shareService.CreateInvite(c.Request.Context(), userID, noteID, req.Email)
+	if err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			response.NotFound(c, "note not found")
+			return
+		}
+		if errors.Is(err, services.ErrInviteDelivery) {
+			response.InternalError(c, "invite created but the email could not be sent; try resending it")
+			return
+		}
+		response.InternalError(c, "failed to create invite")
+		return
+	}
+
+	response.Created(c, h.shareService.InviteToDTO(invite))
+}
+
+// ListInvites lists the invitations for a note
+func (h *ShareHandler) ListInvites(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+
+	invites, err := h.shareService.ListInvites(c.Request.Context(), userID, noteID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			response.NotFound(c, "note not found")
+			return
+		}
+		response.InternalError(c, "failed to fetch invites")
+		return
+	}
+
+	inviteDTOs := make([]models.InviteDTO, len(invites))
+	for i := range invites {
+		inviteDTOs[i] = h.shareService.InviteToDTO(&invites[i])
+	}
+
+	response.Success(c, inviteDTOs)
+}
+
+// ResendInvite emails a pending invitation again and extends its expiry
+func (h *ShareHandler) ResendInvite(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+	inviteID, err := uuid.Parse(c.Param("inviteId"))
+	if err != nil {
+		response.BadRequest(c, "invalid invite ID")
+		return
+	}
+
+	invite, err := h.shareService.ResendInvite(c.Request.Context(), userID, noteID, inviteID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNoteNotFound):
+			response.NotFound(c, "note not found")
+		case errors.Is(err, repository.ErrInviteNotFound):
+			response.NotFound(c, "invite not found")
+		case errors.Is(err, services.ErrInviteAccepted):
+			response.Conflict(c, "invite has already been accepted")
+		case errors.Is(err, services.ErrInviteDelivery):
+			response.InternalError(c, "failed to send invite email")
+		default:
+			response.InternalError(c, "failed to resend invite")
+		}
+		return
+	}
+
+	response.Success(c, h.shareService.InviteToDTO(invite))
+}
+
+// RevokeInvite deletes an invitation so its link stops working
+func (h *ShareHandler) RevokeInvite(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+	inviteID, err := uuid.Parse(c.Param("inviteId"))
+	if err != nil {
+		response.BadRequest(c, "invalid invite ID")
+		return
+	}
+
+	if err := h.shareService.RevokeInvite(c.Request.Context(), userID, noteID, inviteID); err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			response.NotFound(c, "note not found")
+			return
+		}
+		if errors.Is(err, repository.ErrInviteNotFound) {
+			response.NotFound(c, "invite not found")
+			return
+		}
+		response.InternalError(c, "failed to revoke invite")
+		return
+	}
+
+	response.NoContent(c)
+}
+
+// AcceptInvite grants the current user access to the note an invite link points to
+func (h *ShareHandler) AcceptInvite(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.AcceptInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "token is required")
+		return
+	}
+
+	invite, err := h.shareService.AcceptInvite(c.Request.Context(), req.Token, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidInvite):
+			response.BadRequest(c, "invalid or expired invite")
+		case errors.Is(err, services.ErrInviteAccepted):
+			response.Conflict(c, "invite has already been accepted")
+		case errors.Is(err, services.ErrInviteOwnNote):
+			response.BadRequest(c, "you cannot accept an invite to your own note")
+		default:
+			response.InternalError(c, "failed to accept invite")
+		}
+		return
+	}
+
+	note, err := h.shareService.SharedNote(c.Request.Context(), invite.NoteID, userID)
+	if err != nil {
+		response.NotFound(c, "shared note not found")
+		return
+	}
+
+	response.Success(c, h.syncService.NoteToDTO(note))
+}
+
+// ListShared returns the notes other users have shared with the current user
+func (h *ShareHandler) ListShared(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	notes, err := h.shareService.SharedNotes(c.Request.Context(), userID)
+	if err != nil {
+		response.InternalError(c, "failed to fetch shared notes")
+		return
+	}
+
+	noteDTOs := make([]models.NoteDTO, len(notes))
+	for i, note := range notes {
+		noteDTOs[i] = h.syncService.NoteToDTO(&note)
+	}
+
+	response.Success(c, noteDTOs)
+}
+
+// GetShared returns a single note shared with the current user
+func (h *ShareHandler) GetShared(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	noteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid note ID")
+		return
+	}
+
+	note, err := h.shareService.SharedNote(c.Request.Context(), noteID, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			response.NotFound(c, "note not found")
+			return
+		}
+		response.InternalError(c, "failed to fetch note")
+		return
+	}
+
+	response.Success(c, h.syncService.NoteToDTO(note))
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// Large note lists and archives can be streamed: they're written as they're read from the database
+// instead of being built in memory first. The status is sent before the first record, so an error
+// part-way through can only cut the response short; each format is laid out so that a cut-short
+// response can't be mistaken for a whole one.
+
+// MIMENDJSON is newline-delimited JSON, one value per line
+const MIMENDJSON = "application/x-ndjson"
+
+// streamFlushSize is how much a streamed response buffers before flushing it to the client
+const streamFlushSize = 32 << 10
+
+// flushWriter flushes the response every streamFlushSize bytes, so clients get streamed records
+// steadily without a flush per record
+type flushWriter struct {
+	w       gin.ResponseWriter
+	pending int
+}
+
+func newFlushWriter(w gin.ResponseWriter) *flushWriter {
+	return &flushWriter{w: w}
+}
+
+func (fw *flushWriter) Write(b []byte) (int, error) {
+	n, err := fw.w.Write(b)
+	fw.pending += n
+	if fw.pending >= streamFlushSize {
+		fw.w.Flush()
+		fw.pending = 0
+	}
+	return n, err
+}
+
+// Flush sends anything still buffered
+func (fw *flushWriter) Flush() {
+	fw.w.Flush()
+	fw.pending = 0
+}
+
+// clearDeadlines lifts the server's read and write timeouts for the rest of the request, for
+// uploads and downloads that take as long as the client's connection needs
+func clearDeadlines(c *gin.Context) {
+	rc := http.NewResponseController(c.Writer)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
+
+// wantsNDJSON reports whether the client asked for newline-delimited JSON
+func wantsNDJSON(c *gin.Context) bool {
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		mime, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mime), MIMENDJSON) {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamsNotes reports whether a GET /api/notes request gets its listing streamed, with
+// stream=true or by accepting NDJSON, rather than built in memory
+func StreamsNotes(c *gin.Context) bool {
+	return c.Request.Method == http.MethodGet && c.FullPath() == "/api/notes" &&
+		(c.Query("stream") == "true" || wantsNDJSON(c)) && !wantsMsgPack(c)
+}
+
+// errStreamLayout means the listing envelope didn't encode as expected
+var errStreamLayout = errors.New("unexpected listing layout")
+
+// streamNotes writes the user's notes as GET /api/notes does, one at a time from the database. As
+// JSON, the response is the usual SyncResponse; as NDJSON, each line is a NoteStreamLine with a
+// note, and the last carries the deleted note IDs and server timestamp. serverTimestamp is taken
+// before the notes are read, so nothing changed while streaming is missed on the next sync.
+func (h *NotesHandler) streamNotes(c *gin.Context, userID uuid.UUID, since *time.Time, include string, lite bool) {
+	ctx := c.Request.Context()
+	serverTimestamp := time.Now().UTC().Format(services.ISO8601Format)
+
+	deletedIDs, err := h.noteRepo.GetDeletedSince(ctx, userID, since)
+	if err != nil {
+		response.InternalError(c, "failed to fetch deleted notes")
+		return
+	}
+	deletedIDStrings := make([]string, len(deletedIDs))
+	for i, id := range deletedIDs {
+		deletedIDStrings[i] = id.String()
+	}
+
+	toDTO := func(note *models.Note) models.NoteDTO {
+		var dto models.NoteDTO
+		if lite {
+			dto = h.syncService.NoteToLiteDTO(note, since)
+		} else {
+			dto = h.syncService.NoteToDTO(note)
+		}
+		projectChecklist(&dto, include)
+		return dto
+	}
+
+	// Long listings take longer to stream than the server's write timeout allows
+	clearDeadlines(c)
+
+	w := newFlushWriter(c.Writer)
+	if wantsNDJSON(c) {
+		c.Header("Content-Type", MIMENDJSON)
+		c.Status(http.StatusOK)
+		err = h.noteRepo.EachByUserID(ctx, userID, since, include == models.IncludeItems, func(note *models.Note) error {
+			dto := toDTO(note)
+			return writeNDJSON(w, models.NoteStreamLine{Note: &dto})
+		})
+		if err == nil {
+			err = writeNDJSON(w, models.NoteStreamLine{DeletedNoteIDs: deletedIDStrings, ServerTimestamp: serverTimestamp})
+		}
+	} else {
+		// Encode the listing without notes, in the request's API version, and write the notes into it
+		var before, after []byte
+		before, after, err = h.listingEnvelope(c, models.SyncResponse{
+			Notes:           []models.NoteDTO{},
+			DeletedNoteIDs:  deletedIDStrings,
+			ServerTimestamp: serverTimestamp,
+		})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to encode note listing", "error", err)
+			response.InternalError(c, "failed to encode response")
+			return
+		}
+
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.Status(http.StatusOK)
+		first := true
+		err = h.noteRepo.EachByUserID(ctx, userID, since, include == models.IncludeItems, func(note *models.Note) error {
+			translated, err := services.TranslateDTO(toDTO(note), middleware.GetAPIVersion(c))
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(translated)
+			if err != nil {
+				return err
+			}
+			if first {
+				data = append(before, data...)
+				first = false
+			} else {
+				data = append([]byte{','}, data...)
+			}
+			_, err = w.Write(data)
+			return err
+		})
+		if err == nil {
+			if first {
+				after = append(before, after...)
+			}
+			_, err = w.Write(after)
+		}
+	}
+
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			response.InternalError(c, "failed to fetch notes")
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "Streamed note listing was cut short", "error", err)
+		return
+	}
+	w.Flush()
+}
+
+// listingEnvelope encodes an empty listing and splits it where its notes go, returning what comes
+// before the first note and what comes after the last
+func (h *NotesHandler) listingEnvelope(c *gin.Context, empty models.SyncResponse) ([]byte, []byte, error) {
+	translated, err := services.TranslateDTO(empty, middleware.GetAPIVersion(c))
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := json.Marshal(translated)
+	if err != nil {
+		return nil, nil, err
+	}
+	const notesField = `"notes":[`
+	i := bytes.Index(data, []byte(notesField+"]"))
+	if i < 0 {
+		return nil, nil, errStreamLayout
+	}
+	i += len(notesField)
+	return data[:i], data[i:], nil
+}
+
+// writeNDJSON writes v as one line of newline-delimited JSON
+func writeNDJSON(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
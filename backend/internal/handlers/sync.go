@@ -1,26 +1,42 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
 	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
 	"github.com/hamishgilbert/notes-app/backend/internal/services"
 	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
 	"github.com/hamishgilbert/notes-app/backend/pkg/response"
 )
 
+const (
+	defaultSyncBatchLimit = 50
+	maxSyncBatchLimit     = 200
+)
+
 type SyncHandler struct {
-	syncService *services.SyncService
-	wsHub       *websocket.Hub
+	syncService  *services.SyncService
+	linkPreviews *services.LinkPreviewService
+	mentions     *services.MentionService
+	devices      *services.DeviceService
+	wsHub        *websocket.Hub
 }
 
-func NewSyncHandler(syncService *services.SyncService, wsHub *websocket.Hub) *SyncHandler {
+func NewSyncHandler(syncService *services.SyncService, linkPreviews *services.LinkPreviewService, mentions *services.MentionService, devices *services.DeviceService, wsHub *websocket.Hub) *SyncHandler {
 	return &SyncHandler{
-		syncService: syncService,
-		wsHub:       wsHub,
+		syncService:  syncService,
+		linkPreviews: linkPreviews,
+		mentions:     mentions,
+		devices:      devices,
+		wsHub:        wsHub,
 	}
 }
 
@@ -28,67 +44,212 @@ func (h *SyncHandler) Sync(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 
 	var req models.SyncRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindBody(c, &req); err != nil {
 		response.BadRequest(c, "invalid request body")
 		return
 	}
+	if c.Query("lite") == "true" {
+		req.Lite = true
+	}
 
-	// Get the connection ID from context to exclude sender from broadcasts
-	connectionID, _ := c.Get("ws_connection_id")
-	connID, _ := connectionID.(string)
+	// Revoked devices can't sync
+	deviceID := middleware.GetDeviceID(c)
+	if deviceID != "" {
+		if err := h.devices.CheckCanSync(c.Request.Context(), userID, deviceID); err != nil {
+			if errors.Is(err, repository.ErrDeviceRevoked) {
+				response.Forbidden(c, "device has been revoked")
+				return
+			}
+			response.InternalError(c, "sync failed")
+			return
+		}
+	}
+
+	// Reading positions are attributed to the sending device, whatever the client says
+	for i := range req.Positions {
+		req.Positions[i].DeviceID = deviceID
+	}
+
+	// A dry run reports what would happen without applying anything
+	if c.Query("dryRun") == "true" {
+		report, err := h.syncService.Preflight(c.Request.Context(), userID, &req)
+		if err != nil {
+			response.InternalError(c, "sync preflight failed")
+			return
+		}
+		successVersioned(c, report)
+		return
+	}
+
+	if req.HasChanges() && middleware.RefuseWrites(c) {
+		return
+	}
+
+	// Get the sender's connection ID to exclude it from broadcasts
+	connID := middleware.GetConnectionID(c)
+	requestID := middleware.GetRequestID(c)
 
 	resp, err := h.syncService.Sync(c.Request.Context(), userID, &req)
 	if err != nil {
+		var validationErr *services.SyncValidationError
+		if errors.As(err, &validationErr) {
+			// Nothing was applied; the client should fix or drop the listed items and retry
+			c.JSON(http.StatusUnprocessableEntity, models.SyncValidationErrorResponse{
+				Error:     "validation_failed",
+				Message:   validationErr.Error(),
+				Failures:  validationErr.Failures,
+				RequestID: requestID,
+			})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidBatchToken) {
+			response.BadRequest(c, err.Error())
+			return
+		}
+		if quotaExceeded(c, err) {
+			return
+		}
 		response.InternalError(c, "sync failed")
 		return
 	}
 
+	// Track how far the device has synced
+	if deviceID != "" {
+		if err := h.devices.RecordSync(c.Request.Context(), userID, deviceID, resp.ServerTimestamp); err != nil {
+			slog.WarnContext(c.Request.Context(), "Failed to record sync cursor for device", "device_id", deviceID, "error", err)
+		}
+	}
+
+	// Unfurl links and notify mentioned collaborators for changed notes in the background
+	for _, noteDTO := range req.Changes {
+		if noteID, err := uuid.Parse(noteDTO.ID); err == nil {
+			h.linkPreviews.Enqueue(c.Request.Context(), userID, noteID, noteDTO.Content)
+			h.mentions.Enqueue(c.Request.Context(), userID, noteID)
+		}
+	}
+
 	// Broadcast changes to other WebSocket connections
 	if h.wsHub != nil {
-		// Broadcast updated/created notes
-		for _, noteDTO := range req.Changes {
-			h.broadcastNoteChange(userID, websocket.MessageTypeNoteUpdated, noteDTO, connID)
+		// Changes that lost a conflict were saved as conflicted copies, not applied
+		lostConflict := make(map[string]bool)
+		conflictedCopies := make(map[string]bool)
+		for _, conflict := range resp.Conflicts {
+			if conflict.KeptVersion == services.ConflictKeptServer {
+				lostConflict[conflict.NoteID] = true
+			}
+			conflictedCopies[conflict.ConflictedCopyID] = true
+		}
+
+		// Merged changes, and notes whose content was rebuilt from CRDT ops, are broadcast as
+		// the resulting note from the response instead
+		merged := make(map[string]bool)
+		for _, noteID := range resp.MergedNoteIDs {
+			merged[noteID] = true
+		}
+		for _, noteOps := range req.ContentOps {
+			merged[noteOps.NoteID] = true
+		}
+
+		// Broadcast updated/created notes as they were saved
+		for _, noteDTO := range resp.Saved {
+			if lostConflict[noteDTO.ID] || merged[noteDTO.ID] {
+				continue
+			}
+			h.broadcastNoteChange(c.Request.Context(), userID, websocket.MessageTypeNoteUpdated, noteDTO, connID, requestID)
+		}
+
+		// Broadcast conflicted copies and merged notes; the sender receives them in the response
+		for _, noteDTO := range resp.Notes {
+			switch {
+			case conflictedCopies[noteDTO.ID]:
+				h.broadcastNoteChange(c.Request.Context(), userID, websocket.MessageTypeNoteCreated, noteDTO, connID, requestID)
+			case merged[noteDTO.ID]:
+				h.broadcastNoteChange(c.Request.Context(), userID, websocket.MessageTypeNoteUpdated, noteDTO, connID, requestID)
+			}
 		}
 
 		// Broadcast deletions
 		for _, noteID := range req.DeletedIDs {
-			h.broadcastNoteDelete(userID, noteID, connID)
+			h.broadcastNoteDelete(c.Request.Context(), userID, noteID, connID, requestID)
 		}
 	}
 
-	response.Success(c, resp)
+	successVersioned(c, resp)
 }
 
-// broadcastNoteChange sends a note updated message to all user's WebSocket connections except the sender
-func (h *SyncHandler) broadcastNoteChange(userID uuid.UUID, msgType websocket.MessageType, note models.NoteDTO, excludeConnID string) {
-	msg := websocket.WSMessage{
-		Type: msgType,
-		Payload: websocket.NoteChangePayload{
-			Note: note,
-		},
+// ListBatches returns the user's recent syncs that can be reverted, newest first (limit default 50, max 200)
+func (h *SyncHandler) ListBatches(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	limit := defaultSyncBatchLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxSyncBatchLimit {
+			response.BadRequest(c, "limit must be between 1 and 200")
+			return
+		}
+		limit = n
 	}
 
-	data, err := json.Marshal(msg)
+	batches, err := h.syncService.Batches(c.Request.Context(), userID, limit)
 	if err != nil {
+		response.InternalError(c, "failed to fetch sync batches")
 		return
 	}
 
-	h.wsHub.BroadcastToUser(userID, data, excludeConnID)
+	batchDTOs := make([]models.SyncBatchDTO, len(batches))
+	for i, batch := range batches {
+		batchDTOs[i] = services.SyncBatchToDTO(&batch)
+	}
+
+	response.Success(c, batchDTOs)
 }
 
-// broadcastNoteDelete sends a note deleted message to all user's WebSocket connections except the sender
-func (h *SyncHandler) broadcastNoteDelete(userID uuid.UUID, noteID string, excludeConnID string) {
-	msg := websocket.WSMessage{
-		Type: websocket.MessageTypeNoteDeleted,
-		Payload: websocket.NoteDeletePayload{
-			NoteID: noteID,
-		},
+// Revert restores every note a sync batch changed to its state before the batch
+func (h *SyncHandler) Revert(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	batchID, err := uuid.Parse(c.Param("batchId"))
+	if err != nil {
+		response.BadRequest(c, "invalid batch ID")
+		return
 	}
 
-	data, err := json.Marshal(msg)
+	resp, err := h.syncService.RevertBatch(c.Request.Context(), userID, batchID)
 	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrSyncBatchNotFound):
+			response.NotFound(c, "sync batch not found")
+		case errors.Is(err, repository.ErrSyncBatchReverted):
+			response.Conflict(c, "sync batch already reverted")
+		default:
+			response.InternalError(c, "failed to revert sync batch")
+		}
 		return
 	}
 
-	h.wsHub.BroadcastToUser(userID, data, excludeConnID)
+	// Restored notes go to all connections, including the sender's, since its local copies are stale
+	if h.wsHub != nil {
+		requestID := middleware.GetRequestID(c)
+		for _, noteDTO := range resp.Notes {
+			h.broadcastNoteChange(c.Request.Context(), userID, websocket.MessageTypeNoteUpdated, noteDTO, "", requestID)
+		}
+		for _, noteID := range resp.DeletedNoteIDs {
+			h.broadcastNoteDelete(c.Request.Context(), userID, noteID, "", requestID)
+		}
+	}
+
+	successVersioned(c, resp)
+}
+
+// broadcastNoteChange sends a note updated message to all user's WebSocket connections except the sender,
+// tagged with the originating request ID
+func (h *SyncHandler) broadcastNoteChange(ctx context.Context, userID uuid.UUID, msgType websocket.MessageType, note models.NoteDTO, excludeConnID, requestID string) {
+	h.wsHub.BroadcastEvent(ctx, userID, msgType, websocket.NoteChangePayload{Note: note}, excludeConnID, requestID)
+}
+
+// broadcastNoteDelete sends a note deleted message to all user's WebSocket connections except the sender,
+// tagged with the originating request ID
+func (h *SyncHandler) broadcastNoteDelete(ctx context.Context, userID uuid.UUID, noteID, excludeConnID, requestID string) {
+	h.wsHub.BroadcastEvent(ctx, userID, websocket.MessageTypeNoteDeleted, websocket.NoteDeletePayload{NoteID: noteID}, excludeConnID, requestID)
 }
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type TelemetryHandler struct {
+	telemetryService *services.TelemetryService
+}
+
+func NewTelemetryHandler(telemetryService *services.TelemetryService) *TelemetryHandler {
+	return &TelemetryHandler{telemetryService: telemetryService}
+}
+
+// Preview shows the telemetry report exactly as it would be sent, and whether sending is enabled
+func (h *TelemetryHandler) Preview(c *gin.Context) {
+	preview, err := h.telemetryService.Preview(c.Request.Context())
+	if err != nil {
+		response.InternalError(c, "failed to build telemetry report")
+		return
+	}
+
+	response.Success(c, preview)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+type UsageHandler struct {
+	quotas *services.QuotaService
+}
+
+func NewUsageHandler(quotas *services.QuotaService) *UsageHandler {
+	return &UsageHandler{quotas: quotas}
+}
+
+// Get returns how much the user stores in notes and the quotas that apply
+func (h *UsageHandler) Get(c *gin.Context) {
+	usage, err := h.quotas.Usage(c.Request.Context(), middleware.GetUserID(c))
+	if err != nil {
+		response.InternalError(c, "failed to fetch usage")
+		return
+	}
+	response.Success(c, usage)
+}
+
+// quotaExceeded responds 413 if err is a *services.QuotaExceededError, reporting whether it was
+func quotaExceeded(c *gin.Context, err error) bool {
+	var quotaErr *services.QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, models.QuotaExceededResponse{
+		Error:     "quota_exceeded",
+		Message:   quotaErr.Error(),
+		Quota:     quotaErr.Quota,
+		Limit:     quotaErr.Limit,
+		Used:      quotaErr.Used,
+		RequestID: middleware.GetRequestID(c),
+	})
+	return true
+}
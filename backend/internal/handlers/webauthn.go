@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// WebAuthnHandler serves passkey registration and sign-in
+type WebAuthnHandler struct {
+	webAuthnService *services.WebAuthnService
+}
+
+func NewWebAuthnHandler(webAuthnService *services.WebAuthnService) *WebAuthnHandler {
+	return &WebAuthnHandler{webAuthnService: webAuthnService}
+}
+
+// BeginRegistration returns the options for adding a passkey to the current user's account
+func (h *WebAuthnHandler) BeginRegistration(c *gin.Context) {
+	options, err := h.webAuthnService.BeginRegistration(c.Request.Context(), middleware.GetUserID(c))
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyCredentials) {
+			response.BadRequest(c, "too many passkeys; remove one first")
+			return
+		}
+		response.InternalError(c, "failed to start passkey registration")
+		return
+	}
+
+	response.Success(c, options)
+}
+
+// FinishRegistration saves the passkey the authenticator created
+func (h *WebAuthnHandler) FinishRegistration(c *gin.Context) {
+	var req models.WebAuthnRegisterFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request: sessionId and credential are required")
+		return
+	}
+
+	credential, err := h.webAuthnService.FinishRegistration(c.Request.Context(), middleware.GetUserID(c), &req, c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrWebAuthnSessionExpired):
+			response.BadRequest(c, "passkey registration expired; start again")
+		case errors.Is(err, services.ErrWebAuthnFailed):
+			response.BadRequest(c, "passkey could not be verified")
+		case errors.Is(err, services.ErrTooManyCredentials):
+			response.BadRequest(c, "too many passkeys; remove one first")
+		case errors.Is(err, repository.ErrWebAuthnCredentialExists):
+			response.Conflict(c, "passkey already registered")
+		default:
+			response.InternalError(c, "failed to register passkey")
+		}
+		return
+	}
+
+	response.Created(c, services.WebAuthnCredentialToDTO(credential))
+}
+
+// BeginLogin returns the options for signing in with a passkey
+func (h *WebAuthnHandler) BeginLogin(c *gin.Context) {
+	var req models.WebAuthnLoginBeginRequest
+	_ = c.ShouldBindJSON(&req) // Optional body
+
+	options, err := h.webAuthnService.BeginLogin(c.Request.Context(), req.Username)
+	if err != nil {
+		response.InternalError(c, "failed to start passkey login")
+		return
+	}
+
+	response.Success(c, options)
+}
+
+// FinishLogin checks the authenticator's signature and logs the passkey's owner in
+func (h *WebAuthnHandler) FinishLogin(c *gin.Context) {
+	var req models.WebAuthnLoginFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, "invalid request: sessionId and credential are required")
+		return
+	}
+
+	clientIP := c.ClientIP()
+	user, tokens, err := h.webAuthnService.FinishLogin(c.Request.Context(), &req, clientIP)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrWebAuthnSessionExpired):
+			response.BadRequest(c, "passkey login expired; start again")
+		case errors.Is(err, services.ErrWebAuthnFailed):
+			// Record failed attempt for rate limiting
+			if al, exists := c.Get("authRateLimiter"); exists {
+				al.(*middleware.AuthRateLimiter).RecordFailedAttempt(c.Request.Context(), clientIP)
+			}
+			response.Unauthorized(c, "passkey could not be verified")
+		case errors.Is(err, services.ErrEmailNotVerified):
+			response.Forbidden(c, "email address not verified; check your email for the verification link")
+		default:
+			response.InternalError(c, "failed to login")
+		}
+		return
+	}
+
+	// Reset failed attempts on successful login
+	if al, exists := c.Get("authRateLimiter"); exists {
+		al.(*middleware.AuthRateLimiter).ResetFailedAttempts(c.Request.Context(), clientIP)
+	}
+
+	response.Success(c, authResponse(tokens, user))
+}
+
+// ListCredentials returns the current user's passkeys
+func (h *WebAuthnHandler) ListCredentials(c *gin.Context) {
+	credentials, err := h.webAuthnService.Credentials(c.Request.Context(), middleware.GetUserID(c))
+	if err != nil {
+		response.InternalError(c, "failed to fetch passkeys")
+		return
+	}
+
+	dtos := make([]models.WebAuthnCredentialDTO, len(credentials))
+	for i := range credentials {
+		dtos[i] = services.WebAuthnCredentialToDTO(&credentials[i])
+	}
+	response.Success(c, dtos)
+}
+
+// DeleteCredential removes one of the current user's passkeys
+func (h *WebAuthnHandler) DeleteCredential(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "invalid passkey ID")
+		return
+	}
+
+	if err := h.webAuthnService.DeleteCredential(c.Request.Context(), middleware.GetUserID(c), id, c.ClientIP()); err != nil {
+		if errors.Is(err, repository.ErrWebAuthnCredentialNotFound) {
+			response.NotFound(c, "passkey not found")
+			return
+		}
+		response.InternalError(c, "failed to remove passkey")
+		return
+	}
+
+	response.NoContent(c)
+}
@@ -1,43 +1,59 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/hamishgilbert/notes-app/backend/internal/clientinfo"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
 	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
 	"github.com/hamishgilbert/notes-app/backend/internal/services"
 	ws "github.com/hamishgilbert/notes-app/backend/internal/websocket"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
 )
 
 // WebSocket authentication protocol name
 const wsAuthProtocol = "access_token"
 
+// wsUnavailableResponse is returned instead of upgrading while the server is draining
+type wsUnavailableResponse struct {
+	Error     string           `json:"error"`
+	Message   string           `json:"message"`
+	Reconnect ws.ReconnectHint `json:"reconnect"`
+}
+
 type WebSocketHandler struct {
 	hub            *ws.Hub
 	authService    *services.AuthService
+	deviceService  *services.DeviceService
 	upgrader       websocket.Upgrader
 	allowedOrigins []string
 }
 
-func NewWebSocketHandler(hub *ws.Hub, authService *services.AuthService, allowedOrigins []string) *WebSocketHandler {
+func NewWebSocketHandler(hub *ws.Hub, authService *services.AuthService, deviceService *services.DeviceService, allowedOrigins []string) *WebSocketHandler {
 	h := &WebSocketHandler{
 		hub:            hub,
 		authService:    authService,
+		deviceService:  deviceService,
 		allowedOrigins: allowedOrigins,
 	}
 
 	h.upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
+		// Negotiated per connection; the client pump decides which messages are worth compressing
+		EnableCompression: hub.CompressionEnabled(),
+		// HandleWebSocket has already checked and logged the origin
 		CheckOrigin: func(r *http.Request) bool {
-			origin := r.Header.Get("Origin")
-			if origin == "" {
-				// Allow requests without origin (non-browser clients)
-				return true
-			}
-			return middleware.IsOriginAllowed(origin, h.allowedOrigins)
+			return h.originAllowed(r.Header.Get("Origin"))
 		},
 		// Allow the access_token subprotocol for authentication
 		Subprotocols: []string{wsAuthProtocol},
@@ -46,8 +62,96 @@ func NewWebSocketHandler(hub *ws.Hub, authService *services.AuthService, allowed
 	return h
 }
 
+// connectedEncoding is the encoding named in the connected message: only MessagePack is named, so
+// JSON clients see no change
+func connectedEncoding(binary bool) string {
+	if binary {
+		return ws.EncodingMsgpack
+	}
+	return ""
+}
+
+// originAllowed reports whether a connection may be opened from origin. Browsers always send one, so
+// a missing origin means a native client, which cross-site requests can't impersonate.
+func (h *WebSocketHandler) originAllowed(origin string) bool {
+	return origin == "" || middleware.IsOriginAllowed(origin, h.allowedOrigins)
+}
+
+// tokenValidator checks access tokens for a connection from the client described by info. Scoped
+// tokens can't open connections. Errors wrap ws.ErrTokenRejected and the service's reason when the
+// token is refused.
+func (h *WebSocketHandler) tokenValidator(info clientinfo.Info) ws.TokenValidator {
+	return func(ctx context.Context, token string) (ws.TokenClaims, error) {
+		tokenInfo, err := h.authService.AccessTokenInfo(clientinfo.WithContext(ctx, info), token)
+		if err == nil && tokenInfo.Scope != "" {
+			err = services.ErrInsufficientScope
+		}
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrTokenRevoked) ||
+				errors.Is(err, services.ErrTokenExpired) || errors.Is(err, services.ErrDeviceMismatch) ||
+				errors.Is(err, services.ErrInsufficientScope) {
+				err = fmt.Errorf("%w: %w", ws.ErrTokenRejected, err)
+			}
+			return ws.TokenClaims{}, err
+		}
+		return ws.TokenClaims{UserID: tokenInfo.UserID, ExpiresAt: tokenInfo.ExpiresAt, ReadOnly: tokenInfo.ReadOnly}, nil
+	}
+}
+
 // HandleWebSocket upgrades HTTP connection to WebSocket
 func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
+	// While the server is shutting down, turn clients away with a hint rather than letting them connect
+	if h.hub.IsDraining() {
+		hint := h.hub.ReconnectHint()
+		c.Header("Retry-After", strconv.FormatInt((hint.RetryAfterMs+999)/1000, 10))
+		c.JSON(http.StatusServiceUnavailable, wsUnavailableResponse{
+			Error:     "service_unavailable",
+			Message:   "server is restarting",
+			Reconnect: hint,
+		})
+		return
+	}
+
+	// Clients ask for a protocol version with ?v=; old versions may be turned away after a rollout
+	version, err := h.hub.NegotiateVersion(c.Query("v"))
+	if err != nil {
+		response.Error(c, http.StatusUpgradeRequired, "upgrade_required", "this app version is no longer supported; please update")
+		return
+	}
+
+	// Refuse other sites' pages before looking at the token, so a logged-in user's browser can't be
+	// used to open a connection from them
+	if origin := c.GetHeader("Origin"); !h.originAllowed(origin) {
+		logging.Security(c.Request.Context(), "WebSocket upgrade rejected for origin", "origin", origin, "ip", c.ClientIP())
+		response.Forbidden(c, "origin not allowed")
+		return
+	}
+
+	// Clients may ask for MessagePack in binary frames instead of JSON with ?encoding=msgpack
+	binary := false
+	switch c.Query("encoding") {
+	case "", "json":
+	case ws.EncodingMsgpack:
+		if version < ws.MinBinaryProtocolVersion {
+			response.BadRequest(c, "encoding=msgpack needs protocol version 9 or later")
+			return
+		}
+		binary = true
+	default:
+		response.BadRequest(c, "encoding must be json or msgpack")
+		return
+	}
+
+	// Reconnecting clients name the last event they saw with ?resume_from= to have the ones they
+	// missed replayed
+	var resumeFrom *ws.ResumePoint
+	if value := c.Query("resume_from"); value != "" && version >= ws.MinResumeProtocolVersion {
+		if resumeFrom, err = ws.ParseResumePoint(value); err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+	}
+
 	// Get token from (in order of preference):
 	// 1. Sec-WebSocket-Protocol header (most secure - not logged, not in URL)
 	// 2. Authorization header (Bearer token)
@@ -82,17 +186,31 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 	}
 
 	if token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing authentication token"})
+		response.Unauthorized(c, "missing authentication token")
 		return
 	}
 
-	// Validate token
-	userID, err := h.authService.ValidateTokenWithContext(c.Request.Context(), token)
+	// Browsers can't set headers on the upgrade request, so the device a token is bound to may be
+	// named with ?deviceId= instead of X-Device-ID
+	ctx := c.Request.Context()
+	if info := clientinfo.FromContext(ctx); info.DeviceID == "" {
+		if deviceID := c.Query("deviceId"); deviceID != "" && len(deviceID) <= models.MaxDeviceIDLength {
+			info.DeviceID = deviceID
+			ctx = clientinfo.WithContext(ctx, info)
+		}
+	}
+
+	// Validate token; the connection is closed when it expires unless the client sends a new one,
+	// and it's rechecked for revocation while the connection is open
+	validate := h.tokenValidator(clientinfo.FromContext(ctx))
+	claims, err := validate(ctx, token)
 	if err != nil {
-		if err == services.ErrTokenRevoked {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked"})
+		if errors.Is(err, services.ErrTokenRevoked) {
+			response.Unauthorized(c, "token has been revoked")
+		} else if errors.Is(err, services.ErrDeviceMismatch) {
+			response.Unauthorized(c, "token was issued to another device")
 		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+			response.Unauthorized(c, "invalid or expired token")
 		}
 		return
 	}
@@ -111,8 +229,33 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	// Create client and register with hub
-	client := ws.NewClient(h.hub, conn, userID)
+	// Create client, naming its device for the user's other devices
+	userID := claims.UserID
+	client := ws.NewClient(h.hub, conn, userID, version)
+	client.ResumeFrom = resumeFrom
+	client.Binary = binary
+	client.ClientIP = c.ClientIP()
+	client.UserAgent = c.Request.UserAgent()
+	client.SetAuth(token, claims, validate)
+	if deviceID := clientinfo.FromContext(ctx).DeviceID; deviceID != "" {
+		client.DeviceID = deviceID
+		if client.DeviceName, err = h.deviceService.Name(ctx, userID, deviceID); err != nil {
+			slog.WarnContext(ctx, "Failed to look up name of device", "device_id", deviceID, "error", err)
+		}
+	}
+
+	// Tell the client its connection ID so it can send it back as X-Connection-ID. It's queued before
+	// registering so it arrives ahead of the presence message registering broadcasts.
+	client.SendMessage(ws.WSMessage{
+		Type: ws.MessageTypeConnected,
+		Payload: ws.ConnectedPayload{
+			ConnectionID:    client.ID,
+			ProtocolVersion: version,
+			Epoch:           h.hub.Epoch(),
+			Seq:             h.hub.LastSeq(userID),
+			Encoding:        connectedEncoding(binary),
+		},
+	})
 	h.hub.Register(client)
 
 	// Start read/write pumps in goroutines
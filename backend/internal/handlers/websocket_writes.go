@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/currentuser"
+	"github.com/hamishgilbert/notes-app/backend/internal/middleware"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	ws "github.com/hamishgilbert/notes-app/backend/internal/websocket"
+)
+
+// wsNoteWriter saves notes changed over the WebSocket with the same code as PUT and DELETE
+// /api/notes/:id, and audit logs them like the notes routes
+type wsNoteWriter struct {
+	notes       *NotesHandler
+	auditLogger *middleware.AuditLogger
+}
+
+// NewWebSocketNoteWriter lets the hub's clients change notes through the notes handler
+func NewWebSocketNoteWriter(notes *NotesHandler, auditLogger *middleware.AuditLogger) ws.NoteWriter {
+	return &wsNoteWriter{notes: notes, auditLogger: auditLogger}
+}
+
+func (w *wsNoteWriter) UpdateNote(ctx context.Context, source ws.WriteSource, note models.NoteDTO) (models.NoteDTO, error) {
+	start := time.Now()
+	var saved models.NoteDTO
+	err := models.ValidateNoteDTO(&note)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ws.ErrInvalidNote, err)
+	} else {
+		saved, err = w.notes.saveNote(w.context(ctx, source), source.UserID, note, source.ConnectionID, source.RequestID)
+		err = w.translate(err)
+	}
+	w.audit(ctx, source, middleware.AuditActionUpdate, note.ID, start, err)
+	return saved, err
+}
+
+func (w *wsNoteWriter) DeleteNote(ctx context.Context, source ws.WriteSource, noteID uuid.UUID) error {
+	start := time.Now()
+	err := w.translate(w.notes.deleteNote(w.context(ctx, source), source.UserID, noteID, source.ConnectionID, source.RequestID))
+	w.audit(ctx, source, middleware.AuditActionDelete, noteID.String(), start, err)
+	return err
+}
+
+// context carries what the auth and request ID middleware would set on an HTTP request, so row level
+// security and background jobs see the write the same way
+func (w *wsNoteWriter) context(ctx context.Context, source ws.WriteSource) context.Context {
+	ctx = currentuser.WithContext(ctx, source.UserID)
+	return requestid.WithContext(ctx, source.RequestID)
+}
+
+// translate maps the notes handler's errors to the ones the hub reports to clients
+func (w *wsNoteWriter) translate(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrNoteNotFound):
+		return ws.ErrNoteNotFound
+	case errors.Is(err, errInvalidNoteData):
+		return fmt.Errorf("%w: %w", ws.ErrInvalidNote, err)
+	case errors.Is(err, repository.ErrVersionConflict):
+		return ws.ErrVersionConflict
+	}
+	var quotaErr *services.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		return fmt.Errorf("%w: %w", ws.ErrQuotaExceeded, err)
+	}
+	return err
+}
+
+// audit logs a write with the status the equivalent REST request would have had
+func (w *wsNoteWriter) audit(ctx context.Context, source ws.WriteSource, action middleware.AuditAction, noteID string, start time.Time, err error) {
+	status := http.StatusOK
+	if action == middleware.AuditActionDelete {
+		status = http.StatusNoContent
+	}
+	switch {
+	case errors.Is(err, ws.ErrNoteNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ws.ErrInvalidNote):
+		status = http.StatusBadRequest
+	case errors.Is(err, ws.ErrVersionConflict):
+		status = http.StatusConflict
+	case errors.Is(err, ws.ErrQuotaExceeded):
+		status = http.StatusRequestEntityTooLarge
+	case err != nil:
+		status = http.StatusInternalServerError
+	}
+	w.auditLogger.Log(w.context(ctx, source), middleware.AuditLog{
+		Timestamp:  start,
+		RequestID:  source.RequestID,
+		UserID:     source.UserID.String(),
+		Action:     action,
+		Resource:   "notes",
+		ResourceID: noteID,
+		ClientIP:   source.ClientIP,
+		UserAgent:  source.UserAgent,
+		StatusCode: status,
+		Duration:   time.Since(start).Milliseconds(),
+		Details:    "websocket",
+	})
+}
@@ -0,0 +1,129 @@
+// Package hlc implements hybrid logical clocks, which order edits across devices whose clocks
+// disagree. A timestamp pairs a wall time with a counter: a device that has seen a timestamp
+// always stamps its next edit after it, even if its own clock is behind, so an edit made on top of
+// another can't lose to it because of clock skew.
+//
+// Timestamps are written "<unix ms, 13 digits>-<counter, 5 digits>-<node>", where node identifies
+// the device (it breaks ties and may be empty), and compare in that order.
+package hlc
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxDrift is how far ahead of the server's clock a timestamp may be. Devices with clocks further
+// ahead would win every conflict until real time caught up.
+const MaxDrift = 5 * time.Minute
+
+// MaxNodeLength limits the node part of a timestamp
+const MaxNodeLength = 100
+
+var ErrInvalid = errors.New("invalid hybrid logical clock timestamp")
+
+// Timestamp is a point in hybrid logical time
+type Timestamp struct {
+	Wall    int64 // unix milliseconds
+	Counter uint16
+	Node    string
+}
+
+// FromTime returns the timestamp for a plain wall time, used for edits made without a clock
+func FromTime(t time.Time) Timestamp {
+	return Timestamp{Wall: t.UnixMilli()}
+}
+
+// Parse reads a timestamp written by String
+func Parse(s string) (Timestamp, error) {
+	parts := strings.SplitN(s, "-", 3)
+	if len(parts) != 3 || len(parts[0]) != 13 || len(parts[1]) != 5 || len(parts[2]) > MaxNodeLength {
+		return Timestamp{}, ErrInvalid
+	}
+	wall, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || wall < 0 {
+		return Timestamp{}, ErrInvalid
+	}
+	counter, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return Timestamp{}, ErrInvalid
+	}
+	return Timestamp{Wall: wall, Counter: uint16(counter), Node: parts[2]}, nil
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%013d-%05d-%s", t.Wall, t.Counter, t.Node)
+}
+
+// Time returns the wall time part
+func (t Timestamp) Time() time.Time {
+	return time.UnixMilli(t.Wall)
+}
+
+// Compare returns -1, 0 or 1 as t is before, equal to or after u
+func (t Timestamp) Compare(u Timestamp) int {
+	switch {
+	case t.Wall != u.Wall:
+		return cmp.Compare(t.Wall, u.Wall)
+	case t.Counter != u.Counter:
+		return cmp.Compare(t.Counter, u.Counter)
+	default:
+		return strings.Compare(t.Node, u.Node)
+	}
+}
+
+// After reports whether t is after u
+func (t Timestamp) After(u Timestamp) bool {
+	return t.Compare(u) > 0
+}
+
+// Clock issues timestamps for one node. It is safe for concurrent use.
+type Clock struct {
+	mu   sync.Mutex
+	last Timestamp
+	node string
+	now  func() time.Time
+}
+
+func NewClock(node string) *Clock {
+	return &Clock{node: node, now: time.Now}
+}
+
+// Now returns a timestamp after every one the clock has issued or observed
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.advance(Timestamp{})
+}
+
+// Observe moves the clock past a timestamp received from another node and returns the clock's new
+// time. Timestamps more than MaxDrift ahead should be rejected before they're observed.
+func (c *Clock) Observe(remote Timestamp) Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.advance(remote)
+}
+
+func (c *Clock) advance(remote Timestamp) Timestamp {
+	next := Timestamp{Wall: c.now().UnixMilli(), Node: c.node}
+	latest := c.last
+	if remote.Wall > latest.Wall || (remote.Wall == latest.Wall && remote.Counter > latest.Counter) {
+		latest = remote
+	}
+
+	if latest.Wall >= next.Wall {
+		next.Wall = latest.Wall
+		if latest.Counter == math.MaxUint16 {
+			next.Wall++
+		} else {
+			next.Counter = latest.Counter + 1
+		}
+	}
+	c.last = next
+	return next
+}
@@ -0,0 +1,38 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"time"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+// JWKS returns the public keys of the asymmetric keys not yet retired, as a JSON Web Key Set (RFC
+// 7517). Keys scheduled to sign later are included, so verifiers have them before they're used.
+// HMAC secrets are never published.
+func (k *Keyring) JWKS(now time.Time) models.JWKSResponse {
+	set := models.JWKSResponse{Keys: []models.JWK{}}
+	for _, key := range k.keys {
+		if key.private == nil || !key.usable(now) {
+			continue
+		}
+		jwk := models.JWK{KeyID: key.ID, Algorithm: key.Algorithm, Use: "sig"}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
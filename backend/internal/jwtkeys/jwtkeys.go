@@ -0,0 +1,279 @@
+// Package jwtkeys holds the keys JWTs are signed with. Each key has an ID, sent in
+// the token's kid header, and an optional schedule: a key starts signing at its
+// not_before time and is no longer accepted after its not_after time. Tokens are
+// checked against the key they name, so a new key can take over signing while
+// tokens from the old one stay valid until it is retired.
+//
+// Keys are HMAC secrets (HS256) or RSA and Ed25519 private keys (RS256, EdDSA).
+// The public halves of the asymmetric keys are published as a JWK set, so other
+// services can verify tokens without holding anything that can sign them.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MinSecretLength is the shortest secret a keys file may hold
+const MinSecretLength = 32
+
+// MinRSABits is the smallest RSA key accepted for signing
+const MinRSABits = 2048
+
+// Signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// Key is one signing key
+type Key struct {
+	ID             string    `json:"kid"`
+	Algorithm      string    `json:"alg,omitempty"`              // HS256, RS256 or EdDSA; inferred when empty
+	Secret         string    `json:"secret,omitempty"`           // HS256
+	PrivateKeyFile string    `json:"private_key_file,omitempty"` // RS256 and EdDSA: PEM private key
+	NotBefore      time.Time `json:"not_before,omitzero"`        // signs from then on; zero = from the start
+	NotAfter       time.Time `json:"not_after,omitzero"`         // no longer accepted from then on; zero = never retired
+
+	private crypto.Signer // RS256 and EdDSA
+}
+
+// usable reports whether the key verifies tokens at now
+func (k Key) usable(now time.Time) bool {
+	return k.NotAfter.IsZero() || now.Before(k.NotAfter)
+}
+
+// Method returns the JWT signing method for the key's algorithm
+func (k Key) Method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case RS256:
+		return jwt.SigningMethodRS256
+	case EdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// SigningKey returns what signs tokens with the key's method
+func (k Key) SigningKey() any {
+	if k.private != nil {
+		return k.private
+	}
+	return []byte(k.Secret)
+}
+
+// VerificationKey returns what verifies tokens signed with the key
+func (k Key) VerificationKey() any {
+	if k.private != nil {
+		return k.private.Public()
+	}
+	return []byte(k.Secret)
+}
+
+// load reads the key's private key file, if it has one, and checks its algorithm
+func (k *Key) load() error {
+	if k.PrivateKeyFile == "" {
+		if k.Secret == "" {
+			return fmt.Errorf("%w: %s needs a secret or private_key_file", ErrInvalidKey, k.ID)
+		}
+		if k.Algorithm != "" && k.Algorithm != HS256 {
+			return fmt.Errorf("%w: %s is %s, which needs a private_key_file", ErrInvalidKey, k.ID, k.Algorithm)
+		}
+		k.Algorithm = HS256
+		return nil
+	}
+
+	if k.Secret != "" {
+		return fmt.Errorf("%w: %s has both a secret and a private_key_file", ErrInvalidKey, k.ID)
+	}
+	private, err := readPrivateKey(k.PrivateKeyFile)
+	if err != nil {
+		return err
+	}
+	var algorithm string
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < MinRSABits {
+			return fmt.Errorf("%w: %s must be at least %d bits", ErrInvalidKey, k.PrivateKeyFile, MinRSABits)
+		}
+		algorithm = RS256
+	case ed25519.PrivateKey:
+		algorithm = EdDSA
+	default:
+		return fmt.Errorf("%w: %s is not an RSA or Ed25519 key", ErrInvalidKey, k.PrivateKeyFile)
+	}
+	if k.Algorithm != "" && k.Algorithm != algorithm {
+		return fmt.Errorf("%w: %s holds an %s key, not %s", ErrInvalidKey, k.PrivateKeyFile, algorithm, k.Algorithm)
+	}
+	k.Algorithm = algorithm
+	k.private = private
+	return nil
+}
+
+// readPrivateKey reads a PEM private key in PKCS #8, or PKCS #1 for RSA
+func readPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s is not PEM", ErrInvalidKey, path)
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a signing key", ErrInvalidKey, path)
+	}
+	return signer, nil
+}
+
+// Keyring is the set of keys tokens may be signed with
+type Keyring struct {
+	keys []Key // by NotBefore, oldest first
+}
+
+var (
+	ErrNoKeys          = errors.New("no JWT keys")
+	ErrNoSigningKey    = errors.New("no JWT key is active")
+	ErrDuplicateKeyID  = errors.New("duplicate JWT key ID")
+	ErrInvalidKey      = errors.New("invalid JWT key")
+	ErrKeyNotAvailable = errors.New("JWT key unknown or retired")
+)
+
+// New creates a keyring from keys, which must have distinct IDs, reading their private key files
+func New(keys []Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	sorted := make([]Key, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("%w: kid is required", ErrInvalidKey)
+		}
+		if !key.NotAfter.IsZero() && !key.NotAfter.After(key.NotBefore) {
+			return nil, fmt.Errorf("%w: %s is retired before it is active", ErrInvalidKey, key.ID)
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateKeyID, key.ID)
+		}
+		seen[key.ID] = true
+		if err := key.load(); err != nil {
+			return nil, err
+		}
+		sorted[i] = key
+	}
+
+	// Of keys active from the same time, the one listed last signs
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].NotBefore.Before(sorted[j].NotBefore)
+	})
+	return &Keyring{keys: sorted}, nil
+}
+
+// Single creates a keyring with just secret, whose ID is derived from it so it's the same across
+// restarts
+func Single(secret string) *Keyring {
+	keyring, _ := New([]Key{{ID: DeriveID(secret), Secret: secret}})
+	return keyring
+}
+
+// WithPrivateKey creates a keyring that signs with the private key in privateKeyFile. Tokens
+// already signed with secret are still accepted, so switching doesn't sign everyone out.
+func WithPrivateKey(secret, privateKeyFile string) (*Keyring, error) {
+	signing := Key{PrivateKeyFile: privateKeyFile}
+	if err := signing.load(); err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(signing.private.Public())
+	if err != nil {
+		return nil, err
+	}
+	signing.ID = DeriveID(string(der))
+	return New([]Key{{ID: DeriveID(secret), Secret: secret}, signing})
+}
+
+// DeriveID returns a key ID for a secret or public key that doesn't reveal the secret
+func DeriveID(secret string) string {
+	sum := sha256.Sum256([]byte("notes-jwt-key-id:" + secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Load reads a keyring from a JSON file holding an array of keys
+func Load(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, key := range keys {
+		if key.PrivateKeyFile == "" && len(key.Secret) < MinSecretLength {
+			return nil, fmt.Errorf("%s: %w: secret of %s must be at least %d characters", path, ErrInvalidKey, key.ID, MinSecretLength)
+		}
+	}
+	keyring, err := New(keys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keyring, nil
+}
+
+// Signing returns the key to sign new tokens with at now: of the keys not yet retired, the one that
+// became active most recently
+func (k *Keyring) Signing(now time.Time) (Key, error) {
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		key := k.keys[i]
+		if !now.Before(key.NotBefore) && key.usable(now) {
+			return key, nil
+		}
+	}
+	return Key{}, ErrNoSigningKey
+}
+
+// Verification returns the key with the given ID, unless it has been retired. Keys that aren't
+// signing yet are accepted, so servers whose clocks differ slightly agree on tokens signed around
+// a rotation.
+func (k *Keyring) Verification(id string, now time.Time) (Key, error) {
+	for _, key := range k.keys {
+		if key.ID == id && key.usable(now) {
+			return key, nil
+		}
+	}
+	return Key{}, ErrKeyNotAvailable
+}
+
+// Secrets returns the HMAC secrets not yet retired, for tokens signed before they carried a key ID
+func (k *Keyring) Secrets(now time.Time) [][]byte {
+	var secrets [][]byte
+	for _, key := range k.keys {
+		if key.Algorithm == HS256 && key.usable(now) {
+			secrets = append(secrets, []byte(key.Secret))
+		}
+	}
+	return secrets
+}
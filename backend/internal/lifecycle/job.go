@@ -0,0 +1,70 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Job is a background task run every Interval while the server is up
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// RunAtStart runs the job as soon as it starts, instead of waiting for the first interval
+	RunAtStart bool
+	// Run does one run of the job; ctx is cancelled when the job is stopped
+	Run func(ctx context.Context)
+}
+
+// Component returns a component that runs the job in its own goroutine. Stopping it cancels a run
+// in progress and waits for the run to return.
+func (j Job) Component() Component {
+	var (
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	)
+	return Component{
+		Name: j.Name,
+		Start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				j.loop(ctx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// loop runs the job every interval until ctx is cancelled
+func (j Job) loop(ctx context.Context) {
+	if j.RunAtStart {
+		j.Run(ctx)
+	}
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.Run(ctx)
+		}
+	}
+}
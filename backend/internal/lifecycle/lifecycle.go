@@ -0,0 +1,132 @@
+// Package lifecycle starts the server's components in order and stops them in
+// reverse, so everything started is also stopped: background jobs finish or are
+// cancelled, the HTTP server drains its requests, and the database is closed last.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// DefaultStopTimeout is how long a component gets to stop when it doesn't set its own timeout
+const DefaultStopTimeout = 5 * time.Second
+
+// Component is one part of the server that is started and stopped with it. Either function may be
+// nil. Start should return once the component is running; Stop should return once it has stopped,
+// or when ctx is done.
+type Component struct {
+	Name        string
+	Start       func(ctx context.Context) error
+	Stop        func(ctx context.Context) error
+	StopTimeout time.Duration // zero means DefaultStopTimeout
+}
+
+// Manager tracks the components that have been started, for stopping them in reverse order
+type Manager struct {
+	mu       sync.Mutex
+	started  []Component
+	stopping bool
+
+	failed   chan error
+	failOnce sync.Once
+}
+
+// New creates a Manager with nothing started
+func New() *Manager {
+	return &Manager{failed: make(chan error, 1)}
+}
+
+// Start starts a component and records it for Stop. Components are started one at a time, in the
+// order Start is called, so each can depend on the ones before it.
+func (m *Manager) Start(ctx context.Context, c Component) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopping {
+		return fmt.Errorf("start %s: shutting down", c.Name)
+	}
+
+	if c.Start != nil {
+		if err := c.Start(ctx); err != nil {
+			return fmt.Errorf("start %s: %w", c.Name, err)
+		}
+	}
+	m.started = append(m.started, c)
+	slog.Info("Started component", "component", c.Name)
+	return nil
+}
+
+// Fail reports that a running component has failed, ending Wait. Only the first failure is kept.
+func (m *Manager) Fail(err error) {
+	m.failOnce.Do(func() {
+		m.failed <- err
+	})
+}
+
+// Wait blocks until one of the signals arrives or a component fails, returning the failure
+func (m *Manager) Wait(signals ...os.Signal) error {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, signals...)
+	defer signal.Stop(quit)
+
+	select {
+	case sig := <-quit:
+		slog.Info("Received signal", "signal", sig.String())
+		return nil
+	case err := <-m.failed:
+		return err
+	}
+}
+
+// Stop stops the started components in reverse order, each within its own timeout. A component
+// that fails or doesn't stop in time is logged and skipped, so the rest still get to stop; the
+// returned error joins every failure.
+func (m *Manager) Stop() error {
+	m.mu.Lock()
+	m.stopping = true
+	started := m.started
+	m.started = nil
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(started) - 1; i >= 0; i-- {
+		c := started[i]
+		if c.Stop == nil {
+			continue
+		}
+		if err := stop(c); err != nil {
+			slog.Error("Failed to stop component", "component", c.Name, "error", err)
+			errs = append(errs, fmt.Errorf("stop %s: %w", c.Name, err))
+			continue
+		}
+		slog.Info("Stopped component", "component", c.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// stop runs a component's Stop, giving up on it once its timeout has passed even if Stop itself
+// doesn't return
+func stop(c Component) error {
+	timeout := c.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Stop(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+}
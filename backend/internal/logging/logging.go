@@ -0,0 +1,98 @@
+// Package logging sets up the server's structured logs. Entries are JSON in production and text
+// otherwise, and entries logged with a request's context carry its request ID, user and trace, so
+// every line a request produces, including its audit entries, can be found from the ID in its
+// response.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/currentuser"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Categories of entries that are kept or alerted on separately from the rest
+const (
+	CategorySecurity = "security" // refused tokens, device mismatches, admin promotions and the like
+	CategoryAudit    = "audit"    // who did what to which resource
+)
+
+// Options choose how entries are written
+type Options struct {
+	Level slog.Level
+	JSON  bool // one JSON object per line instead of key=value text
+}
+
+// ParseLevel reads a level name: debug, info, warn or error
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q; use debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// New returns a logger writing to w. Install it with slog.SetDefault, which also sends what the
+// standard library and dependencies write to the log package through it.
+func New(w io.Writer, opts Options) *slog.Logger {
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+	var handler slog.Handler
+	if opts.JSON {
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	} else {
+		handler = slog.NewTextHandler(w, handlerOpts)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+// Security logs a security event, such as a refused token, as a warning
+func Security(ctx context.Context, msg string, args ...any) {
+	slog.Default().Log(ctx, slog.LevelWarn, msg, append([]any{"category", CategorySecurity}, args...)...)
+}
+
+// Audit logs who did what, such as an admin restoring a backup
+func Audit(ctx context.Context, msg string, args ...any) {
+	slog.Default().Log(ctx, slog.LevelInfo, msg, append([]any{"category", CategoryAudit}, args...)...)
+}
+
+// contextHandler adds what ctx says about the request to each entry
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestid.FromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if userID, ok := currentuser.FromContext(ctx); ok && !hasAttr(r, "user_id") {
+		r.AddAttrs(slog.String("user_id", userID.String()))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// hasAttr reports whether the entry was logged with key already, as sign-ins name their user before
+// the context carries one
+func hasAttr(r slog.Record, key string) bool {
+	found := false
+	r.Attrs(func(attr slog.Attr) bool {
+		found = attr.Key == key
+		return !found
+	})
+	return found
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,98 @@
+// Package mail sends transactional email (invitations, notifications).
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config holds SMTP settings. When Host is empty, mail is logged instead of sent.
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+
+	// LogBodies includes message bodies (which may contain links with tokens)
+	// in the log when no SMTP server is configured. Development only.
+	LogBodies bool
+}
+
+// New returns an SMTP mailer, or a logging mailer if no SMTP host is configured
+func New(cfg Config) Mailer {
+	if cfg.Host == "" {
+		return &LogMailer{logBodies: cfg.LogBodies}
+	}
+	return &SMTPMailer{cfg: cfg}
+}
+
+// SMTPMailer sends mail through an SMTP server, using STARTTLS when offered
+type SMTPMailer struct {
+	cfg Config
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid header value")
+	}
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	// smtp.SendMail has no context support, so run it in the background and stop waiting on cancel
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(m.cfg.Host, m.cfg.Port), auth, m.cfg.From, []string{msg.To}, []byte(b.String()))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogMailer writes messages to the log instead of sending them
+type LogMailer struct {
+	logBodies bool
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if m.logBodies {
+		slog.InfoContext(ctx, "Email", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	} else {
+		slog.InfoContext(ctx, "SMTP not configured, dropping email", "to", msg.To, "subject", msg.Subject)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Package metrics writes metrics in the Prometheus text exposition format, for scraping without
+// pulling in the Prometheus client library.
+package metrics
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the Content-Type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Label is one name="value" pair on a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Writer builds a scrape response. Families must be written one at a time: Family, then its samples.
+type Writer struct {
+	buf bytes.Buffer
+}
+
+// Family starts a metric with its help text; kind is "gauge" or "counter"
+func (w *Writer) Family(name, kind, help string) {
+	w.buf.WriteString("# HELP " + name + " " + strings.ReplaceAll(help, "\n", " ") + "\n")
+	w.buf.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// Sample writes one value of the current family
+func (w *Writer) Sample(name string, value float64, labels ...Label) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			w.buf.WriteString(label.Name + `="` + escape(label.Value) + `"`)
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.buf.WriteByte('\n')
+}
+
+// Gauge writes a family with a single unlabelled gauge
+func (w *Writer) Gauge(name, help string, value float64) {
+	w.Family(name, "gauge", help)
+	w.Sample(name, value)
+}
+
+// Counter writes a family with a single unlabelled counter
+func (w *Writer) Counter(name, help string, value float64) {
+	w.Family(name, "counter", help)
+	w.Sample(name, value)
+}
+
+// Bytes returns what has been written
+func (w *Writer) Bytes() []byte {
+	return w.buf.Bytes()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return labelEscaper.Replace(value)
+}
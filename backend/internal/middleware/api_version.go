@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// APIVersionHeader carries the API version a client was built against, so responses can be given
+// in the shapes it expects. The response echoes the version used.
+const APIVersionHeader = "X-API-Version"
+
+const apiVersionKey = "apiVersion"
+
+// APIVersionMiddleware reads the client's API version. Clients newer than the server get the latest
+// version; a malformed version is rejected.
+func APIVersionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version := services.APIVersion1
+		if header := c.GetHeader(APIVersionHeader); header != "" {
+			v, err := strconv.Atoi(header)
+			if err != nil || v < services.APIVersion1 {
+				response.BadRequest(c, "invalid X-API-Version")
+				c.Abort()
+				return
+			}
+			version = min(v, services.LatestAPIVersion)
+		}
+
+		c.Set(apiVersionKey, version)
+		c.Header(APIVersionHeader, strconv.Itoa(version))
+		c.Next()
+	}
+}
+
+// GetAPIVersion returns the API version of the request (1 if the middleware didn't run)
+func GetAPIVersion(c *gin.Context) int {
+	if version, ok := c.Get(apiVersionKey); ok {
+		if v, ok := version.(int); ok {
+			return v
+		}
+	}
+	return services.APIVersion1
+}
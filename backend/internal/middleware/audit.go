@@ -1,11 +1,14 @@
 package middleware
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
 )
 
 // AuditAction represents the type of action being audited
@@ -22,6 +25,7 @@ const (
 // AuditLog represents an audit log entry
 type AuditLog struct {
 	Timestamp  time.Time   `json:"timestamp"`
+	RequestID  string      `json:"request_id,omitempty"`
 	UserID     string      `json:"user_id"`
 	Action     AuditAction `json:"action"`
 	Resource   string      `json:"resource"`
@@ -33,32 +37,38 @@ type AuditLog struct {
 	Details    string      `json:"details,omitempty"`
 }
 
-// AuditLogger handles audit logging
+// AuditLogger handles audit logging. Entries go to logger with category=audit.
 type AuditLogger struct {
+	logger  *slog.Logger
 	enabled bool
 }
 
 // NewAuditLogger creates a new audit logger
-func NewAuditLogger(enabled bool) *AuditLogger {
-	return &AuditLogger{enabled: enabled}
+func NewAuditLogger(logger *slog.Logger, enabled bool) *AuditLogger {
+	return &AuditLogger{logger: logger, enabled: enabled}
 }
 
-// Log writes an audit log entry
-func (a *AuditLogger) Log(entry AuditLog) {
+// Log writes an audit log entry. ctx supplies the request ID when the entry doesn't have one.
+func (a *AuditLogger) Log(ctx context.Context, entry AuditLog) {
 	if !a.enabled {
 		return
 	}
+	if entry.RequestID != "" {
+		ctx = requestid.WithContext(ctx, entry.RequestID)
+	}
 
-	log.Printf("[AUDIT] %s | user=%s | action=%s | resource=%s | resource_id=%s | ip=%s | status=%d | duration=%dms | details=%s",
-		entry.Timestamp.Format(time.RFC3339),
-		entry.UserID,
-		entry.Action,
-		entry.Resource,
-		entry.ResourceID,
-		entry.ClientIP,
-		entry.StatusCode,
-		entry.Duration,
-		entry.Details,
+	a.logger.InfoContext(ctx, "Audit",
+		"category", logging.CategoryAudit,
+		"started_at", entry.Timestamp,
+		"user_id", entry.UserID,
+		"action", entry.Action,
+		"resource", entry.Resource,
+		"resource_id", entry.ResourceID,
+		"ip", entry.ClientIP,
+		"user_agent", entry.UserAgent,
+		"status", entry.StatusCode,
+		"duration_ms", entry.Duration,
+		"details", entry.Details,
 	)
 }
 
@@ -95,6 +105,7 @@ func AuditMiddleware(logger *AuditLogger, resource string) gin.HandlerFunc {
 		// Log the audit entry
 		entry := AuditLog{
 			Timestamp:  startTime,
+			RequestID:  GetRequestID(c),
 			UserID:     userID,
 			Action:     action,
 			Resource:   resource,
@@ -110,7 +121,7 @@ func AuditMiddleware(logger *AuditLogger, resource string) gin.HandlerFunc {
 			entry.Details = "resource deleted successfully"
 		}
 
-		logger.Log(entry)
+		logger.Log(c.Request.Context(), entry)
 	}
 }
 
@@ -131,7 +142,7 @@ func methodToAction(method string) AuditAction {
 }
 
 // LogAuthEvent logs authentication-related events
-func (a *AuditLogger) LogAuthEvent(userID, action, clientIP, userAgent, details string, success bool) {
+func (a *AuditLogger) LogAuthEvent(ctx context.Context, userID, action, clientIP, userAgent, details string, success bool) {
 	if !a.enabled {
 		return
 	}
@@ -141,29 +152,29 @@ func (a *AuditLogger) LogAuthEvent(userID, action, clientIP, userAgent, details
 		status = "failure"
 	}
 
-	log.Printf("[AUDIT-AUTH] %s | user=%s | action=%s | ip=%s | user_agent=%s | status=%s | details=%s",
-		time.Now().Format(time.RFC3339),
-		userID,
-		action,
-		clientIP,
-		userAgent,
-		status,
-		details,
+	a.logger.InfoContext(ctx, "Audit auth event",
+		"category", logging.CategoryAudit,
+		"user_id", userID,
+		"action", action,
+		"ip", clientIP,
+		"user_agent", userAgent,
+		"status", status,
+		"details", details,
 	)
 }
 
 // LogSyncEvent logs sync-related events
-func (a *AuditLogger) LogSyncEvent(userID, clientIP string, changesCount, deletedCount int, duration int64) {
+func (a *AuditLogger) LogSyncEvent(ctx context.Context, userID, clientIP string, changesCount, deletedCount int, duration int64) {
 	if !a.enabled {
 		return
 	}
 
-	log.Printf("[AUDIT-SYNC] %s | user=%s | ip=%s | changes=%d | deleted=%d | duration=%dms",
-		time.Now().Format(time.RFC3339),
-		userID,
-		clientIP,
-		changesCount,
-		deletedCount,
-		duration,
+	a.logger.InfoContext(ctx, "Audit sync event",
+		"category", logging.CategoryAudit,
+		"user_id", userID,
+		"ip", clientIP,
+		"changes", changesCount,
+		"deleted", deletedCount,
+		"duration_ms", duration,
 	)
 }
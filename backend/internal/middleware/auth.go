@@ -1,17 +1,30 @@
 package middleware
 
 import (
+	"errors"
+	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/currentuser"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
 	"github.com/hamishgilbert/notes-app/backend/internal/services"
 	"github.com/hamishgilbert/notes-app/backend/pkg/response"
 )
 
-const UserIDKey = "userID"
+const (
+	UserIDKey    = "userID"
+	SessionIDKey = "sessionID"
+	TokenInfoKey = "tokenInfo"
+)
 
-func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
+// AuthMiddleware requires a valid access token. Scoped tokens are only let through to route groups
+// that accept their scope, and then only for what the scope allows; tokens without a scope can be
+// used anywhere.
+func AuthMiddleware(authService *services.AuthService, scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -28,10 +41,12 @@ func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 		}
 
 		token := parts[1]
-		userID, err := authService.ValidateTokenWithContext(c.Request.Context(), token)
+		info, err := authService.AccessTokenInfo(c.Request.Context(), token)
 		if err != nil {
 			if err == services.ErrTokenRevoked {
 				response.Unauthorized(c, "token has been revoked")
+			} else if err == services.ErrDeviceMismatch {
+				response.Unauthorized(c, "token was issued to another device")
 			} else {
 				response.Unauthorized(c, "invalid or expired token")
 			}
@@ -39,7 +54,50 @@ func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 			return
 		}
 
-		c.Set(UserIDKey, userID)
+		if info.Scope != "" && !(slices.Contains(scopes, info.Scope) && scopeAllows(info.Scope, c.Request.Method)) {
+			response.Forbidden(c, services.ErrInsufficientScope.Error())
+			c.Abort()
+			return
+		}
+		// Read-only accounts may read, including through ReadRoutes, whose handlers refuse their changes
+		if info.ReadOnly && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead && !isReadRoute(c) {
+			response.Forbidden(c, services.ErrReadOnlyAccount.Error())
+			c.Abort()
+			return
+		}
+
+		c.Set(UserIDKey, info.UserID)
+		c.Set(SessionIDKey, info.SessionID)
+		c.Set(TokenInfoKey, info)
+		c.Request = c.Request.WithContext(currentuser.WithContext(c.Request.Context(), info.UserID))
+		c.Next()
+	}
+}
+
+// scopeAllows reports whether a token with scope may make a request with method
+func scopeAllows(scope, method string) bool {
+	switch scope {
+	case models.TokenScopeNotesRead:
+		return method == http.MethodGet || method == http.MethodHead
+	default:
+		return false
+	}
+}
+
+// AdminMiddleware only lets administrators through. It must run after AuthMiddleware.
+func AdminMiddleware(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := authService.GetUserByID(c.Request.Context(), GetUserID(c))
+		if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+			response.InternalError(c, "failed to check permissions")
+			c.Abort()
+			return
+		}
+		if err != nil || !user.IsAdmin {
+			response.Forbidden(c, "administrator access required")
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -52,3 +110,24 @@ func GetUserID(c *gin.Context) uuid.UUID {
 	}
 	return uuid.Nil
 }
+
+// GetSessionID returns the login session of the current request's access token, or uuid.Nil if
+// the token predates session tracking
+func GetSessionID(c *gin.Context) uuid.UUID {
+	if sessionID, exists := c.Get(SessionIDKey); exists {
+		if id, ok := sessionID.(uuid.UUID); ok {
+			return id
+		}
+	}
+	return uuid.Nil
+}
+
+// GetTokenInfo returns the access token the request was authenticated with, or nil
+func GetTokenInfo(c *gin.Context) *services.TokenInfo {
+	if info, exists := c.Get(TokenInfoKey); exists {
+		if info, ok := info.(*services.TokenInfo); ok {
+			return info
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/captcha"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// CaptchaTokenHeader carries the token the client got by solving a CAPTCHA
+const CaptchaTokenHeader = "X-Captcha-Token"
+
+// RequireCaptcha rejects requests without a passed CAPTCHA with 403 captcha_required, so the client
+// knows to show the challenge and retry. required decides per request whether one is needed; nil
+// means always. A nil verifier, when no provider is configured, lets every request through.
+func RequireCaptcha(verifier captcha.Verifier, required func(c *gin.Context) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if verifier == nil || (required != nil && !required(c)) {
+			c.Next()
+			return
+		}
+
+		err := verifier.Verify(c.Request.Context(), c.GetHeader(CaptchaTokenHeader), c.ClientIP())
+		switch {
+		case err == nil:
+			c.Next()
+			return
+		case errors.Is(err, captcha.ErrMissingToken), errors.Is(err, captcha.ErrRejected):
+			logging.Security(c.Request.Context(), "CAPTCHA required", "route", c.FullPath(), "ip", c.ClientIP(), "error", err)
+			response.Error(c, http.StatusForbidden, "captcha_required",
+				"complete the CAPTCHA and send its token in the "+CaptchaTokenHeader+" header")
+		default:
+			slog.ErrorContext(c.Request.Context(), "CAPTCHA verification failed", "error", err)
+			response.InternalError(c, "failed to verify CAPTCHA")
+		}
+		c.Abort()
+	}
+}
+
+// CaptchaAfterFailures requires a CAPTCHA once the client's IP has failed to log in failures times
+func CaptchaAfterFailures(al *AuthRateLimiter, failures int) func(c *gin.Context) bool {
+	return func(c *gin.Context) bool {
+		return al.FailedAttempts(c.Request.Context(), c.ClientIP()) >= failures
+	}
+}
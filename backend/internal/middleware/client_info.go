@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/clientinfo"
+)
+
+// maxUserAgentLength bounds the user agents recorded for sessions
+const maxUserAgentLength = 512
+
+// ClientInfoMiddleware stores the client's IP address, user agent and device ID in the request
+// context, where login sessions are recorded from
+func ClientInfoMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userAgent := c.Request.UserAgent()
+		if len(userAgent) > maxUserAgentLength {
+			userAgent = userAgent[:maxUserAgentLength]
+		}
+
+		c.Request = c.Request.WithContext(clientinfo.WithContext(c.Request.Context(), clientinfo.Info{
+			IP:        c.ClientIP(),
+			UserAgent: userAgent,
+			DeviceID:  GetDeviceID(c),
+		}))
+		c.Next()
+	}
+}
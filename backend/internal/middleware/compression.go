@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// MaxDecompressedBodySize limits how large a gzip request body may expand to, so a small
+// compressed body can't exhaust memory
+const MaxDecompressedBodySize = 16 << 20 // 16MB
+
+var gzipWriterPool = sync.Pool{
+	New: func() any { return gzip.NewWriter(io.Discard) },
+}
+
+// gzipResponseWriter compresses the response body. Headers are switched to gzip on the first write,
+// so responses without a body (204s, aborted requests) are left alone.
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipResponseWriter) start() {
+	if w.gz != nil {
+		return
+	}
+	header := w.Header()
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.gz = gzipWriterPool.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.start()
+	return w.gz.Write(b)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	w.start()
+	return w.gz.Write([]byte(s))
+}
+
+// Flush sends what has been compressed so far, so streamed responses arrive as they're written
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	gzipWriterPool.Put(w.gz)
+	w.gz = nil
+}
+
+// CompressionMiddleware accepts gzip request bodies (Content-Encoding: gzip) and gzips responses
+// for clients that send Accept-Encoding: gzip. Apply it before IdempotencyMiddleware so stored
+// responses are kept uncompressed.
+func CompressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if encoding := c.GetHeader("Content-Encoding"); encoding != "" && encoding != "identity" {
+			if !strings.EqualFold(encoding, "gzip") {
+				response.UnsupportedMediaType(c, "unsupported Content-Encoding: use gzip")
+				c.Abort()
+				return
+			}
+			if !decompressRequest(c) {
+				c.Abort()
+				return
+			}
+		}
+
+		c.Header("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer writer.close()
+		c.Next()
+	}
+}
+
+// decompressRequest replaces a gzip request body with its decompressed contents. On failure it
+// writes the error response and returns false.
+func decompressRequest(c *gin.Context) bool {
+	gz, err := gzip.NewReader(c.Request.Body)
+	if err != nil {
+		response.BadRequest(c, "invalid gzip request body")
+		return false
+	}
+	defer gz.Close()
+
+	body, err := io.ReadAll(io.LimitReader(gz, MaxDecompressedBodySize+1))
+	if err != nil {
+		response.BadRequest(c, "invalid gzip request body")
+		return false
+	}
+	if len(body) > MaxDecompressedBodySize {
+		response.PayloadTooLarge(c, "decompressed request body is too large")
+		return false
+	}
+
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	c.Request.ContentLength = int64(len(body))
+	c.Request.Header.Del("Content-Encoding")
+	c.Request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return true
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, "gzip") && name != "*" {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if !ok {
+			return true
+		}
+		if v, err := strconv.ParseFloat(q, 64); err == nil && v > 0 {
+			return true
+		}
+	}
+	return false
+}
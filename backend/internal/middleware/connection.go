@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ConnectionIDHeader carries the WebSocket connection ID of the client making a REST request.
+// Clients receive their ID in the "connected" message sent after the WebSocket upgrade and
+// echo it back so the server can skip broadcasting a change to the device that made it.
+const ConnectionIDHeader = "X-Connection-ID"
+
+// GetConnectionID returns the sender's WebSocket connection ID from the request headers,
+// or an empty string if the header is missing or malformed
+func GetConnectionID(c *gin.Context) string {
+	connID := c.GetHeader(ConnectionIDHeader)
+	if connID == "" {
+		return ""
+	}
+	if _, err := uuid.Parse(connID); err != nil {
+		return ""
+	}
+	return connID
+}
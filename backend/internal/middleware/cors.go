@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"strings"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -20,7 +22,8 @@ func CORSMiddleware(allowedOrigins []string) gin.HandlerFunc {
 			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
 
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization, Accept, Origin, Cache-Control, X-Requested-With, X-CSRF-Token")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Content-Encoding, Accept-Encoding, Authorization, Accept, Origin, Cache-Control, X-Requested-With, X-CSRF-Token, X-Connection-ID, X-Request-ID, Idempotency-Key, X-Device-ID, X-API-Version, X-Captcha-Token")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Idempotent-Replayed, X-API-Version, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
 
@@ -33,12 +36,36 @@ func CORSMiddleware(allowedOrigins []string) gin.HandlerFunc {
 	}
 }
 
-// IsOriginAllowed checks if the given origin is in the allowed origins list
+// IsOriginAllowed checks if the given origin is in the allowed origins list. An entry like
+// https://*.example.com allows every subdomain of example.com (but not example.com itself) over
+// https on the default port.
 func IsOriginAllowed(origin string, allowedOrigins []string) bool {
+	origin = strings.ToLower(origin)
 	for _, allowed := range allowedOrigins {
-		if origin == allowed {
+		allowed = strings.ToLower(allowed)
+		scheme, suffix, wildcard := strings.Cut(allowed, "://*.")
+		if !wildcard {
+			if origin == allowed {
+				return true
+			}
+			continue
+		}
+		if !strings.HasPrefix(origin, scheme+"://") {
+			continue
+		}
+		// The subdomain part can't carry a port, credentials or path that would end the host early
+		host := strings.TrimPrefix(origin, scheme+"://")
+		if sub, found := strings.CutSuffix(host, "."+suffix); found && isHostLabels(sub) {
 			return true
 		}
 	}
 	return false
 }
+
+// isHostLabels reports whether s is one or more DNS labels: letters, digits, hyphens, underscores
+// and dots only
+func isHostLabels(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.')
+	}) < 0
+}
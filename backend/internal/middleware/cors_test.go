@@ -0,0 +1,48 @@
+package middleware
+
+import "testing"
+
+func TestIsOriginAllowed(t *testing.T) {
+	allowed := []string{"https://example.com", "https://*.example.com", "http://localhost:3000"}
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{name: "exact", origin: "https://example.com", want: true},
+		{name: "exact, upper case", origin: "HTTPS://EXAMPLE.COM", want: true},
+		{name: "exact with port", origin: "http://localhost:3000", want: true},
+		{name: "subdomain", origin: "https://a.example.com", want: true},
+		{name: "subdomain, mixed case", origin: "https://A.Example.COM", want: true},
+		{name: "nested subdomain", origin: "https://a.b.example.com", want: true},
+
+		{name: "empty", origin: ""},
+		{name: "opaque origin", origin: "null"},
+		{name: "wildcard entry itself", origin: "https://*.example.com"},
+		{name: "hyphenated lookalike", origin: "https://evil-example.com"},
+		{name: "no dot before suffix", origin: "https://evilexample.com"},
+		{name: "suffix followed by another domain", origin: "https://a.example.com.evil.com"},
+		{name: "empty subdomain", origin: "https://.example.com"},
+		{name: "other scheme", origin: "http://example.com"},
+		{name: "subdomain over other scheme", origin: "http://a.example.com"},
+		{name: "default port spelled out", origin: "https://example.com:443"},
+		{name: "subdomain with port", origin: "https://a.example.com:8443"},
+		{name: "port before suffix", origin: "https://evil.com:443.example.com"},
+		{name: "other port", origin: "http://localhost:3001"},
+		{name: "no port", origin: "http://localhost"},
+		{name: "userinfo", origin: "https://user@a.example.com"},
+		{name: "userinfo hiding the host", origin: "https://evil.com@a.example.com"},
+		{name: "path", origin: "https://a.example.com/"},
+		{name: "path before suffix", origin: "https://evil.com/.example.com"},
+		{name: "query before suffix", origin: "https://evil.com?.example.com"},
+		{name: "fragment before suffix", origin: "https://evil.com#.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsOriginAllowed(tt.origin, allowed); got != tt.want {
+				t.Errorf("IsOriginAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
@@ -52,11 +52,19 @@ func DefaultCSRFConfig(isProduction bool) CSRFConfig {
 			"/api/auth/register",
 			"/api/auth/refresh",
 			"/api/auth/logout",
+			"/api/auth/webauthn/login/begin",
+			"/api/auth/webauthn/login/finish",
+			"/api/auth/oauth/exchange",
+			"/api/auth/oauth/apple/callback", // Apple posts the callback form from its own site
+			"/api/auth/oauth/apple/token",
+			"/api/auth/oauth/google/token",
+			"/api/setup",
 			"/api/ws", // WebSocket uses its own auth mechanism
 		},
 		// Exempt paths that use Bearer token authentication (immune to CSRF)
 		ExemptPathPrefixes: []string{
-			"/api/notes", // Notes API uses JWT auth, not vulnerable to CSRF
+			"/api/notes",   // Notes API uses JWT auth, not vulnerable to CSRF
+			"/api/invites", // Invite acceptance uses JWT auth
 		},
 	}
 }
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+// DeviceIDHeader carries the ID a client registered with POST /api/devices, so the server can track
+// each device's sync cursor
+const DeviceIDHeader = "X-Device-ID"
+
+// GetDeviceID returns the sender's device ID from the request headers, or an empty string if the
+// header is missing or too long
+func GetDeviceID(c *gin.Context) string {
+	deviceID := c.GetHeader(DeviceIDHeader)
+	if len(deviceID) > models.MaxDeviceIDLength {
+		return ""
+	}
+	return deviceID
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+const (
+	// IdempotencyKeyHeader lets a client retry a request safely: a repeat with the same key gets the
+	// original response instead of running again
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set to "true" on responses replayed from an earlier request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// bodyCaptureWriter keeps a copy of the response body as it is written
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *bodyCaptureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// IdempotencyMiddleware honors the Idempotency-Key header on the routes it's applied to. Must run
+// after AuthMiddleware, since keys are scoped to the user. Responses below 500 are stored and replayed
+// for retries; server errors release the key so the request can be retried for real.
+func IdempotencyMiddleware(idempotency *services.IdempotencyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > models.MaxIdempotencyKeyLength {
+			response.BadRequest(c, "Idempotency-Key is too long")
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			response.BadRequest(c, "failed to read request body")
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := GetUserID(c)
+		route := c.Request.Method + " " + c.FullPath()
+		ctx := c.Request.Context()
+
+		// The query is part of the request too, so a dry run can't be replayed as the real sync
+		fingerprint := append([]byte(c.Request.URL.RawQuery+"\n"), body...)
+		stored, err := idempotency.Begin(ctx, userID, key, route, fingerprint)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyKeyReused):
+			response.Error(c, http.StatusUnprocessableEntity, "idempotency_key_reused", err.Error())
+			c.Abort()
+			return
+		case errors.Is(err, services.ErrIdempotencyKeyInProgress):
+			response.Conflict(c, err.Error())
+			c.Abort()
+			return
+		case err != nil:
+			slog.ErrorContext(ctx, "Failed to reserve idempotency key", "error", err)
+			response.InternalError(c, "failed to process request")
+			c.Abort()
+			return
+		case stored != nil:
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Data(*stored.StatusCode, stored.ContentType, stored.Response)
+			c.Abort()
+			return
+		}
+
+		writer := &bodyCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		// The request may have been cancelled by now, but the outcome still needs recording
+		ctx = context.WithoutCancel(ctx)
+		if status := writer.Status(); status < http.StatusInternalServerError {
+			err = idempotency.Complete(ctx, userID, key, status, writer.Header().Get("Content-Type"), writer.body.Bytes())
+		} else {
+			err = idempotency.Release(ctx, userID, key)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record idempotency key outcome", "error", err)
+		}
+	}
+}
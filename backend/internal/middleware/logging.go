@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// RequestLogger logs each request once it has been handled, at warning level for server errors.
+// It goes after RequestIDMiddleware and TracingMiddleware so the entry carries their IDs.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		attrs := []any{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"bytes", max(c.Writer.Size(), 0),
+			"ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		slog.Log(c.Request.Context(), level, "Request handled", attrs...)
+	}
+}
+
+// Recovery turns a panicking handler into a 500 and logs the panic with its stack, in place of
+// gin's recovery, which writes plain text to stderr
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		slog.ErrorContext(c.Request.Context(), "Handler panicked", "panic", err, "stack", string(debug.Stack()))
+		if !c.Writer.Written() {
+			response.InternalError(c, "internal server error")
+		}
+		c.Abort()
+	})
+}
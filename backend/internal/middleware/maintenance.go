@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// maintenanceRetryAfter is how long clients are asked to wait when nobody said when maintenance ends
+const maintenanceRetryAfter = 5 * time.Minute
+
+const maintenanceKey = "maintenance"
+
+// MaintenanceMiddleware refuses requests that would change anything with 503 and a Retry-After
+// while maintenance mode is on. Reads go on as usual, as do routes whose path, as registered, starts
+// with one of exempt, such as signing in and the admin routes that turn maintenance mode off. It
+// goes after ReadRoutes, whose routes are let through for their handlers to refuse changes with
+// RefuseWrites.
+func MaintenanceMiddleware(maintenance *services.MaintenanceService, exempt []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m := maintenance.Current()
+		if !m.Enabled || c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		for _, prefix := range exempt {
+			if strings.HasPrefix(c.FullPath(), prefix) {
+				c.Next()
+				return
+			}
+		}
+		if isReadRoute(c) {
+			c.Set(maintenanceKey, m)
+			c.Next()
+			return
+		}
+
+		refuseForMaintenance(c, m)
+		c.Abort()
+	}
+}
+
+// refuseForMaintenance answers a request that would change something during maintenance
+func refuseForMaintenance(c *gin.Context, m *models.Maintenance) {
+	retryAfter := maintenanceRetryAfter
+	if m.Until != nil {
+		retryAfter = time.Until(*m.Until)
+	}
+	message := m.Message
+	if message == "" {
+		message = "the server is down for maintenance; changes can't be saved until it's over"
+	}
+	c.Header("Retry-After", retryAfterSeconds(retryAfter))
+	response.Error(c, http.StatusServiceUnavailable, "maintenance", message)
+}
@@ -1,14 +1,52 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
-// RateLimiter implements a simple token bucket rate limiter
+// Limiter decides whether a client, identified by key, may make another request
+type Limiter interface {
+	Allow(ctx context.Context, key string) RateLimit
+}
+
+// RateLimit is a limiter's decision on a request, and what is left of the client's budget after it
+type RateLimit struct {
+	Allowed    bool
+	Limit      int           // requests the client may make at once with a full budget
+	Remaining  int           // requests the client may make at once now
+	Reset      time.Duration // until the budget is full again
+	RetryAfter time.Duration // until another request would be allowed, when this one wasn't
+}
+
+// setRateLimitHeaders tells the client about its budget, so it can back off instead of guessing.
+// X-RateLimit-Reset is when the budget is full again, in Unix seconds; Retry-After is set on
+// refusals, in seconds.
+func setRateLimitHeaders(c *gin.Context, rl RateLimit) {
+	header := c.Writer.Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(rl.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(rl.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(rl.Reset+time.Second-1).Unix(), 10))
+	if !rl.Allowed {
+		header.Set("Retry-After", retryAfterSeconds(rl.RetryAfter))
+	}
+}
+
+// retryAfterSeconds rounds d up to whole seconds, as retrying sooner would be refused
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.FormatInt(max(int64((d+time.Second-1)/time.Second), 1), 10)
+}
+
+// RateLimiter implements a simple token bucket rate limiter. Its buckets are kept in memory, so
+// they start afresh on restart and each instance has its own; see RedisRateLimiter for sharing them.
 type RateLimiter struct {
 	requests    int           // requests per interval
 	interval    time.Duration // time interval
@@ -37,7 +75,7 @@ func NewRateLimiter(requests int, interval time.Duration, burst int) *RateLimite
 }
 
 // Allow checks if a request from the given key should be allowed
-func (rl *RateLimiter) Allow(key string) bool {
+func (rl *RateLimiter) Allow(_ context.Context, key string) RateLimit {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
@@ -45,11 +83,12 @@ func (rl *RateLimiter) Allow(key string) bool {
 	client, exists := rl.clients[key]
 
 	if !exists {
-		rl.clients[key] = &clientBucket{
+		client = &clientBucket{
 			tokens:     float64(rl.burst - 1),
 			lastAccess: now,
 		}
-		return true
+		rl.clients[key] = client
+		return rl.result(true, client.tokens)
 	}
 
 	// Calculate tokens to add based on time elapsed
@@ -60,10 +99,25 @@ func (rl *RateLimiter) Allow(key string) bool {
 
 	if client.tokens >= 1 {
 		client.tokens--
-		return true
+		return rl.result(true, client.tokens)
 	}
 
-	return false
+	return rl.result(false, client.tokens)
+}
+
+// result describes a bucket left with tokens
+func (rl *RateLimiter) result(allowed bool, tokens float64) RateLimit {
+	perToken := rl.interval / time.Duration(rl.requests)
+	result := RateLimit{
+		Allowed:   allowed,
+		Limit:     rl.burst,
+		Remaining: int(tokens),
+		Reset:     time.Duration((float64(rl.burst) - tokens) * float64(perToken)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * float64(perToken))
+	}
+	return result
 }
 
 // cleanup removes stale entries
@@ -81,13 +135,28 @@ func (rl *RateLimiter) cleanup() {
 	}
 }
 
-// RateLimitMiddleware returns a Gin middleware for rate limiting
-func RateLimitMiddleware(rl *RateLimiter) gin.HandlerFunc {
+// RateLimitMiddleware returns a Gin middleware for rate limiting. Requests are metered by client
+// IP, except that with users set those carrying a genuine access token are metered by its user, so
+// people sharing an address, such as behind carrier-grade NAT, don't share a budget. Tokens that
+// don't check out leave the request metered by IP, so sending one can't get around the limit.
+func RateLimitMiddleware(rl Limiter, users *UserRateLimits) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Use IP address as the key
 		key := c.ClientIP()
+		limiter := rl
 
-		if !rl.Allow(key) {
+		if users != nil {
+			if token, ok := bearerToken(c); ok {
+				if userID, err := users.Identify(token); err == nil {
+					key = userID.String()
+					limiter = users.limiterFor(c.FullPath())
+				}
+			}
+		}
+
+		result := limiter.Allow(c.Request.Context(), key)
+		setRateLimitHeaders(c, result)
+		if !result.Allowed {
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error": "rate limit exceeded, please try again later",
 			})
@@ -99,80 +168,159 @@ func RateLimitMiddleware(rl *RateLimiter) gin.HandlerFunc {
 	}
 }
 
-// AuthRateLimiter is a stricter rate limiter for authentication endpoints
+// UserRateLimits meter authenticated requests by user, with syncing on a budget of its own so a
+// device catching up doesn't leave the user unable to do anything else
+type UserRateLimits struct {
+	Requests   Limiter
+	Sync       Limiter
+	SyncRoutes []string // routes, as registered, that draw on the Sync budget
+
+	// Identify returns the user an access token was issued to, or an error if it wasn't issued by
+	// this server. It's run on every request carrying a token, so shouldn't touch the database;
+	// AuthMiddleware still checks the token properly.
+	Identify func(token string) (uuid.UUID, error)
+}
+
+// limiterFor returns the budget requests to route draw on
+func (u *UserRateLimits) limiterFor(route string) Limiter {
+	if slices.Contains(u.SyncRoutes, route) {
+		return u.Sync
+	}
+	return u.Requests
+}
+
+// bearerToken returns the access token the request carries, if any
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+const (
+	// Auth endpoints allow authRateLimitRequests a minute per IP, with bursts of authRateLimitBurst
+	authRateLimitRequests = 5
+	authRateLimitBurst    = 10
+
+	// An IP is locked out of them for authLockoutDuration after authMaxFailedAttempts failures
+	authMaxFailedAttempts = 5
+	authLockoutDuration   = 15 * time.Minute
+)
+
+// AuthRateLimiter is a stricter rate limiter for authentication endpoints, which also locks out
+// IPs after repeated failed attempts
 type AuthRateLimiter struct {
-	*RateLimiter
-	failedAttempts map[string]int
-	lockoutTime    map[string]time.Time
-	mu             sync.RWMutex
+	Limiter
+	failures loginFailures
+}
+
+// loginFailures counts failed attempts per key and locks keys out after too many
+type loginFailures interface {
+	record(ctx context.Context, key string)
+	reset(ctx context.Context, key string)
+	count(ctx context.Context, key string) int
+	lockedOutFor(ctx context.Context, key string) time.Duration
 }
 
 // NewAuthRateLimiter creates a rate limiter specifically for auth endpoints
 // with additional protection against brute force attacks
 func NewAuthRateLimiter() *AuthRateLimiter {
-	al := &AuthRateLimiter{
-		RateLimiter:    NewRateLimiter(5, time.Minute, 10), // 5 requests per minute, burst of 10
+	return &AuthRateLimiter{
+		Limiter:  NewRateLimiter(authRateLimitRequests, time.Minute, authRateLimitBurst),
+		failures: newMemoryLoginFailures(),
+	}
+}
+
+// RecordFailedAttempt records a failed login attempt
+func (al *AuthRateLimiter) RecordFailedAttempt(ctx context.Context, key string) {
+	al.failures.record(ctx, key)
+}
+
+// ResetFailedAttempts resets the failed attempt counter on successful login
+func (al *AuthRateLimiter) ResetFailedAttempts(ctx context.Context, key string) {
+	al.failures.reset(ctx, key)
+}
+
+// FailedAttempts returns how many failed logins have been recorded since the last success
+func (al *AuthRateLimiter) FailedAttempts(ctx context.Context, key string) int {
+	return al.failures.count(ctx, key)
+}
+
+// LockedOutFor returns how much longer an IP is locked out for, or 0 if it isn't
+func (al *AuthRateLimiter) LockedOutFor(ctx context.Context, key string) time.Duration {
+	return al.failures.lockedOutFor(ctx, key)
+}
+
+// memoryLoginFailures keeps failed attempts in memory
+type memoryLoginFailures struct {
+	failedAttempts map[string]int
+	lockoutTime    map[string]time.Time
+	mu             sync.RWMutex
+}
+
+func newMemoryLoginFailures() *memoryLoginFailures {
+	f := &memoryLoginFailures{
 		failedAttempts: make(map[string]int),
 		lockoutTime:    make(map[string]time.Time),
 	}
-	go al.cleanupLockouts()
-	return al
+	go f.cleanupLockouts()
+	return f
 }
 
 // cleanupLockouts removes expired lockout entries periodically
-func (al *AuthRateLimiter) cleanupLockouts() {
+func (f *memoryLoginFailures) cleanupLockouts() {
 	ticker := time.NewTicker(5 * time.Minute)
 	for range ticker.C {
-		al.mu.Lock()
+		f.mu.Lock()
 		now := time.Now()
-		for key, lockout := range al.lockoutTime {
+		for key, lockout := range f.lockoutTime {
 			if now.After(lockout) {
-				delete(al.lockoutTime, key)
-				delete(al.failedAttempts, key)
+				delete(f.lockoutTime, key)
+				delete(f.failedAttempts, key)
 			}
 		}
-		al.mu.Unlock()
+		f.mu.Unlock()
 	}
 }
 
-// RecordFailedAttempt records a failed login attempt
-func (al *AuthRateLimiter) RecordFailedAttempt(key string) {
-	al.mu.Lock()
-	defer al.mu.Unlock()
+func (f *memoryLoginFailures) record(_ context.Context, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	al.failedAttempts[key]++
+	f.failedAttempts[key]++
 
-	// Lockout after 5 failed attempts
-	if al.failedAttempts[key] >= 5 {
-		al.lockoutTime[key] = time.Now().Add(15 * time.Minute)
+	if f.failedAttempts[key] >= authMaxFailedAttempts {
+		f.lockoutTime[key] = time.Now().Add(authLockoutDuration)
 	}
 }
 
-// ResetFailedAttempts resets the failed attempt counter on successful login
-func (al *AuthRateLimiter) ResetFailedAttempts(key string) {
-	al.mu.Lock()
-	defer al.mu.Unlock()
+func (f *memoryLoginFailures) reset(_ context.Context, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	delete(al.failedAttempts, key)
-	delete(al.lockoutTime, key)
+	delete(f.failedAttempts, key)
+	delete(f.lockoutTime, key)
 }
 
-// IsLockedOut checks if an IP is currently locked out
-func (al *AuthRateLimiter) IsLockedOut(key string) bool {
-	al.mu.RLock()
-	defer al.mu.RUnlock()
+func (f *memoryLoginFailures) count(_ context.Context, key string) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 
-	lockout, exists := al.lockoutTime[key]
-	if !exists {
-		return false
-	}
+	return f.failedAttempts[key]
+}
+
+func (f *memoryLoginFailures) lockedOutFor(_ context.Context, key string) time.Duration {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 
-	if time.Now().After(lockout) {
-		// Lockout expired, will be cleaned up later
-		return false
+	lockout, exists := f.lockoutTime[key]
+	if !exists {
+		return 0
 	}
 
-	return true
+	// An expired lockout is cleaned up later
+	return max(time.Until(lockout), 0)
 }
 
 // AuthRateLimitMiddleware returns a Gin middleware for auth rate limiting
@@ -181,7 +329,8 @@ func AuthRateLimitMiddleware(al *AuthRateLimiter) gin.HandlerFunc {
 		key := c.ClientIP()
 
 		// Check if locked out
-		if al.IsLockedOut(key) {
+		if lockout := al.LockedOutFor(c.Request.Context(), key); lockout > 0 {
+			c.Header("Retry-After", retryAfterSeconds(lockout))
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error": "too many failed attempts, please try again later",
 			})
@@ -189,8 +338,10 @@ func AuthRateLimitMiddleware(al *AuthRateLimiter) gin.HandlerFunc {
 			return
 		}
 
-		// Check rate limit
-		if !al.Allow(key) {
+		// Check rate limit; its headers replace the general limiter's, as it's the stricter one
+		result := al.Allow(c.Request.Context(), key)
+		setRateLimitHeaders(c, result)
+		if !result.Allowed {
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error": "rate limit exceeded, please try again later",
 			})
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/redis"
+)
+
+const (
+	rateLimitKeyPrefix = "notes:ratelimit:"
+
+	// A rate limit check is on every request's path, so a slow Redis is given up on quickly
+	redisRateLimitTimeout = 500 * time.Millisecond
+)
+
+// gcraScript is the generic cell rate algorithm: KEYS[1] holds the theoretical arrival time of the
+// next request in microseconds, which each allowed request pushes back by one emission interval
+// (ARGV[1]). A request is refused when that would put it more than the burst tolerance (ARGV[2])
+// ahead of now. Time comes from the server so instances with skewed clocks agree. The reply is
+// whether the request was allowed, the requests left, and the microseconds until the budget is full
+// and until another request would be allowed.
+var gcraScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local next_tat = tat + interval
+if next_tat - now > tolerance then
+	return {0, 0, tat - now, next_tat - now - tolerance}
+end
+redis.call('SET', KEYS[1], string.format('%d', next_tat), 'PX', math.max(1, math.ceil((next_tat - now) / 1000)))
+return {1, math.floor((tolerance - (next_tat - now)) / interval), next_tat - now, 0}
+`)
+
+// RedisRateLimiter allows requests at the same rate and burst as RateLimiter, but keeps its counts
+// in Redis so every instance shares them and they survive restarts. While Redis can't be reached it
+// falls back to counting in memory.
+type RedisRateLimiter struct {
+	client    *redis.Client
+	name      string
+	interval  time.Duration
+	tolerance time.Duration
+	burst     int
+	fallback  *RateLimiter
+	degraded  atomic.Bool
+}
+
+// NewRedisRateLimiter creates a rate limiter allowing rate requests per window, with bursts of up
+// to burst, whose counts are kept in Redis under keys named after name
+func NewRedisRateLimiter(client *redis.Client, name string, rate int, window time.Duration, burst int) *RedisRateLimiter {
+	interval := window / time.Duration(rate)
+	return &RedisRateLimiter{
+		client:    client,
+		name:      name,
+		interval:  interval,
+		tolerance: interval * time.Duration(burst),
+		burst:     burst,
+		fallback:  NewRateLimiter(rate, window, burst),
+	}
+}
+
+// Allow checks if a request from the given key should be allowed
+func (rl *RedisRateLimiter) Allow(ctx context.Context, key string) RateLimit {
+	ctx, cancel := context.WithTimeout(ctx, redisRateLimitTimeout)
+	defer cancel()
+
+	reply, err := gcraScript.Run(ctx, rl.client, []string{rateLimitKeyPrefix + rl.name + ":" + key},
+		strconv.FormatInt(rl.interval.Microseconds(), 10),
+		strconv.FormatInt(rl.tolerance.Microseconds(), 10),
+	)
+	if err != nil {
+		if rl.degraded.CompareAndSwap(false, true) {
+			slog.WarnContext(ctx, "Rate limiter can't reach Redis; counting in memory", "limiter", rl.name, "error", err)
+		}
+		return rl.fallback.Allow(ctx, key)
+	}
+	if rl.degraded.CompareAndSwap(true, false) {
+		slog.InfoContext(ctx, "Rate limiter reached Redis again", "limiter", rl.name)
+	}
+
+	values, _ := reply.([]any)
+	field := func(i int) int64 {
+		if i < len(values) {
+			n, _ := values[i].(int64)
+			return n
+		}
+		return 0
+	}
+	return RateLimit{
+		Allowed:    field(0) == 1,
+		Limit:      rl.burst,
+		Remaining:  int(field(1)),
+		Reset:      time.Duration(field(2)) * time.Microsecond,
+		RetryAfter: time.Duration(field(3)) * time.Microsecond,
+	}
+}
+
+// NewRedisAuthRateLimiter creates an AuthRateLimiter whose rate limits and failed attempts are kept
+// in Redis, so a lockout on one instance holds on all of them
+func NewRedisAuthRateLimiter(client *redis.Client) *AuthRateLimiter {
+	return &AuthRateLimiter{
+		Limiter:  NewRedisRateLimiter(client, "auth", authRateLimitRequests, time.Minute, authRateLimitBurst),
+		failures: &redisLoginFailures{client: client},
+	}
+}
+
+// recordFailureScript counts a failed attempt in KEYS[1] and, once there have been ARGV[1] of them,
+// locks the key out by setting KEYS[2]. Both expire after the lockout duration (ARGV[2]).
+var recordFailureScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+if count >= tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[2], '1', 'PX', ARGV[2])
+end
+return count
+`)
+
+// redisLoginFailures keeps failed attempts in Redis. If Redis can't be reached attempts go
+// uncounted, leaving the lockouts on accounts themselves to stop guessing.
+type redisLoginFailures struct {
+	client *redis.Client
+}
+
+func (f *redisLoginFailures) keys(key string) (failures, lockout string) {
+	return rateLimitKeyPrefix + "auth-failures:" + key, rateLimitKeyPrefix + "auth-lockout:" + key
+}
+
+func (f *redisLoginFailures) record(ctx context.Context, key string) {
+	ctx, cancel := context.WithTimeout(ctx, redisRateLimitTimeout)
+	defer cancel()
+
+	failures, lockout := f.keys(key)
+	_, err := recordFailureScript.Run(ctx, f.client, []string{failures, lockout},
+		strconv.Itoa(authMaxFailedAttempts),
+		strconv.FormatInt(authLockoutDuration.Milliseconds(), 10),
+	)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to record failed login attempt in Redis", "ip", key, "error", err)
+	}
+}
+
+func (f *redisLoginFailures) reset(ctx context.Context, key string) {
+	ctx, cancel := context.WithTimeout(ctx, redisRateLimitTimeout)
+	defer cancel()
+
+	failures, lockout := f.keys(key)
+	if _, err := f.client.Do(ctx, "DEL", failures, lockout); err != nil {
+		slog.WarnContext(ctx, "Failed to reset failed login attempts in Redis", "ip", key, "error", err)
+	}
+}
+
+func (f *redisLoginFailures) count(ctx context.Context, key string) int {
+	ctx, cancel := context.WithTimeout(ctx, redisRateLimitTimeout)
+	defer cancel()
+
+	failures, _ := f.keys(key)
+	reply, err := f.client.Do(ctx, "GET", failures)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read failed login attempts from Redis", "ip", key, "error", err)
+		return 0
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return 0
+	}
+	count, _ := strconv.Atoi(string(value))
+	return count
+}
+
+func (f *redisLoginFailures) lockedOutFor(ctx context.Context, key string) time.Duration {
+	ctx, cancel := context.WithTimeout(ctx, redisRateLimitTimeout)
+	defer cancel()
+
+	// PTTL is negative when there is no lockout
+	_, lockout := f.keys(key)
+	reply, err := f.client.Do(ctx, "PTTL", lockout)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to check login lockout in Redis", "ip", key, "error", err)
+		return 0
+	}
+	ttl, _ := reply.(int64)
+	return time.Duration(max(ttl, 0)) * time.Millisecond
+}
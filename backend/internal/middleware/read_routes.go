@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+const readRouteKey = "readRoute"
+
+// ReadRoutes marks the routes, as registered, that are sent with POST but may only read, such as
+// a sync without changes. Read-only accounts, and requests during maintenance, are let through to
+// them, and their handlers call RefuseWrites before changing anything.
+func ReadRoutes(routes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if slices.Contains(routes, c.FullPath()) {
+			c.Set(readRouteKey, true)
+		}
+		c.Next()
+	}
+}
+
+// isReadRoute reports whether the request is to one of ReadRoutes
+func isReadRoute(c *gin.Context) bool {
+	return c.GetBool(readRouteKey)
+}
+
+// RefuseWrites is for handlers of ReadRoutes about to change something: it answers requests by
+// read-only accounts with 403, and requests during maintenance with 503 as MaintenanceMiddleware
+// would, and reports whether it did
+func RefuseWrites(c *gin.Context) bool {
+	if info := GetTokenInfo(c); info != nil && info.ReadOnly {
+		response.Forbidden(c, services.ErrReadOnlyAccount.Error())
+		return true
+	}
+	if m, ok := c.Get(maintenanceKey); ok {
+		refuseForMaintenance(c, m.(*models.Maintenance))
+		return true
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
+)
+
+// RequestIDHeader carries the request ID. A valid client-supplied ID is reused so
+// callers can correlate their own logs; otherwise a new one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+const RequestIDKey = "requestID"
+
+// maxRequestIDLength bounds client-supplied IDs before they reach the logs
+const maxRequestIDLength = 64
+
+// RequestIDMiddleware assigns every request an ID, echoes it in the response
+// headers and stores it in both the Gin context and the request context
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !isValidRequestID(id) {
+			id = uuid.New().String()
+		}
+
+		c.Set(RequestIDKey, id)
+		c.Request = c.Request.WithContext(requestid.WithContext(c.Request.Context(), id))
+		c.Writer.Header().Set(RequestIDHeader, id)
+
+		c.Next()
+	}
+}
+
+// GetRequestID returns the current request's ID
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// isValidRequestID accepts short IDs made of letters, digits, '-', '_' and '.'
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
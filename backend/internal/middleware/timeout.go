@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/pkg/response"
+)
+
+// TimeoutMiddleware gives each request a deadline, after which its context is cancelled so
+// database queries and other calls made with it give up, and the client gets 504 with error
+// timeout instead of whatever the handler made of that. routeTimeouts sets the deadline for routes
+// whose path, as registered, starts with a key, the longest matching key winning; other routes get
+// defaultTimeout. A timeout of 0 leaves a route without a deadline, for streams and long-lived
+// connections. Requests untimed reports true for get no deadline either, for routes that only
+// stream some of their responses; untimed may be nil.
+func TimeoutMiddleware(defaultTimeout time.Duration, routeTimeouts map[string]time.Duration, untimed func(c *gin.Context) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if untimed != nil && untimed(c) {
+			c.Next()
+			return
+		}
+
+		timeout := defaultTimeout
+		matched := ""
+		for prefix, routeTimeout := range routeTimeouts {
+			if strings.HasPrefix(c.FullPath(), prefix) && len(prefix) > len(matched) {
+				timeout, matched = routeTimeout, prefix
+			}
+		}
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		writer := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.timedOut || (errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Writer.Written()) {
+			// Drop what the handler meant to send with the response it no longer gets
+			header := c.Writer.Header()
+			header.Del("Content-Encoding")
+			header.Del("Content-Disposition")
+			header.Del("Content-Length")
+			response.GatewayTimeout(c, "the request took too long")
+		}
+	}
+}
+
+// timeoutWriter holds back what a handler writes once its deadline has passed, unless the response
+// was already under way, so TimeoutMiddleware can answer instead
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx      context.Context
+	timedOut bool
+}
+
+func (w *timeoutWriter) late() bool {
+	if !w.timedOut && !w.ResponseWriter.Written() && errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if !w.late() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	if !w.late() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if w.late() {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	if w.late() {
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *timeoutWriter) Flush() {
+	if !w.late() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ActivitySummaryTopNotes is how many of the most-edited notes a summary lists
+const ActivitySummaryTopNotes = 3
+
+// ActivitySubscription records a user's opt-in to the monthly activity summary email
+type ActivitySubscription struct {
+	UserID     uuid.UUID
+	Email      string
+	LastPeriod *time.Time // first day of the last month a summary was sent for
+	CreatedAt  time.Time
+}
+
+// ActivityStats aggregates a user's activity over [PeriodStart, PeriodEnd)
+type ActivityStats struct {
+	PeriodStart             time.Time
+	PeriodEnd               time.Time
+	NotesCreated            int
+	ChecklistItemsCompleted int
+	MostEdited              []EditedNote
+}
+
+// IsEmpty reports whether there was no activity to summarize
+func (s *ActivityStats) IsEmpty() bool {
+	return s.NotesCreated == 0 && s.ChecklistItemsCompleted == 0 && len(s.MostEdited) == 0
+}
+
+// EditedNote is a note and the number of revisions saved for it in a period
+type EditedNote struct {
+	NoteID uuid.UUID
+	Title  string
+	Edits  int
+}
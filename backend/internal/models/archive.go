@@ -0,0 +1,59 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ArchiveFormat identifies the layout of an archive and how its hashes and signature are computed
+const ArchiveFormat = "notes-archive/1"
+
+// ArchiveSignatureAlgorithm is the algorithm archives are signed with
+const ArchiveSignatureAlgorithm = "Ed25519"
+
+// Values for ArchiveEntry.Kind
+const (
+	ArchiveEntryRevision = "revision" // a saved version of a note, oldest first
+	ArchiveEntryNote     = "note"     // a note as it is at export time
+)
+
+// Archive is a signed, hash-chained export of a user's notes and their history. Each export's
+// PrevHash is the HeadHash of the user's previous export, so the exports form one chain too.
+type Archive struct {
+	Format             string         `json:"format"`
+	ExportID           string         `json:"exportId"`
+	UserID             string         `json:"userId"`
+	CreatedAt          string         `json:"createdAt"`
+	PrevHash           string         `json:"prevHash"`
+	EntryCount         int            `json:"entryCount"`
+	Entries            []ArchiveEntry `json:"entries"`
+	HeadHash           string         `json:"headHash"`
+	SignatureAlgorithm string         `json:"signatureAlgorithm"`
+	PublicKey          string         `json:"publicKey"` // base64
+	Signature          string         `json:"signature"` // base64, over the header fields and HeadHash
+}
+
+// ArchiveEntry is one record in an archive. Hash covers PrevHash, so no entry can be changed,
+// removed or reordered without breaking the chain.
+type ArchiveEntry struct {
+	Seq        int             `json:"seq"`
+	Kind       string          `json:"kind"`
+	NoteID     string          `json:"noteId"`
+	RecordedAt string          `json:"recordedAt"`
+	Data       json.RawMessage `json:"data"` // the note as saved
+	PrevHash   string          `json:"prevHash"`
+	Hash       string          `json:"hash"`
+}
+
+// ArchiveExport is the server's record of an export. Records are never changed, so an archive can
+// later be matched against the export it claims to be.
+type ArchiveExport struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	PrevHash   string
+	HeadHash   string
+	EntryCount int
+	CreatedAt  time.Time
+}
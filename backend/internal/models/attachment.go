@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxAttachmentFilenameLength limits the stored original file name
+const MaxAttachmentFilenameLength = 255
+
+// Attachment is an audio file (such as a voice memo) attached to a note.
+// The file itself is kept in the attachment store under the attachment's ID.
+type Attachment struct {
+	ID          uuid.UUID `json:"id"`
+	NoteID      uuid.UUID `json:"noteId"`
+	UserID      uuid.UUID `json:"userId"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Format      string    `json:"format"`
+	SizeBytes   int64     `json:"sizeBytes"`
+	DurationMs  int64     `json:"durationMs"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
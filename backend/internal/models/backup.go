@@ -0,0 +1,36 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Backups are newline-delimited JSON: a BackupHeader, a BackupRecord for each row, and a closing
+// BackupRecord with End set, so a backup cut short can be told apart from a complete one.
+const (
+	BackupFormat  = "notes-backup"
+	BackupVersion = 1
+)
+
+// Backup scopes
+const (
+	BackupScopeInstance = "instance" // every user, and the instance's settings
+	BackupScopeUser     = "user"     // one user's account and notes
+)
+
+// BackupHeader is the first line of a backup
+type BackupHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Scope     string    `json:"scope"`
+	UserID    string    `json:"userId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BackupRecord is a row of a table, or with End set, the end of the backup and how many rows it has
+type BackupRecord struct {
+	Table string          `json:"table,omitempty"`
+	Row   json.RawMessage `json:"row,omitempty"`
+	End   bool            `json:"end,omitempty"`
+	Rows  int64           `json:"rows,omitempty"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DevicePlatform identifies the kind of client a device runs
+type DevicePlatform string
+
+const (
+	DevicePlatformIOS     DevicePlatform = "ios"
+	DevicePlatformMacOS   DevicePlatform = "macos"
+	DevicePlatformAndroid DevicePlatform = "android"
+	DevicePlatformWeb     DevicePlatform = "web"
+	DevicePlatformOther   DevicePlatform = "other"
+)
+
+// ValidDevicePlatforms contains all allowed platforms
+var ValidDevicePlatforms = map[DevicePlatform]bool{
+	DevicePlatformIOS:     true,
+	DevicePlatformMacOS:   true,
+	DevicePlatformAndroid: true,
+	DevicePlatformWeb:     true,
+	DevicePlatformOther:   true,
+}
+
+// MaxDeviceIDLength limits client-chosen device IDs
+const MaxDeviceIDLength = 100
+
+// DeviceStaleAfter is how long a device can go without syncing before it's reported as stale
+const DeviceStaleAfter = 30 * 24 * time.Hour
+
+// Device is a client installation registered by a user. DeviceID is chosen by the client.
+type Device struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	DeviceID   string
+	Name       string
+	Platform   DevicePlatform
+	LastSyncAt *time.Time // the serverTimestamp of the device's last sync
+	LastSeenAt time.Time
+	CreatedAt  time.Time
+	RevokedAt  *time.Time
+}
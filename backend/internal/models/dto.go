@@ -1,19 +1,126 @@
 package models
 
-// NoteDTO matches the iOS DTOModels.swift structure
+import (
+	"errors"
+	"time"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/hlc"
+)
+
+// NoteDTO matches the iOS DTOModels.swift structure. Language, IsMonospace and Metadata are left
+// out by apps that predate them; a note saved without them keeps their stored values, while an empty
+// language or metadata object clears them.
 type NoteDTO struct {
 	ID             string             `json:"id"`
 	Title          string             `json:"title"`
 	Content        string             `json:"content"`
 	NoteType       string             `json:"noteType"`
+	Language       *string            `json:"language,omitempty"`
+	IsMonospace    *bool              `json:"isMonospace"`
 	IsPinned       bool               `json:"isPinned"`
 	IsArchived     bool               `json:"isArchived"`
 	SortOrder      int                `json:"sortOrder"`
 	CreatedAt      string             `json:"createdAt"`
 	UpdatedAt      string             `json:"updatedAt"`
+	Metadata       map[string]string  `json:"metadata,omitempty"` // nil when left out
 	ChecklistItems []ChecklistItemDTO `json:"checklistItems,omitempty"`
+	LinkPreviews   []LinkPreviewDTO   `json:"linkPreviews,omitempty"` // read-only, filled in by the server
+	Attachments    []AttachmentDTO    `json:"attachments,omitempty"`  // read-only, managed via the attachments endpoints
+
+	// ContentHash identifies the content version (hex SHA-256), for use as a delta's baseHash
+	ContentHash string `json:"contentHash,omitempty"`
+
+	// ContentDelta, in sync changes, replaces Content with a delta against an earlier version
+	ContentDelta *ContentDeltaDTO `json:"contentDelta,omitempty"`
+
+	// IsPartial marks a note from a lite response: content is only a preview and checklistItems only
+	// lists items changed since the request's lastSync/since. Fetch the full note before editing it;
+	// saving a partial note is refused.
+	IsPartial bool `json:"isPartial,omitempty"`
+
+	// HLC is the hybrid logical clock timestamp of the edit ("<unix ms>-<counter>-<node>"). Sync
+	// orders concurrent edits by it rather than by updatedAt, so a skewed device clock can't win.
+	HLC string `json:"hlc,omitempty"`
+
+	// Version is incremented on every update. PUT /api/notes/:id takes the version the client last
+	// loaded and fails with a conflict if the note has changed since.
+	Version int `json:"version,omitempty"`
+
+	// ChecklistSummary, in listings with include=counts, counts the checklist items left out
+	ChecklistSummary *ChecklistSummaryDTO `json:"checklistSummary,omitempty"`
+}
+
+// NoteConflictResponse is the 409 response to saving a note over a version other than its current
+// one, with the current note for the client to merge its edit into
+type NoteConflictResponse struct {
+	Error     string  `json:"error"`
+	Message   string  `json:"message"`
+	Note      NoteDTO `json:"note"`
+	RequestID string  `json:"requestId,omitempty"`
+}
+
+// Storage quotas, as named in QuotaExceededResponse
+const (
+	QuotaNotes          = "notes"
+	QuotaNoteBytes      = "noteBytes"
+	QuotaTotalBytes     = "totalBytes"
+	QuotaChecklistItems = "checklistItems"
+)
+
+// QuotaExceededResponse is the 413 response to a write that would take the user past a storage
+// quota. Used is what the write would have brought it to.
+type QuotaExceededResponse struct {
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	Quota     string `json:"quota"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// UsageDTO is how much the user stores in notes, and the quotas that apply
+type UsageDTO struct {
+	Notes           int64          `json:"notes"`
+	Bytes           int64          `json:"bytes"`
+	AttachmentBytes int64          `json:"attachmentBytes"` // the part of Bytes taken by attachments
+	Limits          QuotaLimitsDTO `json:"limits"`
+}
+
+// QuotaLimitsDTO are the storage quotas; 0 is unlimited
+type QuotaLimitsDTO struct {
+	MaxNotes          int64 `json:"maxNotes"`
+	MaxNoteBytes      int64 `json:"maxNoteBytes"`
+	MaxTotalBytes     int64 `json:"maxTotalBytes"`
+	MaxChecklistItems int64 `json:"maxChecklistItems"`
+}
+
+// NoteStreamLine is one line of GET /api/notes streamed as NDJSON: a note, or, last, the deleted
+// note IDs and the server timestamp. A stream that ends without that line was cut short.
+type NoteStreamLine struct {
+	Note            *NoteDTO `json:"note,omitempty"`
+	DeletedNoteIDs  []string `json:"deletedNoteIds,omitempty"`
+	ServerTimestamp string   `json:"serverTimestamp,omitempty"`
 }
 
+// ChecklistSummaryDTO counts a note's checklist items
+type ChecklistSummaryDTO struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+}
+
+// ContentDeltaDTO is a change to a note's content in diff-match-patch delta format (diff_toDelta),
+// against the version whose contentHash is BaseHash
+type ContentDeltaDTO struct {
+	BaseHash string `json:"baseHash"`
+	Delta    string `json:"delta"`
+}
+
+// MaxContentDeltaLength limits deltas; inserted text is URI-encoded, so they can be longer than content
+const MaxContentDeltaLength = 3 * MaxContentLength
+
+// LitePreviewLength is how many characters of content lite responses include
+const LitePreviewLength = 500
+
 type ChecklistItemDTO struct {
 	ID          string `json:"id"`
 	Text        string `json:"text"`
@@ -23,21 +130,204 @@ type ChecklistItemDTO struct {
 	UpdatedAt   string `json:"updatedAt"`
 }
 
+type LinkPreviewDTO struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"imageUrl,omitempty"`
+	FetchedAt   string `json:"fetchedAt"`
+}
+
+type AttachmentDTO struct {
+	ID          string `json:"id"`
+	NoteID      string `json:"noteId"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Format      string `json:"format"`
+	SizeBytes   int64  `json:"sizeBytes"`
+	DurationMs  int64  `json:"durationMs"`
+	URL         string `json:"url"`
+	CreatedAt   string `json:"createdAt"`
+}
+
 type SyncRequest struct {
 	Changes    []NoteDTO `json:"changes"`
 	DeletedIDs []string  `json:"deletedIDs"`
 	LastSync   *string   `json:"lastSync,omitempty"`
+
+	// CRDT mode: text operations to apply, and the last op sequence number the client has seen.
+	// Setting LastOpSeq opts in to receiving ops in the response.
+	ContentOps []NoteOpsDTO `json:"contentOps,omitempty"`
+	LastOpSeq  *int64       `json:"lastOpSeq,omitempty"`
+
+	// BatchToken continues a sync whose previous response had hasMore set
+	BatchToken string `json:"batchToken,omitempty" binding:"max=1000"`
+
+	// Lite trims content to a preview and leaves out unchanged checklist items in the response
+	// (also set by ?lite=true). Changes sent in the request are still applied in full.
+	Lite bool `json:"lite,omitempty"`
+
+	// Positions are reading positions to save; older than the stored position are ignored
+	Positions []NotePositionDTO `json:"positions,omitempty"`
+}
+
+// HasChanges reports whether the sync would change anything, rather than only fetch changes
+func (r *SyncRequest) HasChanges() bool {
+	return len(r.Changes) > 0 || len(r.DeletedIDs) > 0 || len(r.ContentOps) > 0 || len(r.Positions) > 0
 }
 
 type SyncResponse struct {
-	Notes           []NoteDTO `json:"notes"`
-	DeletedNoteIDs  []string  `json:"deletedNoteIDs"`
-	ServerTimestamp string    `json:"serverTimestamp"`
+	Notes           []NoteDTO     `json:"notes"`
+	DeletedNoteIDs  []string      `json:"deletedNoteIds"`
+	Conflicts       []ConflictDTO `json:"conflicts,omitempty"`
+	MergedNoteIDs   []string      `json:"mergedNoteIds,omitempty"` // notes whose concurrent edits were merged; the merged note is in Notes
+	ResendNoteIDs   []string      `json:"resendNoteIds,omitempty"` // changes whose contentDelta didn't apply; resend them with full content
+	ContentOps      []NoteOpsDTO  `json:"contentOps,omitempty"`    // ops after the request's lastOpSeq
+	LastOpSeq       *int64        `json:"lastOpSeq,omitempty"`     // send as lastOpSeq next time
+	AsOf            string        `json:"asOf,omitempty"`          // set on read-only point-in-time listings
+	BatchID         string        `json:"batchId,omitempty"`       // pass to POST /api/sync/{batchId}/revert to undo this sync's changes
+	BatchToken      string        `json:"batchToken,omitempty"`    // send to fetch the next page when hasMore is set
+	HasMore         bool          `json:"hasMore,omitempty"`       // more notes remain; only store serverTimestamp once this is false
+	ServerTimestamp string        `json:"serverTimestamp"`
+	HLC             string        `json:"hlc,omitempty"` // the server's clock; devices should observe it before their next edit
+
+	// Positions are reading positions changed since lastSync, sent with the first page
+	Positions []NotePositionDTO `json:"positions,omitempty"`
+
+	// Saved is the request's changes as they were saved, with their new versions, for broadcasting to
+	// the user's other devices. Changes older than the stored note aren't in it.
+	Saved []NoteDTO `json:"-"`
+}
+
+// RevisionDTO lists one saved version of a note
+type RevisionDTO struct {
+	ID         string `json:"id"`
+	NoteID     string `json:"noteId"`
+	Title      string `json:"title"`
+	RecordedAt string `json:"recordedAt"`
+}
+
+// NoteDiffDTO shows what changed from the Against revision to Revision. Against is empty when diffing
+// the note's first revision, which is then compared with an empty note.
+type NoteDiffDTO struct {
+	NoteID         string        `json:"noteId"`
+	Revision       string        `json:"revision"`
+	Against        string        `json:"against,omitempty"`
+	Title          []DiffSpanDTO `json:"title"`
+	Content        []DiffLineDTO `json:"content"`
+	ChecklistItems []DiffLineDTO `json:"checklistItems,omitempty"` // one line per item, "[ ] text" or "[x] text"
+}
+
+// DiffLineDTO is one line of a diff. A changed line (a delete followed by its replacement) has spans
+// showing the words that changed.
+type DiffLineDTO struct {
+	Op    string        `json:"op"` // "equal", "insert" or "delete"
+	Text  string        `json:"text"`
+	Spans []DiffSpanDTO `json:"spans,omitempty"`
+}
+
+type DiffSpanDTO struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// RegisterDeviceRequest registers (or renames) the calling device
+type RegisterDeviceRequest struct {
+	DeviceID string `json:"deviceId" binding:"required,max=100"`
+	Name     string `json:"name,omitempty" binding:"max=100"`
+	Platform string `json:"platform" binding:"required"`
+}
+
+// DeviceDTO describes one of the user's devices. IsStale is set when it hasn't synced for 30 days.
+type DeviceDTO struct {
+	ID         string  `json:"id"`
+	DeviceID   string  `json:"deviceId"`
+	Name       string  `json:"name"`
+	Platform   string  `json:"platform"`
+	LastSyncAt *string `json:"lastSyncAt"`
+	LastSeenAt string  `json:"lastSeenAt"`
+	CreatedAt  string  `json:"createdAt"`
+	RevokedAt  *string `json:"revokedAt,omitempty"`
+	IsStale    bool    `json:"isStale"`
+}
+
+// SyncFailureDTO reports one invalid item of a rejected sync request
+type SyncFailureDTO struct {
+	Field   string `json:"field"` // "changes", "deletedIDs", "contentOps" or "positions"
+	Index   int    `json:"index"` // position in that array, or -1 if the item isn't known
+	NoteID  string `json:"noteId,omitempty"`
+	Message string `json:"message"`
+}
+
+// SyncValidationErrorResponse is returned (422) when a sync request is rejected; nothing in it was applied
+type SyncValidationErrorResponse struct {
+	Error     string           `json:"error"`
+	Message   string           `json:"message"`
+	Failures  []SyncFailureDTO `json:"failures"`
+	RequestID string           `json:"requestId,omitempty"`
+}
+
+// SyncPreflightResponse reports what a sync request would do, from a dry run that writes nothing.
+// WouldApply is false if any item would be rejected, since the real sync would then apply nothing;
+// the other items' outcomes say what happens once the rejected ones are fixed or dropped.
+type SyncPreflightResponse struct {
+	DryRun     bool               `json:"dryRun"`
+	WouldApply bool               `json:"wouldApply"`
+	Changes    []SyncPreflightDTO `json:"changes"`
+	DeletedIDs []SyncPreflightDTO `json:"deletedIDs"`
+	ContentOps []SyncPreflightDTO `json:"contentOps"`
+}
+
+// SyncPreflightDTO is the outcome of one item of a sync request, at the same index
+type SyncPreflightDTO struct {
+	Index       int    `json:"index"`
+	NoteID      string `json:"noteId"`
+	Outcome     string `json:"outcome"` // "apply", "merge", "conflict", "resend" or "reject"
+	KeptVersion string `json:"keptVersion,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+// SyncBatchDTO describes an applied sync that can be reverted
+type SyncBatchDTO struct {
+	ID         string  `json:"id"`
+	RequestID  string  `json:"requestId,omitempty"`
+	NoteCount  int     `json:"noteCount"`
+	CreatedAt  string  `json:"createdAt"`
+	RevertedAt *string `json:"revertedAt,omitempty"`
+}
+
+// NoteOpsDTO carries CRDT text operations for one note's content, in order
+type NoteOpsDTO struct {
+	NoteID string      `json:"noteId"`
+	Ops    []TextOpDTO `json:"ops"`
+}
+
+// TextOpDTO is a single-character insert or delete. IDs are "<counter>@<site>".
+type TextOpDTO struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`            // "insert" or "delete"
+	Ref   string `json:"ref,omitempty"`   // insert: element to insert after ("" = start); delete: element to delete
+	Value string `json:"value,omitempty"` // insert: the character
+	Seq   int64  `json:"seq,omitempty"`   // server order, set in responses
+}
+
+// ConflictDTO reports a note that was edited both on the server and by the client since its last sync.
+// The newer edit is kept on the note; the other is saved as a new "conflicted copy" note so nothing is lost.
+type ConflictDTO struct {
+	NoteID           string `json:"noteId"`
+	ConflictedCopyID string `json:"conflictedCopyId"`
+	KeptVersion      string `json:"keptVersion"` // "client" or "server": whose edit remains on the original note
 }
 
 type AuthRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=50,alphanum"`
-	Password string `json:"password" binding:"required,min=12,max=128"`
+	Password string `json:"password" binding:"required,max=128"` // checked against the password policy on registration
+
+	// Email is accepted on registration only; it is required when email verification is enabled
+	Email string `json:"email,omitempty" binding:"omitempty,email,max=254"`
+
+	// InviteToken is accepted on registration only, granting access to the invited note
+	InviteToken string `json:"invite_token,omitempty" binding:"max=200"`
 }
 
 type RefreshRequest struct {
@@ -50,26 +340,415 @@ type LogoutRequest struct {
 
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"current_password" binding:"required,min=1,max=128"`
-	NewPassword     string `json:"new_password" binding:"required,min=12,max=128"`
+	NewPassword     string `json:"new_password" binding:"required,max=128"` // checked against the password policy
 }
 
 type AuthResponse struct {
-	AccessToken  string  `json:"access_token"`
-	RefreshToken string  `json:"refresh_token"`
-	ExpiresIn    int     `json:"expires_in"` // seconds until access token expires
-	TokenType    string  `json:"token_type"` // always "Bearer"
-	User         UserDTO `json:"user"`
+	AccessToken           string  `json:"access_token"`
+	RefreshToken          string  `json:"refresh_token"`
+	ExpiresIn             int     `json:"expires_in"`               // seconds until access token expires
+	AccessTokenExpiresAt  string  `json:"access_token_expires_at"`  // refresh a little before this
+	RefreshTokenExpiresAt string  `json:"refresh_token_expires_at"` // the user must log in again after this
+	TokenType             string  `json:"token_type"`               // always "Bearer"
+	User                  UserDTO `json:"user"`
+}
+
+// TokenInfoResponse describes the access token a request was made with, so clients can refresh it
+// before it expires rather than after a request fails
+type TokenInfoResponse struct {
+	UserID     string `json:"user_id"`
+	SessionID  string `json:"session_id,omitempty"`
+	IssuedAt   string `json:"issued_at"`
+	ExpiresAt  string `json:"expires_at"`
+	ExpiresIn  int    `json:"expires_in"`  // seconds left
+	ServerTime string `json:"server_time"` // compare with the device's clock to allow for skew
+	Scope      string `json:"scope,omitempty"`
+}
+
+// CreateScopedTokenRequest issues an access token limited to a scope, such as a read-only one for
+// a widget or kiosk display
+type CreateScopedTokenRequest struct {
+	Scope         string `json:"scope" binding:"required,oneof=notes:read"`
+	Name          string `json:"name" binding:"required,max=100"`
+	ExpiresInDays int    `json:"expiresInDays,omitempty" binding:"omitempty,min=1,max=365"` // default 90
+}
+
+// ScopedTokenResponse is a newly issued scoped token. It can't be refreshed; revoke it by deleting
+// its session.
+type ScopedTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"` // always "Bearer"
+	Scope       string `json:"scope"`
+	SessionID   string `json:"session_id"`
+	ExpiresAt   string `json:"expires_at"`
+}
+
+// JWKSResponse is a JSON Web Key Set: the public keys access tokens may be signed with
+type JWKSResponse struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is a public signing key (RFC 7517); N and E are set for RSA keys, Curve and X for Ed25519
+type JWK struct {
+	KeyType   string `json:"kty"` // RSA or OKP
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"` // RS256 or EdDSA
+	Use       string `json:"use"` // always "sig"
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
 }
 
 type UserDTO struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"emailVerified"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required,max=200"`
+}
+
+// MagicLinkExchangeRequest trades the token from an emailed sign-in link for tokens
+type MagicLinkExchangeRequest struct {
+	Token string `json:"token" binding:"required,max=200"`
+}
+
+// EmailRequest carries an email address, to resend a verification link to or to change to
+type EmailRequest struct {
+	Email string `json:"email" binding:"required,email,max=254"`
+}
+
+// RegistrationPendingResponse is returned by registration when the user must verify their email
+// address before they can log in
+type RegistrationPendingResponse struct {
+	Message string  `json:"message"`
+	User    UserDTO `json:"user"`
+}
+
+// WebAuthnRPDTO is the relying party passkeys are registered with
+type WebAuthnRPDTO struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// WebAuthnUserDTO is the account a passkey is registered for. ID is the base64url user handle.
+type WebAuthnUserDTO struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type WebAuthnCredentialParamDTO struct {
+	Type string `json:"type"` // always "public-key"
+	Alg  int    `json:"alg"`
+}
+
+// WebAuthnCredentialDescriptorDTO names an existing passkey. ID is base64url.
+type WebAuthnCredentialDescriptorDTO struct {
+	Type       string   `json:"type"` // always "public-key"
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type WebAuthnAuthenticatorSelectionDTO struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// WebAuthnCreationOptionsDTO is passed to navigator.credentials.create, after decoding the base64url
+// challenge, user ID and credential IDs
+type WebAuthnCreationOptionsDTO struct {
+	Challenge              string                            `json:"challenge"`
+	RP                     WebAuthnRPDTO                     `json:"rp"`
+	User                   WebAuthnUserDTO                   `json:"user"`
+	PubKeyCredParams       []WebAuthnCredentialParamDTO      `json:"pubKeyCredParams"`
+	Timeout                int                               `json:"timeout"` // milliseconds
+	ExcludeCredentials     []WebAuthnCredentialDescriptorDTO `json:"excludeCredentials"`
+	AuthenticatorSelection WebAuthnAuthenticatorSelectionDTO `json:"authenticatorSelection"`
+	Attestation            string                            `json:"attestation"`
+}
+
+// WebAuthnRequestOptionsDTO is passed to navigator.credentials.get, after decoding the base64url
+// challenge. There's no allowCredentials, so any discoverable passkey for this site can be used.
+type WebAuthnRequestOptionsDTO struct {
+	Challenge        string `json:"challenge"`
+	RPID             string `json:"rpId"`
+	Timeout          int    `json:"timeout"` // milliseconds
+	UserVerification string `json:"userVerification"`
+}
+
+// WebAuthnRegistrationOptionsResponse starts adding a passkey; finish it with SessionID
+type WebAuthnRegistrationOptionsResponse struct {
+	SessionID string                     `json:"sessionId"`
+	PublicKey WebAuthnCreationOptionsDTO `json:"publicKey"`
+}
+
+// WebAuthnLoginOptionsResponse starts signing in with a passkey; finish it with SessionID
+type WebAuthnLoginOptionsResponse struct {
+	SessionID string                    `json:"sessionId"`
+	PublicKey WebAuthnRequestOptionsDTO `json:"publicKey"`
+}
+
+// WebAuthnLoginBeginRequest optionally names the account signing in, so only its passkeys are
+// accepted. Either way the passkey identifies the user.
+type WebAuthnLoginBeginRequest struct {
+	Username string `json:"username,omitempty" binding:"omitempty,max=50"`
+}
+
+// WebAuthnAttestationResponseDTO is the response of navigator.credentials.create, base64url-encoded
+type WebAuthnAttestationResponseDTO struct {
+	ClientDataJSON    string   `json:"clientDataJSON" binding:"required,max=4096"`
+	AttestationObject string   `json:"attestationObject" binding:"required,max=65536"`
+	Transports        []string `json:"transports,omitempty" binding:"max=10,dive,max=32"`
+}
+
+// WebAuthnAssertionResponseDTO is the response of navigator.credentials.get, base64url-encoded
+type WebAuthnAssertionResponseDTO struct {
+	ClientDataJSON    string `json:"clientDataJSON" binding:"required,max=4096"`
+	AuthenticatorData string `json:"authenticatorData" binding:"required,max=4096"`
+	Signature         string `json:"signature" binding:"required,max=1024"`
+	UserHandle        string `json:"userHandle,omitempty" binding:"max=128"`
+}
+
+// WebAuthnAttestationDTO is the credential navigator.credentials.create returns. RawID is base64url.
+type WebAuthnAttestationDTO struct {
+	RawID    string                         `json:"rawId" binding:"required,max=1400"`
+	Response WebAuthnAttestationResponseDTO `json:"response" binding:"required"`
+}
+
+// WebAuthnAssertionDTO is the credential navigator.credentials.get returns. RawID is base64url.
+type WebAuthnAssertionDTO struct {
+	RawID    string                       `json:"rawId" binding:"required,max=1400"`
+	Response WebAuthnAssertionResponseDTO `json:"response" binding:"required"`
+}
+
+type WebAuthnRegisterFinishRequest struct {
+	SessionID  string                 `json:"sessionId" binding:"required,uuid"`
+	Name       string                 `json:"name,omitempty" binding:"max=100"`
+	Credential WebAuthnAttestationDTO `json:"credential" binding:"required"`
+}
+
+type WebAuthnLoginFinishRequest struct {
+	SessionID  string               `json:"sessionId" binding:"required,uuid"`
+	Credential WebAuthnAssertionDTO `json:"credential" binding:"required"`
+}
+
+// WebAuthnCredentialDTO is a passkey registered to the user
+type WebAuthnCredentialDTO struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Synced     bool    `json:"synced"` // backed up and available on the user's other devices
+	CreatedAt  string  `json:"createdAt"`
+	LastUsedAt *string `json:"lastUsedAt,omitempty"`
+}
+
+// PresenceDTO lists the user's devices connected for real-time sync
+type PresenceDTO struct {
+	DeviceCount int                     `json:"deviceCount"` // connections without a device ID count as one device each
+	Connections []PresenceConnectionDTO `json:"connections"`
+}
+
+// PresenceConnectionDTO is one open WebSocket connection
+type PresenceConnectionDTO struct {
+	ConnectionID string `json:"connectionId"`
+	DeviceID     string `json:"deviceId,omitempty"`
+	DeviceName   string `json:"deviceName,omitempty"` // set if the device is registered
+	ConnectedAt  string `json:"connectedAt"`
+}
+
+// SessionDTO is a device the user is signed in on
+type SessionDTO struct {
+	ID         string `json:"id"`
+	DeviceID   string `json:"deviceId,omitempty"`
+	DeviceName string `json:"deviceName,omitempty"`
+	UserAgent  string `json:"userAgent"`
+	IPAddress  string `json:"ipAddress"`
+	CreatedAt  string `json:"createdAt"`
+	LastSeenAt string `json:"lastSeenAt"`
+	Current    bool   `json:"current"`             // the session making the request
+	Scope      string `json:"scope,omitempty"`     // set for scoped tokens
+	Name       string `json:"name,omitempty"`      // what the user called the scoped token
+	ExpiresAt  string `json:"expiresAt,omitempty"` // when the scoped token expires
+}
+
+// OAuthLinkRequest starts linking a sign-in provider to the current user's account
+type OAuthLinkRequest struct {
+	ReturnTo string `json:"returnTo,omitempty"` // one of OAUTH_RETURN_URLS; defaults to the first
+}
+
+// OAuthURLResponse is where to send the user to sign in with a provider
+type OAuthURLResponse struct {
+	URL string `json:"url"`
+}
+
+// OAuthExchangeRequest trades the code an app was sent back with for tokens
+type OAuthExchangeRequest struct {
+	Code string `json:"code" binding:"required,max=100"`
+}
+
+// OAuthTokenRequest signs in with an ID token a native app got from the provider's SDK
+type OAuthTokenRequest struct {
+	IDToken string `json:"idToken" binding:"required,max=8192"`
+	Nonce   string `json:"nonce" binding:"required,max=128"` // the raw nonce the app passed to the SDK
+}
+
+// UserIdentityDTO is a sign-in provider account linked to the user
+type UserIdentityDTO struct {
+	ID         string  `json:"id"`
+	Provider   string  `json:"provider"`
+	Email      string  `json:"email,omitempty"`
+	CreatedAt  string  `json:"createdAt"`
+	LastUsedAt *string `json:"lastUsedAt,omitempty"`
+}
+
+// CaptchaConfigResponse tells clients which CAPTCHA widget to show, and when
+type CaptchaConfigResponse struct {
+	Enabled            bool   `json:"enabled"`
+	Provider           string `json:"provider,omitempty"`
+	SiteKey            string `json:"siteKey,omitempty"`
+	LoginAfterFailures int    `json:"loginAfterFailures"` // failed logins from the client's IP before logging in needs one; 0 = always
+}
+
+// SetupStatusResponse tells clients whether the server still needs its first administrator
+type SetupStatusResponse struct {
+	SetupRequired    bool   `json:"setupRequired"`
+	InstanceName     string `json:"instanceName"`
+	RegistrationOpen bool   `json:"registrationOpen"`
+}
+
+// SetupRequest creates the first administrator with the setup token from the server log
+type SetupRequest struct {
+	Token            string `json:"token" binding:"required,max=100"`
+	Username         string `json:"username" binding:"required,min=3,max=50,alphanum"`
+	Password         string `json:"password" binding:"required,max=128"` // checked against the password policy
+	Email            string `json:"email,omitempty" binding:"omitempty,email,max=254"`
+	InstanceName     string `json:"instanceName,omitempty" binding:"max=100"`
+	RegistrationOpen *bool  `json:"registrationOpen,omitempty"` // default true
+}
+
+// InstanceSettingsDTO is the server-wide settings
+type InstanceSettingsDTO struct {
+	InstanceName     string `json:"instanceName"`
+	RegistrationOpen bool   `json:"registrationOpen"`
+}
+
+// UpdateInstanceSettingsRequest changes the given server-wide settings
+type UpdateInstanceSettingsRequest struct {
+	InstanceName     *string `json:"instanceName,omitempty" binding:"omitempty,min=1,max=100"`
+	RegistrationOpen *bool   `json:"registrationOpen,omitempty"`
+}
+
+// MaintenanceDTO is whether the server is in maintenance mode
+type MaintenanceDTO struct {
+	Enabled bool    `json:"enabled"`
+	Message string  `json:"message,omitempty"`
+	Until   *string `json:"until,omitempty"` // when it's expected to end (RFC 3339)
+}
+
+// UpdateMaintenanceRequest turns maintenance mode on or off
+type UpdateMaintenanceRequest struct {
+	Enabled *bool   `json:"enabled" binding:"required"`
+	Message string  `json:"message,omitempty" binding:"max=500"`
+	Until   *string `json:"until,omitempty"` // when it's expected to end (RFC 3339), for Retry-After
+}
+
+// MessageResponse is returned by actions that have no other result
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+}
+
+// ReadinessResponse says whether the server can serve requests: "ok", or "unavailable" with status
+// 503 when the database doesn't answer
+type ReadinessResponse struct {
+	Status   string            `json:"status"`
+	Version  string            `json:"version"`
+	Database DatabaseHealthDTO `json:"database"`
+}
+
+// DatabaseHealthDTO is the result of pinging the database, and its connection pool
+type DatabaseHealthDTO struct {
+	Status    string          `json:"status"`
+	LatencyMs float64         `json:"latencyMs"`
+	Pool      DatabasePoolDTO `json:"pool"`
+}
+
+// DatabasePoolDTO is a snapshot of the database connection pool
+type DatabasePoolDTO struct {
+	Acquired         int32 `json:"acquired"` // connections in use
+	Idle             int32 `json:"idle"`
+	Total            int32 `json:"total"`
+	Max              int32 `json:"max"`
+	Acquires         int64 `json:"acquires"`         // connections handed out since the server started
+	WaitedAcquires   int64 `json:"waitedAcquires"`   // of those, how many had to wait for one
+	AcquireWaitMs    int64 `json:"acquireWaitMs"`    // total time spent waiting
+	CanceledAcquires int64 `json:"canceledAcquires"` // waits given up on
+}
+
+// SchemaVersionResponse identifies the API schema the server implements
+type SchemaVersionResponse struct {
+	Version string `json:"version"` // API version, as in the OpenAPI document's info.version
+	Hash    string `json:"hash"`    // SHA-256 of the OpenAPI document; changes whenever any endpoint or DTO changes
+}
+
+type InviteRequest struct {
+	Email string `json:"email" binding:"required,email,max=254"`
+}
+
+type AcceptInviteRequest struct {
+	Token string `json:"token" binding:"required,max=200"`
+}
+
+type InviteDTO struct {
+	ID         string  `json:"id"`
+	NoteID     string  `json:"noteId"`
+	Email      string  `json:"email"`
+	ExpiresAt  string  `json:"expiresAt"`
+	AcceptedAt *string `json:"acceptedAt,omitempty"`
+	CreatedAt  string  `json:"createdAt"`
+	SentAt     string  `json:"sentAt"`
+}
+
+type NotificationDTO struct {
+	ID            string  `json:"id"`
+	Type          string  `json:"type"`
+	NoteID        string  `json:"noteId,omitempty"`
+	NoteTitle     string  `json:"noteTitle,omitempty"`
+	ActorUsername string  `json:"actorUsername,omitempty"`
+	Link          string  `json:"link,omitempty"`
+	ReadAt        *string `json:"readAt,omitempty"`
+	CreatedAt     string  `json:"createdAt"`
+}
+
+// NotificationPreferencesDTO holds the channels each category of notification is delivered on. In
+// updates, categories left out are unchanged.
+type NotificationPreferencesDTO struct {
+	Reminders *NotificationChannels `json:"reminders,omitempty"`
+	Shares    *NotificationChannels `json:"shares,omitempty"`
+	Mentions  *NotificationChannels `json:"mentions,omitempty"`
+	Security  *NotificationChannels `json:"security,omitempty"`
+}
+
+// NoteOrderDTO is the manual order of notes in one ordering context (see IsValidOrderingContext).
+// Notes not listed keep their relative sortOrder after the listed ones.
+type NoteOrderDTO struct {
+	Context string   `json:"context" binding:"required,max=100"`
+	NoteIDs []string `json:"noteIds" binding:"max=10000"`
 }
 
 // ValidNoteTypes contains all valid note types
 var ValidNoteTypes = map[string]bool{
 	string(NoteTypeNote):      true,
 	string(NoteTypeChecklist): true,
+	string(NoteTypeCode):      true,
 }
 
 // IsValidNoteType checks if the note type is valid
@@ -79,7 +758,313 @@ func IsValidNoteType(noteType string) bool {
 
 // MaxFieldLengths defines maximum lengths for various fields
 const (
-	MaxTitleLength   = 500
-	MaxContentLength = 100000 // 100KB
+	MaxTitleLength    = 500
+	MaxContentLength  = 100000 // 100KB
 	MaxItemTextLength = 1000
+	MaxLanguageLength = 50
+
+	MaxMetadataEntries     = 32
+	MaxMetadataKeyLength   = 64
+	MaxMetadataValueLength = 1024
 )
+
+// isContentHash checks for a lowercase hex SHA-256 hash
+func isContentHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, r := range hash {
+		if !(r >= '0' && r <= '9') && !(r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidLanguage checks that a code language hint is a short lowercase
+// identifier such as "go", "c++", "c#" or "objective-c"
+func IsValidLanguage(language string) bool {
+	if len(language) > MaxLanguageLength {
+		return false
+	}
+	for _, r := range language {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r == '+', r == '#', r == '-', r == '.', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidMetadataKey checks that a metadata key is non-empty, within the length
+// limit and only contains letters, digits, '_', '-', '.' or ':'
+func IsValidMetadataKey(key string) bool {
+	if key == "" || len(key) > MaxMetadataKeyLength {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_', r == '-', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateNoteDTO validates the note DTO fields for security
+func ValidateNoteDTO(dto *NoteDTO) error {
+	// Saving a note from a lite or projected listing would replace it with the preview and delete
+	// the checklist items left out
+	if dto.IsPartial {
+		return errors.New("note is partial: fetch the full note before saving it")
+	}
+
+	// Validate note type
+	if dto.NoteType != "" && !IsValidNoteType(dto.NoteType) {
+		return errors.New("invalid note type: must be 'note', 'checklist' or 'code'")
+	}
+
+	// Validate code language hint
+	if dto.Language != nil && !IsValidLanguage(*dto.Language) {
+		return errors.New("invalid language: must be at most 50 lowercase letters, digits or '+#-._' characters")
+	}
+
+	// Validate title length
+	if len(dto.Title) > MaxTitleLength {
+		return errors.New("title exceeds maximum length of 500 characters")
+	}
+
+	// Validate content length
+	if len(dto.Content) > MaxContentLength {
+		return errors.New("content exceeds maximum length of 100000 characters")
+	}
+
+	// Validate content delta
+	if dto.ContentDelta != nil {
+		if !isContentHash(dto.ContentDelta.BaseHash) {
+			return errors.New("contentDelta baseHash must be a hex SHA-256 hash")
+		}
+		if len(dto.ContentDelta.Delta) > MaxContentDeltaLength {
+			return errors.New("contentDelta exceeds maximum length of 300000 characters")
+		}
+	}
+
+	// Validate hybrid logical clock
+	if dto.HLC != "" {
+		ts, err := hlc.Parse(dto.HLC)
+		if err != nil {
+			return errors.New("hlc must be formatted as <unix ms>-<counter>-<node>")
+		}
+		if time.Until(ts.Time()) > hlc.MaxDrift {
+			return errors.New("hlc is more than 5 minutes ahead of the server clock")
+		}
+	}
+
+	// Validate checklist items
+	for _, item := range dto.ChecklistItems {
+		if len(item.Text) > MaxItemTextLength {
+			return errors.New("checklist item text exceeds maximum length of 1000 characters")
+		}
+	}
+
+	// Validate metadata
+	if len(dto.Metadata) > MaxMetadataEntries {
+		return errors.New("metadata exceeds maximum of 32 entries")
+	}
+	for key, value := range dto.Metadata {
+		if !IsValidMetadataKey(key) {
+			return errors.New("invalid metadata key: must be 1-64 characters of letters, digits, '_', '-', '.' or ':'")
+		}
+		if len(value) > MaxMetadataValueLength {
+			return errors.New("metadata value exceeds maximum length of 1024 characters")
+		}
+	}
+
+	return nil
+}
+
+// ActivitySummaryRequest opts in to the monthly activity summary, or changes where it's sent
+type ActivitySummaryRequest struct {
+	Email string `json:"email" binding:"required,email,max=254"`
+}
+
+// ActivitySummaryDTO describes the user's activity summary subscription, with a preview of last
+// month's summary. Periods are months written "YYYY-MM".
+type ActivitySummaryDTO struct {
+	Subscribed  bool             `json:"subscribed"`
+	Email       string           `json:"email,omitempty"`
+	LastSentFor *string          `json:"lastSentFor"`
+	Preview     ActivityStatsDTO `json:"preview"`
+}
+
+type ActivityStatsDTO struct {
+	Period                  string          `json:"period"`
+	NotesCreated            int             `json:"notesCreated"`
+	ChecklistItemsCompleted int             `json:"checklistItemsCompleted"`
+	MostEditedNotes         []EditedNoteDTO `json:"mostEditedNotes"`
+}
+
+type EditedNoteDTO struct {
+	NoteID string `json:"noteId"`
+	Title  string `json:"title"`
+	Edits  int    `json:"edits"`
+}
+
+// CreateExportRequest starts a batch export of the notes matching a filter
+type CreateExportRequest struct {
+	Format          string   `json:"format" binding:"required,oneof=pdf markdown zip"`
+	NoteIDs         []string `json:"noteIds,omitempty" binding:"max=5000"` // e.g. the notes in a folder or tag
+	Query           string   `json:"query,omitempty" binding:"max=200"`
+	IncludeArchived bool     `json:"includeArchived"`
+	Paper           string   `json:"paper,omitempty" binding:"omitempty,oneof=a4 letter"` // pdf only; default a4
+	IncludeMetadata bool     `json:"includeMetadata"`
+}
+
+// ExportJobDTO reports the progress of a batch export
+type ExportJobDTO struct {
+	ID          string  `json:"id"`
+	Status      string  `json:"status"`
+	Format      string  `json:"format"`
+	NoteCount   int     `json:"noteCount"`
+	SizeBytes   int64   `json:"sizeBytes"`
+	Filename    string  `json:"filename,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	DownloadURL string  `json:"downloadUrl,omitempty"` // set once completed
+	CreatedAt   string  `json:"createdAt"`
+	CompletedAt *string `json:"completedAt,omitempty"`
+	ExpiresAt   string  `json:"expiresAt"`
+}
+
+// ArchiveExportDTO describes one archive export in the user's chain of exports
+type ArchiveExportDTO struct {
+	ID         string `json:"id"`
+	PrevHash   string `json:"prevHash"`
+	HeadHash   string `json:"headHash"`
+	EntryCount int    `json:"entryCount"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+// ArchiveVerificationDTO reports whether an archive is intact. Recorded is set when the archive
+// matches an export this server made for the requesting user.
+type ArchiveVerificationDTO struct {
+	Valid      bool   `json:"valid"`
+	Recorded   bool   `json:"recorded"`
+	EntryCount int    `json:"entryCount"`
+	HeadHash   string `json:"headHash"`
+	InvalidSeq int    `json:"invalidSeq,omitempty"` // first entry whose hash doesn't match
+	Message    string `json:"message,omitempty"`
+}
+
+// ArchivePublicKeyDTO is the key archives are signed with, for verifying them offline
+type ArchivePublicKeyDTO struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"publicKey"` // base64
+}
+
+// NotePositionDTO is the reading position in a note: the caret as a character offset into the
+// content and the scroll position as the fraction of the note scrolled past
+type NotePositionDTO struct {
+	NoteID    string  `json:"noteId"`
+	Caret     int     `json:"caret"`
+	Scroll    float64 `json:"scroll"`
+	DeviceID  string  `json:"deviceId,omitempty"` // read-only, the device that set it
+	UpdatedAt string  `json:"updatedAt,omitempty"`
+}
+
+// ValidateNotePositionDTO checks that a position is within the bounds of a note
+func ValidateNotePositionDTO(dto *NotePositionDTO) error {
+	if dto.Caret < 0 || dto.Caret > MaxContentLength {
+		return errors.New("caret must be between 0 and 100000")
+	}
+	if !(dto.Scroll >= 0 && dto.Scroll <= 1) { // also rejects NaN
+		return errors.New("scroll must be between 0 and 1")
+	}
+	return nil
+}
+
+// IntegrityReportDTO is the result of one run of the integrity check. Counts are keyed by check and
+// complete; findings list at most MaxIntegrityFindings records per check.
+type IntegrityReportDTO struct {
+	ID         string             `json:"id"`
+	Repair     bool               `json:"repair"`
+	Counts     map[string]int     `json:"counts"`
+	Findings   []IntegrityFinding `json:"findings"`
+	StartedAt  string             `json:"startedAt"`
+	FinishedAt string             `json:"finishedAt"`
+}
+
+// LoginLockoutDTO is a username locked out after too many failed logins
+type LoginLockoutDTO struct {
+	Username       string `json:"username"`
+	FailedAttempts int    `json:"failedAttempts"`
+	LastFailedAt   string `json:"lastFailedAt"`
+	LockedUntil    string `json:"lockedUntil"`
+}
+
+// WebSocketStatsDTO reports the real-time hub's connections, the panics it has recovered from and
+// the messages slow clients missed
+type WebSocketStatsDTO struct {
+	Connections          int                           `json:"connections"`
+	Users                int                           `json:"users"`              // with at least one connection
+	ConnectionsPerUser   []WebSocketUserConnectionsDTO `json:"connectionsPerUser"` // up to 100 users, most connections first
+	ConnectionsByVersion map[string]int                `json:"connectionsByVersion"`
+	BinaryConnections    int                           `json:"binaryConnections"` // using MessagePack
+
+	// Totals since the server started, and per second over the last minute
+	Broadcasts          int64   `json:"broadcasts"`
+	MessagesDelivered   int64   `json:"messagesDelivered"`
+	BytesDelivered      int64   `json:"bytesDelivered"`
+	BroadcastsPerSecond float64 `json:"broadcastsPerSecond"`
+	MessagesPerSecond   float64 `json:"messagesPerSecond"`
+	BytesPerSecond      float64 `json:"bytesPerSecond"`
+	EventsCoalesced     int64   `json:"eventsCoalesced"` // replaced by a later change before being sent
+
+	Panics          int64                   `json:"panics"`
+	LoopRestarts    int64                   `json:"loopRestarts"`    // times the event loop itself died and was restarted
+	DroppedMessages int64                   `json:"droppedMessages"` // since the server started
+	SlowDisconnects int64                   `json:"slowDisconnects"` // clients closed for falling behind
+	LaggingClients  []WebSocketClientLagDTO `json:"laggingClients"`
+
+	RateLimitDisconnects int64 `json:"rateLimitDisconnects"` // clients closed for flooding the server with messages
+
+	Goroutines    int   `json:"goroutines"` // in the whole server, two per connection
+	UptimeSeconds int64 `json:"uptimeSeconds"`
+}
+
+// WebSocketUserConnectionsDTO is how many WebSocket connections a user has open
+type WebSocketUserConnectionsDTO struct {
+	UserID      string `json:"userId"`
+	Connections int    `json:"connections"`
+}
+
+// WebSocketClientLagDTO describes an open connection that has had messages dropped or is backed up
+type WebSocketClientLagDTO struct {
+	ConnectionID    string `json:"connectionId"`
+	UserID          string `json:"userId"`
+	QueuedMessages  int    `json:"queuedMessages"`
+	DroppedMessages int64  `json:"droppedMessages"`
+}
+
+// RunIntegrityCheckRequest runs the integrity check now; with repair set, broken records are removed
+type RunIntegrityCheckRequest struct {
+	Repair bool `json:"repair"`
+}
+
+// BackupRequest takes a backup of one user, or of the whole instance if UserID is empty
+type BackupRequest struct {
+	UserID string `json:"userId"`
+}
+
+// RestoreResultDTO describes a restored backup, or with DryRun set, one that was checked and would
+// restore cleanly
+type RestoreResultDTO struct {
+	Scope  string           `json:"scope"`
+	UserID string           `json:"userId,omitempty"`
+	DryRun bool             `json:"dryRun"`
+	Rows   int64            `json:"rows"`
+	Tables map[string]int64 `json:"tables"`
+}
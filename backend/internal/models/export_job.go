@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExportFormat is the kind of file an export job produces
+type ExportFormat string
+
+const (
+	ExportFormatPDF      ExportFormat = "pdf"      // one PDF, each note on a new page
+	ExportFormatMarkdown ExportFormat = "markdown" // one Markdown document
+	ExportFormatZip      ExportFormat = "zip"      // a zip of Markdown files, one per note
+)
+
+// ExportJobStatus is where an export job is in its lifecycle
+type ExportJobStatus string
+
+const (
+	ExportJobPending   ExportJobStatus = "pending"
+	ExportJobRunning   ExportJobStatus = "running"
+	ExportJobCompleted ExportJobStatus = "completed"
+	ExportJobFailed    ExportJobStatus = "failed"
+)
+
+// ExportJobExpiry is how long a finished export can be downloaded before it's removed
+const ExportJobExpiry = 24 * time.Hour
+
+// MaxExportNotes limits how many notes one export can include
+const MaxExportNotes = 5000
+
+// ExportFilter selects the notes to export. Folders and tags are kept by clients, so a folder or tag
+// is exported by sending its note IDs.
+type ExportFilter struct {
+	NoteIDs         []uuid.UUID `json:"noteIds,omitempty"`
+	Query           string      `json:"query,omitempty"` // case-insensitive text in the title, content or checklist items
+	IncludeArchived bool        `json:"includeArchived"`
+}
+
+// ExportJob is a batch export of notes, produced in the background. The file is kept in the export
+// store under the job's ID.
+type ExportJob struct {
+	ID              uuid.UUID
+	UserID          uuid.UUID
+	Status          ExportJobStatus
+	Format          ExportFormat
+	Filter          ExportFilter
+	Paper           string // pdf only
+	IncludeMetadata bool
+	Filename        string
+	SizeBytes       int64
+	NoteCount       int
+	Error           string
+	CreatedAt       time.Time
+	CompletedAt     *time.Time
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKeyRetention is how long a response is kept for replaying to retries of its request
+const IdempotencyKeyRetention = 24 * time.Hour
+
+// MaxIdempotencyKeyLength limits the Idempotency-Key header; clients typically send a UUID
+const MaxIdempotencyKeyLength = 255
+
+// IdempotencyRecord is the stored outcome of a request sent with an Idempotency-Key. StatusCode is
+// nil while the request is still being handled.
+type IdempotencyRecord struct {
+	UserID      uuid.UUID
+	Key         string
+	Route       string
+	RequestHash string
+	StatusCode  *int
+	ContentType string
+	Response    []byte
+	CreatedAt   time.Time
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuthStateExpiry is how long a user has to sign in with a provider once they've been sent there
+const OAuthStateExpiry = 10 * time.Minute
+
+// OAuthLoginCodeExpiry is how long the app has to exchange the code it was redirected back with
+// for tokens
+const OAuthLoginCodeExpiry = time.Minute
+
+// UserIdentity links an account to a user at a sign-in provider such as Apple or Google
+type UserIdentity struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Provider   string
+	Subject    string // the provider's stable ID for the user
+	Email      string // as the provider last reported it
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// OAuthState is one sign-in with a provider in progress
+type OAuthState struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	UserID       *uuid.UUID // set when linking the provider to a signed-in user's account
+	ReturnTo     string
+	ExpiresAt    time.Time
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// InstanceSettings are the server-wide settings administrators choose
+type InstanceSettings struct {
+	Name             string // shown by clients, e.g. in page titles and emails
+	RegistrationOpen bool   // anyone may create an account; when off, only administrators set up accounts
+	SetupCompletedAt *time.Time
+}
+
+// Maintenance is whether the server is in maintenance mode, refusing changes while reads carry on
+type Maintenance struct {
+	Enabled bool
+	Message string     // shown to users, such as what is being done
+	Until   *time.Time // when it's expected to end, if known
+}
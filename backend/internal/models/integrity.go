@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Integrity checks, used as IntegrityFinding.Check and as the keys of IntegrityReport.Counts
+const (
+	IntegrityOrphanedChecklistItem = "orphaned_checklist_item" // checklist item whose note doesn't exist
+	IntegrityNoteWithoutUser       = "note_without_user"       // note whose owner doesn't exist
+	IntegrityAttachmentWithoutBlob = "attachment_without_blob" // attachment record whose file is missing
+)
+
+// MaxIntegrityFindings limits how many findings of each check are listed in a report; counts are
+// always complete
+const MaxIntegrityFindings = 1000
+
+// IntegrityFinding is one broken record found by the integrity check
+type IntegrityFinding struct {
+	Check    string    `json:"check"`
+	ID       uuid.UUID `json:"id"`
+	Detail   string    `json:"detail,omitempty"`
+	Repaired bool      `json:"repaired"`
+}
+
+// IntegrityReport is the result of one run of the integrity check
+type IntegrityReport struct {
+	ID         uuid.UUID
+	Repair     bool // broken records were removed
+	Counts     map[string]int
+	Findings   []IntegrityFinding
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
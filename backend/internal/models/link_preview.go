@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LinkPreview holds the unfurled metadata for a URL found in a note's content
+type LinkPreview struct {
+	NoteID      uuid.UUID `json:"noteId"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	ImageURL    string    `json:"imageUrl"`
+	FetchedAt   time.Time `json:"fetchedAt"`
+}
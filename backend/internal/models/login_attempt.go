@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Failed password logins are counted per username. From the LoginLockoutThreshold-th failure in a
+// row the account is locked, for LoginLockoutBase at first and twice as long after each further
+// failure, up to LoginLockoutMax.
+const (
+	LoginLockoutThreshold = 5
+	LoginLockoutBase      = time.Minute
+	LoginLockoutMax       = 24 * time.Hour
+
+	// LoginFailureWindow is how long a failure counts towards a lockout
+	LoginFailureWindow = 24 * time.Hour
+)
+
+// LoginAttempts is the failed password logins counted against a username
+type LoginAttempts struct {
+	Username       string
+	FailedAttempts int
+	LastFailedAt   time.Time
+	LockedUntil    *time.Time
+}
+
+// LoginLockoutDuration is how long an account is locked after the given number of failures in a row
+func LoginLockoutDuration(failures int) time.Duration {
+	if failures < LoginLockoutThreshold {
+		return 0
+	}
+	lockout := LoginLockoutBase
+	for i := LoginLockoutThreshold; i < failures && lockout < LoginLockoutMax; i++ {
+		lockout *= 2
+	}
+	return min(lockout, LoginLockoutMax)
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MagicLinkToken is a single-use token emailed to Email that signs its user in without a password.
+// Only a hash of the token is stored.
+type MagicLinkToken struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Email       string
+	TokenHash   string // hex SHA-256 of the token
+	RequestedIP string
+	ExpiresAt   time.Time
+	UsedAt      *time.Time
+	CreatedAt   time.Time
+}
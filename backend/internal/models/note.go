@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/hlc"
 )
 
 type NoteType string
@@ -11,19 +13,82 @@ type NoteType string
 const (
 	NoteTypeNote      NoteType = "note"
 	NoteTypeChecklist NoteType = "checklist"
+	NoteTypeCode      NoteType = "code"
 )
 
 type Note struct {
-	ID             uuid.UUID       `json:"id"`
-	UserID         uuid.UUID       `json:"userId"`
-	Title          string          `json:"title"`
-	Content        string          `json:"content"`
-	NoteType       NoteType        `json:"noteType"`
-	IsPinned       bool            `json:"isPinned"`
-	IsArchived     bool            `json:"isArchived"`
-	SortOrder      int             `json:"sortOrder"`
-	CreatedAt      time.Time       `json:"createdAt"`
-	UpdatedAt      time.Time       `json:"updatedAt"`
-	DeletedAt      *time.Time      `json:"deletedAt,omitempty"`
-	ChecklistItems []ChecklistItem `json:"checklistItems,omitempty"`
+	ID             uuid.UUID         `json:"id"`
+	UserID         uuid.UUID         `json:"userId"`
+	Title          string            `json:"title"`
+	Content        string            `json:"content"`
+	NoteType       NoteType          `json:"noteType"`
+	Language       string            `json:"language,omitempty"` // syntax highlighting hint for code notes
+	IsMonospace    bool              `json:"isMonospace"`
+	IsPinned       bool              `json:"isPinned"`
+	IsArchived     bool              `json:"isArchived"`
+	SortOrder      int               `json:"sortOrder"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	UpdatedAt      time.Time         `json:"updatedAt"`
+	HLC            string            `json:"hlc,omitempty"`     // hybrid logical clock of the last edit; orders edits across devices
+	Version        int               `json:"version,omitempty"` // incremented on every update, for optimistic concurrency
+	DeletedAt      *time.Time        `json:"deletedAt,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	ChecklistItems []ChecklistItem   `json:"checklistItems,omitempty"`
+	LinkPreviews   []LinkPreview     `json:"linkPreviews,omitempty"`
+	Attachments    []Attachment      `json:"attachments,omitempty"`
+
+	// ChecklistSummary counts the checklist items of a note loaded without them
+	ChecklistSummary *ChecklistSummary `json:"checklistSummary,omitempty"`
+
+	// Omitted lists the fields the client left out of the note it sent, which keep their stored
+	// values when it's saved
+	Omitted OmittedFields `json:"-"`
+}
+
+// OmittedFields are the optional note fields a client can leave out
+type OmittedFields struct {
+	Language    bool
+	IsMonospace bool
+	Metadata    bool
+}
+
+// Any reports whether any field was left out
+func (o OmittedFields) Any() bool {
+	return o.Language || o.IsMonospace || o.Metadata
+}
+
+// KeepOmitted gives the note stored's values for the fields its client left out
+func (n *Note) KeepOmitted(stored *Note) {
+	if n.Omitted.Language {
+		n.Language = stored.Language
+	}
+	if n.Omitted.IsMonospace {
+		n.IsMonospace = stored.IsMonospace
+	}
+	if n.Omitted.Metadata {
+		n.Metadata = stored.Metadata
+	}
+	n.Omitted = OmittedFields{}
+}
+
+// ChecklistSummary counts a note's checklist items
+type ChecklistSummary struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+}
+
+// Note listing projections, chosen with ?include= on GET /api/notes
+const (
+	IncludeItems  = "items"  // checklist items in full (the default)
+	IncludeCounts = "counts" // checklist item counts only
+	IncludeNone   = "none"   // no checklist items or counts
+)
+
+// Clock returns the hybrid logical clock timestamp of the note's last edit, falling back to
+// UpdatedAt for notes saved before clocks were tracked
+func (n *Note) Clock() hlc.Timestamp {
+	if ts, err := hlc.Parse(n.HLC); err == nil {
+		return ts
+	}
+	return hlc.FromTime(n.UpdatedAt)
 }
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/crdt"
+)
+
+// MaxOpsPerSync limits how many text operations one sync request may carry
+const MaxOpsPerSync = 20000
+
+// NoteOp is a stored CRDT text operation; Seq is its position in the server's log
+type NoteOp struct {
+	Seq       int64
+	NoteID    uuid.UUID
+	Op        crdt.Op
+	CreatedAt time.Time
+}
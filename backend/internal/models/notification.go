@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type NotificationType string
+
+const (
+	NotificationTypeMention         NotificationType = "mention"
+	NotificationTypeShareAccepted   NotificationType = "share_accepted"   // someone accepted an invite to the user's note
+	NotificationTypePasswordChanged NotificationType = "password_changed" // security alert
+	NotificationTypeTokenReused     NotificationType = "token_reused"     // security alert: a stolen refresh token was replayed
+	NotificationTypeReminder        NotificationType = "reminder"
+)
+
+// Category returns the preference category the notification type belongs to
+func (t NotificationType) Category() NotificationCategory {
+	switch t {
+	case NotificationTypeMention:
+		return NotificationCategoryMentions
+	case NotificationTypeShareAccepted:
+		return NotificationCategoryShares
+	case NotificationTypeReminder:
+		return NotificationCategoryReminders
+	default:
+		return NotificationCategorySecurity
+	}
+}
+
+// NoteMention records that a collaborator was @mentioned in a shared note
+type NoteMention struct {
+	NoteID      uuid.UUID `json:"noteId"`
+	UserID      uuid.UUID `json:"userId"`
+	MentionedBy uuid.UUID `json:"mentionedBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Notification is an in-app notification for a user
+type Notification struct {
+	ID        uuid.UUID        `json:"id"`
+	UserID    uuid.UUID        `json:"userId"`
+	Type      NotificationType `json:"type"`
+	NoteID    *uuid.UUID       `json:"noteId,omitempty"`
+	ActorID   *uuid.UUID       `json:"actorId,omitempty"`
+	ReadAt    *time.Time       `json:"readAt,omitempty"`
+	CreatedAt time.Time        `json:"createdAt"`
+
+	// Populated on read
+	ActorUsername string `json:"actorUsername,omitempty"`
+	NoteTitle     string `json:"noteTitle,omitempty"`
+}
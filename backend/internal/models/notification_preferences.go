@@ -0,0 +1,40 @@
+package models
+
+// NotificationCategory groups notification types the user can configure together
+type NotificationCategory string
+
+const (
+	NotificationCategoryReminders NotificationCategory = "reminders"
+	NotificationCategoryShares    NotificationCategory = "shares"
+	NotificationCategoryMentions  NotificationCategory = "mentions"
+	NotificationCategorySecurity  NotificationCategory = "security"
+)
+
+// NotificationChannels says which channels a category of notification is delivered on
+type NotificationChannels struct {
+	Push  bool `json:"push"`
+	Email bool `json:"email"`
+	InApp bool `json:"inApp"`
+}
+
+// NotificationPreferences holds the user's channels for each category. Categories the user hasn't
+// set use DefaultNotificationPreferences.
+type NotificationPreferences map[NotificationCategory]NotificationChannels
+
+// DefaultNotificationPreferences are the channels used until the user changes them
+func DefaultNotificationPreferences() NotificationPreferences {
+	return NotificationPreferences{
+		NotificationCategoryReminders: {Push: true, Email: false, InApp: true},
+		NotificationCategoryShares:    {Push: true, Email: true, InApp: true},
+		NotificationCategoryMentions:  {Push: true, Email: false, InApp: true},
+		NotificationCategorySecurity:  {Push: true, Email: true, InApp: true},
+	}
+}
+
+// Channels returns the channels for a category, falling back to the default
+func (p NotificationPreferences) Channels(category NotificationCategory) NotificationChannels {
+	if channels, ok := p[category]; ok {
+		return channels
+	}
+	return DefaultNotificationPreferences()[category]
+}
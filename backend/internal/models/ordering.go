@@ -0,0 +1,57 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Ordering contexts. Each view of the notes list keeps its own manual order:
+//
+//	all              - the main list; stored in notes.sort_order and synced as NoteDTO.sortOrder
+//	folder:<uuid>    - a folder view
+//	tag:<name>       - a tag view
+const (
+	OrderingContextAll    = "all"
+	OrderingContextFolder = "folder"
+	OrderingContextTag    = "tag"
+)
+
+const (
+	MaxTagLength         = 50
+	MaxOrderingNoteCount = 10000
+)
+
+// IsValidOrderingContext checks that a context is "all", "folder:<uuid>" or "tag:<name>", where a tag
+// name is up to MaxTagLength letters, digits, '_' or '-'
+func IsValidOrderingContext(context string) bool {
+	if context == OrderingContextAll {
+		return true
+	}
+
+	kind, key, ok := strings.Cut(context, ":")
+	if !ok {
+		return false
+	}
+
+	switch kind {
+	case OrderingContextFolder:
+		_, err := uuid.Parse(key)
+		return err == nil
+	case OrderingContextTag:
+		if key == "" || len(key) > MaxTagLength {
+			return false
+		}
+		for _, r := range key {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			case r == '_', r == '-':
+			default:
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NotePosition is where a user last was in a note, so another device can resume there. Each user
+// has one position per note; the latest write wins.
+type NotePosition struct {
+	NoteID    uuid.UUID
+	UserID    uuid.UUID
+	Caret     int     // character offset of the caret in the content
+	Scroll    float64 // fraction of the note scrolled past, 0 to 1
+	DeviceID  string  // device that set it, if known
+	UpdatedAt time.Time
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is the server's record of an issued refresh token. Each login starts a family;
+// refreshing uses up the token and issues the next one in the same family.
+type RefreshToken struct {
+	ID        uuid.UUID // the token's jti claim
+	FamilyID  uuid.UUID
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
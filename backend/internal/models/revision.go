@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxRevisionsPerNote is how many snapshots are kept for each note; older ones are pruned on write
+const MaxRevisionsPerNote = 50
+
+// NoteRevision is a snapshot of a note's contents as saved at RecordedAt (server time)
+type NoteRevision struct {
+	ID         uuid.UUID `json:"id"`
+	NoteID     uuid.UUID `json:"noteId"`
+	UserID     uuid.UUID `json:"userId"`
+	Note       Note      `json:"note"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
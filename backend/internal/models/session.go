@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is one login on one device. Its ID is the family ID of the refresh tokens it holds.
+type Session struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	UserAgent  string
+	DeviceID   string
+	DeviceName string // name of the registered device with DeviceID, if any
+	IPAddress  string // where it last signed in or refreshed from
+	CreatedAt  time.Time
+	LastSeenAt time.Time // when it last signed in or refreshed its tokens
+	RevokedAt  *time.Time
+
+	// Set for scoped tokens, which are issued on their own rather than by logging in
+	Scope     string     // what the token may do, such as TokenScopeNotesRead; empty = anything the user can
+	Name      string     // what the user called the token
+	ExpiresAt *time.Time // when the token expires
+}
+
+// Token scopes. Access tokens without a scope can do anything their user can.
+const (
+	// TokenScopeNotesRead can read notes and attachments, but change nothing
+	TokenScopeNotesRead = "notes:read"
+)
+
+// IsValidTokenScope reports whether scope can be given to a token
+func IsValidTokenScope(scope string) bool {
+	return scope == TokenScopeNotesRead
+}
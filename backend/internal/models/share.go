@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NoteShare grants a collaborator access to another user's note
+type NoteShare struct {
+	NoteID    uuid.UUID `json:"noteId"`
+	UserID    uuid.UUID `json:"userId"`
+	GrantedBy uuid.UUID `json:"grantedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// NoteInvite is a pending invitation for someone, identified by email, to collaborate on a note
+type NoteInvite struct {
+	ID         uuid.UUID  `json:"id"`
+	NoteID     uuid.UUID  `json:"noteId"`
+	InvitedBy  uuid.UUID  `json:"invitedBy"`
+	Email      string     `json:"email"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	AcceptedAt *time.Time `json:"acceptedAt,omitempty"`
+	AcceptedBy *uuid.UUID `json:"acceptedBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	SentAt     time.Time  `json:"sentAt"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SyncBatchRetention is how long applied sync batches can be reverted
+const SyncBatchRetention = 30 * 24 * time.Hour
+
+// SyncBatch records one applied sync request (or revert)
+type SyncBatch struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	RequestID  string
+	CreatedAt  time.Time
+	RevertedAt *time.Time
+	NoteCount  int
+}
+
+// SyncBatchNote is the state of a note before a batch changed it; PreImage is nil if the batch created it
+type SyncBatchNote struct {
+	BatchID  uuid.UUID
+	NoteID   uuid.UUID
+	PreImage *Note
+}
@@ -0,0 +1,21 @@
+package models
+
+// TelemetryReport is everything an instance sends when telemetry is enabled. It identifies the
+// instance only by a random ID and gives the user count as a range.
+type TelemetryReport struct {
+	InstanceID      string `json:"instanceId"`
+	Version         string `json:"version"`
+	GoVersion       string `json:"goVersion"`
+	OS              string `json:"os"`
+	Arch            string `json:"arch"`
+	Database        string `json:"database"`
+	DatabaseVersion string `json:"databaseVersion"` // major version only
+	Users           string `json:"users"`           // e.g. "2-10"
+}
+
+// TelemetryPreviewDTO shows whether telemetry is on, where it goes, and the exact report
+type TelemetryPreviewDTO struct {
+	Enabled  bool            `json:"enabled"`
+	Endpoint string          `json:"endpoint,omitempty"`
+	Report   TelemetryReport `json:"report"`
+}
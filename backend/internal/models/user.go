@@ -7,9 +7,15 @@ import (
 )
 
 type User struct {
-	ID           uuid.UUID  `json:"id"`
-	Username     string     `json:"username"`
-	PasswordHash string     `json:"-"`
-	CreatedAt    time.Time  `json:"createdAt"`
-	UpdatedAt    time.Time  `json:"updatedAt"`
+	ID                uuid.UUID  `json:"id"`
+	Username          string     `json:"username"`
+	PasswordHash      string     `json:"-"`
+	PasswordAlgorithm string     `json:"-"` // what made PasswordHash: passwordhash.Argon2id or passwordhash.Bcrypt
+	Email             string     `json:"email,omitempty"`
+	VerifiedAt        *time.Time `json:"verifiedAt,omitempty"` // when Email was verified
+	IsAdmin           bool       `json:"-"`
+	ReadOnly          bool       `json:"-"` // can read but not change anything, like a shared demo account
+	DemoExpiresAt     *time.Time `json:"-"` // for a visitor's own demo account, when it stops working; nil otherwise
+	CreatedAt         time.Time  `json:"createdAt"`
+	UpdatedAt         time.Time  `json:"updatedAt"`
 }
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebAuthn ceremonies a session can be used for
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+)
+
+// WebAuthnSessionExpiry is how long a client has to complete a passkey registration or sign-in
+const WebAuthnSessionExpiry = 5 * time.Minute
+
+// MaxWebAuthnCredentials limits how many passkeys a user can register
+const MaxWebAuthnCredentials = 20
+
+// WebAuthnCredential is a passkey a user can sign in with
+type WebAuthnCredential struct {
+	ID             uuid.UUID
+	UserID         uuid.UUID
+	CredentialID   []byte // chosen by the authenticator
+	PublicKey      []byte // COSE_Key
+	SignCount      int64
+	AAGUID         []byte
+	Transports     []string // hints for how clients reach the authenticator, such as "internal" or "usb"
+	BackupEligible bool     // synced between the user's devices
+	Name           string
+	CreatedAt      time.Time
+	LastUsedAt     *time.Time
+}
+
+// WebAuthnSession is the challenge for one passkey registration or sign-in
+type WebAuthnSession struct {
+	ID        uuid.UUID
+	UserID    *uuid.UUID // nil for sign-ins that let the passkey identify the user
+	Ceremony  string
+	Challenge []byte
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,313 @@
+// Package nats is a small NATS client speaking the core text protocol over one connection: enough
+// to publish and to subscribe, without pulling in a full client library. JetStream, request-reply
+// and cluster discovery aren't supported.
+package nats
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 5 * time.Second
+
+	// Connections that drop are re-established after a delay that doubles up to maxReconnectDelay
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 10 * time.Second
+)
+
+var (
+	ErrInvalidURL   = errors.New("invalid NATS URL: use nats://[user:password@]host[:port] or tls:// for TLS")
+	ErrNotConnected = errors.New("nats: not connected")
+)
+
+// Client holds one connection to a NATS server. Connect opens it, and Run keeps it open, delivering
+// messages for the subscriptions and reconnecting when it drops.
+type Client struct {
+	addr     string
+	host     string
+	useTLS   bool
+	user     string
+	password string
+	token    string
+
+	mu   sync.Mutex
+	conn net.Conn
+	subs []subscription
+
+	// pendingReader reads the connection Connect opened, until Run takes it over
+	pendingReader *bufio.Reader
+}
+
+type subscription struct {
+	subject string
+	receive func(subject string, data []byte)
+}
+
+// New returns a client for the server at rawURL. A user without a password is sent as a token.
+func New(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Hostname() == "" {
+		return nil, ErrInvalidURL
+	}
+	c := &Client{addr: u.Host, host: u.Hostname(), useTLS: u.Scheme == "tls"}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			c.user, c.password = u.User.Username(), password
+		} else {
+			c.token = u.User.Username()
+		}
+	}
+	return c, nil
+}
+
+// Subscribe delivers messages on subject, which may contain wildcards, to receive while Run runs.
+// Call it before Run, which subscribes each time it connects.
+func (c *Client) Subscribe(subject string, receive func(subject string, data []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subs = append(c.subs, subscription{subject: subject, receive: receive})
+}
+
+// Publish sends data on subject. It fails while the connection is down; nothing is queued.
+func (c *Client) Publish(ctx context.Context, subject string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	return c.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", subject, len(data), data))
+}
+
+// Connect opens the connection, so a server that can't be reached is found at startup
+func (c *Client) Connect(ctx context.Context) error {
+	conn, rd, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.pendingReader = rd
+	c.mu.Unlock()
+	return nil
+}
+
+// Run reads from the connection until ctx is cancelled, reconnecting and resubscribing when it drops.
+// Messages published while it's down are lost.
+func (c *Client) Run(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		start := time.Now()
+		err := c.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+		slog.Warn("NATS connection dropped, retrying", "addr", c.addr, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// serve uses the connection Connect opened, or opens one, subscribes, and reads until it fails
+func (c *Client) serve(ctx context.Context) error {
+	c.mu.Lock()
+	conn, rd := c.conn, c.pendingReader
+	c.pendingReader = nil
+	c.mu.Unlock()
+	if conn == nil || rd == nil {
+		var err error
+		if conn, rd, err = c.dial(ctx); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	for i, sub := range c.subs {
+		if err := c.write(fmt.Sprintf("SUB %s %d\r\n", sub.subject, i+1)); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+	}
+	c.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer func() {
+		c.mu.Lock()
+		if c.conn == conn {
+			c.conn = nil
+		}
+		c.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		line, err := readLine(rd)
+		if err != nil {
+			return err
+		}
+		op, args, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(op) {
+		case "MSG":
+			subject, sid, size, err := parseMsg(args)
+			if err != nil {
+				return err
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(rd, data); err != nil {
+				return err
+			}
+			c.dispatch(sid, subject, data[:size])
+		case "PING":
+			c.mu.Lock()
+			err := c.write("PONG\r\n")
+			c.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		case "-ERR":
+			return fmt.Errorf("nats: %s", args)
+		}
+		// +OK, PONG and INFO need no answer
+	}
+}
+
+// dispatch passes a message to the subscription it was delivered for
+func (c *Client) dispatch(sid int, subject string, data []byte) {
+	c.mu.Lock()
+	var receive func(string, []byte)
+	if sid >= 1 && sid <= len(c.subs) {
+		receive = c.subs[sid-1].receive
+	}
+	c.mu.Unlock()
+	if receive != nil {
+		receive(subject, data)
+	}
+}
+
+// write sends raw protocol text; the caller holds mu
+func (c *Client) write(text string) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := io.WriteString(c.conn, text)
+	return err
+}
+
+// dial connects, upgrading to TLS after the server's INFO when asked, and sends CONNECT, waiting for
+// the PONG that confirms it was accepted
+func (c *Client) dial(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	conn, err := (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	rd := bufio.NewReader(conn)
+
+	line, err := readLine(rd)
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, nil, fmt.Errorf("nats: expected INFO from server: %v", err)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info)
+	if c.useTLS || info.TLSRequired {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: c.host, MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+		rd = bufio.NewReader(conn)
+	}
+
+	connect, _ := json.Marshal(map[string]any{
+		"verbose":    false,
+		"pedantic":   false,
+		"name":       "notes-backend",
+		"lang":       "go",
+		"protocol":   1,
+		"user":       c.user,
+		"pass":       c.password,
+		"auth_token": c.token,
+	})
+	if _, err := io.WriteString(conn, "CONNECT "+string(connect)+"\r\nPING\r\n"); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	for {
+		line, err := readLine(rd)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return nil, nil, fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		if line == "PONG" {
+			break
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, rd, nil
+}
+
+func readLine(rd *bufio.Reader) (string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// parseMsg parses the arguments of MSG: <subject> <sid> [reply-to] <#bytes>
+func parseMsg(args string) (subject string, sid, size int, err error) {
+	fields := strings.Fields(args)
+	if len(fields) != 3 && len(fields) != 4 {
+		return "", 0, 0, fmt.Errorf("nats: malformed MSG %q", args)
+	}
+	if sid, err = strconv.Atoi(fields[1]); err != nil {
+		return "", 0, 0, fmt.Errorf("nats: malformed MSG %q", args)
+	}
+	if size, err = strconv.Atoi(fields[len(fields)-1]); err != nil || size < 0 {
+		return "", 0, 0, fmt.Errorf("nats: malformed MSG %q", args)
+	}
+	return fields[0], sid, size, nil
+}
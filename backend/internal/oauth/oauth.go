@@ -0,0 +1,393 @@
+// Package oauth signs users in with OpenID Connect providers: Sign in with Apple and Google.
+//
+// It implements the authorization code flow (with PKCE where the provider supports it) and
+// verifies the ID tokens providers return, including ones apps get directly from the providers'
+// native SDKs.
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrNonceMismatch  = errors.New("ID token nonce does not match")
+	ErrExchangeFailed = errors.New("authorization code exchange failed")
+)
+
+// Providers
+const (
+	ProviderApple  = "apple"
+	ProviderGoogle = "google"
+)
+
+// Provider is an OpenID Connect provider users can sign in with
+type Provider struct {
+	Name string
+
+	clientID  string
+	audiences []string // client IDs ID tokens may be issued to: the web client plus any native apps
+
+	authURL  string
+	tokenURL string
+	jwksURL  string
+	issuers  []string
+	scopes   string
+	pkce     bool
+	formPost bool // the provider posts the callback as a form instead of redirecting
+
+	// clientSecret returns the secret sent with the authorization code
+	clientSecret func() (string, error)
+
+	keys   *keySet
+	client *http.Client
+}
+
+// Identity is the user a provider vouched for
+type Identity struct {
+	Subject       string // stable ID of the user at the provider
+	Email         string
+	EmailVerified bool
+}
+
+// GoogleConfig configures Sign in with Google
+type GoogleConfig struct {
+	ClientID     string   // web client ID
+	ClientSecret string   // web client secret
+	AppClientIDs []string // client IDs of the native apps, whose ID tokens are also accepted
+}
+
+// AppleConfig configures Sign in with Apple
+type AppleConfig struct {
+	ServiceID  string   // Services ID used on the web
+	TeamID     string   // Apple developer team ID
+	KeyID      string   // ID of the Sign in with Apple private key
+	PrivateKey string   // the private key (.p8 file contents, PEM)
+	BundleIDs  []string // bundle IDs of the native apps, whose ID tokens are also accepted
+}
+
+// appleClientSecretLifetime is how long each client secret JWT is valid for; Apple allows up to six
+// months, but they are cheap to make
+const appleClientSecretLifetime = 5 * time.Minute
+
+// NewGoogle returns the Google provider
+func NewGoogle(cfg GoogleConfig) *Provider {
+	return newProvider(&Provider{
+		Name:         ProviderGoogle,
+		clientID:     cfg.ClientID,
+		audiences:    append([]string{cfg.ClientID}, cfg.AppClientIDs...),
+		authURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		tokenURL:     "https://oauth2.googleapis.com/token",
+		jwksURL:      "https://www.googleapis.com/oauth2/v3/certs",
+		issuers:      []string{"https://accounts.google.com", "accounts.google.com"},
+		scopes:       "openid email",
+		pkce:         true,
+		clientSecret: func() (string, error) { return cfg.ClientSecret, nil },
+	})
+}
+
+// NewApple returns the Apple provider. Its client secret is a JWT signed with the team's private key.
+func NewApple(cfg AppleConfig) (*Provider, error) {
+	key, err := parseECPrivateKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("apple private key: %w", err)
+	}
+
+	return newProvider(&Provider{
+		Name:      ProviderApple,
+		clientID:  cfg.ServiceID,
+		audiences: append([]string{cfg.ServiceID}, cfg.BundleIDs...),
+		authURL:   "https://appleid.apple.com/auth/authorize",
+		tokenURL:  "https://appleid.apple.com/auth/token",
+		jwksURL:   "https://appleid.apple.com/auth/keys",
+		issuers:   []string{"https://appleid.apple.com"},
+		scopes:    "email",
+		formPost:  true, // required when asking for the email scope
+		clientSecret: func() (string, error) {
+			now := time.Now()
+			token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+				Issuer:    cfg.TeamID,
+				Subject:   cfg.ServiceID,
+				Audience:  jwt.ClaimStrings{"https://appleid.apple.com"},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(appleClientSecretLifetime)),
+			})
+			token.Header["kid"] = cfg.KeyID
+			return token.SignedString(key)
+		},
+	}), nil
+}
+
+func newProvider(p *Provider) *Provider {
+	p.client = &http.Client{Timeout: 10 * time.Second}
+	p.keys = &keySet{url: p.jwksURL, client: p.client}
+	return p
+}
+
+// FormPost reports whether the provider posts its callback as a form
+func (p *Provider) FormPost() bool {
+	return p.formPost
+}
+
+// PKCE reports whether the provider takes a PKCE code verifier
+func (p *Provider) PKCE() bool {
+	return p.pkce
+}
+
+// AuthURL returns where to send the user to sign in. The provider sends them back to redirectURI
+// with the state and an authorization code.
+func (p *Provider) AuthURL(redirectURI, state, nonce, codeVerifier string) string {
+	params := url.Values{
+		"client_id":     {p.clientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {p.scopes},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	if p.formPost {
+		params.Set("response_mode", "form_post")
+	}
+	if p.pkce {
+		challenge := sha256.Sum256([]byte(codeVerifier))
+		params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+		params.Set("code_challenge_method", "S256")
+	}
+	return p.authURL + "?" + params.Encode()
+}
+
+// Exchange trades an authorization code for the user's identity, checking the ID token was issued
+// for this sign-in's nonce
+func (p *Provider) Exchange(ctx context.Context, code, redirectURI, codeVerifier, nonce string) (*Identity, error) {
+	secret, err := p.clientSecret()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.clientID},
+		"client_secret": {secret},
+	}
+	if p.pkce {
+		form.Set("code_verifier", codeVerifier)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s returned %d: %s", ErrExchangeFailed, p.Name, resp.StatusCode, truncate(string(body), 200))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: %s returned no ID token", ErrExchangeFailed, p.Name)
+	}
+
+	identity, tokenNonce, err := p.VerifyIDToken(ctx, tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if tokenNonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+	return identity, nil
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience and expiry, and returns the
+// identity along with the token's nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string) (*Identity, string, error) {
+	var claims struct {
+		jwt.RegisteredClaims
+		Email         string `json:"email"`
+		EmailVerified any    `json:"email_verified"` // Apple sends "true" as a string
+		Nonce         string `json:"nonce"`
+	}
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.get(ctx, kid)
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithExpirationRequired(), jwt.WithLeeway(time.Minute))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if !slices.Contains(p.issuers, claims.Issuer) {
+		return nil, "", fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+	if !slices.ContainsFunc(claims.Audience, func(aud string) bool { return aud != "" && slices.Contains(p.audiences, aud) }) {
+		return nil, "", fmt.Errorf("%w: issued to another client", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return nil, "", fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	verified := claims.EmailVerified == true || claims.EmailVerified == "true"
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: verified && claims.Email != "",
+	}, claims.Nonce, nil
+}
+
+// NonceMatches reports whether a token's nonce is the given raw nonce, or its hex SHA-256 as
+// Apple's native SDK sends it
+func NonceMatches(tokenNonce, nonce string) bool {
+	if tokenNonce == "" || nonce == "" {
+		return false
+	}
+	hash := sha256.Sum256([]byte(nonce))
+	return tokenNonce == nonce || tokenNonce == hex.EncodeToString(hash[:])
+}
+
+// RandomString returns a random URL-safe string, for states, nonces and PKCE code verifiers
+func RandomString() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// keyRefreshInterval is the longest a provider's signing keys are cached; an unknown key ID
+// refreshes them sooner, at most once per keyRefreshMinInterval
+const (
+	keyRefreshInterval    = time.Hour
+	keyRefreshMinInterval = time.Minute
+)
+
+// keySet caches a provider's signing keys
+type keySet struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// get returns the key with the given ID, fetching the provider's keys if it isn't cached
+func (k *keySet) get(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	age := time.Since(k.fetchedAt)
+	if key, ok := k.keys[kid]; ok && age < keyRefreshInterval {
+		return key, nil
+	}
+	if age >= keyRefreshMinInterval {
+		if err := k.fetch(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (k *keySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching signing keys: %s returned %d", k.url, resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&jwks); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	k.keys = keys
+	k.fetchedAt = time.Now()
+	return nil
+}
+
+// parseECPrivateKey parses a PKCS #8 PEM EC private key. Literal "\n" sequences are accepted in
+// place of newlines, so the key can be given in a single-line environment variable.
+func parseECPrivateKey(pemData string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(strings.ReplaceAll(pemData, `\n`, "\n")))
+	if block == nil {
+		return nil, errors.New("not PEM-encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an EC private key")
+	}
+	return ecKey, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,131 @@
+// Package passwordhash hashes passwords with Argon2id and checks them against
+// stored hashes. Each stored hash is tagged with the algorithm that made it, so
+// bcrypt hashes from before Argon2id was adopted keep working, and can be
+// replaced the next time their owner logs in.
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms a stored password hash may be tagged with
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// Params are the Argon2id cost parameters
+type Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultParams follow the OWASP recommendation for Argon2id
+var DefaultParams = Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 2}
+
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+// ErrMalformedHash means a stored Argon2id hash couldn't be decoded
+var ErrMalformedHash = errors.New("malformed argon2id hash")
+
+// Hasher makes Argon2id hashes with its parameters
+type Hasher struct {
+	params Params
+}
+
+// New creates a Hasher; zero parameters fall back to DefaultParams
+func New(params Params) *Hasher {
+	if params.Memory == 0 {
+		params.Memory = DefaultParams.Memory
+	}
+	if params.Iterations == 0 {
+		params.Iterations = DefaultParams.Iterations
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = DefaultParams.Parallelism
+	}
+	return &Hasher{params: params}
+}
+
+// Hash returns an Argon2id hash of password in the standard encoding,
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches a hash made with algorithm. Empty and malformed hashes,
+// such as those of accounts that only sign in with a provider, match nothing.
+func (h *Hasher) Verify(password, hash, algorithm string) bool {
+	switch algorithm {
+	case Argon2id:
+		params, salt, key, err := decode(hash)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	case Bcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	default:
+		return false
+	}
+}
+
+// NeedsRehash reports whether a hash that just verified should be replaced: it was made by another
+// algorithm, or with other Argon2id parameters
+func (h *Hasher) NeedsRehash(hash, algorithm string) bool {
+	if algorithm != Argon2id {
+		return true
+	}
+	params, _, _, err := decode(hash)
+	return err != nil || params != h.params
+}
+
+// decode splits an encoded Argon2id hash into its parameters, salt and key
+func decode(hash string) (Params, []byte, []byte, error) {
+	var params Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != Argon2id {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return params, nil, nil, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrMalformedHash
+	}
+	return params, salt, key, nil
+}
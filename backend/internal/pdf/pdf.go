@@ -0,0 +1,291 @@
+// Package pdf writes simple text-only PDF documents using the standard
+// Helvetica fonts, so no font files or external dependencies are needed.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PageSize is a page's width and height in points (1/72 inch)
+type PageSize struct {
+	Width  float64
+	Height float64
+}
+
+var (
+	PageSizeA4     = PageSize{Width: 595.28, Height: 841.89}
+	PageSizeLetter = PageSize{Width: 612, Height: 792}
+)
+
+// ParsePageSize returns the page size for a name such as "a4" or "letter"
+func ParsePageSize(name string) (PageSize, bool) {
+	switch strings.ToLower(name) {
+	case "a4":
+		return PageSizeA4, true
+	case "letter":
+		return PageSizeLetter, true
+	}
+	return PageSize{}, false
+}
+
+// Font selects one of the built-in fonts
+type Font int
+
+const (
+	FontRegular Font = iota
+	FontBold
+)
+
+const (
+	margin      = 56 // ~20mm
+	lineSpacing = 1.35
+)
+
+// Document accumulates pages of wrapped text
+type Document struct {
+	size  PageSize
+	title string
+	pages []*bytes.Buffer
+	y     float64
+}
+
+// New creates an empty document with the given page size
+func New(size PageSize) *Document {
+	return &Document{size: size}
+}
+
+// SetTitle sets the title stored in the document information dictionary
+func (d *Document) SetTitle(title string) {
+	d.title = title
+}
+
+// Text writes text in the given font and size, wrapping it to the page width
+// and starting new pages as needed. Newlines in text start new lines.
+func (d *Document) Text(text string, font Font, size float64) {
+	d.TextIndented(text, font, size, 0)
+}
+
+// TextIndented is like Text but indents every line by indent points
+func (d *Document) TextIndented(text string, font Font, size float64, indent float64) {
+	maxWidth := d.size.Width - 2*margin - indent
+	for _, paragraph := range strings.Split(text, "\n") {
+		for _, line := range wrap(paragraph, font, size, maxWidth) {
+			d.writeLine(line, font, size, margin+indent)
+		}
+	}
+}
+
+// Space adds vertical space, in points
+func (d *Document) Space(height float64) {
+	d.ensurePage()
+	d.y -= height
+}
+
+// Rule draws a thin horizontal line across the text area
+func (d *Document) Rule() {
+	d.Space(6)
+	page := d.pages[len(d.pages)-1]
+	fmt.Fprintf(page, "0.8 G 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n", float64(margin), d.y, d.size.Width-margin, d.y)
+	d.Space(10)
+}
+
+// PageBreak starts a new page, unless nothing has been written on the current one
+func (d *Document) PageBreak() {
+	if len(d.pages) > 0 && d.y < d.size.Height-margin {
+		d.newPage()
+	}
+}
+
+// Bytes renders the document
+func (d *Document) Bytes() []byte {
+	d.ensurePage()
+
+	var buf bytes.Buffer
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-5 are fixed; each page then adds a page object and its content stream
+	const firstPageObj = 6
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPageObj+2*i)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (Notes) /CreationDate (D:%s) >>",
+		escape(d.title), time.Now().UTC().Format("20060102150405Z")))
+
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			d.size.Width, d.size.Height, firstPageObj+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes()
+}
+
+func (d *Document) ensurePage() {
+	if len(d.pages) == 0 {
+		d.newPage()
+	}
+}
+
+func (d *Document) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = d.size.Height - margin
+}
+
+func (d *Document) writeLine(line string, font Font, size float64, x float64) {
+	d.ensurePage()
+	height := size * lineSpacing
+	if d.y-height < margin {
+		d.newPage()
+	}
+	d.y -= height
+
+	fontName := "F1"
+	if font == FontBold {
+		fontName = "F2"
+	}
+
+	page := d.pages[len(d.pages)-1]
+	fmt.Fprintf(page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", fontName, size, x, d.y, escape(line))
+}
+
+// wrap splits text into lines no wider than maxWidth, breaking at spaces
+// where possible and mid-word only when a single word is too long
+func wrap(text string, font Font, size float64, maxWidth float64) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{""}
+	}
+
+	var lines []string
+	current := ""
+	for _, word := range words {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if textWidth(candidate, font, size) <= maxWidth {
+			current = candidate
+			continue
+		}
+		if current != "" {
+			lines = append(lines, current)
+			current = ""
+		}
+		// Break words that don't fit on a line by themselves
+		for textWidth(word, font, size) > maxWidth {
+			cut := len([]rune(word)) - 1
+			for cut > 1 && textWidth(string([]rune(word)[:cut]), font, size) > maxWidth {
+				cut--
+			}
+			lines = append(lines, string([]rune(word)[:cut]))
+			word = string([]rune(word)[cut:])
+		}
+		current = word
+	}
+	return append(lines, current)
+}
+
+// textWidth returns the width of text in points
+func textWidth(text string, font Font, size float64) float64 {
+	widths := &helveticaWidths
+	if font == FontBold {
+		widths = &helveticaBoldWidths
+	}
+
+	total := 0
+	for _, r := range text {
+		if r >= 32 && r <= 126 {
+			total += widths[r-32]
+		} else {
+			total += defaultGlyphWidth
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// escape encodes text as WinAnsi and escapes it for a PDF string literal.
+// Characters outside WinAnsi are replaced with '?'.
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		c, ok := toWinAnsi(r)
+		if !ok {
+			c = '?'
+		}
+		switch c {
+		case '\\', '(', ')':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func toWinAnsi(r rune) (byte, bool) {
+	switch {
+	case r == '\t':
+		return ' ', true
+	case r >= 32 && r <= 126:
+		return byte(r), true
+	case r >= 160 && r <= 255:
+		return byte(r), true
+	}
+	if c, ok := winAnsiExtras[r]; ok {
+		return c, true
+	}
+	return 0, false
+}
+
+// winAnsiExtras maps the characters WinAnsiEncoding places in 0x80-0x9F
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// Glyph widths (per 1000 units of font size) for characters 32-126
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// Width used for characters outside the printable ASCII range
+const defaultGlyphWidth = 556
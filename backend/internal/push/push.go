@@ -0,0 +1,75 @@
+// Package push delivers push notifications to users' devices through a push gateway.
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Message is a push notification for all of one user's devices
+type Message struct {
+	UserID string `json:"userId"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	Link   string `json:"link,omitempty"`
+}
+
+// Pusher delivers push notifications
+type Pusher interface {
+	Push(ctx context.Context, msg Message) error
+}
+
+// New returns a pusher that posts to the gateway at gatewayURL, or a logging pusher if it is empty.
+// The gateway fans each message out to the user's registered devices (APNs, FCM, web push).
+func New(gatewayURL string) Pusher {
+	if gatewayURL == "" {
+		return LogPusher{}
+	}
+	return &GatewayPusher{
+		url:    gatewayURL,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GatewayPusher posts messages as JSON to a push gateway
+type GatewayPusher struct {
+	url    string
+	client *http.Client
+}
+
+func (p *GatewayPusher) Push(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("push gateway returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// LogPusher writes messages to the log instead of sending them
+type LogPusher struct{}
+
+func (LogPusher) Push(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "Push gateway not configured, dropping message", "recipient_id", msg.UserID, "title", msg.Title)
+	return nil
+}
@@ -0,0 +1,94 @@
+package pwned
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+// A Bloom filter file is bloomMagic, the number of hash functions (uint32) and the number of bits
+// (uint64), both big-endian, followed by the bits
+var bloomMagic = []byte("PWNBLOOM")
+
+var ErrBadBloomFile = errors.New("pwned: not a Bloom filter file")
+
+// Bloom is a Bloom filter of SHA-1 password hashes. Since the hashes are already uniformly
+// distributed, its bit positions are derived from them directly.
+type Bloom struct {
+	hashes uint32
+	bits   uint64
+	set    []byte
+}
+
+// NewBloom creates an empty filter sized for n hashes with false positive rate p
+func NewBloom(n uint64, p float64) *Bloom {
+	bits := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	bits = max(bits, 64)
+	hashes := uint32(max(math.Round(float64(bits)/float64(n)*math.Ln2), 1))
+	return &Bloom{hashes: hashes, bits: bits, set: make([]byte, (bits+7)/8)}
+}
+
+// LoadBloom reads a filter written by WriteTo
+func LoadBloom(path string) (*Bloom, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	header := len(bloomMagic) + 12
+	if len(data) < header || !bytes.Equal(data[:len(bloomMagic)], bloomMagic) {
+		return nil, ErrBadBloomFile
+	}
+	b := &Bloom{
+		hashes: binary.BigEndian.Uint32(data[len(bloomMagic):]),
+		bits:   binary.BigEndian.Uint64(data[len(bloomMagic)+4:]),
+		set:    data[header:],
+	}
+	if b.hashes == 0 || b.bits == 0 || uint64(len(b.set)) != (b.bits+7)/8 {
+		return nil, ErrBadBloomFile
+	}
+	return b, nil
+}
+
+// WriteTo writes the filter in the format LoadBloom reads
+func (b *Bloom) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, 0, len(bloomMagic)+12)
+	header = append(header, bloomMagic...)
+	header = binary.BigEndian.AppendUint32(header, b.hashes)
+	header = binary.BigEndian.AppendUint64(header, b.bits)
+	n, err := w.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(b.set)
+	return int64(n + m), err
+}
+
+// Add adds a SHA-1 password hash
+func (b *Bloom) Add(sum [sha1.Size]byte) {
+	h1, h2 := b.split(sum)
+	for i := uint64(0); i < uint64(b.hashes); i++ {
+		bit := (h1 + i*h2) % b.bits
+		b.set[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Contains reports whether a SHA-1 password hash may have been added
+func (b *Bloom) Contains(sum [sha1.Size]byte) bool {
+	h1, h2 := b.split(sum)
+	for i := uint64(0); i < uint64(b.hashes); i++ {
+		bit := (h1 + i*h2) % b.bits
+		if b.set[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// split takes the two hashes for double hashing from the SHA-1; the second is odd, so it's never 0
+func (b *Bloom) split(sum [sha1.Size]byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(sum[0:8]), binary.BigEndian.Uint64(sum[8:16]) | 1
+}
@@ -0,0 +1,103 @@
+// Package pwned checks passwords against the Have I Been Pwned list of passwords
+// exposed in data breaches. Passwords are looked up with the k-anonymity range API,
+// which is sent only the first five characters of the password's SHA-1 hash, or in
+// an offline Bloom filter built from the downloaded list.
+package pwned
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultAPIURL is the Have I Been Pwned range API; the hash prefix is appended to it
+const DefaultAPIURL = "https://api.pwnedpasswords.com/range/"
+
+// Checker reports whether a password has appeared in a breach
+type Checker interface {
+	Breached(ctx context.Context, password string) (bool, error)
+}
+
+// Config chooses where passwords are looked up
+type Config struct {
+	APIURL    string // range API; empty = only use the Bloom filter
+	BloomFile string // Bloom filter built by cmd/pwnedbloom, used offline or when the API fails
+}
+
+// New returns a checker that asks the range API, falling back to the Bloom filter if it can't be
+// reached, or just uses the filter if no API is set
+func New(cfg Config) (Checker, error) {
+	checker := &RangeChecker{
+		url:    cfg.APIURL,
+		client: &http.Client{Timeout: 3 * time.Second},
+	}
+	if cfg.BloomFile != "" {
+		bloom, err := LoadBloom(cfg.BloomFile)
+		if err != nil {
+			return nil, err
+		}
+		checker.bloom = bloom
+	}
+	if checker.url == "" && checker.bloom == nil {
+		return nil, fmt.Errorf("pwned: an API URL or a Bloom filter is required")
+	}
+	return checker, nil
+}
+
+// RangeChecker looks passwords up with the range API and a Bloom filter
+type RangeChecker struct {
+	url    string
+	client *http.Client
+	bloom  *Bloom // nil = no offline fallback
+}
+
+func (c *RangeChecker) Breached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	if c.url != "" {
+		found, err := c.lookup(ctx, strings.ToUpper(hex.EncodeToString(sum[:])))
+		if err == nil {
+			return found, nil
+		}
+		if c.bloom == nil {
+			return false, err
+		}
+		slog.WarnContext(ctx, "Pwned Passwords API unavailable, using the offline filter", "error", err)
+	}
+	return c.bloom.Contains(sum), nil
+}
+
+// lookup asks the range API for the hashes sharing the first five characters of hash. Responses
+// are padded with fake entries, counted 0, so their size doesn't hint at the password.
+func (c *RangeChecker) lookup(ctx context.Context, hash string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+hash[:5], nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", "notes-app-backend")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("pwned passwords API returned %d", resp.StatusCode)
+	}
+
+	suffix := hash[5:]
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok && strings.EqualFold(candidate, suffix) {
+			return count != "0", nil
+		}
+	}
+	return false, scanner.Err()
+}
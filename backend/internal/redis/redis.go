@@ -0,0 +1,311 @@
+// Package redis is a small Redis client speaking RESP over a single connection: enough to run
+// commands and to subscribe to a Pub/Sub channel, without pulling in a full client library.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout    = 5 * time.Second
+	commandTimeout = 5 * time.Second
+
+	// Subscriptions that drop are re-established after a delay that doubles up to maxResubscribeDelay
+	minResubscribeDelay = 100 * time.Millisecond
+	maxResubscribeDelay = 10 * time.Second
+)
+
+var ErrInvalidURL = errors.New("invalid Redis URL: use redis://[user:password@]host[:port][/db] or rediss:// for TLS")
+
+// Error is an error reply from the server
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Client runs commands over one connection, redialled when it breaks. Commands from concurrent
+// callers take turns.
+type Client struct {
+	addr     string
+	username string
+	password string
+	db       int
+	useTLS   bool
+	host     string
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// New returns a client for the server at rawURL. It doesn't connect until the first command.
+func New(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return nil, ErrInvalidURL
+	}
+	c := &Client{
+		addr:   u.Host,
+		host:   u.Hostname(),
+		useTLS: u.Scheme == "rediss",
+	}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, ErrInvalidURL
+		}
+	}
+	return c, nil
+}
+
+// Do runs a command and returns its reply: a string for simple strings, int64 for integers, []byte
+// or nil for bulk strings, and []any or nil for arrays. Error replies are returned as Error.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		conn, rd, err := c.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.conn, c.rd = conn, rd
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(commandTimeout)
+	}
+	c.conn.SetDeadline(deadline)
+
+	reply, err := command(c.conn, c.rd, args...)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection may be out of step with the server; start afresh next time
+		c.conn.Close()
+		c.conn, c.rd = nil, nil
+	}
+	return reply, err
+}
+
+// Ping checks that the server can be reached
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Close closes the connection; the next command opens a new one
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.rd = nil, nil
+	return err
+}
+
+// Subscribe passes each message published to channel to receive until ctx is cancelled. It uses
+// its own connection, which is re-established if it drops; messages published meanwhile are lost.
+func (c *Client) Subscribe(ctx context.Context, channel string, receive func(payload []byte)) {
+	delay := minResubscribeDelay
+	for {
+		start := time.Now()
+		err := c.subscribe(ctx, channel, receive)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxResubscribeDelay {
+			delay = minResubscribeDelay
+		}
+		slog.Warn("Redis subscription dropped, retrying", "channel", channel, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxResubscribeDelay)
+	}
+}
+
+func (c *Client) subscribe(ctx context.Context, channel string, receive func([]byte)) error {
+	conn, rd, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Reads block until a message arrives; closing the connection ends them on shutdown
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(commandTimeout))
+	if err := writeCommand(conn, "SUBSCRIBE", channel); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	for {
+		reply, err := readReply(rd)
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]any)
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		if kind, _ := parts[0].([]byte); string(kind) == "message" {
+			if payload, ok := parts[2].([]byte); ok {
+				receive(payload)
+			}
+		}
+	}
+}
+
+// dial opens a connection, authenticating and selecting the database
+func (c *Client) dial(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	var err error
+	if c.useTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: c.host, MinVersion: tls.VersionTLS12}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", c.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	rd := bufio.NewReader(conn)
+
+	conn.SetDeadline(time.Now().Add(commandTimeout))
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.username != "" {
+			args = []string{"AUTH", c.username, c.password}
+		}
+		if _, err := command(conn, rd, args...); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := command(conn, rd, "SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, rd, nil
+}
+
+func command(w io.Writer, rd *bufio.Reader, args ...string) (any, error) {
+	if err := writeCommand(w, args...); err != nil {
+		return nil, err
+	}
+	return readReply(rd)
+}
+
+// writeCommand sends a command as an array of bulk strings
+func writeCommand(w io.Writer, args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// readReply reads one RESP2 reply
+func readReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(rd); err != nil {
+				var replyErr Error
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				items[i] = replyErr
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// Script is a Lua script run on the server. It is sent by its SHA1 digest, and in full only when
+// the server hasn't cached it yet, such as after a restart.
+type Script struct {
+	src string
+	sha string
+}
+
+// NewScript returns a script for src
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+// Run runs the script with keys and args, returning its reply as Do does
+func (s *Script) Run(ctx context.Context, c *Client, keys []string, args ...string) (any, error) {
+	cmd := append([]string{"EVALSHA", s.sha, strconv.Itoa(len(keys))}, keys...)
+	reply, err := c.Do(ctx, append(cmd, args...)...)
+	var replyErr Error
+	if errors.As(err, &replyErr) && strings.HasPrefix(string(replyErr), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", s.src
+		reply, err = c.Do(ctx, append(cmd, args...)...)
+	}
+	return reply, err
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrActivitySubscriptionNotFound = errors.New("not subscribed to the activity summary")
+
+// ActivityRepository aggregates a user's activity and stores activity summary subscriptions
+type ActivityRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewActivityRepository(pool *pgxpool.Pool) *ActivityRepository {
+	return &ActivityRepository{pool: pool}
+}
+
+const activitySubscriptionColumns = `user_id, email, last_period, created_at`
+
+func scanActivitySubscription(row pgx.Row) (*models.ActivitySubscription, error) {
+	var sub models.ActivitySubscription
+	if err := row.Scan(&sub.UserID, &sub.Email, &sub.LastPeriod, &sub.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// Subscribe opts a user in, or changes the address of an existing subscription. lastPeriod is only
+// used for new subscriptions.
+func (r *ActivityRepository) Subscribe(ctx context.Context, userID uuid.UUID, email string, lastPeriod time.Time) (*models.ActivitySubscription, error) {
+	return scanActivitySubscription(r.pool.QueryRow(ctx, `
+		INSERT INTO activity_summary_subscriptions (user_id, email, last_period)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email, updated_at = NOW()
+		RETURNING `+activitySubscriptionColumns,
+		userID, email, lastPeriod))
+}
+
+// GetSubscription returns the user's subscription, or ErrActivitySubscriptionNotFound
+func (r *ActivityRepository) GetSubscription(ctx context.Context, userID uuid.UUID) (*models.ActivitySubscription, error) {
+	sub, err := scanActivitySubscription(r.pool.QueryRow(ctx, `
+		SELECT `+activitySubscriptionColumns+`
+		FROM activity_summary_subscriptions
+		WHERE user_id = $1
+	`, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrActivitySubscriptionNotFound
+	}
+	return sub, err
+}
+
+// Unsubscribe opts a user out
+func (r *ActivityRepository) Unsubscribe(ctx context.Context, userID uuid.UUID) error {
+	result, err := r.pool.Exec(ctx, `DELETE FROM activity_summary_subscriptions WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrActivitySubscriptionNotFound
+	}
+	return nil
+}
+
+// ListDue returns up to limit subscriptions that haven't been sent the summary for period yet
+func (r *ActivityRepository) ListDue(ctx context.Context, period time.Time, limit int) ([]models.ActivitySubscription, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+activitySubscriptionColumns+`
+		FROM activity_summary_subscriptions
+		WHERE last_period IS NULL OR last_period < $1
+		ORDER BY user_id
+		LIMIT $2
+	`, period, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []models.ActivitySubscription
+	for rows.Next() {
+		sub, err := scanActivitySubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, *sub)
+	}
+	return subs, rows.Err()
+}
+
+// MarkSent records that the summary for period was sent
+func (r *ActivityRepository) MarkSent(ctx context.Context, userID uuid.UUID, period time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE activity_summary_subscriptions SET last_period = $2, updated_at = NOW()
+		WHERE user_id = $1
+	`, userID, period)
+	return err
+}
+
+// Stats aggregates the user's activity in [start, end). Checklist items count as completed in the
+// period if they are completed now and were last changed in it. Deleted notes are left out.
+func (r *ActivityRepository) Stats(ctx context.Context, userID uuid.UUID, start, end time.Time) (*models.ActivityStats, error) {
+	stats := &models.ActivityStats{PeriodStart: start, PeriodEnd: end}
+
+	err := r.pool.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM notes
+				WHERE user_id = $1 AND deleted_at IS NULL AND created_at >= $2 AND created_at < $3),
+			(SELECT COUNT(*) FROM checklist_items ci
+				JOIN notes n ON n.id = ci.note_id
+				WHERE n.user_id = $1 AND n.deleted_at IS NULL AND ci.is_completed
+					AND ci.updated_at >= $2 AND ci.updated_at < $3)
+	`, userID, start, end).Scan(&stats.NotesCreated, &stats.ChecklistItemsCompleted)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT n.id, n.title, COUNT(*) AS edits
+		FROM note_revisions r
+		JOIN notes n ON n.id = r.note_id
+		WHERE r.user_id = $1 AND n.deleted_at IS NULL AND r.recorded_at >= $2 AND r.recorded_at < $3
+		GROUP BY n.id, n.title
+		ORDER BY edits DESC, n.id
+		LIMIT $4
+	`, userID, start, end, models.ActivitySummaryTopNotes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var note models.EditedNote
+		if err := rows.Scan(&note.NoteID, &note.Title, &note.Edits); err != nil {
+			return nil, err
+		}
+		stats.MostEdited = append(stats.MostEdited, note)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrArchiveExportNotFound = errors.New("archive export not found")
+	ErrArchiveExportConflict = errors.New("another archive export was made at the same time")
+)
+
+// ArchiveRepository records archive exports. Records are only ever inserted.
+type ArchiveRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewArchiveRepository(pool *pgxpool.Pool) *ArchiveRepository {
+	return &ArchiveRepository{pool: pool}
+}
+
+const archiveExportColumns = `id, user_id, prev_hash, head_hash, entry_count, created_at`
+
+func scanArchiveExport(row pgx.Row) (*models.ArchiveExport, error) {
+	var export models.ArchiveExport
+	if err := row.Scan(&export.ID, &export.UserID, &export.PrevHash, &export.HeadHash, &export.EntryCount, &export.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// Latest returns the user's most recent export, or ErrArchiveExportNotFound if there are none
+func (r *ArchiveRepository) Latest(ctx context.Context, userID uuid.UUID) (*models.ArchiveExport, error) {
+	export, err := scanArchiveExport(r.pool.QueryRow(ctx, `
+		SELECT `+archiveExportColumns+`
+		FROM archive_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrArchiveExportNotFound
+	}
+	return export, err
+}
+
+// GetByID returns one of the user's exports
+func (r *ArchiveRepository) GetByID(ctx context.Context, id, userID uuid.UUID) (*models.ArchiveExport, error) {
+	export, err := scanArchiveExport(r.pool.QueryRow(ctx, `
+		SELECT `+archiveExportColumns+`
+		FROM archive_exports
+		WHERE id = $1 AND user_id = $2
+	`, id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrArchiveExportNotFound
+	}
+	return export, err
+}
+
+// List returns the user's exports, newest first
+func (r *ArchiveRepository) List(ctx context.Context, userID uuid.UUID) ([]models.ArchiveExport, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+archiveExportColumns+`
+		FROM archive_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []models.ArchiveExport
+	for rows.Next() {
+		export, err := scanArchiveExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, *export)
+	}
+
+	return exports, rows.Err()
+}
+
+// Create records an export. Each export must follow on from a different one, so if another export
+// was chained onto the same previous export first this returns ErrArchiveExportConflict.
+func (r *ArchiveRepository) Create(ctx context.Context, export *models.ArchiveExport) error {
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO archive_exports (id, user_id, prev_hash, head_hash, entry_count, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, prev_hash) DO NOTHING
+		RETURNING id
+	`, export.ID, export.UserID, export.PrevHash, export.HeadHash, export.EntryCount, export.CreatedAt).Scan(&export.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrArchiveExportConflict
+	}
+	return err
+}
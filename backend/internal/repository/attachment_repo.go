@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+const attachmentColumns = `id, note_id, user_id, filename, content_type, format, size_bytes, duration_ms, created_at`
+
+type AttachmentRepository struct {
+	db DBTX
+}
+
+func NewAttachmentRepository(pool *pgxpool.Pool) *AttachmentRepository {
+	return &AttachmentRepository{db: pool}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *AttachmentRepository) WithTx(tx pgx.Tx) *AttachmentRepository {
+	return &AttachmentRepository{db: tx}
+}
+
+func (r *AttachmentRepository) Create(ctx context.Context, a *models.Attachment) error {
+	query := `
+		INSERT INTO attachments (` + attachmentColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	_, err := r.db.Exec(ctx, query,
+		a.ID,
+		a.NoteID,
+		a.UserID,
+		a.Filename,
+		a.ContentType,
+		a.Format,
+		a.SizeBytes,
+		a.DurationMs,
+		a.CreatedAt,
+	)
+	return err
+}
+
+func (r *AttachmentRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE id = $1`
+
+	a := &models.Attachment{}
+	if err := scanAttachment(r.db.QueryRow(ctx, query, id), a); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetByNoteID returns a note's attachments, oldest first
+func (r *AttachmentRepository) GetByNoteID(ctx context.Context, noteID uuid.UUID) ([]models.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE note_id = $1 ORDER BY created_at ASC`
+
+	rows, err := r.db.Query(ctx, query, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []models.Attachment
+	for rows.Next() {
+		var a models.Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+
+	return attachments, nil
+}
+
+func (r *AttachmentRepository) Delete(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `DELETE FROM attachments WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrAttachmentNotFound
+	}
+	return nil
+}
+
+func scanAttachment(row pgx.Row, a *models.Attachment) error {
+	return row.Scan(
+		&a.ID,
+		&a.NoteID,
+		&a.UserID,
+		&a.Filename,
+		&a.ContentType,
+		&a.Format,
+		&a.SizeBytes,
+		&a.DurationMs,
+		&a.CreatedAt,
+	)
+}
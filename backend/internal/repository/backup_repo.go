@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ownedNotes limits a table with a note_id to the user's own notes
+const ownedNotes = `note_id IN (SELECT id FROM notes WHERE user_id = $1)`
+
+// BackupTable is a table included in backups
+type BackupTable struct {
+	Name string
+
+	// UserFilter selects one user's rows, given their ID as $1. Tables without one hold data shared
+	// between users and are only in whole-instance backups.
+	UserFilter string
+
+	// OrderBy orders the rows where their order matters on restore
+	OrderBy string
+
+	// Renumbered is a serial column left out when restoring one user, so their rows take fresh
+	// values rather than clashing with other users'
+	Renumbered string
+
+	// Owner is the column naming the user a row belongs to, for tables whose rows are updated in
+	// place when one user is restored. Deleting them would take rows other users own, or that aren't
+	// backed up, with them through the foreign keys.
+	Owner string
+}
+
+// BackupTables are the tables backed up, in an order rows can be restored in without breaking
+// foreign keys. Sign-ins, tokens, idempotency keys and other short-lived state are left out.
+var BackupTables = []BackupTable{
+	{Name: "instance_info"},
+	{Name: "users", UserFilter: `id = $1`, Owner: "id"},
+	{Name: "notes", UserFilter: `user_id = $1`, Owner: "user_id"},
+	{Name: "checklist_items", UserFilter: ownedNotes},
+	// Revisions by collaborators belong with the collaborator, who may not exist where one user is restored
+	{Name: "note_revisions", UserFilter: `user_id = $1 AND ` + ownedNotes},
+	{Name: "note_ops", UserFilter: ownedNotes, OrderBy: "seq", Renumbered: "seq"},
+	{Name: "attachments", UserFilter: `user_id = $1 AND ` + ownedNotes},
+	{Name: "link_previews", UserFilter: ownedNotes},
+	{Name: "note_shares"},
+	{Name: "note_invites"},
+	{Name: "note_mentions"},
+	{Name: "notifications"},
+	{Name: "note_orderings", UserFilter: `user_id = $1 AND ` + ownedNotes},
+	{Name: "note_positions", UserFilter: `user_id = $1 AND ` + ownedNotes},
+	{Name: "cold_notes", UserFilter: `user_id = $1`},
+	{Name: "sync_batches", UserFilter: `user_id = $1`},
+	{Name: "sync_batch_notes", UserFilter: `batch_id IN (SELECT id FROM sync_batches WHERE user_id = $1)`},
+	{Name: "devices", UserFilter: `user_id = $1`},
+	{Name: "user_settings", UserFilter: `user_id = $1`},
+	{Name: "activity_summary_subscriptions", UserFilter: `user_id = $1`},
+	{Name: "archive_exports", UserFilter: `user_id = $1`},
+	{Name: "export_jobs", UserFilter: `user_id = $1`},
+	{Name: "integrity_reports"},
+	{Name: "webauthn_credentials", UserFilter: `user_id = $1`},
+	{Name: "user_identities", UserFilter: `user_id = $1`},
+}
+
+// BackupRepository reads and writes the backed up tables row by row, as JSON objects keyed by
+// column name, so backups don't depend on pg_dump or the exact column order.
+type BackupRepository struct {
+	db DBTX
+}
+
+func NewBackupRepository(pool *pgxpool.Pool) *BackupRepository {
+	return &BackupRepository{db: pool}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *BackupRepository) WithTx(tx pgx.Tx) *BackupRepository {
+	return &BackupRepository{db: tx}
+}
+
+// Begin starts a transaction for use with WithTx
+func (r *BackupRepository) Begin(ctx context.Context) (pgx.Tx, error) {
+	return r.db.Begin(ctx)
+}
+
+// Snapshot runs fn with a copy of the repository that reads from one consistent snapshot of the
+// database, so a backup taken while users write still restores cleanly
+func (r *BackupRepository) Snapshot(ctx context.Context, fn func(*BackupRepository) error) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY`); err != nil {
+		return err
+	}
+	return fn(r.WithTx(tx))
+}
+
+// EachRow calls fn with every row of table as a JSON object, or only the user's rows if userID isn't
+// uuid.Nil
+func (r *BackupRepository) EachRow(ctx context.Context, table BackupTable, userID uuid.UUID, fn func(row json.RawMessage) error) error {
+	query := `SELECT row_to_json(t)::text FROM ` + pgx.Identifier{table.Name}.Sanitize() + ` t`
+	var args []any
+	if userID != uuid.Nil {
+		query += ` WHERE ` + table.UserFilter
+		args = append(args, userID)
+	}
+	if table.OrderBy != "" {
+		query += ` ORDER BY ` + table.OrderBy
+	}
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			return err
+		}
+		if err := fn(json.RawMessage(row)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// UserExists reports whether the user exists
+func (r *BackupRepository) UserExists(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists)
+	return exists, err
+}
+
+// DeleteAll deletes every user and, through the foreign keys, everything of theirs, along with the
+// instance's settings and integrity reports. Deleting rather than truncating leaves the tables
+// readable until the caller's transaction commits.
+func (r *BackupRepository) DeleteAll(ctx context.Context) error {
+	for _, table := range []string{"users", "instance_info", "integrity_reports"} {
+		if _, err := r.db.Exec(ctx, `DELETE FROM `+table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUserRows deletes the user's rows from the tables given, last first, other than those of
+// tables whose rows are updated in place. Rows of tables that aren't backed up are left alone.
+func (r *BackupRepository) DeleteUserRows(ctx context.Context, tables []BackupTable, userID uuid.UUID) error {
+	for i := len(tables) - 1; i >= 0; i-- {
+		table := tables[i]
+		if table.Owner != "" {
+			continue
+		}
+		if _, err := r.db.Exec(ctx, `DELETE FROM `+pgx.Identifier{table.Name}.Sanitize()+` WHERE `+table.UserFilter, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteNotesExcept deletes the user's notes other than those given, with everything on them
+func (r *BackupRepository) DeleteNotesExcept(ctx context.Context, userID uuid.UUID, keep []uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM notes WHERE user_id = $1 AND NOT (id = ANY($2))`, userID, keep)
+	return err
+}
+
+// Columns returns the names of the table's columns
+func (r *BackupRepository) Columns(ctx context.Context, table string) (map[string]bool, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// BackupRow is a row to restore into Table, setting only the columns named. Columns left out take
+// their defaults.
+type BackupRow struct {
+	Table   string
+	Columns []string
+	Row     json.RawMessage
+
+	// Owner, if set, updates the row with the same ID in place, provided this column matches
+	Owner string
+}
+
+// ErrBackupRowNotOwned is returned, wrapped in a BackupRowError, for a row that would update one
+// belonging to another user
+var ErrBackupRowNotOwned = errors.New("the row with this ID belongs to another user")
+
+// InsertRows restores rows in one round trip. An error names the row that failed by its index.
+func (r *BackupRepository) InsertRows(ctx context.Context, rows []BackupRow) error {
+	batch := &pgx.Batch{}
+	for _, row := range rows {
+		table := pgx.Identifier{row.Table}.Sanitize()
+		columns := make([]string, len(row.Columns))
+		for i, column := range row.Columns {
+			columns[i] = pgx.Identifier{column}.Sanitize()
+		}
+		list := strings.Join(columns, ", ")
+		query := `INSERT INTO ` + table + ` (` + list + `) SELECT ` + list + ` FROM json_populate_record(NULL::` + table + `, $1::json)`
+		if row.Owner != "" {
+			updates := make([]string, len(columns))
+			for i, column := range columns {
+				updates[i] = column + ` = EXCLUDED.` + column
+			}
+			owner := pgx.Identifier{row.Owner}.Sanitize()
+			query += ` ON CONFLICT (id) DO UPDATE SET ` + strings.Join(updates, ", ") +
+				` WHERE ` + table + `.` + owner + ` = EXCLUDED.` + owner
+		}
+		batch.Queue(query, string(row.Row))
+	}
+
+	results := r.db.SendBatch(ctx, batch)
+	defer results.Close()
+	for i, row := range rows {
+		tag, err := results.Exec()
+		if err != nil {
+			return &BackupRowError{Index: i, Err: err}
+		}
+		if row.Owner != "" && tag.RowsAffected() == 0 {
+			return &BackupRowError{Index: i, Err: ErrBackupRowNotOwned}
+		}
+	}
+	return results.Close()
+}
+
+// BackupRowError is an InsertRows failure, naming the row of the batch that failed
+type BackupRowError struct {
+	Index int
+	Err   error
+}
+
+func (e *BackupRowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Index, e.Err)
+}
+
+func (e *BackupRowError) Unwrap() error {
+	return e.Err
+}
+
+// ResetSequences moves serial columns past the highest restored value, so new rows don't clash
+func (r *BackupRepository) ResetSequences(ctx context.Context) error {
+	_, err := r.db.Exec(ctx, `SELECT setval(pg_get_serial_sequence('note_ops', 'seq'), COALESCE(MAX(seq), 0) + 1, false) FROM note_ops`)
+	return err
+}
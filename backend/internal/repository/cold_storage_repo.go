@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ColdStorageRepository moves long-archived notes out of the notes table into cold_notes,
+// where each note (with its checklist items) is kept as a JSON snapshot
+type ColdStorageRepository struct {
+	pool     *pgxpool.Pool
+	noteRepo *NoteRepository
+}
+
+func NewColdStorageRepository(pool *pgxpool.Pool, noteRepo *NoteRepository) *ColdStorageRepository {
+	return &ColdStorageRepository{pool: pool, noteRepo: noteRepo}
+}
+
+// GetMovable returns the IDs and owners of up to limit archived notes untouched since before.
+// Notes with attachments or collaborators stay in the notes table, since those rows reference them.
+func (r *ColdStorageRepository) GetMovable(ctx context.Context, before time.Time, limit int) ([]models.Note, error) {
+	query := `
+		SELECT n.id, n.user_id FROM notes n
+		WHERE n.is_archived = true AND n.deleted_at IS NULL AND n.updated_at < $1
+			AND NOT EXISTS (SELECT 1 FROM attachments a WHERE a.note_id = n.id)
+			AND NOT EXISTS (SELECT 1 FROM note_shares s WHERE s.note_id = n.id)
+		ORDER BY n.updated_at ASC
+		LIMIT $2
+	`
+
+	rows, err := r.pool.Query(ctx, query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		var note models.Note
+		if err := rows.Scan(&note.ID, &note.UserID); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+
+	return notes, rows.Err()
+}
+
+// Move copies a note into cold storage and removes it from the notes table.
+// Returns false without moving it if the note changed or was unarchived in the meantime.
+func (r *ColdStorageRepository) Move(ctx context.Context, id, userID uuid.UUID) (bool, error) {
+	note, err := r.noteRepo.GetByID(ctx, id, userID)
+	if err != nil {
+		if errors.Is(err, ErrNoteNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !note.IsArchived {
+		return false, nil
+	}
+
+	// Previews are refetched and attachments never move, so neither is kept in the snapshot
+	note.LinkPreviews = nil
+	note.Attachments = nil
+	data, err := json.Marshal(note)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO cold_notes (id, user_id, snapshot, archived_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET snapshot = EXCLUDED.snapshot, archived_at = EXCLUDED.archived_at, moved_at = NOW()
+	`, note.ID, note.UserID, data, note.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	// Only delete the version that was copied
+	result, err := tx.Exec(ctx, `
+		DELETE FROM notes
+		WHERE id = $1 AND user_id = $2 AND updated_at = $3 AND is_archived = true AND deleted_at IS NULL
+	`, note.ID, note.UserID, note.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+	if result.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// List returns a page of the user's cold-stored notes, most recently archived first
+func (r *ColdStorageRepository) List(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.Note, error) {
+	query := `
+		SELECT snapshot FROM cold_notes
+		WHERE user_id = $1
+		ORDER BY archived_at DESC, id ASC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.pool.Query(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		var snapshot []byte
+		if err := rows.Scan(&snapshot); err != nil {
+			return nil, err
+		}
+		var note models.Note
+		if err := json.Unmarshal(snapshot, &note); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+
+	return notes, rows.Err()
+}
+
+// GetByID returns one of the user's cold-stored notes
+func (r *ColdStorageRepository) GetByID(ctx context.Context, id, userID uuid.UUID) (*models.Note, error) {
+	var snapshot []byte
+	err := r.pool.QueryRow(ctx, `SELECT snapshot FROM cold_notes WHERE id = $1 AND user_id = $2`, id, userID).Scan(&snapshot)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoteNotFound
+		}
+		return nil, err
+	}
+
+	var note models.Note
+	if err := json.Unmarshal(snapshot, &note); err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
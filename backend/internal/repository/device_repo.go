@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrDeviceNotFound = errors.New("device not found")
+	ErrDeviceRevoked  = errors.New("device has been revoked")
+)
+
+type DeviceRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewDeviceRepository(pool *pgxpool.Pool) *DeviceRepository {
+	return &DeviceRepository{pool: pool}
+}
+
+const deviceColumns = `id, user_id, device_id, name, platform, last_sync_at, last_seen_at, created_at, revoked_at`
+
+func scanDevice(row pgx.Row) (*models.Device, error) {
+	var device models.Device
+	err := row.Scan(
+		&device.ID,
+		&device.UserID,
+		&device.DeviceID,
+		&device.Name,
+		&device.Platform,
+		&device.LastSyncAt,
+		&device.LastSeenAt,
+		&device.CreatedAt,
+		&device.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// Register creates a device, or updates the name and platform of an existing one. Returns
+// ErrDeviceRevoked if the device was revoked.
+func (r *DeviceRepository) Register(ctx context.Context, device *models.Device) (*models.Device, error) {
+	row := r.pool.QueryRow(ctx, `
+		INSERT INTO devices (user_id, device_id, name, platform)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, device_id) DO UPDATE
+			SET name = EXCLUDED.name, platform = EXCLUDED.platform, last_seen_at = NOW()
+			WHERE devices.revoked_at IS NULL
+		RETURNING `+deviceColumns,
+		device.UserID, device.DeviceID, device.Name, device.Platform)
+
+	registered, err := scanDevice(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The conflicting row was revoked, so the update was skipped
+		return nil, ErrDeviceRevoked
+	}
+	return registered, err
+}
+
+// ListByUser returns the user's devices, most recently seen first
+func (r *DeviceRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.Device, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+deviceColumns+`
+		FROM devices
+		WHERE user_id = $1
+		ORDER BY last_seen_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []models.Device
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, *device)
+	}
+
+	return devices, rows.Err()
+}
+
+// GetByDeviceID returns a device by its client-chosen ID
+func (r *DeviceRepository) GetByDeviceID(ctx context.Context, userID uuid.UUID, deviceID string) (*models.Device, error) {
+	device, err := scanDevice(r.pool.QueryRow(ctx, `
+		SELECT `+deviceColumns+`
+		FROM devices
+		WHERE user_id = $1 AND device_id = $2
+	`, userID, deviceID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDeviceNotFound
+	}
+	return device, err
+}
+
+// RecordSync stores the cursor a device has synced to
+func (r *DeviceRepository) RecordSync(ctx context.Context, userID uuid.UUID, deviceID string, cursor time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE devices SET last_sync_at = $3, last_seen_at = NOW()
+		WHERE user_id = $1 AND device_id = $2 AND revoked_at IS NULL
+	`, userID, deviceID, cursor)
+	return err
+}
+
+// Revoke marks a device as revoked so it can no longer sync
+func (r *DeviceRepository) Revoke(ctx context.Context, id, userID uuid.UUID) (*models.Device, error) {
+	device, err := scanDevice(r.pool.QueryRow(ctx, `
+		UPDATE devices SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1 AND user_id = $2
+		RETURNING `+deviceColumns,
+		id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDeviceNotFound
+	}
+	return device, err
+}
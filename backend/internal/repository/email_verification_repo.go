@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrVerificationTokenNotFound = errors.New("verification token not found, used or expired")
+
+// EmailVerificationRepository stores the tokens sent to confirm email addresses
+type EmailVerificationRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewEmailVerificationRepository(pool *pgxpool.Pool) *EmailVerificationRepository {
+	return &EmailVerificationRepository{pool: pool}
+}
+
+// Create records a newly issued token
+func (r *EmailVerificationRepository) Create(ctx context.Context, token *models.EmailVerificationToken) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO email_verification_tokens (id, user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, token.ID, token.UserID, token.Email, token.TokenHash, token.ExpiresAt)
+	return err
+}
+
+// Use marks the token with the given hash as used and returns it. Each token can only be used once.
+func (r *EmailVerificationRepository) Use(ctx context.Context, tokenHash string) (*models.EmailVerificationToken, error) {
+	var token models.EmailVerificationToken
+	err := r.pool.QueryRow(ctx, `
+		UPDATE email_verification_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING id, user_id, email, token_hash, expires_at, used_at, created_at
+	`, tokenHash).Scan(&token.ID, &token.UserID, &token.Email, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrVerificationTokenNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DeleteExpired removes tokens that were used or can no longer be
+func (r *EmailVerificationRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := r.pool.Exec(ctx, `DELETE FROM email_verification_tokens WHERE expires_at < NOW() OR used_at IS NOT NULL`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
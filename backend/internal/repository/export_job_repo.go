@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrExportJobNotFound = errors.New("export job not found")
+
+// ExportJobRepository stores batch export jobs
+type ExportJobRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewExportJobRepository(pool *pgxpool.Pool) *ExportJobRepository {
+	return &ExportJobRepository{pool: pool}
+}
+
+const exportJobColumns = `id, user_id, status, format, filter, paper, include_metadata, filename, size_bytes, note_count, error, created_at, completed_at`
+
+func scanExportJob(row pgx.Row) (*models.ExportJob, error) {
+	var job models.ExportJob
+	var filter []byte
+	err := row.Scan(&job.ID, &job.UserID, &job.Status, &job.Format, &filter, &job.Paper, &job.IncludeMetadata,
+		&job.Filename, &job.SizeBytes, &job.NoteCount, &job.Error, &job.CreatedAt, &job.CompletedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrExportJobNotFound
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(filter, &job.Filter); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Create records a new job
+func (r *ExportJobRepository) Create(ctx context.Context, job *models.ExportJob) error {
+	filter, err := json.Marshal(job.Filter)
+	if err != nil {
+		return err
+	}
+	_, err = r.pool.Exec(ctx, `
+		INSERT INTO export_jobs (id, user_id, status, format, filter, paper, include_metadata, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, job.ID, job.UserID, job.Status, job.Format, filter, job.Paper, job.IncludeMetadata, job.CreatedAt)
+	return err
+}
+
+// GetByID returns one of the user's jobs
+func (r *ExportJobRepository) GetByID(ctx context.Context, id, userID uuid.UUID) (*models.ExportJob, error) {
+	return scanExportJob(r.pool.QueryRow(ctx, `
+		SELECT `+exportJobColumns+`
+		FROM export_jobs WHERE id = $1 AND user_id = $2
+	`, id, userID))
+}
+
+// List returns the user's jobs, newest first
+func (r *ExportJobRepository) List(ctx context.Context, userID uuid.UUID) ([]models.ExportJob, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+exportJobColumns+`
+		FROM export_jobs WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.ExportJob{}
+	for rows.Next() {
+		job, err := scanExportJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// SetStatus moves a job to a new status
+func (r *ExportJobRepository) SetStatus(ctx context.Context, id uuid.UUID, status models.ExportJobStatus) error {
+	_, err := r.pool.Exec(ctx, `UPDATE export_jobs SET status = $1 WHERE id = $2`, status, id)
+	return err
+}
+
+// Complete records a job's finished file
+func (r *ExportJobRepository) Complete(ctx context.Context, job *models.ExportJob) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE export_jobs SET status = $1, filename = $2, size_bytes = $3, note_count = $4, completed_at = $5
+		WHERE id = $6
+	`, models.ExportJobCompleted, job.Filename, job.SizeBytes, job.NoteCount, job.CompletedAt, job.ID)
+	return err
+}
+
+// Fail records why a job failed
+func (r *ExportJobRepository) Fail(ctx context.Context, id uuid.UUID, message string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE export_jobs SET status = $1, error = $2, completed_at = NOW()
+		WHERE id = $3
+	`, models.ExportJobFailed, message, id)
+	return err
+}
+
+// DeleteOlderThan removes jobs created before the cutoff and returns their IDs, so their files can
+// be removed too
+func (r *ExportJobRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `DELETE FROM export_jobs WHERE created_at < $1 RETURNING id`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// FailStale fails jobs still pending or running that were created before the cutoff, which were
+// interrupted, e.g. by a restart
+func (r *ExportJobRepository) FailStale(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE export_jobs SET status = $1, error = 'export was interrupted; start it again', completed_at = NOW()
+		WHERE status IN ($2, $3) AND created_at < $4
+	`, models.ExportJobFailed, models.ExportJobPending, models.ExportJobRunning, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type IdempotencyRepository struct {
+	db DBTX
+}
+
+func NewIdempotencyRepository(pool *pgxpool.Pool) *IdempotencyRepository {
+	return &IdempotencyRepository{db: pool}
+}
+
+// Reserve claims a key for a request that is about to run. If the key was already used it returns the
+// existing record instead (whose StatusCode is nil if that request hasn't finished).
+func (r *IdempotencyRepository) Reserve(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	tag, err := r.db.Exec(ctx, `
+		INSERT INTO idempotency_keys (user_id, key, route, request_hash)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO NOTHING
+	`, record.UserID, record.Key, record.Route, record.RequestHash)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	var existing models.IdempotencyRecord
+	err = r.db.QueryRow(ctx, `
+		SELECT user_id, key, route, request_hash, status_code, content_type, response, created_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+	`, record.UserID, record.Key).Scan(
+		&existing.UserID,
+		&existing.Key,
+		&existing.Route,
+		&existing.RequestHash,
+		&existing.StatusCode,
+		&existing.ContentType,
+		&existing.Response,
+		&existing.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// Complete stores the response to a reserved key
+func (r *IdempotencyRepository) Complete(ctx context.Context, userID uuid.UUID, key string, statusCode int, contentType string, response []byte) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE idempotency_keys SET status_code = $3, content_type = $4, response = $5
+		WHERE user_id = $1 AND key = $2
+	`, userID, key, statusCode, contentType, response)
+	return err
+}
+
+// Release frees a reserved key so the request can be retried, after it failed without a usable response
+func (r *IdempotencyRepository) Release(ctx context.Context, userID uuid.UUID, key string) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status_code IS NULL
+	`, userID, key)
+	return err
+}
+
+// DeleteOlderThan removes keys created before cutoff
+func (r *IdempotencyRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
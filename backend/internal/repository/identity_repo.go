@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrIdentityNotFound   = errors.New("linked account not found")
+	ErrIdentityExists     = errors.New("provider account already linked")
+	ErrOAuthStateNotFound = errors.New("sign-in state not found, used or expired")
+	ErrLoginCodeNotFound  = errors.New("login code not found, used or expired")
+)
+
+// IdentityRepository stores the provider accounts linked to users and the sign-ins with providers
+// in progress
+type IdentityRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewIdentityRepository(pool *pgxpool.Pool) *IdentityRepository {
+	return &IdentityRepository{pool: pool}
+}
+
+const identityColumns = `id, user_id, provider, subject, email, created_at, last_used_at`
+
+func scanIdentity(row pgx.Row) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	if err := row.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email,
+		&identity.CreatedAt, &identity.LastUsedAt); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// Create links a provider account to a user
+func (r *IdentityRepository) Create(ctx context.Context, identity *models.UserIdentity) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO user_identities (id, user_id, provider, subject, email, created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+	`, identity.ID, identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.CreatedAt)
+	if uniqueViolation(err, "user_identities_provider_subject_key") {
+		return ErrIdentityExists
+	}
+	return err
+}
+
+// Get returns the link for a provider account
+func (r *IdentityRepository) Get(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	identity, err := scanIdentity(r.pool.QueryRow(ctx, `
+		SELECT `+identityColumns+` FROM user_identities WHERE provider = $1 AND subject = $2
+	`, provider, subject))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIdentityNotFound
+	}
+	return identity, err
+}
+
+// ListByUser returns the provider accounts linked to a user, oldest first
+func (r *IdentityRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.UserIdentity, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+identityColumns+` FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []models.UserIdentity
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+	return identities, rows.Err()
+}
+
+// RecordUse notes a sign-in with a provider account, updating the email the provider reported
+func (r *IdentityRepository) RecordUse(ctx context.Context, id uuid.UUID, email string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE user_identities SET last_used_at = NOW(), email = CASE WHEN $2 = '' THEN email ELSE $2 END
+		WHERE id = $1
+	`, id, email)
+	return err
+}
+
+// Delete unlinks one of a user's provider accounts
+func (r *IdentityRepository) Delete(ctx context.Context, id, userID uuid.UUID) error {
+	result, err := r.pool.Exec(ctx, `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+// CreateState records a sign-in with a provider that has just started
+func (r *IdentityRepository) CreateState(ctx context.Context, state *models.OAuthState) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO oauth_states (state_hash, provider, nonce, code_verifier, user_id, return_to, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, state.StateHash, state.Provider, state.Nonce, state.CodeVerifier, state.UserID, state.ReturnTo, state.ExpiresAt)
+	return err
+}
+
+// UseState removes and returns an unexpired sign-in state, so each can only be completed once
+func (r *IdentityRepository) UseState(ctx context.Context, stateHash, provider string) (*models.OAuthState, error) {
+	var state models.OAuthState
+	err := r.pool.QueryRow(ctx, `
+		DELETE FROM oauth_states
+		WHERE state_hash = $1 AND provider = $2 AND expires_at > NOW()
+		RETURNING state_hash, provider, nonce, code_verifier, user_id, return_to, expires_at
+	`, stateHash, provider).Scan(&state.StateHash, &state.Provider, &state.Nonce, &state.CodeVerifier, &state.UserID, &state.ReturnTo, &state.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOAuthStateNotFound
+		}
+		return nil, err
+	}
+	return &state, nil
+}
+
+// CreateLoginCode records a code the app can exchange for a user's tokens
+func (r *IdentityRepository) CreateLoginCode(ctx context.Context, codeHash string, userID uuid.UUID, provider string, expiresAt time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO oauth_login_codes (code_hash, user_id, provider, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, codeHash, userID, provider, expiresAt)
+	return err
+}
+
+// UseLoginCode removes an unexpired login code and returns the user and provider it was issued for
+func (r *IdentityRepository) UseLoginCode(ctx context.Context, codeHash string) (uuid.UUID, string, error) {
+	var userID uuid.UUID
+	var provider string
+	err := r.pool.QueryRow(ctx, `
+		DELETE FROM oauth_login_codes WHERE code_hash = $1 AND expires_at > NOW()
+		RETURNING user_id, provider
+	`, codeHash).Scan(&userID, &provider)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, "", ErrLoginCodeNotFound
+	}
+	return userID, provider, err
+}
+
+// DeleteExpired removes sign-in states and login codes that were never used
+func (r *IdentityRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	states, err := r.pool.Exec(ctx, `DELETE FROM oauth_states WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	codes, err := r.pool.Exec(ctx, `DELETE FROM oauth_login_codes WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return states.RowsAffected() + codes.RowsAffected(), nil
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrSetupTokenInvalid = errors.New("setup token invalid or setup already completed")
+
+// InstanceRepository holds facts about this server instance as a whole
+type InstanceRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewInstanceRepository(pool *pgxpool.Pool) *InstanceRepository {
+	return &InstanceRepository{pool: pool}
+}
+
+// ID returns the instance's random ID, creating it on first use
+func (r *InstanceRepository) ID(ctx context.Context) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO instance_info DEFAULT VALUES
+		ON CONFLICT (singleton) DO UPDATE SET singleton = TRUE
+		RETURNING id
+	`).Scan(&id)
+	return id, err
+}
+
+// Usage returns the number of users and the database server's major version
+func (r *InstanceRepository) Usage(ctx context.Context) (users int, dbMajorVersion int, err error) {
+	err = r.pool.QueryRow(ctx, `
+		SELECT (SELECT COUNT(*) FROM users), current_setting('server_version_num')::int / 10000
+	`).Scan(&users, &dbMajorVersion)
+	return users, dbMajorVersion, err
+}
+
+// Settings returns the instance-wide settings
+func (r *InstanceRepository) Settings(ctx context.Context) (*models.InstanceSettings, error) {
+	var settings models.InstanceSettings
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO instance_info DEFAULT VALUES
+		ON CONFLICT (singleton) DO UPDATE SET singleton = TRUE
+		RETURNING name, registration_open, setup_completed_at
+	`).Scan(&settings.Name, &settings.RegistrationOpen, &settings.SetupCompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateSettings changes the instance-wide settings
+func (r *InstanceRepository) UpdateSettings(ctx context.Context, name string, registrationOpen bool) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO instance_info (name, registration_open) VALUES ($1, $2)
+		ON CONFLICT (singleton) DO UPDATE SET name = $1, registration_open = $2
+	`, name, registrationOpen)
+	return err
+}
+
+// Maintenance returns whether the instance is in maintenance mode
+func (r *InstanceRepository) Maintenance(ctx context.Context) (*models.Maintenance, error) {
+	var m models.Maintenance
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO instance_info DEFAULT VALUES
+		ON CONFLICT (singleton) DO UPDATE SET singleton = TRUE
+		RETURNING maintenance_enabled, maintenance_message, maintenance_until
+	`).Scan(&m.Enabled, &m.Message, &m.Until)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SetMaintenance turns maintenance mode on or off
+func (r *InstanceRepository) SetMaintenance(ctx context.Context, m *models.Maintenance) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO instance_info (maintenance_enabled, maintenance_message, maintenance_until) VALUES ($1, $2, $3)
+		ON CONFLICT (singleton) DO UPDATE SET maintenance_enabled = $1, maintenance_message = $2, maintenance_until = $3
+	`, m.Enabled, m.Message, m.Until)
+	return err
+}
+
+// SetupPending reports whether the instance still needs its first administrator: setup hasn't
+// been completed and no user is an administrator
+func (r *InstanceRepository) SetupPending(ctx context.Context) (bool, error) {
+	var pending bool
+	err := r.pool.QueryRow(ctx, `
+		SELECT NOT EXISTS (SELECT 1 FROM instance_info WHERE setup_completed_at IS NOT NULL)
+			AND NOT EXISTS (SELECT 1 FROM users WHERE is_admin)
+	`).Scan(&pending)
+	return pending, err
+}
+
+// SetSetupToken replaces the setup token, invalidating any issued before
+func (r *InstanceRepository) SetSetupToken(ctx context.Context, tokenHash string) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO instance_info (setup_token_hash) VALUES ($1)
+		ON CONFLICT (singleton) DO UPDATE SET setup_token_hash = $1
+	`, tokenHash)
+	return err
+}
+
+// MarkSetupCompleted records that setup is done without creating anyone, for instances that
+// already have an administrator
+func (r *InstanceRepository) MarkSetupCompleted(ctx context.Context) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO instance_info (setup_completed_at) VALUES (NOW())
+		ON CONFLICT (singleton) DO UPDATE SET setup_completed_at = COALESCE(instance_info.setup_completed_at, NOW()), setup_token_hash = NULL
+	`)
+	return err
+}
+
+// CompleteSetup uses up the setup token, creating the first administrator and saving the
+// instance's settings in one transaction. It fails with ErrSetupTokenInvalid if the token is wrong,
+// setup was already completed, or an administrator exists.
+func (r *InstanceRepository) CompleteSetup(ctx context.Context, tokenHash string, admin *models.User, settings *models.InstanceSettings) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE instance_info
+		SET setup_completed_at = NOW(), setup_token_hash = NULL, name = $2, registration_open = $3
+		WHERE setup_token_hash = $1 AND setup_completed_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM users WHERE is_admin)
+	`, tokenHash, settings.Name, settings.RegistrationOpen)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrSetupTokenInvalid
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO users (id, username, password_hash, password_algorithm, email, email_verified_at, is_admin, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, TRUE, $7, $8)
+	`, admin.ID, admin.Username, admin.PasswordHash, admin.PasswordAlgorithm, admin.Email, admin.VerifiedAt, admin.CreatedAt, admin.UpdatedAt)
+	if err != nil {
+		if uniqueViolation(err, "users_username_key") {
+			return ErrUserExists
+		}
+		if uniqueViolation(err, "idx_users_email") {
+			return ErrEmailExists
+		}
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// IntegrityRepository finds and removes records whose references are broken. The foreign keys
+// prevent most of these, but rows written before a constraint existed, restored from a partial
+// backup or changed by hand can still slip through.
+type IntegrityRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewIntegrityRepository(pool *pgxpool.Pool) *IntegrityRepository {
+	return &IntegrityRepository{pool: pool}
+}
+
+// OrphanedChecklistItems returns checklist items whose note doesn't exist
+func (r *IntegrityRepository) OrphanedChecklistItems(ctx context.Context) ([]models.IntegrityFinding, error) {
+	return r.findings(ctx, models.IntegrityOrphanedChecklistItem, `
+		SELECT c.id, 'note ' || c.note_id::text
+		FROM checklist_items c
+		LEFT JOIN notes n ON n.id = c.note_id
+		WHERE n.id IS NULL
+		ORDER BY c.id
+	`)
+}
+
+// NotesWithoutUsers returns notes whose owner doesn't exist
+func (r *IntegrityRepository) NotesWithoutUsers(ctx context.Context) ([]models.IntegrityFinding, error) {
+	return r.findings(ctx, models.IntegrityNoteWithoutUser, `
+		SELECT n.id, 'user ' || n.user_id::text
+		FROM notes n
+		LEFT JOIN users u ON u.id = n.user_id
+		WHERE u.id IS NULL
+		ORDER BY n.id
+	`)
+}
+
+// AttachmentIDs returns the ID of every attachment record, for checking against the file store
+func (r *IntegrityRepository) AttachmentIDs(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id FROM attachments ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *IntegrityRepository) findings(ctx context.Context, check, query string) ([]models.IntegrityFinding, error) {
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var findings []models.IntegrityFinding
+	for rows.Next() {
+		finding := models.IntegrityFinding{Check: check}
+		if err := rows.Scan(&finding.ID, &finding.Detail); err != nil {
+			return nil, err
+		}
+		findings = append(findings, finding)
+	}
+	return findings, rows.Err()
+}
+
+// DeleteChecklistItems removes checklist items
+func (r *IntegrityRepository) DeleteChecklistItems(ctx context.Context, ids []uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM checklist_items WHERE id = ANY($1)`, ids)
+	return err
+}
+
+// DeleteNotes removes notes outright, along with everything that belongs to them
+func (r *IntegrityRepository) DeleteNotes(ctx context.Context, ids []uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM notes WHERE id = ANY($1)`, ids)
+	return err
+}
+
+// DeleteAttachments removes attachment records
+func (r *IntegrityRepository) DeleteAttachments(ctx context.Context, ids []uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM attachments WHERE id = ANY($1)`, ids)
+	return err
+}
+
+// SaveReport records the result of a check
+func (r *IntegrityRepository) SaveReport(ctx context.Context, report *models.IntegrityReport) error {
+	counts, err := json.Marshal(report.Counts)
+	if err != nil {
+		return err
+	}
+	findings, err := json.Marshal(report.Findings)
+	if err != nil {
+		return err
+	}
+	_, err = r.pool.Exec(ctx, `
+		INSERT INTO integrity_reports (id, repair, counts, findings, started_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, report.ID, report.Repair, counts, findings, report.StartedAt, report.FinishedAt)
+	return err
+}
+
+// ListReports returns the most recent reports, newest first
+func (r *IntegrityRepository) ListReports(ctx context.Context, limit int) ([]models.IntegrityReport, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, repair, counts, findings, started_at, finished_at
+		FROM integrity_reports
+		ORDER BY started_at DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []models.IntegrityReport{}
+	for rows.Next() {
+		report, err := scanIntegrityReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	return reports, rows.Err()
+}
+
+func scanIntegrityReport(row pgx.Row) (*models.IntegrityReport, error) {
+	var report models.IntegrityReport
+	var counts, findings []byte
+	if err := row.Scan(&report.ID, &report.Repair, &counts, &findings, &report.StartedAt, &report.FinishedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(counts, &report.Counts); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(findings, &report.Findings); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// DeleteOldReports removes all but the newest keep reports
+func (r *IntegrityRepository) DeleteOldReports(ctx context.Context, keep int) error {
+	_, err := r.pool.Exec(ctx, `
+		DELETE FROM integrity_reports
+		WHERE id NOT IN (SELECT id FROM integrity_reports ORDER BY started_at DESC LIMIT $1)
+	`, keep)
+	return err
+}
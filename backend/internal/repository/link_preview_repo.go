@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type LinkPreviewRepository struct {
+	db DBTX
+}
+
+func NewLinkPreviewRepository(pool *pgxpool.Pool) *LinkPreviewRepository {
+	return &LinkPreviewRepository{db: pool}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *LinkPreviewRepository) WithTx(tx pgx.Tx) *LinkPreviewRepository {
+	return &LinkPreviewRepository{db: tx}
+}
+
+// Upsert stores or refreshes the preview for a note's URL
+func (r *LinkPreviewRepository) Upsert(ctx context.Context, preview *models.LinkPreview) error {
+	query := `
+		INSERT INTO link_previews (note_id, url, title, description, image_url, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (note_id, url) DO UPDATE SET
+			title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			image_url = EXCLUDED.image_url,
+			fetched_at = EXCLUDED.fetched_at
+	`
+	_, err := r.db.Exec(ctx, query,
+		preview.NoteID,
+		preview.URL,
+		preview.Title,
+		preview.Description,
+		preview.ImageURL,
+		preview.FetchedAt,
+	)
+	return err
+}
+
+// GetByNoteID returns all stored previews for a note
+func (r *LinkPreviewRepository) GetByNoteID(ctx context.Context, noteID uuid.UUID) ([]models.LinkPreview, error) {
+	query := `
+		SELECT note_id, url, title, description, image_url, fetched_at
+		FROM link_previews WHERE note_id = $1
+		ORDER BY url ASC
+	`
+
+	rows, err := r.db.Query(ctx, query, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var previews []models.LinkPreview
+	for rows.Next() {
+		var preview models.LinkPreview
+		err := rows.Scan(
+			&preview.NoteID,
+			&preview.URL,
+			&preview.Title,
+			&preview.Description,
+			&preview.ImageURL,
+			&preview.FetchedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		previews = append(previews, preview)
+	}
+
+	return previews, nil
+}
+
+// DeleteExcept removes previews for URLs that are no longer in the note's content
+func (r *LinkPreviewRepository) DeleteExcept(ctx context.Context, noteID uuid.UUID, urls []string) (int64, error) {
+	if urls == nil {
+		urls = []string{}
+	}
+	result, err := r.db.Exec(ctx, `DELETE FROM link_previews WHERE note_id = $1 AND NOT (url = ANY($2))`, noteID, urls)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
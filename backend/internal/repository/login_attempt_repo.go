@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrLockoutNotFound = errors.New("no lockout for that username")
+
+// LoginAttemptRepository counts failed password logins per username
+type LoginAttemptRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewLoginAttemptRepository(pool *pgxpool.Pool) *LoginAttemptRepository {
+	return &LoginAttemptRepository{pool: pool}
+}
+
+// LockedUntil returns when the username's lockout ends, or nil if it isn't locked
+func (r *LoginAttemptRepository) LockedUntil(ctx context.Context, username string) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := r.pool.QueryRow(ctx, `
+		SELECT locked_until FROM login_attempts WHERE username = $1 AND locked_until > NOW()
+	`, username).Scan(&lockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return lockedUntil, err
+}
+
+// RecordFailure counts a failed login and locks the username for however long lockout returns for
+// the failures counted so far. Failures longer ago than window no longer count. It returns the lockout's
+// end, or nil if the username isn't locked.
+func (r *LoginAttemptRepository) RecordFailure(ctx context.Context, username string, window time.Duration, lockout func(failures int) time.Duration) (*time.Time, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var failures int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO login_attempts (username, failed_attempts, last_failed_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (username) DO UPDATE SET
+			failed_attempts = CASE WHEN login_attempts.last_failed_at < $2 THEN 1 ELSE login_attempts.failed_attempts + 1 END,
+			last_failed_at = NOW()
+		RETURNING failed_attempts
+	`, username, time.Now().Add(-window)).Scan(&failures)
+	if err != nil {
+		return nil, err
+	}
+
+	var lockedUntil *time.Time
+	if d := lockout(failures); d > 0 {
+		until := time.Now().Add(d)
+		lockedUntil = &until
+		if _, err := tx.Exec(ctx, `UPDATE login_attempts SET locked_until = $2 WHERE username = $1`, username, until); err != nil {
+			return nil, err
+		}
+	}
+
+	return lockedUntil, tx.Commit(ctx)
+}
+
+// Reset clears a username's failed logins after a successful one
+func (r *LoginAttemptRepository) Reset(ctx context.Context, username string) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM login_attempts WHERE username = $1`, username)
+	return err
+}
+
+// ListLocked returns the usernames locked out now, the longest-locked first
+func (r *LoginAttemptRepository) ListLocked(ctx context.Context) ([]models.LoginAttempts, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT username, failed_attempts, last_failed_at, locked_until
+		FROM login_attempts
+		WHERE locked_until > NOW()
+		ORDER BY locked_until DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lockouts []models.LoginAttempts
+	for rows.Next() {
+		var attempts models.LoginAttempts
+		if err := rows.Scan(&attempts.Username, &attempts.FailedAttempts, &attempts.LastFailedAt, &attempts.LockedUntil); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, attempts)
+	}
+	return lockouts, rows.Err()
+}
+
+// Unlock ends a username's lockout and clears its failed logins
+func (r *LoginAttemptRepository) Unlock(ctx context.Context, username string) error {
+	result, err := r.pool.Exec(ctx, `DELETE FROM login_attempts WHERE username = $1 AND locked_until > NOW()`, username)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrLockoutNotFound
+	}
+	return nil
+}
+
+// DeleteStale removes the records of usernames that are no longer locked and whose last failure
+// was before the given time
+func (r *LoginAttemptRepository) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.pool.Exec(ctx, `
+		DELETE FROM login_attempts
+		WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < NOW())
+	`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
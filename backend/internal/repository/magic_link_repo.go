@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrMagicLinkNotFound = errors.New("magic link token not found, used or expired")
+
+// MagicLinkRepository stores the tokens emailed for signing in without a password
+type MagicLinkRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewMagicLinkRepository(pool *pgxpool.Pool) *MagicLinkRepository {
+	return &MagicLinkRepository{pool: pool}
+}
+
+// Create records a newly issued token
+func (r *MagicLinkRepository) Create(ctx context.Context, token *models.MagicLinkToken) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO magic_link_tokens (id, user_id, email, token_hash, requested_ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, token.ID, token.UserID, token.Email, token.TokenHash, token.RequestedIP, token.ExpiresAt)
+	return err
+}
+
+// Use marks the token with the given hash as used and returns it. Each token can only be used once.
+func (r *MagicLinkRepository) Use(ctx context.Context, tokenHash string) (*models.MagicLinkToken, error) {
+	var token models.MagicLinkToken
+	err := r.pool.QueryRow(ctx, `
+		UPDATE magic_link_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING id, user_id, email, token_hash, requested_ip, expires_at, used_at, created_at
+	`, tokenHash).Scan(&token.ID, &token.UserID, &token.Email, &token.TokenHash, &token.RequestedIP, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMagicLinkNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeAllForUser uses up the user's outstanding tokens, so only the newest link works
+func (r *MagicLinkRepository) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE magic_link_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL
+	`, userID)
+	return err
+}
+
+// DeleteExpired removes tokens that were used or can no longer be
+func (r *MagicLinkRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := r.pool.Exec(ctx, `DELETE FROM magic_link_tokens WHERE expires_at < NOW() OR used_at IS NOT NULL`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
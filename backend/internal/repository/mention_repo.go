@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type MentionRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewMentionRepository(pool *pgxpool.Pool) *MentionRepository {
+	return &MentionRepository{pool: pool}
+}
+
+// ReplaceMentions sets the users mentioned in a note, dropping mentions that were removed.
+// Returns the IDs of users who were not already mentioned.
+func (r *MentionRepository) ReplaceMentions(ctx context.Context, noteID, mentionedBy uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// A nil slice would be sent as NULL and match nothing
+	if userIDs == nil {
+		userIDs = []uuid.UUID{}
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM note_mentions
+		WHERE note_id = $1 AND NOT (user_id = ANY($2))
+	`, noteID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []uuid.UUID
+	for _, userID := range userIDs {
+		result, err := tx.Exec(ctx, `
+			INSERT INTO note_mentions (note_id, user_id, mentioned_by)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (note_id, user_id) DO NOTHING
+		`, noteID, userID, mentionedBy)
+		if err != nil {
+			return nil, err
+		}
+		if result.RowsAffected() > 0 {
+			added = append(added, userID)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/crdt"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type NoteOpRepository struct {
+	db DBTX
+}
+
+func NewNoteOpRepository(pool *pgxpool.Pool) *NoteOpRepository {
+	return &NoteOpRepository{db: pool}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *NoteOpRepository) WithTx(tx pgx.Tx) *NoteOpRepository {
+	return &NoteOpRepository{db: tx}
+}
+
+// Append stores ops for a note, skipping any already stored (same site and counter)
+func (r *NoteOpRepository) Append(ctx context.Context, noteID uuid.UUID, ops []crdt.Op) error {
+	batch := &pgx.Batch{}
+	for _, op := range ops {
+		batch.Queue(`
+			INSERT INTO note_ops (note_id, site_id, counter, op_type, ref, value)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (note_id, site_id, counter) DO NOTHING
+		`, noteID, op.ID.Site, op.ID.Counter, string(op.Type), op.Ref.String(), op.Value)
+	}
+	return r.db.SendBatch(ctx, batch).Close()
+}
+
+// GetByNoteID returns all of a note's ops in log order
+func (r *NoteOpRepository) GetByNoteID(ctx context.Context, noteID uuid.UUID) ([]models.NoteOp, error) {
+	return r.queryOps(ctx, `
+		SELECT seq, note_id, site_id, counter, op_type, ref, value, created_at
+		FROM note_ops WHERE note_id = $1
+		ORDER BY seq ASC
+	`, noteID)
+}
+
+// GetSince returns ops on the user's notes with a sequence number after seq, in log order
+func (r *NoteOpRepository) GetSince(ctx context.Context, userID uuid.UUID, seq int64) ([]models.NoteOp, error) {
+	return r.queryOps(ctx, `
+		SELECT o.seq, o.note_id, o.site_id, o.counter, o.op_type, o.ref, o.value, o.created_at
+		FROM note_ops o
+		JOIN notes n ON n.id = o.note_id
+		WHERE n.user_id = $1 AND n.deleted_at IS NULL AND o.seq > $2
+		ORDER BY o.seq ASC
+	`, userID, seq)
+}
+
+// HasOps reports whether a note is edited in CRDT mode
+func (r *NoteOpRepository) HasOps(ctx context.Context, noteID uuid.UUID) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM note_ops WHERE note_id = $1)`, noteID).Scan(&exists)
+	return exists, err
+}
+
+func (r *NoteOpRepository) queryOps(ctx context.Context, query string, args ...interface{}) ([]models.NoteOp, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []models.NoteOp
+	for rows.Next() {
+		var op models.NoteOp
+		var opType, ref string
+		if err := rows.Scan(&op.Seq, &op.NoteID, &op.Op.ID.Site, &op.Op.ID.Counter, &opType, &ref, &op.Op.Value, &op.CreatedAt); err != nil {
+			return nil, err
+		}
+		op.Op.Type = crdt.OpType(opType)
+		op.Op.Ref, err = crdt.ParseID(ref)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+
+	return ops, rows.Err()
+}
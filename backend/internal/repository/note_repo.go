@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,29 +12,81 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-var ErrNoteNotFound = errors.New("note not found")
+var (
+	ErrNoteNotFound = errors.New("note not found")
+
+	// ErrVersionConflict is returned by Update when the note was changed since the expected version
+	ErrVersionConflict = errors.New("note was changed by another request")
+)
+
+// AnyVersion, as the expected version, updates a note whatever its version. It's for writes that
+// settle conflicts another way, such as sync by hybrid logical clock, and for clients older than
+// the version check, whose saves win over whatever is there.
+const AnyVersion = 0
 
 type NoteRepository struct {
-	pool *pgxpool.Pool
+	db           DBTX
+	linkPreviews *LinkPreviewRepository
+	attachments  *AttachmentRepository
+
+	// Reads of a user's notes go to replicaReads while replica is usable (see WithReplica)
+	replica      ReadReplica
+	replicaReads *NoteRepository
 }
 
 func NewNoteRepository(pool *pgxpool.Pool) *NoteRepository {
-	return &NoteRepository{pool: pool}
+	return &NoteRepository{
+		db:           pool,
+		linkPreviews: NewLinkPreviewRepository(pool),
+		attachments:  NewAttachmentRepository(pool),
+	}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *NoteRepository) WithTx(tx pgx.Tx) *NoteRepository {
+	return &NoteRepository{
+		db:           tx,
+		linkPreviews: r.linkPreviews.WithTx(tx),
+		attachments:  r.attachments.WithTx(tx),
+	}
+}
+
+// Begin starts a transaction for use with WithTx
+func (r *NoteRepository) Begin(ctx context.Context) (pgx.Tx, error) {
+	return r.db.Begin(ctx)
 }
 
 func (r *NoteRepository) Create(ctx context.Context, note *models.Note) error {
-	tx, err := r.pool.Begin(ctx)
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
 
-	query := `
-		INSERT INTO notes (id, user_id, title, content, note_type, is_pinned, is_archived, sort_order, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-	`
+	batch := &pgx.Batch{}
+	if err := queueCreate(batch, note); err != nil {
+		return err
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
 
-	_, err = tx.Exec(ctx, query,
+	return tx.Commit(ctx)
+}
+
+// queueCreate adds the statements that insert a note, its checklist items and its first revision
+// to a batch. New notes start at version 1.
+func queueCreate(batch *pgx.Batch, note *models.Note) error {
+	note.Version = 1
+	note.Omitted = models.OmittedFields{} // There's nothing stored to keep
+
+	// A note recreated with the same ID (restored, or re-sent by a client) replaces its cold-stored copy
+	batch.Queue(`DELETE FROM cold_notes WHERE id = $1 AND user_id = $2`, note.ID, note.UserID)
+
+	batch.Queue(`
+		INSERT INTO notes (id, user_id, title, content, note_type, is_pinned, is_archived, sort_order, created_at, updated_at, metadata, language, is_monospace, hlc, version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	`,
 		note.ID,
 		note.UserID,
 		note.Title,
@@ -44,18 +97,67 @@ func (r *NoteRepository) Create(ctx context.Context, note *models.Note) error {
 		note.SortOrder,
 		note.CreatedAt,
 		note.UpdatedAt,
+		metadataOrEmpty(note.Metadata),
+		note.Language,
+		note.IsMonospace,
+		note.HLC,
+		note.Version,
+	)
+
+	queueChecklistItems(batch, note)
+	return queueRevision(batch, note)
+}
+
+// queueUpdate adds the statements that update a note at expectedVersion (or AnyVersion), replace its
+// checklist items and record a revision to a batch. The update comes first and returns the note's
+// new version, or no row if the note wasn't found at that version.
+func queueUpdate(batch *pgx.Batch, note *models.Note, expectedVersion int) error {
+	batch.Queue(`
+		UPDATE notes SET
+			title = $1,
+			content = $2,
+			note_type = $3,
+			is_pinned = $4,
+			is_archived = $5,
+			sort_order = $6,
+			updated_at = $7,
+			metadata = $8,
+			language = $9,
+			is_monospace = $10,
+			hlc = $11,
+			version = version + 1
+		WHERE id = $12 AND user_id = $13 AND deleted_at IS NULL AND ($14 = 0 OR version = $14)
+		RETURNING version
+	`,
+		note.Title,
+		note.Content,
+		note.NoteType,
+		note.IsPinned,
+		note.IsArchived,
+		note.SortOrder,
+		note.UpdatedAt,
+		metadataOrEmpty(note.Metadata),
+		note.Language,
+		note.IsMonospace,
+		note.HLC,
+		note.ID,
+		note.UserID,
+		expectedVersion,
 	)
-	if err != nil {
-		return err
-	}
 
-	// Insert checklist items if any
+	// Delete existing checklist items and re-insert
+	batch.Queue(`DELETE FROM checklist_items WHERE note_id = $1`, note.ID)
+	queueChecklistItems(batch, note)
+	return queueRevision(batch, note)
+}
+
+// queueChecklistItems adds the inserts for a note's checklist items to a batch
+func queueChecklistItems(batch *pgx.Batch, note *models.Note) {
 	for _, item := range note.ChecklistItems {
-		itemQuery := `
+		batch.Queue(`
 			INSERT INTO checklist_items (id, note_id, text, is_completed, sort_order, created_at, updated_at)
 			VALUES ($1, $2, $3, $4, $5, $6, $7)
-		`
-		_, err = tx.Exec(ctx, itemQuery,
+		`,
 			item.ID,
 			note.ID,
 			item.Text,
@@ -64,73 +166,106 @@ func (r *NoteRepository) Create(ctx context.Context, note *models.Note) error {
 			item.CreatedAt,
 			item.UpdatedAt,
 		)
-		if err != nil {
-			return err
-		}
 	}
-
-	return tx.Commit(ctx)
 }
 
 func (r *NoteRepository) GetByID(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*models.Note, error) {
+	var note *models.Note
+	err := r.read(ctx, func(r *NoteRepository) (err error) {
+		note, err = r.getByID(ctx, id, userID)
+		return err
+	})
+	return note, err
+}
+
+func (r *NoteRepository) getByID(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*models.Note, error) {
 	query := `
-		SELECT id, user_id, title, content, note_type, is_pinned, is_archived, sort_order, created_at, updated_at, deleted_at
+		SELECT ` + noteColumns + `
 		FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
 	`
 
 	note := &models.Note{}
-	err := r.pool.QueryRow(ctx, query, id, userID).Scan(
-		&note.ID,
-		&note.UserID,
-		&note.Title,
-		&note.Content,
-		&note.NoteType,
-		&note.IsPinned,
-		&note.IsArchived,
-		&note.SortOrder,
-		&note.CreatedAt,
-		&note.UpdatedAt,
-		&note.DeletedAt,
-	)
-
-	if err != nil {
+	if err := scanNote(r.db.QueryRow(ctx, query, id, userID), note); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNoteNotFound
 		}
 		return nil, err
 	}
 
-	// Fetch checklist items
-	items, err := r.getChecklistItems(ctx, note.ID)
-	if err != nil {
+	if err := r.loadChildren(ctx, note); err != nil {
 		return nil, err
 	}
-	note.ChecklistItems = items
 
 	return note, nil
 }
 
 func (r *NoteRepository) GetAllByUserID(ctx context.Context, userID uuid.UUID, since *time.Time) ([]models.Note, error) {
+	var notes []models.Note
+	err := r.read(ctx, func(r *NoteRepository) (err error) {
+		notes, err = r.getAllByUserID(ctx, userID, since)
+		return err
+	})
+	return notes, err
+}
+
+func (r *NoteRepository) getAllByUserID(ctx context.Context, userID uuid.UUID, since *time.Time) ([]models.Note, error) {
 	var query string
 	var args []interface{}
 
 	if since != nil {
 		query = `
-			SELECT id, user_id, title, content, note_type, is_pinned, is_archived, sort_order, created_at, updated_at, deleted_at
+			SELECT ` + noteColumns + `
 			FROM notes WHERE user_id = $1 AND deleted_at IS NULL AND updated_at > $2
 			ORDER BY sort_order ASC
 		`
 		args = []interface{}{userID, since}
 	} else {
 		query = `
-			SELECT id, user_id, title, content, note_type, is_pinned, is_archived, sort_order, created_at, updated_at, deleted_at
+			SELECT ` + noteColumns + `
 			FROM notes WHERE user_id = $1 AND deleted_at IS NULL
 			ORDER BY sort_order ASC
 		`
 		args = []interface{}{userID}
 	}
 
-	rows, err := r.pool.Query(ctx, query, args...)
+	return r.queryNotes(ctx, query, args...)
+}
+
+// noteSummariesQuery selects the user's notes changed since $2 (all if null) like GetAllByUserID,
+// followed by counts of each note's checklist items in place of the items themselves
+var noteSummariesQuery = `
+	SELECT ` + prefixedNoteColumns("n") + `, COUNT(ci.id), COUNT(ci.id) FILTER (WHERE ci.is_completed)
+	FROM notes n
+	LEFT JOIN checklist_items ci ON ci.note_id = n.id
+	WHERE n.user_id = $1 AND n.deleted_at IS NULL
+		AND ($2::timestamptz IS NULL OR n.updated_at > $2)
+	GROUP BY n.id
+	ORDER BY n.sort_order ASC
+`
+
+// scanNoteSummary scans a row of noteSummariesQuery
+func scanNoteSummary(row pgx.Row, note *models.Note) error {
+	var summary models.ChecklistSummary
+	if err := row.Scan(append(noteScanTargets(note), &summary.Total, &summary.Completed)...); err != nil {
+		return err
+	}
+	note.ChecklistSummary = &summary
+	return nil
+}
+
+// GetSummariesByUserID is GetAllByUserID for list screens: checklist items aren't loaded, and each
+// note's ChecklistSummary counts them instead
+func (r *NoteRepository) GetSummariesByUserID(ctx context.Context, userID uuid.UUID, since *time.Time) ([]models.Note, error) {
+	var notes []models.Note
+	err := r.read(ctx, func(r *NoteRepository) (err error) {
+		notes, err = r.getSummariesByUserID(ctx, userID, since)
+		return err
+	})
+	return notes, err
+}
+
+func (r *NoteRepository) getSummariesByUserID(ctx context.Context, userID uuid.UUID, since *time.Time) ([]models.Note, error) {
+	rows, err := r.db.Query(ctx, noteSummariesQuery, userID, since)
 	if err != nil {
 		return nil, err
 	}
@@ -139,110 +274,422 @@ func (r *NoteRepository) GetAllByUserID(ctx context.Context, userID uuid.UUID, s
 	var notes []models.Note
 	for rows.Next() {
 		var note models.Note
-		err := rows.Scan(
-			&note.ID,
-			&note.UserID,
-			&note.Title,
-			&note.Content,
-			&note.NoteType,
-			&note.IsPinned,
-			&note.IsArchived,
-			&note.SortOrder,
-			&note.CreatedAt,
-			&note.UpdatedAt,
-			&note.DeletedAt,
-		)
-		if err != nil {
+		if err := scanNoteSummary(rows, &note); err != nil {
 			return nil, err
 		}
 		notes = append(notes, note)
 	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	// Fetch checklist items for all notes
 	for i := range notes {
-		items, err := r.getChecklistItems(ctx, notes[i].ID)
-		if err != nil {
+		if err := r.loadPreviewsAndAttachments(ctx, &notes[i]); err != nil {
 			return nil, err
 		}
-		notes[i].ChecklistItems = items
 	}
 
 	return notes, nil
 }
 
-func (r *NoteRepository) Update(ctx context.Context, note *models.Note) error {
-	tx, err := r.pool.Begin(ctx)
+// EachByUserID calls fn with each of the user's notes changed since since (all notes if nil), in
+// list order, as they're read from the database rather than collecting them first, so very large
+// accounts can be streamed. With withItems false, notes come as from GetSummariesByUserID. An error
+// from fn stops the iteration and is returned. Each note's children are fetched while the cursor is
+// still open, which needs a second connection, so this can't run on a repository bound to a
+// transaction.
+func (r *NoteRepository) EachByUserID(ctx context.Context, userID uuid.UUID, since *time.Time, withItems bool, fn func(*models.Note) error) error {
+	query := noteSummariesQuery
+	if withItems {
+		query = `
+			SELECT ` + noteColumns + `
+			FROM notes
+			WHERE user_id = $1 AND deleted_at IS NULL
+				AND ($2::timestamptz IS NULL OR updated_at > $2)
+			ORDER BY sort_order ASC
+		`
+	}
+	rows, err := r.db.Query(ctx, query, userID, since)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback(ctx)
+	defer rows.Close()
+
+	for rows.Next() {
+		var note models.Note
+		if withItems {
+			err = scanNote(rows, &note)
+		} else {
+			err = scanNoteSummary(rows, &note)
+		}
+		if err != nil {
+			return err
+		}
+
+		if withItems {
+			err = r.loadChildren(ctx, &note)
+		} else {
+			err = r.loadPreviewsAndAttachments(ctx, &note)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(&note); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetSharedWithUser returns notes other users have shared with userID
+// GetPage returns up to limit of the user's notes changed since since (all notes if nil), ordered by
+// (updated_at, id) and starting after the given cursor, for paging through large syncs
+func (r *NoteRepository) GetPage(ctx context.Context, userID uuid.UUID, since *time.Time, afterUpdatedAt time.Time, afterID uuid.UUID, limit int) ([]models.Note, error) {
+	var notes []models.Note
+	err := r.read(ctx, func(r *NoteRepository) (err error) {
+		notes, err = r.getPage(ctx, userID, since, afterUpdatedAt, afterID, limit)
+		return err
+	})
+	return notes, err
+}
+
+func (r *NoteRepository) getPage(ctx context.Context, userID uuid.UUID, since *time.Time, afterUpdatedAt time.Time, afterID uuid.UUID, limit int) ([]models.Note, error) {
+	// updated_at > since is (updated_at, id) > (since, the largest ID), so the two bounds fold into
+	// one, which idx_notes_live_user_updated can seek to directly
+	if since != nil && !since.Before(afterUpdatedAt) {
+		afterUpdatedAt, afterID = *since, uuid.Max
+	}
 
 	query := `
-		UPDATE notes SET
-			title = $1,
-			content = $2,
-			note_type = $3,
-			is_pinned = $4,
-			is_archived = $5,
-			sort_order = $6,
-			updated_at = $7
-		WHERE id = $8 AND user_id = $9 AND deleted_at IS NULL
+		SELECT ` + noteColumns + `
+		FROM notes
+		WHERE user_id = $1 AND deleted_at IS NULL
+			AND (updated_at, id) > ($2, $3)
+		ORDER BY updated_at ASC, id ASC
+		LIMIT $4
 	`
+	return r.queryNotes(ctx, query, userID, afterUpdatedAt, afterID, limit)
+}
 
-	result, err := tx.Exec(ctx, query,
-		note.Title,
-		note.Content,
-		note.NoteType,
-		note.IsPinned,
-		note.IsArchived,
-		note.SortOrder,
-		note.UpdatedAt,
-		note.ID,
-		note.UserID,
-	)
+// Search returns up to limit of the user's notes matching query, best matches first. By default
+// notes match when their title and content contain every word of query; fuzzy matches titles
+// containing something close to query, so typos and partial words still find them.
+func (r *NoteRepository) Search(ctx context.Context, userID uuid.UUID, query string, fuzzy bool, limit int) ([]models.Note, error) {
+	var notes []models.Note
+	err := r.read(ctx, func(r *NoteRepository) (err error) {
+		notes, err = r.search(ctx, userID, query, fuzzy, limit)
+		return err
+	})
+	return notes, err
+}
+
+func (r *NoteRepository) search(ctx context.Context, userID uuid.UUID, query string, fuzzy bool, limit int) ([]models.Note, error) {
+	// <% compares query with the closest run of words in the title, using idx_notes_title_trgm
+	sql := `
+		SELECT ` + noteColumns + `
+		FROM notes
+		WHERE user_id = $1 AND deleted_at IS NULL AND $2 <% title
+		ORDER BY word_similarity($2, title) DESC, updated_at DESC
+		LIMIT $3
+	`
+	if !fuzzy {
+		sql = `
+			SELECT ` + noteColumns + `
+			FROM notes
+			WHERE user_id = $1 AND deleted_at IS NULL
+				AND to_tsvector('simple', title || ' ' || content) @@ plainto_tsquery('simple', $2)
+			ORDER BY ts_rank(to_tsvector('simple', title || ' ' || content), plainto_tsquery('simple', $2)) DESC, updated_at DESC
+			LIMIT $3
+		`
+	}
+	return r.queryNotes(ctx, sql, userID, query, limit)
+}
+
+func (r *NoteRepository) GetSharedWithUser(ctx context.Context, userID uuid.UUID) ([]models.Note, error) {
+	query := `
+		SELECT ` + prefixedNoteColumns("n") + `
+		FROM notes n
+		JOIN note_shares s ON s.note_id = n.id
+		WHERE s.user_id = $1 AND n.deleted_at IS NULL
+		ORDER BY n.updated_at DESC
+	`
+	return r.queryNotes(ctx, query, userID)
+}
+
+// GetSharedByID returns a note shared with userID
+func (r *NoteRepository) GetSharedByID(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*models.Note, error) {
+	query := `
+		SELECT ` + prefixedNoteColumns("n") + `
+		FROM notes n
+		JOIN note_shares s ON s.note_id = n.id
+		WHERE n.id = $1 AND s.user_id = $2 AND n.deleted_at IS NULL
+	`
+
+	note := &models.Note{}
+	if err := scanNote(r.db.QueryRow(ctx, query, id, userID), note); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoteNotFound
+		}
+		return nil, err
+	}
+
+	if err := r.loadChildren(ctx, note); err != nil {
+		return nil, err
+	}
+
+	return note, nil
+}
+
+// noteColumns lists the notes columns in the order scanNote expects
+const noteColumns = `id, user_id, title, content, note_type, is_pinned, is_archived, sort_order, created_at, updated_at, deleted_at, metadata, language, is_monospace, hlc, version`
+
+// prefixedNoteColumns qualifies noteColumns with a table alias for joins
+func prefixedNoteColumns(alias string) string {
+	return alias + "." + strings.ReplaceAll(noteColumns, ", ", ", "+alias+".")
+}
+
+func scanNote(row pgx.Row, note *models.Note) error {
+	return row.Scan(noteScanTargets(note)...)
+}
+
+// noteScanTargets returns the fields noteColumns scan into, for queries selecting more columns
+func noteScanTargets(note *models.Note) []any {
+	return []any{
+		&note.ID,
+		&note.UserID,
+		&note.Title,
+		&note.Content,
+		&note.NoteType,
+		&note.IsPinned,
+		&note.IsArchived,
+		&note.SortOrder,
+		&note.CreatedAt,
+		&note.UpdatedAt,
+		&note.DeletedAt,
+		&note.Metadata,
+		&note.Language,
+		&note.IsMonospace,
+		&note.HLC,
+		&note.Version,
+	}
+}
+
+// queryNotes runs a query selecting noteColumns and loads each note's checklist items, link previews and attachments
+func (r *NoteRepository) queryNotes(ctx context.Context, query string, args ...interface{}) ([]models.Note, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		var note models.Note
+		if err := scanNote(rows, &note); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	rows.Close()
+
+	for i := range notes {
+		if err := r.loadChildren(ctx, &notes[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return notes, nil
+}
+
+// loadChildren fetches a note's checklist items, link previews and attachments
+func (r *NoteRepository) loadChildren(ctx context.Context, note *models.Note) error {
+	items, err := r.getChecklistItems(ctx, note.ID)
 	if err != nil {
 		return err
 	}
+	note.ChecklistItems = items
 
-	if result.RowsAffected() == 0 {
-		return ErrNoteNotFound
+	return r.loadPreviewsAndAttachments(ctx, note)
+}
+
+// loadPreviewsAndAttachments fetches a note's link previews and attachments
+func (r *NoteRepository) loadPreviewsAndAttachments(ctx context.Context, note *models.Note) error {
+	previews, err := r.getLinkPreviews(ctx, note.ID)
+	if err != nil {
+		return err
 	}
+	note.LinkPreviews = previews
 
-	// Delete existing checklist items and re-insert
-	_, err = tx.Exec(ctx, `DELETE FROM checklist_items WHERE note_id = $1`, note.ID)
+	attachments, err := r.attachments.GetByNoteID(ctx, note.ID)
 	if err != nil {
 		return err
 	}
+	note.Attachments = attachments
 
-	for _, item := range note.ChecklistItems {
-		itemQuery := `
-			INSERT INTO checklist_items (id, note_id, text, is_completed, sort_order, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
-		`
-		_, err = tx.Exec(ctx, itemQuery,
-			item.ID,
-			note.ID,
-			item.Text,
-			item.IsCompleted,
-			item.SortOrder,
-			item.CreatedAt,
-			item.UpdatedAt,
-		)
-		if err != nil {
+	return nil
+}
+
+// Update saves a note, which must be at expectedVersion unless that is AnyVersion, and sets its new
+// version. A note at another version gives ErrVersionConflict.
+func (r *NoteRepository) Update(ctx context.Context, note *models.Note, expectedVersion int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if note.Omitted.Any() {
+		if err := keepOmitted(ctx, tx, note); err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit(ctx)
+	batch := &pgx.Batch{}
+	if err := queueUpdate(batch, note, expectedVersion); err != nil {
+		return err
+	}
+	results := tx.SendBatch(ctx, batch)
+	var version int
+	err = results.QueryRow().Scan(&version)
+	if closeErr := results.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r.missedUpdate(ctx, note)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	note.Version = version
+	return nil
+}
+
+// keepOmitted gives a note the stored values of the fields its client left out. A note that isn't
+// stored is left for the update to miss.
+func keepOmitted(ctx context.Context, db DBTX, note *models.Note) error {
+	stored := &models.Note{}
+	err := db.QueryRow(ctx, `
+		SELECT metadata, language, is_monospace FROM notes
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+	`, note.ID, note.UserID).Scan(&stored.Metadata, &stored.Language, &stored.IsMonospace)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	note.KeepOmitted(stored)
+	return nil
+}
+
+// missedUpdate explains an update that matched no row: the note is gone, or at another version
+func (r *NoteRepository) missedUpdate(ctx context.Context, note *models.Note) error {
+	var exists bool
+	err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL)
+	`, note.ID, note.UserID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrVersionConflict
+	}
+	return ErrNoteNotFound
 }
 
 func (r *NoteRepository) SoftDelete(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
 	query := `
-		UPDATE notes SET deleted_at = NOW(), updated_at = NOW()
+		UPDATE notes SET deleted_at = NOW(), updated_at = NOW(), version = version + 1
 		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
 	`
 
-	result, err := r.pool.Exec(ctx, query, id, userID)
+	result, err := r.db.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		// Cold-stored notes aren't synced, so they're removed outright
+		result, err = r.db.Exec(ctx, `DELETE FROM cold_notes WHERE id = $1 AND user_id = $2`, id, userID)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrNoteNotFound
+		}
+	}
+
+	return nil
+}
+
+// SetSortOrders sets the main list order of the user's notes to the order of noteIDs, following the
+// clients' convention that pinned notes use negative sort orders and unpinned notes count up from 0.
+// Notes are touched so other devices pick up the new sortOrder on their next sync.
+func (r *NoteRepository) SetSortOrders(ctx context.Context, userID uuid.UUID, noteIDs []uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		WITH ordered AS (
+			SELECT n.id, n.is_pinned, t.ord
+			FROM unnest($2::uuid[]) WITH ORDINALITY AS t(id, ord)
+			JOIN notes n ON n.id = t.id AND n.user_id = $1 AND n.deleted_at IS NULL
+		), ranked AS (
+			SELECT id,
+				ROW_NUMBER() OVER (PARTITION BY is_pinned ORDER BY ord) - 1
+					- CASE WHEN is_pinned THEN COUNT(*) OVER (PARTITION BY is_pinned) ELSE 0 END AS position
+			FROM ordered
+		)
+		UPDATE notes SET sort_order = ranked.position, updated_at = NOW(), version = version + 1
+		FROM ranked
+		WHERE notes.id = ranked.id
+	`, userID, noteIDs)
+	return err
+}
+
+// Undelete clears a note's soft delete
+func (r *NoteRepository) Undelete(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE notes SET deleted_at = NULL, updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL
+	`, id, userID)
+	return err
+}
+
+// UpdateContent saves a note's content and updated time, as rebuilt from its CRDT ops
+func (r *NoteRepository) UpdateContent(ctx context.Context, note *models.Note) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		UPDATE notes SET content = $1, updated_at = $2, version = version + 1
+		WHERE id = $3 AND user_id = $4 AND deleted_at IS NULL
+		RETURNING version
+	`, note.Content, note.UpdatedAt, note.ID, note.UserID).Scan(&note.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNoteNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := recordRevision(ctx, tx, note); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Touch bumps a note's updated_at so incremental syncs pick up server-side changes such as new attachments
+func (r *NoteRepository) Touch(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `
+		UPDATE notes SET updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+	`, id, userID)
 	if err != nil {
 		return err
 	}
@@ -272,7 +719,7 @@ func (r *NoteRepository) GetDeletedSince(ctx context.Context, userID uuid.UUID,
 		args = []interface{}{userID}
 	}
 
-	rows, err := r.pool.Query(ctx, query, args...)
+	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +737,8 @@ func (r *NoteRepository) GetDeletedSince(ctx context.Context, userID uuid.UUID,
 	return ids, nil
 }
 
-func (r *NoteRepository) Upsert(ctx context.Context, note *models.Note) error {
+// Upsert creates a note, or updates it at expectedVersion if the incoming edit is newer
+func (r *NoteRepository) Upsert(ctx context.Context, note *models.Note, expectedVersion int) error {
 	// Check if note exists
 	existing, err := r.GetByID(ctx, note.ID, note.UserID)
 	if err != nil && !errors.Is(err, ErrNoteNotFound) {
@@ -298,9 +746,10 @@ func (r *NoteRepository) Upsert(ctx context.Context, note *models.Note) error {
 	}
 
 	if existing != nil {
-		// Only update if incoming is newer
-		if note.UpdatedAt.After(existing.UpdatedAt) {
-			return r.Update(ctx, note)
+		// Only update if incoming is newer by hybrid logical clock, so a device with a fast wall
+		// clock can't overwrite a later edit
+		if note.Clock().After(existing.Clock()) {
+			return r.Update(ctx, note, expectedVersion)
 		}
 		return nil
 	}
@@ -308,6 +757,122 @@ func (r *NoteRepository) Upsert(ctx context.Context, note *models.Note) error {
 	return r.Create(ctx, note)
 }
 
+// UpsertBatch upserts a user's notes like Upsert, in two round trips however many notes and
+// checklist items there are: one to read the stored clocks and one batch of writes. The notes must
+// have distinct IDs. It returns the notes that were written, with their new versions and the stored
+// values of fields their clients left out; older edits than what's stored are left out.
+func (r *NoteRepository) UpsertBatch(ctx context.Context, userID uuid.UUID, notes []*models.Note) ([]*models.Note, error) {
+	if len(notes) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uuid.UUID, len(notes))
+	for i, note := range notes {
+		ids[i] = note.ID
+	}
+	rows, err := r.db.Query(ctx, `
+		SELECT id, updated_at, hlc, metadata, language, is_monospace FROM notes
+		WHERE user_id = $1 AND id = ANY($2) AND deleted_at IS NULL
+	`, userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[uuid.UUID]*models.Note)
+	for rows.Next() {
+		stored := &models.Note{}
+		if err := rows.Scan(&stored.ID, &stored.UpdatedAt, &stored.HLC, &stored.Metadata, &stored.Language, &stored.IsMonospace); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		existing[stored.ID] = stored
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	batch := &pgx.Batch{}
+	var written []*models.Note
+	updates := make(map[int]*models.Note) // by the position of the statement returning their version
+	for _, note := range notes {
+		stored, ok := existing[note.ID]
+		switch {
+		case !ok:
+			err = queueCreate(batch, note)
+		case note.Clock().After(stored.Clock()):
+			note.KeepOmitted(stored)
+			updates[batch.Len()] = note
+			err = queueUpdate(batch, note, AnyVersion)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		written = append(written, note)
+	}
+	if batch.Len() == 0 {
+		return nil, nil
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	results := tx.SendBatch(ctx, batch)
+	for i := 0; i < batch.Len(); i++ {
+		if note, ok := updates[i]; ok {
+			err = results.QueryRow().Scan(&note.Version)
+		} else {
+			_, err = results.Exec()
+		}
+		if err != nil {
+			results.Close()
+			return nil, err
+		}
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return written, nil
+}
+
+// NoteUsage is how much a user stores in notes. Bytes counts titles, content, checklist item text
+// and the sizes of attachments, of which AttachmentBytes is the attachments' part; deleted notes and
+// their attachments don't count.
+type NoteUsage struct {
+	Notes           int64
+	Bytes           int64
+	AttachmentBytes int64
+}
+
+// Usage returns what the user stores in notes other than except, and in except itself, if it
+// exists. Pass uuid.Nil to count every note in the first.
+func (r *NoteRepository) Usage(ctx context.Context, userID, except uuid.UUID) (others NoteUsage, note NoteUsage, err error) {
+	err = r.db.QueryRow(ctx, `
+		WITH sizes AS (
+			SELECT n.id, octet_length(n.title) + octet_length(n.content) +
+				COALESCE((SELECT SUM(octet_length(ci.text)) FROM checklist_items ci WHERE ci.note_id = n.id), 0) AS text_bytes,
+				COALESCE((SELECT SUM(a.size_bytes) FROM attachments a WHERE a.note_id = n.id), 0) AS attachment_bytes
+			FROM notes n
+			WHERE n.user_id = $1 AND n.deleted_at IS NULL
+		)
+		SELECT COUNT(*) FILTER (WHERE id <> $2),
+			COALESCE(SUM(text_bytes + attachment_bytes) FILTER (WHERE id <> $2), 0),
+			COALESCE(SUM(attachment_bytes) FILTER (WHERE id <> $2), 0),
+			COUNT(*) FILTER (WHERE id = $2),
+			COALESCE(SUM(text_bytes + attachment_bytes) FILTER (WHERE id = $2), 0),
+			COALESCE(SUM(attachment_bytes) FILTER (WHERE id = $2), 0)
+		FROM sizes
+	`, userID, except).Scan(&others.Notes, &others.Bytes, &others.AttachmentBytes, &note.Notes, &note.Bytes, &note.AttachmentBytes)
+	return others, note, err
+}
+
 func (r *NoteRepository) getChecklistItems(ctx context.Context, noteID uuid.UUID) ([]models.ChecklistItem, error) {
 	query := `
 		SELECT id, note_id, text, is_completed, sort_order, created_at, updated_at
@@ -315,7 +880,7 @@ func (r *NoteRepository) getChecklistItems(ctx context.Context, noteID uuid.UUID
 		ORDER BY sort_order ASC
 	`
 
-	rows, err := r.pool.Query(ctx, query, noteID)
+	rows, err := r.db.Query(ctx, query, noteID)
 	if err != nil {
 		return nil, err
 	}
@@ -342,10 +907,22 @@ func (r *NoteRepository) getChecklistItems(ctx context.Context, noteID uuid.UUID
 	return items, nil
 }
 
+func (r *NoteRepository) getLinkPreviews(ctx context.Context, noteID uuid.UUID) ([]models.LinkPreview, error) {
+	return r.linkPreviews.GetByNoteID(ctx, noteID)
+}
+
+// metadataOrEmpty avoids writing a JSON null into the NOT NULL metadata column
+func metadataOrEmpty(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return map[string]string{}
+	}
+	return metadata
+}
+
 // HardDeleteAllByUserID permanently deletes all notes for a user (used for demo account reset)
 func (r *NoteRepository) HardDeleteAllByUserID(ctx context.Context, userID uuid.UUID) error {
 	// Delete checklist items first (foreign key constraint)
-	_, err := r.pool.Exec(ctx, `
+	_, err := r.db.Exec(ctx, `
 		DELETE FROM checklist_items
 		WHERE note_id IN (SELECT id FROM notes WHERE user_id = $1)
 	`, userID)
@@ -354,6 +931,6 @@ func (r *NoteRepository) HardDeleteAllByUserID(ctx context.Context, userID uuid.
 	}
 
 	// Delete notes
-	_, err = r.pool.Exec(ctx, `DELETE FROM notes WHERE user_id = $1`, userID)
+	_, err = r.db.Exec(ctx, `DELETE FROM notes WHERE user_id = $1`, userID)
 	return err
 }
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// OrderingRepository stores the manual order of notes within folder and tag views
+type OrderingRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewOrderingRepository(pool *pgxpool.Pool) *OrderingRepository {
+	return &OrderingRepository{pool: pool}
+}
+
+// GetOrder returns the IDs of the user's notes in a context, in position order.
+// Deleted notes are skipped.
+func (r *OrderingRepository) GetOrder(ctx context.Context, userID uuid.UUID, orderingContext string) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT o.note_id
+		FROM note_orderings o
+		JOIN notes n ON n.id = o.note_id AND n.deleted_at IS NULL
+		WHERE o.user_id = $1 AND o.context = $2
+		ORDER BY o.position ASC
+	`, userID, orderingContext)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// GetMainOrder returns the IDs of the user's notes in main list (sort_order) order
+func (r *OrderingRepository) GetMainOrder(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id FROM notes
+		WHERE user_id = $1 AND deleted_at IS NULL
+		ORDER BY sort_order ASC, created_at ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// SetOrder replaces the order of a context. IDs that aren't the user's notes are ignored.
+func (r *OrderingRepository) SetOrder(ctx context.Context, userID uuid.UUID, orderingContext string, noteIDs []uuid.UUID) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM note_orderings WHERE user_id = $1 AND context = $2`, userID, orderingContext)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO note_orderings (user_id, context, note_id, position)
+		SELECT $1, $2, t.id, t.ord - 1
+		FROM unnest($3::uuid[]) WITH ORDINALITY AS t(id, ord)
+		JOIN notes n ON n.id = t.id AND n.user_id = $1
+		ON CONFLICT (user_id, context, note_id) DO NOTHING
+	`, userID, orderingContext, noteIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
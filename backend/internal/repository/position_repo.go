@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrPositionNotFound = errors.New("no reading position saved for this note")
+
+// PositionRepository stores each user's reading position in their notes
+type PositionRepository struct {
+	db DBTX
+}
+
+func NewPositionRepository(pool *pgxpool.Pool) *PositionRepository {
+	return &PositionRepository{db: pool}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *PositionRepository) WithTx(tx pgx.Tx) *PositionRepository {
+	return &PositionRepository{db: tx}
+}
+
+const positionColumns = `note_id, user_id, caret, scroll, device_id, updated_at`
+
+func scanPosition(row pgx.Row) (*models.NotePosition, error) {
+	var position models.NotePosition
+	if err := row.Scan(&position.NoteID, &position.UserID, &position.Caret, &position.Scroll, &position.DeviceID, &position.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &position, nil
+}
+
+// Set saves a position in a note the user owns or collaborates on, unless a newer one is already
+// stored. Returns ErrNoteNotFound if the user can't open the note.
+func (r *PositionRepository) Set(ctx context.Context, position *models.NotePosition) error {
+	var accessible bool
+	if err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM notes n
+			WHERE n.id = $1 AND n.deleted_at IS NULL AND (n.user_id = $2 OR EXISTS (
+				SELECT 1 FROM note_shares s WHERE s.note_id = n.id AND s.user_id = $2))
+		)
+	`, position.NoteID, position.UserID).Scan(&accessible); err != nil {
+		return err
+	}
+	if !accessible {
+		return ErrNoteNotFound
+	}
+
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO note_positions (note_id, user_id, caret, scroll, device_id, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (note_id, user_id) DO UPDATE SET
+			caret = EXCLUDED.caret,
+			scroll = EXCLUDED.scroll,
+			device_id = EXCLUDED.device_id,
+			updated_at = EXCLUDED.updated_at
+		WHERE note_positions.updated_at <= EXCLUDED.updated_at
+	`, position.NoteID, position.UserID, position.Caret, position.Scroll, position.DeviceID, position.UpdatedAt)
+	return err
+}
+
+// Get returns the user's position in a note
+func (r *PositionRepository) Get(ctx context.Context, noteID, userID uuid.UUID) (*models.NotePosition, error) {
+	position, err := scanPosition(r.db.QueryRow(ctx, `
+		SELECT `+positionColumns+`
+		FROM note_positions
+		WHERE note_id = $1 AND user_id = $2
+	`, noteID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPositionNotFound
+	}
+	return position, err
+}
+
+// ListSince returns the user's positions saved after since, or all of them if since is nil
+func (r *PositionRepository) ListSince(ctx context.Context, userID uuid.UUID, since *time.Time) ([]models.NotePosition, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+positionColumns+`
+		FROM note_positions
+		WHERE user_id = $1 AND ($2::timestamptz IS NULL OR updated_at > $2)
+		ORDER BY updated_at ASC
+	`, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var positions []models.NotePosition
+	for rows.Next() {
+		position, err := scanPosition(rows)
+		if err != nil {
+			return nil, err
+		}
+		positions = append(positions, *position)
+	}
+
+	return positions, rows.Err()
+}
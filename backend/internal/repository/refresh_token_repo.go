@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found or expired")
+	ErrRefreshTokenUsed     = errors.New("refresh token already used")
+	ErrRefreshTokenRevoked  = errors.New("refresh token revoked")
+)
+
+// RefreshTokenRepository tracks issued refresh tokens so each can be used once
+type RefreshTokenRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewRefreshTokenRepository(pool *pgxpool.Pool) *RefreshTokenRepository {
+	return &RefreshTokenRepository{pool: pool}
+}
+
+const refreshTokenColumns = `id, family_id, user_id, expires_at, used_at, revoked_at, created_at`
+
+func scanRefreshToken(row pgx.Row) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := row.Scan(&token.ID, &token.FamilyID, &token.UserID, &token.ExpiresAt, &token.UsedAt, &token.RevokedAt, &token.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Create records a newly issued refresh token
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO refresh_tokens (id, family_id, user_id, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, token.ID, token.FamilyID, token.UserID, token.ExpiresAt)
+	return err
+}
+
+// Use marks a token as used and returns it. A token can only be used once: using it again returns
+// ErrRefreshTokenUsed along with the token, so the caller can revoke its family.
+func (r *RefreshTokenRepository) Use(ctx context.Context, id uuid.UUID) (*models.RefreshToken, error) {
+	token, err := scanRefreshToken(r.pool.QueryRow(ctx, `
+		UPDATE refresh_tokens SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING `+refreshTokenColumns,
+		id))
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	// Work out why it couldn't be used
+	token, err = scanRefreshToken(r.pool.QueryRow(ctx, `
+		SELECT `+refreshTokenColumns+`
+		FROM refresh_tokens
+		WHERE id = $1 AND expires_at > NOW()
+	`, id))
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, ErrRefreshTokenNotFound
+	case err != nil:
+		return nil, err
+	case token.RevokedAt != nil:
+		return token, ErrRefreshTokenRevoked
+	default:
+		return token, ErrRefreshTokenUsed
+	}
+}
+
+// RevokeFamily revokes every token descended from one login
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID)
+	return err
+}
+
+// RevokeAllForUser revokes every refresh token the user holds
+func (r *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	return err
+}
+
+// DeleteExpired removes tokens past their expiry, which can no longer be used or replayed
+func (r *RefreshTokenRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := r.pool.Exec(ctx, `DELETE FROM refresh_tokens WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ReadReplica decides whether reads may go to a read replica (see database.Replica)
+type ReadReplica interface {
+	// Usable reports whether the replica is reachable and caught up closely enough to read from
+	Usable(ctx context.Context) bool
+
+	// Failed reports a read that failed on the replica, so reads go to the primary for a while
+	Failed(err error)
+
+	// MaxStaleness returns how far behind the primary reads from the replica can be, or 0 if
+	// there's no limit
+	MaxStaleness() time.Duration
+}
+
+type primaryReadsKey struct{}
+
+// ReadPrimary marks ctx so reads made with it skip the replica, for reading changes just written
+// before the replica may have them
+func ReadPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// CursorReads prepares ctx for reads whose results are handed to the client with a timestamp to
+// read changes since next time, as sync and note listings do. Changes the replica hasn't replayed
+// yet are older than the read, so the timestamp must be moved back by the returned duration for
+// the client not to skip them. With no limit on the replica's lag the reads go to the primary.
+func (r *NoteRepository) CursorReads(ctx context.Context) (context.Context, time.Duration) {
+	if r.replicaReads == nil || ctx.Value(primaryReadsKey{}) != nil {
+		return ctx, 0
+	}
+	staleness := r.replica.MaxStaleness()
+	if staleness == 0 {
+		return ReadPrimary(ctx), 0
+	}
+	return ctx, staleness
+}
+
+// WithReplica returns a copy of the repository that sends reads of a user's notes to the replica
+// whose pool is given, while replica says it's usable. Reads that fail there, or find nothing, are
+// retried on the primary.
+func (r *NoteRepository) WithReplica(pool *pgxpool.Pool, replica ReadReplica) *NoteRepository {
+	return &NoteRepository{
+		db:           r.db,
+		linkPreviews: r.linkPreviews,
+		attachments:  r.attachments,
+		replica:      replica,
+		replicaReads: NewNoteRepository(pool),
+	}
+}
+
+// read runs fn on the replica's repository if reads may go there, and on this one otherwise or if
+// it fails. A note not found on the replica may just not have reached it yet, so that's retried too.
+func (r *NoteRepository) read(ctx context.Context, fn func(*NoteRepository) error) error {
+	if r.replicaReads == nil || ctx.Value(primaryReadsKey{}) != nil || !r.replica.Usable(ctx) {
+		return fn(r)
+	}
+
+	err := fn(r.replicaReads)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	if !errors.Is(err, ErrNoteNotFound) {
+		r.replica.Failed(err)
+	}
+	return fn(r)
+}
@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/textdelta"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+type RevisionRepository struct {
+	db DBTX
+}
+
+func NewRevisionRepository(pool *pgxpool.Pool) *RevisionRepository {
+	return &RevisionRepository{db: pool}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *RevisionRepository) WithTx(tx pgx.Tx) *RevisionRepository {
+	return &RevisionRepository{db: tx}
+}
+
+// GetAsOf returns the latest revision of a note recorded at or before t
+func (r *RevisionRepository) GetAsOf(ctx context.Context, noteID, userID uuid.UUID, t time.Time) (*models.NoteRevision, error) {
+	query := `
+		SELECT id, note_id, user_id, snapshot, recorded_at
+		FROM note_revisions
+		WHERE note_id = $1 AND user_id = $2 AND recorded_at <= $3
+		ORDER BY recorded_at DESC
+		LIMIT 1
+	`
+
+	return r.getOne(ctx, query, noteID, userID, t)
+}
+
+// GetAllAsOf returns the user's notes as they were at t: the latest revision of each note recorded by
+// then, leaving out notes deleted by then. Notes with no revision that old are missing.
+func (r *RevisionRepository) GetAllAsOf(ctx context.Context, userID uuid.UUID, t time.Time) ([]models.Note, error) {
+	query := `
+		SELECT snapshot FROM (
+			SELECT DISTINCT ON (r.note_id) r.snapshot
+			FROM note_revisions r
+			JOIN notes n ON n.id = r.note_id
+			WHERE n.user_id = $1 AND r.user_id = $1 AND r.recorded_at <= $2
+				AND (n.deleted_at IS NULL OR n.deleted_at > $2)
+			ORDER BY r.note_id, r.recorded_at DESC
+		) latest
+		ORDER BY (snapshot->>'sortOrder')::int ASC
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		var snapshot []byte
+		if err := rows.Scan(&snapshot); err != nil {
+			return nil, err
+		}
+		var note models.Note
+		if err := json.Unmarshal(snapshot, &note); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+
+	return notes, rows.Err()
+}
+
+// GetContentByHash returns the content of a note revision with the given content hash
+func (r *RevisionRepository) GetContentByHash(ctx context.Context, noteID, userID uuid.UUID, hash string) (string, error) {
+	var content string
+	err := r.db.QueryRow(ctx, `
+		SELECT COALESCE(snapshot->>'content', '')
+		FROM note_revisions
+		WHERE note_id = $1 AND user_id = $2 AND content_hash = $3
+		ORDER BY recorded_at DESC
+		LIMIT 1
+	`, noteID, userID, hash).Scan(&content)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrRevisionNotFound
+		}
+		return "", err
+	}
+	return content, nil
+}
+
+// ListByNote returns a note's revisions, newest first. Only the title of each snapshot is loaded.
+func (r *RevisionRepository) ListByNote(ctx context.Context, noteID, userID uuid.UUID) ([]models.NoteRevision, error) {
+	query := `
+		SELECT id, note_id, user_id, COALESCE(snapshot->>'title', ''), recorded_at
+		FROM note_revisions
+		WHERE note_id = $1 AND user_id = $2
+		ORDER BY recorded_at DESC, id DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.NoteRevision
+	for rows.Next() {
+		var revision models.NoteRevision
+		if err := rows.Scan(
+			&revision.ID,
+			&revision.NoteID,
+			&revision.UserID,
+			&revision.Note.Title,
+			&revision.RecordedAt,
+		); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+// ListAllByUser returns every saved revision of the user's notes, with snapshots, oldest first
+func (r *RevisionRepository) ListAllByUser(ctx context.Context, userID uuid.UUID) ([]models.NoteRevision, error) {
+	var revisions []models.NoteRevision
+	err := r.EachByUser(ctx, userID, func(revision *models.NoteRevision) error {
+		revisions = append(revisions, *revision)
+		return nil
+	})
+	return revisions, err
+}
+
+// EachByUser calls fn with every saved revision of the user's notes, oldest first, as they're read
+// rather than collecting them first. An error from fn stops the iteration and is returned.
+func (r *RevisionRepository) EachByUser(ctx context.Context, userID uuid.UUID, fn func(*models.NoteRevision) error) error {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, note_id, user_id, snapshot, recorded_at
+		FROM note_revisions
+		WHERE user_id = $1
+		ORDER BY recorded_at ASC, id ASC
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var revision models.NoteRevision
+		var snapshot []byte
+		if err := rows.Scan(&revision.ID, &revision.NoteID, &revision.UserID, &snapshot, &revision.RecordedAt); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(snapshot, &revision.Note); err != nil {
+			return err
+		}
+		if err := fn(&revision); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetByID returns one revision of a note
+func (r *RevisionRepository) GetByID(ctx context.Context, id, noteID, userID uuid.UUID) (*models.NoteRevision, error) {
+	return r.getOne(ctx, `
+		SELECT id, note_id, user_id, snapshot, recorded_at
+		FROM note_revisions
+		WHERE id = $1 AND note_id = $2 AND user_id = $3
+	`, id, noteID, userID)
+}
+
+// GetPrevious returns the revision recorded just before the given one
+func (r *RevisionRepository) GetPrevious(ctx context.Context, revision *models.NoteRevision) (*models.NoteRevision, error) {
+	return r.getOne(ctx, `
+		SELECT id, note_id, user_id, snapshot, recorded_at
+		FROM note_revisions
+		WHERE note_id = $1 AND user_id = $2 AND (recorded_at, id) < ($3, $4)
+		ORDER BY recorded_at DESC, id DESC
+		LIMIT 1
+	`, revision.NoteID, revision.UserID, revision.RecordedAt, revision.ID)
+}
+
+func (r *RevisionRepository) getOne(ctx context.Context, query string, args ...any) (*models.NoteRevision, error) {
+	var revision models.NoteRevision
+	var snapshot []byte
+	err := r.db.QueryRow(ctx, query, args...).Scan(
+		&revision.ID,
+		&revision.NoteID,
+		&revision.UserID,
+		&snapshot,
+		&revision.RecordedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRevisionNotFound
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(snapshot, &revision.Note); err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// recordRevision snapshots a note's contents within a write transaction and prunes old revisions
+func recordRevision(ctx context.Context, tx pgx.Tx, note *models.Note) error {
+	batch := &pgx.Batch{}
+	if err := queueRevision(batch, note); err != nil {
+		return err
+	}
+	return tx.SendBatch(ctx, batch).Close()
+}
+
+// queueRevision adds recordRevision's statements to a batch
+func queueRevision(batch *pgx.Batch, note *models.Note) error {
+	// Previews and attachments are stored separately and aren't part of the note's edits
+	snapshot := *note
+	snapshot.LinkPreviews = nil
+	snapshot.Attachments = nil
+	snapshot.DeletedAt = nil
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	batch.Queue(`
+		INSERT INTO note_revisions (note_id, user_id, snapshot, content_hash, recorded_at)
+		VALUES ($1, $2, $3, $4, NOW())
+	`, note.ID, note.UserID, data, textdelta.Hash(note.Content))
+
+	batch.Queue(`
+		DELETE FROM note_revisions
+		WHERE note_id = $1 AND id NOT IN (
+			SELECT id FROM note_revisions WHERE note_id = $1
+			ORDER BY recorded_at DESC
+			LIMIT $2
+		)
+	`, note.ID, models.MaxRevisionsPerNote)
+	return nil
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionRepository tracks users' login sessions
+type SessionRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewSessionRepository(pool *pgxpool.Pool) *SessionRepository {
+	return &SessionRepository{pool: pool}
+}
+
+// Record creates a session, or notes that an existing one was just used from the given client.
+// Sessions started before they were tracked are created on their first refresh.
+func (r *SessionRepository) Record(ctx context.Context, session *models.Session) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO sessions (id, user_id, user_agent, device_id, ip_address, scope, name, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE SET
+			last_seen_at = NOW(),
+			ip_address = EXCLUDED.ip_address,
+			user_agent = CASE WHEN EXCLUDED.user_agent = '' THEN sessions.user_agent ELSE EXCLUDED.user_agent END,
+			device_id = CASE WHEN EXCLUDED.device_id = '' THEN sessions.device_id ELSE EXCLUDED.device_id END
+	`, session.ID, session.UserID, session.UserAgent, session.DeviceID, session.IPAddress, session.Scope, session.Name, session.ExpiresAt)
+	return err
+}
+
+// ListActive returns a user's sessions that haven't been revoked and were used since the given
+// time, or are scoped tokens that haven't expired, most recently used first
+func (r *SessionRepository) ListActive(ctx context.Context, userID uuid.UUID, since time.Time) ([]models.Session, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT s.id, s.user_id, s.user_agent, s.device_id, COALESCE(d.name, ''), s.ip_address, s.created_at, s.last_seen_at, s.revoked_at,
+			s.scope, s.name, s.expires_at
+		FROM sessions s
+		LEFT JOIN devices d ON d.user_id = s.user_id AND d.device_id = s.device_id AND s.device_id <> ''
+		WHERE s.user_id = $1 AND s.revoked_at IS NULL AND (s.last_seen_at > $2 OR s.expires_at > NOW())
+		ORDER BY s.last_seen_at DESC
+	`, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.DeviceID, &session.DeviceName,
+			&session.IPAddress, &session.CreatedAt, &session.LastSeenAt, &session.RevokedAt,
+			&session.Scope, &session.Name, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke ends one session. Ending a session that is already revoked succeeds.
+func (r *SessionRepository) Revoke(ctx context.Context, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	return err
+}
+
+// RevokeForUser ends one of a user's sessions
+func (r *SessionRepository) RevokeForUser(ctx context.Context, id, userID uuid.UUID) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAllForUser ends every session a user has
+func (r *SessionRepository) RevokeAllForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+// IsRevoked reports whether a session has been ended. Sessions that were never recorded aren't.
+func (r *SessionRepository) IsRevoked(ctx context.Context, id uuid.UUID) (bool, error) {
+	var revoked bool
+	err := r.pool.QueryRow(ctx, `SELECT revoked_at IS NOT NULL FROM sessions WHERE id = $1`, id).Scan(&revoked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return revoked, err
+}
+
+// DeleteUnusedBefore removes sessions last used before the given time whose scoped tokens, if any,
+// have expired; none of their tokens can still be valid
+func (r *SessionRepository) DeleteUnusedBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.pool.Exec(ctx, `DELETE FROM sessions WHERE last_seen_at < $1 AND (expires_at IS NULL OR expires_at < NOW())`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
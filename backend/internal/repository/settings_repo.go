@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SettingsRepository stores per-user settings
+type SettingsRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewSettingsRepository(pool *pgxpool.Pool) *SettingsRepository {
+	return &SettingsRepository{pool: pool}
+}
+
+// GetNotificationPreferences returns the categories the user has configured. Users who haven't
+// configured any get an empty map.
+func (r *SettingsRepository) GetNotificationPreferences(ctx context.Context, userID uuid.UUID) (models.NotificationPreferences, error) {
+	var data []byte
+	err := r.pool.QueryRow(ctx, `SELECT notification_preferences FROM user_settings WHERE user_id = $1`, userID).Scan(&data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.NotificationPreferences{}, nil
+		}
+		return nil, err
+	}
+
+	prefs := models.NotificationPreferences{}
+	if err := json.Unmarshal(data, &prefs); err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// SetNotificationPreferences replaces the user's notification preferences
+func (r *SettingsRepository) SetNotificationPreferences(ctx context.Context, userID uuid.UUID, prefs models.NotificationPreferences) error {
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+	_, err = r.pool.Exec(ctx, `
+		INSERT INTO user_settings (user_id, notification_preferences, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			notification_preferences = EXCLUDED.notification_preferences,
+			updated_at = EXCLUDED.updated_at
+	`, userID, data)
+	return err
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrInviteNotFound = errors.New("invite not found")
+
+type ShareRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewShareRepository(pool *pgxpool.Pool) *ShareRepository {
+	return &ShareRepository{pool: pool}
+}
+
+func (r *ShareRepository) CreateInvite(ctx context.Context, invite *models.NoteInvite) error {
+	query := `
+		INSERT INTO note_invites (id, note_id, invited_by, email, expires_at, created_at, sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err := r.pool.Exec(ctx, query,
+		invite.ID,
+		invite.NoteID,
+		invite.InvitedBy,
+		invite.Email,
+		invite.ExpiresAt,
+		invite.CreatedAt,
+		invite.SentAt,
+	)
+	return err
+}
+
+func (r *ShareRepository) GetInvite(ctx context.Context, id uuid.UUID) (*models.NoteInvite, error) {
+	query := `
+		SELECT id, note_id, invited_by, email, expires_at, accepted_at, accepted_by, created_at, sent_at
+		FROM note_invites WHERE id = $1
+	`
+
+	invite := &models.NoteInvite{}
+	err := r.pool.QueryRow(ctx, query, id).Scan(
+		&invite.ID,
+		&invite.NoteID,
+		&invite.InvitedBy,
+		&invite.Email,
+		&invite.ExpiresAt,
+		&invite.AcceptedAt,
+		&invite.AcceptedBy,
+		&invite.CreatedAt,
+		&invite.SentAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInviteNotFound
+		}
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+// ListInvites returns all invites for a note, newest first
+func (r *ShareRepository) ListInvites(ctx context.Context, noteID uuid.UUID) ([]models.NoteInvite, error) {
+	query := `
+		SELECT id, note_id, invited_by, email, expires_at, accepted_at, accepted_by, created_at, sent_at
+		FROM note_invites WHERE note_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.pool.Query(ctx, query, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []models.NoteInvite
+	for rows.Next() {
+		var invite models.NoteInvite
+		err := rows.Scan(
+			&invite.ID,
+			&invite.NoteID,
+			&invite.InvitedBy,
+			&invite.Email,
+			&invite.ExpiresAt,
+			&invite.AcceptedAt,
+			&invite.AcceptedBy,
+			&invite.CreatedAt,
+			&invite.SentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, nil
+}
+
+// RenewInvite extends a pending invite's expiry when it is resent
+func (r *ShareRepository) RenewInvite(ctx context.Context, id uuid.UUID, expiresAt time.Time) error {
+	query := `
+		UPDATE note_invites SET expires_at = $1, sent_at = NOW()
+		WHERE id = $2 AND accepted_at IS NULL
+	`
+	result, err := r.pool.Exec(ctx, query, expiresAt, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+func (r *ShareRepository) DeleteInvite(ctx context.Context, id uuid.UUID, noteID uuid.UUID) error {
+	result, err := r.pool.Exec(ctx, `DELETE FROM note_invites WHERE id = $1 AND note_id = $2`, id, noteID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// AcceptInvite marks a pending invite as accepted and grants the user access to the note.
+// Returns ErrInviteNotFound if the invite was already accepted or has expired.
+func (r *ShareRepository) AcceptInvite(ctx context.Context, invite *models.NoteInvite, userID uuid.UUID) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE note_invites SET accepted_at = NOW(), accepted_by = $1
+		WHERE id = $2 AND accepted_at IS NULL AND expires_at > NOW()
+	`, userID, invite.ID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrInviteNotFound
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO note_shares (note_id, user_id, granted_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (note_id, user_id) DO NOTHING
+	`, invite.NoteID, userID, invite.InvitedBy)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetCollaborators returns the IDs of users a note has been shared with
+func (r *ShareRepository) GetCollaborators(ctx context.Context, noteID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `SELECT user_id FROM note_shares WHERE note_id = $1`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
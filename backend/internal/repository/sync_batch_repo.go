@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrSyncBatchNotFound = errors.New("sync batch not found")
+	ErrSyncBatchReverted = errors.New("sync batch already reverted")
+)
+
+type SyncBatchRepository struct {
+	db DBTX
+}
+
+func NewSyncBatchRepository(pool *pgxpool.Pool) *SyncBatchRepository {
+	return &SyncBatchRepository{db: pool}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *SyncBatchRepository) WithTx(tx pgx.Tx) *SyncBatchRepository {
+	return &SyncBatchRepository{db: tx}
+}
+
+// Create records a new batch
+func (r *SyncBatchRepository) Create(ctx context.Context, batch *models.SyncBatch) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO sync_batches (id, user_id, request_id, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, batch.ID, batch.UserID, batch.RequestID, batch.CreatedAt)
+	return err
+}
+
+// AddNote records a note's state before the batch changed it. Only the first state recorded for a
+// note in a batch is kept. A nil preImage means the note didn't exist.
+func (r *SyncBatchRepository) AddNote(ctx context.Context, batchID, noteID uuid.UUID, preImage *models.Note) error {
+	var data []byte
+	if preImage != nil {
+		snapshot := *preImage
+		snapshot.LinkPreviews = nil
+		snapshot.Attachments = nil
+
+		var err error
+		if data, err = json.Marshal(snapshot); err != nil {
+			return err
+		}
+	}
+
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO sync_batch_notes (batch_id, note_id, pre_image)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (batch_id, note_id) DO NOTHING
+	`, batchID, noteID, data)
+	return err
+}
+
+// GetByID returns one of the user's batches
+func (r *SyncBatchRepository) GetByID(ctx context.Context, id, userID uuid.UUID) (*models.SyncBatch, error) {
+	query := `
+		SELECT b.id, b.user_id, b.request_id, b.created_at, b.reverted_at,
+			(SELECT COUNT(*) FROM sync_batch_notes n WHERE n.batch_id = b.id)
+		FROM sync_batches b
+		WHERE b.id = $1 AND b.user_id = $2
+	`
+
+	var batch models.SyncBatch
+	err := r.db.QueryRow(ctx, query, id, userID).Scan(
+		&batch.ID,
+		&batch.UserID,
+		&batch.RequestID,
+		&batch.CreatedAt,
+		&batch.RevertedAt,
+		&batch.NoteCount,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSyncBatchNotFound
+		}
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ListByUser returns the user's most recent batches, newest first
+func (r *SyncBatchRepository) ListByUser(ctx context.Context, userID uuid.UUID, limit int) ([]models.SyncBatch, error) {
+	query := `
+		SELECT b.id, b.user_id, b.request_id, b.created_at, b.reverted_at,
+			(SELECT COUNT(*) FROM sync_batch_notes n WHERE n.batch_id = b.id)
+		FROM sync_batches b
+		WHERE b.user_id = $1
+		ORDER BY b.created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []models.SyncBatch
+	for rows.Next() {
+		var batch models.SyncBatch
+		err := rows.Scan(
+			&batch.ID,
+			&batch.UserID,
+			&batch.RequestID,
+			&batch.CreatedAt,
+			&batch.RevertedAt,
+			&batch.NoteCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+
+	return batches, rows.Err()
+}
+
+// GetNotes returns the pre-images recorded for a batch
+func (r *SyncBatchRepository) GetNotes(ctx context.Context, batchID uuid.UUID) ([]models.SyncBatchNote, error) {
+	rows, err := r.db.Query(ctx, `SELECT batch_id, note_id, pre_image FROM sync_batch_notes WHERE batch_id = $1`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.SyncBatchNote
+	for rows.Next() {
+		var note models.SyncBatchNote
+		var data []byte
+		if err := rows.Scan(&note.BatchID, &note.NoteID, &data); err != nil {
+			return nil, err
+		}
+		if data != nil {
+			note.PreImage = &models.Note{}
+			if err := json.Unmarshal(data, note.PreImage); err != nil {
+				return nil, err
+			}
+		}
+		notes = append(notes, note)
+	}
+
+	return notes, rows.Err()
+}
+
+// MarkReverted marks a batch as reverted, failing if it already was
+func (r *SyncBatchRepository) MarkReverted(ctx context.Context, id, userID uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `
+		UPDATE sync_batches SET reverted_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND reverted_at IS NULL
+	`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrSyncBatchReverted
+	}
+	return nil
+}
+
+// DeleteOlderThan removes batches created before the cutoff
+func (r *SyncBatchRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := r.db.Exec(ctx, `DELETE FROM sync_batches WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -3,15 +3,18 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hamishgilbert/notes-app/backend/internal/models"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var ErrUserNotFound = errors.New("user not found")
 var ErrUserExists = errors.New("username already exists")
+var ErrEmailExists = errors.New("email already in use")
 
 type UserRepository struct {
 	pool *pgxpool.Pool
@@ -21,24 +24,63 @@ func NewUserRepository(pool *pgxpool.Pool) *UserRepository {
 	return &UserRepository{pool: pool}
 }
 
+const userColumns = `id, username, password_hash, password_algorithm, COALESCE(email, ''), email_verified_at, is_admin, read_only, demo_expires_at, created_at, updated_at`
+
+func scanUser(row pgx.Row) (*models.User, error) {
+	user := &models.User{}
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.PasswordHash,
+		&user.PasswordAlgorithm,
+		&user.Email,
+		&user.VerifiedAt,
+		&user.IsAdmin,
+		&user.ReadOnly,
+		&user.DemoExpiresAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// uniqueViolation reports whether err is a unique constraint violation on the given constraint or index
+func uniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
 func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 	query := `
-		INSERT INTO users (id, username, password_hash, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO users (id, username, password_hash, password_algorithm, email, read_only, demo_expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9)
 	`
 
 	_, err := r.pool.Exec(ctx, query,
 		user.ID,
 		user.Username,
 		user.PasswordHash,
+		user.PasswordAlgorithm,
+		user.Email,
+		user.ReadOnly,
+		user.DemoExpiresAt,
 		user.CreatedAt,
 		user.UpdatedAt,
 	)
 
 	if err != nil {
-		if err.Error() == `ERROR: duplicate key value violates unique constraint "users_username_key" (SQLSTATE 23505)` {
+		if uniqueViolation(err, "users_username_key") {
 			return ErrUserExists
 		}
+		if uniqueViolation(err, "idx_users_email") {
+			return ErrEmailExists
+		}
 		return err
 	}
 
@@ -46,58 +88,77 @@ func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 }
 
 func (r *UserRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
-	query := `
-		SELECT id, username, password_hash, created_at, updated_at
-		FROM users WHERE id = $1
-	`
+	return scanUser(r.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+}
 
-	user := &models.User{}
-	err := r.pool.QueryRow(ctx, query, id).Scan(
-		&user.ID,
-		&user.Username,
-		&user.PasswordHash,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return scanUser(r.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE username = $1`, username))
+}
+
+// GetByEmail finds a user by email address, ignoring case
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return scanUser(r.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE LOWER(email) = LOWER($1)`, email))
+}
 
+func (r *UserRepository) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash, algorithm string) error {
+	query := `UPDATE users SET password_hash = $1, password_algorithm = $2, updated_at = NOW() WHERE id = $3`
+	result, err := r.pool.Exec(ctx, query, passwordHash, algorithm, id)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrUserNotFound
-		}
-		return nil, err
+		return err
 	}
-
-	return user, nil
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
 
-func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
-	query := `
-		SELECT id, username, password_hash, created_at, updated_at
-		FROM users WHERE username = $1
-	`
-
-	user := &models.User{}
-	err := r.pool.QueryRow(ctx, query, username).Scan(
-		&user.ID,
-		&user.Username,
-		&user.PasswordHash,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+// RehashPassword replaces a password hash with a new hash of the same password, unless the password
+// has been changed since oldHash was read. It reports whether the hash was replaced.
+func (r *UserRepository) RehashPassword(ctx context.Context, id uuid.UUID, oldHash, newHash, algorithm string) (bool, error) {
+	query := `UPDATE users SET password_hash = $1, password_algorithm = $2 WHERE id = $3 AND password_hash = $4`
+	result, err := r.pool.Exec(ctx, query, newHash, algorithm, id, oldHash)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
 
+// SetEmail changes a user's email address, which then needs verifying again
+func (r *UserRepository) SetEmail(ctx context.Context, id uuid.UUID, email string) error {
+	query := `UPDATE users SET email = NULLIF($1, ''), email_verified_at = NULL, updated_at = NOW() WHERE id = $2`
+	result, err := r.pool.Exec(ctx, query, email, id)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrUserNotFound
+		if uniqueViolation(err, "idx_users_email") {
+			return ErrEmailExists
 		}
-		return nil, err
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
 	}
+	return nil
+}
 
-	return user, nil
+// MarkEmailVerified records that the user verified the given address. It does nothing if the user
+// has since changed their address, so a stale token can't verify a new one.
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id uuid.UUID, email string, verifiedAt time.Time) error {
+	query := `
+		UPDATE users SET email_verified_at = $1, updated_at = NOW()
+		WHERE id = $2 AND LOWER(email) = LOWER($3)
+	`
+	result, err := r.pool.Exec(ctx, query, verifiedAt, id, email)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
 
-func (r *UserRepository) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
-	query := `UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2`
-	result, err := r.pool.Exec(ctx, query, passwordHash, id)
+// SetReadOnly sets whether the user can only read
+func (r *UserRepository) SetReadOnly(ctx context.Context, id uuid.UUID, readOnly bool) error {
+	result, err := r.pool.Exec(ctx, `UPDATE users SET read_only = $1, updated_at = NOW() WHERE id = $2`, readOnly, id)
 	if err != nil {
 		return err
 	}
@@ -106,3 +167,30 @@ func (r *UserRepository) UpdatePassword(ctx context.Context, id uuid.UUID, passw
 	}
 	return nil
 }
+
+// CountDemos returns how many visitors' demo accounts exist
+func (r *UserRepository) CountDemos(ctx context.Context) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE demo_expires_at IS NOT NULL`).Scan(&count)
+	return count, err
+}
+
+// DeleteDemosExpiredBefore deletes visitors' demo accounts, with their notes, that stopped working
+// before the given time
+func (r *UserRepository) DeleteDemosExpiredBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.pool.Exec(ctx, `DELETE FROM users WHERE demo_expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// SetAdmins makes the users with the given lower-cased usernames administrators and returns how
+// many were promoted. Existing administrators are left as they are.
+func (r *UserRepository) SetAdmins(ctx context.Context, usernames []string) (int64, error) {
+	result, err := r.pool.Exec(ctx, `UPDATE users SET is_admin = TRUE, updated_at = NOW() WHERE LOWER(username) = ANY($1) AND NOT is_admin`, usernames)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
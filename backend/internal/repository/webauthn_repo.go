@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrWebAuthnCredentialNotFound = errors.New("passkey not found")
+	ErrWebAuthnCredentialExists   = errors.New("passkey already registered")
+	ErrWebAuthnSessionNotFound    = errors.New("passkey session not found, used or expired")
+)
+
+// WebAuthnRepository stores passkeys and the challenges issued to register and sign in with them
+type WebAuthnRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewWebAuthnRepository(pool *pgxpool.Pool) *WebAuthnRepository {
+	return &WebAuthnRepository{pool: pool}
+}
+
+const webAuthnCredentialColumns = `id, user_id, credential_id, public_key, sign_count, aaguid, transports, backup_eligible, name, created_at, last_used_at`
+
+func scanWebAuthnCredential(row pgx.Row) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	if err := row.Scan(&credential.ID, &credential.UserID, &credential.CredentialID, &credential.PublicKey, &credential.SignCount,
+		&credential.AAGUID, &credential.Transports, &credential.BackupEligible, &credential.Name, &credential.CreatedAt, &credential.LastUsedAt); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// CreateCredential records a newly registered passkey
+func (r *WebAuthnRepository) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	transports := credential.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO webauthn_credentials (id, user_id, credential_id, public_key, sign_count, aaguid, transports, backup_eligible, name, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, credential.ID, credential.UserID, credential.CredentialID, credential.PublicKey, credential.SignCount,
+		credential.AAGUID, transports, credential.BackupEligible, credential.Name, credential.CreatedAt)
+	if uniqueViolation(err, "webauthn_credentials_credential_id_key") {
+		return ErrWebAuthnCredentialExists
+	}
+	return err
+}
+
+// GetCredential returns the passkey with the ID the authenticator chose
+func (r *WebAuthnRepository) GetCredential(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	credential, err := scanWebAuthnCredential(r.pool.QueryRow(ctx, `
+		SELECT `+webAuthnCredentialColumns+` FROM webauthn_credentials WHERE credential_id = $1
+	`, credentialID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrWebAuthnCredentialNotFound
+	}
+	return credential, err
+}
+
+// ListCredentials returns a user's passkeys, oldest first
+func (r *WebAuthnRepository) ListCredentials(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+webAuthnCredentialColumns+` FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []models.WebAuthnCredential
+	for rows.Next() {
+		credential, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, *credential)
+	}
+	return credentials, rows.Err()
+}
+
+// CountCredentials returns how many passkeys a user has
+func (r *WebAuthnRepository) CountCredentials(ctx context.Context, userID uuid.UUID) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = $1`, userID).Scan(&count)
+	return count, err
+}
+
+// RecordUse stores a passkey's new signature counter after a sign-in. The counter is only moved
+// forwards, so of two sign-ins replaying the same counter only one succeeds.
+func (r *WebAuthnRepository) RecordUse(ctx context.Context, id uuid.UUID, previousSignCount, signCount int64) error {
+	result, err := r.pool.Exec(ctx, `
+		UPDATE webauthn_credentials SET sign_count = $3, last_used_at = NOW()
+		WHERE id = $1 AND sign_count = $2
+	`, id, previousSignCount, signCount)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+// DeleteCredential removes one of a user's passkeys
+func (r *WebAuthnRepository) DeleteCredential(ctx context.Context, id, userID uuid.UUID) error {
+	result, err := r.pool.Exec(ctx, `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+// CreateSession records a newly issued challenge
+func (r *WebAuthnRepository) CreateSession(ctx context.Context, session *models.WebAuthnSession) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO webauthn_sessions (id, user_id, ceremony, challenge, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, session.ID, session.UserID, session.Ceremony, session.Challenge, session.ExpiresAt)
+	return err
+}
+
+// UseSession removes and returns an unexpired session for the given ceremony, so each challenge can
+// only be answered once
+func (r *WebAuthnRepository) UseSession(ctx context.Context, id uuid.UUID, ceremony string) (*models.WebAuthnSession, error) {
+	var session models.WebAuthnSession
+	err := r.pool.QueryRow(ctx, `
+		DELETE FROM webauthn_sessions
+		WHERE id = $1 AND ceremony = $2 AND expires_at > NOW()
+		RETURNING id, user_id, ceremony, challenge, expires_at, created_at
+	`, id, ceremony).Scan(&session.ID, &session.UserID, &session.Ceremony, &session.Challenge, &session.ExpiresAt, &session.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWebAuthnSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// DeleteExpiredSessions removes challenges that were never answered
+func (r *WebAuthnRepository) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	result, err := r.pool.Exec(ctx, `DELETE FROM webauthn_sessions WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
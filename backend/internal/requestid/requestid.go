@@ -0,0 +1,19 @@
+// Package requestid carries the ID of the HTTP request that started a piece of
+// work, so the REST write, the WebSocket broadcast and any background job it
+// spawns can be correlated in the logs.
+package requestid
+
+import "context"
+
+type contextKey struct{}
+
+// WithContext returns a copy of ctx carrying the request ID
+func WithContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID stored in ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/mail"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+// activitySummaryBatchSize is how many subscriptions are loaded per query while sending
+const activitySummaryBatchSize = 100
+
+// activityPeriodFormat writes a summary period (a calendar month)
+const activityPeriodFormat = "2006-01"
+
+// ActivitySummaryService sends opted-in users a monthly email summarizing what they did in the
+// previous calendar month (UTC)
+type ActivitySummaryService struct {
+	activityRepo *repository.ActivityRepository
+	mailer       mail.Mailer
+	appBaseURL   string
+}
+
+func NewActivitySummaryService(activityRepo *repository.ActivityRepository, mailer mail.Mailer, appBaseURL string) *ActivitySummaryService {
+	return &ActivitySummaryService{
+		activityRepo: activityRepo,
+		mailer:       mailer,
+		appBaseURL:   strings.TrimRight(appBaseURL, "/"),
+	}
+}
+
+// previousMonth returns the start and end of the calendar month before the one containing now
+func previousMonth(now time.Time) (time.Time, time.Time) {
+	end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return end.AddDate(0, -1, 0), end
+}
+
+// Get returns the user's subscription and a preview of last month's summary
+func (s *ActivitySummaryService) Get(ctx context.Context, userID uuid.UUID) (*models.ActivitySummaryDTO, error) {
+	start, end := previousMonth(time.Now().UTC())
+	stats, err := s.activityRepo.Stats(ctx, userID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	dto := &models.ActivitySummaryDTO{Preview: activityStatsToDTO(stats)}
+
+	sub, err := s.activityRepo.GetSubscription(ctx, userID)
+	if errors.Is(err, repository.ErrActivitySubscriptionNotFound) {
+		return dto, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dto.Subscribed = true
+	dto.Email = sub.Email
+	if sub.LastPeriod != nil {
+		lastSentFor := sub.LastPeriod.Format(activityPeriodFormat)
+		dto.LastSentFor = &lastSentFor
+	}
+	return dto, nil
+}
+
+// Subscribe opts the user in. The first summary is sent after the current month ends.
+func (s *ActivitySummaryService) Subscribe(ctx context.Context, userID uuid.UUID, email string) (*models.ActivitySummaryDTO, error) {
+	lastPeriod, _ := previousMonth(time.Now().UTC())
+	if _, err := s.activityRepo.Subscribe(ctx, userID, strings.ToLower(strings.TrimSpace(email)), lastPeriod); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, userID)
+}
+
+// Unsubscribe opts the user out. Returns repository.ErrActivitySubscriptionNotFound if they weren't subscribed.
+func (s *ActivitySummaryService) Unsubscribe(ctx context.Context, userID uuid.UUID) error {
+	return s.activityRepo.Unsubscribe(ctx, userID)
+}
+
+// SendDue sends last month's summary to every subscriber who hasn't had it, returning how many
+// were sent. Subscribers with no activity are skipped. Stops at the first delivery failure so the
+// rest are retried on the next run.
+func (s *ActivitySummaryService) SendDue(ctx context.Context) (int, error) {
+	start, end := previousMonth(time.Now().UTC())
+	sent := 0
+	for {
+		subs, err := s.activityRepo.ListDue(ctx, start, activitySummaryBatchSize)
+		if err != nil {
+			return sent, err
+		}
+
+		for _, sub := range subs {
+			stats, err := s.activityRepo.Stats(ctx, sub.UserID, start, end)
+			if err != nil {
+				return sent, err
+			}
+			if !stats.IsEmpty() {
+				err := s.mailer.Send(ctx, mail.Message{
+					To:      sub.Email,
+					Subject: "Your notes in " + start.Format("January 2006"),
+					Body:    s.renderSummary(stats),
+				})
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to send activity summary", "user_id", sub.UserID.String(), "error", err)
+					return sent, err
+				}
+				sent++
+			}
+			if err := s.activityRepo.MarkSent(ctx, sub.UserID, start); err != nil {
+				return sent, err
+			}
+		}
+
+		if len(subs) < activitySummaryBatchSize {
+			return sent, nil
+		}
+	}
+}
+
+func (s *ActivitySummaryService) renderSummary(stats *models.ActivityStats) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Here's what you did in %s.\n\n", stats.PeriodStart.Format("January 2006"))
+	fmt.Fprintf(&b, "Notes created: %d\n", stats.NotesCreated)
+	fmt.Fprintf(&b, "Checklist items completed: %d\n", stats.ChecklistItemsCompleted)
+
+	if len(stats.MostEdited) > 0 {
+		b.WriteString("\nMost edited notes:\n")
+		for _, note := range stats.MostEdited {
+			title := note.Title
+			if title == "" {
+				title = "Untitled note"
+			}
+			edits := "edits"
+			if note.Edits == 1 {
+				edits = "edit"
+			}
+			fmt.Fprintf(&b, "- %s (%d %s)\n", title, note.Edits, edits)
+		}
+	}
+
+	fmt.Fprintf(&b, "\nYou're receiving this because you turned on monthly summaries. "+
+		"To stop them, turn them off in the app's settings:\n%s\n", s.appBaseURL)
+	return b.String()
+}
+
+func activityStatsToDTO(stats *models.ActivityStats) models.ActivityStatsDTO {
+	dto := models.ActivityStatsDTO{
+		Period:                  stats.PeriodStart.Format(activityPeriodFormat),
+		NotesCreated:            stats.NotesCreated,
+		ChecklistItemsCompleted: stats.ChecklistItemsCompleted,
+		MostEditedNotes:         make([]models.EditedNoteDTO, len(stats.MostEdited)),
+	}
+	for i, note := range stats.MostEdited {
+		dto.MostEditedNotes[i] = models.EditedNoteDTO{
+			NoteID: note.NoteID.String(),
+			Title:  note.Title,
+			Edits:  note.Edits,
+		}
+	}
+	return dto
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// API versions. Clients declare the version they were built against in the X-API-Version header
+// (none means 1), and responses are translated back to that version's shapes, so DTO fields can be
+// renamed or restructured without breaking older apps.
+//
+//	1: the original shapes
+//	2: SyncResponse.deletedNoteIDs is renamed deletedNoteIds
+//	3: PUT /api/notes/:id requires the version of the note being saved over
+const (
+	APIVersion1 = 1
+
+	// APIVersionNoteVersions is the first version that must send a note's version when updating it
+	APIVersionNoteVersions = 3
+
+	LatestAPIVersion = 3
+)
+
+// dtoChange is a change to a DTO's JSON shape made in version since. undo rewrites an encoded
+// object of the new shape in the shape it had before.
+type dtoChange struct {
+	since int
+	undo  func(fields map[string]json.RawMessage, version int) error
+}
+
+// dtoChanges lists the changes to each DTO by Go type name, oldest first. Add an entry, and bump
+// LatestAPIVersion, whenever a field is renamed, removed or changes type.
+var dtoChanges = map[string][]dtoChange{
+	"SyncResponse": {
+		{since: 2, undo: renameField("deletedNoteIds", "deletedNoteIDs")},
+	},
+}
+
+// nestedDTOs lists the fields of each DTO that hold other DTOs with changes, so they're translated too
+var nestedDTOs = map[string]map[string]string{
+	"SyncResponse": {"notes": "NoteDTO", "conflicts": "ConflictDTO"},
+}
+
+// TranslateDTO returns v encoded in the shape it had in version. Values whose type has no changes
+// since then are returned as is.
+func TranslateDTO(v any, version int) (any, error) {
+	if version >= LatestAPIVersion {
+		return v, nil
+	}
+
+	t := reflect.TypeOf(v)
+	isSlice := t != nil && t.Kind() == reflect.Slice
+	if isSlice {
+		t = t.Elem()
+	}
+	if t == nil || !hasChanges(t.Name()) {
+		return v, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if isSlice {
+		data, err = translateArray(data, t.Name(), version)
+	} else {
+		data, err = translateObject(data, t.Name(), version)
+	}
+	return json.RawMessage(data), err
+}
+
+// hasChanges reports whether a DTO, or one nested in it, has changed since version 1
+func hasChanges(typeName string) bool {
+	if len(dtoChanges[typeName]) > 0 {
+		return true
+	}
+	for _, nested := range nestedDTOs[typeName] {
+		if hasChanges(nested) {
+			return true
+		}
+	}
+	return false
+}
+
+func translateObject(data json.RawMessage, typeName string, version int) (json.RawMessage, error) {
+	if !hasChanges(typeName) || string(data) == "null" {
+		return data, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	for field, nestedType := range nestedDTOs[typeName] {
+		value, ok := fields[field]
+		if !ok || !hasChanges(nestedType) {
+			continue
+		}
+		translated, err := translateArrayOrObject(value, nestedType, version)
+		if err != nil {
+			return nil, err
+		}
+		fields[field] = translated
+	}
+
+	// Undo the newest changes first
+	changes := dtoChanges[typeName]
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].since > version {
+			if err := changes[i].undo(fields, version); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+func translateArray(data json.RawMessage, typeName string, version int) (json.RawMessage, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil || items == nil {
+		return data, err
+	}
+	for i, item := range items {
+		translated, err := translateObject(item, typeName, version)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = translated
+	}
+	return json.Marshal(items)
+}
+
+func translateArrayOrObject(data json.RawMessage, typeName string, version int) (json.RawMessage, error) {
+	if len(data) > 0 && data[0] == '[' {
+		return translateArray(data, typeName, version)
+	}
+	return translateObject(data, typeName, version)
+}
+
+// renameField undoes a rename from oldName to newName
+func renameField(newName, oldName string) func(map[string]json.RawMessage, int) error {
+	return func(fields map[string]json.RawMessage, _ int) error {
+		if value, ok := fields[newName]; ok {
+			fields[oldName] = value
+			delete(fields, newName)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/archive"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+// ArchiveService exports tamper-evident archives of a user's notes and history, for users who must
+// be able to show their notes weren't changed after the fact
+type ArchiveService struct {
+	repo         *repository.ArchiveRepository
+	noteRepo     *repository.NoteRepository
+	revisionRepo *repository.RevisionRepository
+	signer       *archive.Signer
+}
+
+// NewArchiveService creates the service; signingKey is the 32-byte Ed25519 seed archives are signed with
+func NewArchiveService(repo *repository.ArchiveRepository, noteRepo *repository.NoteRepository, revisionRepo *repository.RevisionRepository, signingKey []byte) *ArchiveService {
+	return &ArchiveService{
+		repo:         repo,
+		noteRepo:     noteRepo,
+		revisionRepo: revisionRepo,
+		signer:       archive.NewSigner(signingKey),
+	}
+}
+
+// Export builds a signed archive of every saved revision of the user's notes, oldest first, followed by
+// each note as it is now. It is chained onto the user's previous export and recorded, so exports can
+// only be added to, never replaced.
+func (s *ArchiveService) Export(ctx context.Context, userID uuid.UUID) (*models.Archive, error) {
+	export, a, err := s.newExport(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.revisionRepo.ListAllByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := s.noteRepo.GetAllByUserID(ctx, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	a.Entries = make([]models.ArchiveEntry, 0, len(revisions)+len(notes))
+
+	for _, revision := range revisions {
+		entry, err := archiveEntry(models.ArchiveEntryRevision, &revision.Note, revision.RecordedAt)
+		if err != nil {
+			return nil, err
+		}
+		a.Entries = append(a.Entries, entry)
+	}
+	for _, note := range notes {
+		entry, err := archiveEntry(models.ArchiveEntryNote, &note, note.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		a.Entries = append(a.Entries, entry)
+	}
+
+	if err := s.signer.Seal(a); err != nil {
+		return nil, err
+	}
+
+	export.HeadHash = a.HeadHash
+	export.EntryCount = a.EntryCount
+	if err := s.repo.Create(ctx, export); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ExportTo is Export for very large accounts: the archive is written to w entry by entry as it's
+// read from the database, so it's never held in memory. The export is recorded before the signature
+// is written, and an archive cut short by an error is left without one. The returned archive has no
+// entries.
+func (s *ArchiveService) ExportTo(ctx context.Context, userID uuid.UUID, w io.Writer) (*models.Archive, error) {
+	export, a, err := s.newExport(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sw := s.signer.NewStreamWriter(w, a)
+	err = s.revisionRepo.EachByUser(ctx, userID, func(revision *models.NoteRevision) error {
+		entry, err := archiveEntry(models.ArchiveEntryRevision, &revision.Note, revision.RecordedAt)
+		if err != nil {
+			return err
+		}
+		return sw.WriteEntry(&entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.noteRepo.EachByUserID(ctx, userID, nil, true, func(note *models.Note) error {
+		entry, err := archiveEntry(models.ArchiveEntryNote, note, note.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		return sw.WriteEntry(&entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sw.Sign()
+	export.HeadHash = a.HeadHash
+	export.EntryCount = a.EntryCount
+	if err := s.repo.Create(ctx, export); err != nil {
+		return nil, err
+	}
+	return a, sw.Close()
+}
+
+// newExport starts the record and archive of a new export, chained onto the user's latest one
+func (s *ArchiveService) newExport(ctx context.Context, userID uuid.UUID) (*models.ArchiveExport, *models.Archive, error) {
+	prevHash := archive.GenesisHash
+	latest, err := s.repo.Latest(ctx, userID)
+	if err == nil {
+		prevHash = latest.HeadHash
+	} else if !errors.Is(err, repository.ErrArchiveExportNotFound) {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	export := &models.ArchiveExport{
+		ID:        uuid.New(),
+		UserID:    userID,
+		PrevHash:  prevHash,
+		CreatedAt: now,
+	}
+	a := &models.Archive{
+		ExportID:  export.ID.String(),
+		UserID:    userID.String(),
+		CreatedAt: now.Format(ISO8601Format),
+		PrevHash:  prevHash,
+	}
+	return export, a, nil
+}
+
+func archiveEntry(kind string, note *models.Note, recordedAt time.Time) (models.ArchiveEntry, error) {
+	data, err := json.Marshal(note)
+	if err != nil {
+		return models.ArchiveEntry{}, err
+	}
+	return models.ArchiveEntry{
+		Kind:       kind,
+		NoteID:     note.ID.String(),
+		RecordedAt: recordedAt.UTC().Format(ISO8601Format),
+		Data:       data,
+	}, nil
+}
+
+// Verify checks that an archive is intact and was signed by this server. An archive that fails the
+// check is reported as invalid rather than returned as an error.
+func (s *ArchiveService) Verify(ctx context.Context, userID uuid.UUID, a *models.Archive) (*models.ArchiveVerificationDTO, error) {
+	result := &models.ArchiveVerificationDTO{
+		EntryCount: len(a.Entries),
+		HeadHash:   a.HeadHash,
+	}
+
+	seq, err := archive.Verify(a, s.signer.PublicKey())
+	if err != nil {
+		result.InvalidSeq = seq
+		result.Message = err.Error()
+		return result, nil
+	}
+	result.Valid = true
+
+	// Match the archive against the server's record, which also shows where it sits in the chain
+	exportID, err := uuid.Parse(a.ExportID)
+	if err != nil || a.UserID != userID.String() {
+		return result, nil
+	}
+	export, err := s.repo.GetByID(ctx, exportID, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrArchiveExportNotFound) {
+			return result, nil
+		}
+		return nil, err
+	}
+	result.Recorded = export.PrevHash == a.PrevHash && export.HeadHash == a.HeadHash
+	return result, nil
+}
+
+// List returns the user's exports, newest first
+func (s *ArchiveService) List(ctx context.Context, userID uuid.UUID) ([]models.ArchiveExportDTO, error) {
+	exports, err := s.repo.List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	dtos := make([]models.ArchiveExportDTO, len(exports))
+	for i, export := range exports {
+		dtos[i] = models.ArchiveExportDTO{
+			ID:         export.ID.String(),
+			PrevHash:   export.PrevHash,
+			HeadHash:   export.HeadHash,
+			EntryCount: export.EntryCount,
+			CreatedAt:  export.CreatedAt.UTC().Format(ISO8601Format),
+		}
+	}
+	return dtos, nil
+}
+
+// PublicKey returns the key archives are signed with
+func (s *ArchiveService) PublicKey() models.ArchivePublicKeyDTO {
+	return models.ArchivePublicKeyDTO{
+		Algorithm: models.ArchiveSignatureAlgorithm,
+		PublicKey: s.signer.PublicKey(),
+	}
+}
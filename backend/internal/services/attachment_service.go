@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/audio"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/storage"
+)
+
+var (
+	ErrUnsupportedAttachment = errors.New("unsupported audio format: upload m4a, caf or wav")
+	ErrAttachmentTooLarge    = errors.New("attachment too large")
+)
+
+// AttachmentService stores audio attachments and extracts their format and duration
+type AttachmentService struct {
+	repo     *repository.AttachmentRepository
+	noteRepo *repository.NoteRepository
+	quotas   *QuotaService
+	store    *storage.FileStore
+	maxBytes int64
+}
+
+func NewAttachmentService(repo *repository.AttachmentRepository, noteRepo *repository.NoteRepository, quotas *QuotaService, store *storage.FileStore, maxBytes int64) *AttachmentService {
+	return &AttachmentService{
+		repo:     repo,
+		noteRepo: noteRepo,
+		quotas:   quotas,
+		store:    store,
+		maxBytes: maxBytes,
+	}
+}
+
+// MaxBytes returns the largest attachment accepted
+func (s *AttachmentService) MaxBytes() int64 {
+	return s.maxBytes
+}
+
+// Upload stores an audio file for one of the user's notes, returning a *QuotaExceededError if it
+// would take the user past their total storage quota.
+// The format is detected from the file contents; the client's file name and type are not trusted.
+func (s *AttachmentService) Upload(ctx context.Context, userID, noteID uuid.UUID, filename string, r io.Reader) (*models.Attachment, error) {
+	if _, err := s.noteRepo.GetByID(ctx, noteID, userID); err != nil {
+		return nil, err
+	}
+
+	tmp, size, err := s.store.Copy(r, s.maxBytes)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileTooLarge) {
+			return nil, ErrAttachmentTooLarge
+		}
+		return nil, err
+	}
+	if err := s.quotas.CheckUpload(ctx, userID, size); err != nil {
+		s.store.Discard(tmp)
+		return nil, err
+	}
+
+	info, err := audio.Probe(tmp)
+	if err != nil {
+		s.store.Discard(tmp)
+		if errors.Is(err, audio.ErrUnsupportedFormat) || errors.Is(err, audio.ErrMalformed) {
+			return nil, ErrUnsupportedAttachment
+		}
+		return nil, err
+	}
+
+	attachment := &models.Attachment{
+		ID:          uuid.New(),
+		NoteID:      noteID,
+		UserID:      userID,
+		Filename:    sanitizeFilename(filename, info.Format),
+		ContentType: info.Format.ContentType(),
+		Format:      string(info.Format),
+		SizeBytes:   size,
+		DurationMs:  info.Duration.Milliseconds(),
+		CreatedAt:   time.Now(),
+	}
+
+	if err := s.store.Commit(tmp, attachment.ID); err != nil {
+		s.store.Discard(tmp)
+		return nil, err
+	}
+	tmp.Close()
+
+	if err := s.repo.Create(ctx, attachment); err != nil {
+		s.store.Delete(attachment.ID)
+		return nil, err
+	}
+
+	// Bump the note so incremental syncs pick up the new attachment
+	if err := s.noteRepo.Touch(ctx, noteID, userID); err != nil {
+		slog.WarnContext(ctx, "Failed to touch note after attachment upload", "note_id", noteID.String(), "error", err)
+	}
+
+	return attachment, nil
+}
+
+// List returns the attachments of a note the user owns or has been shared
+func (s *AttachmentService) List(ctx context.Context, userID, noteID uuid.UUID) ([]models.Attachment, error) {
+	if err := s.checkAccess(ctx, userID, noteID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetByNoteID(ctx, noteID)
+}
+
+// Open returns an attachment and its file for streaming. The caller must close the file.
+func (s *AttachmentService) Open(ctx context.Context, userID, attachmentID uuid.UUID) (*models.Attachment, *os.File, error) {
+	attachment, err := s.repo.GetByID(ctx, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkAccess(ctx, userID, attachment.NoteID); err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			return nil, nil, repository.ErrAttachmentNotFound
+		}
+		return nil, nil, err
+	}
+
+	f, err := s.store.Open(attachment.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			slog.ErrorContext(ctx, "Attachment has no stored file", "attachment_id", attachment.ID.String())
+			return nil, nil, repository.ErrAttachmentNotFound
+		}
+		return nil, nil, err
+	}
+	return attachment, f, nil
+}
+
+// Delete removes one of the user's attachments and its file, returning the deleted attachment
+func (s *AttachmentService) Delete(ctx context.Context, userID, attachmentID uuid.UUID) (*models.Attachment, error) {
+	attachment, err := s.repo.GetByID(ctx, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Delete(ctx, attachmentID, userID); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Delete(attachment.ID); err != nil {
+		slog.WarnContext(ctx, "Failed to delete file for attachment", "attachment_id", attachment.ID.String(), "error", err)
+	}
+
+	if err := s.noteRepo.Touch(ctx, attachment.NoteID, userID); err != nil {
+		slog.WarnContext(ctx, "Failed to touch note after attachment delete", "note_id", attachment.NoteID.String(), "error", err)
+	}
+
+	return attachment, nil
+}
+
+// checkAccess allows the note's owner and collaborators it has been shared with
+func (s *AttachmentService) checkAccess(ctx context.Context, userID, noteID uuid.UUID) error {
+	_, err := s.noteRepo.GetByID(ctx, noteID, userID)
+	if errors.Is(err, repository.ErrNoteNotFound) {
+		_, err = s.noteRepo.GetSharedByID(ctx, noteID, userID)
+	}
+	return err
+}
+
+// AttachmentToDTO converts an attachment for API responses
+func AttachmentToDTO(a *models.Attachment) models.AttachmentDTO {
+	return models.AttachmentDTO{
+		ID:          a.ID.String(),
+		NoteID:      a.NoteID.String(),
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Format:      a.Format,
+		SizeBytes:   a.SizeBytes,
+		DurationMs:  a.DurationMs,
+		URL:         "/api/attachments/" + a.ID.String(),
+		CreatedAt:   a.CreatedAt.UTC().Format(ISO8601Format),
+	}
+}
+
+// sanitizeFilename keeps the base name of an uploaded file without control characters,
+// with the extension of its detected format
+func sanitizeFilename(filename string, format audio.Format) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '\\' || r == '/' {
+			return -1
+		}
+		return r
+	}, filepath.Base(strings.ReplaceAll(filename, "\\", "/")))
+
+	name = strings.TrimSpace(strings.TrimSuffix(name, filepath.Ext(name)))
+	if name == "" || name == "." {
+		name = "Voice memo"
+	}
+
+	ext := "." + string(format)
+	if maxLen := models.MaxAttachmentFilenameLength - len(ext); len(name) > maxLen {
+		name = strings.ToValidUTF8(name[:maxLen], "")
+	}
+	return name + ext
+}
@@ -3,27 +3,52 @@ package services
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/clientinfo"
+	"github.com/hamishgilbert/notes-app/backend/internal/jwtkeys"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
 	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/passwordhash"
+	"github.com/hamishgilbert/notes-app/backend/internal/pwned"
 	"github.com/hamishgilbert/notes-app/backend/internal/repository"
 	"github.com/hamishgilbert/notes-app/backend/internal/validation"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserExists         = errors.New("username already exists")
+	ErrEmailExists        = errors.New("email already in use")
+	ErrEmailRequired      = errors.New("email address required")
+	ErrEmailNotVerified   = errors.New("email address not verified")
 	ErrInvalidToken       = errors.New("invalid token")
 	ErrTokenExpired       = errors.New("token expired")
 	ErrTokenRevoked       = errors.New("token revoked")
+	ErrTokenReused        = errors.New("refresh token reused")
 	ErrPasswordMismatch   = errors.New("current password is incorrect")
 	ErrWeakPassword       = errors.New("password does not meet complexity requirements")
+	ErrBreachedPassword   = errors.New("password has appeared in a data breach")
+	ErrAccountLocked      = errors.New("account temporarily locked after too many failed logins")
+	ErrInsufficientScope  = errors.New("token scope does not allow this request")
+	ErrInvalidScope       = errors.New("invalid token scope")
+	ErrDeviceMismatch     = errors.New("token was issued to another device")
+	ErrReadOnlyAccount    = errors.New("this account is read-only")
 )
 
+// AccountLockedError is returned by Login while the account is locked out. It matches
+// ErrAccountLocked.
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string { return ErrAccountLocked.Error() }
+
+func (e *AccountLockedError) Is(target error) bool { return target == ErrAccountLocked }
+
 // TokenType represents the type of JWT token
 type TokenType string
 
@@ -34,46 +59,103 @@ const (
 
 // TokenPair contains both access and refresh tokens
 type TokenPair struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int    `json:"expires_in"` // seconds until access token expires
+	AccessToken      string    `json:"access_token"`
+	RefreshToken     string    `json:"refresh_token"`
+	ExpiresIn        int       `json:"expires_in"` // seconds until access token expires
+	AccessExpiresAt  time.Time `json:"-"`
+	RefreshExpiresAt time.Time `json:"-"`
 }
 
+// TokenInfo describes a valid access token
+type TokenInfo struct {
+	UserID    uuid.UUID
+	SessionID uuid.UUID // uuid.Nil for tokens issued before sessions were tracked
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Scope     string // empty for tokens that can do anything the user can
+	ReadOnly  bool   // issued to a read-only account
+}
+
+// Scoped tokens last DefaultScopedTokenLifetime unless asked for otherwise, and at most
+// MaxScopedTokenLifetime
+const (
+	DefaultScopedTokenLifetime = 90 * 24 * time.Hour
+	MaxScopedTokenLifetime     = 365 * 24 * time.Hour
+)
+
 // Claims represents the JWT claims
 type Claims struct {
 	jwt.RegisteredClaims
 	TokenType TokenType `json:"type"`
+	FamilyID  string    `json:"fam,omitempty"`   // the login session the token belongs to; refresh tokens in it form a family
+	Scope     string    `json:"scope,omitempty"` // limits what an access token can do; empty = anything the user can
+	DeviceID  string    `json:"did,omitempty"`   // the X-Device-ID the token must be presented with; empty = any
+	ReadOnly  bool      `json:"ro,omitempty"`    // issued to a read-only account, so it can only read
 }
 
 type AuthService struct {
-	userRepo      *repository.UserRepository
-	blacklistRepo *repository.TokenBlacklistRepository
-	jwtSecret     []byte
-	accessExpiry  time.Duration
-	refreshExpiry time.Duration
+	userRepo       *repository.UserRepository
+	blacklistRepo  *repository.TokenBlacklistRepository
+	refreshRepo    *repository.RefreshTokenRepository
+	sessionRepo    *repository.SessionRepository
+	loginAttempts  *repository.LoginAttemptRepository
+	hasher         *passwordhash.Hasher
+	breached       pwned.Checker // nil = new passwords aren't screened
+	passwordPolicy validation.PasswordRequirements
+	keys           *jwtkeys.Keyring
+	accessExpiry   time.Duration
+	refreshExpiry  time.Duration
+
+	// requireVerification stops users logging in until they verify their email address
+	requireVerification bool
+
+	// bindDevice binds tokens issued to a client that sent X-Device-ID to that device ID
+	bindDevice bool
+
+	dispatcher *NotificationDispatcher // security alerts
 }
 
-func NewAuthService(userRepo *repository.UserRepository, blacklistRepo *repository.TokenBlacklistRepository, jwtSecret string, accessExpiryMinutes int, refreshExpiryHours int) *AuthService {
+func NewAuthService(userRepo *repository.UserRepository, blacklistRepo *repository.TokenBlacklistRepository, refreshRepo *repository.RefreshTokenRepository, sessionRepo *repository.SessionRepository, loginAttempts *repository.LoginAttemptRepository, hasher *passwordhash.Hasher, breached pwned.Checker, passwordPolicy validation.PasswordRequirements, keys *jwtkeys.Keyring, accessExpiryMinutes int, refreshExpiryHours int, requireVerification bool, bindDevice bool, dispatcher *NotificationDispatcher) *AuthService {
 	return &AuthService{
-		userRepo:      userRepo,
-		blacklistRepo: blacklistRepo,
-		jwtSecret:     []byte(jwtSecret),
-		accessExpiry:  time.Duration(accessExpiryMinutes) * time.Minute,
-		refreshExpiry: time.Duration(refreshExpiryHours) * time.Hour,
+		userRepo:       userRepo,
+		blacklistRepo:  blacklistRepo,
+		refreshRepo:    refreshRepo,
+		sessionRepo:    sessionRepo,
+		loginAttempts:  loginAttempts,
+		hasher:         hasher,
+		breached:       breached,
+		passwordPolicy: passwordPolicy,
+		keys:           keys,
+		accessExpiry:   time.Duration(accessExpiryMinutes) * time.Minute,
+		refreshExpiry:  time.Duration(refreshExpiryHours) * time.Hour,
+
+		requireVerification: requireVerification,
+		bindDevice:          bindDevice,
+		dispatcher:          dispatcher,
 	}
 }
 
-func (s *AuthService) Register(ctx context.Context, username, password string, clientIP string) (*models.User, *TokenPair, error) {
+// Register creates a user and logs them in. When email verification is required an address must be
+// given, and no tokens are returned until it has been verified.
+func (s *AuthService) Register(ctx context.Context, username, email, password string, clientIP string) (*models.User, *TokenPair, error) {
+	if s.requireVerification && email == "" {
+		return nil, nil, ErrEmailRequired
+	}
+
 	// Validate password complexity
-	if err := validation.ValidatePasswordDefault(password); err != nil {
-		log.Printf("[SECURITY] Registration rejected - weak password for username: %s from IP: %s - %v", username, clientIP, err)
-		return nil, nil, ErrWeakPassword
+	if err := s.ValidatePassword(password); err != nil {
+		logging.Security(ctx, "Registration rejected - weak password", "username", username, "ip", clientIP, "error", err)
+		return nil, nil, err
+	}
+	if err := s.CheckBreached(ctx, password); err != nil {
+		logging.Security(ctx, "Registration rejected - breached password", "username", username, "ip", clientIP)
+		return nil, nil, err
 	}
 
 	// Check if user exists
 	_, err := s.userRepo.GetByUsername(ctx, username)
 	if err == nil {
-		log.Printf("[SECURITY] Registration attempt with existing username: %s from IP: %s", username, clientIP)
+		logging.Security(ctx, "Registration attempt with existing username", "username", username, "ip", clientIP)
 		return nil, nil, ErrUserExists
 	}
 	if !errors.Is(err, repository.ErrUserNotFound) {
@@ -81,7 +163,7 @@ func (s *AuthService) Register(ctx context.Context, username, password string, c
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hasher.Hash(password)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -89,56 +171,210 @@ func (s *AuthService) Register(ctx context.Context, username, password string, c
 	// Create user
 	now := time.Now()
 	user := &models.User{
-		ID:           uuid.New(),
-		Username:     username,
-		PasswordHash: string(hashedPassword),
-		CreatedAt:    now,
-		UpdatedAt:    now,
+		ID:                uuid.New(),
+		Username:          username,
+		PasswordHash:      hashedPassword,
+		PasswordAlgorithm: passwordhash.Argon2id,
+		Email:             email,
+		CreatedAt:         now,
+		UpdatedAt:         now,
 	}
 
 	if err := s.userRepo.Create(ctx, user); err != nil {
 		if errors.Is(err, repository.ErrUserExists) {
 			return nil, nil, ErrUserExists
 		}
+		if errors.Is(err, repository.ErrEmailExists) {
+			return nil, nil, ErrEmailExists
+		}
 		return nil, nil, err
 	}
 
+	if s.requireVerification {
+		logging.Security(ctx, "User registered pending email verification", "username", username, "user_id", user.ID.String(), "ip", clientIP)
+		return user, nil, nil
+	}
+
 	// Generate token pair
-	tokens, err := s.generateTokenPair(user.ID)
+	tokens, err := s.startSession(ctx, user)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	log.Printf("[SECURITY] User registered successfully: %s from IP: %s", username, clientIP)
+	logging.Security(ctx, "User registered successfully", "username", username, "user_id", user.ID.String(), "ip", clientIP)
 	return user, tokens, nil
 }
 
 func (s *AuthService) Login(ctx context.Context, username, password string, clientIP string) (*models.User, *TokenPair, error) {
+	// Locked accounts are refused before the password is checked, so guesses can't go on
+	lockedUntil, err := s.loginAttempts.LockedUntil(ctx, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lockedUntil != nil {
+		logging.Security(ctx, "Login rejected - account locked", "username", username, "ip", clientIP)
+		return nil, nil, &AccountLockedError{Until: *lockedUntil}
+	}
+
 	user, err := s.userRepo.GetByUsername(ctx, username)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
-			log.Printf("[SECURITY] Failed login attempt - user not found: %s from IP: %s", username, clientIP)
-			return nil, nil, ErrInvalidCredentials
+			logging.Security(ctx, "Failed login attempt - user not found", "username", username, "ip", clientIP)
+			return nil, nil, s.recordLoginFailure(ctx, username, clientIP)
 		}
 		return nil, nil, err
 	}
 
 	// Compare password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		log.Printf("[SECURITY] Failed login attempt - invalid password for user: %s from IP: %s", username, clientIP)
-		return nil, nil, ErrInvalidCredentials
+	if !s.hasher.Verify(password, user.PasswordHash, user.PasswordAlgorithm) {
+		logging.Security(ctx, "Failed login attempt - invalid password", "username", username, "user_id", user.ID.String(), "ip", clientIP)
+		return nil, nil, s.recordLoginFailure(ctx, username, clientIP)
+	}
+	s.rehashPassword(ctx, user, password)
+
+	if err := s.loginAttempts.Reset(ctx, username); err != nil {
+		return nil, nil, err
+	}
+
+	// Users who gave an address must verify it first. Users who registered before verification was
+	// required have no address and aren't locked out.
+	if s.requireVerification && user.Email != "" && user.VerifiedAt == nil {
+		logging.Security(ctx, "Login rejected - email not verified", "username", username, "user_id", user.ID.String(), "ip", clientIP)
+		return nil, nil, ErrEmailNotVerified
 	}
 
 	// Generate token pair
-	tokens, err := s.generateTokenPair(user.ID)
+	tokens, err := s.startSession(ctx, user)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	log.Printf("[SECURITY] Successful login: %s from IP: %s", username, clientIP)
+	logging.Security(ctx, "Successful login", "username", username, "user_id", user.ID.String(), "ip", clientIP)
 	return user, tokens, nil
 }
 
+// rehashPassword replaces the hash of a password that just verified if it was made by bcrypt or with
+// other Argon2id parameters. Failing to is logged and the login carries on; it's retried next time.
+func (s *AuthService) rehashPassword(ctx context.Context, user *models.User, password string) {
+	if !s.hasher.NeedsRehash(user.PasswordHash, user.PasswordAlgorithm) {
+		return
+	}
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to rehash password", "username", user.Username, "error", err)
+		return
+	}
+	replaced, err := s.userRepo.RehashPassword(ctx, user.ID, user.PasswordHash, hashedPassword, passwordhash.Argon2id)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to rehash password", "username", user.Username, "error", err)
+		return
+	}
+	if replaced {
+		logging.Security(ctx, "Password hash upgraded", "username", user.Username, "from", user.PasswordAlgorithm, "to", passwordhash.Argon2id)
+		user.PasswordHash, user.PasswordAlgorithm = hashedPassword, passwordhash.Argon2id
+	}
+}
+
+// HashPassword hashes a new password, returning the hash and the algorithm to tag it with
+func (s *AuthService) HashPassword(password string) (string, string, error) {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return "", "", err
+	}
+	return hashedPassword, passwordhash.Argon2id, nil
+}
+
+// ValidatePassword checks a new password against the password policy. The error wraps
+// ErrWeakPassword and says which requirement wasn't met.
+func (s *AuthService) ValidatePassword(password string) error {
+	if err := validation.ValidatePassword(password, s.passwordPolicy); err != nil {
+		return fmt.Errorf("%w: %w", ErrWeakPassword, err)
+	}
+	return nil
+}
+
+// PasswordRequirements describes the password policy, for messages about passwords that don't meet it
+func (s *AuthService) PasswordRequirements() string {
+	return validation.PasswordRequirementsMessage(s.passwordPolicy)
+}
+
+// CheckBreached returns ErrBreachedPassword if a new password is known to have appeared in a data
+// breach. If the breach list can't be consulted the password is allowed, so an outage doesn't stop
+// sign-ups.
+func (s *AuthService) CheckBreached(ctx context.Context, password string) error {
+	if s.breached == nil {
+		return nil
+	}
+	breached, err := s.breached.Breached(ctx, password)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not screen password against breach list", "error", err)
+		return nil
+	}
+	if breached {
+		return ErrBreachedPassword
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed password login against the username, locking it once there
+// have been too many, and returns the error to report
+func (s *AuthService) recordLoginFailure(ctx context.Context, username, clientIP string) error {
+	lockedUntil, err := s.loginAttempts.RecordFailure(ctx, username, models.LoginFailureWindow, models.LoginLockoutDuration)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to record failed login", "username", username, "error", err)
+		return err
+	}
+	if lockedUntil != nil {
+		logging.Security(ctx, "Account locked after repeated failed logins", "username", username, "locked_until", lockedUntil.UTC(), "ip", clientIP)
+	}
+	return ErrInvalidCredentials
+}
+
+// Lockouts returns the usernames locked out after failed logins
+func (s *AuthService) Lockouts(ctx context.Context) ([]models.LoginAttempts, error) {
+	return s.loginAttempts.ListLocked(ctx)
+}
+
+// Unlock ends a username's lockout early
+func (s *AuthService) Unlock(ctx context.Context, username string) error {
+	return s.loginAttempts.Unlock(ctx, username)
+}
+
+// CleanupLoginAttempts removes failed login counts that have run out
+func (s *AuthService) CleanupLoginAttempts(ctx context.Context) (int64, error) {
+	return s.loginAttempts.DeleteStale(ctx, time.Now().Add(-models.LoginFailureWindow))
+}
+
+// LoginLockoutToDTO converts a lockout for the admin API
+func LoginLockoutToDTO(attempts *models.LoginAttempts) models.LoginLockoutDTO {
+	dto := models.LoginLockoutDTO{
+		Username:       attempts.Username,
+		FailedAttempts: attempts.FailedAttempts,
+		LastFailedAt:   attempts.LastFailedAt.UTC().Format(ISO8601Format),
+	}
+	if attempts.LockedUntil != nil {
+		dto.LockedUntil = attempts.LockedUntil.UTC().Format(ISO8601Format)
+	}
+	return dto
+}
+
+// LoginVerified logs in a user who proved who they are without their password, such as with a
+// passkey. The method is recorded in the security log.
+func (s *AuthService) LoginVerified(ctx context.Context, user *models.User, method string, clientIP string) (*TokenPair, error) {
+	if s.requireVerification && user.Email != "" && user.VerifiedAt == nil {
+		logging.Security(ctx, "Login rejected - email not verified", "method", method, "username", user.Username, "user_id", user.ID.String(), "ip", clientIP)
+		return nil, ErrEmailNotVerified
+	}
+
+	tokens, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Security(ctx, "Successful login", "method", method, "username", user.Username, "user_id", user.ID.String(), "ip", clientIP)
+	return tokens, nil
+}
+
 // ValidateToken validates an access token and returns the user ID
 func (s *AuthService) ValidateToken(tokenString string) (uuid.UUID, error) {
 	return s.ValidateTokenWithContext(context.Background(), tokenString)
@@ -146,26 +382,79 @@ func (s *AuthService) ValidateToken(tokenString string) (uuid.UUID, error) {
 
 // ValidateTokenWithContext validates an access token with context and returns the user ID
 func (s *AuthService) ValidateTokenWithContext(ctx context.Context, tokenString string) (uuid.UUID, error) {
+	userID, _, err := s.ValidateAccessToken(ctx, tokenString)
+	return userID, err
+}
+
+// ValidateAccessToken validates an access token and returns the user ID and the session it belongs
+// to. The session is uuid.Nil for tokens issued before sessions were tracked. Scoped tokens are
+// refused, since callers can't tell what they're allowed to do; AuthMiddleware checks scopes.
+func (s *AuthService) ValidateAccessToken(ctx context.Context, tokenString string) (userID, sessionID uuid.UUID, err error) {
+	info, err := s.AccessTokenInfo(ctx, tokenString)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	if info.Scope != "" {
+		return uuid.Nil, uuid.Nil, ErrInsufficientScope
+	}
+	return info.UserID, info.SessionID, nil
+}
+
+// JWKS returns the public keys tokens may be signed with; it's empty when tokens are HMAC-signed
+func (s *AuthService) JWKS() models.JWKSResponse {
+	return s.keys.JWKS(time.Now())
+}
+
+// AccessTokenInfo validates an access token and describes it
+func (s *AuthService) AccessTokenInfo(ctx context.Context, tokenString string) (*TokenInfo, error) {
 	claims, err := s.parseAndValidateToken(tokenString)
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
 
 	// Ensure it's an access token
-	if claims.TokenType != AccessToken {
-		return uuid.Nil, ErrInvalidToken
+	if claims.TokenType != AccessToken || claims.ExpiresAt == nil {
+		return nil, ErrInvalidToken
 	}
 
 	userID, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.Nil, ErrInvalidToken
+		return nil, ErrInvalidToken
 	}
 
 	// Check if token is revoked
 	if err := s.checkTokenRevoked(ctx, claims, userID); err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
+	if err := checkTokenDevice(ctx, claims, userID); err != nil {
+		return nil, err
+	}
+
+	info := &TokenInfo{UserID: userID, ExpiresAt: claims.ExpiresAt.Time, Scope: claims.Scope, ReadOnly: claims.ReadOnly}
+	if claims.IssuedAt != nil {
+		info.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.FamilyID != "" {
+		info.SessionID, _ = uuid.Parse(claims.FamilyID)
+	}
+	return info, nil
+}
 
+// AccessTokenSubject returns the user an access token was issued to, checking only its signature
+// and expiry. It doesn't look up whether the token has been revoked, so it's cheap enough to run on
+// every request, but only suits deciding whose budget a request is charged to.
+func (s *AuthService) AccessTokenSubject(tokenString string) (uuid.UUID, error) {
+	claims, err := s.parseAndValidateToken(tokenString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if claims.TokenType != AccessToken {
+		return uuid.Nil, ErrInvalidToken
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, ErrInvalidToken
+	}
 	return userID, nil
 }
 
@@ -195,10 +484,27 @@ func (s *AuthService) ValidateRefreshTokenWithContext(ctx context.Context, token
 	if err := s.checkTokenRevoked(ctx, claims, userID); err != nil {
 		return uuid.Nil, err
 	}
+	if err := checkTokenDevice(ctx, claims, userID); err != nil {
+		return uuid.Nil, err
+	}
 
 	return userID, nil
 }
 
+// checkTokenDevice rejects a device-bound token presented by a client with another device ID, as a
+// token copied off the device it was issued to would be
+func checkTokenDevice(ctx context.Context, claims *Claims, userID uuid.UUID) error {
+	if claims.DeviceID == "" {
+		return nil
+	}
+	info := clientinfo.FromContext(ctx)
+	if info.DeviceID != claims.DeviceID {
+		logging.Security(ctx, "Token bound to another device", "token_device", claims.DeviceID, "device", info.DeviceID, "user_id", userID.String(), "ip", info.IP)
+		return ErrDeviceMismatch
+	}
+	return nil
+}
+
 // checkTokenRevoked checks if a token has been revoked
 func (s *AuthService) checkTokenRevoked(ctx context.Context, claims *Claims, userID uuid.UUID) error {
 	if s.blacklistRepo == nil {
@@ -209,12 +515,30 @@ func (s *AuthService) checkTokenRevoked(ctx context.Context, claims *Claims, use
 	if claims.ID != "" {
 		revoked, err := s.blacklistRepo.IsTokenRevoked(ctx, claims.ID)
 		if err != nil {
-			log.Printf("[ERROR] Failed to check token blacklist: %v", err)
+			slog.ErrorContext(ctx, "Failed to check token blacklist", "error", err)
+			// Fail closed - reject token when we can't verify revocation status
+			return ErrInvalidToken
+		}
+		if revoked {
+			logging.Security(ctx, "Revoked token used", "user_id", userID.String())
+			return ErrTokenRevoked
+		}
+	}
+
+	// Check if the token's session was ended
+	if claims.FamilyID != "" && s.sessionRepo != nil {
+		sessionID, err := uuid.Parse(claims.FamilyID)
+		if err != nil {
+			return ErrInvalidToken
+		}
+		revoked, err := s.sessionRepo.IsRevoked(ctx, sessionID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check session revocation", "error", err)
 			// Fail closed - reject token when we can't verify revocation status
 			return ErrInvalidToken
 		}
 		if revoked {
-			log.Printf("[SECURITY] Revoked token used for user: %s", userID.String())
+			logging.Security(ctx, "Token from revoked session used", "user_id", userID.String(), "session_id", sessionID.String())
 			return ErrTokenRevoked
 		}
 	}
@@ -222,13 +546,13 @@ func (s *AuthService) checkTokenRevoked(ctx context.Context, claims *Claims, use
 	// Check if all tokens before a certain time are revoked
 	revokeAllTime, err := s.blacklistRepo.GetUserRevokeAllTime(ctx, userID)
 	if err != nil {
-		log.Printf("[ERROR] Failed to check revoke-all time: %v", err)
+		slog.ErrorContext(ctx, "Failed to check revoke-all time", "error", err)
 		// Fail closed - reject token when we can't verify revocation status
 		return ErrInvalidToken
 	}
 	if !revokeAllTime.IsZero() && claims.IssuedAt != nil {
 		if claims.IssuedAt.Time.Before(revokeAllTime) {
-			log.Printf("[SECURITY] Token issued before revoke-all time used for user: %s", userID.String())
+			logging.Security(ctx, "Token issued before revoke-all time used", "user_id", userID.String())
 			return ErrTokenRevoked
 		}
 	}
@@ -238,10 +562,31 @@ func (s *AuthService) checkTokenRevoked(ctx context.Context, claims *Claims, use
 
 func (s *AuthService) parseAndValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		now := time.Now()
+		kid, _ := token.Header["kid"].(string)
+		if kid != "" {
+			// The token must use the algorithm of the key it names, so a public key can't be
+			// passed off as an HMAC secret
+			key, err := s.keys.Verification(kid, now)
+			if err != nil {
+				return nil, err
+			}
+			if token.Method.Alg() != key.Algorithm {
+				return nil, ErrInvalidToken
+			}
+			return key.VerificationKey(), nil
+		}
+
+		// Tokens issued before keys had IDs are HMAC-signed, and tried against every secret still
+		// accepted
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, ErrInvalidToken
 		}
-		return s.jwtSecret, nil
+		var set jwt.VerificationKeySet
+		for _, secret := range s.keys.Secrets(now) {
+			set.Keys = append(set.Keys, secret)
+		}
+		return set, nil
 	})
 
 	if err != nil {
@@ -259,17 +604,23 @@ func (s *AuthService) parseAndValidateToken(tokenString string) (*Claims, error)
 	return claims, nil
 }
 
+// RequiresVerification reports whether users must verify their email address before logging in
+func (s *AuthService) RequiresVerification() bool {
+	return s.requireVerification
+}
+
 func (s *AuthService) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	return s.userRepo.GetByID(ctx, id)
 }
 
-// RefreshTokenPair generates a new token pair using a valid refresh token
-// Implements token rotation: the old refresh token is revoked after issuing new tokens
+// RefreshTokenPair exchanges a refresh token for a new token pair. Each refresh token can be used
+// once: the new refresh token continues the same family, and replaying a token that was already
+// used revokes the whole family, since either the client or an attacker holds a stolen copy.
 func (s *AuthService) RefreshTokenPair(ctx context.Context, refreshToken string, clientIP string) (*TokenPair, error) {
 	// Parse the refresh token to get claims (including token ID for revocation)
 	claims, err := s.parseAndValidateToken(refreshToken)
 	if err != nil {
-		log.Printf("[SECURITY] Failed token refresh attempt from IP: %s - %v", clientIP, err)
+		logging.Security(ctx, "Failed token refresh attempt", "ip", clientIP, "error", err)
 		return nil, err
 	}
 
@@ -284,26 +635,92 @@ func (s *AuthService) RefreshTokenPair(ctx context.Context, refreshToken string,
 
 	// Check if token is revoked
 	if err := s.checkTokenRevoked(ctx, claims, userID); err != nil {
-		log.Printf("[SECURITY] Revoked refresh token used from IP: %s", clientIP)
+		logging.Security(ctx, "Revoked refresh token used", "user_id", userID.String(), "ip", clientIP)
+		return nil, err
+	}
+	if err := checkTokenDevice(ctx, claims, userID); err != nil {
+		return nil, err
+	}
+
+	// The account may have been deleted or made read-only since the last refresh, and a visitor's
+	// demo account stops refreshing when it expires
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if user.DemoExpiresAt != nil && time.Now().After(*user.DemoExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	familyID, err := s.useRefreshToken(ctx, claims, userID, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.recordSession(ctx, familyID, userID); err != nil {
 		return nil, err
 	}
 
 	// Generate new token pair
-	tokens, err := s.generateTokenPair(userID)
+	tokens, err := s.generateTokenPair(ctx, user, familyID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Token rotation: revoke the old refresh token
-	if s.blacklistRepo != nil && claims.ID != "" && claims.ExpiresAt != nil {
-		if err := s.blacklistRepo.RevokeToken(ctx, claims.ID, userID, claims.ExpiresAt.Time); err != nil {
-			log.Printf("[ERROR] Failed to revoke old refresh token: %v", err)
-			// Don't fail the refresh, just log the error
+	logging.Security(ctx, "Token refreshed", "user_id", userID.String(), "ip", clientIP)
+	return tokens, nil
+}
+
+// useRefreshToken uses up a refresh token and returns the family its replacement belongs to
+func (s *AuthService) useRefreshToken(ctx context.Context, claims *Claims, userID uuid.UUID, clientIP string) (uuid.UUID, error) {
+	// Refresh tokens issued before rotation was tracked have no family. They start one, and are
+	// blacklisted so they can't start another.
+	if claims.FamilyID == "" {
+		if s.blacklistRepo != nil && claims.ID != "" && claims.ExpiresAt != nil {
+			if err := s.blacklistRepo.RevokeToken(ctx, claims.ID, userID, claims.ExpiresAt.Time); err != nil {
+				return uuid.Nil, err
+			}
+		}
+		return uuid.New(), nil
+	}
+
+	familyID, err := uuid.Parse(claims.FamilyID)
+	if err != nil {
+		return uuid.Nil, ErrInvalidToken
+	}
+	tokenID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return uuid.Nil, ErrInvalidToken
+	}
+
+	token, err := s.refreshRepo.Use(ctx, tokenID)
+	switch {
+	case errors.Is(err, repository.ErrRefreshTokenUsed):
+		if err := s.revokeSession(ctx, token.FamilyID); err != nil {
+			slog.ErrorContext(ctx, "Failed to revoke refresh token family", "family_id", token.FamilyID.String(), "error", err)
 		}
+		logging.Security(ctx, "Refresh token reuse detected - revoked token family", "user_id", userID.String(), "ip", clientIP, "family_id", token.FamilyID.String())
+		s.securityAlert(ctx, userID, models.NotificationTypeTokenReused, NotificationText{
+			Title: "You were signed out for your security",
+			Body: "A sign-in token from one of your devices was used twice, which can mean it was stolen. " +
+				"That device has been signed out; sign in again on it. If you don't recognize this, change your password.",
+		})
+		return uuid.Nil, ErrTokenReused
+	case errors.Is(err, repository.ErrRefreshTokenRevoked):
+		logging.Security(ctx, "Revoked refresh token used", "user_id", userID.String(), "ip", clientIP)
+		return uuid.Nil, ErrTokenRevoked
+	case errors.Is(err, repository.ErrRefreshTokenNotFound):
+		return uuid.Nil, ErrInvalidToken
+	case err != nil:
+		return uuid.Nil, err
 	}
 
-	log.Printf("[SECURITY] Token refreshed for user: %s from IP: %s", userID.String(), clientIP)
-	return tokens, nil
+	if token.UserID != userID || token.FamilyID != familyID {
+		return uuid.Nil, ErrInvalidToken
+	}
+	return familyID, nil
 }
 
 // Logout revokes the given access and refresh tokens
@@ -319,23 +736,28 @@ func (s *AuthService) Logout(ctx context.Context, accessToken, refreshToken stri
 			userID, _ := uuid.Parse(claims.Subject)
 			if claims.ExpiresAt != nil {
 				if err := s.blacklistRepo.RevokeToken(ctx, claims.ID, userID, claims.ExpiresAt.Time); err != nil {
-					log.Printf("[ERROR] Failed to revoke access token: %v", err)
+					slog.ErrorContext(ctx, "Failed to revoke access token", "error", err)
 				}
 			}
 		}
 	}
 
-	// Revoke refresh token
+	// Revoke refresh token, and the rest of its family
 	if refreshToken != "" {
 		claims, err := s.parseAndValidateToken(refreshToken)
 		if err == nil && claims.ID != "" {
 			userID, _ := uuid.Parse(claims.Subject)
+			if familyID, err := uuid.Parse(claims.FamilyID); err == nil {
+				if err := s.revokeSession(ctx, familyID); err != nil {
+					slog.ErrorContext(ctx, "Failed to revoke refresh token family", "error", err)
+				}
+			}
 			if claims.ExpiresAt != nil {
 				if err := s.blacklistRepo.RevokeToken(ctx, claims.ID, userID, claims.ExpiresAt.Time); err != nil {
-					log.Printf("[ERROR] Failed to revoke refresh token: %v", err)
+					slog.ErrorContext(ctx, "Failed to revoke refresh token", "error", err)
 				}
 			}
-			log.Printf("[SECURITY] User logged out: %s from IP: %s", userID.String(), clientIP)
+			logging.Security(ctx, "User logged out", "user_id", userID.String(), "ip", clientIP)
 		}
 	}
 
@@ -349,20 +771,28 @@ func (s *AuthService) LogoutAll(ctx context.Context, userID uuid.UUID, clientIP
 	}
 
 	if err := s.blacklistRepo.RevokeAllUserTokens(ctx, userID, time.Now()); err != nil {
-		log.Printf("[ERROR] Failed to revoke all tokens for user %s: %v", userID.String(), err)
+		slog.ErrorContext(ctx, "Failed to revoke all tokens", "user_id", userID.String(), "error", err)
+		return err
+	}
+	if err := s.refreshRepo.RevokeAllForUser(ctx, userID); err != nil {
+		slog.ErrorContext(ctx, "Failed to revoke refresh tokens", "user_id", userID.String(), "error", err)
+		return err
+	}
+	if err := s.sessionRepo.RevokeAllForUser(ctx, userID); err != nil {
+		slog.ErrorContext(ctx, "Failed to revoke sessions", "user_id", userID.String(), "error", err)
 		return err
 	}
 
-	log.Printf("[SECURITY] All tokens revoked for user: %s from IP: %s", userID.String(), clientIP)
+	logging.Security(ctx, "All tokens revoked", "user_id", userID.String(), "ip", clientIP)
 	return nil
 }
 
 // ChangePassword changes a user's password after verifying the current password
 func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword, newPassword, clientIP string) error {
 	// Validate new password complexity
-	if err := validation.ValidatePasswordDefault(newPassword); err != nil {
-		log.Printf("[SECURITY] Password change rejected - weak password for user ID: %s from IP: %s - %v", userID.String(), clientIP, err)
-		return ErrWeakPassword
+	if err := s.ValidatePassword(newPassword); err != nil {
+		logging.Security(ctx, "Password change rejected - weak password", "user_id", userID.String(), "ip", clientIP, "error", err)
+		return err
 	}
 
 	// Get user
@@ -372,32 +802,158 @@ func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, curr
 	}
 
 	// Verify current password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)); err != nil {
-		log.Printf("[SECURITY] Failed password change attempt - invalid current password for user: %s from IP: %s", user.Username, clientIP)
+	if !s.hasher.Verify(currentPassword, user.PasswordHash, user.PasswordAlgorithm) {
+		logging.Security(ctx, "Failed password change attempt - invalid current password", "username", user.Username, "user_id", userID.String(), "ip", clientIP)
 		return ErrPasswordMismatch
 	}
+	if err := s.CheckBreached(ctx, newPassword); err != nil {
+		logging.Security(ctx, "Password change rejected - breached password", "username", user.Username, "user_id", userID.String(), "ip", clientIP)
+		return err
+	}
 
 	// Hash new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	hashedPassword, err := s.hasher.Hash(newPassword)
 	if err != nil {
 		return err
 	}
 
 	// Update password
-	if err := s.userRepo.UpdatePassword(ctx, userID, string(hashedPassword)); err != nil {
+	if err := s.userRepo.UpdatePassword(ctx, userID, hashedPassword, passwordhash.Argon2id); err != nil {
 		return err
 	}
 
-	log.Printf("[SECURITY] Password changed successfully for user: %s from IP: %s", user.Username, clientIP)
+	logging.Security(ctx, "Password changed successfully", "username", user.Username, "user_id", userID.String(), "ip", clientIP)
+	s.securityAlert(ctx, userID, models.NotificationTypePasswordChanged, NotificationText{
+		Title: "Your password was changed",
+		Body:  "The password for " + user.Username + " was just changed. If this wasn't you, reset your password and sign out of all devices.",
+	})
 	return nil
 }
 
-// CleanupExpiredTokens removes expired tokens from the blacklist
+// securityAlert notifies the user of a security event on their account
+func (s *AuthService) securityAlert(ctx context.Context, userID uuid.UUID, notificationType models.NotificationType, text NotificationText) {
+	s.dispatcher.Dispatch(ctx, &models.Notification{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Type:      notificationType,
+		CreatedAt: time.Now(),
+	}, text)
+}
+
+// Sessions returns the user's active login sessions, most recently used first
+func (s *AuthService) Sessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	return s.sessionRepo.ListActive(ctx, userID, time.Now().Add(-s.refreshExpiry))
+}
+
+// RevokeSession signs one of the user's sessions out: its refresh token stops working and its
+// access tokens are rejected
+func (s *AuthService) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID, clientIP string) error {
+	if err := s.sessionRepo.RevokeForUser(ctx, sessionID, userID); err != nil {
+		return err
+	}
+	if err := s.refreshRepo.RevokeFamily(ctx, sessionID); err != nil {
+		return err
+	}
+	logging.Security(ctx, "Session revoked", "session_id", sessionID.String(), "user_id", userID.String(), "ip", clientIP)
+	return nil
+}
+
+// IssueScopedToken issues an access token limited to scope, such as a read-only one for a display.
+// It is recorded as a session named name, so it's listed and revoked like a login, and it lasts
+// lifetime (DefaultScopedTokenLifetime if zero) with no refresh token.
+func (s *AuthService) IssueScopedToken(ctx context.Context, userID uuid.UUID, scope, name string, lifetime time.Duration, clientIP string) (string, *models.Session, error) {
+	if !models.IsValidTokenScope(scope) {
+		return "", nil, ErrInvalidScope
+	}
+	if lifetime <= 0 {
+		lifetime = DefaultScopedTokenLifetime
+	}
+	lifetime = min(lifetime, MaxScopedTokenLifetime)
+
+	now := time.Now()
+	expiresAt := jwt.NewNumericDate(now.Add(lifetime))
+	info := clientinfo.FromContext(ctx)
+	session := &models.Session{
+		ID:        uuid.New(),
+		UserID:    userID,
+		UserAgent: info.UserAgent,
+		DeviceID:  info.DeviceID,
+		IPAddress: info.IP,
+		Scope:     scope,
+		Name:      name,
+		ExpiresAt: &expiresAt.Time,
+	}
+	if err := s.sessionRepo.Record(ctx, session); err != nil {
+		return "", nil, err
+	}
+
+	token, err := s.signToken(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			ExpiresAt: expiresAt,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ID:        uuid.New().String(),
+		},
+		TokenType: AccessToken,
+		FamilyID:  session.ID.String(),
+		Scope:     scope,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	logging.Security(ctx, "Scoped token issued", "scope", scope, "session_id", session.ID.String(), "user_id", userID.String(), "ip", clientIP)
+	return token, session, nil
+}
+
+// startSession records a new login session for the client making the request and issues its
+// first tokens
+func (s *AuthService) startSession(ctx context.Context, user *models.User) (*TokenPair, error) {
+	sessionID := uuid.New()
+	if err := s.recordSession(ctx, sessionID, user.ID); err != nil {
+		return nil, err
+	}
+	return s.generateTokenPair(ctx, user, sessionID)
+}
+
+// recordSession notes that a session was used by the client making the request
+func (s *AuthService) recordSession(ctx context.Context, sessionID, userID uuid.UUID) error {
+	info := clientinfo.FromContext(ctx)
+	return s.sessionRepo.Record(ctx, &models.Session{
+		ID:        sessionID,
+		UserID:    userID,
+		UserAgent: info.UserAgent,
+		DeviceID:  info.DeviceID,
+		IPAddress: info.IP,
+	})
+}
+
+// revokeSession ends a session and its refresh token family
+func (s *AuthService) revokeSession(ctx context.Context, sessionID uuid.UUID) error {
+	if err := s.refreshRepo.RevokeFamily(ctx, sessionID); err != nil {
+		return err
+	}
+	return s.sessionRepo.Revoke(ctx, sessionID)
+}
+
+// CleanupExpiredTokens removes expired tokens from the blacklist and the refresh token records,
+// and sessions none of whose tokens can still be valid
 func (s *AuthService) CleanupExpiredTokens(ctx context.Context) (int64, error) {
+	refreshCount, err := s.refreshRepo.DeleteExpired(ctx)
+	if err != nil {
+		return 0, err
+	}
+	sessionCount, err := s.sessionRepo.DeleteUnusedBefore(ctx, time.Now().Add(-s.refreshExpiry))
+	if err != nil {
+		return 0, err
+	}
+	refreshCount += sessionCount
 	if s.blacklistRepo == nil {
-		return 0, nil
+		return refreshCount, nil
 	}
-	return s.blacklistRepo.CleanupExpired(ctx)
+	blacklistCount, err := s.blacklistRepo.CleanupExpired(ctx)
+	return refreshCount + blacklistCount, err
 }
 
 // GenerateAccessToken generates only an access token (for backward compatibility)
@@ -405,21 +961,64 @@ func (s *AuthService) GenerateAccessToken(userID uuid.UUID) (string, error) {
 	return s.generateToken(userID, AccessToken, s.accessExpiry)
 }
 
-func (s *AuthService) generateTokenPair(userID uuid.UUID) (*TokenPair, error) {
-	accessToken, err := s.generateToken(userID, AccessToken, s.accessExpiry)
+// generateTokenPair issues an access token and a refresh token in the given family, recording the
+// refresh token so it can only be used once
+func (s *AuthService) generateTokenPair(ctx context.Context, user *models.User, familyID uuid.UUID) (*TokenPair, error) {
+	userID := user.ID
+	now := time.Now()
+	var deviceID string
+	if s.bindDevice {
+		deviceID = clientinfo.FromContext(ctx).DeviceID
+	}
+	accessExpiresAt := jwt.NewNumericDate(now.Add(s.accessExpiry))
+	accessToken, err := s.signToken(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			ExpiresAt: accessExpiresAt,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ID:        uuid.New().String(), // Unique token ID for revocation support
+		},
+		TokenType: AccessToken,
+		FamilyID:  familyID.String(),
+		DeviceID:  deviceID,
+		ReadOnly:  user.ReadOnly,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	refreshToken, err := s.generateToken(userID, RefreshToken, s.refreshExpiry)
+	record := &models.RefreshToken{
+		ID:        uuid.New(),
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: jwt.NewNumericDate(now.Add(s.refreshExpiry)).Time, // as precise as the token's exp
+	}
+	refreshToken, err := s.signToken(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			ExpiresAt: jwt.NewNumericDate(record.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ID:        record.ID.String(),
+		},
+		TokenType: RefreshToken,
+		FamilyID:  familyID.String(),
+		DeviceID:  deviceID,
+	})
 	if err != nil {
 		return nil, err
 	}
+	if err := s.refreshRepo.Create(ctx, record); err != nil {
+		return nil, err
+	}
 
 	return &TokenPair{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int(s.accessExpiry.Seconds()),
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        int(s.accessExpiry.Seconds()),
+		AccessExpiresAt:  accessExpiresAt.Time,
+		RefreshExpiresAt: record.ExpiresAt,
 	}, nil
 }
 
@@ -436,6 +1035,36 @@ func (s *AuthService) generateToken(userID uuid.UUID, tokenType TokenType, expir
 		TokenType: tokenType,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
+	return s.signToken(claims)
+}
+
+// signToken signs claims with the key currently scheduled for signing, naming it in the kid header
+func (s *AuthService) signToken(claims Claims) (string, error) {
+	key, err := s.keys.Signing(time.Now())
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.SigningKey())
+}
+
+// SessionToDTO converts a login session for the API
+func SessionToDTO(session *models.Session, current bool) models.SessionDTO {
+	dto := models.SessionDTO{
+		ID:         session.ID.String(),
+		DeviceID:   session.DeviceID,
+		DeviceName: session.DeviceName,
+		UserAgent:  session.UserAgent,
+		IPAddress:  session.IPAddress,
+		CreatedAt:  session.CreatedAt.Format(time.RFC3339),
+		LastSeenAt: session.LastSeenAt.Format(time.RFC3339),
+		Current:    current,
+		Scope:      session.Scope,
+		Name:       session.Name,
+	}
+	if session.ExpiresAt != nil {
+		dto.ExpiresAt = session.ExpiresAt.Format(time.RFC3339)
+	}
+	return dto
 }
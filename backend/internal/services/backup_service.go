@@ -0,0 +1,383 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/currentuser"
+	"github.com/hamishgilbert/notes-app/backend/internal/database"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// restoreBatchSize is how many rows are restored per round trip to the database
+const restoreBatchSize = 500
+
+var (
+	ErrRestoreRunning = errors.New("a restore is already running")
+	ErrInvalidBackup  = errors.New("invalid backup")
+)
+
+// errDryRun rolls back a dry run once the backup has been restored and checked
+var errDryRun = errors.New("dry run")
+
+func invalidBackup(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidBackup, fmt.Sprintf(format, args...))
+}
+
+// BackupService takes logical backups of the whole instance or one user, and restores them. Backups
+// are read from one snapshot, so they're consistent while users keep writing. Attachment files live
+// in the attachment store and are not included, only their records.
+type BackupService struct {
+	repo *repository.BackupRepository
+
+	mu        sync.Mutex
+	restoring bool
+}
+
+func NewBackupService(repo *repository.BackupRepository) *BackupService {
+	return &BackupService{repo: repo}
+}
+
+// backupTables returns the tables in a backup of one user, or of the whole instance
+func backupTables(userScoped bool) []repository.BackupTable {
+	if !userScoped {
+		return repository.BackupTables
+	}
+	var tables []repository.BackupTable
+	for _, table := range repository.BackupTables {
+		if table.UserFilter != "" {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// Backup writes a backup of the user, or of the whole instance if userID is uuid.Nil, to w. It
+// returns repository.ErrUserNotFound before writing anything if the user doesn't exist.
+func (s *BackupService) Backup(ctx context.Context, userID uuid.UUID, w io.Writer) error {
+	// Admins back up every user's rows, which row-level security would hide
+	ctx = currentuser.Without(ctx)
+
+	header := models.BackupHeader{
+		Format:    models.BackupFormat,
+		Version:   models.BackupVersion,
+		Scope:     models.BackupScopeInstance,
+		CreatedAt: time.Now().UTC(),
+	}
+	if userID != uuid.Nil {
+		header.Scope = models.BackupScopeUser
+		header.UserID = userID.String()
+	}
+
+	return s.repo.Snapshot(ctx, func(repo *repository.BackupRepository) error {
+		if userID != uuid.Nil {
+			exists, err := repo.UserExists(ctx, userID)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return repository.ErrUserNotFound
+			}
+		}
+
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(header); err != nil {
+			return err
+		}
+		var rows int64
+		for _, table := range backupTables(userID != uuid.Nil) {
+			err := repo.EachRow(ctx, table, userID, func(row json.RawMessage) error {
+				rows++
+				return enc.Encode(models.BackupRecord{Table: table.Name, Row: row})
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return enc.Encode(models.BackupRecord{End: true, Rows: rows})
+	})
+}
+
+// Restore replaces the instance's data, or one user's, with the backup read from r, in one
+// transaction. A problem with the backup, including a row the database refuses, is returned as
+// ErrInvalidBackup and leaves everything as it was. With dryRun set the backup is restored and
+// checked the same way, then rolled back.
+func (s *BackupService) Restore(ctx context.Context, r io.Reader, dryRun bool) (*models.RestoreResultDTO, error) {
+	s.mu.Lock()
+	if s.restoring {
+		s.mu.Unlock()
+		return nil, ErrRestoreRunning
+	}
+	s.restoring = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.restoring = false
+		s.mu.Unlock()
+	}()
+
+	ctx = currentuser.Without(ctx)
+
+	dec := json.NewDecoder(r)
+	var header models.BackupHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, invalidBackup("can't read the header: %v", err)
+	}
+	if header.Format != models.BackupFormat {
+		return nil, invalidBackup("not a notes backup")
+	}
+	if header.Version != models.BackupVersion {
+		return nil, invalidBackup("unsupported version %d", header.Version)
+	}
+	userID := uuid.Nil
+	switch header.Scope {
+	case models.BackupScopeInstance:
+	case models.BackupScopeUser:
+		id, err := uuid.Parse(header.UserID)
+		if err != nil {
+			return nil, invalidBackup("invalid user ID %q", header.UserID)
+		}
+		userID = id
+	default:
+		return nil, invalidBackup("unknown scope %q", header.Scope)
+	}
+
+	result := &models.RestoreResultDTO{
+		Scope:  header.Scope,
+		UserID: header.UserID,
+		DryRun: dryRun,
+		Tables: map[string]int64{},
+	}
+	err := database.WithTx(ctx, s.repo, func(tx pgx.Tx) error {
+		repo := s.repo.WithTx(tx)
+		var err error
+		if userID == uuid.Nil {
+			err = repo.DeleteAll(ctx)
+		} else {
+			err = repo.DeleteUserRows(ctx, backupTables(true), userID)
+		}
+		if err != nil {
+			return err
+		}
+
+		restorer := newBackupRestorer(repo, userID, result)
+		for {
+			var record models.BackupRecord
+			if err := dec.Decode(&record); err != nil {
+				if errors.Is(err, io.EOF) {
+					return invalidBackup("the backup is incomplete")
+				}
+				return invalidBackup("can't read row %d: %v", result.Rows+1, err)
+			}
+			if record.End {
+				if record.Rows != result.Rows {
+					return invalidBackup("the backup should have %d rows but has %d", record.Rows, result.Rows)
+				}
+				break
+			}
+			if err := restorer.add(ctx, &record); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+			return invalidBackup("unexpected data after the end of the backup")
+		}
+		if err := restorer.flush(ctx); err != nil {
+			return err
+		}
+
+		if userID != uuid.Nil {
+			// The user's notes were updated in place, so those the backup doesn't have are left to delete
+			if err := repo.DeleteNotesExcept(ctx, userID, restorer.noteIDs()); err != nil {
+				return err
+			}
+		} else {
+			if err := repo.ResetSequences(ctx); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	return result, nil
+}
+
+// backupRestorer checks a backup's rows and restores them in batches
+type backupRestorer struct {
+	repo   *repository.BackupRepository
+	userID uuid.UUID
+	result *models.RestoreResultDTO
+
+	tables   map[string]int // position of each table in the backup
+	position int            // position of the table of the last row
+	columns  map[string]map[string]bool
+
+	pending []repository.BackupRow
+	flushed int64
+
+	// IDs of the notes and sync batches restored, which rows hanging off them must name
+	owned map[string]map[uuid.UUID]bool
+}
+
+func newBackupRestorer(repo *repository.BackupRepository, userID uuid.UUID, result *models.RestoreResultDTO) *backupRestorer {
+	tables := map[string]int{}
+	for i, table := range backupTables(userID != uuid.Nil) {
+		tables[table.Name] = i
+	}
+	return &backupRestorer{
+		repo:    repo,
+		userID:  userID,
+		result:  result,
+		tables:  tables,
+		columns: map[string]map[string]bool{},
+		owned:   map[string]map[uuid.UUID]bool{"note_id": {}, "batch_id": {}},
+	}
+}
+
+// noteIDs returns the IDs of the notes restored
+func (r *backupRestorer) noteIDs() []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(r.owned["note_id"]))
+	for id := range r.owned["note_id"] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// add checks a row and queues it to be restored
+func (r *backupRestorer) add(ctx context.Context, record *models.BackupRecord) error {
+	n := r.result.Rows + 1
+	position, ok := r.tables[record.Table]
+	if !ok {
+		return invalidBackup("row %d: %q is not a table in a %s backup", n, record.Table, r.result.Scope)
+	}
+	// Rows are restored in the order given, which must follow the foreign keys
+	if position < r.position {
+		return invalidBackup("row %d: %s rows are out of order", n, record.Table)
+	}
+	r.position = position
+	table := backupTables(r.userID != uuid.Nil)[position]
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(record.Row, &fields); err != nil || fields == nil {
+		return invalidBackup("row %d: not a %s row", n, record.Table)
+	}
+	if err := r.checkOwner(record.Table, fields); err != nil {
+		return invalidBackup("row %d: %v", n, err)
+	}
+
+	columns, ok := r.columns[record.Table]
+	if !ok {
+		var err error
+		if columns, err = r.repo.Columns(ctx, record.Table); err != nil {
+			return err
+		}
+		r.columns[record.Table] = columns
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if !columns[name] {
+			return invalidBackup("row %d: %s has no column %q; the backup may be from a newer version", n, record.Table, name)
+		}
+		if r.userID != uuid.Nil && name == table.Renumbered {
+			continue
+		}
+		names = append(names, name)
+	}
+	// The same columns in the same order give the same statement, which pgx prepares once
+	sort.Strings(names)
+
+	row := repository.BackupRow{Table: record.Table, Columns: names, Row: record.Row}
+	if r.userID != uuid.Nil {
+		row.Owner = table.Owner
+	}
+	r.pending = append(r.pending, row)
+	r.result.Rows++
+	r.result.Tables[record.Table]++
+	if len(r.pending) >= restoreBatchSize {
+		return r.flush(ctx)
+	}
+	return nil
+}
+
+// checkOwner checks a row in a backup of one user belongs to that user: it names them, and rows
+// hanging off a note or sync batch hang off one restored from the backup
+func (r *backupRestorer) checkOwner(table string, fields map[string]json.RawMessage) error {
+	if r.userID == uuid.Nil {
+		return nil
+	}
+	owner := "user_id"
+	if table == "users" {
+		owner = "id"
+	}
+	if raw, ok := fields[owner]; ok {
+		var id uuid.UUID
+		if err := json.Unmarshal(raw, &id); err != nil || id != r.userID {
+			return fmt.Errorf("%s row belongs to another user", table)
+		}
+	}
+
+	for column, ids := range r.owned {
+		raw, ok := fields[column]
+		// Sync batches also list notes they deleted
+		if !ok || (table == "sync_batch_notes" && column == "note_id") {
+			continue
+		}
+		var id uuid.UUID
+		if err := json.Unmarshal(raw, &id); err != nil || !ids[id] {
+			return fmt.Errorf("%s row is for a %s not in the backup", table, strings.TrimSuffix(column, "_id"))
+		}
+	}
+
+	// Record the notes and sync batches, for the rows that follow them
+	switch table {
+	case "notes", "sync_batches":
+		var id uuid.UUID
+		if err := json.Unmarshal(fields["id"], &id); err != nil {
+			return fmt.Errorf("%s row has no valid id", table)
+		}
+		column := "note_id"
+		if table == "sync_batches" {
+			column = "batch_id"
+		}
+		r.owned[column][id] = true
+	}
+	return nil
+}
+
+// flush restores the queued rows
+func (r *backupRestorer) flush(ctx context.Context) error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+	err := r.repo.InsertRows(ctx, r.pending)
+	var rowErr *repository.BackupRowError
+	var pgErr *pgconn.PgError
+	if errors.As(err, &rowErr) && errors.As(err, &pgErr) {
+		return invalidBackup("row %d: %s: %s", r.flushed+int64(rowErr.Index)+1, r.pending[rowErr.Index].Table, pgErr.Message)
+	}
+	if errors.As(err, &rowErr) && errors.Is(err, repository.ErrBackupRowNotOwned) {
+		return invalidBackup("row %d: %s: %v", r.flushed+int64(rowErr.Index)+1, r.pending[rowErr.Index].Table, rowErr.Err)
+	}
+	if err != nil {
+		return err
+	}
+	r.flushed += int64(len(r.pending))
+	r.pending = r.pending[:0]
+	return nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+// coldStorageBatchSize is how many notes are moved per query while sweeping
+const coldStorageBatchSize = 100
+
+// ColdStorageService moves notes that have been archived and untouched for a number of months out of
+// the notes table, and brings them back on request
+type ColdStorageService struct {
+	repo     *repository.ColdStorageRepository
+	noteRepo *repository.NoteRepository
+	months   int
+}
+
+// NewColdStorageService creates the service; months of 0 stops notes being moved, while notes already in
+// cold storage can still be listed and restored
+func NewColdStorageService(repo *repository.ColdStorageRepository, noteRepo *repository.NoteRepository, months int) *ColdStorageService {
+	return &ColdStorageService{
+		repo:     repo,
+		noteRepo: noteRepo,
+		months:   months,
+	}
+}
+
+// MoveArchived moves every eligible note into cold storage, returning how many were moved
+func (s *ColdStorageService) MoveArchived(ctx context.Context) (int, error) {
+	if s.months <= 0 {
+		return 0, nil
+	}
+
+	before := time.Now().AddDate(0, -s.months, 0)
+	moved := 0
+	for {
+		notes, err := s.repo.GetMovable(ctx, before, coldStorageBatchSize)
+		if err != nil {
+			return moved, err
+		}
+
+		batchMoved := 0
+		for _, note := range notes {
+			ok, err := s.repo.Move(ctx, note.ID, note.UserID)
+			if err != nil {
+				return moved, err
+			}
+			if ok {
+				batchMoved++
+			}
+		}
+		moved += batchMoved
+
+		// Stop on the last batch, or if nothing could be moved (edited concurrently) to avoid spinning
+		if len(notes) < coldStorageBatchSize || batchMoved == 0 {
+			return moved, nil
+		}
+	}
+}
+
+// List returns a page of the user's cold-stored notes
+func (s *ColdStorageService) List(ctx context.Context, userID uuid.UUID, limit, offset int) ([]models.Note, error) {
+	return s.repo.List(ctx, userID, limit, offset)
+}
+
+// Get returns one of the user's cold-stored notes
+func (s *ColdStorageService) Get(ctx context.Context, userID, noteID uuid.UUID) (*models.Note, error) {
+	return s.repo.GetByID(ctx, noteID, userID)
+}
+
+// Restore moves a note back into the notes table, still archived. Its updated time is bumped so it
+// syncs to every client and isn't moved back out until it has been untouched for the full period again.
+func (s *ColdStorageService) Restore(ctx context.Context, userID, noteID uuid.UUID) (*models.Note, error) {
+	note, err := s.repo.GetByID(ctx, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	note.UserID = userID
+	note.UpdatedAt = time.Now()
+	if err := s.noteRepo.Create(ctx, note); err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Restored note from cold storage", "note_id", noteID.String())
+	return note, nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/crdt"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+// parseContentOps validates and groups the ops in a sync request, reporting each invalid group
+func parseContentOps(dtos []models.NoteOpsDTO) (map[uuid.UUID][]crdt.Op, []uuid.UUID, []models.SyncFailureDTO) {
+	byNote := make(map[uuid.UUID][]crdt.Op)
+	var order []uuid.UUID
+	var failures []models.SyncFailureDTO
+	total := 0
+	for i, dto := range dtos {
+		fail := func(message string) {
+			failures = append(failures, models.SyncFailureDTO{Field: SyncFieldContentOps, Index: i, NoteID: dto.NoteID, Message: message})
+		}
+
+		noteID, err := uuid.Parse(dto.NoteID)
+		if err != nil {
+			fail("invalid note ID")
+			continue
+		}
+
+		total += len(dto.Ops)
+		if total > models.MaxOpsPerSync {
+			fail(fmt.Sprintf("more than %d ops in one sync", models.MaxOpsPerSync))
+			break
+		}
+
+		ops := make([]crdt.Op, 0, len(dto.Ops))
+		for j, opDTO := range dto.Ops {
+			op, err := textOpFromDTO(opDTO)
+			if err != nil {
+				fail(fmt.Sprintf("op %d: %v", j, err))
+				break
+			}
+			ops = append(ops, op)
+		}
+		if len(ops) < len(dto.Ops) {
+			continue
+		}
+
+		if _, seen := byNote[noteID]; !seen {
+			order = append(order, noteID)
+		}
+		byNote[noteID] = append(byNote[noteID], ops...)
+	}
+	return byNote, order, failures
+}
+
+// applyContentOps stores a note's new ops and rewrites its content from the full op log.
+// Notes that don't exist or belong to someone else are skipped, like invalid changes.
+func (s *SyncService) applyContentOps(ctx context.Context, userID, noteID uuid.UUID, ops []crdt.Op) error {
+	note, err := s.noteRepo.GetByID(ctx, noteID, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	existing, err := s.opRepo.GetByNoteID(ctx, noteID)
+	if err != nil {
+		return err
+	}
+
+	text := crdt.NewText()
+	for _, stored := range existing {
+		if err := text.Apply(stored.Op); err != nil {
+			return err
+		}
+	}
+	for _, op := range ops {
+		if err := text.Apply(op); err != nil {
+			return err
+		}
+	}
+	if text.Len() > models.MaxContentLength {
+		return &SyncValidationError{Failures: []models.SyncFailureDTO{{
+			Field:   SyncFieldContentOps,
+			Index:   -1,
+			NoteID:  noteID.String(),
+			Message: fmt.Sprintf("content would exceed %d bytes", models.MaxContentLength),
+		}}}
+	}
+
+	if err := s.opRepo.Append(ctx, noteID, ops); err != nil {
+		return err
+	}
+
+	note.Content = text.String()
+	note.UpdatedAt = time.Now()
+	return s.noteRepo.UpdateContent(ctx, note)
+}
+
+// keepCRDTContent stops a full-text change from overwriting the content of a note edited in CRDT mode,
+// since the op log is the source of truth for it. Other fields of the change still apply.
+func (s *SyncService) keepCRDTContent(ctx context.Context, note *models.Note) error {
+	hasOps, err := s.opRepo.HasOps(ctx, note.ID)
+	if err != nil || !hasOps {
+		return err
+	}
+
+	existing, err := s.noteRepo.GetByID(ctx, note.ID, note.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			return nil
+		}
+		return err
+	}
+	note.Content = existing.Content
+	return nil
+}
+
+// contentOpsSince returns the ops on the user's notes after lastSeq, grouped by note, and the
+// sequence number the client should send next time
+func (s *SyncService) contentOpsSince(ctx context.Context, userID uuid.UUID, lastSeq int64) ([]models.NoteOpsDTO, int64, error) {
+	ops, err := s.opRepo.GetSince(ctx, userID, lastSeq)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var dtos []models.NoteOpsDTO
+	index := make(map[uuid.UUID]int)
+	for _, op := range ops {
+		i, ok := index[op.NoteID]
+		if !ok {
+			i = len(dtos)
+			index[op.NoteID] = i
+			dtos = append(dtos, models.NoteOpsDTO{NoteID: op.NoteID.String()})
+		}
+		dto := textOpToDTO(op.Op)
+		dto.Seq = op.Seq
+		dtos[i].Ops = append(dtos[i].Ops, dto)
+		lastSeq = max(lastSeq, op.Seq)
+	}
+	return dtos, lastSeq, nil
+}
+
+func textOpFromDTO(dto models.TextOpDTO) (crdt.Op, error) {
+	id, err := crdt.ParseID(dto.ID)
+	if err != nil {
+		return crdt.Op{}, err
+	}
+	ref, err := crdt.ParseID(dto.Ref)
+	if err != nil {
+		return crdt.Op{}, err
+	}
+	op := crdt.Op{ID: id, Type: crdt.OpType(dto.Type), Ref: ref, Value: dto.Value}
+	return op, op.Validate()
+}
+
+func textOpToDTO(op crdt.Op) models.TextOpDTO {
+	return models.TextOpDTO{
+		ID:    op.ID.String(),
+		Type:  string(op.Type),
+		Ref:   op.Ref.String(),
+		Value: op.Value,
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+var ErrInvalidDevicePlatform = errors.New("invalid platform: use 'ios', 'macos', 'android', 'web' or 'other'")
+
+// DeviceService tracks the devices a user syncs from and how far each has synced, so the user can
+// spot and revoke devices that have stopped syncing
+type DeviceService struct {
+	deviceRepo *repository.DeviceRepository
+}
+
+func NewDeviceService(deviceRepo *repository.DeviceRepository) *DeviceService {
+	return &DeviceService{deviceRepo: deviceRepo}
+}
+
+// Register adds or renames a device
+func (s *DeviceService) Register(ctx context.Context, userID uuid.UUID, req *models.RegisterDeviceRequest) (*models.Device, error) {
+	platform := models.DevicePlatform(req.Platform)
+	if !models.ValidDevicePlatforms[platform] {
+		return nil, ErrInvalidDevicePlatform
+	}
+	return s.deviceRepo.Register(ctx, &models.Device{
+		UserID:   userID,
+		DeviceID: req.DeviceID,
+		Name:     req.Name,
+		Platform: platform,
+	})
+}
+
+// List returns the user's devices, most recently seen first
+func (s *DeviceService) List(ctx context.Context, userID uuid.UUID) ([]models.Device, error) {
+	return s.deviceRepo.ListByUser(ctx, userID)
+}
+
+// Revoke stops a device from syncing
+func (s *DeviceService) Revoke(ctx context.Context, userID, id uuid.UUID) (*models.Device, error) {
+	return s.deviceRepo.Revoke(ctx, id, userID)
+}
+
+// CheckCanSync returns repository.ErrDeviceRevoked if deviceID belongs to a revoked device. Devices
+// that haven't registered can sync; they just aren't tracked.
+func (s *DeviceService) CheckCanSync(ctx context.Context, userID uuid.UUID, deviceID string) error {
+	device, err := s.deviceRepo.GetByDeviceID(ctx, userID, deviceID)
+	if err != nil {
+		if errors.Is(err, repository.ErrDeviceNotFound) {
+			return nil
+		}
+		return err
+	}
+	if device.RevokedAt != nil {
+		return repository.ErrDeviceRevoked
+	}
+	return nil
+}
+
+// Name returns the name a device was registered with, or "" if it isn't registered
+func (s *DeviceService) Name(ctx context.Context, userID uuid.UUID, deviceID string) (string, error) {
+	device, err := s.deviceRepo.GetByDeviceID(ctx, userID, deviceID)
+	if err != nil {
+		if errors.Is(err, repository.ErrDeviceNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return device.Name, nil
+}
+
+// RecordSync stores the serverTimestamp a device received from a sync as its cursor
+func (s *DeviceService) RecordSync(ctx context.Context, userID uuid.UUID, deviceID, serverTimestamp string) error {
+	cursor, err := time.Parse(ISO8601Format, serverTimestamp)
+	if err != nil {
+		return err
+	}
+	return s.deviceRepo.RecordSync(ctx, userID, deviceID, cursor)
+}
+
+// DeviceToDTO converts a device for API responses
+func DeviceToDTO(d *models.Device) models.DeviceDTO {
+	dto := models.DeviceDTO{
+		ID:         d.ID.String(),
+		DeviceID:   d.DeviceID,
+		Name:       d.Name,
+		Platform:   string(d.Platform),
+		LastSeenAt: d.LastSeenAt.UTC().Format(ISO8601Format),
+		CreatedAt:  d.CreatedAt.UTC().Format(ISO8601Format),
+		IsStale:    d.LastSyncAt == nil || time.Since(*d.LastSyncAt) > models.DeviceStaleAfter,
+	}
+	if d.LastSyncAt != nil {
+		lastSync := d.LastSyncAt.UTC().Format(ISO8601Format)
+		dto.LastSyncAt = &lastSync
+	}
+	if d.RevokedAt != nil {
+		revokedAt := d.RevokedAt.UTC().Format(ISO8601Format)
+		dto.RevokedAt = &revokedAt
+	}
+	return dto
+}
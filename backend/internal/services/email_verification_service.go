@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/mail"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrVerificationDelivery     = errors.New("failed to send verification email")
+)
+
+// emailVerificationExpiry is how long a verification link stays valid
+const emailVerificationExpiry = 24 * time.Hour
+
+// EmailVerificationService confirms that users can receive mail at the address they gave
+type EmailVerificationService struct {
+	userRepo   *repository.UserRepository
+	tokenRepo  *repository.EmailVerificationRepository
+	mailer     mail.Mailer
+	appBaseURL string
+}
+
+func NewEmailVerificationService(userRepo *repository.UserRepository, tokenRepo *repository.EmailVerificationRepository, mailer mail.Mailer, appBaseURL string) *EmailVerificationService {
+	return &EmailVerificationService{
+		userRepo:   userRepo,
+		tokenRepo:  tokenRepo,
+		mailer:     mailer,
+		appBaseURL: strings.TrimRight(appBaseURL, "/"),
+	}
+}
+
+// SendVerification emails the user a link to verify their address. It does nothing if the user has
+// no address or has already verified it.
+func (s *EmailVerificationService) SendVerification(ctx context.Context, user *models.User) error {
+	if user.Email == "" || user.VerifiedAt != nil {
+		return nil
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	record := &models.EmailVerificationToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Email:     user.Email,
+		TokenHash: hashVerificationToken(token),
+		ExpiresAt: now.Add(emailVerificationExpiry),
+	}
+	if err := s.tokenRepo.Create(ctx, record); err != nil {
+		return err
+	}
+
+	link := s.appBaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\n"+
+		"Confirm this is your email address by opening the link below:\n%s\n\n"+
+		"The link expires on %s. If you didn't sign up, you can ignore this email.\n",
+		user.Username, link, record.ExpiresAt.UTC().Format("2 Jan 2006 15:04 MST"))
+
+	err := s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    body,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to send verification email", "user_id", user.ID.String(), "error", err)
+		return ErrVerificationDelivery
+	}
+	return nil
+}
+
+// Verify uses up a token and marks the address it was sent to as verified
+func (s *EmailVerificationService) Verify(ctx context.Context, token string) (*models.User, error) {
+	record, err := s.tokenRepo.Use(ctx, hashVerificationToken(token))
+	if err != nil {
+		if errors.Is(err, repository.ErrVerificationTokenNotFound) {
+			return nil, ErrInvalidVerificationToken
+		}
+		return nil, err
+	}
+
+	// Fails if the user changed their address after the token was sent
+	if err := s.userRepo.MarkEmailVerified(ctx, record.UserID, record.Email, time.Now()); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrInvalidVerificationToken
+		}
+		return nil, err
+	}
+
+	logging.Security(ctx, "Email verified", "user_id", record.UserID.String())
+	return s.userRepo.GetByID(ctx, record.UserID)
+}
+
+// Resend sends a new verification link to an unverified address. It reports success whether or not
+// the address belongs to anyone, so it can't be used to find out which addresses have accounts.
+func (s *EmailVerificationService) Resend(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := s.SendVerification(ctx, user); err != nil && !errors.Is(err, ErrVerificationDelivery) {
+		return err
+	}
+	return nil
+}
+
+// ChangeEmail sets the user's address and sends a link to verify it
+func (s *EmailVerificationService) ChangeEmail(ctx context.Context, userID uuid.UUID, email string) (*models.User, error) {
+	if err := s.userRepo.SetEmail(ctx, userID, email); err != nil {
+		if errors.Is(err, repository.ErrEmailExists) {
+			return nil, ErrEmailExists
+		}
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	logging.Security(ctx, "Email changed", "user_id", userID.String())
+	return user, s.SendVerification(ctx, user)
+}
+
+// CleanupExpired removes used and expired tokens
+func (s *EmailVerificationService) CleanupExpired(ctx context.Context) (int64, error) {
+	return s.tokenRepo.DeleteExpired(ctx)
+}
+
+// hashVerificationToken is the hex SHA-256 of a token, which is what's stored
+func hashVerificationToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,353 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/pdf"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
+	"github.com/hamishgilbert/notes-app/backend/internal/storage"
+)
+
+// exportJobTimeout bounds how long one export may run; jobs still unfinished after it are failed
+const exportJobTimeout = 10 * time.Minute
+
+var (
+	ErrNoNotesToExport = errors.New("no notes match the export filter")
+	ErrTooManyNotes    = fmt.Errorf("exports are limited to %d notes", models.MaxExportNotes)
+	ErrExportNotReady  = errors.New("export has not completed")
+	ErrInvalidExportID = errors.New("invalid note ID in export filter")
+)
+
+// ExportService exports the notes matching a filter as one file, in the background
+type ExportService struct {
+	jobRepo  *repository.ExportJobRepository
+	noteRepo *repository.NoteRepository
+	store    *storage.FileStore
+}
+
+func NewExportService(jobRepo *repository.ExportJobRepository, noteRepo *repository.NoteRepository, store *storage.FileStore) *ExportService {
+	return &ExportService{
+		jobRepo:  jobRepo,
+		noteRepo: noteRepo,
+		store:    store,
+	}
+}
+
+// Start records an export job and runs it in the background
+func (s *ExportService) Start(ctx context.Context, userID uuid.UUID, req *models.CreateExportRequest) (*models.ExportJob, error) {
+	filter := models.ExportFilter{
+		Query:           strings.TrimSpace(req.Query),
+		IncludeArchived: req.IncludeArchived,
+	}
+	for _, idStr := range req.NoteIDs {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			return nil, ErrInvalidExportID
+		}
+		filter.NoteIDs = append(filter.NoteIDs, id)
+	}
+
+	job := &models.ExportJob{
+		ID:              uuid.New(),
+		UserID:          userID,
+		Status:          models.ExportJobPending,
+		Format:          models.ExportFormat(req.Format),
+		Filter:          filter,
+		Paper:           req.Paper,
+		IncludeMetadata: req.IncludeMetadata,
+		CreatedAt:       time.Now(),
+	}
+	if job.Format == models.ExportFormatPDF && job.Paper == "" {
+		job.Paper = "a4"
+	}
+	if err := s.jobRepo.Create(ctx, job); err != nil {
+		return nil, err
+	}
+
+	requestID := requestid.FromContext(ctx)
+
+	go func() {
+		jobCtx := requestid.WithContext(context.Background(), requestID)
+		jobCtx, cancel := context.WithTimeout(jobCtx, exportJobTimeout)
+		defer cancel()
+
+		if err := s.run(jobCtx, job); err != nil {
+			slog.WarnContext(jobCtx, "Export job failed", "export_id", job.ID.String(), "error", err)
+			message := "export failed"
+			if errors.Is(err, ErrNoNotesToExport) || errors.Is(err, ErrTooManyNotes) {
+				message = err.Error()
+			}
+			if err := s.jobRepo.Fail(context.Background(), job.ID, message); err != nil {
+				slog.ErrorContext(jobCtx, "Failed to record export job failure", "export_id", job.ID.String(), "error", err)
+			}
+		}
+	}()
+
+	return job, nil
+}
+
+func (s *ExportService) run(ctx context.Context, job *models.ExportJob) error {
+	if err := s.jobRepo.SetStatus(ctx, job.ID, models.ExportJobRunning); err != nil {
+		return err
+	}
+
+	notes, err := s.matchingNotes(ctx, job.UserID, job.Filter)
+	if err != nil {
+		return err
+	}
+
+	data, ext, err := renderExport(job, notes)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := s.store.CreateTemp()
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		s.store.Discard(tmp)
+		return err
+	}
+	if err := s.store.Commit(tmp, job.ID); err != nil {
+		s.store.Discard(tmp)
+		return err
+	}
+	tmp.Close()
+
+	now := time.Now()
+	job.Filename = "notes-export-" + job.CreatedAt.UTC().Format("2006-01-02") + ext
+	job.SizeBytes = int64(len(data))
+	job.NoteCount = len(notes)
+	job.CompletedAt = &now
+	return s.jobRepo.Complete(ctx, job)
+}
+
+// matchingNotes returns the user's notes that match the filter: in the order given when note IDs are
+// listed, otherwise in the main list's order
+func (s *ExportService) matchingNotes(ctx context.Context, userID uuid.UUID, filter models.ExportFilter) ([]models.Note, error) {
+	all, err := s.noteRepo.GetAllByUserID(ctx, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := all
+	if len(filter.NoteIDs) > 0 {
+		byID := make(map[uuid.UUID]models.Note, len(all))
+		for _, note := range all {
+			byID[note.ID] = note
+		}
+		candidates = make([]models.Note, 0, len(filter.NoteIDs))
+		for _, id := range filter.NoteIDs {
+			if note, ok := byID[id]; ok {
+				candidates = append(candidates, note)
+				delete(byID, id) // each note once, even if listed twice
+			}
+		}
+	}
+
+	query := strings.ToLower(filter.Query)
+	notes := []models.Note{}
+	for _, note := range candidates {
+		if note.IsArchived && !filter.IncludeArchived {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(noteText(&note)), query) {
+			continue
+		}
+		notes = append(notes, note)
+	}
+
+	if len(notes) == 0 {
+		return nil, ErrNoNotesToExport
+	}
+	if len(notes) > models.MaxExportNotes {
+		return nil, ErrTooManyNotes
+	}
+	return notes, nil
+}
+
+// renderExport produces the export file and its extension
+func renderExport(job *models.ExportJob, notes []models.Note) ([]byte, string, error) {
+	switch job.Format {
+	case models.ExportFormatPDF:
+		pageSize, ok := pdf.ParsePageSize(job.Paper)
+		if !ok {
+			pageSize = pdf.PageSizeA4
+		}
+		data := RenderNotesPDF("Notes export", notes, NotePDFOptions{
+			PageSize:        pageSize,
+			IncludeMetadata: job.IncludeMetadata,
+		})
+		return data, ".pdf", nil
+	case models.ExportFormatMarkdown:
+		parts := make([]string, len(notes))
+		for i := range notes {
+			parts[i] = noteMarkdown(&notes[i], job.IncludeMetadata)
+		}
+		return []byte(strings.Join(parts, "\n---\n\n")), ".md", nil
+	case models.ExportFormatZip:
+		data, err := notesZip(notes, job.IncludeMetadata)
+		return data, ".zip", err
+	default:
+		return nil, "", fmt.Errorf("unknown export format %q", job.Format)
+	}
+}
+
+// noteMarkdown renders a note as a Markdown document
+func noteMarkdown(note *models.Note, includeMetadata bool) string {
+	var b strings.Builder
+	b.WriteString("# " + notePDFTitle(note) + "\n\n")
+
+	if includeMetadata {
+		for _, line := range notePDFMetadataLines(note) {
+			b.WriteString("_" + line + "_  \n")
+		}
+		b.WriteString("\n")
+	}
+
+	if note.Content != "" {
+		if note.NoteType == models.NoteTypeCode {
+			b.WriteString("```" + note.Language + "\n" + note.Content + "\n```\n")
+		} else {
+			b.WriteString(note.Content + "\n")
+		}
+	}
+
+	if len(note.ChecklistItems) > 0 {
+		if note.Content != "" {
+			b.WriteString("\n")
+		}
+		for _, item := range note.ChecklistItems {
+			box := "[ ]"
+			if item.IsCompleted {
+				box = "[x]"
+			}
+			b.WriteString("- " + box + " " + item.Text + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+var unsafeExportFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._ -]+`)
+
+// notesZip packs each note as a Markdown file named after its title
+func notesZip(notes []models.Note, includeMetadata bool) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	used := make(map[string]bool)
+	for i := range notes {
+		note := &notes[i]
+		base := strings.TrimSpace(unsafeExportFilenameChars.ReplaceAllString(note.Title, ""))
+		if len(base) > 100 {
+			base = base[:100]
+		}
+		if base == "" {
+			base = "note-" + note.ID.String()
+		}
+		name := base + ".md"
+		for n := 2; used[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s (%d).md", base, n)
+		}
+		used[strings.ToLower(name)] = true
+
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: note.UpdatedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(noteMarkdown(note, includeMetadata))); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Get returns one of the user's export jobs
+func (s *ExportService) Get(ctx context.Context, userID, jobID uuid.UUID) (*models.ExportJob, error) {
+	return s.jobRepo.GetByID(ctx, jobID, userID)
+}
+
+// List returns the user's export jobs, newest first
+func (s *ExportService) List(ctx context.Context, userID uuid.UUID) ([]models.ExportJob, error) {
+	return s.jobRepo.List(ctx, userID)
+}
+
+// Open returns a completed export's file. The caller must close it.
+func (s *ExportService) Open(ctx context.Context, userID, jobID uuid.UUID) (*models.ExportJob, *os.File, error) {
+	job, err := s.jobRepo.GetByID(ctx, jobID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if job.Status != models.ExportJobCompleted {
+		return nil, nil, ErrExportNotReady
+	}
+	f, err := s.store.Open(job.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return job, f, nil
+}
+
+// Cleanup fails exports that were interrupted, and removes exports older than models.ExportJobExpiry
+// along with their files
+func (s *ExportService) Cleanup(ctx context.Context) (int64, error) {
+	if _, err := s.jobRepo.FailStale(ctx, time.Now().Add(-exportJobTimeout)); err != nil {
+		return 0, err
+	}
+
+	ids, err := s.jobRepo.DeleteOlderThan(ctx, time.Now().Add(-models.ExportJobExpiry))
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := s.store.Delete(id); err != nil {
+			slog.WarnContext(ctx, "Failed to delete export file", "export_id", id.String(), "error", err)
+		}
+	}
+	return int64(len(ids)), nil
+}
+
+// ExportJobToDTO converts a job for API responses
+func ExportJobToDTO(job *models.ExportJob) models.ExportJobDTO {
+	dto := models.ExportJobDTO{
+		ID:        job.ID.String(),
+		Status:    string(job.Status),
+		Format:    string(job.Format),
+		NoteCount: job.NoteCount,
+		SizeBytes: job.SizeBytes,
+		Filename:  job.Filename,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt.UTC().Format(ISO8601Format),
+		ExpiresAt: job.CreatedAt.Add(models.ExportJobExpiry).UTC().Format(ISO8601Format),
+	}
+	if job.Status == models.ExportJobCompleted {
+		dto.DownloadURL = "/api/export/" + job.ID.String() + "/download"
+	}
+	if job.CompletedAt != nil {
+		completedAt := job.CompletedAt.UTC().Format(ISO8601Format)
+		dto.CompletedAt = &completedAt
+	}
+	return dto
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was already used for a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still being processed")
+)
+
+// IdempotencyService remembers the responses to requests sent with an Idempotency-Key, so a client
+// retrying after a network failure gets the original response instead of repeating the request
+type IdempotencyService struct {
+	repo *repository.IdempotencyRepository
+}
+
+func NewIdempotencyService(repo *repository.IdempotencyRepository) *IdempotencyService {
+	return &IdempotencyService{repo: repo}
+}
+
+// Begin claims key for a request. It returns the stored response if the request was already handled,
+// nil if the caller should handle it and then call Complete or Release, ErrIdempotencyKeyReused if the
+// key belongs to a different request, or ErrIdempotencyKeyInProgress if the first request hasn't finished.
+func (s *IdempotencyService) Begin(ctx context.Context, userID uuid.UUID, key, route string, request []byte) (*models.IdempotencyRecord, error) {
+	hash := sha256.Sum256(request)
+	existing, err := s.repo.Reserve(ctx, &models.IdempotencyRecord{
+		UserID:      userID,
+		Key:         key,
+		Route:       route,
+		RequestHash: hex.EncodeToString(hash[:]),
+	})
+	if err != nil || existing == nil {
+		return nil, err
+	}
+
+	if existing.Route != route || existing.RequestHash != hex.EncodeToString(hash[:]) {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if existing.StatusCode == nil {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return existing, nil
+}
+
+// Complete stores the response to replay for key
+func (s *IdempotencyService) Complete(ctx context.Context, userID uuid.UUID, key string, statusCode int, contentType string, response []byte) error {
+	return s.repo.Complete(ctx, userID, key, statusCode, contentType, response)
+}
+
+// Release forgets key so the request can be retried
+func (s *IdempotencyService) Release(ctx context.Context, userID uuid.UUID, key string) error {
+	return s.repo.Release(ctx, userID, key)
+}
+
+// Cleanup removes keys older than models.IdempotencyKeyRetention
+func (s *IdempotencyService) Cleanup(ctx context.Context) (int64, error) {
+	return s.repo.DeleteOlderThan(ctx, time.Now().Add(-models.IdempotencyKeyRetention))
+}
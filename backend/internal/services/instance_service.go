@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+var (
+	ErrSetupTokenInvalid  = errors.New("setup token invalid or setup already completed")
+	ErrRegistrationClosed = errors.New("registration is closed")
+)
+
+// InstanceService sets up a new server and manages its instance-wide settings
+type InstanceService struct {
+	repo        *repository.InstanceRepository
+	authService *AuthService
+}
+
+func NewInstanceService(repo *repository.InstanceRepository, authService *AuthService) *InstanceService {
+	return &InstanceService{repo: repo, authService: authService}
+}
+
+// PasswordRequirements describes the password policy the administrator's password must meet
+func (s *InstanceService) PasswordRequirements() string {
+	return s.authService.PasswordRequirements()
+}
+
+// Bootstrap runs at startup. If the instance has no administrator yet it issues a new one-time
+// setup token and returns it, to be shown to the operator; otherwise it returns "".
+func (s *InstanceService) Bootstrap(ctx context.Context) (string, error) {
+	pending, err := s.repo.SetupPending(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !pending {
+		// Instances set up before the setup flow existed, or through ADMIN_USERNAMES
+		return "", s.repo.MarkSetupCompleted(ctx)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	if err := s.repo.SetSetupToken(ctx, hashSecret(token)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Status tells clients whether the server still needs setting up, and its public settings
+func (s *InstanceService) Status(ctx context.Context) (*models.SetupStatusResponse, error) {
+	settings, err := s.repo.Settings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.repo.SetupPending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &models.SetupStatusResponse{
+		SetupRequired:    pending,
+		InstanceName:     settings.Name,
+		RegistrationOpen: settings.RegistrationOpen,
+	}, nil
+}
+
+// CompleteSetup creates the first administrator with the setup token and logs them in. The
+// address given is trusted as verified, since whoever has the token runs the server.
+func (s *InstanceService) CompleteSetup(ctx context.Context, req *models.SetupRequest, clientIP string) (*models.User, *TokenPair, error) {
+	if err := s.authService.ValidatePassword(req.Password); err != nil {
+		return nil, nil, err
+	}
+	if err := s.authService.CheckBreached(ctx, req.Password); err != nil {
+		return nil, nil, err
+	}
+	hashedPassword, algorithm, err := s.authService.HashPassword(req.Password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	settings, err := s.repo.Settings(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.InstanceName != "" {
+		settings.Name = req.InstanceName
+	}
+	if req.RegistrationOpen != nil {
+		settings.RegistrationOpen = *req.RegistrationOpen
+	}
+
+	now := time.Now()
+	admin := &models.User{
+		ID:                uuid.New(),
+		Username:          req.Username,
+		PasswordHash:      hashedPassword,
+		PasswordAlgorithm: algorithm,
+		Email:             req.Email,
+		IsAdmin:           true,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if admin.Email != "" {
+		admin.VerifiedAt = &now
+	}
+
+	if err := s.repo.CompleteSetup(ctx, hashSecret(req.Token), admin, settings); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrSetupTokenInvalid):
+			logging.Security(ctx, "Setup rejected - invalid token", "ip", clientIP)
+			return nil, nil, ErrSetupTokenInvalid
+		case errors.Is(err, repository.ErrUserExists):
+			return nil, nil, ErrUserExists
+		case errors.Is(err, repository.ErrEmailExists):
+			return nil, nil, ErrEmailExists
+		}
+		return nil, nil, err
+	}
+	logging.Security(ctx, "Setup completed - administrator created", "username", admin.Username, "user_id", admin.ID.String(), "ip", clientIP)
+
+	tokens, err := s.authService.LoginVerified(ctx, admin, "setup", clientIP)
+	if err != nil {
+		return nil, nil, err
+	}
+	return admin, tokens, nil
+}
+
+// Settings returns the instance-wide settings
+func (s *InstanceService) Settings(ctx context.Context) (*models.InstanceSettings, error) {
+	return s.repo.Settings(ctx)
+}
+
+// UpdateSettings changes the instance-wide settings given in the request
+func (s *InstanceService) UpdateSettings(ctx context.Context, req *models.UpdateInstanceSettingsRequest) (*models.InstanceSettings, error) {
+	settings, err := s.repo.Settings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.InstanceName != nil {
+		settings.Name = *req.InstanceName
+	}
+	if req.RegistrationOpen != nil {
+		settings.RegistrationOpen = *req.RegistrationOpen
+	}
+	if err := s.repo.UpdateSettings(ctx, settings.Name, settings.RegistrationOpen); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// CheckRegistrationOpen returns ErrRegistrationClosed if new accounts can't be created
+func (s *InstanceService) CheckRegistrationOpen(ctx context.Context) error {
+	settings, err := s.repo.Settings(ctx)
+	if err != nil {
+		return err
+	}
+	if !settings.RegistrationOpen {
+		return ErrRegistrationClosed
+	}
+	return nil
+}
+
+// InstanceSettingsToDTO converts the instance-wide settings for the API
+func InstanceSettingsToDTO(settings *models.InstanceSettings) models.InstanceSettingsDTO {
+	return models.InstanceSettingsDTO{
+		InstanceName:     settings.Name,
+		RegistrationOpen: settings.RegistrationOpen,
+	}
+}
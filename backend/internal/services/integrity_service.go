@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/storage"
+)
+
+// integrityReportsKept is how many reports are kept for the admin API
+const integrityReportsKept = 100
+
+var ErrIntegrityCheckRunning = errors.New("an integrity check is already running")
+
+// IntegrityService checks referential integrity: checklist items without notes, notes without
+// users and attachment records without files. In repair mode it removes the broken records.
+type IntegrityService struct {
+	repo            *repository.IntegrityRepository
+	attachmentStore *storage.FileStore
+
+	mu      sync.Mutex
+	running bool
+}
+
+func NewIntegrityService(repo *repository.IntegrityRepository, attachmentStore *storage.FileStore) *IntegrityService {
+	return &IntegrityService{
+		repo:            repo,
+		attachmentStore: attachmentStore,
+	}
+}
+
+// Run checks every record and saves a report. With repair set, broken records are removed.
+func (s *IntegrityService) Run(ctx context.Context, repair bool) (*models.IntegrityReport, error) {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return nil, ErrIntegrityCheckRunning
+	}
+	s.running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	report := &models.IntegrityReport{
+		ID:        uuid.New(),
+		Repair:    repair,
+		Counts:    map[string]int{},
+		Findings:  []models.IntegrityFinding{},
+		StartedAt: time.Now(),
+	}
+
+	// Notes without users go first: repairing them also removes their checklist items
+	checks := []struct {
+		find   func(context.Context) ([]models.IntegrityFinding, error)
+		delete func(context.Context, []uuid.UUID) error
+	}{
+		{s.repo.NotesWithoutUsers, s.repo.DeleteNotes},
+		{s.repo.OrphanedChecklistItems, s.repo.DeleteChecklistItems},
+		{s.attachmentsWithoutBlobs, s.repo.DeleteAttachments},
+	}
+	for _, check := range checks {
+		findings, err := check.find(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(findings) == 0 {
+			continue
+		}
+
+		if repair {
+			ids := make([]uuid.UUID, len(findings))
+			for i := range findings {
+				ids[i] = findings[i].ID
+			}
+			if err := check.delete(ctx, ids); err != nil {
+				return nil, err
+			}
+			for i := range findings {
+				findings[i].Repaired = true
+			}
+		}
+
+		report.Counts[findings[0].Check] = len(findings)
+		if len(findings) > models.MaxIntegrityFindings {
+			findings = findings[:models.MaxIntegrityFindings]
+		}
+		report.Findings = append(report.Findings, findings...)
+	}
+
+	report.FinishedAt = time.Now()
+	if err := s.repo.SaveReport(ctx, report); err != nil {
+		return nil, err
+	}
+	if err := s.repo.DeleteOldReports(ctx, integrityReportsKept); err != nil {
+		slog.WarnContext(ctx, "Failed to remove old integrity reports", "error", err)
+	}
+
+	if len(report.Findings) > 0 {
+		slog.WarnContext(ctx, "Integrity check found broken records", "report_id", report.ID.String(), "repair", repair, "counts", report.Counts)
+	}
+	return report, nil
+}
+
+// attachmentsWithoutBlobs returns attachment records whose file is missing from the store
+func (s *IntegrityService) attachmentsWithoutBlobs(ctx context.Context) ([]models.IntegrityFinding, error) {
+	ids, err := s.repo.AttachmentIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []models.IntegrityFinding
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		exists, err := s.attachmentStore.Exists(id)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			findings = append(findings, models.IntegrityFinding{
+				Check:  models.IntegrityAttachmentWithoutBlob,
+				ID:     id,
+				Detail: "file missing from the attachment store",
+			})
+		}
+	}
+	return findings, nil
+}
+
+// Reports returns the most recent reports, newest first
+func (s *IntegrityService) Reports(ctx context.Context, limit int) ([]models.IntegrityReport, error) {
+	return s.repo.ListReports(ctx, limit)
+}
+
+// IntegrityReportToDTO converts a report for API responses
+func IntegrityReportToDTO(report *models.IntegrityReport) models.IntegrityReportDTO {
+	return models.IntegrityReportDTO{
+		ID:         report.ID.String(),
+		Repair:     report.Repair,
+		Counts:     report.Counts,
+		Findings:   report.Findings,
+		StartedAt:  report.StartedAt.UTC().Format(ISO8601Format),
+		FinishedAt: report.FinishedAt.UTC().Format(ISO8601Format),
+	}
+}
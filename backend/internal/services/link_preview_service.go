@@ -0,0 +1,442 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"html"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
+	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
+)
+
+const (
+	// Maximum number of URLs unfurled per note
+	maxPreviewsPerNote = 5
+
+	// Time allowed for a single page fetch, including redirects
+	previewFetchTimeout = 5 * time.Second
+
+	// Maximum number of bytes read from a fetched page
+	maxPreviewBodySize = 512 * 1024
+
+	// Maximum number of redirects followed per fetch
+	maxPreviewRedirects = 3
+
+	// Previews older than this are fetched again when the note changes
+	previewRefreshAge = 24 * time.Hour
+
+	// Maximum number of notes being unfurled at the same time
+	maxConcurrentPreviewJobs = 4
+
+	// Maximum number of notes waiting to be unfurled; more are dropped
+	maxQueuedPreviewJobs = 256
+
+	maxPreviewTitleLength       = 300
+	maxPreviewDescriptionLength = 1000
+)
+
+var (
+	ErrPreviewURLNotAllowed = errors.New("url not allowed for link preview")
+	ErrPreviewAddrBlocked   = errors.New("link preview target resolves to a non-public address")
+	ErrPreviewNotHTML       = errors.New("link preview target is not an HTML page")
+)
+
+var (
+	urlPattern      = regexp.MustCompile(`https?://[^\s<>"'\x60]+`)
+	titlePattern    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaTagPattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaAttrPattern = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// nonPublicPrefixes are the special-purpose ranges previews may not reach beyond the loopback,
+// private, link-local and multicast ones the netip.Addr methods recognise
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+}
+
+// nat64Prefix is the well-known NAT64 prefix, whose addresses reach the IPv4 address in their last
+// four bytes
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// previewJob is a note waiting to have its URLs unfurled
+type previewJob struct {
+	requestID string
+	userID    uuid.UUID
+	noteID    uuid.UUID
+	urls      []string
+}
+
+// LinkPreviewService unfurls URLs found in note content in the background and
+// notifies the owner's devices when new previews are available. Notes are unfurled
+// by a fixed pool of workers between Start and Stop.
+type LinkPreviewService struct {
+	repo         *repository.LinkPreviewRepository
+	hub          *websocket.Hub
+	client       *http.Client
+	allowedHosts []string
+	jobs         chan previewJob
+	cancel       context.CancelFunc
+	workers      sync.WaitGroup
+}
+
+// NewLinkPreviewService creates a link preview service. If allowedHosts is empty,
+// any public host may be fetched; otherwise only the listed hosts and their subdomains.
+func NewLinkPreviewService(repo *repository.LinkPreviewRepository, hub *websocket.Hub, allowedHosts []string) *LinkPreviewService {
+	s := &LinkPreviewService{
+		repo:         repo,
+		hub:          hub,
+		allowedHosts: allowedHosts,
+		jobs:         make(chan previewJob, maxQueuedPreviewJobs),
+	}
+
+	dialer := &net.Dialer{
+		Timeout: previewFetchTimeout,
+		// Control runs after DNS resolution, so the check applies to the actual IP being dialed
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !isPublicIP(addr) {
+				return ErrPreviewAddrBlocked
+			}
+			return nil
+		},
+	}
+
+	s.client = &http.Client{
+		Timeout: previewFetchTimeout,
+		Transport: &http.Transport{
+			Proxy:                 nil, // Never route previews through an environment proxy
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   previewFetchTimeout,
+			ResponseHeaderTimeout: previewFetchTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxPreviewRedirects {
+				return errors.New("too many redirects")
+			}
+			return s.checkURL(req.URL)
+		},
+	}
+
+	return s
+}
+
+// Start starts the workers that unfurl URLs
+func (s *LinkPreviewService) Start() {
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	for range maxConcurrentPreviewJobs {
+		s.workers.Add(1)
+		go s.work(ctx)
+	}
+}
+
+// Stop cancels the notes being unfurled and waits for the workers to return. Queued notes are
+// dropped; their URLs are unfurled the next time they're saved.
+func (s *LinkPreviewService) Stop(ctx context.Context) error {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Enqueue schedules the URLs in a note's content to be unfurled in the background.
+// The job carries the request ID from ctx but not its cancellation. When the queue is
+// full the note is dropped, and its URLs are unfurled the next time it's saved.
+// Safe to call on a nil service (link previews disabled).
+func (s *LinkPreviewService) Enqueue(ctx context.Context, userID, noteID uuid.UUID, content string) {
+	if s == nil {
+		return
+	}
+
+	job := previewJob{requestID: requestid.FromContext(ctx), userID: userID, noteID: noteID, urls: extractURLs(content)}
+	select {
+	case s.jobs <- job:
+	default:
+		slog.WarnContext(ctx, "Link preview queue full, skipping note", "note_id", noteID.String())
+	}
+}
+
+// work unfurls queued notes until ctx is cancelled
+func (s *LinkPreviewService) work(ctx context.Context) {
+	defer s.workers.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.jobs:
+			jobCtx, cancel := context.WithTimeout(requestid.WithContext(ctx, job.requestID), time.Duration(maxPreviewsPerNote+1)*previewFetchTimeout)
+			if err := s.refresh(jobCtx, job.userID, job.noteID, job.urls); err != nil {
+				slog.WarnContext(jobCtx, "Failed to update link previews", "note_id", job.noteID.String(), "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// refresh drops previews for URLs no longer in the note and fetches new or stale ones
+func (s *LinkPreviewService) refresh(ctx context.Context, userID, noteID uuid.UUID, urls []string) error {
+	existing, err := s.repo.GetByNoteID(ctx, noteID)
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.repo.DeleteExcept(ctx, noteID, urls)
+	if err != nil {
+		return err
+	}
+
+	fetchedAt := make(map[string]time.Time, len(existing))
+	for _, preview := range existing {
+		fetchedAt[preview.URL] = preview.FetchedAt
+	}
+
+	changed := removed > 0
+	for _, rawURL := range urls {
+		if t, ok := fetchedAt[rawURL]; ok && time.Since(t) < previewRefreshAge {
+			continue
+		}
+
+		preview, err := s.fetch(ctx, rawURL)
+		if err != nil {
+			slog.InfoContext(ctx, "Skipping link preview", "url", rawURL, "error", err)
+			continue
+		}
+		preview.NoteID = noteID
+
+		if err := s.repo.Upsert(ctx, preview); err != nil {
+			// Note may have been deleted while we were fetching
+			return err
+		}
+		changed = true
+	}
+
+	if changed {
+		s.broadcast(ctx, userID, noteID)
+	}
+	return nil
+}
+
+// fetch downloads a page and extracts its title, description and preview image
+func (s *LinkPreviewService) fetch(ctx context.Context, rawURL string) (*models.LinkPreview, error) {
+	pageURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkURL(pageURL); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "NotesLinkPreview/1.0")
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected status " + resp.Status)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, ErrPreviewNotHTML
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPreviewBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	preview := parsePreview(string(body), resp.Request.URL)
+	preview.URL = rawURL
+	preview.FetchedAt = time.Now()
+	return preview, nil
+}
+
+// checkURL rejects non-HTTP(S) URLs, unusual ports and hosts outside the allowlist
+func (s *LinkPreviewService) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrPreviewURLNotAllowed
+	}
+	if u.User != nil {
+		return ErrPreviewURLNotAllowed
+	}
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		return ErrPreviewURLNotAllowed
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPreviewURLNotAllowed
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublicIP(addr) {
+		return ErrPreviewAddrBlocked
+	}
+
+	if len(s.allowedHosts) == 0 {
+		return nil
+	}
+	for _, allowed := range s.allowedHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return ErrPreviewURLNotAllowed
+}
+
+// broadcast sends the note's current previews to all of the user's connections
+func (s *LinkPreviewService) broadcast(ctx context.Context, userID, noteID uuid.UUID) {
+	if s.hub == nil {
+		return
+	}
+
+	previews, err := s.repo.GetByNoteID(ctx, noteID)
+	if err != nil {
+		return
+	}
+
+	s.hub.BroadcastEvent(ctx, userID, websocket.MessageTypeLinkPreviews, websocket.LinkPreviewsPayload{
+		NoteID:       noteID.String(),
+		LinkPreviews: linkPreviewsToDTO(previews),
+	}, "", requestid.FromContext(ctx))
+}
+
+// extractURLs returns the distinct http(s) URLs in content, up to maxPreviewsPerNote
+func extractURLs(content string) []string {
+	seen := make(map[string]bool)
+	urls := []string{}
+	for _, match := range urlPattern.FindAllString(content, -1) {
+		// Trailing punctuation is almost always part of the sentence, not the URL
+		match = strings.TrimRight(match, ".,;:!?)]}")
+		if seen[match] {
+			continue
+		}
+		seen[match] = true
+		urls = append(urls, match)
+		if len(urls) == maxPreviewsPerNote {
+			break
+		}
+	}
+	return urls
+}
+
+// parsePreview extracts Open Graph metadata, falling back to <title> and the description meta tag
+func parsePreview(page string, pageURL *url.URL) *models.LinkPreview {
+	meta := make(map[string]string)
+	for _, tag := range metaTagPattern.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, m := range metaAttrPattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = m[2] + m[3]
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if key != "" && meta[key] == "" {
+			meta[key] = attrs["content"]
+		}
+	}
+
+	preview := &models.LinkPreview{
+		Title:       meta["og:title"],
+		Description: meta["og:description"],
+	}
+	if preview.Title == "" {
+		if m := titlePattern.FindStringSubmatch(page); m != nil {
+			preview.Title = m[1]
+		}
+	}
+	if preview.Description == "" {
+		preview.Description = meta["description"]
+	}
+	if image := meta["og:image"]; image != "" {
+		if imageURL, err := pageURL.Parse(html.UnescapeString(image)); err == nil &&
+			(imageURL.Scheme == "http" || imageURL.Scheme == "https") {
+			preview.ImageURL = imageURL.String()
+		}
+	}
+
+	preview.Title = truncate(cleanText(preview.Title), maxPreviewTitleLength)
+	preview.Description = truncate(cleanText(preview.Description), maxPreviewDescriptionLength)
+	return preview
+}
+
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+func truncate(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	// Avoid cutting a multi-byte character in half
+	for maxLen > 0 && !utf8.RuneStart(s[maxLen]) {
+		maxLen--
+	}
+	return s[:maxLen]
+}
+
+// isPublicIP reports whether addr is a globally routable unicast address. IPv4 addresses mapped
+// into IPv6 or behind the well-known NAT64 prefix are judged by the IPv4 address they reach.
+func isPublicIP(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if nat64Prefix.Contains(addr) {
+		ip4 := addr.As16()
+		addr = netip.AddrFrom4([4]byte(ip4[12:]))
+	}
+
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/mail"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
+)
+
+var ErrInvalidMagicLink = errors.New("invalid, used or expired sign-in link")
+
+// magicLinkExpiry is how long a sign-in link stays valid
+const magicLinkExpiry = 15 * time.Minute
+
+// magicLinkJobTimeout bounds looking up the address, saving the link and sending it
+const magicLinkJobTimeout = 30 * time.Second
+
+const (
+	magicLinkWorkers   = 4   // links sent at once
+	magicLinkQueueSize = 256 // links waiting to be sent; more are dropped
+)
+
+// magicLinkJob is a link waiting to be sent
+type magicLinkJob struct {
+	requestID string
+	email     string
+	clientIP  string
+}
+
+// MagicLinkService signs users in with single-use links emailed to them, instead of a password.
+// Links are sent by a fixed pool of workers between Start and Stop.
+type MagicLinkService struct {
+	userRepo    *repository.UserRepository
+	tokenRepo   *repository.MagicLinkRepository
+	authService *AuthService
+	mailer      mail.Mailer
+	appBaseURL  string
+
+	mu      sync.RWMutex
+	running bool
+	queue   chan magicLinkJob
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+func NewMagicLinkService(userRepo *repository.UserRepository, tokenRepo *repository.MagicLinkRepository, authService *AuthService, mailer mail.Mailer, appBaseURL string) *MagicLinkService {
+	return &MagicLinkService{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		authService: authService,
+		mailer:      mailer,
+		appBaseURL:  strings.TrimRight(appBaseURL, "/"),
+	}
+}
+
+// Start starts the workers that send links
+func (s *MagicLinkService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.queue = make(chan magicLinkJob, magicLinkQueueSize)
+	s.running = true
+	for range magicLinkWorkers {
+		s.workers.Add(1)
+		go s.work(ctx, s.queue)
+	}
+}
+
+// Stop stops taking links and waits for the workers to send the ones queued. If ctx is done first,
+// the links still being sent are abandoned.
+func (s *MagicLinkService) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.running {
+		s.running = false
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
+}
+
+// Send emails a sign-in link to the account with the given address, in the background. Earlier
+// links it sent stop working. It returns before the address is even looked up, so neither its
+// answer nor how long it takes tells which addresses have accounts. The job carries the request ID
+// from ctx but not its cancellation. When the queue is full the link is dropped, and the user can
+// ask again.
+func (s *MagicLinkService) Send(ctx context.Context, email, clientIP string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.running {
+		slog.ErrorContext(ctx, "Magic link dropped - not running")
+		return
+	}
+
+	select {
+	case s.queue <- magicLinkJob{requestID: requestid.FromContext(ctx), email: email, clientIP: clientIP}:
+	default:
+		slog.ErrorContext(ctx, "Magic link dropped - queue full", "queued", magicLinkQueueSize)
+	}
+}
+
+// work sends the links in queue until it is closed
+func (s *MagicLinkService) work(ctx context.Context, queue <-chan magicLinkJob) {
+	defer s.workers.Done()
+	for job := range queue {
+		jobCtx, cancel := context.WithTimeout(requestid.WithContext(ctx, job.requestID), magicLinkJobTimeout)
+		if err := s.send(jobCtx, job.email, job.clientIP); err != nil {
+			slog.ErrorContext(jobCtx, "Failed to send magic link", "error", err)
+		}
+		cancel()
+	}
+}
+
+// send does the work of Send
+func (s *MagicLinkService) send(ctx context.Context, email, clientIP string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			logging.Security(ctx, "Magic link requested for unknown address", "ip", clientIP)
+			return nil
+		}
+		return err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	if err := s.tokenRepo.RevokeAllForUser(ctx, user.ID); err != nil {
+		return err
+	}
+	record := &models.MagicLinkToken{
+		ID:          uuid.New(),
+		UserID:      user.ID,
+		Email:       user.Email,
+		TokenHash:   hashVerificationToken(token),
+		RequestedIP: clientIP,
+		ExpiresAt:   time.Now().Add(magicLinkExpiry),
+	}
+	if err := s.tokenRepo.Create(ctx, record); err != nil {
+		return err
+	}
+
+	link := s.appBaseURL + "/magic-link?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\n"+
+		"Open the link below to sign in. It works once and expires in %d minutes:\n%s\n\n"+
+		"The request came from %s. If it wasn't you, you can ignore this email; nobody can sign in without the link.\n",
+		user.Username, int(magicLinkExpiry.Minutes()), link, clientIP)
+
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body:    body,
+	})
+	if err != nil {
+		return fmt.Errorf("mail user %s: %w", user.ID, err)
+	}
+
+	logging.Security(ctx, "Magic link sent", "username", user.Username, "user_id", user.ID.String(), "ip", clientIP)
+	return nil
+}
+
+// Exchange uses up a sign-in link's token and logs its user in. Opening the link proves the user
+// reads mail at their address, so it's marked verified if it wasn't already.
+func (s *MagicLinkService) Exchange(ctx context.Context, token, clientIP string) (*models.User, *TokenPair, error) {
+	record, err := s.tokenRepo.Use(ctx, hashVerificationToken(token))
+	if err != nil {
+		if errors.Is(err, repository.ErrMagicLinkNotFound) {
+			logging.Security(ctx, "Invalid, used or expired magic link", "ip", clientIP)
+			return nil, nil, ErrInvalidMagicLink
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, record.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The link was sent to an address the user has since changed
+	if !strings.EqualFold(user.Email, record.Email) {
+		logging.Security(ctx, "Magic link rejected - address changed", "username", user.Username, "user_id", user.ID.String(), "ip", clientIP)
+		return nil, nil, ErrInvalidMagicLink
+	}
+
+	if user.VerifiedAt == nil {
+		now := time.Now()
+		if err := s.userRepo.MarkEmailVerified(ctx, user.ID, record.Email, now); err != nil {
+			return nil, nil, err
+		}
+		user.VerifiedAt = &now
+	}
+
+	tokens, err := s.authService.LoginVerified(ctx, user, "magic link", clientIP)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, tokens, nil
+}
+
+// CleanupExpired removes used and expired tokens
+func (s *MagicLinkService) CleanupExpired(ctx context.Context) (int64, error) {
+	return s.tokenRepo.DeleteExpired(ctx)
+}
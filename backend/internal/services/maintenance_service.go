@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+// MaintenanceService switches maintenance mode, in which changes are refused while reads carry on.
+// The mode is kept with the instance's settings so every server instance follows it. Each keeps
+// its own copy, updated by Refresh, so checking it costs nothing per request.
+type MaintenanceService struct {
+	repo     *repository.InstanceRepository
+	current  atomic.Pointer[models.Maintenance]
+	mu       sync.Mutex // orders updates, so changes are reported in the order they're seen
+	onChange func(*models.Maintenance)
+}
+
+func NewMaintenanceService(repo *repository.InstanceRepository) *MaintenanceService {
+	s := &MaintenanceService{repo: repo}
+	s.current.Store(&models.Maintenance{})
+	return s
+}
+
+// OnChange has fn called whenever this instance sees the mode change, such as to tell WebSocket
+// clients. Set it before the first Refresh.
+func (s *MaintenanceService) OnChange(fn func(*models.Maintenance)) {
+	s.onChange = fn
+}
+
+// Current returns the mode as this instance last saw it
+func (s *MaintenanceService) Current() *models.Maintenance {
+	return s.current.Load()
+}
+
+// Set turns maintenance mode on or off for every instance. Other instances see the change when
+// they next refresh.
+func (s *MaintenanceService) Set(ctx context.Context, m *models.Maintenance) error {
+	if !m.Enabled {
+		m = &models.Maintenance{}
+	}
+	if err := s.repo.SetMaintenance(ctx, m); err != nil {
+		return err
+	}
+	s.update(m)
+	return nil
+}
+
+// Refresh picks up changes made through other instances
+func (s *MaintenanceService) Refresh(ctx context.Context) error {
+	m, err := s.repo.Maintenance(ctx)
+	if err != nil {
+		return err
+	}
+	s.update(m)
+	return nil
+}
+
+func (s *MaintenanceService) update(m *models.Maintenance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.current.Swap(m)
+	if maintenanceEqual(previous, m) || s.onChange == nil {
+		return
+	}
+	s.onChange(m)
+}
+
+func maintenanceEqual(a, b *models.Maintenance) bool {
+	if a.Enabled != b.Enabled || a.Message != b.Message || (a.Until == nil) != (b.Until == nil) {
+		return false
+	}
+	return a.Until == nil || a.Until.Equal(*b.Until)
+}
+
+// MaintenanceToDTO converts the maintenance mode for the API
+func MaintenanceToDTO(m *models.Maintenance) models.MaintenanceDTO {
+	dto := models.MaintenanceDTO{Enabled: m.Enabled, Message: m.Message}
+	if m.Until != nil {
+		until := m.Until.UTC().Format(time.RFC3339)
+		dto.Until = &until
+	}
+	return dto
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
+)
+
+const mentionJobTimeout = 10 * time.Second
+
+// mentionPattern matches @username where the @ isn't part of a word or email address.
+// Usernames are 3-50 alphanumeric characters (see AuthRequest).
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_.@])@([A-Za-z0-9]{3,50})\b`)
+
+// MentionService records @username mentions of collaborators in shared notes and notifies them
+type MentionService struct {
+	mentionRepo      *repository.MentionRepository
+	notificationRepo *repository.NotificationRepository
+	shareRepo        *repository.ShareRepository
+	noteRepo         *repository.NoteRepository
+	userRepo         *repository.UserRepository
+	dispatcher       *NotificationDispatcher
+}
+
+func NewMentionService(mentionRepo *repository.MentionRepository, notificationRepo *repository.NotificationRepository, shareRepo *repository.ShareRepository, noteRepo *repository.NoteRepository, userRepo *repository.UserRepository, dispatcher *NotificationDispatcher) *MentionService {
+	return &MentionService{
+		mentionRepo:      mentionRepo,
+		notificationRepo: notificationRepo,
+		shareRepo:        shareRepo,
+		noteRepo:         noteRepo,
+		userRepo:         userRepo,
+		dispatcher:       dispatcher,
+	}
+}
+
+// Enqueue re-parses a note's mentions in the background after the author has saved it.
+// Safe to call on a nil service.
+func (s *MentionService) Enqueue(ctx context.Context, authorID, noteID uuid.UUID) {
+	if s == nil {
+		return
+	}
+
+	requestID := requestid.FromContext(ctx)
+
+	go func() {
+		jobCtx := requestid.WithContext(context.Background(), requestID)
+		jobCtx, cancel := context.WithTimeout(jobCtx, mentionJobTimeout)
+		defer cancel()
+
+		if err := s.process(jobCtx, authorID, noteID); err != nil {
+			slog.WarnContext(jobCtx, "Failed to process mentions", "note_id", noteID.String(), "error", err)
+		}
+	}()
+}
+
+func (s *MentionService) process(ctx context.Context, authorID, noteID uuid.UUID) error {
+	// Only the owner can write a note, so this also stops mentions being attributed to other users' notes
+	note, err := s.noteRepo.GetByID(ctx, noteID, authorID)
+	if err != nil {
+		return err
+	}
+
+	collaborators, err := s.shareRepo.GetCollaborators(ctx, note.ID)
+	if err != nil {
+		return err
+	}
+
+	usernames := extractMentions(noteText(note))
+
+	// Only collaborators can be mentioned: they're the only other users who can open the note
+	mentioned := []uuid.UUID{}
+	if len(usernames) > 0 {
+		for _, userID := range collaborators {
+			if userID == authorID {
+				continue
+			}
+			user, err := s.userRepo.GetByID(ctx, userID)
+			if err != nil {
+				continue
+			}
+			if usernames[strings.ToLower(user.Username)] {
+				mentioned = append(mentioned, userID)
+			}
+		}
+	}
+
+	added, err := s.mentionRepo.ReplaceMentions(ctx, note.ID, authorID, mentioned)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range added {
+		s.notify(ctx, userID, authorID, note)
+	}
+	return nil
+}
+
+// notify sends a mention notification on the channels the user has chosen
+func (s *MentionService) notify(ctx context.Context, userID, authorID uuid.UUID, note *models.Note) {
+	notification := &models.Notification{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Type:      models.NotificationTypeMention,
+		NoteID:    &note.ID,
+		ActorID:   &authorID,
+		CreatedAt: time.Now(),
+		NoteTitle: note.Title,
+	}
+	if author, err := s.userRepo.GetByID(ctx, authorID); err == nil {
+		notification.ActorUsername = author.Username
+	}
+
+	s.dispatcher.Dispatch(ctx, notification, NotificationText{
+		Title: notification.ActorUsername + " mentioned you",
+		Body:  fmt.Sprintf("%s mentioned you in \"%s\".", notification.ActorUsername, note.Title),
+	})
+}
+
+// Notifications returns a user's most recent notifications
+func (s *MentionService) Notifications(ctx context.Context, userID uuid.UUID, unreadOnly bool, limit int) ([]models.Notification, error) {
+	return s.notificationRepo.ListByUser(ctx, userID, unreadOnly, limit)
+}
+
+// MarkRead marks one of the user's notifications as read
+func (s *MentionService) MarkRead(ctx context.Context, userID, notificationID uuid.UUID) error {
+	return s.notificationRepo.MarkRead(ctx, notificationID, userID)
+}
+
+// MarkAllRead marks all of the user's notifications as read
+func (s *MentionService) MarkAllRead(ctx context.Context, userID uuid.UUID) error {
+	return s.notificationRepo.MarkAllRead(ctx, userID)
+}
+
+// noteText joins the parts of a note that can contain mentions
+func noteText(note *models.Note) string {
+	parts := []string{note.Title, note.Content}
+	for _, item := range note.ChecklistItems {
+		parts = append(parts, item.Text)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// extractMentions returns the distinct lower-cased usernames mentioned in text
+func extractMentions(text string) map[string]bool {
+	usernames := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		usernames[strings.ToLower(match[1])] = true
+	}
+	return usernames
+}
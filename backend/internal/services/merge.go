@@ -0,0 +1,217 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+// mergeNotes does a three-way merge of the server's and client's edits to a note against the version
+// both started from. Each field, and each field of each checklist item, takes whichever side changed it.
+// Returns false if both sides changed the same field to different values.
+func mergeNotes(base, server, client *models.Note) (*models.Note, bool) {
+	merged := *server
+	ok := true
+
+	merge := func(b, s, c any) any {
+		v, clean := mergeValue(b, s, c)
+		ok = ok && clean
+		return v
+	}
+
+	merged.Title = merge(base.Title, server.Title, client.Title).(string)
+	merged.Content = merge(base.Content, server.Content, client.Content).(string)
+	merged.NoteType = merge(base.NoteType, server.NoteType, client.NoteType).(models.NoteType)
+	merged.Language = merge(base.Language, server.Language, client.Language).(string)
+	merged.IsMonospace = merge(base.IsMonospace, server.IsMonospace, client.IsMonospace).(bool)
+	merged.IsPinned = merge(base.IsPinned, server.IsPinned, client.IsPinned).(bool)
+	merged.IsArchived = merge(base.IsArchived, server.IsArchived, client.IsArchived).(bool)
+
+	// Sort order is cosmetic; take it from the newer edit rather than failing the merge
+	if client.Clock().After(server.Clock()) {
+		merged.SortOrder = client.SortOrder
+	}
+
+	metadata, metadataOK := mergeMetadata(base.Metadata, server.Metadata, client.Metadata)
+	merged.Metadata = metadata
+	ok = ok && metadataOK
+
+	items, itemsOK := mergeChecklistItems(base.ChecklistItems, server.ChecklistItems, client.ChecklistItems)
+	merged.ChecklistItems = items
+	ok = ok && itemsOK
+
+	return &merged, ok
+}
+
+// mergeValue returns the side that changed a value from base; it's a conflict if both changed it differently
+func mergeValue(base, server, client any) (any, bool) {
+	switch {
+	case server == client, client == base:
+		return server, true
+	case server == base:
+		return client, true
+	default:
+		return server, false
+	}
+}
+
+func mergeMetadata(base, server, client map[string]string) (map[string]string, bool) {
+	keys := make(map[string]bool)
+	for _, m := range []map[string]string{base, server, client} {
+		for k := range m {
+			keys[k] = true
+		}
+	}
+
+	merged := make(map[string]string)
+	ok := true
+	for k := range keys {
+		b, inBase := base[k]
+		s, inServer := server[k]
+		c, inClient := client[k]
+
+		// Treat a missing key as a distinct value so additions and removals merge like edits
+		type entry struct {
+			value   string
+			present bool
+		}
+		v, clean := mergeValue(entry{b, inBase}, entry{s, inServer}, entry{c, inClient})
+		ok = ok && clean
+		if e := v.(entry); e.present {
+			merged[k] = e.value
+		}
+	}
+
+	if len(merged) == 0 {
+		return nil, ok
+	}
+	return merged, ok
+}
+
+// mergeChecklistItems merges items by ID. Items added on either side are kept, in a deterministic order;
+// an item deleted on one side is dropped unless the other side edited it, which is a conflict.
+func mergeChecklistItems(base, server, client []models.ChecklistItem) ([]models.ChecklistItem, bool) {
+	baseByID := checklistItemsByID(base)
+	serverByID := checklistItemsByID(server)
+	clientByID := checklistItemsByID(client)
+
+	var merged []models.ChecklistItem
+	ok := true
+
+	// Server items in their current order, then items only the client has
+	for _, s := range server {
+		b, inBase := baseByID[s.ID]
+		c, inClient := clientByID[s.ID]
+
+		switch {
+		case inClient && inBase:
+			item, clean := mergeChecklistItem(b, s, c)
+			ok = ok && clean
+			merged = append(merged, item)
+		case inClient:
+			// Both sides added the same ID; keep the newer
+			if c.UpdatedAt.After(s.UpdatedAt) {
+				s = c
+			}
+			merged = append(merged, s)
+		case inBase:
+			// Deleted by the client; a conflict if the server edited it since
+			if !checklistItemsEqual(b, s) {
+				ok = false
+				merged = append(merged, s)
+			}
+		default:
+			merged = append(merged, s)
+		}
+	}
+
+	for _, c := range client {
+		if _, inServer := serverByID[c.ID]; inServer {
+			continue
+		}
+		b, inBase := baseByID[c.ID]
+		switch {
+		case !inBase:
+			merged = append(merged, c)
+		case !checklistItemsEqual(b, c):
+			// Deleted on the server but edited by the client
+			ok = false
+			merged = append(merged, c)
+		}
+	}
+
+	sortChecklistItems(merged)
+	return merged, ok
+}
+
+// appendChecklistAdditions adds to kept the checklist items that lost added since lastSync and kept
+// doesn't have, for when two edits can't be merged. Items created before lastSync that kept lacks were
+// deleted on kept's side and stay deleted. Reports whether any were added.
+func appendChecklistAdditions(kept, lost *models.Note, lastSync time.Time) bool {
+	keptByID := checklistItemsByID(kept.ChecklistItems)
+	added := false
+	for _, item := range lost.ChecklistItems {
+		if _, exists := keptByID[item.ID]; exists || !item.CreatedAt.After(lastSync) {
+			continue
+		}
+		item.NoteID = kept.ID
+		kept.ChecklistItems = append(kept.ChecklistItems, item)
+		added = true
+	}
+	if added {
+		sortChecklistItems(kept.ChecklistItems)
+	}
+	return added
+}
+
+// sortChecklistItems orders items by sort order, breaking ties between items both devices added at the
+// same position by creation time and then ID, so every device ends up with the same order whichever
+// synced first. Sort orders are then renumbered to be unique.
+func sortChecklistItems(items []models.ChecklistItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.SortOrder != b.SortOrder {
+			return a.SortOrder < b.SortOrder
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID.String() < b.ID.String()
+	})
+	for i := range items {
+		items[i].SortOrder = i
+	}
+}
+
+func mergeChecklistItem(base, server, client models.ChecklistItem) (models.ChecklistItem, bool) {
+	merged := server
+	text, textOK := mergeValue(base.Text, server.Text, client.Text)
+	completed, completedOK := mergeValue(base.IsCompleted, server.IsCompleted, client.IsCompleted)
+
+	// Reordering alone never blocks a merge
+	sortOrder, _ := mergeValue(base.SortOrder, server.SortOrder, client.SortOrder)
+
+	merged.Text = text.(string)
+	merged.IsCompleted = completed.(bool)
+	merged.SortOrder = sortOrder.(int)
+	if client.UpdatedAt.After(merged.UpdatedAt) {
+		merged.UpdatedAt = client.UpdatedAt
+	}
+
+	return merged, textOK && completedOK
+}
+
+func checklistItemsByID(items []models.ChecklistItem) map[uuid.UUID]models.ChecklistItem {
+	byID := make(map[uuid.UUID]models.ChecklistItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	return byID
+}
+
+// checklistItemsEqual compares the parts of an item a user edits; moving an item isn't an edit
+func checklistItemsEqual(a, b models.ChecklistItem) bool {
+	return a.Text == b.Text && a.IsCompleted == b.IsCompleted
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/pdf"
+)
+
+// NotePDFOptions controls how a note is rendered to PDF
+type NotePDFOptions struct {
+	PageSize        pdf.PageSize
+	IncludeMetadata bool // timestamps, flags and custom metadata
+}
+
+// RenderNotePDF renders a note, including its checklist items, as a PDF document
+func RenderNotePDF(note *models.Note, opts NotePDFOptions) []byte {
+	doc := pdf.New(opts.PageSize)
+	doc.SetTitle(notePDFTitle(note))
+	writeNotePDF(doc, note, opts)
+	return doc.Bytes()
+}
+
+// RenderNotesPDF renders several notes as one PDF document, each starting on a new page
+func RenderNotesPDF(title string, notes []models.Note, opts NotePDFOptions) []byte {
+	doc := pdf.New(opts.PageSize)
+	doc.SetTitle(title)
+	for i := range notes {
+		doc.PageBreak()
+		writeNotePDF(doc, &notes[i], opts)
+	}
+	return doc.Bytes()
+}
+
+func notePDFTitle(note *models.Note) string {
+	if note.Title == "" {
+		return "Untitled note"
+	}
+	return note.Title
+}
+
+func writeNotePDF(doc *pdf.Document, note *models.Note, opts NotePDFOptions) {
+	doc.Text(notePDFTitle(note), pdf.FontBold, 18)
+
+	if opts.IncludeMetadata {
+		doc.Space(4)
+		for _, line := range notePDFMetadataLines(note) {
+			doc.Text(line, pdf.FontRegular, 9)
+		}
+	}
+	doc.Rule()
+
+	if note.Content != "" {
+		doc.Text(note.Content, pdf.FontRegular, 11)
+	}
+
+	if len(note.ChecklistItems) > 0 {
+		if note.Content != "" {
+			doc.Space(8)
+		}
+		for _, item := range note.ChecklistItems {
+			box := "[ ]"
+			if item.IsCompleted {
+				box = "[x]"
+			}
+			doc.TextIndented(box+" "+item.Text, pdf.FontRegular, 11, 8)
+		}
+	}
+}
+
+func notePDFMetadataLines(note *models.Note) []string {
+	lines := []string{
+		"Created: " + note.CreatedAt.UTC().Format("2 Jan 2006 15:04 MST"),
+		"Updated: " + note.UpdatedAt.UTC().Format("2 Jan 2006 15:04 MST"),
+	}
+
+	var flags []string
+	if note.IsPinned {
+		flags = append(flags, "pinned")
+	}
+	if note.IsArchived {
+		flags = append(flags, "archived")
+	}
+	kind := "Type: " + string(note.NoteType)
+	if len(flags) > 0 {
+		kind += " (" + strings.Join(flags, ", ") + ")"
+	}
+	lines = append(lines, kind)
+
+	keys := make([]string, 0, len(note.Metadata))
+	for key := range note.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s: %s", key, note.Metadata[key]))
+	}
+
+	return lines
+}
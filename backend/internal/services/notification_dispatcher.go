@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/mail"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/push"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
+	"github.com/hamishgilbert/notes-app/backend/internal/websocket"
+)
+
+const notificationJobTimeout = 30 * time.Second
+
+// NotificationText is how a notification reads in email and push messages
+type NotificationText struct {
+	Title string
+	Body  string
+}
+
+// NotificationDispatcher delivers notifications in-app, by email and by push, on the channels the
+// user has turned on for the notification's category
+type NotificationDispatcher struct {
+	notificationRepo *repository.NotificationRepository
+	settingsRepo     *repository.SettingsRepository
+	userRepo         *repository.UserRepository
+	mailer           mail.Mailer
+	pusher           push.Pusher
+	hub              *websocket.Hub
+	appBaseURL       string
+}
+
+func NewNotificationDispatcher(notificationRepo *repository.NotificationRepository, settingsRepo *repository.SettingsRepository, userRepo *repository.UserRepository, mailer mail.Mailer, pusher push.Pusher, hub *websocket.Hub, appBaseURL string) *NotificationDispatcher {
+	return &NotificationDispatcher{
+		notificationRepo: notificationRepo,
+		settingsRepo:     settingsRepo,
+		userRepo:         userRepo,
+		mailer:           mailer,
+		pusher:           pusher,
+		hub:              hub,
+		appBaseURL:       strings.TrimRight(appBaseURL, "/"),
+	}
+}
+
+// Dispatch delivers a notification in the background. Safe to call on a nil dispatcher.
+func (d *NotificationDispatcher) Dispatch(ctx context.Context, n *models.Notification, text NotificationText) {
+	if d == nil {
+		return
+	}
+
+	requestID := requestid.FromContext(ctx)
+
+	go func() {
+		jobCtx := requestid.WithContext(context.Background(), requestID)
+		jobCtx, cancel := context.WithTimeout(jobCtx, notificationJobTimeout)
+		defer cancel()
+
+		d.deliver(jobCtx, n, text)
+	}()
+}
+
+func (d *NotificationDispatcher) deliver(ctx context.Context, n *models.Notification, text NotificationText) {
+	prefs, err := d.settingsRepo.GetNotificationPreferences(ctx, n.UserID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to load notification preferences, using defaults", "recipient_id", n.UserID.String(), "error", err)
+		prefs = models.DefaultNotificationPreferences()
+	}
+	channels := prefs.Channels(n.Type.Category())
+
+	if n.ActorID != nil && n.ActorUsername == "" {
+		if actor, err := d.userRepo.GetByID(ctx, *n.ActorID); err == nil {
+			n.ActorUsername = actor.Username
+		}
+	}
+	link := d.link(n)
+
+	if channels.InApp {
+		d.deliverInApp(ctx, n)
+	}
+	if channels.Email {
+		d.deliverEmail(ctx, n, text, link)
+	}
+	if channels.Push {
+		err := d.pusher.Push(ctx, push.Message{
+			UserID: n.UserID.String(),
+			Title:  text.Title,
+			Body:   text.Body,
+			Link:   link,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to push notification", "type", n.Type, "recipient_id", n.UserID.String(), "error", err)
+		}
+	}
+}
+
+// deliverInApp stores the notification and pushes it to the user's open connections
+func (d *NotificationDispatcher) deliverInApp(ctx context.Context, n *models.Notification) {
+	if err := d.notificationRepo.Create(ctx, n); err != nil {
+		slog.ErrorContext(ctx, "Failed to create notification", "type", n.Type, "recipient_id", n.UserID.String(), "error", err)
+		return
+	}
+
+	if d.hub == nil {
+		return
+	}
+
+	d.hub.BroadcastEvent(ctx, n.UserID, websocket.MessageTypeNotification, websocket.NotificationPayload{
+		Notification: d.NotificationToDTO(n),
+	}, "", requestid.FromContext(ctx))
+}
+
+// deliverEmail emails the notification, if the user has a verified address
+func (d *NotificationDispatcher) deliverEmail(ctx context.Context, n *models.Notification, text NotificationText, link string) {
+	user, err := d.userRepo.GetByID(ctx, n.UserID)
+	if err != nil || user.Email == "" || user.VerifiedAt == nil {
+		return
+	}
+
+	body := text.Body + "\n"
+	if link != "" {
+		body += "\n" + link + "\n"
+	}
+	body += "\nTo choose which notifications you get by email, update your notification settings:\n" +
+		d.appBaseURL + "/settings/notifications\n"
+
+	err = d.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: text.Title,
+		Body:    body,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to email notification", "type", n.Type, "recipient_id", n.UserID.String(), "error", err)
+	}
+}
+
+// link is where the notification takes the user in the web app
+func (d *NotificationDispatcher) link(n *models.Notification) string {
+	if n.NoteID == nil {
+		return ""
+	}
+	if n.Type == models.NotificationTypeShareAccepted {
+		// The note is the recipient's own
+		return d.appBaseURL + "/notes/" + n.NoteID.String()
+	}
+	return d.appBaseURL + "/shared/notes/" + n.NoteID.String()
+}
+
+// NotificationToDTO converts a notification for API responses
+func (d *NotificationDispatcher) NotificationToDTO(n *models.Notification) models.NotificationDTO {
+	dto := models.NotificationDTO{
+		ID:            n.ID.String(),
+		Type:          string(n.Type),
+		NoteTitle:     n.NoteTitle,
+		ActorUsername: n.ActorUsername,
+		Link:          d.link(n),
+		CreatedAt:     n.CreatedAt.UTC().Format(ISO8601Format),
+	}
+	if n.NoteID != nil {
+		dto.NoteID = n.NoteID.String()
+	}
+	if n.ReadAt != nil {
+		readAt := n.ReadAt.UTC().Format(ISO8601Format)
+		dto.ReadAt = &readAt
+	}
+	return dto
+}
+
+// Preferences returns the user's channels for every category
+func (d *NotificationDispatcher) Preferences(ctx context.Context, userID uuid.UUID) (*models.NotificationPreferencesDTO, error) {
+	prefs, err := d.settingsRepo.GetNotificationPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return preferencesToDTO(prefs), nil
+}
+
+// UpdatePreferences changes the channels for the categories given, leaving the rest unchanged
+func (d *NotificationDispatcher) UpdatePreferences(ctx context.Context, userID uuid.UUID, dto *models.NotificationPreferencesDTO) (*models.NotificationPreferencesDTO, error) {
+	prefs, err := d.settingsRepo.GetNotificationPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for category, channels := range map[models.NotificationCategory]*models.NotificationChannels{
+		models.NotificationCategoryReminders: dto.Reminders,
+		models.NotificationCategoryShares:    dto.Shares,
+		models.NotificationCategoryMentions:  dto.Mentions,
+		models.NotificationCategorySecurity:  dto.Security,
+	} {
+		if channels != nil {
+			prefs[category] = *channels
+		}
+	}
+
+	if err := d.settingsRepo.SetNotificationPreferences(ctx, userID, prefs); err != nil {
+		return nil, err
+	}
+	return preferencesToDTO(prefs), nil
+}
+
+func preferencesToDTO(prefs models.NotificationPreferences) *models.NotificationPreferencesDTO {
+	reminders := prefs.Channels(models.NotificationCategoryReminders)
+	shares := prefs.Channels(models.NotificationCategoryShares)
+	mentions := prefs.Channels(models.NotificationCategoryMentions)
+	security := prefs.Channels(models.NotificationCategorySecurity)
+	return &models.NotificationPreferencesDTO{
+		Reminders: &reminders,
+		Shares:    &shares,
+		Mentions:  &mentions,
+		Security:  &security,
+	}
+}
@@ -0,0 +1,442 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/oauth"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+var (
+	ErrUnknownProvider       = errors.New("unknown sign-in provider")
+	ErrReturnURLNotAllowed   = errors.New("return URL not allowed")
+	ErrOAuthStateExpired     = errors.New("sign-in expired; start again")
+	ErrOAuthFailed           = errors.New("sign-in with provider failed")
+	ErrIdentityLinked        = errors.New("provider account is linked to another user")
+	ErrLastSignInMethod      = errors.New("can't remove the only way to sign in")
+	ErrOAuthLoginCodeExpired = errors.New("login code expired or already used")
+)
+
+// Errors sent back to the app in the return URL's error parameter
+const (
+	oauthErrorDenied  = "access_denied"
+	oauthErrorExpired = "expired"
+	oauthErrorFailed  = "failed"
+	oauthErrorLinked  = "already_linked"
+	oauthErrorClosed  = "registration_closed"
+)
+
+// OAuthService signs users in with Apple and Google, creating or linking accounts as needed
+type OAuthService struct {
+	repo         *repository.IdentityRepository
+	userRepo     *repository.UserRepository
+	webAuthnRepo *repository.WebAuthnRepository
+	authService  *AuthService
+	instance     *InstanceService
+	providers    map[string]*oauth.Provider
+	callbackBase string   // public URL of the API, which providers send users back to
+	returnURLs   []string // where apps may ask to be sent afterwards; the first is the default
+}
+
+func NewOAuthService(repo *repository.IdentityRepository, userRepo *repository.UserRepository, webAuthnRepo *repository.WebAuthnRepository,
+	authService *AuthService, instance *InstanceService, providers []*oauth.Provider, callbackBase string, returnURLs []string) *OAuthService {
+	byName := make(map[string]*oauth.Provider, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name] = provider
+	}
+	return &OAuthService{
+		repo:         repo,
+		userRepo:     userRepo,
+		webAuthnRepo: webAuthnRepo,
+		authService:  authService,
+		instance:     instance,
+		providers:    byName,
+		callbackBase: callbackBase,
+		returnURLs:   returnURLs,
+	}
+}
+
+// Providers returns the names of the configured providers
+func (s *OAuthService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Start begins signing in with a provider and returns where to send the user. When linkUserID is
+// set the provider account is linked to that user instead of signing in.
+func (s *OAuthService) Start(ctx context.Context, providerName, returnTo string, linkUserID *uuid.UUID) (string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+	if returnTo == "" {
+		returnTo = s.returnURLs[0]
+	} else if !slices.Contains(s.returnURLs, returnTo) {
+		return "", ErrReturnURLNotAllowed
+	}
+
+	stateValue, err1 := oauth.RandomString()
+	nonce, err2 := oauth.RandomString()
+	codeVerifier, err3 := oauth.RandomString()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return "", err
+	}
+	if !provider.PKCE() {
+		codeVerifier = ""
+	}
+
+	state := &models.OAuthState{
+		StateHash:    hashSecret(stateValue),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		UserID:       linkUserID,
+		ReturnTo:     returnTo,
+		ExpiresAt:    time.Now().Add(models.OAuthStateExpiry),
+	}
+	if err := s.repo.CreateState(ctx, state); err != nil {
+		return "", err
+	}
+
+	return provider.AuthURL(s.redirectURI(provider), stateValue, nonce, codeVerifier), nil
+}
+
+// Callback completes a sign-in when the provider sends the user back, and returns where to send
+// them next: the app's return URL with a login code, or with an error. It only fails outright when
+// the state is unknown, since then there's nowhere safe to send them.
+func (s *OAuthService) Callback(ctx context.Context, providerName, stateValue, code, providerError, clientIP string) (string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+	state, err := s.repo.UseState(ctx, hashSecret(stateValue), provider.Name)
+	if err != nil {
+		if errors.Is(err, repository.ErrOAuthStateNotFound) {
+			return "", ErrOAuthStateExpired
+		}
+		return "", err
+	}
+
+	if providerError != "" || code == "" {
+		logging.Security(ctx, "Sign-in cancelled", "provider", provider.Name, "ip", clientIP, "error", providerError)
+		return returnURL(state.ReturnTo, "error", oauthErrorDenied), nil
+	}
+
+	identity, err := provider.Exchange(ctx, code, s.redirectURI(provider), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		logging.Security(ctx, "Failed sign-in", "provider", provider.Name, "ip", clientIP, "error", err)
+		return returnURL(state.ReturnTo, "error", oauthErrorFailed), nil
+	}
+
+	user, err := s.resolveUser(ctx, provider.Name, identity, state.UserID, clientIP)
+	if err != nil {
+		if errors.Is(err, ErrIdentityLinked) {
+			return returnURL(state.ReturnTo, "error", oauthErrorLinked), nil
+		}
+		if errors.Is(err, ErrRegistrationClosed) {
+			return returnURL(state.ReturnTo, "error", oauthErrorClosed), nil
+		}
+		slog.ErrorContext(ctx, "Sign-in failed", "provider", provider.Name, "error", err)
+		return returnURL(state.ReturnTo, "error", oauthErrorFailed), nil
+	}
+	if state.UserID != nil {
+		return returnURL(state.ReturnTo, "linked", provider.Name), nil
+	}
+
+	loginCode, err := oauth.RandomString()
+	if err != nil {
+		return "", err
+	}
+	if err := s.repo.CreateLoginCode(ctx, hashSecret(loginCode), user.ID, provider.Name, time.Now().Add(models.OAuthLoginCodeExpiry)); err != nil {
+		return "", err
+	}
+	return returnURL(state.ReturnTo, "code", loginCode), nil
+}
+
+// ExpiredReturnURL is where to send a user whose sign-in state was unknown
+func (s *OAuthService) ExpiredReturnURL() string {
+	return returnURL(s.returnURLs[0], "error", oauthErrorExpired)
+}
+
+// Exchange trades a login code from Callback for the user's tokens
+func (s *OAuthService) Exchange(ctx context.Context, code, clientIP string) (*models.User, *TokenPair, error) {
+	userID, provider, err := s.repo.UseLoginCode(ctx, hashSecret(code))
+	if err != nil {
+		if errors.Is(err, repository.ErrLoginCodeNotFound) {
+			logging.Security(ctx, "Invalid sign-in code", "ip", clientIP)
+			return nil, nil, ErrOAuthLoginCodeExpired
+		}
+		return nil, nil, err
+	}
+	return s.login(ctx, userID, provider, clientIP)
+}
+
+// LoginWithIDToken signs in with an ID token a native app got from the provider's SDK. The token
+// must be signed by the provider, unexpired and issued to one of the configured clients; that
+// audience check is what keeps out tokens issued to other apps. Its nonce has to match the one
+// sent with it, but the app chose that nonce itself, so this doesn't stop replay: whoever holds a
+// token and its nonce can sign in with them until the token expires.
+func (s *OAuthService) LoginWithIDToken(ctx context.Context, providerName, idToken, nonce, clientIP string) (*models.User, *TokenPair, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, nil, ErrUnknownProvider
+	}
+	identity, tokenNonce, err := provider.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		logging.Security(ctx, "Failed sign-in", "provider", provider.Name, "ip", clientIP, "error", err)
+		return nil, nil, ErrOAuthFailed
+	}
+	if !oauth.NonceMatches(tokenNonce, nonce) {
+		logging.Security(ctx, "Failed sign-in - nonce mismatch", "provider", provider.Name, "ip", clientIP)
+		return nil, nil, ErrOAuthFailed
+	}
+
+	user, err := s.resolveUser(ctx, provider.Name, identity, nil, clientIP)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.login(ctx, user.ID, provider.Name, clientIP)
+}
+
+// Identities returns the provider accounts linked to the user
+func (s *OAuthService) Identities(ctx context.Context, userID uuid.UUID) ([]models.UserIdentity, error) {
+	return s.repo.ListByUser(ctx, userID)
+}
+
+// Unlink removes one of the user's provider accounts, unless the user would be left with no way to
+// sign in
+func (s *OAuthService) Unlink(ctx context.Context, userID, id uuid.UUID, clientIP string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	identities, err := s.repo.ListByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(identities, func(identity models.UserIdentity) bool { return identity.ID == id }) {
+		return repository.ErrIdentityNotFound
+	}
+	if user.PasswordHash == "" && len(identities) == 1 {
+		passkeys, err := s.webAuthnRepo.CountCredentials(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if passkeys == 0 {
+			return ErrLastSignInMethod
+		}
+	}
+
+	if err := s.repo.Delete(ctx, id, userID); err != nil {
+		return err
+	}
+	logging.Security(ctx, "Linked account removed", "account_id", id.String(), "username", user.Username, "user_id", userID.String(), "ip", clientIP)
+	return nil
+}
+
+// CleanupExpired removes sign-in states and login codes that were never used
+func (s *OAuthService) CleanupExpired(ctx context.Context) (int64, error) {
+	return s.repo.DeleteExpired(ctx)
+}
+
+// login issues tokens for a user who signed in with a provider
+func (s *OAuthService) login(ctx context.Context, userID uuid.UUID, provider, clientIP string) (*models.User, *TokenPair, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens, err := s.authService.LoginVerified(ctx, user, provider, clientIP)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, tokens, nil
+}
+
+// resolveUser finds the user a provider account belongs to. An account that isn't linked yet is
+// linked to linkUserID if given, else to the user with the same verified email address, else to a
+// new user.
+func (s *OAuthService) resolveUser(ctx context.Context, provider string, identity *oauth.Identity, linkUserID *uuid.UUID, clientIP string) (*models.User, error) {
+	existing, err := s.repo.Get(ctx, provider, identity.Subject)
+	switch {
+	case err == nil:
+		if linkUserID != nil && *linkUserID != existing.UserID {
+			logging.Security(ctx, "Account already linked to another user, rejected", "provider", provider, "user_id", linkUserID.String(), "ip", clientIP)
+			return nil, ErrIdentityLinked
+		}
+		if err := s.repo.RecordUse(ctx, existing.ID, identity.Email); err != nil {
+			return nil, err
+		}
+		return s.userRepo.GetByID(ctx, existing.UserID)
+	case !errors.Is(err, repository.ErrIdentityNotFound):
+		return nil, err
+	}
+
+	var user *models.User
+	switch {
+	case linkUserID != nil:
+		user, err = s.userRepo.GetByID(ctx, *linkUserID)
+	case identity.EmailVerified:
+		// Only addresses both sides verified are trusted to be the same person
+		user, err = s.userRepo.GetByEmail(ctx, identity.Email)
+		if err == nil && user.VerifiedAt == nil {
+			user, err = nil, repository.ErrUserNotFound
+		}
+		if errors.Is(err, repository.ErrUserNotFound) {
+			user, err = s.createUser(ctx, provider, identity, clientIP)
+		}
+	default:
+		user, err = s.createUser(ctx, provider, identity, clientIP)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(ctx, &models.UserIdentity{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Provider:  provider,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		if errors.Is(err, repository.ErrIdentityExists) {
+			return nil, ErrIdentityLinked // linked by a concurrent sign-in
+		}
+		return nil, err
+	}
+	logging.Security(ctx, "Account linked", "provider", provider, "username", user.Username, "user_id", user.ID.String(), "ip", clientIP)
+	return user, nil
+}
+
+// createUser creates a user without a password for a provider account. The provider's email is
+// used only if it verified it and no one else has it.
+func (s *OAuthService) createUser(ctx context.Context, provider string, identity *oauth.Identity, clientIP string) (*models.User, error) {
+	if err := s.instance.CheckRegistrationOpen(ctx); err != nil {
+		return nil, err
+	}
+
+	email := ""
+	if identity.EmailVerified {
+		// Left off if an account that never verified the address holds it
+		_, err := s.userRepo.GetByEmail(ctx, identity.Email)
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			email = identity.Email
+		case err != nil:
+			return nil, err
+		}
+	}
+	base := usernameFromEmail(email, provider)
+
+	now := time.Now()
+	for attempt := 0; attempt < 5; attempt++ {
+		username := base
+		if attempt > 0 {
+			suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+			if err != nil {
+				return nil, err
+			}
+			username = fmt.Sprintf("%s%04d", base, suffix.Int64())
+		}
+
+		user := &models.User{
+			ID:        uuid.New(),
+			Username:  username,
+			Email:     email,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := s.userRepo.Create(ctx, user); err != nil {
+			if errors.Is(err, repository.ErrUserExists) {
+				continue
+			}
+			return nil, err
+		}
+
+		if email != "" {
+			if err := s.userRepo.MarkEmailVerified(ctx, user.ID, email, now); err != nil {
+				return nil, err
+			}
+			user.VerifiedAt = &now
+		}
+		logging.Security(ctx, "User registered", "provider", provider, "username", username, "user_id", user.ID.String(), "ip", clientIP)
+		return user, nil
+	}
+	return nil, fmt.Errorf("no free username for %q", base)
+}
+
+// usernameFromEmail suggests a username from the local part of an email address, falling back to
+// one based on the provider
+func usernameFromEmail(email, provider string) string {
+	local, _, _ := strings.Cut(email, "@")
+	var b strings.Builder
+	for _, r := range strings.ToLower(local) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+		if b.Len() == 30 {
+			break
+		}
+	}
+	if b.Len() < 3 {
+		return provider + "user"
+	}
+	return b.String()
+}
+
+// redirectURI is where the provider sends users back to
+func (s *OAuthService) redirectURI(provider *oauth.Provider) string {
+	return s.callbackBase + "/api/auth/oauth/" + provider.Name + "/callback"
+}
+
+// returnURL adds a query parameter to an allowed return URL
+func returnURL(base, key, value string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// hashSecret hashes random secrets such as sign-in states and login codes, which are stored only
+// as hashes
+func hashSecret(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+// UserIdentityToDTO converts a linked provider account for the API
+func UserIdentityToDTO(identity *models.UserIdentity) models.UserIdentityDTO {
+	dto := models.UserIdentityDTO{
+		ID:        identity.ID.String(),
+		Provider:  identity.Provider,
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt.Format(time.RFC3339),
+	}
+	if identity.LastUsedAt != nil {
+		lastUsed := identity.LastUsedAt.Format(time.RFC3339)
+		dto.LastUsedAt = &lastUsed
+	}
+	return dto
+}
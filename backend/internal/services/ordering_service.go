@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+var ErrInvalidOrderingContext = errors.New("invalid ordering context: use 'all', 'folder:<id>' or 'tag:<name>'")
+
+// OrderingService keeps a separate manual note order for each view, so reordering a folder or tag
+// view doesn't reorder the main list
+type OrderingService struct {
+	orderingRepo *repository.OrderingRepository
+	noteRepo     *repository.NoteRepository
+}
+
+func NewOrderingService(orderingRepo *repository.OrderingRepository, noteRepo *repository.NoteRepository) *OrderingService {
+	return &OrderingService{
+		orderingRepo: orderingRepo,
+		noteRepo:     noteRepo,
+	}
+}
+
+// GetOrder returns the ordered note IDs of a context
+func (s *OrderingService) GetOrder(ctx context.Context, userID uuid.UUID, orderingContext string) ([]uuid.UUID, error) {
+	if !models.IsValidOrderingContext(orderingContext) {
+		return nil, ErrInvalidOrderingContext
+	}
+	if orderingContext == models.OrderingContextAll {
+		return s.orderingRepo.GetMainOrder(ctx, userID)
+	}
+	return s.orderingRepo.GetOrder(ctx, userID, orderingContext)
+}
+
+// SetOrder replaces the order of a context with noteIDs. The main list order is stored on the notes
+// themselves (sortOrder) so existing clients keep syncing it.
+func (s *OrderingService) SetOrder(ctx context.Context, userID uuid.UUID, orderingContext string, noteIDs []uuid.UUID) error {
+	if !models.IsValidOrderingContext(orderingContext) {
+		return ErrInvalidOrderingContext
+	}
+	if orderingContext == models.OrderingContextAll {
+		return s.noteRepo.SetSortOrders(ctx, userID, noteIDs)
+	}
+	return s.orderingRepo.SetOrder(ctx, userID, orderingContext, noteIDs)
+}
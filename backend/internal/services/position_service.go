@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+// PositionService saves where users are in their notes. Positions are written separately from the
+// notes, so scrolling never touches a note's content, revisions or updatedAt.
+type PositionService struct {
+	repo *repository.PositionRepository
+}
+
+func NewPositionService(repo *repository.PositionRepository) *PositionService {
+	return &PositionService{repo: repo}
+}
+
+// Get returns the user's position in a note
+func (s *PositionService) Get(ctx context.Context, noteID, userID uuid.UUID) (*models.NotePositionDTO, error) {
+	position, err := s.repo.Get(ctx, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	dto := positionToDTO(position)
+	return &dto, nil
+}
+
+// Set saves the user's position in a note as of now
+func (s *PositionService) Set(ctx context.Context, noteID, userID uuid.UUID, deviceID string, dto *models.NotePositionDTO) (*models.NotePositionDTO, error) {
+	position := &models.NotePosition{
+		NoteID:    noteID,
+		UserID:    userID,
+		Caret:     dto.Caret,
+		Scroll:    dto.Scroll,
+		DeviceID:  deviceID,
+		UpdatedAt: time.Now(),
+	}
+	if err := s.repo.Set(ctx, position); err != nil {
+		return nil, err
+	}
+	saved := positionToDTO(position)
+	return &saved, nil
+}
+
+// dtoToPosition converts a validated position sent with a sync. Its time is the client's, so a
+// position set offline doesn't replace a later one from another device, but never later than now.
+func dtoToPosition(dto models.NotePositionDTO, userID uuid.UUID) *models.NotePosition {
+	noteID, _ := uuid.Parse(dto.NoteID)
+	now := time.Now()
+	updatedAt, err := time.Parse(ISO8601Format, dto.UpdatedAt)
+	if err != nil || updatedAt.After(now) {
+		updatedAt = now
+	}
+	return &models.NotePosition{
+		NoteID:    noteID,
+		UserID:    userID,
+		Caret:     dto.Caret,
+		Scroll:    dto.Scroll,
+		DeviceID:  dto.DeviceID,
+		UpdatedAt: updatedAt,
+	}
+}
+
+func positionToDTO(position *models.NotePosition) models.NotePositionDTO {
+	return models.NotePositionDTO{
+		NoteID:    position.NoteID.String(),
+		Caret:     position.Caret,
+		Scroll:    position.Scroll,
+		DeviceID:  position.DeviceID,
+		UpdatedAt: position.UpdatedAt.UTC().Format(ISO8601Format),
+	}
+}
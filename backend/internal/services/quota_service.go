@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+// Quotas limit what each user may store in notes. Zero values are unlimited.
+type Quotas struct {
+	MaxNotes          int64
+	MaxNoteBytes      int64 // title, content and checklist item text of one note
+	MaxTotalBytes     int64 // the same plus attachment sizes, over all the user's notes
+	MaxChecklistItems int64 // per note
+}
+
+// QuotaExceededError is returned for a write that would take the user past a quota
+type QuotaExceededError struct {
+	Quota string // one of the models.Quota* names
+	Limit int64
+	Used  int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded: %d of %d", e.Quota, e.Used, e.Limit)
+}
+
+// noteBytes is how much of a user's storage a note takes up
+func noteBytes(note *models.Note) int64 {
+	size := int64(len(note.Title) + len(note.Content))
+	for _, item := range note.ChecklistItems {
+		size += int64(len(item.Text))
+	}
+	return size
+}
+
+// checkNote checks a note being saved is within the per-note quotas. These apply to every save,
+// like the field length limits.
+func (q Quotas) checkNote(note *models.Note) error {
+	if size := noteBytes(note); q.MaxNoteBytes > 0 && size > q.MaxNoteBytes {
+		return &QuotaExceededError{Quota: models.QuotaNoteBytes, Limit: q.MaxNoteBytes, Used: size}
+	}
+	if items := int64(len(note.ChecklistItems)); q.MaxChecklistItems > 0 && items > q.MaxChecklistItems {
+		return &QuotaExceededError{Quota: models.QuotaChecklistItems, Limit: q.MaxChecklistItems, Used: items}
+	}
+	return nil
+}
+
+// checkGrowth checks a change from before to after stays within the per-user quotas. Only growth is
+// refused, so users over a quota, for instance after it was lowered, can still trim and delete notes.
+func (q Quotas) checkGrowth(before, after repository.NoteUsage) error {
+	if q.MaxNotes > 0 && after.Notes > q.MaxNotes && after.Notes > before.Notes {
+		return &QuotaExceededError{Quota: models.QuotaNotes, Limit: q.MaxNotes, Used: after.Notes}
+	}
+	if q.MaxTotalBytes > 0 && after.Bytes > q.MaxTotalBytes && after.Bytes > before.Bytes {
+		return &QuotaExceededError{Quota: models.QuotaTotalBytes, Limit: q.MaxTotalBytes, Used: after.Bytes}
+	}
+	return nil
+}
+
+// limited reports whether any per-user quota is set, so usage needs counting
+func (q Quotas) limited() bool {
+	return q.MaxNotes > 0 || q.MaxTotalBytes > 0
+}
+
+// QuotaService enforces storage quotas on notes saved through the REST and WebSocket APIs, and
+// reports usage. Sync enforces them itself (see SyncService.SetQuotas).
+type QuotaService struct {
+	noteRepo *repository.NoteRepository
+	quotas   Quotas
+}
+
+func NewQuotaService(noteRepo *repository.NoteRepository, quotas Quotas) *QuotaService {
+	return &QuotaService{noteRepo: noteRepo, quotas: quotas}
+}
+
+// CheckSave checks the user may save note, new or changed, returning a *QuotaExceededError if not.
+// Concurrent saves are checked independently, so together they may overshoot a quota slightly.
+func (s *QuotaService) CheckSave(ctx context.Context, note *models.Note) error {
+	if err := s.quotas.checkNote(note); err != nil {
+		return err
+	}
+	if !s.quotas.limited() {
+		return nil
+	}
+
+	others, existing, err := s.noteRepo.Usage(repository.ReadPrimary(ctx), note.UserID, note.ID)
+	if err != nil {
+		return err
+	}
+	before := repository.NoteUsage{Notes: others.Notes + existing.Notes, Bytes: others.Bytes + existing.Bytes}
+	// Saving a note leaves its attachments as they are
+	after := repository.NoteUsage{Notes: others.Notes + 1, Bytes: others.Bytes + noteBytes(note) + existing.AttachmentBytes}
+	return s.quotas.checkGrowth(before, after)
+}
+
+// CheckUpload checks the user may attach size more bytes to their notes, returning a
+// *QuotaExceededError if not. Like CheckSave, concurrent uploads are checked independently.
+func (s *QuotaService) CheckUpload(ctx context.Context, userID uuid.UUID, size int64) error {
+	if s.quotas.MaxTotalBytes <= 0 {
+		return nil
+	}
+
+	before, _, err := s.noteRepo.Usage(repository.ReadPrimary(ctx), userID, uuid.Nil)
+	if err != nil {
+		return err
+	}
+	after := before
+	after.Bytes += size
+	return s.quotas.checkGrowth(before, after)
+}
+
+// Usage returns what the user stores and the quotas that apply
+func (s *QuotaService) Usage(ctx context.Context, userID uuid.UUID) (*models.UsageDTO, error) {
+	usage, _, err := s.noteRepo.Usage(ctx, userID, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+	return &models.UsageDTO{
+		Notes:           usage.Notes,
+		Bytes:           usage.Bytes,
+		AttachmentBytes: usage.AttachmentBytes,
+		Limits: models.QuotaLimitsDTO{
+			MaxNotes:          s.quotas.MaxNotes,
+			MaxNoteBytes:      s.quotas.MaxNoteBytes,
+			MaxTotalBytes:     s.quotas.MaxTotalBytes,
+			MaxChecklistItems: s.quotas.MaxChecklistItems,
+		},
+	}, nil
+}
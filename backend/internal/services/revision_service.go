@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/diff"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+// RevisionService exposes a note's saved revisions and the differences between them, so clients
+// can show history without diffing themselves
+type RevisionService struct {
+	revisionRepo *repository.RevisionRepository
+}
+
+func NewRevisionService(revisionRepo *repository.RevisionRepository) *RevisionService {
+	return &RevisionService{revisionRepo: revisionRepo}
+}
+
+// List returns a note's revisions, newest first
+func (s *RevisionService) List(ctx context.Context, userID, noteID uuid.UUID) ([]models.RevisionDTO, error) {
+	revisions, err := s.revisionRepo.ListByNote(ctx, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	dtos := make([]models.RevisionDTO, len(revisions))
+	for i, revision := range revisions {
+		dtos[i] = models.RevisionDTO{
+			ID:         revision.ID.String(),
+			NoteID:     revision.NoteID.String(),
+			Title:      revision.Note.Title,
+			RecordedAt: revision.RecordedAt.UTC().Format(ISO8601Format),
+		}
+	}
+	return dtos, nil
+}
+
+// Diff compares revision revID of a note with revision againstID, or with the revision before it
+// if againstID is nil. Returns repository.ErrRevisionNotFound if either revision doesn't exist.
+func (s *RevisionService) Diff(ctx context.Context, userID, noteID, revID uuid.UUID, againstID *uuid.UUID) (*models.NoteDiffDTO, error) {
+	revision, err := s.revisionRepo.GetByID(ctx, revID, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var against *models.NoteRevision
+	if againstID != nil {
+		if against, err = s.revisionRepo.GetByID(ctx, *againstID, noteID, userID); err != nil {
+			return nil, err
+		}
+	} else {
+		against, err = s.revisionRepo.GetPrevious(ctx, revision)
+		if err != nil && !errors.Is(err, repository.ErrRevisionNotFound) {
+			return nil, err
+		}
+	}
+
+	// The first revision is compared with an empty note
+	var old models.Note
+	result := &models.NoteDiffDTO{
+		NoteID:   noteID.String(),
+		Revision: revision.ID.String(),
+	}
+	if against != nil {
+		old = against.Note
+		result.Against = against.ID.String()
+	}
+
+	result.Title = spansToDTO(diff.Words(old.Title, revision.Note.Title))
+	if result.Title == nil {
+		result.Title = []models.DiffSpanDTO{}
+	}
+	result.Content = linesToDTO(diff.Lines(old.Content, revision.Note.Content))
+	if len(old.ChecklistItems) > 0 || len(revision.Note.ChecklistItems) > 0 {
+		result.ChecklistItems = linesToDTO(diff.LineSlices(checklistLines(old.ChecklistItems), checklistLines(revision.Note.ChecklistItems)))
+	}
+	return result, nil
+}
+
+// checklistLines renders checklist items in order as "[ ] text" / "[x] text"
+func checklistLines(items []models.ChecklistItem) []string {
+	sorted := append([]models.ChecklistItem(nil), items...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].SortOrder < sorted[j].SortOrder })
+
+	lines := make([]string, len(sorted))
+	for i, item := range sorted {
+		if item.IsCompleted {
+			lines[i] = "[x] " + item.Text
+		} else {
+			lines[i] = "[ ] " + item.Text
+		}
+	}
+	return lines
+}
+
+func linesToDTO(lines []diff.Line) []models.DiffLineDTO {
+	dtos := make([]models.DiffLineDTO, len(lines))
+	for i, line := range lines {
+		dtos[i] = models.DiffLineDTO{
+			Op:    string(line.Op),
+			Text:  line.Text,
+			Spans: spansToDTO(line.Spans),
+		}
+	}
+	return dtos
+}
+
+func spansToDTO(spans []diff.Span) []models.DiffSpanDTO {
+	if len(spans) == 0 {
+		return nil
+	}
+	dtos := make([]models.DiffSpanDTO, len(spans))
+	for i, span := range spans {
+		dtos[i] = models.DiffSpanDTO{Op: string(span.Op), Text: span.Text}
+	}
+	return dtos
+}
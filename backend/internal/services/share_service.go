@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/mail"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+var (
+	ErrInvalidInvite  = errors.New("invalid or expired invite")
+	ErrInviteAccepted = errors.New("invite already accepted")
+	ErrInviteOwnNote  = errors.New("cannot accept an invite to your own note")
+	ErrInviteDelivery = errors.New("failed to send invite email")
+)
+
+// ShareService manages note collaborators and email invitations
+type ShareService struct {
+	shareRepo    *repository.ShareRepository
+	noteRepo     *repository.NoteRepository
+	userRepo     *repository.UserRepository
+	mailer       mail.Mailer
+	secret       []byte
+	appBaseURL   string
+	inviteExpiry time.Duration
+	dispatcher   *NotificationDispatcher
+}
+
+func NewShareService(shareRepo *repository.ShareRepository, noteRepo *repository.NoteRepository, userRepo *repository.UserRepository, mailer mail.Mailer, secret string, appBaseURL string, inviteExpiryHours int, dispatcher *NotificationDispatcher) *ShareService {
+	return &ShareService{
+		shareRepo:    shareRepo,
+		noteRepo:     noteRepo,
+		userRepo:     userRepo,
+		mailer:       mailer,
+		secret:       []byte(secret),
+		appBaseURL:   strings.TrimRight(appBaseURL, "/"),
+		inviteExpiry: time.Duration(inviteExpiryHours) * time.Hour,
+		dispatcher:   dispatcher,
+	}
+}
+
+// CreateInvite invites an email address to collaborate on one of the owner's notes
+func (s *ShareService) CreateInvite(ctx context.Context, ownerID, noteID uuid.UUID, email string) (*models.NoteInvite, error) {
+	note, err := s.noteRepo.GetByID(ctx, noteID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invite := &models.NoteInvite{
+		ID:        uuid.New(),
+		NoteID:    note.ID,
+		InvitedBy: ownerID,
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		ExpiresAt: now.Add(s.inviteExpiry),
+		CreatedAt: now,
+		SentAt:    now,
+	}
+
+	if err := s.shareRepo.CreateInvite(ctx, invite); err != nil {
+		return nil, err
+	}
+
+	logging.Security(ctx, "Note shared via invite", "note_id", note.ID.String(), "user_id", ownerID.String(), "invite_id", invite.ID.String())
+
+	if err := s.sendInvite(ctx, invite, note); err != nil {
+		return invite, err
+	}
+	return invite, nil
+}
+
+// ResendInvite sends a pending invite again and extends its expiry
+func (s *ShareService) ResendInvite(ctx context.Context, ownerID, noteID, inviteID uuid.UUID) (*models.NoteInvite, error) {
+	note, err := s.noteRepo.GetByID(ctx, noteID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	invite, err := s.shareRepo.GetInvite(ctx, inviteID)
+	if err != nil {
+		return nil, err
+	}
+	if invite.NoteID != note.ID {
+		return nil, repository.ErrInviteNotFound
+	}
+	if invite.AcceptedAt != nil {
+		return nil, ErrInviteAccepted
+	}
+
+	invite.ExpiresAt = time.Now().Add(s.inviteExpiry)
+	invite.SentAt = time.Now()
+	if err := s.shareRepo.RenewInvite(ctx, invite.ID, invite.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	if err := s.sendInvite(ctx, invite, note); err != nil {
+		return invite, err
+	}
+	return invite, nil
+}
+
+// ListInvites returns the invites for one of the owner's notes
+func (s *ShareService) ListInvites(ctx context.Context, ownerID, noteID uuid.UUID) ([]models.NoteInvite, error) {
+	if _, err := s.noteRepo.GetByID(ctx, noteID, ownerID); err != nil {
+		return nil, err
+	}
+	return s.shareRepo.ListInvites(ctx, noteID)
+}
+
+// RevokeInvite deletes an invite so its link stops working
+func (s *ShareService) RevokeInvite(ctx context.Context, ownerID, noteID, inviteID uuid.UUID) error {
+	if _, err := s.noteRepo.GetByID(ctx, noteID, ownerID); err != nil {
+		return err
+	}
+	return s.shareRepo.DeleteInvite(ctx, inviteID, noteID)
+}
+
+// AcceptInvite verifies an invite token and grants the user access to the invited note
+func (s *ShareService) AcceptInvite(ctx context.Context, token string, userID uuid.UUID) (*models.NoteInvite, error) {
+	inviteID, err := s.verifyInviteToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	invite, err := s.shareRepo.GetInvite(ctx, inviteID)
+	if err != nil {
+		if errors.Is(err, repository.ErrInviteNotFound) {
+			return nil, ErrInvalidInvite
+		}
+		return nil, err
+	}
+	if invite.InvitedBy == userID {
+		return nil, ErrInviteOwnNote
+	}
+	if invite.AcceptedAt != nil {
+		return nil, ErrInviteAccepted
+	}
+	if time.Now().After(invite.ExpiresAt) {
+		return nil, ErrInvalidInvite
+	}
+
+	if err := s.shareRepo.AcceptInvite(ctx, invite, userID); err != nil {
+		if errors.Is(err, repository.ErrInviteNotFound) {
+			return nil, ErrInvalidInvite
+		}
+		return nil, err
+	}
+
+	logging.Security(ctx, "Invite accepted", "invite_id", invite.ID.String(), "user_id", userID.String(), "note_id", invite.NoteID.String())
+	s.notifyAccepted(ctx, invite, userID)
+	return invite, nil
+}
+
+// notifyAccepted tells the note's owner that a collaborator joined
+func (s *ShareService) notifyAccepted(ctx context.Context, invite *models.NoteInvite, userID uuid.UUID) {
+	notification := &models.Notification{
+		ID:        uuid.New(),
+		UserID:    invite.InvitedBy,
+		Type:      models.NotificationTypeShareAccepted,
+		NoteID:    &invite.NoteID,
+		ActorID:   &userID,
+		CreatedAt: time.Now(),
+	}
+	if note, err := s.noteRepo.GetByID(ctx, invite.NoteID, invite.InvitedBy); err == nil {
+		notification.NoteTitle = note.Title
+	}
+	if user, err := s.userRepo.GetByID(ctx, userID); err == nil {
+		notification.ActorUsername = user.Username
+	}
+
+	s.dispatcher.Dispatch(ctx, notification, NotificationText{
+		Title: notification.ActorUsername + " joined your note",
+		Body:  fmt.Sprintf("%s accepted your invitation to collaborate on \"%s\".", notification.ActorUsername, notification.NoteTitle),
+	})
+}
+
+// SharedNotes returns the notes other users have shared with userID
+func (s *ShareService) SharedNotes(ctx context.Context, userID uuid.UUID) ([]models.Note, error) {
+	return s.noteRepo.GetSharedWithUser(ctx, userID)
+}
+
+// SharedNote returns a single note shared with userID
+func (s *ShareService) SharedNote(ctx context.Context, noteID, userID uuid.UUID) (*models.Note, error) {
+	return s.noteRepo.GetSharedByID(ctx, noteID, userID)
+}
+
+// NoteAudience returns the users who can see a note: its owner and collaborators. userID must be
+// one of them, or repository.ErrNoteNotFound is returned.
+func (s *ShareService) NoteAudience(ctx context.Context, userID, noteID uuid.UUID) ([]uuid.UUID, error) {
+	note, err := s.noteRepo.GetByID(ctx, noteID, userID)
+	if errors.Is(err, repository.ErrNoteNotFound) {
+		note, err = s.noteRepo.GetSharedByID(ctx, noteID, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	collaborators, err := s.shareRepo.GetCollaborators(ctx, note.ID)
+	if err != nil {
+		return nil, err
+	}
+	audience := []uuid.UUID{note.UserID}
+	for _, id := range collaborators {
+		if id != note.UserID {
+			audience = append(audience, id)
+		}
+	}
+	return audience, nil
+}
+
+// InviteToDTO converts an invite for API responses
+func (s *ShareService) InviteToDTO(invite *models.NoteInvite) models.InviteDTO {
+	dto := models.InviteDTO{
+		ID:        invite.ID.String(),
+		NoteID:    invite.NoteID.String(),
+		Email:     invite.Email,
+		ExpiresAt: invite.ExpiresAt.UTC().Format(ISO8601Format),
+		CreatedAt: invite.CreatedAt.UTC().Format(ISO8601Format),
+		SentAt:    invite.SentAt.UTC().Format(ISO8601Format),
+	}
+	if invite.AcceptedAt != nil {
+		acceptedAt := invite.AcceptedAt.UTC().Format(ISO8601Format)
+		dto.AcceptedAt = &acceptedAt
+	}
+	return dto
+}
+
+func (s *ShareService) sendInvite(ctx context.Context, invite *models.NoteInvite, note *models.Note) error {
+	inviter := "Someone"
+	if user, err := s.userRepo.GetByID(ctx, invite.InvitedBy); err == nil {
+		inviter = user.Username
+	}
+
+	title := note.Title
+	if title == "" {
+		title = "Untitled note"
+	}
+
+	link := s.appBaseURL + "/register?invite=" + url.QueryEscape(s.inviteToken(invite.ID))
+	body := fmt.Sprintf("%s has invited you to collaborate on the note \"%s\".\n\n"+
+		"Create an account to get access:\n%s\n\n"+
+		"This invitation expires on %s. If you weren't expecting it, you can ignore this email.\n",
+		inviter, title, link, invite.ExpiresAt.UTC().Format("2 Jan 2006 15:04 MST"))
+
+	err := s.mailer.Send(ctx, mail.Message{
+		To:      invite.Email,
+		Subject: inviter + " shared a note with you",
+		Body:    body,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to send invite", "invite_id", invite.ID.String(), "error", err)
+		return ErrInviteDelivery
+	}
+	return nil
+}
+
+// inviteToken signs an invite ID: "<invite-id>.<base64url HMAC-SHA256>"
+func (s *ShareService) inviteToken(inviteID uuid.UUID) string {
+	return inviteID.String() + "." + base64.RawURLEncoding.EncodeToString(s.inviteSignature(inviteID))
+}
+
+func (s *ShareService) verifyInviteToken(token string) (uuid.UUID, error) {
+	idPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.Nil, ErrInvalidInvite
+	}
+	inviteID, err := uuid.Parse(idPart)
+	if err != nil {
+		return uuid.Nil, ErrInvalidInvite
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil || !hmac.Equal(sig, s.inviteSignature(inviteID)) {
+		return uuid.Nil, ErrInvalidInvite
+	}
+	return inviteID, nil
+}
+
+func (s *ShareService) inviteSignature(inviteID uuid.UUID) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("note-invite:" + inviteID.String()))
+	return mac.Sum(nil)
+}
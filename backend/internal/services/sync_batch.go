@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// batchRecorder captures the state of each note before a sync changes it. The batch row is only
+// written once the first note is captured, so syncs that change nothing leave no batch behind.
+type batchRecorder struct {
+	repo     *repository.SyncBatchRepository
+	noteRepo *repository.NoteRepository
+	batch    models.SyncBatch
+	saved    bool
+	captured map[uuid.UUID]bool
+}
+
+func (s *SyncService) newBatchRecorder(ctx context.Context, userID uuid.UUID) *batchRecorder {
+	return &batchRecorder{
+		repo:     s.batchRepo,
+		noteRepo: s.noteRepo,
+		batch: models.SyncBatch{
+			ID:        uuid.New(),
+			UserID:    userID,
+			RequestID: requestid.FromContext(ctx),
+			CreatedAt: time.Now(),
+		},
+		captured: make(map[uuid.UUID]bool),
+	}
+}
+
+// capture records a note's current state, before it is changed
+func (b *batchRecorder) capture(ctx context.Context, noteID uuid.UUID) error {
+	if b.captured[noteID] {
+		return nil
+	}
+
+	note, err := b.noteRepo.GetByID(ctx, noteID, b.batch.UserID)
+	if err != nil && !errors.Is(err, repository.ErrNoteNotFound) {
+		return err
+	}
+	return b.record(ctx, noteID, note)
+}
+
+// captureCreated records a note the batch created
+func (b *batchRecorder) captureCreated(ctx context.Context, noteID uuid.UUID) error {
+	if b.captured[noteID] {
+		return nil
+	}
+	return b.record(ctx, noteID, nil)
+}
+
+func (b *batchRecorder) record(ctx context.Context, noteID uuid.UUID, preImage *models.Note) error {
+	if !b.saved {
+		if err := b.repo.Create(ctx, &b.batch); err != nil {
+			return err
+		}
+		b.saved = true
+	}
+	if err := b.repo.AddNote(ctx, b.batch.ID, noteID, preImage); err != nil {
+		return err
+	}
+	b.captured[noteID] = true
+	return nil
+}
+
+// id returns the batch ID, or "" if nothing was captured
+func (b *batchRecorder) id() string {
+	if !b.saved {
+		return ""
+	}
+	return b.batch.ID.String()
+}
+
+// Batches returns the user's recent sync batches, newest first
+func (s *SyncService) Batches(ctx context.Context, userID uuid.UUID, limit int) ([]models.SyncBatch, error) {
+	return s.batchRepo.ListByUser(ctx, userID, limit)
+}
+
+// RevertBatch puts every note a sync batch changed back to its state before the batch: notes it
+// created are deleted, and notes it edited or deleted are restored. Later edits to those notes are
+// overwritten. The revert is itself recorded as a batch, whose ID is returned so it can be undone too.
+func (s *SyncService) RevertBatch(ctx context.Context, userID, batchID uuid.UUID) (*models.SyncResponse, error) {
+	ctx, span := startSpan(ctx, "SyncService.RevertBatch", attribute.String("sync.batch_id", batchID.String()))
+	resp, err := s.revertBatch(ctx, userID, batchID)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (s *SyncService) revertBatch(ctx context.Context, userID, batchID uuid.UUID) (*models.SyncResponse, error) {
+	batch, err := s.batchRepo.GetByID(ctx, batchID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if batch.RevertedAt != nil {
+		return nil, repository.ErrSyncBatchReverted
+	}
+
+	batchNotes, err := s.batchRepo.GetNotes(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &models.SyncResponse{
+		Notes:          []models.NoteDTO{},
+		DeletedNoteIDs: []string{},
+	}
+	var recorder *batchRecorder
+
+	// Revert in one transaction, so a failure part way leaves the batch and its notes as they were
+	err = s.inTx(ctx, func(ctx context.Context, txService *SyncService) error {
+		// Claim the batch first so concurrent reverts can't both apply it
+		if err := txService.batchRepo.MarkReverted(ctx, batchID, userID); err != nil {
+			return err
+		}
+
+		recorder = txService.newBatchRecorder(ctx, userID)
+		now := time.Now()
+		for _, batchNote := range batchNotes {
+			if err := recorder.capture(ctx, batchNote.NoteID); err != nil {
+				return err
+			}
+
+			if batchNote.PreImage == nil {
+				err := txService.noteRepo.SoftDelete(ctx, batchNote.NoteID, userID)
+				if err != nil && !errors.Is(err, repository.ErrNoteNotFound) {
+					return err
+				}
+				resp.DeletedNoteIDs = append(resp.DeletedNoteIDs, batchNote.NoteID.String())
+				continue
+			}
+
+			note := batchNote.PreImage
+			note.UserID = userID
+			note.UpdatedAt = now
+			txService.StampNote(note)
+			if err := txService.restoreNote(ctx, note); err != nil {
+				return err
+			}
+			resp.Notes = append(resp.Notes, txService.noteToDTO(note))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Audit(ctx, "User reverted sync batch", "user_id", userID.String(), "batch_id", batchID.String(), "notes", len(batchNotes))
+
+	resp.BatchID = recorder.id()
+	resp.ServerTimestamp = time.Now().UTC().Format(ISO8601Format)
+	return resp, nil
+}
+
+// restoreNote writes a note back, undeleting it or recreating it if it no longer exists
+func (s *SyncService) restoreNote(ctx context.Context, note *models.Note) error {
+	if err := s.noteRepo.Undelete(ctx, note.ID, note.UserID); err != nil {
+		return err
+	}
+	err := s.noteRepo.Update(ctx, note, repository.AnyVersion)
+	if errors.Is(err, repository.ErrNoteNotFound) {
+		return s.noteRepo.Create(ctx, note)
+	}
+	return err
+}
+
+// CleanupBatches removes sync batches too old to revert
+func (s *SyncService) CleanupBatches(ctx context.Context) (int64, error) {
+	return s.batchRepo.DeleteOlderThan(ctx, time.Now().Add(-models.SyncBatchRetention))
+}
+
+// SyncBatchToDTO converts a batch for API responses
+func SyncBatchToDTO(b *models.SyncBatch) models.SyncBatchDTO {
+	dto := models.SyncBatchDTO{
+		ID:        b.ID.String(),
+		RequestID: b.RequestID,
+		NoteCount: b.NoteCount,
+		CreatedAt: b.CreatedAt.UTC().Format(ISO8601Format),
+	}
+	if b.RevertedAt != nil {
+		revertedAt := b.RevertedAt.UTC().Format(ISO8601Format)
+		dto.RevertedAt = &revertedAt
+	}
+	return dto
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/textdelta"
+)
+
+// resolveContentDeltas replaces the content delta of each change with the full content it produces,
+// so the rest of sync (and the handler's broadcasts) only deal with full notes. The base is the note's
+// current content or an earlier revision with the delta's base hash. If the base isn't found or the
+// delta doesn't fit it, the change's full content is used if it has any; otherwise the change is
+// dropped from the request and its note ID returned for the client to resend in full.
+func (s *SyncService) resolveContentDeltas(ctx context.Context, userID uuid.UUID, req *models.SyncRequest) ([]string, []models.SyncFailureDTO, error) {
+	var resendIDs []string
+	var failures []models.SyncFailureDTO
+	changes := req.Changes[:0]
+
+	for i, dto := range req.Changes {
+		if dto.ContentDelta == nil {
+			changes = append(changes, dto)
+			continue
+		}
+
+		noteID, _ := uuid.Parse(dto.ID) // validated already
+		content, err := s.applyContentDelta(ctx, userID, noteID, dto.ContentDelta)
+		switch {
+		case err == nil:
+			if len(content) > models.MaxContentLength {
+				failures = append(failures, models.SyncFailureDTO{
+					Field:   SyncFieldChanges,
+					Index:   i,
+					NoteID:  dto.ID,
+					Message: fmt.Sprintf("content would exceed %d bytes", models.MaxContentLength),
+				})
+				continue
+			}
+			dto.Content = content
+		case errors.Is(err, textdelta.ErrMismatch) || errors.Is(err, repository.ErrRevisionNotFound):
+			if dto.Content == "" {
+				resendIDs = append(resendIDs, dto.ID)
+				continue
+			}
+		default:
+			return nil, nil, err
+		}
+
+		dto.ContentDelta = nil
+		changes = append(changes, dto)
+	}
+
+	req.Changes = changes
+	return resendIDs, failures, nil
+}
+
+// applyContentDelta applies a delta to the version of a note's content it was made against
+func (s *SyncService) applyContentDelta(ctx context.Context, userID, noteID uuid.UUID, delta *models.ContentDeltaDTO) (string, error) {
+	var base string
+	existing, err := s.noteRepo.GetByID(ctx, noteID, userID)
+	switch {
+	case err == nil && textdelta.Hash(existing.Content) == delta.BaseHash:
+		base = existing.Content
+	case err == nil || errors.Is(err, repository.ErrNoteNotFound):
+		if base, err = s.revisionRepo.GetContentByHash(ctx, noteID, userID, delta.BaseHash); err != nil {
+			return "", err
+		}
+	default:
+		return "", err
+	}
+
+	return textdelta.Apply(base, delta.Delta)
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"time"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+// NoteToLiteDTO converts a note for a lite (metered connection) response: content is cut to a preview
+// and only checklist items changed after since are included (none if since is nil)
+func (s *SyncService) NoteToLiteDTO(note *models.Note, since *time.Time) models.NoteDTO {
+	lite := *note
+	lite.ChecklistItems = nil
+	if since != nil {
+		for _, item := range note.ChecklistItems {
+			if item.UpdatedAt.After(*since) {
+				lite.ChecklistItems = append(lite.ChecklistItems, item)
+			}
+		}
+	}
+
+	dto := s.noteToDTO(&lite)
+	dto.Content = truncateRunes(dto.Content, models.LitePreviewLength)
+	dto.IsPartial = true
+	return dto
+}
+
+// truncateRunes cuts s to at most n characters without splitting a UTF-8 sequence
+func truncateRunes(s string, n int) string {
+	count := 0
+	for i := range s {
+		if count == n {
+			return s[:i]
+		}
+		count++
+	}
+	return s
+}
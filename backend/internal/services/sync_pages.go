@@ -0,0 +1,42 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidBatchToken is returned when a sync continuation token can't be decoded
+var ErrInvalidBatchToken = errors.New("invalid batch token")
+
+// DefaultSyncPageSize is the most notes returned by one sync response unless configured otherwise
+const DefaultSyncPageSize = 500
+
+// syncCursor is the state carried between pages of one sync in the batch token. Tokens are only
+// opaque, not secret: they can only select the requesting user's own notes.
+type syncCursor struct {
+	Since     *time.Time `json:"s,omitempty"` // the lastSync the paging started from
+	StartedAt time.Time  `json:"t"`           // returned as serverTimestamp once the last page is sent
+	UpdatedAt time.Time  `json:"u"`           // last note sent
+	ID        uuid.UUID  `json:"i"`
+}
+
+func encodeSyncCursor(cursor syncCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSyncCursor(token string) (*syncCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidBatchToken
+	}
+	var cursor syncCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.StartedAt.IsZero() {
+		return nil, ErrInvalidBatchToken
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+// Values for SyncPreflightDTO.Outcome
+const (
+	SyncOutcomeApply    = "apply"    // saved as sent
+	SyncOutcomeMerge    = "merge"    // merged with concurrent changes on the server
+	SyncOutcomeConflict = "conflict" // split off into a conflicted copy (see KeptVersion)
+	SyncOutcomeResend   = "resend"   // content delta doesn't apply; the client must send full content
+	SyncOutcomeReject   = "reject"   // invalid; the whole sync would be rejected
+)
+
+// Preflight reports what Sync would do with a request without changing anything. The valid items
+// are applied in a transaction that is rolled back, so merges and conflicts are found exactly as a
+// real sync would find them.
+func (s *SyncService) Preflight(ctx context.Context, userID uuid.UUID, req *models.SyncRequest) (*models.SyncPreflightResponse, error) {
+	var lastSync *time.Time
+	if req.LastSync != nil && *req.LastSync != "" {
+		if t, err := time.Parse(ISO8601Format, *req.LastSync); err == nil {
+			lastSync = &t
+		}
+	}
+
+	resp := &models.SyncPreflightResponse{
+		DryRun:     true,
+		Changes:    make([]models.SyncPreflightDTO, len(req.Changes)),
+		DeletedIDs: make([]models.SyncPreflightDTO, len(req.DeletedIDs)),
+		ContentOps: make([]models.SyncPreflightDTO, len(req.ContentOps)),
+	}
+	for i, dto := range req.Changes {
+		resp.Changes[i] = models.SyncPreflightDTO{Index: i, NoteID: dto.ID, Outcome: SyncOutcomeApply}
+	}
+	for i, id := range req.DeletedIDs {
+		resp.DeletedIDs[i] = models.SyncPreflightDTO{Index: i, NoteID: id, Outcome: SyncOutcomeApply}
+	}
+	for i, dto := range req.ContentOps {
+		resp.ContentOps[i] = models.SyncPreflightDTO{Index: i, NoteID: dto.NoteID, Outcome: SyncOutcomeApply}
+	}
+
+	failures := validateSyncRequest(req)
+	contentOps, contentOpNotes, opFailures := parseContentOps(req.ContentOps)
+	failures = append(failures, opFailures...)
+	rejectFailures(resp, failures)
+
+	// Dry-run only the items that passed validation, remembering where each change came from
+	valid := &models.SyncRequest{LastSync: req.LastSync}
+	var changeIndexes []int
+	for i, dto := range req.Changes {
+		if resp.Changes[i].Outcome == SyncOutcomeApply {
+			valid.Changes = append(valid.Changes, dto)
+			changeIndexes = append(changeIndexes, i)
+		}
+	}
+	for i, id := range req.DeletedIDs {
+		if resp.DeletedIDs[i].Outcome == SyncOutcomeApply {
+			valid.DeletedIDs = append(valid.DeletedIDs, id)
+		}
+	}
+
+	// Changes whose delta can't be used are dropped from valid.Changes
+	indexByID := make(map[string]int, len(valid.Changes))
+	for i, dto := range valid.Changes {
+		indexByID[dto.ID] = changeIndexes[i]
+	}
+	resendIDs, deltaFailures, err := s.resolveContentDeltas(ctx, userID, valid)
+	if err != nil {
+		return nil, err
+	}
+	for i := range deltaFailures {
+		deltaFailures[i].Index = changeIndexes[deltaFailures[i].Index]
+	}
+	rejectFailures(resp, deltaFailures)
+	failures = append(failures, deltaFailures...)
+	for _, id := range resendIDs {
+		resp.Changes[indexByID[id]].Outcome = SyncOutcomeResend
+	}
+
+	tx, err := s.noteRepo.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	applied, err := s.withTx(tx).applyChanges(ctx, userID, valid, lastSync, contentOps, contentOpNotes)
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes := make(map[string]models.SyncPreflightDTO)
+	for _, id := range applied.mergedIDs {
+		outcomes[id] = models.SyncPreflightDTO{Outcome: SyncOutcomeMerge}
+	}
+	for _, conflict := range applied.conflicts {
+		outcomes[conflict.NoteID] = models.SyncPreflightDTO{Outcome: SyncOutcomeConflict, KeptVersion: conflict.KeptVersion}
+	}
+	for _, dto := range valid.Changes {
+		if outcome, ok := outcomes[dto.ID]; ok {
+			item := &resp.Changes[indexByID[dto.ID]]
+			item.Outcome, item.KeptVersion = outcome.Outcome, outcome.KeptVersion
+		}
+	}
+
+	resp.WouldApply = len(failures) == 0
+	return resp, nil
+}
+
+// rejectFailures marks the items named by failures as rejected
+func rejectFailures(resp *models.SyncPreflightResponse, failures []models.SyncFailureDTO) {
+	items := map[string][]models.SyncPreflightDTO{
+		SyncFieldChanges:    resp.Changes,
+		SyncFieldDeletedIDs: resp.DeletedIDs,
+		SyncFieldContentOps: resp.ContentOps,
+	}
+	for _, failure := range failures {
+		if list := items[failure.Field]; failure.Index >= 0 && failure.Index < len(list) {
+			list[failure.Index].Outcome = SyncOutcomeReject
+			list[failure.Index].Message = failure.Message
+		}
+	}
+}
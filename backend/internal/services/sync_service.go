@@ -2,24 +2,79 @@ package services
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/crdt"
+	"github.com/hamishgilbert/notes-app/backend/internal/database"
+	"github.com/hamishgilbert/notes-app/backend/internal/hlc"
 	"github.com/hamishgilbert/notes-app/backend/internal/models"
 	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/textdelta"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const ISO8601Format = "2006-01-02T15:04:05.000Z"
 
+// ConflictedCopyOfKey is the metadata key linking a conflicted copy to the note it was split from
+const ConflictedCopyOfKey = "conflictedCopyOf"
+
+// Values for ConflictDTO.KeptVersion
+const (
+	ConflictKeptClient = "client"
+	ConflictKeptServer = "server"
+)
+
 type SyncService struct {
-	noteRepo *repository.NoteRepository
+	noteRepo     *repository.NoteRepository
+	revisionRepo *repository.RevisionRepository
+	opRepo       *repository.NoteOpRepository
+	batchRepo    *repository.SyncBatchRepository
+	positionRepo *repository.PositionRepository
+	pageSize     int        // most notes per sync response
+	clock        *hlc.Clock // stamps edits the server makes, such as merges
+	quotas       Quotas
 }
 
-func NewSyncService(noteRepo *repository.NoteRepository) *SyncService {
-	return &SyncService{noteRepo: noteRepo}
+func NewSyncService(noteRepo *repository.NoteRepository, revisionRepo *repository.RevisionRepository, opRepo *repository.NoteOpRepository, batchRepo *repository.SyncBatchRepository, positionRepo *repository.PositionRepository, pageSize int) *SyncService {
+	if pageSize <= 0 {
+		pageSize = DefaultSyncPageSize
+	}
+	return &SyncService{
+		noteRepo:     noteRepo,
+		revisionRepo: revisionRepo,
+		opRepo:       opRepo,
+		batchRepo:    batchRepo,
+		positionRepo: positionRepo,
+		pageSize:     pageSize,
+		clock:        hlc.NewClock("server"),
+	}
 }
 
 func (s *SyncService) Sync(ctx context.Context, userID uuid.UUID, req *models.SyncRequest) (*models.SyncResponse, error) {
+	ctx, span := startSpan(ctx, "SyncService.Sync",
+		attribute.Int("sync.changes", len(req.Changes)),
+		attribute.Int("sync.deletions", len(req.DeletedIDs)),
+		attribute.Int("sync.content_ops", len(req.ContentOps)),
+		attribute.Bool("sync.lite", req.Lite),
+		attribute.Bool("sync.next_page", req.BatchToken != ""),
+	)
+	resp, err := s.sync(ctx, userID, req)
+	if err == nil {
+		span.SetAttributes(
+			attribute.Int("sync.notes_returned", len(resp.Notes)),
+			attribute.Int("sync.conflicts", len(resp.Conflicts)),
+			attribute.Bool("sync.has_more", resp.HasMore),
+		)
+	}
+	endSpan(span, err)
+	return resp, err
+}
+
+func (s *SyncService) sync(ctx context.Context, userID uuid.UUID, req *models.SyncRequest) (*models.SyncResponse, error) {
 	// Parse lastSync time
 	var lastSync *time.Time
 	if req.LastSync != nil && *req.LastSync != "" {
@@ -29,68 +84,503 @@ func (s *SyncService) Sync(ctx context.Context, userID uuid.UUID, req *models.Sy
 		}
 	}
 
-	// Process incoming changes (upsert)
+	// Validate everything up front and reject the request with a report of each invalid item
+	failures := validateSyncRequest(req)
+	contentOps, contentOpNotes, opFailures := parseContentOps(req.ContentOps)
+	failures = append(failures, opFailures...)
+	if len(failures) > 0 {
+		return nil, &SyncValidationError{Failures: failures}
+	}
+
+	// Turn content deltas into full content; changes whose delta doesn't apply are left out
+	resendIDs, deltaFailures, err := s.resolveContentDeltas(ctx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(deltaFailures) > 0 {
+		return nil, &SyncValidationError{Failures: deltaFailures}
+	}
+
+	var cursor *syncCursor
+	if req.BatchToken != "" {
+		var err error
+		if cursor, err = decodeSyncCursor(req.BatchToken); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.checkQuotas(req, userID); err != nil {
+		return nil, err
+	}
+
+	// Apply the whole batch in one transaction, so a failure part way leaves nothing applied
+	var applied *appliedChanges
+	err = s.inTx(ctx, func(ctx context.Context, txService *SyncService) error {
+		var usageBefore repository.NoteUsage
+		var err error
+		if s.quotas.limited() {
+			if usageBefore, _, err = txService.noteRepo.Usage(ctx, userID, uuid.Nil); err != nil {
+				return err
+			}
+		}
+
+		if applied, err = txService.applyChanges(ctx, userID, req, lastSync, contentOps, contentOpNotes); err != nil {
+			return err
+		}
+
+		// A batch that grows the user's notes past a quota is refused as a whole
+		if s.quotas.limited() {
+			usageAfter, _, err := txService.noteRepo.Usage(ctx, userID, uuid.Nil)
+			if err != nil {
+				return err
+			}
+			return s.quotas.checkGrowth(usageBefore, usageAfter)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	conflicts, mergedIDs, batchID := applied.conflicts, applied.mergedIDs, applied.batchID
+	saved := make([]models.NoteDTO, len(applied.saved))
+	for i, note := range applied.saved {
+		saved[i] = s.noteToDTO(note)
+	}
+
+	// The response carries what was just written, which a read replica may not have yet
+	if len(req.Changes) > 0 || len(contentOps) > 0 {
+		ctx = repository.ReadPrimary(ctx)
+	}
+	ctx, staleness := s.noteRepo.CursorReads(ctx)
+
+	// Fetch notes updated since lastSync, one page at a time for large accounts
+	if cursor == nil {
+		cursor = &syncCursor{Since: lastSync, StartedAt: time.Now().Add(-staleness)}
+	}
+	notes, err := s.noteRepo.GetPage(ctx, userID, cursor.Since, cursor.UpdatedAt, cursor.ID, s.pageSize+1)
+	if err != nil {
+		return nil, err
+	}
+
+	var batchToken string
+	if len(notes) > s.pageSize {
+		notes = notes[:s.pageSize]
+		last := notes[len(notes)-1]
+		cursor.UpdatedAt, cursor.ID = last.UpdatedAt, last.ID
+		batchToken = encodeSyncCursor(*cursor)
+	}
+
+	// Fetch deleted note IDs since lastSync; they're sent with the first page
+	deletedIDStrings := []string{}
+	if req.BatchToken == "" {
+		deletedIDs, err := s.noteRepo.GetDeletedSince(ctx, userID, cursor.Since)
+		if err != nil {
+			return nil, err
+		}
+		deletedIDStrings = make([]string, len(deletedIDs))
+		for i, id := range deletedIDs {
+			deletedIDStrings[i] = id.String()
+		}
+	}
+
+	// Reading positions since lastSync also come with the first page
+	var positions []models.NotePositionDTO
+	if req.BatchToken == "" {
+		changed, err := s.positionRepo.ListSince(ctx, userID, cursor.Since)
+		if err != nil {
+			return nil, err
+		}
+		for _, position := range changed {
+			positions = append(positions, positionToDTO(&position))
+		}
+	}
+
+	// Convert to DTOs. Lite responses still carry merged notes and conflicted copies in full, since
+	// they're the result of the client's own changes and are broadcast to its other devices as is.
+	fullIDs := make(map[string]bool)
+	for _, id := range mergedIDs {
+		fullIDs[id] = true
+	}
+	for _, conflict := range conflicts {
+		fullIDs[conflict.ConflictedCopyID] = true
+	}
+	noteDTOs := make([]models.NoteDTO, len(notes))
+	for i, note := range notes {
+		if req.Lite && !fullIDs[note.ID.String()] {
+			noteDTOs[i] = s.NoteToLiteDTO(&note, cursor.Since)
+		} else {
+			noteDTOs[i] = s.noteToDTO(&note)
+		}
+	}
+
+	// The final page's timestamp is when paging started, so changes made while the client paged are
+	// picked up next time. Earlier pages report their last note's time instead, so clients that don't
+	// know about paging still catch up over successive syncs.
+	serverTimestamp := cursor.StartedAt
+	if batchToken != "" {
+		serverTimestamp = cursor.UpdatedAt
+	}
+
+	resp := &models.SyncResponse{
+		Notes:           noteDTOs,
+		DeletedNoteIDs:  deletedIDStrings,
+		Conflicts:       conflicts,
+		MergedNoteIDs:   mergedIDs,
+		ResendNoteIDs:   resendIDs,
+		BatchID:         batchID,
+		BatchToken:      batchToken,
+		HasMore:         batchToken != "",
+		ServerTimestamp: serverTimestamp.UTC().Format(ISO8601Format),
+		HLC:             s.clock.Now().String(),
+		Positions:       positions,
+		Saved:           saved,
+	}
+
+	// Clients in CRDT mode also receive the ops they haven't seen, with the first page
+	if req.LastOpSeq != nil && req.BatchToken == "" {
+		ops, lastOpSeq, err := s.contentOpsSince(ctx, userID, *req.LastOpSeq)
+		if err != nil {
+			return nil, err
+		}
+		resp.ContentOps = ops
+		resp.LastOpSeq = &lastOpSeq
+	}
+
+	return resp, nil
+}
+
+// appliedChanges is what applyChanges reports back for the response
+type appliedChanges struct {
+	conflicts []models.ConflictDTO
+	mergedIDs []string
+	batchID   string
+	saved     []*models.Note // changes as saved, the last one of each note
+}
+
+// applyChanges applies a validated sync request's changes, CRDT ops and deletions. Sync runs it on a
+// transaction-bound copy of the service (see withTx).
+func (s *SyncService) applyChanges(ctx context.Context, userID uuid.UUID, req *models.SyncRequest, lastSync *time.Time, contentOps map[uuid.UUID][]crdt.Op, contentOpNotes []uuid.UUID) (*appliedChanges, error) {
+	// Record each note's state before it changes so the whole sync can be reverted
+	recorder := s.newBatchRecorder(ctx, userID)
+	applied := &appliedChanges{}
+
+	// Process incoming changes (upsert), merging or splitting off conflicted copies when both sides changed.
+	// Plain upserts are written together in one batch; a note changed twice in the request flushes the
+	// batch first, so the second change sees the first.
+	var upserts []*models.Note
+	pending := make(map[uuid.UUID]bool)
+	savedAt := make(map[uuid.UUID]int)
+	flush := func() error {
+		written, err := s.noteRepo.UpsertBatch(ctx, userID, upserts)
+		for _, note := range written {
+			if i, ok := savedAt[note.ID]; ok {
+				applied.saved[i] = note
+			} else {
+				savedAt[note.ID] = len(applied.saved)
+				applied.saved = append(applied.saved, note)
+			}
+		}
+		upserts = upserts[:0]
+		clear(pending)
+		return err
+	}
 	for _, dto := range req.Changes {
 		note, err := s.dtoToNote(dto, userID)
 		if err != nil {
-			continue // Skip invalid notes
+			return nil, err
+		}
+
+		if pending[note.ID] {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := recorder.capture(ctx, note.ID); err != nil {
+			return nil, err
 		}
-		if err := s.noteRepo.Upsert(ctx, note); err != nil {
+
+		if err := s.keepCRDTContent(ctx, note); err != nil {
+			return nil, err
+		}
+
+		if lastSync != nil {
+			conflict, merged, err := s.resolveConflict(ctx, note, *lastSync)
+			if err != nil {
+				return nil, err
+			}
+			if merged {
+				applied.mergedIDs = append(applied.mergedIDs, note.ID.String())
+			}
+			if conflict != nil {
+				copyID, _ := uuid.Parse(conflict.ConflictedCopyID)
+				if err := recorder.captureCreated(ctx, copyID); err != nil {
+					return nil, err
+				}
+				applied.conflicts = append(applied.conflicts, *conflict)
+			}
+			if merged || conflict != nil {
+				continue
+			}
+		}
+
+		upserts = append(upserts, note)
+		pending[note.ID] = true
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	// Apply CRDT ops after changes, so ops can target notes created in the same request
+	for _, noteID := range contentOpNotes {
+		if err := recorder.capture(ctx, noteID); err != nil {
+			return nil, err
+		}
+		if err := s.applyContentOps(ctx, userID, noteID, contentOps[noteID]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Save reading positions; positions in notes the user can't open are dropped
+	for _, dto := range req.Positions {
+		err := s.positionRepo.Set(ctx, dtoToPosition(dto, userID))
+		if err != nil && !errors.Is(err, repository.ErrNoteNotFound) {
 			return nil, err
 		}
 	}
 
-	// Process deletions
+	// Process deletions; deleting a note that doesn't exist isn't an error
 	for _, idStr := range req.DeletedIDs {
 		id, err := uuid.Parse(idStr)
 		if err != nil {
-			continue
+			return nil, err
+		}
+		if err := recorder.capture(ctx, id); err != nil {
+			return nil, err
+		}
+		if err := s.noteRepo.SoftDelete(ctx, id, userID); err != nil && !errors.Is(err, repository.ErrNoteNotFound) {
+			return nil, err
+		}
+	}
+
+	applied.batchID = recorder.id()
+	return applied, nil
+}
+
+// withTx returns a copy of the service whose repositories run in tx
+func (s *SyncService) withTx(tx pgx.Tx) *SyncService {
+	return &SyncService{
+		noteRepo:     s.noteRepo.WithTx(tx),
+		revisionRepo: s.revisionRepo.WithTx(tx),
+		opRepo:       s.opRepo.WithTx(tx),
+		batchRepo:    s.batchRepo.WithTx(tx),
+		positionRepo: s.positionRepo.WithTx(tx),
+		pageSize:     s.pageSize,
+		clock:        s.clock,
+		quotas:       s.quotas,
+	}
+}
+
+// inTx runs fn with a copy of the service whose repositories all work in one transaction, which is
+// committed if fn returns nil. fn is given a context recording its work under the transaction's span.
+func (s *SyncService) inTx(ctx context.Context, fn func(ctx context.Context, txService *SyncService) error) error {
+	ctx, span := startSpan(ctx, "SyncService transaction")
+	err := database.WithTx(ctx, s.noteRepo, func(tx pgx.Tx) error {
+		return fn(ctx, s.withTx(tx))
+	})
+	endSpan(span, err)
+	return err
+}
+
+// SetQuotas makes sync refuse changes that would take a user past a storage quota
+func (s *SyncService) SetQuotas(quotas Quotas) {
+	s.quotas = quotas
+}
+
+// checkQuotas checks each changed note is within the per-note quotas. It runs after the request
+// is validated, so every change converts; invalid ones have already failed the sync.
+func (s *SyncService) checkQuotas(req *models.SyncRequest, userID uuid.UUID) error {
+	for _, dto := range req.Changes {
+		note, err := s.dtoToNote(dto, userID)
+		if err != nil {
+			return err
+		}
+		if err := s.quotas.checkNote(note); err != nil {
+			return err
 		}
-		// Soft delete - ignore errors for non-existent notes
-		_ = s.noteRepo.SoftDelete(ctx, id, userID)
 	}
+	return nil
+}
 
-	// Fetch notes updated since lastSync
-	notes, err := s.noteRepo.GetAllByUserID(ctx, userID, lastSync)
+// resolveConflict checks whether an incoming note and the server's copy were both edited since the
+// client's last sync. If they were, and their contents differ, the edits are merged field by field against
+// the version the client last synced. If they can't be merged, the newer edit is saved to the note and the
+// older one is saved as a conflicted copy; checklist items the older edit added are still added to the note,
+// in which case merged is also true. Returns a nil conflict and false if there was no conflict and the note
+// still needs upserting.
+func (s *SyncService) resolveConflict(ctx context.Context, incoming *models.Note, lastSync time.Time) (*models.ConflictDTO, bool, error) {
+	existing, err := s.noteRepo.GetByID(ctx, incoming.ID, incoming.UserID)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, repository.ErrNoteNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	// Fields the client left out are as the server has them, not edits
+	incoming.KeepOmitted(existing)
+
+	if !existing.UpdatedAt.After(lastSync) || !incoming.UpdatedAt.After(lastSync) || !notesDiffer(existing, incoming) {
+		return nil, false, nil
 	}
 
-	// Fetch deleted note IDs since lastSync
-	deletedIDs, err := s.noteRepo.GetDeletedSince(ctx, userID, lastSync)
+	merged, err := s.mergeConflict(ctx, existing, incoming, lastSync)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	if merged {
+		return nil, true, nil
 	}
 
-	// Convert to DTOs
-	noteDTOs := make([]models.NoteDTO, len(notes))
-	for i, note := range notes {
-		noteDTOs[i] = s.noteToDTO(&note)
+	kept, lost := existing, incoming
+	keptVersion := ConflictKeptServer
+	if incoming.Clock().After(existing.Clock()) {
+		kept, lost = incoming, existing
+		keptVersion = ConflictKeptClient
 	}
 
-	deletedIDStrings := make([]string, len(deletedIDs))
-	for i, id := range deletedIDs {
-		deletedIDStrings[i] = id.String()
+	// Adding checklist items commutes with any other edit, so the older side's additions aren't lost
+	// to the conflicted copy
+	added := appendChecklistAdditions(kept, lost, lastSync)
+	if added {
+		kept.UpdatedAt = time.Now()
+		s.StampNote(kept)
+	}
+	if keptVersion == ConflictKeptClient || added {
+		if err := s.noteRepo.Update(ctx, kept, existing.Version); err != nil {
+			return nil, false, err
+		}
 	}
 
-	return &models.SyncResponse{
-		Notes:           noteDTOs,
-		DeletedNoteIDs:  deletedIDStrings,
-		ServerTimestamp: time.Now().UTC().Format(ISO8601Format),
-	}, nil
+	conflictedCopy := newConflictedCopy(lost, kept.ID, time.Now())
+	s.StampNote(conflictedCopy)
+	if err := s.noteRepo.Create(ctx, conflictedCopy); err != nil {
+		return nil, false, err
+	}
+
+	return &models.ConflictDTO{
+		NoteID:           kept.ID.String(),
+		ConflictedCopyID: conflictedCopy.ID.String(),
+		KeptVersion:      keptVersion,
+	}, added, nil
+}
+
+// mergeConflict three-way merges the server's and client's edits using the revision the client last
+// synced as the common ancestor, saving the result if no field was changed differently on both sides.
+// Reports false if there is no revision to merge against or the edits overlap.
+func (s *SyncService) mergeConflict(ctx context.Context, existing, incoming *models.Note, lastSync time.Time) (bool, error) {
+	base, err := s.revisionRepo.GetAsOf(ctx, existing.ID, existing.UserID, lastSync)
+	if err != nil {
+		if errors.Is(err, repository.ErrRevisionNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	merged, ok := mergeNotes(&base.Note, existing, incoming)
+	if !ok {
+		return false, nil
+	}
+
+	// The merge is newer than both edits, so every client picks it up on its next sync
+	merged.UpdatedAt = time.Now()
+	s.StampNote(merged)
+	if err := s.noteRepo.Update(ctx, merged, existing.Version); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// notesDiffer reports whether two versions of a note have different user-visible contents
+func notesDiffer(a, b *models.Note) bool {
+	if a.Title != b.Title || a.Content != b.Content || a.NoteType != b.NoteType || a.Language != b.Language {
+		return true
+	}
+	if len(a.ChecklistItems) != len(b.ChecklistItems) {
+		return true
+	}
+	for i := range a.ChecklistItems {
+		if a.ChecklistItems[i].Text != b.ChecklistItems[i].Text || a.ChecklistItems[i].IsCompleted != b.ChecklistItems[i].IsCompleted {
+			return true
+		}
+	}
+	return false
+}
+
+// newConflictedCopy copies a note version under a new ID, titled so users can spot and merge it
+func newConflictedCopy(note *models.Note, originalID uuid.UUID, now time.Time) *models.Note {
+	suffix := " (conflicted copy " + now.UTC().Format("2006-01-02 15:04") + ")"
+	title := note.Title
+	if maxLen := models.MaxTitleLength - len(suffix); len(title) > maxLen {
+		title = strings.ToValidUTF8(title[:maxLen], "")
+	}
+
+	metadata := make(map[string]string, len(note.Metadata)+1)
+	for k, v := range note.Metadata {
+		metadata[k] = v
+	}
+	if len(metadata) < models.MaxMetadataEntries {
+		metadata[ConflictedCopyOfKey] = originalID.String()
+	}
+
+	copied := &models.Note{
+		ID:          uuid.New(),
+		UserID:      note.UserID,
+		Title:       title + suffix,
+		Content:     note.Content,
+		NoteType:    note.NoteType,
+		Language:    note.Language,
+		IsMonospace: note.IsMonospace,
+		IsPinned:    note.IsPinned,
+		IsArchived:  note.IsArchived,
+		SortOrder:   note.SortOrder,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Metadata:    metadata,
+	}
+
+	for _, item := range note.ChecklistItems {
+		copied.ChecklistItems = append(copied.ChecklistItems, models.ChecklistItem{
+			ID:          uuid.New(),
+			NoteID:      copied.ID,
+			Text:        item.Text,
+			IsCompleted: item.IsCompleted,
+			SortOrder:   item.SortOrder,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+	}
+
+	return copied
 }
 
 func (s *SyncService) noteToDTO(note *models.Note) models.NoteDTO {
 	dto := models.NoteDTO{
-		ID:         note.ID.String(),
-		Title:      note.Title,
-		Content:    note.Content,
-		NoteType:   string(note.NoteType),
-		IsPinned:   note.IsPinned,
-		IsArchived: note.IsArchived,
-		SortOrder:  note.SortOrder,
-		CreatedAt:  note.CreatedAt.UTC().Format(ISO8601Format),
-		UpdatedAt:  note.UpdatedAt.UTC().Format(ISO8601Format),
+		ID:          note.ID.String(),
+		Title:       note.Title,
+		Content:     note.Content,
+		ContentHash: textdelta.Hash(note.Content),
+		NoteType:    string(note.NoteType),
+		Language:    &note.Language,
+		IsMonospace: &note.IsMonospace,
+		IsPinned:    note.IsPinned,
+		IsArchived:  note.IsArchived,
+		SortOrder:   note.SortOrder,
+		CreatedAt:   note.CreatedAt.UTC().Format(ISO8601Format),
+		UpdatedAt:   note.UpdatedAt.UTC().Format(ISO8601Format),
+		Metadata:    note.Metadata,
+		HLC:         note.HLC,
+		Version:     note.Version,
 	}
 
 	if len(note.ChecklistItems) > 0 {
@@ -107,9 +597,41 @@ func (s *SyncService) noteToDTO(note *models.Note) models.NoteDTO {
 		}
 	}
 
+	if note.ChecklistSummary != nil {
+		dto.ChecklistSummary = &models.ChecklistSummaryDTO{
+			Total:     note.ChecklistSummary.Total,
+			Completed: note.ChecklistSummary.Completed,
+		}
+	}
+
+	if len(note.LinkPreviews) > 0 {
+		dto.LinkPreviews = linkPreviewsToDTO(note.LinkPreviews)
+	}
+
+	if len(note.Attachments) > 0 {
+		dto.Attachments = make([]models.AttachmentDTO, len(note.Attachments))
+		for i, attachment := range note.Attachments {
+			dto.Attachments[i] = AttachmentToDTO(&attachment)
+		}
+	}
+
 	return dto
 }
 
+func linkPreviewsToDTO(previews []models.LinkPreview) []models.LinkPreviewDTO {
+	dtos := make([]models.LinkPreviewDTO, len(previews))
+	for i, preview := range previews {
+		dtos[i] = models.LinkPreviewDTO{
+			URL:         preview.URL,
+			Title:       preview.Title,
+			Description: preview.Description,
+			ImageURL:    preview.ImageURL,
+			FetchedAt:   preview.FetchedAt.UTC().Format(ISO8601Format),
+		}
+	}
+	return dtos
+}
+
 func (s *SyncService) dtoToNote(dto models.NoteDTO, userID uuid.UUID) (*models.Note, error) {
 	id, err := uuid.Parse(dto.ID)
 	if err != nil {
@@ -137,6 +659,25 @@ func (s *SyncService) dtoToNote(dto models.NoteDTO, userID uuid.UUID) (*models.N
 		SortOrder:  dto.SortOrder,
 		CreatedAt:  createdAt,
 		UpdatedAt:  updatedAt,
+		Metadata:   dto.Metadata,
+		HLC:        dto.HLC,
+		Version:    dto.Version,
+		Omitted: models.OmittedFields{
+			Language:    dto.Language == nil,
+			IsMonospace: dto.IsMonospace == nil,
+			Metadata:    dto.Metadata == nil,
+		},
+	}
+	if dto.Language != nil {
+		note.Language = *dto.Language
+	}
+	if dto.IsMonospace != nil {
+		note.IsMonospace = *dto.IsMonospace
+	}
+
+	// Keep the server's clock ahead of every edit it has seen, so its own edits order after them
+	if ts, err := hlc.Parse(dto.HLC); err == nil {
+		s.clock.Observe(ts)
 	}
 
 	// Convert checklist items
@@ -182,3 +723,9 @@ func (s *SyncService) NoteToDTO(note *models.Note) models.NoteDTO {
 func (s *SyncService) DTOToNote(dto models.NoteDTO, userID uuid.UUID) (*models.Note, error) {
 	return s.dtoToNote(dto, userID)
 }
+
+// StampNote gives a note the server's next clock timestamp, for edits made by the server or through
+// the REST endpoints, which replace the note outright
+func (s *SyncService) StampNote(note *models.Note) {
+	note.HLC = s.clock.Now().String()
+}
@@ -0,0 +1,212 @@
+package services_test
+
+// Sync latency and allocations against a database seeded with accounts of 100, 1k and 10k notes:
+//
+//	DATABASE_URL=postgres://... go test -run '^$' -bench Sync -benchmem ./internal/services/
+//
+// Each size gets its own "syncbench<size>" account whose notes are reset and re-seeded from a fixed
+// seed with fixed IDs on every run, so results are comparable between runs and branches. -short
+// leaves out the 10k-note account. Do not point this at a production database.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/config"
+	"github.com/hamishgilbert/notes-app/backend/internal/database"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/passwordhash"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/services"
+)
+
+// Shape of the seeded accounts
+const (
+	benchSeed          = 1
+	checklistRatio     = 4 // every 4th note is a checklist
+	itemsPerChecklist  = 5
+	incrementalChanges = 10
+)
+
+// benchNamespace derives the benchmark accounts' IDs, so every run seeds the same rows
+var benchNamespace = uuid.MustParse("5b0f6a8e-3c1d-4e2a-9f7b-6d8c0e1a2b3c")
+
+type syncBench struct {
+	noteRepo    *repository.NoteRepository
+	userRepo    *repository.UserRepository
+	syncService *services.SyncService
+}
+
+// newSyncBench connects to DATABASE_URL and migrates it, skipping the benchmark without one
+func newSyncBench(b *testing.B) *syncBench {
+	b.Helper()
+	if os.Getenv("DATABASE_URL") == "" {
+		b.Skip("DATABASE_URL is not set")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		b.Fatalf("failed to load configuration: %v", err)
+	}
+	if cfg.IsProduction() {
+		b.Fatal("refusing to run sync benchmarks in production")
+	}
+
+	db, err := database.New(cfg.DatabaseURL, database.Options{RowLevelSecurity: cfg.RowLevelSecurity})
+	if err != nil {
+		b.Fatalf("failed to connect to database: %v", err)
+	}
+	b.Cleanup(db.Close)
+	if err := db.RunMigrations(context.Background()); err != nil {
+		b.Fatalf("failed to run migrations: %v", err)
+	}
+
+	noteRepo := repository.NewNoteRepository(db.Pool)
+	return &syncBench{
+		noteRepo:    noteRepo,
+		userRepo:    repository.NewUserRepository(db.Pool),
+		syncService: services.NewSyncService(noteRepo, repository.NewRevisionRepository(db.Pool), repository.NewNoteOpRepository(db.Pool), repository.NewSyncBatchRepository(db.Pool), repository.NewPositionRepository(db.Pool), services.DefaultSyncPageSize),
+	}
+}
+
+func benchSizes() []int {
+	if testing.Short() {
+		return []int{100, 1000}
+	}
+	return []int{100, 1000, 10000}
+}
+
+// BenchmarkSyncFull measures a first sync (no lastSync) that returns every note
+func BenchmarkSyncFull(b *testing.B) {
+	s := newSyncBench(b)
+	ctx := context.Background()
+
+	for _, size := range benchSizes() {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			userID := s.seedAccount(b, size)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.syncService.Sync(ctx, userID, &models.SyncRequest{}); err != nil {
+					b.Fatalf("sync failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSyncIncremental measures a sync that pushes a handful of changes and pulls everything
+// updated since the previous sync
+func BenchmarkSyncIncremental(b *testing.B) {
+	s := newSyncBench(b)
+	ctx := context.Background()
+
+	for _, size := range benchSizes() {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			userID := s.seedAccount(b, size)
+			notes, err := s.noteRepo.GetAllByUserID(ctx, userID, nil)
+			if err != nil {
+				b.Fatalf("failed to load notes: %v", err)
+			}
+			if len(notes) > incrementalChanges {
+				notes = notes[:incrementalChanges]
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			lastSync := time.Now().UTC().Format(services.ISO8601Format)
+			for i := 0; i < b.N; i++ {
+				req := &models.SyncRequest{LastSync: &lastSync}
+				for _, note := range notes {
+					dto := s.syncService.NoteToDTO(&note)
+					dto.Content = fmt.Sprintf("%s (edit %d)", note.Content, i)
+					dto.UpdatedAt = time.Now().UTC().Format(services.ISO8601Format)
+					req.Changes = append(req.Changes, dto)
+				}
+
+				resp, err := s.syncService.Sync(ctx, userID, req)
+				if err != nil {
+					b.Fatalf("sync failed: %v", err)
+				}
+				lastSync = resp.ServerTimestamp
+			}
+		})
+	}
+}
+
+// seedAccount creates (or resets) the benchmark account for the given size and fills it with the
+// same mix of text notes and checklists every time
+func (s *syncBench) seedAccount(b *testing.B, size int) uuid.UUID {
+	b.Helper()
+	ctx := context.Background()
+	username := "syncbench" + strconv.Itoa(size)
+
+	user, err := s.userRepo.GetByUsername(ctx, username)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		now := time.Now()
+		user = &models.User{
+			ID:                uuid.NewSHA1(benchNamespace, []byte(username)),
+			Username:          username,
+			PasswordHash:      "!", // Not a valid bcrypt hash, so the account can't be logged into
+			PasswordAlgorithm: passwordhash.Bcrypt,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		}
+		err = s.userRepo.Create(ctx, user)
+	}
+	if err != nil {
+		b.Fatalf("failed to create %s: %v", username, err)
+	}
+
+	if err := s.noteRepo.HardDeleteAllByUserID(ctx, user.ID); err != nil {
+		b.Fatalf("failed to reset %s: %v", username, err)
+	}
+
+	rng := rand.New(rand.NewSource(benchSeed + int64(size)))
+	start := time.Now().Add(-time.Duration(size) * time.Minute)
+
+	for i := 0; i < size; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		noteKey := username + "/" + strconv.Itoa(i)
+		note := &models.Note{
+			ID:        uuid.NewSHA1(benchNamespace, []byte(noteKey)),
+			UserID:    user.ID,
+			Title:     fmt.Sprintf("Benchmark note %d", i),
+			NoteType:  models.NoteTypeNote,
+			IsPinned:  rng.Intn(20) == 0,
+			SortOrder: i,
+			CreatedAt: ts,
+			UpdatedAt: ts,
+		}
+
+		if i%checklistRatio == 0 {
+			note.NoteType = models.NoteTypeChecklist
+			for j := 0; j < itemsPerChecklist; j++ {
+				note.ChecklistItems = append(note.ChecklistItems, models.ChecklistItem{
+					ID:          uuid.NewSHA1(benchNamespace, []byte(noteKey+"/"+strconv.Itoa(j))),
+					Text:        fmt.Sprintf("Item %d", j),
+					IsCompleted: rng.Intn(2) == 0,
+					SortOrder:   j,
+					CreatedAt:   ts,
+					UpdatedAt:   ts,
+				})
+			}
+		} else {
+			note.Content = strings.Repeat("Lorem ipsum dolor sit amet. ", 1+rng.Intn(20))
+		}
+
+		if err := s.noteRepo.Create(ctx, note); err != nil {
+			b.Fatalf("failed to seed %s: %v", username, err)
+		}
+	}
+	return user.ID
+}
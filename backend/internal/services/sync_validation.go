@@ -0,0 +1,58 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+// Values for SyncFailureDTO.Field
+const (
+	SyncFieldChanges    = "changes"
+	SyncFieldDeletedIDs = "deletedIDs"
+	SyncFieldContentOps = "contentOps"
+	SyncFieldPositions  = "positions"
+)
+
+// SyncValidationError rejects a sync request without applying any of it, listing every invalid item
+type SyncValidationError struct {
+	Failures []models.SyncFailureDTO
+}
+
+func (e *SyncValidationError) Error() string {
+	return fmt.Sprintf("sync request has %d invalid items", len(e.Failures))
+}
+
+// validateSyncRequest checks the changes, deletions and reading positions of a sync request
+func validateSyncRequest(req *models.SyncRequest) []models.SyncFailureDTO {
+	var failures []models.SyncFailureDTO
+
+	for i, dto := range req.Changes {
+		if _, err := uuid.Parse(dto.ID); err != nil {
+			failures = append(failures, models.SyncFailureDTO{Field: SyncFieldChanges, Index: i, NoteID: dto.ID, Message: "invalid note ID"})
+			continue
+		}
+		if err := models.ValidateNoteDTO(&dto); err != nil {
+			failures = append(failures, models.SyncFailureDTO{Field: SyncFieldChanges, Index: i, NoteID: dto.ID, Message: err.Error()})
+		}
+	}
+
+	for i, idStr := range req.DeletedIDs {
+		if _, err := uuid.Parse(idStr); err != nil {
+			failures = append(failures, models.SyncFailureDTO{Field: SyncFieldDeletedIDs, Index: i, NoteID: idStr, Message: "invalid note ID"})
+		}
+	}
+
+	for i, dto := range req.Positions {
+		if _, err := uuid.Parse(dto.NoteID); err != nil {
+			failures = append(failures, models.SyncFailureDTO{Field: SyncFieldPositions, Index: i, NoteID: dto.NoteID, Message: "invalid note ID"})
+			continue
+		}
+		if err := models.ValidateNotePositionDTO(&dto); err != nil {
+			failures = append(failures, models.SyncFailureDTO{Field: SyncFieldPositions, Index: i, NoteID: dto.NoteID, Message: err.Error()})
+		}
+	}
+
+	return failures
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+)
+
+const (
+	// TelemetryInterval is how often an instance with telemetry enabled reports
+	TelemetryInterval = 24 * time.Hour
+
+	telemetrySendTimeout = 10 * time.Second
+)
+
+// TelemetryService reports anonymous facts about a self-hosted instance (version, platform,
+// database and a user count range) when the operator opts in with TELEMETRY_ENABLED
+type TelemetryService struct {
+	instanceRepo *repository.InstanceRepository
+	enabled      bool
+	endpoint     string
+	version      string
+	client       *http.Client
+}
+
+// NewTelemetryService creates the service. Nothing is sent unless enabled is true and endpoint is set,
+// but the report can always be previewed.
+func NewTelemetryService(instanceRepo *repository.InstanceRepository, enabled bool, endpoint, version string) *TelemetryService {
+	return &TelemetryService{
+		instanceRepo: instanceRepo,
+		enabled:      enabled && endpoint != "",
+		endpoint:     endpoint,
+		version:      version,
+		client:       &http.Client{Timeout: telemetrySendTimeout},
+	}
+}
+
+// Enabled reports whether reports will be sent
+func (s *TelemetryService) Enabled() bool {
+	return s.enabled
+}
+
+// Report builds the report that would be sent now
+func (s *TelemetryService) Report(ctx context.Context) (*models.TelemetryReport, error) {
+	id, err := s.instanceRepo.ID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, dbVersion, err := s.instanceRepo.Usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TelemetryReport{
+		InstanceID:      id.String(),
+		Version:         s.version,
+		GoVersion:       runtime.Version(),
+		OS:              runtime.GOOS,
+		Arch:            runtime.GOARCH,
+		Database:        "postgres",
+		DatabaseVersion: strconv.Itoa(dbVersion),
+		Users:           userCountBucket(users),
+	}, nil
+}
+
+// Preview returns exactly what would be sent, and whether it will be
+func (s *TelemetryService) Preview(ctx context.Context) (*models.TelemetryPreviewDTO, error) {
+	report, err := s.Report(ctx)
+	if err != nil {
+		return nil, err
+	}
+	preview := &models.TelemetryPreviewDTO{Enabled: s.enabled, Report: *report}
+	if s.enabled {
+		preview.Endpoint = s.endpoint
+	}
+	return preview, nil
+}
+
+// Send posts a report to the telemetry endpoint. Does nothing when telemetry is disabled.
+func (s *TelemetryService) Send(ctx context.Context) error {
+	if !s.enabled {
+		return nil
+	}
+
+	report, err := s.Report(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "NotesTelemetry/"+s.version)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// userCountBucket reports a user count as a range so small instances can't be told apart
+func userCountBucket(n int) string {
+	switch {
+	case n <= 1:
+		return strconv.Itoa(n)
+	case n <= 10:
+		return "2-10"
+	case n <= 100:
+		return "11-100"
+	case n <= 1000:
+		return "101-1000"
+	case n <= 10000:
+		return "1001-10000"
+	default:
+		return "10000+"
+	}
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/repository"
+	"github.com/hamishgilbert/notes-app/backend/internal/webauthn"
+)
+
+var (
+	ErrWebAuthnSessionExpired = errors.New("passkey session expired; start again")
+	ErrWebAuthnFailed         = errors.New("passkey verification failed")
+	ErrTooManyCredentials     = errors.New("too many passkeys")
+)
+
+// WebAuthnService registers passkeys and signs users in with them
+type WebAuthnService struct {
+	repo        *repository.WebAuthnRepository
+	userRepo    *repository.UserRepository
+	authService *AuthService
+	rp          *webauthn.RelyingParty
+}
+
+func NewWebAuthnService(repo *repository.WebAuthnRepository, userRepo *repository.UserRepository, authService *AuthService, rp *webauthn.RelyingParty) *WebAuthnService {
+	return &WebAuthnService{
+		repo:        repo,
+		userRepo:    userRepo,
+		authService: authService,
+		rp:          rp,
+	}
+}
+
+// BeginRegistration issues the options for adding a passkey to the user's account
+func (s *WebAuthnService) BeginRegistration(ctx context.Context, userID uuid.UUID) (*models.WebAuthnRegistrationOptionsResponse, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := s.repo.ListCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) >= models.MaxWebAuthnCredentials {
+		return nil, ErrTooManyCredentials
+	}
+
+	session, err := s.startSession(ctx, &userID, models.WebAuthnCeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make([]models.WebAuthnCredentialParamDTO, len(webauthn.Algorithms))
+	for i, alg := range webauthn.Algorithms {
+		params[i] = models.WebAuthnCredentialParamDTO{Type: "public-key", Alg: alg}
+	}
+
+	return &models.WebAuthnRegistrationOptionsResponse{
+		SessionID: session.ID.String(),
+		PublicKey: models.WebAuthnCreationOptionsDTO{
+			Challenge: webauthn.EncodeBase64URL(session.Challenge),
+			RP:        models.WebAuthnRPDTO{ID: s.rp.ID, Name: s.rp.Name},
+			User: models.WebAuthnUserDTO{
+				ID:          webauthn.EncodeBase64URL(user.ID[:]),
+				Name:        user.Username,
+				DisplayName: user.Username,
+			},
+			PubKeyCredParams:   params,
+			Timeout:            int(models.WebAuthnSessionExpiry.Milliseconds()),
+			ExcludeCredentials: credentialDescriptors(credentials), // don't register the same authenticator twice
+			AuthenticatorSelection: models.WebAuthnAuthenticatorSelectionDTO{
+				ResidentKey:        "required", // signing in doesn't list passkeys, so they must be discoverable
+				RequireResidentKey: true,       // the same for browsers older than residentKey
+				UserVerification:   "preferred",
+			},
+			Attestation: "none",
+		},
+	}, nil
+}
+
+// FinishRegistration verifies the authenticator's response and saves the new passkey
+func (s *WebAuthnService) FinishRegistration(ctx context.Context, userID uuid.UUID, req *models.WebAuthnRegisterFinishRequest, clientIP string) (*models.WebAuthnCredential, error) {
+	session, err := s.useSession(ctx, req.SessionID, models.WebAuthnCeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID == nil || *session.UserID != userID {
+		return nil, ErrWebAuthnSessionExpired
+	}
+
+	clientDataJSON, err1 := webauthn.DecodeBase64URL(req.Credential.Response.ClientDataJSON)
+	attestationObject, err2 := webauthn.DecodeBase64URL(req.Credential.Response.AttestationObject)
+	if err1 != nil || err2 != nil {
+		return nil, ErrWebAuthnFailed
+	}
+
+	verified, err := s.rp.VerifyRegistration(session.Challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		logging.Security(ctx, "Passkey registration failed", "user_id", userID.String(), "ip", clientIP, "error", err)
+		return nil, ErrWebAuthnFailed
+	}
+
+	count, err := s.repo.CountCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= models.MaxWebAuthnCredentials {
+		return nil, ErrTooManyCredentials
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "Passkey"
+	}
+	credential := &models.WebAuthnCredential{
+		ID:             uuid.New(),
+		UserID:         userID,
+		CredentialID:   verified.ID,
+		PublicKey:      verified.PublicKey,
+		SignCount:      int64(verified.SignCount),
+		AAGUID:         verified.AAGUID,
+		Transports:     req.Credential.Response.Transports,
+		BackupEligible: verified.BackupEligible,
+		Name:           name,
+		CreatedAt:      time.Now(),
+	}
+	if err := s.repo.CreateCredential(ctx, credential); err != nil {
+		return nil, err
+	}
+
+	logging.Security(ctx, "Passkey registered", "passkey_id", credential.ID.String(), "user_id", userID.String(), "ip", clientIP)
+	return credential, nil
+}
+
+// BeginLogin issues the options for signing in with a passkey. Given a username, only that user's
+// passkeys are accepted. The options never list passkeys, since that would tell whoever asks which
+// accounts exist and have passkeys; the authenticator offers the discoverable ones it holds.
+func (s *WebAuthnService) BeginLogin(ctx context.Context, username string) (*models.WebAuthnLoginOptionsResponse, error) {
+	var userID *uuid.UUID
+	if username != "" {
+		user, err := s.userRepo.GetByUsername(ctx, username)
+		switch {
+		case err == nil:
+			userID = &user.ID
+		case !errors.Is(err, repository.ErrUserNotFound):
+			return nil, err
+		}
+	}
+
+	session, err := s.startSession(ctx, userID, models.WebAuthnCeremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WebAuthnLoginOptionsResponse{
+		SessionID: session.ID.String(),
+		PublicKey: models.WebAuthnRequestOptionsDTO{
+			Challenge:        webauthn.EncodeBase64URL(session.Challenge),
+			RPID:             s.rp.ID,
+			Timeout:          int(models.WebAuthnSessionExpiry.Milliseconds()),
+			UserVerification: "preferred",
+		},
+	}, nil
+}
+
+// FinishLogin verifies the authenticator's signature and logs in the passkey's owner
+func (s *WebAuthnService) FinishLogin(ctx context.Context, req *models.WebAuthnLoginFinishRequest, clientIP string) (*models.User, *TokenPair, error) {
+	session, err := s.useSession(ctx, req.SessionID, models.WebAuthnCeremonyLogin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credentialID, err1 := webauthn.DecodeBase64URL(req.Credential.RawID)
+	clientDataJSON, err2 := webauthn.DecodeBase64URL(req.Credential.Response.ClientDataJSON)
+	authenticatorData, err3 := webauthn.DecodeBase64URL(req.Credential.Response.AuthenticatorData)
+	signature, err4 := webauthn.DecodeBase64URL(req.Credential.Response.Signature)
+	userHandle, err5 := webauthn.DecodeBase64URL(req.Credential.Response.UserHandle)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil {
+		return nil, nil, ErrWebAuthnFailed
+	}
+
+	credential, err := s.repo.GetCredential(ctx, credentialID)
+	if err != nil {
+		if errors.Is(err, repository.ErrWebAuthnCredentialNotFound) {
+			logging.Security(ctx, "Failed passkey login - unknown passkey", "ip", clientIP)
+			return nil, nil, ErrWebAuthnFailed
+		}
+		return nil, nil, err
+	}
+
+	// The passkey must belong to the user who started signing in, and to the user it says it was
+	// registered for
+	if session.UserID != nil && *session.UserID != credential.UserID {
+		logging.Security(ctx, "Failed passkey login - passkey of another user", "user_id", session.UserID.String(), "ip", clientIP)
+		return nil, nil, ErrWebAuthnFailed
+	}
+	if len(userHandle) > 0 && !bytes.Equal(userHandle, credential.UserID[:]) {
+		logging.Security(ctx, "Failed passkey login - user handle mismatch", "user_id", credential.UserID.String(), "ip", clientIP)
+		return nil, nil, ErrWebAuthnFailed
+	}
+
+	assertion, err := s.rp.VerifyAssertion(session.Challenge, credential.PublicKey, uint32(credential.SignCount), clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		logging.Security(ctx, "Failed passkey login", "user_id", credential.UserID.String(), "ip", clientIP, "error", err)
+		return nil, nil, ErrWebAuthnFailed
+	}
+	if err := s.repo.RecordUse(ctx, credential.ID, credential.SignCount, int64(assertion.SignCount)); err != nil {
+		if errors.Is(err, repository.ErrWebAuthnCredentialNotFound) {
+			return nil, nil, ErrWebAuthnFailed // removed, or used by a concurrent sign-in
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, credential.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens, err := s.authService.LoginVerified(ctx, user, "passkey", clientIP)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, tokens, nil
+}
+
+// Credentials returns the user's passkeys
+func (s *WebAuthnService) Credentials(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	return s.repo.ListCredentials(ctx, userID)
+}
+
+// DeleteCredential removes one of the user's passkeys
+func (s *WebAuthnService) DeleteCredential(ctx context.Context, userID, id uuid.UUID, clientIP string) error {
+	if err := s.repo.DeleteCredential(ctx, id, userID); err != nil {
+		return err
+	}
+	logging.Security(ctx, "Passkey removed", "passkey_id", id.String(), "user_id", userID.String(), "ip", clientIP)
+	return nil
+}
+
+// CleanupExpired removes challenges that were never answered
+func (s *WebAuthnService) CleanupExpired(ctx context.Context) (int64, error) {
+	return s.repo.DeleteExpiredSessions(ctx)
+}
+
+// startSession issues a challenge for one ceremony
+func (s *WebAuthnService) startSession(ctx context.Context, userID *uuid.UUID, ceremony string) (*models.WebAuthnSession, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	session := &models.WebAuthnSession{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(models.WebAuthnSessionExpiry),
+	}
+	if err := s.repo.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// useSession uses up the session with the given ID
+func (s *WebAuthnService) useSession(ctx context.Context, id string, ceremony string) (*models.WebAuthnSession, error) {
+	sessionID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrWebAuthnSessionExpired
+	}
+	session, err := s.repo.UseSession(ctx, sessionID, ceremony)
+	if errors.Is(err, repository.ErrWebAuthnSessionNotFound) {
+		return nil, ErrWebAuthnSessionExpired
+	}
+	return session, err
+}
+
+// credentialDescriptors lists passkeys for the options sent to clients
+func credentialDescriptors(credentials []models.WebAuthnCredential) []models.WebAuthnCredentialDescriptorDTO {
+	descriptors := make([]models.WebAuthnCredentialDescriptorDTO, len(credentials))
+	for i, credential := range credentials {
+		descriptors[i] = models.WebAuthnCredentialDescriptorDTO{
+			Type:       "public-key",
+			ID:         webauthn.EncodeBase64URL(credential.CredentialID),
+			Transports: credential.Transports,
+		}
+	}
+	return descriptors
+}
+
+// WebAuthnCredentialToDTO converts a passkey for the API
+func WebAuthnCredentialToDTO(credential *models.WebAuthnCredential) models.WebAuthnCredentialDTO {
+	dto := models.WebAuthnCredentialDTO{
+		ID:        credential.ID.String(),
+		Name:      credential.Name,
+		Synced:    credential.BackupEligible,
+		CreatedAt: credential.CreatedAt.Format(time.RFC3339),
+	}
+	if credential.LastUsedAt != nil {
+		lastUsed := credential.LastUsedAt.Format(time.RFC3339)
+		dto.LastUsedAt = &lastUsed
+	}
+	return dto
+}
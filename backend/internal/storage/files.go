@@ -0,0 +1,99 @@
+// Package storage keeps uploaded files on the local filesystem
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrFileNotFound = errors.New("file not found")
+	ErrFileTooLarge = errors.New("file too large")
+)
+
+// FileStore stores files in a directory, named by UUID so user input never reaches a path
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// CreateTemp returns a temporary file in the store's directory for streaming an upload into.
+// Pass it to Commit to keep it, or remove it with Discard.
+func (s *FileStore) CreateTemp() (*os.File, error) {
+	return os.CreateTemp(s.dir, "upload-*")
+}
+
+// Commit moves a temporary file to its permanent name
+func (s *FileStore) Commit(tmp *os.File, id uuid.UUID) error {
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(id))
+}
+
+// Discard closes and removes a temporary file
+func (s *FileStore) Discard(tmp *os.File) {
+	tmp.Close()
+	os.Remove(tmp.Name())
+}
+
+// Open opens a stored file for reading
+func (s *FileStore) Open(id uuid.UUID) (*os.File, error) {
+	f, err := os.Open(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrFileNotFound
+	}
+	return f, err
+}
+
+// Exists reports whether a file is stored under id
+func (s *FileStore) Exists(id uuid.UUID) (bool, error) {
+	_, err := os.Stat(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes a stored file; missing files are ignored
+func (s *FileStore) Delete(id uuid.UUID) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Copy writes r to a temporary file, stopping with an error once more than maxBytes have been read
+func (s *FileStore) Copy(r io.Reader, maxBytes int64) (*os.File, int64, error) {
+	tmp, err := s.CreateTemp()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n, err := io.Copy(tmp, io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		s.Discard(tmp)
+		return nil, 0, err
+	}
+	if n > maxBytes {
+		s.Discard(tmp)
+		return nil, 0, ErrFileTooLarge
+	}
+	return tmp, n, nil
+}
+
+func (s *FileStore) path(id uuid.UUID) string {
+	return filepath.Join(s.dir, id.String())
+}
@@ -0,0 +1,68 @@
+// Package textdelta applies text deltas in the format produced by diff-match-patch's diff_toDelta,
+// so clients can send the changes to a large note instead of its full content.
+//
+// A delta is a tab-separated list of operations applied to the base text in order: "=N" keeps the
+// next N characters, "-N" deletes them, and "+text" inserts URI-encoded text. Lengths count UTF-16
+// code units, as the JavaScript, Java and Objective-C versions of the library do.
+package textdelta
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ErrMismatch is returned when a delta doesn't fit the base text it is applied to
+var ErrMismatch = errors.New("delta does not match base text")
+
+// Hash identifies a version of note content: the hex SHA-256 of its UTF-8 bytes
+func Hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Apply returns base with delta applied. Every character of base must be kept or deleted.
+func Apply(base, delta string) (string, error) {
+	src := utf16.Encode([]rune(base))
+	out := make([]uint16, 0, len(src))
+	pos := 0
+
+	if delta != "" {
+		for _, token := range strings.Split(delta, "\t") {
+			if token == "" {
+				continue
+			}
+			param := token[1:]
+			switch token[0] {
+			case '+':
+				// diff_toDelta leaves '+' unescaped, so it must not be decoded as a space
+				text, err := url.PathUnescape(param)
+				if err != nil {
+					return "", fmt.Errorf("%w: bad insert %q", ErrMismatch, param)
+				}
+				out = append(out, utf16.Encode([]rune(text))...)
+			case '=', '-':
+				n, err := strconv.Atoi(param)
+				if err != nil || n < 0 || pos+n > len(src) {
+					return "", fmt.Errorf("%w: bad length %q", ErrMismatch, param)
+				}
+				if token[0] == '=' {
+					out = append(out, src[pos:pos+n]...)
+				}
+				pos += n
+			default:
+				return "", fmt.Errorf("%w: unknown operation %q", ErrMismatch, token[:1])
+			}
+		}
+	}
+
+	if pos != len(src) {
+		return "", fmt.Errorf("%w: delta covers %d of %d characters", ErrMismatch, pos, len(src))
+	}
+	return string(utf16.Decode(out)), nil
+}
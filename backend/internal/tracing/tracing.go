@@ -0,0 +1,55 @@
+// Package tracing sets up OpenTelemetry tracing. Requests, sync, database queries and WebSocket
+// broadcasts record spans through the global tracer provider, which does nothing until Setup
+// installs one that exports them over OTLP.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name is the instrumentation scope of the server's spans, and the service name unless
+// OTEL_SERVICE_NAME is set
+const Name = "notes-backend"
+
+// Tracer returns the tracer the server's spans are recorded with
+func Tracer() trace.Tracer {
+	return otel.Tracer(Name)
+}
+
+// Setup exports spans over OTLP/HTTP. The exporter is configured by the standard OTEL_EXPORTER_OTLP_*
+// variables, sampling by OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG, and the resource by
+// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES. Trace context is taken from and passed on in W3C
+// traceparent and baggage headers. The returned function flushes the spans still buffered.
+func Setup(ctx context.Context, environment string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName(Name), semconv.DeploymentEnvironmentName(environment)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
@@ -2,14 +2,15 @@ package validation
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"unicode"
 )
 
 // Password validation errors
 var (
-	ErrPasswordTooShort     = errors.New("password must be at least 12 characters")
-	ErrPasswordTooLong      = errors.New("password must be at most 128 characters")
+	ErrPasswordTooShort     = errors.New("password is too short")
+	ErrPasswordTooLong      = errors.New("password is too long")
 	ErrPasswordNoUppercase  = errors.New("password must contain at least one uppercase letter")
 	ErrPasswordNoLowercase  = errors.New("password must contain at least one lowercase letter")
 	ErrPasswordNoDigit      = errors.New("password must contain at least one digit")
@@ -42,10 +43,10 @@ func DefaultPasswordRequirements() PasswordRequirements {
 func ValidatePassword(password string, req PasswordRequirements) error {
 	// Check length
 	if len(password) < req.MinLength {
-		return ErrPasswordTooShort
+		return fmt.Errorf("%w: it must be at least %d characters", ErrPasswordTooShort, req.MinLength)
 	}
 	if len(password) > req.MaxLength {
-		return ErrPasswordTooLong
+		return fmt.Errorf("%w: it must be at most %d characters", ErrPasswordTooLong, req.MaxLength)
 	}
 
 	var (
@@ -96,6 +97,28 @@ func isSpecialChar(char rune) bool {
 }
 
 // PasswordRequirementsMessage returns a human-readable description of password requirements
-func PasswordRequirementsMessage() string {
-	return "Password must be 12-128 characters and contain at least one uppercase letter, one lowercase letter, one digit, and one special character (!@#$%^&*()_+-=[]{}|;':\",./<>?`~)"
+func PasswordRequirementsMessage(req PasswordRequirements) string {
+	var classes []string
+	if req.RequireUppercase {
+		classes = append(classes, "one uppercase letter")
+	}
+	if req.RequireLowercase {
+		classes = append(classes, "one lowercase letter")
+	}
+	if req.RequireDigit {
+		classes = append(classes, "one digit")
+	}
+	if req.RequireSpecial {
+		classes = append(classes, "one special character (!@#$%^&*()_+-=[]{}|;':\",./<>?`~)")
+	}
+
+	message := fmt.Sprintf("Password must be %d-%d characters", req.MinLength, req.MaxLength)
+	switch len(classes) {
+	case 0:
+	case 1:
+		message += " and contain at least " + classes[0]
+	default:
+		message += " and contain at least " + strings.Join(classes[:len(classes)-1], ", ") + ", and " + classes[len(classes)-1]
+	}
+	return message
 }
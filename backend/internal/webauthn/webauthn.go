@@ -0,0 +1,357 @@
+// Package webauthn verifies WebAuthn (passkey) registrations and sign-ins.
+//
+// Only the "none" attestation conveyance is supported: the authenticator's make isn't vouched for,
+// which is what passkey providers send anyway. Credential keys may be ES256, EdDSA or RS256.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+)
+
+var (
+	ErrInvalidClientData        = errors.New("invalid client data")
+	ErrChallengeMismatch        = errors.New("challenge does not match")
+	ErrOriginNotAllowed         = errors.New("origin not allowed")
+	ErrInvalidAuthenticatorData = errors.New("invalid authenticator data")
+	ErrRPIDMismatch             = errors.New("credential is for another relying party")
+	ErrUserNotPresent           = errors.New("user presence not confirmed")
+	ErrUnsupportedKey           = errors.New("unsupported credential key")
+	ErrInvalidSignature         = errors.New("invalid signature")
+	ErrSignCountRegressed       = errors.New("signature counter went backwards; the authenticator may have been cloned")
+)
+
+// ChallengeSize is the number of random bytes in a challenge
+const ChallengeSize = 32
+
+// COSE algorithm identifiers
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms lists the supported algorithms, most preferred first
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// Ceremonies, as sent in the client data type
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent        = 0x01
+	flagUserVerified       = 0x04
+	flagBackupEligible     = 0x08
+	flagAttestedCredential = 0x40
+)
+
+// RelyingParty is the site credentials are registered with
+type RelyingParty struct {
+	ID      string   // domain credentials are scoped to, such as notes.example.com
+	Name    string   // shown by the authenticator
+	Origins []string // origins clients may register and sign in from
+}
+
+// Credential is a newly registered credential
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // COSE_Key
+	SignCount      uint32
+	AAGUID         []byte // authenticator model, all zeroes when not disclosed
+	UserVerified   bool
+	BackupEligible bool // synced passkey rather than one bound to a device
+}
+
+// Assertion is a verified sign-in
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// NewChallenge returns a random challenge
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// EncodeBase64URL encodes bytes as the unpadded base64url WebAuthn uses in JSON
+func EncodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeBase64URL decodes base64url, with or without padding
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// VerifyRegistration checks the response to navigator.credentials.create and returns the new
+// credential
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, ceremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	var attestation struct {
+		Fmt      string `codec:"fmt"`
+		AuthData []byte `codec:"authData"`
+	}
+	if err := codec.NewDecoderBytes(attestationObject, cborHandle()).Decode(&attestation); err != nil {
+		return nil, ErrInvalidAuthenticatorData
+	}
+
+	data, err := rp.parseAuthenticatorData(attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if data.credentialID == nil {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	if _, err := parsePublicKey(data.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:             data.credentialID,
+		PublicKey:      data.publicKey,
+		SignCount:      data.signCount,
+		AAGUID:         data.aaguid,
+		UserVerified:   data.flags&flagUserVerified != 0,
+		BackupEligible: data.flags&flagBackupEligible != 0,
+	}, nil
+}
+
+// VerifyAssertion checks the response to navigator.credentials.get against the credential's public
+// key and the signature counter stored for it
+func (rp *RelyingParty) VerifyAssertion(challenge, publicKey []byte, storedSignCount uint32, clientDataJSON, authenticatorData, signature []byte) (*Assertion, error) {
+	if err := rp.verifyClientData(clientDataJSON, ceremonyGet, challenge); err != nil {
+		return nil, err
+	}
+
+	data, err := rp.parseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+	if err := key.verify(signed, signature); err != nil {
+		return nil, err
+	}
+
+	// Authenticators that count signatures must always count up. Synced passkeys always send 0.
+	if (data.signCount != 0 || storedSignCount != 0) && data.signCount <= storedSignCount {
+		return nil, ErrSignCountRegressed
+	}
+
+	return &Assertion{
+		SignCount:    data.signCount,
+		UserVerified: data.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// verifyClientData checks the client data is for this ceremony, challenge and an allowed origin
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil || clientData.Type != ceremony {
+		return ErrInvalidClientData
+	}
+
+	got, err := DecodeBase64URL(clientData.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	for _, origin := range rp.Origins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+	return ErrOriginNotAllowed
+}
+
+// authenticatorData is the parsed authenticator data
+type authenticatorData struct {
+	flags     byte
+	signCount uint32
+
+	// Present when registering
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData parses authenticator data, checking it is for this relying party and the
+// user was present
+func (rp *RelyingParty) parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	// rpIdHash (32), flags (1), signCount (4)
+	if len(b) < 37 {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(b[:32], rpIDHash[:]) {
+		return nil, ErrRPIDMismatch
+	}
+
+	data := &authenticatorData{
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if data.flags&flagUserPresent == 0 {
+		return nil, ErrUserNotPresent
+	}
+	if data.flags&flagAttestedCredential == 0 {
+		return data, nil
+	}
+
+	// aaguid (16), credentialIdLength (2), credentialId, credentialPublicKey (CBOR), then any
+	// extensions
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	data.aaguid = append([]byte{}, rest[:16]...)
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	data.credentialID = append([]byte{}, rest[:idLength]...)
+	rest = rest[idLength:]
+
+	var key map[int64]interface{}
+	decoder := codec.NewDecoderBytes(rest, cborHandle())
+	if err := decoder.Decode(&key); err != nil {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	data.publicKey = append([]byte{}, rest[:decoder.NumBytesRead()]...)
+	return data, nil
+}
+
+// COSE_Key parameters
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1 // OKP and EC2
+	coseX      = -2 // OKP and EC2
+	coseY      = -3 // EC2
+	coseRSAN   = -1
+	coseRSAE   = -2
+	ktyOKP     = 1
+	ktyEC2     = 2
+	ktyRSA     = 3
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// minRSABits is the smallest RSA key accepted
+const minRSABits = 2048
+
+// publicKey is a credential's public key
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey parses a COSE_Key
+func parsePublicKey(cose []byte) (*publicKey, error) {
+	var params map[int64]interface{}
+	if err := codec.NewDecoderBytes(cose, cborHandle()).Decode(&params); err != nil {
+		return nil, ErrUnsupportedKey
+	}
+	kty, _ := params[coseKty].(int64)
+	alg, _ := params[coseAlg].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := params[coseCrv].(int64)
+		x, _ := params[coseX].([]byte)
+		y, _ := params[coseY].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+		// Rejects points that aren't on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, ErrUnsupportedKey
+		}
+		return &publicKey{alg: alg, key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := params[coseCrv].(int64)
+		x, _ := params[coseX].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := params[coseRSAN].([]byte)
+		e, _ := params[coseRSAE].([]byte)
+		modulus := new(big.Int).SetBytes(n)
+		exponent := new(big.Int).SetBytes(e)
+		if modulus.BitLen() < minRSABits || len(e) == 0 || !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, ErrUnsupportedKey
+		}
+		return &publicKey{alg: alg, key: &rsa.PublicKey{N: modulus, E: int(exponent.Int64())}}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// verify checks a signature over data
+func (k *publicKey) verify(data, signature []byte) error {
+	var ok bool
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// cborHandle decodes CBOR integers as int64, byte strings as []byte and text as string
+func cborHandle() *codec.CborHandle {
+	h := &codec.CborHandle{}
+	h.SignedInteger = true
+	return h
+}
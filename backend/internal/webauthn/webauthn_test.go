@@ -0,0 +1,271 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	testRPID   = "notes.example.com"
+	testOrigin = "https://notes.example.com"
+)
+
+var testRP = &RelyingParty{ID: testRPID, Name: "Notes", Origins: []string{testOrigin}}
+
+// testKey is a credential key pair: its COSE_Key and a signer for assertions
+type testKey struct {
+	cose []byte
+	sign func(data []byte) []byte
+}
+
+func encodeCBOR(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	var b []byte
+	if err := codec.NewEncoderBytes(&b, cborHandle()).Encode(v); err != nil {
+		t.Fatalf("encode CBOR: %v", err)
+	}
+	return b
+}
+
+func newES256Key(t *testing.T) testKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{
+		cose: encodeCBOR(t, map[int64]interface{}{
+			coseKty: ktyEC2, coseAlg: AlgES256, coseCrv: crvP256,
+			coseX: key.X.FillBytes(make([]byte, 32)), coseY: key.Y.FillBytes(make([]byte, 32)),
+		}),
+		sign: func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		},
+	}
+}
+
+func newEdDSAKey(t *testing.T) testKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{
+		cose: encodeCBOR(t, map[int64]interface{}{
+			coseKty: ktyOKP, coseAlg: AlgEdDSA, coseCrv: crvEd25519, coseX: []byte(pub),
+		}),
+		sign: func(data []byte) []byte { return ed25519.Sign(priv, data) },
+	}
+}
+
+func newRS256Key(t *testing.T, bits int) testKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{
+		cose: encodeCBOR(t, map[int64]interface{}{
+			coseKty: ktyRSA, coseAlg: AlgRS256,
+			coseRSAN: key.N.Bytes(), coseRSAE: big.NewInt(int64(key.E)).Bytes(),
+		}),
+		sign: func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		},
+	}
+}
+
+// offCurveKey is an ES256 COSE_Key whose point isn't on P-256
+func offCurveKey(t *testing.T) []byte {
+	t.Helper()
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	x[31], y[31] = 1, 1
+	return encodeCBOR(t, map[int64]interface{}{
+		coseKty: ktyEC2, coseAlg: AlgES256, coseCrv: crvP256, coseX: x, coseY: y,
+	})
+}
+
+func clientDataJSON(t *testing.T, ceremony string, challenge []byte, origin string) []byte {
+	t.Helper()
+	b, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": EncodeBase64URL(challenge),
+		"origin":    origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// authData builds authenticator data; with a COSE_Key it carries an attested credential
+func authData(rpID string, flags byte, signCount uint32, cose []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	b := append([]byte{}, rpIDHash[:]...)
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, signCount)
+	if cose != nil {
+		credentialID := []byte("credential-1")
+		b = append(b, make([]byte, 16)...) // aaguid
+		b = binary.BigEndian.AppendUint16(b, uint16(len(credentialID)))
+		b = append(b, credentialID...)
+		b = append(b, cose...)
+	}
+	return b
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	challenge := []byte("registration-challenge-32-bytes!")
+	es256 := newES256Key(t)
+	eddsa := newEdDSAKey(t)
+	rs256 := newRS256Key(t, 2048)
+	rsaSmall := newRS256Key(t, 1024)
+
+	tests := []struct {
+		name      string
+		rpID      string
+		flags     byte
+		ceremony  string
+		challenge []byte
+		origin    string
+		cose      []byte
+		want      error
+	}{
+		{name: "ES256", cose: es256.cose},
+		{name: "EdDSA", cose: eddsa.cose},
+		{name: "RS256", cose: rs256.cose},
+		{name: "RS256 key too small", cose: rsaSmall.cose, want: ErrUnsupportedKey},
+		{name: "ES256 point off the curve", cose: offCurveKey(t), want: ErrUnsupportedKey},
+		{name: "wrong RP ID hash", rpID: "evil.example.com", cose: es256.cose, want: ErrRPIDMismatch},
+		{name: "wrong origin", origin: "https://evil.example.com", cose: es256.cose, want: ErrOriginNotAllowed},
+		{name: "user not present", flags: flagAttestedCredential, cose: es256.cose, want: ErrUserNotPresent},
+		{name: "wrong challenge", challenge: []byte("another-challenge"), cose: es256.cose, want: ErrChallengeMismatch},
+		{name: "sign-in client data", ceremony: ceremonyGet, cose: es256.cose, want: ErrInvalidClientData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rpID, flags, ceremony, sent, origin := testRPID, byte(flagUserPresent|flagAttestedCredential), ceremonyCreate, challenge, testOrigin
+			if tt.rpID != "" {
+				rpID = tt.rpID
+			}
+			if tt.flags != 0 {
+				flags = tt.flags
+			}
+			if tt.ceremony != "" {
+				ceremony = tt.ceremony
+			}
+			if tt.challenge != nil {
+				sent = tt.challenge
+			}
+			if tt.origin != "" {
+				origin = tt.origin
+			}
+
+			attestationObject := encodeCBOR(t, map[string]interface{}{
+				"fmt":      "none",
+				"attStmt":  map[string]interface{}{},
+				"authData": authData(rpID, flags, 0, tt.cose),
+			})
+			credential, err := testRP.VerifyRegistration(challenge, clientDataJSON(t, ceremony, sent, origin), attestationObject)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyRegistration() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && string(credential.ID) != "credential-1" {
+				t.Errorf("credential ID = %q, want %q", credential.ID, "credential-1")
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	challenge := []byte("assertion-challenge-of-32-bytes!")
+	es256 := newES256Key(t)
+	eddsa := newEdDSAKey(t)
+	rs256 := newRS256Key(t, 2048)
+
+	tests := []struct {
+		name        string
+		key         testKey
+		storedKey   []byte // the key on record, when not key's own
+		rpID        string
+		flags       byte
+		origin      string
+		storedCount uint32
+		signCount   uint32
+		tamper      bool // sign something other than what's sent
+		want        error
+	}{
+		{name: "ES256", key: es256, signCount: 1},
+		{name: "EdDSA", key: eddsa, signCount: 1},
+		{name: "RS256", key: rs256, signCount: 1},
+		{name: "synced passkey without a counter", key: es256},
+		{name: "counter goes up", key: es256, storedCount: 7, signCount: 8},
+		{name: "counter repeats", key: es256, storedCount: 7, signCount: 7, want: ErrSignCountRegressed},
+		{name: "counter goes back", key: es256, storedCount: 7, signCount: 3, want: ErrSignCountRegressed},
+		{name: "counter stops", key: es256, storedCount: 7, signCount: 0, want: ErrSignCountRegressed},
+		{name: "bad ES256 signature", key: es256, signCount: 1, tamper: true, want: ErrInvalidSignature},
+		{name: "bad EdDSA signature", key: eddsa, signCount: 1, tamper: true, want: ErrInvalidSignature},
+		{name: "bad RS256 signature", key: rs256, signCount: 1, tamper: true, want: ErrInvalidSignature},
+		{name: "signed by another key", key: es256, storedKey: newES256Key(t).cose, signCount: 1, want: ErrInvalidSignature},
+		{name: "stored ES256 point off the curve", key: es256, storedKey: offCurveKey(t), signCount: 1, want: ErrUnsupportedKey},
+		{name: "wrong RP ID hash", key: es256, rpID: "evil.example.com", signCount: 1, want: ErrRPIDMismatch},
+		{name: "wrong origin", key: es256, origin: "https://evil.example.com", signCount: 1, want: ErrOriginNotAllowed},
+		{name: "user not present", key: es256, flags: flagUserVerified, signCount: 1, want: ErrUserNotPresent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rpID, flags, origin, stored := testRPID, byte(flagUserPresent), testOrigin, tt.key.cose
+			if tt.rpID != "" {
+				rpID = tt.rpID
+			}
+			if tt.flags != 0 {
+				flags = tt.flags
+			}
+			if tt.origin != "" {
+				origin = tt.origin
+			}
+			if tt.storedKey != nil {
+				stored = tt.storedKey
+			}
+
+			clientData := clientDataJSON(t, ceremonyGet, challenge, origin)
+			authenticatorData := authData(rpID, flags, tt.signCount, nil)
+			clientDataHash := sha256.Sum256(clientData)
+			signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+			if tt.tamper {
+				signed[len(signed)-1] ^= 0xff
+			}
+
+			assertion, err := testRP.VerifyAssertion(challenge, stored, tt.storedCount, clientData, authenticatorData, tt.key.sign(signed))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyAssertion() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && assertion.SignCount != tt.signCount {
+				t.Errorf("sign count = %d, want %d", assertion.SignCount, tt.signCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
+)
+
+// CloseUnauthorized closes connections whose access token expired or was revoked. Clients should get
+// a new token and reconnect.
+const CloseUnauthorized = 4401
+
+const (
+	// authRecheckInterval is how often an open connection's token is checked for revocation
+	authRecheckInterval = time.Minute
+
+	// authCheckTimeout bounds one token check
+	authCheckTimeout = 5 * time.Second
+)
+
+// ErrTokenRejected wraps the reason a TokenValidator refused a token. Other errors mean the token
+// couldn't be checked, and the connection is left open.
+var ErrTokenRejected = errors.New("access token rejected")
+
+// TokenClaims is what a connection's access token allows
+type TokenClaims struct {
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	ReadOnly  bool // the account can't change anything, so note writes are refused
+}
+
+// TokenValidator checks an access token for a connection
+type TokenValidator func(ctx context.Context, token string) (TokenClaims, error)
+
+// AuthRefreshPayload is sent by a client with a new access token before its current one expires
+type AuthRefreshPayload struct {
+	Token string `json:"token"`
+}
+
+// AuthRefreshedPayload confirms a new token was accepted
+type AuthRefreshedPayload struct {
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// SetAuth records the token a client connected with. validate rechecks it, and checks the tokens
+// the client refreshes with.
+func (c *Client) SetAuth(token string, claims TokenClaims, validate TokenValidator) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.token = token
+	c.tokenExpiresAt = claims.ExpiresAt
+	c.tokenCheckedAt = time.Now()
+	c.readOnly = claims.ReadOnly
+	c.validate = validate
+}
+
+// isReadOnly reports whether the client's token is for a read-only account
+func (c *Client) isReadOnly() bool {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	return c.readOnly
+}
+
+// refreshAuth switches the connection to a new token, which must belong to the same user. A rejected
+// token leaves the current one in place.
+func (c *Client) refreshAuth(token string) {
+	c.authMu.Lock()
+	validate := c.validate
+	c.authMu.Unlock()
+	if validate == nil {
+		c.sendError("auth_failed", "token refresh isn't supported on this connection")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), authCheckTimeout)
+	claims, err := validate(ctx, token)
+	cancel()
+	if err == nil && claims.UserID != c.UserID {
+		err = ErrTokenRejected
+	}
+	if err != nil {
+		logging.Security(ctx, "WebSocket token refresh rejected", "connection_id", c.ID, "user_id", c.UserID.String(), "error", err)
+		c.sendError("auth_failed", "token was rejected")
+		return
+	}
+
+	c.authMu.Lock()
+	c.token = token
+	c.tokenExpiresAt = claims.ExpiresAt
+	c.tokenCheckedAt = time.Now()
+	c.readOnly = claims.ReadOnly
+	c.authMu.Unlock()
+
+	// Let WritePump move its expiry timer
+	select {
+	case c.authChanged <- struct{}{}:
+	default:
+	}
+	c.SendMessage(WSMessage{
+		Type:    MessageTypeAuthRefreshed,
+		Payload: AuthRefreshedPayload{ExpiresAt: claims.ExpiresAt.UTC().Format(time.RFC3339)},
+	})
+}
+
+// untilExpiry is how long the connection's token has left; connections without one never expire
+func (c *Client) untilExpiry() time.Duration {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.tokenExpiresAt.IsZero() {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Until(c.tokenExpiresAt)
+}
+
+// recheckAuth checks the token again if it hasn't been for authRecheckInterval, returning an error
+// wrapping ErrTokenRejected once it has been revoked
+func (c *Client) recheckAuth() error {
+	c.authMu.Lock()
+	token, validate, due := c.token, c.validate, time.Since(c.tokenCheckedAt) >= authRecheckInterval
+	c.authMu.Unlock()
+	if validate == nil || !due {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), authCheckTimeout)
+	_, err := validate(ctx, token)
+	cancel()
+	if err != nil && !errors.Is(err, ErrTokenRejected) {
+		slog.WarnContext(ctx, "Failed to recheck WebSocket token", "connection_id", c.ID, "user_id", c.UserID.String(), "error", err)
+		return nil
+	}
+
+	c.authMu.Lock()
+	if c.token == token {
+		c.tokenCheckedAt = time.Now()
+	}
+	c.authMu.Unlock()
+	return err
+}
+
+// closeUnauthorized tells the client why before closing the connection with CloseUnauthorized.
+// Only WritePump calls it.
+func (c *Client) closeUnauthorized(code, message string) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.Hub.config.WriteWait))
+	if data, err := json.Marshal(WSMessage{Type: MessageTypeError, Payload: ErrorPayload{Code: code, Message: message}}); err == nil {
+		if data, keep, err := c.encode(data); err == nil && keep {
+			c.Conn.WriteMessage(c.frameType(), data)
+		}
+	}
+	c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseUnauthorized, message))
+}
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"log/slog"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// CloseSyncRequired closes a connection that fell so far behind that messages to it were dropped.
+// The client has missed changes, so it should reconnect and do a full sync.
+const CloseSyncRequired = 4409
+
+// maxLaggingClients caps how many slow connections Stats lists
+const maxLaggingClients = 20
+
+// ClientLag describes a connection that has had messages dropped or has a backed-up send buffer
+type ClientLag struct {
+	ConnectionID string
+	UserID       uuid.UUID
+	Queued       int   // messages waiting in the send buffer
+	Dropped      int64 // messages dropped because the buffer was full
+}
+
+// enqueue queues a message for a client without blocking. A full buffer drops the message, and once
+// MaxDroppedMessages have been dropped in a row the client is closed with CloseSyncRequired rather
+// than left to drift further out of date.
+func (h *Hub) enqueue(client *Client, data []byte) bool {
+	if client.lagging.Load() || client.isClosed() {
+		return false
+	}
+	select {
+	case client.Send <- data:
+		client.droppedInRow.Store(0)
+		h.delivered.Add(1)
+		h.bytesSent.Add(int64(len(data)))
+		return true
+	default:
+	}
+
+	client.dropped.Add(1)
+	h.dropped.Add(1)
+	if client.droppedInRow.Add(1) >= int64(h.config.MaxDroppedMessages) && client.lagging.CompareAndSwap(false, true) {
+		slog.Warn("WebSocket connection dropped too many messages in a row; closing it so the client resyncs",
+			"connection_id", client.ID, "user_id", client.UserID.String(), "dropped", h.config.MaxDroppedMessages)
+		h.slowDisconnects.Add(1)
+		client.closeCode = CloseSyncRequired
+		client.closeReason = "sync_required"
+		// The caller may hold the hub's locks, which unregistering takes
+		go h.Unregister(client)
+	}
+	return false
+}
+
+// laggingClients lists the connections with dropped messages or a send buffer at least half full,
+// most dropped first
+func (h *Hub) laggingClients() []ClientLag {
+	h.mu.RLock()
+	var lagging []ClientLag
+	for _, userClients := range h.clients {
+		for _, client := range userClients {
+			lag := ClientLag{
+				ConnectionID: client.ID,
+				UserID:       client.UserID,
+				Queued:       len(client.Send),
+				Dropped:      client.dropped.Load(),
+			}
+			if lag.Dropped > 0 || lag.Queued >= cap(client.Send)/2 {
+				lagging = append(lagging, lag)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(lagging, func(i, j int) bool {
+		if lagging[i].Dropped != lagging[j].Dropped {
+			return lagging[i].Dropped > lagging[j].Dropped
+		}
+		return lagging[i].Queued > lagging[j].Queued
+	})
+	if len(lagging) > maxLaggingClients {
+		lagging = lagging[:maxLaggingClients]
+	}
+	return lagging
+}
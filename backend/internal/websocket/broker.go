@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Broker carries broadcasts between server instances, so a user's connections get every event
+// whichever instance they're connected to and whichever one handled the change
+type Broker interface {
+	// Publish sends data, a broadcast to userID's connections, to every instance, including this one
+	Publish(ctx context.Context, userID uuid.UUID, data []byte) error
+	// Run passes data published by any instance to receive until ctx is cancelled
+	Run(ctx context.Context, receive func(data []byte))
+}
+
+const (
+	// brokerOutboxSize is how many broadcasts can wait to be published before new ones are dropped
+	brokerOutboxSize = 1024
+
+	// brokerPublishTimeout bounds publishing one broadcast
+	brokerPublishTimeout = 5 * time.Second
+)
+
+// brokerEnvelope is a broadcast as published to other instances
+type brokerEnvelope struct {
+	Origin  string          `json:"origin"` // the publishing hub's epoch, so it can skip its own
+	UserID  uuid.UUID       `json:"userId"`
+	Exclude string          `json:"exclude,omitempty"`
+	Message json.RawMessage `json:"message"`
+
+	// Trace carries the broadcast's trace context, so other instances deliver it as part of the trace
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+// outgoingBroadcast is a broadcast waiting to be published
+type outgoingBroadcast struct {
+	userID uuid.UUID
+	data   []byte
+	span   trace.SpanContext
+}
+
+// SetBroker makes the hub share broadcasts with other instances through broker. Call it before Run.
+func (h *Hub) SetBroker(broker Broker) {
+	h.broker = broker
+	h.outbox = make(chan outgoingBroadcast, brokerOutboxSize)
+}
+
+// publish queues a broadcast for other instances. Broadcasts are published in order by runBroker, so
+// a slow or unreachable broker doesn't hold up the request that made the change.
+func (h *Hub) publish(userID uuid.UUID, message []byte, excludeConnID string, span trace.SpanContext) {
+	if h.broker == nil {
+		return
+	}
+	envelope := brokerEnvelope{Origin: h.epoch, UserID: userID, Exclude: excludeConnID, Message: message}
+	if span.IsValid() {
+		envelope.Trace = map[string]string{}
+		otel.GetTextMapPropagator().Inject(trace.ContextWithSpanContext(context.Background(), span), propagation.MapCarrier(envelope.Trace))
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		slog.Warn("Failed to encode broadcast for other instances", "error", err)
+		return
+	}
+	select {
+	case h.outbox <- outgoingBroadcast{userID: userID, data: data, span: span}:
+	default:
+		slog.Warn("Broker outbox full; broadcast not sent to other instances", "user_id", userID.String())
+	}
+}
+
+// runBroker publishes queued broadcasts and delivers other instances' to local clients until ctx
+// is cancelled
+func (h *Hub) runBroker(ctx context.Context) {
+	go h.broker.Run(ctx, h.receiveBroadcast)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case pending := <-h.outbox:
+			publishCtx, cancel := context.WithTimeout(ctx, brokerPublishTimeout)
+			span := trace.SpanFromContext(publishCtx)
+			if pending.span.IsValid() {
+				publishCtx, span = tracing.Tracer().Start(trace.ContextWithSpanContext(publishCtx, pending.span), "websocket.publish",
+					trace.WithSpanKind(trace.SpanKindProducer))
+			}
+			if err := h.broker.Publish(publishCtx, pending.userID, pending.data); err != nil && ctx.Err() == nil {
+				slog.WarnContext(publishCtx, "Failed to publish broadcast to other instances", "error", err)
+			}
+			span.End()
+			cancel()
+		}
+	}
+}
+
+// receiveBroadcast delivers a broadcast published by another instance to this one's clients
+func (h *Hub) receiveBroadcast(data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.recordPanic("broadcast from another instance", r)
+		}
+	}()
+
+	var envelope brokerEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		slog.Warn("Ignoring malformed broadcast from another instance", "error", err)
+		return
+	}
+	if envelope.Origin == h.epoch {
+		return
+	}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(envelope.Trace))
+	h.deliver(ctx, envelope.UserID, envelope.Message, envelope.Exclude)
+}
@@ -1,26 +1,16 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-)
-
-const (
-	// Time allowed to write a message to the peer
-	writeWait = 10 * time.Second
-
-	// Time allowed to read the next pong message from the peer
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period (must be less than pongWait)
-	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer
-	maxMessageSize = 65536
+	"github.com/hamishgilbert/notes-app/backend/internal/logging"
 )
 
 // Client represents a single WebSocket connection
@@ -30,105 +20,306 @@ type Client struct {
 	Hub    *Hub
 	Conn   *websocket.Conn
 	Send   chan []byte
+
+	// Version is the protocol version negotiated at connect; messages are converted down to it
+	Version int
+
+	// Binary clients asked for MessagePack in binary frames instead of JSON text
+	Binary bool
+
+	// The device the connection is from, shown to the user's other devices in presence messages
+	DeviceID    string
+	DeviceName  string
+	ConnectedAt time.Time
+
+	// Where the connection came from, for audit logs of writes made over it
+	ClientIP  string
+	UserAgent string
+
+	// ResumeFrom is the last event the client saw before reconnecting; later ones are replayed
+	ResumeFrom *ResumePoint
+
+	// The access token the connection is authorized by, replaced by auth_refresh
+	authMu         sync.Mutex
+	token          string
+	tokenExpiresAt time.Time
+	tokenCheckedAt time.Time
+	readOnly       bool
+	validate       TokenValidator
+	authChanged    chan struct{}
+
+	// Events the client subscribed to; nil means all of them. subscriptionMu serializes changes.
+	subscription   atomic.Pointer[subscription]
+	subscriptionMu sync.Mutex
+
+	// Messages dropped because Send was full, in total and since the last one that fit; lagging is
+	// set once the client is being closed for it
+	dropped      atomic.Int64
+	droppedInRow atomic.Int64
+	lagging      atomic.Bool
+
+	// closed is closed when the hub lets go of the client, for WritePump to close the connection.
+	// Send itself is never closed, so sending after that is dropped instead of panicking.
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// closeCode and closeReason are sent in the close frame when the hub lets go of the client
+	// (default: normal closure)
+	closeCode   int
+	closeReason string
 }
 
-// NewClient creates a new client instance
-func NewClient(hub *Hub, conn *websocket.Conn, userID uuid.UUID) *Client {
+// NewClient creates a new client instance speaking the given protocol version
+func NewClient(hub *Hub, conn *websocket.Conn, userID uuid.UUID, version int) *Client {
 	return &Client{
-		ID:     uuid.New().String(),
-		UserID: userID,
-		Hub:    hub,
-		Conn:   conn,
-		Send:   make(chan []byte, 256),
+		ID:      uuid.New().String(),
+		UserID:  userID,
+		Hub:     hub,
+		Conn:    conn,
+		Send:    make(chan []byte, 256),
+		Version: version,
+
+		ConnectedAt: time.Now(),
+		authChanged: make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+	}
+}
+
+// close tells WritePump to close the connection. It may be called more than once.
+func (c *Client) close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// isClosed reports whether the hub has let go of the client
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
 	}
 }
 
 // ReadPump pumps messages from the WebSocket connection to the hub
 func (c *Client) ReadPump() {
 	defer func() {
+		if r := recover(); r != nil {
+			c.Hub.recordPanic("read pump of client "+c.ID, r)
+		}
 		c.Hub.Unregister(c)
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(maxMessageSize)
-	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	limiter := newMessageLimiter(c.Hub.config)
+
+	c.Conn.SetReadLimit(c.Hub.config.MaxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(c.Hub.config.PongWait))
 	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.Conn.SetReadDeadline(time.Now().Add(c.Hub.config.PongWait))
 		return nil
 	})
 
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		frameType, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				slog.Info("WebSocket connection closed unexpectedly", "connection_id", c.ID, "user_id", c.UserID.String(), "error", err)
 			}
 			break
 		}
 
-		c.handleMessage(message)
+		// The HTTP rate limiter only sees the upgrade, so each connection limits its own messages
+		switch limiter.check(len(message), time.Now()) {
+		case rateAllowed:
+			// Binary frames carry MessagePack, handled like the equivalent JSON
+			if frameType == websocket.BinaryMessage {
+				if message, err = fromMsgpack(message); err != nil {
+					c.sendError("invalid_message", "message is not valid MessagePack")
+					continue
+				}
+			}
+			c.handleMessage(message)
+		case rateWarn:
+			c.sendError("rate_limited", "too many messages; slow down or the connection will be closed")
+		case rateDropped:
+		case rateDisconnect:
+			logging.Security(context.Background(), "WebSocket connection closed - kept exceeding the message rate limit", "connection_id", c.ID, "user_id", c.UserID.String())
+			c.Hub.rateLimitDisconnects.Add(1)
+			c.Conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded"),
+				time.Now().Add(c.Hub.config.WriteWait))
+			return
+		}
 	}
 }
 
 // WritePump pumps messages from the hub to the WebSocket connection
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.Hub.config.PingPeriod)
+	expiry := time.NewTimer(c.untilExpiry())
 	defer func() {
+		// Closing the connection ends ReadPump too, which unregisters the client
+		if r := recover(); r != nil {
+			c.Hub.recordPanic("write pump of client "+c.ID, r)
+		}
 		ticker.Stop()
+		expiry.Stop()
 		c.Conn.Close()
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// Hub closed the channel
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+		case <-c.closed:
+			// Hub let go of the client; what's already queued goes out before the close frame
+			for len(c.Send) > 0 {
+				if err := c.writeMessage(<-c.Send); err != nil {
+					return
+				}
 			}
+			c.Conn.SetWriteDeadline(time.Now().Add(c.Hub.config.WriteWait))
+			closeMessage := []byte{}
+			if c.closeCode != 0 {
+				closeMessage = websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+			}
+			c.Conn.WriteMessage(websocket.CloseMessage, closeMessage)
+			return
 
-			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		case message := <-c.Send:
+			if err := c.writeMessage(message); err != nil {
 				return
 			}
 
+		case <-c.authChanged:
+			expiry.Reset(c.untilExpiry())
+
+		case <-expiry.C:
+			logging.Security(context.Background(), "WebSocket connection closed - access token expired", "connection_id", c.ID, "user_id", c.UserID.String())
+			c.closeUnauthorized("token_expired", "access token expired")
+			return
+
 		case <-ticker.C:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.Conn.SetWriteDeadline(time.Now().Add(c.Hub.config.WriteWait))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+			if err := c.recheckAuth(); err != nil {
+				logging.Security(context.Background(), "WebSocket connection closed - access token no longer valid", "connection_id", c.ID, "user_id", c.UserID.String(), "error", err)
+				c.closeUnauthorized("token_revoked", "access token is no longer valid")
+				return
+			}
 		}
 	}
 }
 
-// handleMessage processes incoming messages from the client
+// writeMessage writes a message from Send to the connection
+func (c *Client) writeMessage(message []byte) error {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.Hub.config.WriteWait))
+
+	// Only has an effect when the client negotiated permessage-deflate; small messages
+	// don't shrink enough to be worth the CPU
+	c.Conn.EnableWriteCompression(len(message) >= c.Hub.config.CompressionThreshold)
+	return c.Conn.WriteMessage(c.frameType(), message)
+}
+
+// handleMessage processes incoming messages from the client. A panic is reported to the client as
+// an error, and the connection stays open.
 func (c *Client) handleMessage(message []byte) {
-	var msg WSMessage
+	defer func() {
+		if r := recover(); r != nil {
+			c.Hub.recordPanic("message from client "+c.ID, r)
+			c.sendError("internal_error", "message could not be handled")
+		}
+	}()
+
+	var msg rawMessage
 	if err := json.Unmarshal(message, &msg); err != nil {
-		log.Printf("Failed to parse WebSocket message: %v", err)
+		slog.Info("Failed to parse WebSocket message", "connection_id", c.ID, "error", err)
+		c.sendError("invalid_message", "message is not valid JSON")
 		return
 	}
 
 	switch msg.Type {
 	case MessageTypePing:
 		// Respond with pong
-		pong := WSMessage{Type: MessageTypePong}
-		if data, err := json.Marshal(pong); err == nil {
-			select {
-			case c.Send <- data:
-			default:
+		c.SendMessage(WSMessage{Type: MessageTypePong})
+
+	case MessageTypeNoteEditingStarted, MessageTypeNoteEditingStopped:
+		var payload NoteEditingPayload
+		if len(msg.Payload) > 0 {
+			json.Unmarshal(msg.Payload, &payload)
+		}
+		noteID, err := uuid.Parse(payload.NoteID)
+		if err != nil {
+			c.sendError("invalid_message", "payload needs a valid noteId")
+			return
+		}
+		if msg.Type == MessageTypeNoteEditingStarted {
+			c.Hub.startEditing(c, noteID)
+		} else {
+			c.Hub.stopEditing(c, noteID, EditingStoppedByClient)
+		}
+
+	case MessageTypeNoteUpdate:
+		var payload NoteUpdateRequestPayload
+		if len(msg.Payload) > 0 {
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				c.sendError("invalid_message", "payload needs a note")
+				return
+			}
+		}
+		c.writeNote(msg.Type, payload.Ref, &payload.Note, payload.Note.ID)
+
+	case MessageTypeNoteDelete:
+		var payload NoteDeleteRequestPayload
+		if len(msg.Payload) > 0 {
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				c.sendError("invalid_message", "payload needs a noteId")
+				return
+			}
+		}
+		c.writeNote(msg.Type, payload.Ref, nil, payload.NoteID)
+
+	case MessageTypeSubscribe, MessageTypeUnsubscribe:
+		var payload SubscriptionPayload
+		if len(msg.Payload) > 0 {
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				c.sendError("invalid_message", "payload needs noteIds and contexts lists")
+				return
 			}
 		}
+		c.updateSubscription(msg.Type, payload)
+
+	case MessageTypeAuthRefresh:
+		var payload AuthRefreshPayload
+		if len(msg.Payload) > 0 {
+			json.Unmarshal(msg.Payload, &payload)
+		}
+		if payload.Token == "" {
+			c.sendError("invalid_message", "payload needs a token")
+			return
+		}
+		c.refreshAuth(payload.Token)
 
 	case MessageTypeSyncRequest:
 		// Client is requesting a sync
 		// This could trigger a full sync response, but for now we just acknowledge
 		// The client should use the REST API for initial sync
-		log.Printf("Sync request from client %s", c.ID)
+		slog.Debug("Sync request from WebSocket client", "connection_id", c.ID)
 
 	default:
-		log.Printf("Unknown message type: %s", msg.Type)
+		slog.Info("Unknown WebSocket message type", "connection_id", c.ID, "type", msg.Type)
+		c.sendError("unknown_type", "unknown message type")
+	}
+}
+
+// sendError reports a problem with a client's message, with a reconnect hint if the server is draining
+func (c *Client) sendError(code, message string) {
+	payload := ErrorPayload{Code: code, Message: message}
+	if c.Hub.IsDraining() {
+		hint := c.Hub.ReconnectHint()
+		payload.Reconnect = &hint
 	}
+	c.SendMessage(WSMessage{Type: MessageTypeError, Payload: payload})
 }
 
 // SendMessage sends a message to this client
@@ -137,6 +328,13 @@ func (c *Client) SendMessage(msg WSMessage) error {
 	if err != nil {
 		return err
 	}
+	data, ok, err := c.encode(data)
+	if err != nil || !ok {
+		return err
+	}
+	if c.isClosed() {
+		return nil // Closing, nobody will read it
+	}
 
 	select {
 	case c.Send <- data:
@@ -0,0 +1,137 @@
+package websocket
+
+import "time"
+
+// Config holds connection keepalive and size limits for WebSocket clients
+type Config struct {
+	// Time allowed to write a message to the peer
+	WriteWait time.Duration
+
+	// Time allowed to read the next pong message from the peer
+	PongWait time.Duration
+
+	// Send pings to peer with this period (must be less than PongWait)
+	PingPeriod time.Duration
+
+	// Maximum message size allowed from peer
+	MaxMessageSize int64
+
+	// Compression offers permessage-deflate; clients that accept it get messages of at least
+	// CompressionThreshold bytes compressed
+	Compression          bool
+	CompressionThreshold int
+
+	// Reconnect hints: clients are told to wait ReconnectDelay plus a random part of ReconnectJitter,
+	// and may be pointed at AlternateURL
+	ReconnectDelay  time.Duration
+	ReconnectJitter time.Duration
+	AlternateURL    string
+
+	// Oldest protocol version clients may connect with; raise it once old apps are gone
+	MinProtocolVersion int
+
+	// How long an editing indicator lasts unless the client sends note_editing_started again
+	EditingTimeout time.Duration
+
+	// Each user's last ResumeBufferSize events are kept for reconnecting clients to resume from, until
+	// the user has had no events for ResumeWindow
+	ResumeBufferSize int
+	ResumeWindow     time.Duration
+
+	// Each connection may send MessagesPerSecond messages and BytesPerSecond bytes on average, in
+	// bursts of up to MessageBurst messages and ByteBurst bytes
+	MessagesPerSecond int
+	MessageBurst      int
+	BytesPerSecond    int
+	ByteBurst         int
+
+	// A client whose send buffer stays full for this many messages in a row is closed with
+	// CloseSyncRequired
+	MaxDroppedMessages int
+
+	// Events broadcast for a user within CoalesceWindow are sent together; zero sends each at once
+	CoalesceWindow time.Duration
+}
+
+// DefaultConfig returns the keepalive settings used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		WriteWait:      10 * time.Second,
+		PongWait:       60 * time.Second,
+		PingPeriod:     54 * time.Second,
+		MaxMessageSize: 65536,
+
+		CompressionThreshold: 1024,
+		ReconnectDelay:       time.Second,
+		ReconnectJitter:      30 * time.Second,
+		EditingTimeout:       30 * time.Second,
+
+		ResumeBufferSize: 256,
+		ResumeWindow:     10 * time.Minute,
+
+		MessagesPerSecond: 20,
+		MessageBurst:      40,
+		BytesPerSecond:    131072,
+		ByteBurst:         262144,
+
+		MaxDroppedMessages: 16,
+
+		CoalesceWindow: 20 * time.Millisecond,
+	}
+}
+
+// normalize fills in zero values from the defaults and keeps PingPeriod below PongWait
+func (c Config) normalize() Config {
+	defaults := DefaultConfig()
+	if c.WriteWait <= 0 {
+		c.WriteWait = defaults.WriteWait
+	}
+	if c.PongWait <= 0 {
+		c.PongWait = defaults.PongWait
+	}
+	if c.PingPeriod <= 0 || c.PingPeriod >= c.PongWait {
+		c.PingPeriod = (c.PongWait * 9) / 10
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = defaults.MaxMessageSize
+	}
+	if c.CompressionThreshold < 0 {
+		c.CompressionThreshold = 0
+	}
+	if c.ReconnectDelay < 0 {
+		c.ReconnectDelay = 0
+	}
+	if c.ReconnectJitter < 0 {
+		c.ReconnectJitter = 0
+	}
+	if c.EditingTimeout <= 0 {
+		c.EditingTimeout = defaults.EditingTimeout
+	}
+	if c.ResumeBufferSize <= 0 {
+		c.ResumeBufferSize = defaults.ResumeBufferSize
+	}
+	if c.ResumeWindow <= 0 {
+		c.ResumeWindow = defaults.ResumeWindow
+	}
+	if c.MessagesPerSecond <= 0 {
+		c.MessagesPerSecond = defaults.MessagesPerSecond
+	}
+	if c.MessageBurst < c.MessagesPerSecond {
+		c.MessageBurst = max(defaults.MessageBurst, c.MessagesPerSecond)
+	}
+	if c.BytesPerSecond <= 0 {
+		c.BytesPerSecond = defaults.BytesPerSecond
+	}
+	// A message as large as MaxMessageSize must always be able to get through eventually
+	if c.ByteBurst <= 0 {
+		c.ByteBurst = defaults.ByteBurst
+	}
+	c.ByteBurst = max(c.ByteBurst, c.BytesPerSecond, int(c.MaxMessageSize))
+	if c.MaxDroppedMessages <= 0 {
+		c.MaxDroppedMessages = defaults.MaxDroppedMessages
+	}
+	if c.CoalesceWindow < 0 {
+		c.CoalesceWindow = 0
+	}
+	return c
+}
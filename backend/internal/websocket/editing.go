@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Why an editing indicator went away, sent as reason in note_editing_stopped
+const (
+	EditingStoppedByClient = "stopped"      // the client said it stopped
+	EditingStoppedTimeout  = "timeout"      // the client didn't renew it in time
+	EditingStoppedClosed   = "disconnected" // the connection closed
+)
+
+const (
+	// maxEditingPerConnection caps the notes one connection can be editing at once
+	maxEditingPerConnection = 16
+
+	// noteAudienceTimeout bounds the lookup of who can see a note
+	noteAudienceTimeout = 5 * time.Second
+)
+
+// NoteAudienceFunc returns the users who can see a note, including userID, or an error if userID
+// can't see it
+type NoteAudienceFunc func(ctx context.Context, userID, noteID uuid.UUID) ([]uuid.UUID, error)
+
+// NoteEditingPayload is sent by a client that started or stopped editing a note, and relayed to the
+// user's other connections and the note's collaborators with the editor filled in
+type NoteEditingPayload struct {
+	NoteID       string `json:"noteId"`
+	UserID       string `json:"userId,omitempty"`
+	ConnectionID string `json:"connectionId,omitempty"`
+	DeviceName   string `json:"deviceName,omitempty"`
+	ExpiresAt    string `json:"expiresAt,omitempty"` // started: when the indicator lapses unless renewed
+	Reason       string `json:"reason,omitempty"`    // stopped: stopped, timeout or disconnected
+}
+
+type editingKey struct {
+	connID string
+	noteID uuid.UUID
+}
+
+type editingState struct {
+	client    *Client
+	audience  []uuid.UUID
+	expiresAt time.Time
+}
+
+// SetNoteAudience sets how the hub finds who to relay editing indicators to. Without it they only
+// reach the editor's own connections.
+func (h *Hub) SetNoteAudience(fn NoteAudienceFunc) {
+	h.editingMu.Lock()
+	defer h.editingMu.Unlock()
+	h.noteAudience = fn
+}
+
+// startEditing records that a client is editing a note, or renews the indicator, and relays it.
+// Clients that keep editing send note_editing_started again before EditingTimeout runs out.
+func (h *Hub) startEditing(client *Client, noteID uuid.UUID) {
+	key := editingKey{connID: client.ID, noteID: noteID}
+	expiresAt := time.Now().Add(h.config.EditingTimeout)
+
+	h.editingMu.Lock()
+	state, renewing := h.editing[key]
+	if renewing {
+		state.expiresAt = expiresAt
+	}
+	audienceFn := h.noteAudience
+	editingCount := 0
+	for k := range h.editing {
+		if k.connID == client.ID {
+			editingCount++
+		}
+	}
+	h.editingMu.Unlock()
+
+	if !renewing {
+		if editingCount >= maxEditingPerConnection {
+			client.sendError("too_many_edits", "stop editing another note first")
+			return
+		}
+
+		audience := []uuid.UUID{client.UserID}
+		if audienceFn != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), noteAudienceTimeout)
+			var err error
+			audience, err = audienceFn(ctx, client.UserID, noteID)
+			cancel()
+			if err != nil {
+				// Unknown notes and notes the user can't see are reported the same way
+				client.sendError("note_not_found", "note not found")
+				return
+			}
+		}
+
+		state = &editingState{client: client, audience: audience, expiresAt: expiresAt}
+		h.editingMu.Lock()
+		h.editing[key] = state
+		h.editingMu.Unlock()
+	}
+
+	h.relayEditing(state, MessageTypeNoteEditingStarted, NoteEditingPayload{
+		NoteID:    noteID.String(),
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// stopEditing removes a client's indicator for a note and relays that it's gone
+func (h *Hub) stopEditing(client *Client, noteID uuid.UUID, reason string) {
+	key := editingKey{connID: client.ID, noteID: noteID}
+
+	h.editingMu.Lock()
+	state, ok := h.editing[key]
+	delete(h.editing, key)
+	h.editingMu.Unlock()
+
+	if ok {
+		h.relayEditing(state, MessageTypeNoteEditingStopped, NoteEditingPayload{NoteID: noteID.String(), Reason: reason})
+	}
+}
+
+// stopAllEditing removes the indicators of a closed connection
+func (h *Hub) stopAllEditing(client *Client) {
+	h.editingMu.Lock()
+	var noteIDs []uuid.UUID
+	for key := range h.editing {
+		if key.connID == client.ID {
+			noteIDs = append(noteIDs, key.noteID)
+		}
+	}
+	h.editingMu.Unlock()
+
+	for _, noteID := range noteIDs {
+		h.stopEditing(client, noteID, EditingStoppedClosed)
+	}
+}
+
+// expireEditing removes indicators their clients stopped renewing, so a client that crashed or lost
+// its connection without closing it doesn't seem to be editing forever
+func (h *Hub) expireEditing(now time.Time) {
+	h.editingMu.Lock()
+	var expired []editingKey
+	for key, state := range h.editing {
+		if now.After(state.expiresAt) {
+			expired = append(expired, key)
+		}
+	}
+	states := make([]*editingState, len(expired))
+	for i, key := range expired {
+		states[i] = h.editing[key]
+		delete(h.editing, key)
+	}
+	h.editingMu.Unlock()
+
+	for i, key := range expired {
+		h.relayEditing(states[i], MessageTypeNoteEditingStopped, NoteEditingPayload{NoteID: key.noteID.String(), Reason: EditingStoppedTimeout})
+	}
+}
+
+// relayEditing sends an editing message to everyone who can see the note except the editing
+// connection itself. Nothing is sent while draining.
+func (h *Hub) relayEditing(state *editingState, msgType MessageType, payload NoteEditingPayload) {
+	if h.IsDraining() {
+		return
+	}
+	payload.UserID = state.client.UserID.String()
+	payload.ConnectionID = state.client.ID
+	payload.DeviceName = state.client.DeviceName
+
+	data, err := json.Marshal(WSMessage{Type: msgType, Payload: payload})
+	if err != nil {
+		slog.Warn("Failed to encode editing message", "error", err)
+		return
+	}
+	for _, userID := range state.audience {
+		h.BroadcastToUser(userID, data, state.client.ID)
+	}
+}
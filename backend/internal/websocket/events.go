@@ -0,0 +1,337 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MinBatchProtocolVersion is the oldest protocol version that receives several events in one
+// events frame; older clients get them one message at a time
+const MinBatchProtocolVersion = 10
+
+// EventsPayload carries several events, in the order they happened, each a complete message as it
+// would otherwise have been sent on its own
+type EventsPayload struct {
+	Events []json.RawMessage `json:"events"`
+}
+
+// maxPendingEvents is how many events may wait for the coalescing window before they are sent
+// early
+const maxPendingEvents = 100
+
+// event is one encoded broadcast, opened up once for numbering, filtering and coalescing
+type event struct {
+	data   []byte
+	msg    rawMessage
+	parsed bool
+	target eventTarget
+
+	// The event converted for older protocol versions, by version
+	converted map[int][]byte
+
+	// span is the broadcast that sent the event, which its delivery is linked to
+	span trace.SpanContext
+}
+
+func newEvent(data []byte) *event {
+	ev := &event{data: data}
+	ev.parsed = json.Unmarshal(data, &ev.msg) == nil
+	if ev.parsed {
+		ev.target = targetOf(&ev.msg)
+	}
+	return ev
+}
+
+// coalesceKey identifies the events a later one supersedes: note changes to the same note, and
+// other events of the same type about the same note or ordering context. Events about nothing in
+// particular, such as notifications, are never coalesced.
+type coalesceKey struct {
+	class  MessageType
+	target eventTarget
+}
+
+func (ev *event) coalesceKey() (coalesceKey, bool) {
+	if !ev.parsed || ev.target == (eventTarget{}) {
+		return coalesceKey{}, false
+	}
+	switch ev.msg.Type {
+	case MessageTypeNoteCreated, MessageTypeNoteUpdated, MessageTypeNoteDeleted:
+		return coalesceKey{class: MessageTypeNoteCreated, target: ev.target}, true
+	}
+	return coalesceKey{class: ev.msg.Type, target: ev.target}, true
+}
+
+// forVersion returns the event as a client speaking version receives it, or nil if it doesn't
+func (ev *event) forVersion(version int) []byte {
+	if version >= ProtocolVersion {
+		return ev.data
+	}
+	if data, ok := ev.converted[version]; ok {
+		return data
+	}
+	data, keep, err := convertMessage(ev.data, version)
+	if err != nil {
+		slog.Warn("Failed to convert WebSocket message to protocol version", "version", version, "error", err)
+	}
+	if !keep {
+		data = nil
+	}
+	if ev.converted == nil {
+		ev.converted = make(map[int][]byte)
+	}
+	ev.converted[version] = data
+	return data
+}
+
+// pendingKey groups events waiting to be sent together
+type pendingKey struct {
+	userID  uuid.UUID
+	exclude string
+}
+
+// pendingEvents are a user's events waiting out the coalescing window
+type pendingEvents struct {
+	events []*event
+	timer  *time.Timer
+}
+
+// add queues an event, replacing an earlier one it supersedes. A note created then updated within
+// the window is still sent as created, with the latest note. Returns true if an event was replaced.
+func (p *pendingEvents) add(ev *event) bool {
+	key, ok := ev.coalesceKey()
+	if ok {
+		for i, prev := range p.events {
+			if prevKey, ok := prev.coalesceKey(); !ok || prevKey != key {
+				continue
+			}
+			if prev.msg.Type == MessageTypeNoteCreated && ev.msg.Type == MessageTypeNoteUpdated {
+				ev.msg.Type = MessageTypeNoteCreated
+				if data, err := json.Marshal(ev.msg); err == nil {
+					ev.data = data
+				} else {
+					ev.msg.Type = MessageTypeNoteUpdated
+				}
+			}
+			p.events = append(p.events[:i], p.events[i+1:]...)
+			p.events = append(p.events, ev)
+			return true
+		}
+	}
+	p.events = append(p.events, ev)
+	return false
+}
+
+// BroadcastEvent sends an event to all of a user's connections except excludeConnID, tagged with
+// the request that caused it. The message is marshalled once here; events for the same user within
+// the coalescing window are sent together, later changes replacing earlier ones to the same note,
+// and clients from MinBatchProtocolVersion get them in a single events frame. The broadcast is traced
+// as part of the request in ctx.
+func (h *Hub) BroadcastEvent(ctx context.Context, userID uuid.UUID, msgType MessageType, payload interface{}, excludeConnID, requestID string) {
+	ctx, span := tracing.Tracer().Start(ctx, "websocket.broadcast", trace.WithAttributes(
+		attribute.String("websocket.message_type", string(msgType)),
+		attribute.String("enduser.id", userID.String()),
+	))
+	defer span.End()
+
+	data, err := json.Marshal(WSMessage{Type: msgType, Payload: payload, RequestID: requestID})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to encode WebSocket event", "type", msgType, "error", err)
+		return
+	}
+	ev := newEvent(data)
+	ev.span = span.SpanContext()
+
+	if h.config.CoalesceWindow <= 0 {
+		h.deliverEvents(ctx, userID, []*event{ev}, excludeConnID)
+		h.publish(userID, ev.data, excludeConnID, ev.span)
+		return
+	}
+
+	key := pendingKey{userID: userID, exclude: excludeConnID}
+	h.pendingMu.Lock()
+	pending := h.pending[key]
+	if pending == nil {
+		pending = &pendingEvents{}
+		pending.timer = time.AfterFunc(h.config.CoalesceWindow, func() { h.flushEvents(key) })
+		h.pending[key] = pending
+	}
+	if pending.add(ev) {
+		h.coalesced.Add(1)
+	}
+	full := len(pending.events) >= maxPendingEvents
+	h.pendingMu.Unlock()
+
+	if full {
+		h.flushEvents(key)
+	}
+}
+
+// flushEvents sends the events waiting under key. flushMu is held from taking the events until
+// they're delivered, so a later batch can't overtake an earlier one.
+func (h *Hub) flushEvents(key pendingKey) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.recordPanic("event flush", r)
+		}
+	}()
+
+	h.flushMu.Lock()
+	defer h.flushMu.Unlock()
+
+	h.pendingMu.Lock()
+	pending := h.pending[key]
+	delete(h.pending, key)
+	h.pendingMu.Unlock()
+	if pending == nil {
+		return
+	}
+	pending.timer.Stop()
+
+	h.deliverEvents(context.Background(), key.userID, pending.events, key.exclude)
+	for _, ev := range pending.events {
+		h.publish(key.userID, ev.data, key.exclude, ev.span)
+	}
+}
+
+// FlushEvents sends every event still waiting out the coalescing window, for shutdown
+func (h *Hub) FlushEvents() {
+	h.pendingMu.Lock()
+	keys := make([]pendingKey, 0, len(h.pending))
+	for key := range h.pending {
+		keys = append(keys, key)
+	}
+	h.pendingMu.Unlock()
+
+	for _, key := range keys {
+		h.flushEvents(key)
+	}
+}
+
+// deliverEvents sends events to the user's connections on this instance. Resumable events are
+// numbered and kept for clients that reconnect; each client gets the events it's subscribed to,
+// together in an events frame when there are several and it speaks MinBatchProtocolVersion.
+// Delivery is traced under the span in ctx, if any, and linked to the broadcasts of events sent after
+// waiting out the coalescing window.
+func (h *Hub) deliverEvents(ctx context.Context, userID uuid.UUID, events []*event, excludeConnID string) {
+	var links []trace.Link
+	parent := trace.SpanContextFromContext(ctx)
+	for _, ev := range events {
+		if ev.span.IsValid() && !ev.span.Equal(parent) {
+			links = append(links, trace.Link{SpanContext: ev.span})
+		}
+	}
+	// Messages sent outside any trace, such as presence, aren't traced
+	span := trace.SpanFromContext(ctx)
+	if parent.IsValid() || len(links) > 0 {
+		_, span = tracing.Tracer().Start(ctx, "websocket.deliver", trace.WithLinks(links...), trace.WithAttributes(
+			attribute.String("enduser.id", userID.String()),
+			attribute.Int("websocket.events", len(events)),
+		))
+		defer span.End()
+	}
+
+	h.broadcasts.Add(int64(len(events)))
+
+	locked := false
+	for _, ev := range events {
+		if !ev.parsed || !resumableTypes[ev.msg.Type] {
+			continue
+		}
+		if !locked {
+			h.seqMu.Lock()
+			defer h.seqMu.Unlock()
+			locked = true
+		}
+		ev.data = h.sequence(userID, &ev.msg, ev.data)
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	// Frames for clients subscribed to everything, built once per version and encoding
+	var shared map[encoding][][]byte
+
+	clients := 0
+	for connID, client := range h.clients[userID] {
+		if connID == excludeConnID {
+			continue
+		}
+
+		var frames [][]byte
+		if client.wantsAll() {
+			key := encoding{version: client.Version, binary: client.Binary}
+			var cached bool
+			if frames, cached = shared[key]; !cached {
+				frames = h.framesFor(client, events)
+				if shared == nil {
+					shared = make(map[encoding][][]byte)
+				}
+				shared[key] = frames
+			}
+		} else {
+			frames = h.framesFor(client, events)
+		}
+
+		for _, data := range frames {
+			h.enqueue(client, data)
+		}
+		clients++
+	}
+	span.SetAttributes(attribute.Int("websocket.clients", clients))
+}
+
+// framesFor encodes the events a client is subscribed to as it receives them
+func (h *Hub) framesFor(client *Client, events []*event) [][]byte {
+	var messages [][]byte
+	for _, ev := range events {
+		if ev.parsed && !client.wantsAll() && !client.wants(ev.target) {
+			continue
+		}
+		if data := ev.forVersion(client.Version); data != nil {
+			messages = append(messages, data)
+		}
+	}
+
+	if len(messages) > 1 && client.Version >= MinBatchProtocolVersion {
+		batch, err := encodeBatch(messages, client.Version)
+		if err != nil {
+			slog.Warn("Failed to batch WebSocket events", "error", err)
+		} else {
+			messages = [][]byte{batch}
+		}
+	}
+
+	if !client.Binary {
+		return messages
+	}
+	frames := messages[:0]
+	for _, data := range messages {
+		packed, err := toMsgpack(data)
+		if err != nil {
+			slog.Warn("Failed to encode WebSocket message as MessagePack", "error", err)
+			continue
+		}
+		frames = append(frames, packed)
+	}
+	return frames
+}
+
+// encodeBatch wraps messages already in a client's protocol version in one events frame
+func encodeBatch(messages [][]byte, version int) ([]byte, error) {
+	events := make([]json.RawMessage, len(messages))
+	for i, data := range messages {
+		events[i] = data
+	}
+	return json.Marshal(WSMessage{
+		Type:    MessageTypeEvents,
+		Payload: EventsPayload{Events: events},
+		Version: version,
+	})
+}
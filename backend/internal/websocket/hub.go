@@ -1,9 +1,18 @@
 package websocket
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Hub maintains the set of active clients and broadcasts messages to them.
@@ -17,10 +26,78 @@ type Hub struct {
 	// Unregister requests from clients
 	unregister chan *Client
 
+	// Closed when Run returns, so requests made after the hub has stopped don't block
+	done chan struct{}
+
 	// Mutex for thread-safe access to clients map
 	mu sync.RWMutex
+
+	// Keepalive settings applied to every client
+	config Config
+
+	// Set by Drain: new connections are refused, and clients are told to wait until maintenanceUntil
+	draining         bool
+	maintenanceUntil time.Time
+
+	// Panics recovered in the event loop and client pumps, and restarts of the event loop
+	panics   atomic.Int64
+	restarts atomic.Int64
+
+	// Notes being edited, by connection, and who to tell about them
+	editing      map[editingKey]*editingState
+	editingMu    sync.Mutex
+	noteAudience NoteAudienceFunc
+
+	// Resumable events: epoch changes each time the server starts, seqs holds each user's last
+	// sequence number and resume their recent events. seqMu is held while numbering and delivering
+	// an event, so clients get events in sequence order.
+	epoch  string
+	seqs   map[uuid.UUID]int64
+	resume map[uuid.UUID]*resumeBuffer
+	seqMu  sync.Mutex
+
+	// Messages dropped because a client's send buffer was full, and clients closed for it
+	dropped         atomic.Int64
+	slowDisconnects atomic.Int64
+
+	// Clients closed for sending messages faster than the rate limit
+	rateLimitDisconnects atomic.Int64
+
+	// Broadcasts delivered on this instance, and the messages and bytes queued for clients, with
+	// samples of them taken each sweep for the rate over the last minute
+	broadcasts atomic.Int64
+	delivered  atomic.Int64
+	bytesSent  atomic.Int64
+	startedAt  time.Time
+	samples    []throughputSample
+	samplesMu  sync.Mutex
+
+	// Events waiting out the coalescing window, by user and excluded connection, and how many were
+	// replaced by a later event before being sent. flushMu keeps flushes in order.
+	pending   map[pendingKey]*pendingEvents
+	pendingMu sync.Mutex
+	flushMu   sync.Mutex
+	coalesced atomic.Int64
+
+	// Saves notes clients change over the socket; nil refuses such writes
+	noteWriter NoteWriter
+
+	// Maintenance mode, which clients are told about and which refuses writes while it's on
+	maintenance models.MaintenanceDTO
+
+	// Shares broadcasts with other server instances; nil when running alone
+	broker Broker
+	outbox chan outgoingBroadcast
 }
 
+const (
+	// loopRestartDelay is how long Run waits before restarting the event loop after it panicked
+	loopRestartDelay = time.Second
+
+	// sweepInterval is how often the hub expires editing indicators and idle resume buffers
+	sweepInterval = 5 * time.Second
+)
+
 // BroadcastMessage represents a message to broadcast to a user's connections
 type BroadcastMessage struct {
 	UserID    uuid.UUID
@@ -28,55 +105,138 @@ type BroadcastMessage struct {
 	ExcludeID string // Connection ID to exclude (the sender)
 }
 
-// NewHub creates a new Hub instance
-func NewHub() *Hub {
+// NewHub creates a new Hub instance; zero values in config fall back to DefaultConfig
+func NewHub(config Config) *Hub {
+	epoch := make([]byte, 8)
+	rand.Read(epoch)
+
 	return &Hub{
 		clients:    make(map[uuid.UUID]map[string]*Client),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		done:       make(chan struct{}),
+		config:     config.normalize(),
+		editing:    make(map[editingKey]*editingState),
+		epoch:      hex.EncodeToString(epoch),
+		seqs:       make(map[uuid.UUID]int64),
+		resume:     make(map[uuid.UUID]*resumeBuffer),
+		pending:    make(map[pendingKey]*pendingEvents),
+		startedAt:  time.Now(),
+	}
+}
+
+// Run starts the hub's main event loop, returning when ctx is cancelled. A panic handling one
+// request is logged and the loop carries on; should the loop itself die it is restarted, so
+// real-time sync never silently stops.
+func (h *Hub) Run(ctx context.Context) {
+	defer close(h.done)
+	if h.broker != nil {
+		go h.runBroker(ctx)
+	}
+	for !h.runLoop(ctx) {
+		h.restarts.Add(1)
+		slog.Error("WebSocket hub event loop died; restarting", "delay", loopRestartDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(loopRestartDelay):
+		}
 	}
 }
 
-// Run starts the hub's main event loop
-func (h *Hub) Run() {
+// runLoop handles register and unregister requests, returning true when ctx is cancelled and
+// false if it panics
+func (h *Hub) runLoop(ctx context.Context) (stopped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.recordPanic("hub event loop", r)
+		}
+	}()
+
+	sweep := time.NewTicker(sweepInterval)
+	defer sweep.Stop()
+
 	for {
 		select {
+		case <-ctx.Done():
+			return true
+		case now := <-sweep.C:
+			h.handle("editing sweep", nil, func(*Client) { h.expireEditing(now) })
+			h.handle("resume buffer sweep", nil, func(*Client) { h.expireResumeBuffers(now) })
+			h.handle("throughput sample", nil, func(*Client) { h.sampleThroughput(now) })
 		case client := <-h.register:
-			h.registerClient(client)
+			h.handle("register", client, h.registerClient)
 		case client := <-h.unregister:
-			h.unregisterClient(client)
+			h.handle("unregister", client, h.unregisterClient)
 		}
 	}
 }
 
+// handle runs one request, recovering from a panic so one bad client can't stop the loop
+func (h *Hub) handle(op string, client *Client, fn func(*Client)) {
+	defer func() {
+		if r := recover(); r != nil {
+			if client != nil {
+				op += " of client " + client.ID
+			}
+			h.recordPanic(op, r)
+		}
+	}()
+	fn(client)
+}
+
+// recordPanic logs a recovered panic with its stack and counts it
+func (h *Hub) recordPanic(where string, r any) {
+	h.panics.Add(1)
+	slog.Error("WebSocket panic", "where", where, "panic", r, "stack", string(debug.Stack()))
+}
+
 // Register adds a client to the hub
 func (h *Hub) Register(client *Client) {
-	h.register <- client
+	select {
+	case h.register <- client:
+	case <-h.done:
+	}
 }
 
 // Unregister removes a client from the hub
 func (h *Hub) Unregister(client *Client) {
-	h.unregister <- client
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
 }
 
+// registerClient adds a client and replays the events it asked to resume from. Both happen under
+// seqMu, so no event is missed or delivered ahead of the replay.
 func (h *Hub) registerClient(client *Client) {
+	h.seqMu.Lock()
 	h.mu.Lock()
-	defer h.mu.Unlock()
-
 	if h.clients[client.UserID] == nil {
 		h.clients[client.UserID] = make(map[string]*Client)
 	}
 	h.clients[client.UserID][client.ID] = client
+	h.mu.Unlock()
+	if client.ResumeFrom != nil {
+		h.replay(client)
+	}
+	h.seqMu.Unlock()
+
+	if maintenance := h.Maintenance(); maintenance.Enabled {
+		client.SendMessage(WSMessage{Type: MessageTypeMaintenance, Payload: maintenance})
+	}
+
+	h.broadcastPresence(client.UserID)
 }
 
 func (h *Hub) unregisterClient(client *Client) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
-
+	removed := false
 	if userClients, ok := h.clients[client.UserID]; ok {
 		if _, ok := userClients[client.ID]; ok {
 			delete(userClients, client.ID)
-			close(client.Send)
+			client.close()
+			removed = true
 
 			// Clean up empty user map
 			if len(userClients) == 0 {
@@ -84,27 +244,25 @@ func (h *Hub) unregisterClient(client *Client) {
 			}
 		}
 	}
+	h.mu.Unlock()
+
+	if removed {
+		h.stopAllEditing(client)
+		h.broadcastPresence(client.UserID)
+	}
 }
 
 // BroadcastToUser sends a message to all connections for a given user
-// optionally excluding a specific connection (e.g., the sender)
+// optionally excluding a specific connection (e.g., the sender), on this instance and, with a
+// broker, every other one
 func (h *Hub) BroadcastToUser(userID uuid.UUID, message []byte, excludeConnID string) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	h.deliver(context.Background(), userID, message, excludeConnID)
+	h.publish(userID, message, excludeConnID, trace.SpanContext{})
+}
 
-	if userClients, ok := h.clients[userID]; ok {
-		for connID, client := range userClients {
-			if connID == excludeConnID {
-				continue
-			}
-			select {
-			case client.Send <- message:
-			default:
-				// Client's send buffer is full, skip this message
-				// The client will reconnect and sync if needed
-			}
-		}
-	}
+// deliver sends a message to the user's connections on this instance
+func (h *Hub) deliver(ctx context.Context, userID uuid.UUID, message []byte, excludeConnID string) {
+	h.deliverEvents(ctx, userID, []*event{newEvent(message)}, excludeConnID)
 }
 
 // GetConnectionCount returns the number of active connections for a user
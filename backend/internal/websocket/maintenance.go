@@ -0,0 +1,28 @@
+package websocket
+
+import "github.com/hamishgilbert/notes-app/backend/internal/models"
+
+// Maintenance returns the maintenance mode as the hub last heard of it
+func (h *Hub) Maintenance() models.MaintenanceDTO {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.maintenance
+}
+
+// SetMaintenance tells every client on this instance that maintenance mode has changed, with a
+// "maintenance" message; clients connecting while it's on are told when they connect. Note writes
+// over the socket are refused while it's on.
+func (h *Hub) SetMaintenance(maintenance models.MaintenanceDTO) {
+	h.mu.Lock()
+	h.maintenance = maintenance
+	h.mu.Unlock()
+
+	// Sending under the lock keeps clients from being unregistered meanwhile
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, userClients := range h.clients {
+		for _, client := range userClients {
+			client.SendMessage(WSMessage{Type: MessageTypeMaintenance, Payload: maintenance})
+		}
+	}
+}
@@ -5,19 +5,63 @@ import "github.com/hamishgilbert/notes-app/backend/internal/models"
 type MessageType string
 
 const (
-	MessageTypeNoteCreated  MessageType = "note_created"
-	MessageTypeNoteUpdated  MessageType = "note_updated"
-	MessageTypeNoteDeleted  MessageType = "note_deleted"
-	MessageTypeSyncRequest  MessageType = "sync_request"
-	MessageTypeSyncResponse MessageType = "sync_response"
-	MessageTypePing         MessageType = "ping"
-	MessageTypePong         MessageType = "pong"
+	MessageTypeConnected          MessageType = "connected"
+	MessageTypeNoteCreated        MessageType = "note_created"
+	MessageTypeNoteUpdated        MessageType = "note_updated"
+	MessageTypeNoteDeleted        MessageType = "note_deleted"
+	MessageTypeLinkPreviews       MessageType = "link_previews_updated"
+	MessageTypeNotification       MessageType = "notification"
+	MessageTypeNoteOrder          MessageType = "note_order_updated"
+	MessageTypeNotePosition       MessageType = "note_position_updated"
+	MessageTypePresence           MessageType = "presence"
+	MessageTypeNoteEditingStarted MessageType = "note_editing_started"
+	MessageTypeNoteEditingStopped MessageType = "note_editing_stopped"
+	MessageTypeNoteUpdate         MessageType = "note_update"
+	MessageTypeNoteDelete         MessageType = "note_delete"
+	MessageTypeNoteAck            MessageType = "note_ack"
+	MessageTypeSubscribe          MessageType = "subscribe"
+	MessageTypeUnsubscribe        MessageType = "unsubscribe"
+	MessageTypeSubscriptions      MessageType = "subscriptions"
+	MessageTypeAuthRefresh        MessageType = "auth_refresh"
+	MessageTypeAuthRefreshed      MessageType = "auth_refreshed"
+	MessageTypeEvents             MessageType = "events"
+	MessageTypeResumed            MessageType = "resumed"
+	MessageTypeSyncRequest        MessageType = "sync_request"
+	MessageTypeSyncResponse       MessageType = "sync_response"
+	MessageTypePing               MessageType = "ping"
+	MessageTypePong               MessageType = "pong"
+	MessageTypeReconnect          MessageType = "reconnect"
+	MessageTypeMaintenance        MessageType = "maintenance" // payload is models.MaintenanceDTO
+	MessageTypeError              MessageType = "error"
 )
 
 // WSMessage is the envelope for all WebSocket messages
 type WSMessage struct {
 	Type    MessageType `json:"type"`
 	Payload interface{} `json:"payload,omitempty"`
+
+	// RequestID is the ID of the HTTP request that caused a broadcast, for tracing
+	RequestID string `json:"requestId,omitempty"`
+
+	// Version is the protocol version of the message; set to ProtocolVersion when encoding
+	Version int `json:"v,omitempty"`
+
+	// Seq numbers the user's resumable events; set by the hub when broadcasting
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// ConnectedPayload is sent to a client right after it connects so it can
+// identify itself on REST requests via the X-Connection-ID header
+type ConnectedPayload struct {
+	ConnectionID    string `json:"connectionId"`
+	ProtocolVersion int    `json:"protocolVersion"` // the version the server will speak on this connection
+
+	// Where the user's events stand, to resume from after reconnecting
+	Epoch string `json:"epoch"`
+	Seq   int64  `json:"seq"`
+
+	// Encoding is "msgpack" when the connection uses binary MessagePack frames
+	Encoding string `json:"encoding,omitempty"`
 }
 
 // NoteChangePayload is sent when a note is created or updated
@@ -30,6 +74,32 @@ type NoteDeletePayload struct {
 	NoteID string `json:"noteId"`
 }
 
+// LinkPreviewsPayload is sent when a note's link previews have been fetched
+type LinkPreviewsPayload struct {
+	NoteID       string                  `json:"noteId"`
+	LinkPreviews []models.LinkPreviewDTO `json:"linkPreviews"`
+}
+
+// NotificationPayload is sent when a user receives a new notification, such as a mention
+type NotificationPayload struct {
+	Notification models.NotificationDTO `json:"notification"`
+}
+
+// NoteOrderPayload is sent when the manual order of an ordering context changes
+type NoteOrderPayload struct {
+	Order models.NoteOrderDTO `json:"order"`
+}
+
+// NotePositionPayload is sent when the reading position in a note changes on another device
+type NotePositionPayload struct {
+	Position models.NotePositionDTO `json:"position"`
+}
+
+// PresencePayload is sent when one of the user's devices connects or disconnects
+type PresencePayload struct {
+	Presence models.PresenceDTO `json:"presence"`
+}
+
 // SyncRequestPayload is sent by clients to request a sync
 type SyncRequestPayload struct {
 	Since string `json:"since,omitempty"`
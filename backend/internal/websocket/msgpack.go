@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// EncodingMsgpack is the ?encoding= value that switches a connection to binary frames carrying
+// MessagePack, from MinBinaryProtocolVersion on. The envelope and payloads have the same fields as
+// in JSON.
+const EncodingMsgpack = "msgpack"
+
+// MinBinaryProtocolVersion is the oldest protocol version that can ask for MessagePack frames
+const MinBinaryProtocolVersion = 9
+
+var (
+	jsonHandle    = &codec.JsonHandle{}
+	msgpackHandle = newMsgpackHandle()
+)
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true    // use the str and bin types of the current spec
+	h.RawToString = true // and decode str as strings, not bytes
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+func init() {
+	jsonHandle.MapType = reflect.TypeOf(map[string]interface{}(nil))
+}
+
+// toMsgpack re-encodes a JSON message as MessagePack
+func toMsgpack(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := codec.NewDecoderBytes(data, jsonHandle).Decode(&value); err != nil {
+		return nil, err
+	}
+	var out []byte
+	err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(value)
+	return out, err
+}
+
+// fromMsgpack re-encodes a MessagePack message from a client as JSON
+func fromMsgpack(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// encoding identifies how a client receives messages
+type encoding struct {
+	version int
+	binary  bool
+}
+
+// encode prepares a current-version JSON message for the client: converted to its protocol version
+// and, for binary clients, to MessagePack. The second result is false if the client doesn't receive
+// messages of this type.
+func (c *Client) encode(data []byte) ([]byte, bool, error) {
+	data, keep, err := convertMessage(data, c.Version)
+	if err != nil || !keep || !c.Binary {
+		return data, keep, err
+	}
+	if data, err = toMsgpack(data); err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// frameType is the WebSocket frame type the client's messages are sent in
+func (c *Client) frameType() int {
+	if c.Binary {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
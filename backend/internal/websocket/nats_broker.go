@@ -0,0 +1,28 @@
+package websocket
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/nats"
+)
+
+// NATSBroker shares broadcasts through NATS, publishing each on a subject per user,
+// <prefix>.<userID>, and subscribing to them all with <prefix>.*
+type NATSBroker struct {
+	client *nats.Client
+	prefix string
+}
+
+func NewNATSBroker(client *nats.Client, prefix string) *NATSBroker {
+	return &NATSBroker{client: client, prefix: prefix}
+}
+
+func (b *NATSBroker) Publish(ctx context.Context, userID uuid.UUID, data []byte) error {
+	return b.client.Publish(ctx, b.prefix+"."+userID.String(), data)
+}
+
+func (b *NATSBroker) Run(ctx context.Context, receive func(data []byte)) {
+	b.client.Subscribe(b.prefix+".*", func(_ string, data []byte) { receive(data) })
+	b.client.Run(ctx)
+}
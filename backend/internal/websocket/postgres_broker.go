@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// notifyPartSize is how many bytes of a broadcast go in each NOTIFY when it's too large for one.
+	// Postgres refuses payloads of 8000 bytes or more, and parts grow by a third when encoded.
+	notifyPartSize = 5000
+
+	// maxPartialBroadcasts is how many broadcasts may be part received before the oldest are dropped
+	maxPartialBroadcasts = 16
+
+	// maxNotifyParts is the most parts a broadcast may be sent in, 10MB of it, so a bad part count
+	// can't make receivers allocate without bound
+	maxNotifyParts = 2000
+
+	// listenRetryDelay is the longest wait between attempts to listen again after losing the connection
+	listenRetryDelay = 30 * time.Second
+)
+
+// PostgresBroker shares broadcasts through Postgres LISTEN/NOTIFY on one channel, so instances that
+// share a database need nothing else to run together. Broadcasts too large for one notification
+// are sent as parts in one transaction, which Postgres delivers together and in order. Listening
+// holds one connection from the pool.
+type PostgresBroker struct {
+	pool    *pgxpool.Pool
+	channel string
+}
+
+func NewPostgresBroker(pool *pgxpool.Pool, channel string) *PostgresBroker {
+	return &PostgresBroker{pool: pool, channel: channel}
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, _ uuid.UUID, data []byte) error {
+	if len(data) <= notifyPartSize {
+		_, err := b.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, b.channel, string(data))
+		return err
+	}
+
+	if len(data) > maxNotifyParts*notifyPartSize {
+		return fmt.Errorf("broadcast of %d bytes is too large to share", len(data))
+	}
+	batch := &pgx.Batch{}
+	for _, payload := range notifyParts(uuid.NewString(), data) {
+		batch.Queue(`SELECT pg_notify($1, $2)`, b.channel, payload)
+	}
+
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// notifyParts splits a broadcast into notification payloads "<id>:<index>:<count>:<base64>", which
+// can't be mistaken for a whole broadcast
+func notifyParts(id string, data []byte) []string {
+	count := (len(data) + notifyPartSize - 1) / notifyPartSize
+	payloads := make([]string, count)
+	for i := range payloads {
+		part := data[i*notifyPartSize : min((i+1)*notifyPartSize, len(data))]
+		payloads[i] = fmt.Sprintf("%s:%d:%d:%s", id, i, count, base64.StdEncoding.EncodeToString(part))
+	}
+	return payloads
+}
+
+func (b *PostgresBroker) Run(ctx context.Context, receive func(data []byte)) {
+	delay := time.Second
+	for {
+		started := time.Now()
+		err := b.listen(ctx, receive)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > listenRetryDelay {
+			delay = time.Second
+		}
+		slog.Warn("Lost Postgres broadcast listener, retrying", "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, listenRetryDelay)
+	}
+}
+
+// listen holds a connection listening on the channel and passes on what arrives, until the
+// connection fails or ctx is cancelled
+func (b *PostgresBroker) listen(ctx context.Context, receive func(data []byte)) error {
+	conn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection is left listening, so it isn't returned to the pool
+	defer conn.Hijack().Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		return err
+	}
+
+	partial := newPartialBroadcasts()
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if data, ok := partial.add(notification.Payload); ok {
+			receive(data)
+		}
+	}
+}
+
+// partialBroadcasts puts broadcasts sent in parts back together
+type partialBroadcasts struct {
+	parts map[string][][]byte
+	order []string
+}
+
+func newPartialBroadcasts() *partialBroadcasts {
+	return &partialBroadcasts{parts: make(map[string][][]byte)}
+}
+
+// add takes a notification, returning a broadcast once all of it has arrived
+func (p *partialBroadcasts) add(payload string) ([]byte, bool) {
+	if strings.HasPrefix(payload, "{") {
+		return []byte(payload), true
+	}
+
+	fields := strings.SplitN(payload, ":", 4)
+	if len(fields) != 4 {
+		slog.Warn("Ignoring malformed Postgres broadcast notification")
+		return nil, false
+	}
+	id := fields[0]
+	index, err1 := strconv.Atoi(fields[1])
+	count, err2 := strconv.Atoi(fields[2])
+	part, err3 := base64.StdEncoding.DecodeString(fields[3])
+	if err1 != nil || err2 != nil || err3 != nil || count < 1 || count > maxNotifyParts || index < 0 || index >= count {
+		slog.Warn("Ignoring malformed Postgres broadcast notification")
+		return nil, false
+	}
+
+	parts, ok := p.parts[id]
+	if !ok {
+		if len(p.order) >= maxPartialBroadcasts {
+			delete(p.parts, p.order[0])
+			p.order = p.order[1:]
+		}
+		parts = make([][]byte, count)
+		p.parts[id] = parts
+		p.order = append(p.order, id)
+	}
+	if len(parts) != count {
+		return nil, false
+	}
+	parts[index] = part
+
+	var data []byte
+	for _, part := range parts {
+		if part == nil {
+			return nil, false
+		}
+		data = append(data, part...)
+	}
+	p.forget(id)
+	return data, true
+}
+
+func (p *partialBroadcasts) forget(id string) {
+	delete(p.parts, id)
+	for i, other := range p.order {
+		if other == id {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+}
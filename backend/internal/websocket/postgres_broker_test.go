@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestPartialBroadcastsAdd(t *testing.T) {
+	// Three parts, the last one short, with bytes that differ by position so misordering shows
+	data := make([]byte, 2*notifyPartSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	parts := notifyParts("a", data)
+	other := []byte(`{"type":"other"}`)
+	otherParts := notifyParts("b", bytes.Repeat(other, notifyPartSize/len(other)+1))
+	whole := `{"type":"note_updated"}`
+
+	// evicting starts enough other broadcasts that the first is dropped
+	var evicting []string
+	for i := range maxPartialBroadcasts {
+		evicting = append(evicting, notifyParts(fmt.Sprintf("e%d", i), data)[0])
+	}
+
+	tests := []struct {
+		name     string
+		payloads []string
+		want     [][]byte // broadcasts returned, in order
+	}{
+		{name: "whole broadcast", payloads: []string{whole}, want: [][]byte{[]byte(whole)}},
+		{name: "parts in order", payloads: parts, want: [][]byte{data}},
+		{name: "parts reversed", payloads: []string{parts[2], parts[1], parts[0]}, want: [][]byte{data}},
+		{name: "parts shuffled", payloads: []string{parts[1], parts[2], parts[0]}, want: [][]byte{data}},
+		{name: "part duplicated", payloads: []string{parts[0], parts[0], parts[1], parts[1], parts[2]}, want: [][]byte{data}},
+		{name: "part repeated after the broadcast completed", payloads: append(parts, parts[1]), want: [][]byte{data}},
+		{name: "part missing", payloads: []string{parts[0], parts[2]}},
+		{name: "whole broadcast between parts", payloads: []string{parts[0], whole, parts[1], parts[2]}, want: [][]byte{[]byte(whole), data}},
+		{name: "broadcasts interleaved",
+			payloads: []string{parts[0], otherParts[1], parts[1], otherParts[0], parts[2]},
+			want:     [][]byte{bytes.Repeat(other, notifyPartSize/len(other)+1), data}},
+		{name: "oldest dropped after too many others", payloads: append(append([]string{parts[0]}, evicting...), parts[1], parts[2])},
+
+		{name: "too few fields", payloads: []string{"a:0:1"}},
+		{name: "index not a number", payloads: []string{"a:x:1:e30="}},
+		{name: "count not a number", payloads: []string{"a:0:x:e30="}},
+		{name: "negative index", payloads: []string{"a:-1:1:e30="}},
+		{name: "index past count", payloads: []string{"a:1:1:e30="}},
+		{name: "zero count", payloads: []string{"a:0:0:"}},
+		{name: "count too large", payloads: []string{fmt.Sprintf("a:0:%d:e30=", maxNotifyParts+1)}},
+		{name: "not base64", payloads: []string{"a:0:1:{}"}},
+		{name: "count differs from earlier parts", payloads: []string{parts[0], "a:1:2:e30=", parts[1], parts[2]}, want: [][]byte{data}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partial := newPartialBroadcasts()
+			var got [][]byte
+			for _, payload := range tt.payloads {
+				if data, ok := partial.add(payload); ok {
+					got = append(got, data)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d broadcasts, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("broadcast %d: got %d bytes, not the %d sent", i, len(got[i]), len(tt.want[i]))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+// Presence lists a user's open connections and how many devices they come from, oldest first
+func (h *Hub) Presence(userID uuid.UUID) models.PresenceDTO {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	presence := models.PresenceDTO{Connections: []models.PresenceConnectionDTO{}}
+	devices := make(map[string]bool)
+	clients := make([]*Client, 0, len(h.clients[userID]))
+	for _, client := range h.clients[userID] {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ConnectedAt.Before(clients[j].ConnectedAt) })
+
+	for _, client := range clients {
+		presence.Connections = append(presence.Connections, models.PresenceConnectionDTO{
+			ConnectionID: client.ID,
+			DeviceID:     client.DeviceID,
+			DeviceName:   client.DeviceName,
+			ConnectedAt:  client.ConnectedAt.Format(time.RFC3339),
+		})
+		// Connections that don't name their device are counted as a device each
+		if client.DeviceID == "" {
+			presence.DeviceCount++
+		} else if !devices[client.DeviceID] {
+			devices[client.DeviceID] = true
+			presence.DeviceCount++
+		}
+	}
+	return presence
+}
+
+// broadcastPresence tells all of a user's connections which of their devices are connected. Nothing
+// is sent while draining, when every connection is about to close anyway.
+func (h *Hub) broadcastPresence(userID uuid.UUID) {
+	if h.IsDraining() {
+		return
+	}
+	presence := h.Presence(userID)
+	if len(presence.Connections) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(WSMessage{
+		Type:    MessageTypePresence,
+		Payload: PresencePayload{Presence: presence},
+	})
+	if err != nil {
+		slog.Warn("Failed to encode presence message", "error", err)
+		return
+	}
+	h.BroadcastToUser(userID, data, "")
+}
@@ -0,0 +1,228 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Protocol versions. Clients ask for a version with the "v" query parameter when connecting (none
+// means 1) and the server sends every message in that version, converting down from the current one.
+//
+//	1: the original envelope, without "v"
+//	2: adds "v" to the envelope, the reconnect and error messages, protocolVersion in connected, and
+//	   contentHash on notes
+//	3: adds the presence message
+//	4: adds the note_editing_started and note_editing_stopped messages
+//	5: adds "seq" to the envelope of resumable events, epoch and seq in connected, and the resumed
+//	   message
+//	6: adds the auth_refreshed message
+//	7: adds the subscriptions message
+//	8: adds the note_ack message
+//	9: adds MessagePack binary frames with ?encoding=msgpack, and encoding in connected
+//	10: adds the events message, carrying several events in one frame
+//	11: adds the maintenance message
+//	12: note_update requires the version of the note being saved over
+const (
+	ProtocolVersion    = 12
+	MinProtocolVersion = 1
+)
+
+var ErrUnsupportedProtocolVersion = errors.New("unsupported WebSocket protocol version")
+
+// NegotiateVersion picks the version to speak with a client that asked for requested. Clients newer
+// than the server get the current version; versions below the hub's minimum are refused.
+func (h *Hub) NegotiateVersion(requested string) (int, error) {
+	if requested == "" {
+		requested = "1"
+	}
+	version, err := strconv.Atoi(requested)
+	if err != nil || version < max(h.config.MinProtocolVersion, MinProtocolVersion) {
+		return 0, ErrUnsupportedProtocolVersion
+	}
+	return min(version, ProtocolVersion), nil
+}
+
+// MarshalJSON stamps messages with the current protocol version
+func (m WSMessage) MarshalJSON() ([]byte, error) {
+	type plain WSMessage
+	if m.Version == 0 {
+		m.Version = ProtocolVersion
+	}
+	return json.Marshal(plain(m))
+}
+
+// rawMessage is an encoded message opened up for conversion
+type rawMessage struct {
+	Type      MessageType     `json:"type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+	Version   int             `json:"v,omitempty"`
+	Seq       int64           `json:"seq,omitempty"`
+}
+
+// downConverters[v] rewrites a version v+1 message as version v, or returns false to drop it
+var downConverters = map[int]func(msg *rawMessage) (bool, error){
+	1:  toVersion1,
+	2:  toVersion2,
+	3:  toVersion3,
+	4:  toVersion4,
+	5:  toVersion5,
+	6:  toVersion6,
+	7:  toVersion7,
+	8:  toVersion8,
+	9:  toVersion9,
+	10: toVersion10,
+	11: toVersion11,
+}
+
+// convertMessage re-encodes a current-version message for a client speaking version. The second result
+// is false if the message has no equivalent in that version and shouldn't be sent.
+func convertMessage(data []byte, version int) ([]byte, bool, error) {
+	if version >= ProtocolVersion {
+		return data, true, nil
+	}
+
+	var msg rawMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, false, err
+	}
+	for v := ProtocolVersion - 1; v >= version; v-- {
+		keep, err := downConverters[v](&msg)
+		if err != nil || !keep {
+			return nil, false, err
+		}
+	}
+
+	if version == 1 {
+		msg.Version = 0
+	} else {
+		msg.Version = version
+	}
+	converted, err := json.Marshal(msg)
+	return converted, err == nil, err
+}
+
+// Version 12 changed what clients send, not what they receive
+func toVersion11(msg *rawMessage) (bool, error) {
+	return true, nil
+}
+
+func toVersion10(msg *rawMessage) (bool, error) {
+	if msg.Type == MessageTypeMaintenance {
+		return false, nil
+	}
+	return true, nil
+}
+
+func toVersion9(msg *rawMessage) (bool, error) {
+	if msg.Type == MessageTypeEvents {
+		return false, nil
+	}
+	return true, nil
+}
+
+func toVersion8(msg *rawMessage) (bool, error) {
+	if msg.Type == MessageTypeConnected {
+		return true, removePayloadField(msg, "encoding")
+	}
+	return true, nil
+}
+
+func toVersion7(msg *rawMessage) (bool, error) {
+	if msg.Type == MessageTypeNoteAck {
+		return false, nil
+	}
+	return true, nil
+}
+
+func toVersion6(msg *rawMessage) (bool, error) {
+	if msg.Type == MessageTypeSubscriptions {
+		return false, nil
+	}
+	return true, nil
+}
+
+func toVersion5(msg *rawMessage) (bool, error) {
+	if msg.Type == MessageTypeAuthRefreshed {
+		return false, nil
+	}
+	return true, nil
+}
+
+func toVersion4(msg *rawMessage) (bool, error) {
+	msg.Seq = 0
+	switch msg.Type {
+	case MessageTypeResumed:
+		return false, nil
+	case MessageTypeConnected:
+		if err := removePayloadField(msg, "epoch"); err != nil {
+			return false, err
+		}
+		return true, removePayloadField(msg, "seq")
+	}
+	return true, nil
+}
+
+func toVersion3(msg *rawMessage) (bool, error) {
+	switch msg.Type {
+	case MessageTypeNoteEditingStarted, MessageTypeNoteEditingStopped:
+		return false, nil
+	}
+	return true, nil
+}
+
+func toVersion2(msg *rawMessage) (bool, error) {
+	if msg.Type == MessageTypePresence {
+		return false, nil
+	}
+	return true, nil
+}
+
+func toVersion1(msg *rawMessage) (bool, error) {
+	switch msg.Type {
+	case MessageTypeReconnect, MessageTypeError:
+		// Version 1 clients only know to reconnect with their own backoff
+		return false, nil
+	case MessageTypeConnected:
+		return true, removePayloadField(msg, "protocolVersion")
+	case MessageTypeNoteCreated, MessageTypeNoteUpdated:
+		return true, removePayloadField(msg, "note", "contentHash")
+	}
+	return true, nil
+}
+
+// removePayloadField deletes a field from the payload, following path through nested objects
+func removePayloadField(msg *rawMessage, path ...string) error {
+	if len(msg.Payload) == 0 {
+		return nil
+	}
+	payload, err := removeField(msg.Payload, path)
+	if err != nil {
+		return err
+	}
+	msg.Payload = payload
+	return nil
+}
+
+func removeField(data json.RawMessage, path []string) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if len(path) == 1 {
+		delete(fields, path[0])
+	} else if nested, ok := fields[path[0]]; ok {
+		updated, err := removeField(nested, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		fields[path[0]] = updated
+	}
+	return json.Marshal(fields)
+}
+
+// CompressionEnabled reports whether connections should be offered permessage-deflate
+func (h *Hub) CompressionEnabled() bool {
+	return h.config.Compression
+}
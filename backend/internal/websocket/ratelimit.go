@@ -0,0 +1,85 @@
+package websocket
+
+import "time"
+
+const (
+	// rateViolationWindow is how long after a rate_limited warning further excess messages count
+	// towards disconnecting the client
+	rateViolationWindow = 10 * time.Second
+
+	// maxRateViolations is how many messages over the limit a client may send within
+	// rateViolationWindow of its warning before it is disconnected
+	maxRateViolations = 50
+)
+
+// rateDecision is what ReadPump does with a message from a client
+type rateDecision int
+
+const (
+	rateAllowed    rateDecision = iota // handle it
+	rateWarn                           // drop it and warn the client
+	rateDropped                        // drop it; the client has been warned
+	rateDisconnect                     // the client kept flooding after the warning
+)
+
+// tokenBucket allows rate units per second on average, in bursts of up to burst
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int) tokenBucket {
+	return tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst)}
+}
+
+// take removes n tokens if there are that many after refilling for the time since the last call
+func (b *tokenBucket) take(n float64, now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+b.rate*now.Sub(b.last).Seconds())
+	}
+	b.last = now
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// messageLimiter limits the messages, and bytes, one connection may send. Only ReadPump uses it.
+type messageLimiter struct {
+	messages   tokenBucket
+	bytes      tokenBucket
+	warnedAt   time.Time
+	violations int
+}
+
+func newMessageLimiter(config Config) *messageLimiter {
+	return &messageLimiter{
+		messages: newTokenBucket(config.MessagesPerSecond, config.MessageBurst),
+		bytes:    newTokenBucket(config.BytesPerSecond, config.ByteBurst),
+	}
+}
+
+// check decides what to do with a message of size bytes. The first message over either limit gets
+// a warning; a client that keeps sending too fast for rateViolationWindow after it is disconnected.
+func (l *messageLimiter) check(size int, now time.Time) rateDecision {
+	// Both buckets are charged, so a client can't spend one while the other is empty
+	messageOK := l.messages.take(1, now)
+	bytesOK := l.bytes.take(float64(size), now)
+	if messageOK && bytesOK {
+		return rateAllowed
+	}
+
+	if l.warnedAt.IsZero() || now.Sub(l.warnedAt) > rateViolationWindow {
+		l.warnedAt = now
+		l.violations = 0
+		return rateWarn
+	}
+	l.violations++
+	if l.violations >= maxRateViolations {
+		return rateDisconnect
+	}
+	return rateDropped
+}
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ReconnectHint tells a client when and where to reconnect, so clients dropped together (for example
+// by a deploy) spread their reconnects out instead of all hitting the upgrade endpoint at once
+type ReconnectHint struct {
+	// RetryAfterMs is how long to wait before reconnecting. Each client gets a different, randomized delay.
+	RetryAfterMs int64 `json:"retryAfterMs"`
+
+	// MaintenanceUntil is when the server expects to be back (ISO 8601), if it is going down for maintenance
+	MaintenanceUntil string `json:"maintenanceUntil,omitempty"`
+
+	// AlternateURL is another WebSocket endpoint to try
+	AlternateURL string `json:"alternateUrl,omitempty"`
+}
+
+// ReconnectPayload is sent just before the server closes a connection it wants the client to re-open
+type ReconnectPayload struct {
+	Reason string        `json:"reason"`
+	Hint   ReconnectHint `json:"hint"`
+}
+
+// ErrorPayload reports a problem with a message from the client. Reconnect is set when the server
+// would rather the client came back later.
+type ErrorPayload struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Reconnect *ReconnectHint `json:"reconnect,omitempty"`
+}
+
+// ReconnectHint returns a hint for one client: the configured base delay plus random jitter, counted
+// from the end of the maintenance window if one is set
+func (h *Hub) ReconnectHint() ReconnectHint {
+	h.mu.RLock()
+	maintenanceUntil := h.maintenanceUntil
+	h.mu.RUnlock()
+
+	delay := h.config.ReconnectDelay
+	if h.config.ReconnectJitter > 0 {
+		delay += rand.N(h.config.ReconnectJitter)
+	}
+
+	hint := ReconnectHint{AlternateURL: h.config.AlternateURL}
+	if wait := time.Until(maintenanceUntil); wait > 0 {
+		delay += wait
+		hint.MaintenanceUntil = maintenanceUntil.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+	hint.RetryAfterMs = delay.Milliseconds()
+	return hint
+}
+
+// IsDraining reports whether the hub has stopped accepting connections
+func (h *Hub) IsDraining() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.draining
+}
+
+// Drain stops accepting connections and closes every open one with a "reconnect" message carrying a
+// per-client hint, followed by a 1012 (service restart) close frame. maintenanceUntil, if not zero, is
+// when the server expects to be back. Events still waiting to be sent go out first.
+func (h *Hub) Drain(reason string, maintenanceUntil time.Time) {
+	h.FlushEvents()
+
+	h.mu.Lock()
+	h.draining = true
+	h.maintenanceUntil = maintenanceUntil
+	var clients []*Client
+	for _, userClients := range h.clients {
+		for _, client := range userClients {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, client := range clients {
+		client.SendMessage(WSMessage{
+			Type:    MessageTypeReconnect,
+			Payload: ReconnectPayload{Reason: reason, Hint: h.ReconnectHint()},
+		})
+		client.closeCode = websocket.CloseServiceRestart
+		h.Unregister(client)
+	}
+}
@@ -0,0 +1,27 @@
+package websocket
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/redis"
+)
+
+// RedisBroker shares broadcasts through one Redis Pub/Sub channel
+type RedisBroker struct {
+	client  *redis.Client
+	channel string
+}
+
+func NewRedisBroker(client *redis.Client, channel string) *RedisBroker {
+	return &RedisBroker{client: client, channel: channel}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, _ uuid.UUID, data []byte) error {
+	_, err := b.client.Do(ctx, "PUBLISH", b.channel, string(data))
+	return err
+}
+
+func (b *RedisBroker) Run(ctx context.Context, receive func(data []byte)) {
+	b.client.Subscribe(ctx, b.channel, receive)
+}
@@ -0,0 +1,159 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// resumableTypes are the events numbered and kept for resuming: changes a client would otherwise
+// have to fetch with a full sync. Presence and editing indicators only matter while they happen.
+var resumableTypes = map[MessageType]bool{
+	MessageTypeNoteCreated:  true,
+	MessageTypeNoteUpdated:  true,
+	MessageTypeNoteDeleted:  true,
+	MessageTypeLinkPreviews: true,
+	MessageTypeNotification: true,
+	MessageTypeNoteOrder:    true,
+	MessageTypeNotePosition: true,
+}
+
+// MinResumeProtocolVersion is the first protocol version whose clients can resume
+const MinResumeProtocolVersion = 5
+
+var ErrInvalidResumePoint = errors.New("resume_from must be <epoch>:<seq>")
+
+// ResumePoint is the last event a client saw: the epoch and seq from connected or an event
+type ResumePoint struct {
+	Epoch string
+	Seq   int64
+}
+
+// ParseResumePoint parses the resume_from query parameter, "<epoch>:<seq>"
+func ParseResumePoint(value string) (*ResumePoint, error) {
+	epoch, seqText, ok := strings.Cut(value, ":")
+	if !ok || epoch == "" {
+		return nil, ErrInvalidResumePoint
+	}
+	seq, err := strconv.ParseInt(seqText, 10, 64)
+	if err != nil || seq < 0 {
+		return nil, ErrInvalidResumePoint
+	}
+	return &ResumePoint{Epoch: epoch, Seq: seq}, nil
+}
+
+// ResumedPayload is sent after the events a reconnecting client missed. SyncRequired means they
+// couldn't all be replayed, because the server restarted or they're no longer kept, and the
+// client should sync over REST instead.
+type ResumedPayload struct {
+	Replayed     int   `json:"replayed"`
+	Seq          int64 `json:"seq"`
+	SyncRequired bool  `json:"syncRequired"`
+}
+
+// resumeBuffer holds a user's most recent events, oldest first
+type resumeBuffer struct {
+	events []sequencedEvent
+	lastAt time.Time
+}
+
+type sequencedEvent struct {
+	seq  int64
+	data []byte
+}
+
+// Epoch identifies this run of the server; sequence numbers from another epoch can't be resumed from
+func (h *Hub) Epoch() string {
+	return h.epoch
+}
+
+// LastSeq returns the sequence number of the user's latest event
+func (h *Hub) LastSeq(userID uuid.UUID) int64 {
+	h.seqMu.Lock()
+	defer h.seqMu.Unlock()
+	return h.seqs[userID]
+}
+
+// sequence numbers an event and keeps it for resuming, returning it re-encoded with its seq. The
+// caller holds seqMu.
+func (h *Hub) sequence(userID uuid.UUID, msg *rawMessage, message []byte) []byte {
+	msg.Seq = h.seqs[userID] + 1
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Warn("Failed to number WebSocket event", "error", err)
+		return message
+	}
+	h.seqs[userID] = msg.Seq
+
+	buf := h.resume[userID]
+	if buf == nil {
+		buf = &resumeBuffer{}
+		h.resume[userID] = buf
+	}
+	buf.events = append(buf.events, sequencedEvent{seq: msg.Seq, data: data})
+	if excess := len(buf.events) - h.config.ResumeBufferSize; excess > 0 {
+		buf.events = append(buf.events[:0], buf.events[excess:]...)
+	}
+	buf.lastAt = time.Now()
+	return data
+}
+
+// replay sends a registering client the events it missed, then a resumed message. The caller
+// holds seqMu.
+func (h *Hub) replay(client *Client) {
+	from := client.ResumeFrom
+	last := h.seqs[client.UserID]
+	result := ResumedPayload{Seq: last}
+
+	var missed []sequencedEvent
+	switch {
+	case from.Epoch != h.epoch || from.Seq > last:
+		result.SyncRequired = true
+	case from.Seq < last:
+		buf := h.resume[client.UserID]
+		if buf == nil || len(buf.events) == 0 || buf.events[0].seq > from.Seq+1 {
+			result.SyncRequired = true
+			break
+		}
+		for _, event := range buf.events {
+			if event.seq > from.Seq {
+				missed = append(missed, event)
+			}
+		}
+		// Everything must fit in the send buffer, with room for resumed, or none is sent
+		if len(missed) >= cap(client.Send)-len(client.Send) {
+			result.SyncRequired = true
+			missed = nil
+		}
+	}
+
+	for _, event := range missed {
+		data, keep, err := client.encode(event.data)
+		if err != nil || !keep {
+			continue
+		}
+		select {
+		case client.Send <- data:
+			result.Replayed++
+		default:
+			result.SyncRequired = true
+		}
+	}
+	client.SendMessage(WSMessage{Type: MessageTypeResumed, Payload: result})
+}
+
+// expireResumeBuffers drops the events of users who have had none for ResumeWindow
+func (h *Hub) expireResumeBuffers(now time.Time) {
+	h.seqMu.Lock()
+	defer h.seqMu.Unlock()
+	for userID, buf := range h.resume {
+		if now.Sub(buf.lastAt) > h.config.ResumeWindow {
+			delete(h.resume, userID)
+		}
+	}
+}
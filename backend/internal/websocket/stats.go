@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// throughputWindow is how far back the broadcast rates in Stats look
+	throughputWindow = time.Minute
+
+	// maxStatsUsers caps how many users Stats lists connections for
+	maxStatsUsers = 100
+)
+
+// HubStats describes the hub's connections and traffic, the panics it has recovered from and the
+// messages slow clients missed
+type HubStats struct {
+	Connections int
+	Users       int
+	// The users with the most connections, most first
+	ConnectionsPerUser []UserConnections
+	// Connections by the protocol version they speak
+	ConnectionsByVersion map[int]int
+	BinaryConnections    int
+
+	// Totals since the server started, and per second over the last minute
+	Broadcasts          int64
+	MessagesDelivered   int64
+	BytesDelivered      int64
+	BroadcastsPerSecond float64
+	MessagesPerSecond   float64
+	BytesPerSecond      float64
+
+	// Events replaced by a later change to the same note before they were sent
+	EventsCoalesced int64
+
+	Panics          int64
+	LoopRestarts    int64
+	DroppedMessages int64
+	SlowDisconnects int64
+	LaggingClients  []ClientLag
+
+	RateLimitDisconnects int64
+
+	Goroutines int
+	Uptime     time.Duration
+}
+
+// UserConnections is how many connections a user has open
+type UserConnections struct {
+	UserID      uuid.UUID
+	Connections int
+}
+
+// throughputSample is the traffic counters at one moment
+type throughputSample struct {
+	at         time.Time
+	broadcasts int64
+	delivered  int64
+	bytes      int64
+}
+
+// sampleThroughput records the traffic counters, keeping throughputWindow of samples
+func (h *Hub) sampleThroughput(now time.Time) {
+	sample := throughputSample{
+		at:         now,
+		broadcasts: h.broadcasts.Load(),
+		delivered:  h.delivered.Load(),
+		bytes:      h.bytesSent.Load(),
+	}
+
+	h.samplesMu.Lock()
+	defer h.samplesMu.Unlock()
+	h.samples = append(h.samples, sample)
+	cutoff := now.Add(-throughputWindow)
+	for len(h.samples) > 1 && !h.samples[1].at.After(cutoff) {
+		h.samples = h.samples[1:]
+	}
+}
+
+// Stats returns the hub's connections by user and version, its traffic, how often it has recovered
+// from a panic, and how many messages were dropped for slow clients
+func (h *Hub) Stats() HubStats {
+	now := time.Now()
+	stats := HubStats{
+		ConnectionsByVersion: make(map[int]int),
+
+		Broadcasts:        h.broadcasts.Load(),
+		MessagesDelivered: h.delivered.Load(),
+		BytesDelivered:    h.bytesSent.Load(),
+		EventsCoalesced:   h.coalesced.Load(),
+
+		Panics:          h.panics.Load(),
+		LoopRestarts:    h.restarts.Load(),
+		DroppedMessages: h.dropped.Load(),
+		SlowDisconnects: h.slowDisconnects.Load(),
+		LaggingClients:  h.laggingClients(),
+
+		RateLimitDisconnects: h.rateLimitDisconnects.Load(),
+
+		Goroutines: runtime.NumGoroutine(),
+		Uptime:     now.Sub(h.startedAt),
+	}
+
+	h.mu.RLock()
+	stats.Users = len(h.clients)
+	for userID, userClients := range h.clients {
+		stats.Connections += len(userClients)
+		stats.ConnectionsPerUser = append(stats.ConnectionsPerUser, UserConnections{UserID: userID, Connections: len(userClients)})
+		for _, client := range userClients {
+			stats.ConnectionsByVersion[client.Version]++
+			if client.Binary {
+				stats.BinaryConnections++
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(stats.ConnectionsPerUser, func(i, j int) bool {
+		return stats.ConnectionsPerUser[i].Connections > stats.ConnectionsPerUser[j].Connections
+	})
+	if len(stats.ConnectionsPerUser) > maxStatsUsers {
+		stats.ConnectionsPerUser = stats.ConnectionsPerUser[:maxStatsUsers]
+	}
+
+	// Rates run from the oldest sample in the window, or from startup until there is one
+	h.samplesMu.Lock()
+	from := throughputSample{at: h.startedAt}
+	if len(h.samples) > 0 {
+		from = h.samples[0]
+	}
+	h.samplesMu.Unlock()
+	if elapsed := now.Sub(from.at).Seconds(); elapsed > 0 {
+		stats.BroadcastsPerSecond = float64(stats.Broadcasts-from.broadcasts) / elapsed
+		stats.MessagesPerSecond = float64(stats.MessagesDelivered-from.delivered) / elapsed
+		stats.BytesPerSecond = float64(stats.BytesDelivered-from.bytes) / elapsed
+	}
+	return stats
+}
@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+)
+
+const (
+	// maxSubscribedNotes and maxSubscribedContexts cap one connection's subscriptions
+	maxSubscribedNotes    = 500
+	maxSubscribedContexts = 50
+)
+
+// SubscriptionPayload names notes and ordering contexts to subscribe to or unsubscribe from. An
+// unsubscribe without either goes back to receiving everything.
+type SubscriptionPayload struct {
+	NoteIDs  []string `json:"noteIds,omitempty"`
+	Contexts []string `json:"contexts,omitempty"`
+}
+
+// SubscriptionsPayload is the connection's subscriptions after a subscribe or unsubscribe. All means
+// it receives every event.
+type SubscriptionsPayload struct {
+	All      bool     `json:"all"`
+	NoteIDs  []string `json:"noteIds"`
+	Contexts []string `json:"contexts"`
+}
+
+// subscription limits the events a connection receives. It is replaced, never changed, so deliver
+// can read it without a lock.
+type subscription struct {
+	notes    map[string]bool
+	contexts map[string]bool
+}
+
+// eventTarget is what a broadcast is about: a note, an ordering context, or neither for events like
+// notifications and presence, which every connection gets
+type eventTarget struct {
+	noteID  string
+	context string
+}
+
+// targetOf finds the note or ordering context an event concerns
+func targetOf(msg *rawMessage) eventTarget {
+	var payload struct {
+		NoteID string `json:"noteId"`
+		Note   struct {
+			ID string `json:"id"`
+		} `json:"note"`
+		Position struct {
+			NoteID string `json:"noteId"`
+		} `json:"position"`
+		Order struct {
+			Context string `json:"context"`
+		} `json:"order"`
+	}
+	if len(msg.Payload) == 0 || json.Unmarshal(msg.Payload, &payload) != nil {
+		return eventTarget{}
+	}
+
+	switch msg.Type {
+	case MessageTypeNoteCreated, MessageTypeNoteUpdated:
+		return eventTarget{noteID: payload.Note.ID}
+	case MessageTypeNoteDeleted, MessageTypeLinkPreviews, MessageTypeNoteEditingStarted, MessageTypeNoteEditingStopped:
+		return eventTarget{noteID: payload.NoteID}
+	case MessageTypeNotePosition:
+		return eventTarget{noteID: payload.Position.NoteID}
+	case MessageTypeNoteOrder:
+		return eventTarget{context: payload.Order.Context}
+	}
+	return eventTarget{}
+}
+
+// wants reports whether a connection with this subscription should get an event about target.
+// Without a subscription it gets everything.
+func (s *subscription) wants(target eventTarget) bool {
+	switch {
+	case s == nil:
+		return true
+	case target.noteID != "":
+		return s.notes[target.noteID]
+	case target.context != "":
+		return s.contexts[target.context]
+	}
+	return true
+}
+
+// wantsAll reports whether the client receives every event
+func (c *Client) wantsAll() bool {
+	return c.subscription.Load() == nil
+}
+
+// wants reports whether the client subscribed to events about target
+func (c *Client) wants(target eventTarget) bool {
+	return c.subscription.Load().wants(target)
+}
+
+// updateSubscription adds the payload's notes and contexts to the client's subscription, or removes
+// them when unsubscribing, and replies with the result
+func (c *Client) updateSubscription(msgType MessageType, payload SubscriptionPayload) {
+	noteIDs := make([]string, 0, len(payload.NoteIDs))
+	for _, id := range payload.NoteIDs {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			c.sendError("invalid_message", "noteIds must be note IDs")
+			return
+		}
+		noteIDs = append(noteIDs, parsed.String())
+	}
+	for _, context := range payload.Contexts {
+		if !models.IsValidOrderingContext(context) {
+			c.sendError("invalid_message", "contexts must be 'all', 'folder:<id>' or 'tag:<name>'")
+			return
+		}
+	}
+
+	c.subscriptionMu.Lock()
+	defer c.subscriptionMu.Unlock()
+
+	current := c.subscription.Load()
+	subscribe := msgType == MessageTypeSubscribe
+	if !subscribe && (current == nil || len(noteIDs) == 0 && len(payload.Contexts) == 0) {
+		// Back to everything, or already receiving everything with nothing narrower to remove from
+		c.subscription.Store(nil)
+		c.SendMessage(WSMessage{Type: MessageTypeSubscriptions, Payload: (*subscription)(nil).describe()})
+		return
+	}
+
+	next := &subscription{notes: make(map[string]bool), contexts: make(map[string]bool)}
+	if current != nil {
+		for id := range current.notes {
+			next.notes[id] = true
+		}
+		for context := range current.contexts {
+			next.contexts[context] = true
+		}
+	}
+	for _, id := range noteIDs {
+		if subscribe {
+			next.notes[id] = true
+		} else {
+			delete(next.notes, id)
+		}
+	}
+	for _, context := range payload.Contexts {
+		if subscribe {
+			next.contexts[context] = true
+		} else {
+			delete(next.contexts, context)
+		}
+	}
+	if len(next.notes) > maxSubscribedNotes || len(next.contexts) > maxSubscribedContexts {
+		c.sendError("too_many_subscriptions", "at most 500 notes and 50 contexts can be subscribed to")
+		return
+	}
+
+	c.subscription.Store(next)
+	c.SendMessage(WSMessage{Type: MessageTypeSubscriptions, Payload: next.describe()})
+}
+
+// describe lists a subscription's notes and contexts in order
+func (s *subscription) describe() SubscriptionsPayload {
+	if s == nil {
+		return SubscriptionsPayload{All: true, NoteIDs: []string{}, Contexts: []string{}}
+	}
+	payload := SubscriptionsPayload{
+		NoteIDs:  make([]string, 0, len(s.notes)),
+		Contexts: make([]string, 0, len(s.contexts)),
+	}
+	for id := range s.notes {
+		payload.NoteIDs = append(payload.NoteIDs, id)
+	}
+	for context := range s.contexts {
+		payload.Contexts = append(payload.Contexts, context)
+	}
+	sort.Strings(payload.NoteIDs)
+	sort.Strings(payload.Contexts)
+	return payload
+}
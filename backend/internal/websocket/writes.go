@@ -0,0 +1,169 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamishgilbert/notes-app/backend/internal/models"
+	"github.com/hamishgilbert/notes-app/backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MinWriteProtocolVersion is the oldest protocol version that can change notes over the socket, since
+// older clients don't receive note_ack
+const MinWriteProtocolVersion = 8
+
+// MinVersionedWriteProtocolVersion is the oldest protocol version whose note_update must carry the
+// version of the note being saved over. Older clients may leave it out and save over whatever is there.
+const MinVersionedWriteProtocolVersion = 12
+
+const (
+	// writeTimeout bounds one note write made over the socket
+	writeTimeout = 10 * time.Second
+
+	// maxWriteRefLength caps the ref clients use to match acks to their writes
+	maxWriteRefLength = 64
+)
+
+var (
+	ErrNoteNotFound    = errors.New("note not found")
+	ErrInvalidNote     = errors.New("invalid note")
+	ErrVersionConflict = errors.New("note was changed by another request")
+	ErrQuotaExceeded   = errors.New("storage quota exceeded")
+)
+
+// NoteWriter saves the note changes clients send over the socket, the same way as the REST
+// endpoints, and broadcasts them to the user's other connections. Errors wrap ErrNoteNotFound,
+// ErrInvalidNote, ErrVersionConflict or ErrQuotaExceeded when the client is at fault; with ErrVersionConflict
+// UpdateNote returns the current note.
+type NoteWriter interface {
+	UpdateNote(ctx context.Context, source WriteSource, note models.NoteDTO) (models.NoteDTO, error)
+	DeleteNote(ctx context.Context, source WriteSource, noteID uuid.UUID) error
+}
+
+// WriteSource is the connection a write came from, which the change isn't broadcast back to
+type WriteSource struct {
+	UserID       uuid.UUID
+	ConnectionID string
+	RequestID    string // generated per write, like X-Request-ID, for tracing
+	ClientIP     string
+	UserAgent    string
+}
+
+// NoteUpdateRequestPayload is sent by a client to save a note, like PUT /api/notes/:id
+type NoteUpdateRequestPayload struct {
+	Ref  string         `json:"ref,omitempty"`
+	Note models.NoteDTO `json:"note"`
+}
+
+// NoteDeleteRequestPayload is sent by a client to delete a note, like DELETE /api/notes/:id
+type NoteDeleteRequestPayload struct {
+	Ref    string `json:"ref,omitempty"`
+	NoteID string `json:"noteId"`
+}
+
+// NoteAckPayload answers a note_update or note_delete with the saved note or why it failed. Ref is
+// the client's, and RequestID identifies the write in logs and on the broadcast.
+type NoteAckPayload struct {
+	Ref       string          `json:"ref,omitempty"`
+	RequestID string          `json:"requestId"`
+	OK        bool            `json:"ok"`
+	Note      *models.NoteDTO `json:"note,omitempty"`
+	NoteID    string          `json:"noteId,omitempty"`
+	Error     *ErrorPayload   `json:"error,omitempty"`
+}
+
+// SetNoteWriter lets clients change notes over the socket; without one, writes are refused
+func (h *Hub) SetNoteWriter(writer NoteWriter) {
+	h.noteWriter = writer
+}
+
+// writeNote handles a note_update or note_delete and acks it. Writes are handled one at a time, in
+// the order the client sent them.
+func (c *Client) writeNote(msgType MessageType, ref string, note *models.NoteDTO, noteID string) {
+	if c.Version < MinWriteProtocolVersion {
+		c.sendError("unknown_type", "note writes need protocol version 8")
+		return
+	}
+
+	ack := NoteAckPayload{Ref: ref, RequestID: uuid.New().String(), NoteID: noteID}
+	fail := func(code, message string) {
+		ack.Error = &ErrorPayload{Code: code, Message: message}
+		c.SendMessage(WSMessage{Type: MessageTypeNoteAck, Payload: ack, RequestID: ack.RequestID})
+	}
+
+	if len(ref) > maxWriteRefLength {
+		fail("invalid_message", "ref is too long")
+		return
+	}
+	writer := c.Hub.noteWriter
+	if writer == nil {
+		fail("unavailable", "notes can't be changed over this connection")
+		return
+	}
+	if c.isReadOnly() {
+		fail("read_only", "this account is read-only")
+		return
+	}
+	if maintenance := c.Hub.Maintenance(); maintenance.Enabled {
+		fail("maintenance", "the server is down for maintenance; try again later")
+		return
+	}
+	id, err := uuid.Parse(noteID)
+	if err != nil {
+		fail("invalid_message", "payload needs a valid note ID")
+		return
+	}
+	if msgType == MessageTypeNoteUpdate && note.Version == 0 && c.Version >= MinVersionedWriteProtocolVersion {
+		fail("invalid_note", "version is required")
+		return
+	}
+
+	source := WriteSource{
+		UserID:       c.UserID,
+		ConnectionID: c.ID,
+		RequestID:    ack.RequestID,
+		ClientIP:     c.ClientIP,
+		UserAgent:    c.UserAgent,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	ctx, span := tracing.Tracer().Start(ctx, "websocket "+string(msgType), trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("enduser.id", c.UserID.String()),
+		attribute.String("request.id", ack.RequestID),
+	))
+	defer span.End()
+
+	if msgType == MessageTypeNoteUpdate {
+		note.ID = id.String()
+		var saved models.NoteDTO
+		if saved, err = writer.UpdateNote(ctx, source, *note); err == nil || errors.Is(err, ErrVersionConflict) {
+			ack.Note = &saved
+		}
+	} else {
+		err = writer.DeleteNote(ctx, source, id)
+	}
+
+	switch {
+	case err == nil:
+		ack.OK = true
+		c.SendMessage(WSMessage{Type: MessageTypeNoteAck, Payload: ack, RequestID: ack.RequestID})
+	case errors.Is(err, ErrNoteNotFound):
+		fail("note_not_found", "note not found")
+	case errors.Is(err, ErrInvalidNote):
+		fail("invalid_note", err.Error())
+	case errors.Is(err, ErrVersionConflict):
+		fail("version_conflict", "note was changed by another request")
+	case errors.Is(err, ErrQuotaExceeded):
+		fail("quota_exceeded", err.Error())
+	default:
+		span.SetStatus(codes.Error, err.Error())
+		slog.ErrorContext(ctx, "WebSocket note write failed", "type", msgType, "note_id", noteID, "connection_id", c.ID, "user_id", c.UserID.String(), "request_id", ack.RequestID, "error", err)
+		fail("write_failed", "note could not be saved")
+	}
+}
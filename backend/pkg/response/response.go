@@ -4,11 +4,24 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hamishgilbert/notes-app/backend/internal/requestid"
 )
 
+// ErrorResponse is the body of every error. RequestID matches the X-Request-ID header and the
+// request_id of the server's log lines for the request.
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
+	Error     string `json:"error"`
+	Message   string `json:"message,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Error responds with status and an ErrorResponse carrying code and message
+func Error(c *gin.Context, status int, code, message string) {
+	c.JSON(status, ErrorResponse{
+		Error:     code,
+		Message:   message,
+		RequestID: requestid.FromContext(c.Request.Context()),
+	})
 }
 
 func Success(c *gin.Context, data interface{}) {
@@ -19,48 +32,51 @@ func Created(c *gin.Context, data interface{}) {
 	c.JSON(http.StatusCreated, data)
 }
 
+// Accepted reports that work was started and will finish in the background
+func Accepted(c *gin.Context, data interface{}) {
+	c.JSON(http.StatusAccepted, data)
+}
+
 func NoContent(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
 func BadRequest(c *gin.Context, message string) {
-	c.JSON(http.StatusBadRequest, ErrorResponse{
-		Error:   "bad_request",
-		Message: message,
-	})
+	Error(c, http.StatusBadRequest, "bad_request", message)
 }
 
 func Unauthorized(c *gin.Context, message string) {
-	c.JSON(http.StatusUnauthorized, ErrorResponse{
-		Error:   "unauthorized",
-		Message: message,
-	})
+	Error(c, http.StatusUnauthorized, "unauthorized", message)
 }
 
 func Forbidden(c *gin.Context, message string) {
-	c.JSON(http.StatusForbidden, ErrorResponse{
-		Error:   "forbidden",
-		Message: message,
-	})
+	Error(c, http.StatusForbidden, "forbidden", message)
 }
 
 func NotFound(c *gin.Context, message string) {
-	c.JSON(http.StatusNotFound, ErrorResponse{
-		Error:   "not_found",
-		Message: message,
-	})
+	Error(c, http.StatusNotFound, "not_found", message)
 }
 
 func Conflict(c *gin.Context, message string) {
-	c.JSON(http.StatusConflict, ErrorResponse{
-		Error:   "conflict",
-		Message: message,
-	})
+	Error(c, http.StatusConflict, "conflict", message)
+}
+
+func PayloadTooLarge(c *gin.Context, message string) {
+	Error(c, http.StatusRequestEntityTooLarge, "payload_too_large", message)
+}
+
+func UnsupportedMediaType(c *gin.Context, message string) {
+	Error(c, http.StatusUnsupportedMediaType, "unsupported_media_type", message)
+}
+
+func TooManyRequests(c *gin.Context, message string) {
+	Error(c, http.StatusTooManyRequests, "too_many_requests", message)
 }
 
 func InternalError(c *gin.Context, message string) {
-	c.JSON(http.StatusInternalServerError, ErrorResponse{
-		Error:   "internal_error",
-		Message: message,
-	})
+	Error(c, http.StatusInternalServerError, "internal_error", message)
+}
+
+func GatewayTimeout(c *gin.Context, message string) {
+	Error(c, http.StatusGatewayTimeout, "timeout", message)
 }
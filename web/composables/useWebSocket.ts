@@ -1,19 +1,32 @@
 import type { NoteDTO } from '~/types'
+import { api } from '~/utils/api'
 
 export type WSMessageType =
+  | 'connected'
   | 'note_created'
   | 'note_updated'
   | 'note_deleted'
+  | 'link_previews_updated'
+  | 'notification'
+  | 'note_order_updated'
   | 'sync_request'
   | 'sync_response'
   | 'ping'
   | 'pong'
+  | 'reconnect'
+  | 'error'
 
 export type ConnectionStatus = 'disconnected' | 'connecting' | 'connected'
 
 export interface WSMessage {
   type: WSMessageType
   payload?: unknown
+  v?: number
+}
+
+export interface ConnectedPayload {
+  connectionId: string
+  protocolVersion?: number
 }
 
 export interface NoteChangePayload {
@@ -24,6 +37,24 @@ export interface NoteDeletePayload {
   noteId: string
 }
 
+// Sent by the server when it drops connections, so clients spread out their reconnects
+export interface ReconnectHint {
+  retryAfterMs: number
+  maintenanceUntil?: string
+  alternateUrl?: string
+}
+
+export interface ReconnectPayload {
+  reason: string
+  hint: ReconnectHint
+}
+
+export interface ErrorPayload {
+  code: string
+  message: string
+  reconnect?: ReconnectHint
+}
+
 // Shared state across all components
 const socket = ref<WebSocket | null>(null)
 const connectionStatus = ref<ConnectionStatus>('disconnected')
@@ -36,14 +67,18 @@ const PING_INTERVAL = 30000
 let reconnectTimeout: ReturnType<typeof setTimeout> | null = null
 let pingInterval: ReturnType<typeof setInterval> | null = null
 let reconnectDelay = INITIAL_RECONNECT_DELAY
+let serverHint: ReconnectHint | null = null
 
 // WebSocket authentication protocol name (must match server)
 const WS_AUTH_PROTOCOL = 'access_token'
 
+// Message format version this client understands; the server converts messages down to it
+const WS_PROTOCOL_VERSION = 2
+
 export function useWebSocket() {
   const config = useRuntimeConfig()
 
-  const connect = (token: string) => {
+  const connect = (token: string, url?: string) => {
     if (!token || socket.value?.readyState === WebSocket.OPEN) {
       return
     }
@@ -52,7 +87,8 @@ export function useWebSocket() {
 
     // Convert HTTP URL to WebSocket URL (without token in URL for security)
     const baseUrl = config.public.apiBase as string
-    const wsUrl = baseUrl.replace(/^http/, 'ws') + '/api/ws'
+    const wsUrl = new URL(url ?? baseUrl.replace(/^http/, 'ws') + '/api/ws')
+    wsUrl.searchParams.set('v', String(WS_PROTOCOL_VERSION))
 
     try {
       // Use Sec-WebSocket-Protocol header for authentication
@@ -90,6 +126,12 @@ export function useWebSocket() {
     const notesStore = useNotesStore()
 
     switch (message.type) {
+      case 'connected': {
+        const payload = message.payload as ConnectedPayload
+        api.setConnectionId(payload?.connectionId ?? null)
+        break
+      }
+
       case 'note_created':
       case 'note_updated': {
         const payload = message.payload as NoteChangePayload
@@ -114,6 +156,20 @@ export function useWebSocket() {
         // Connection is alive, nothing to do
         break
 
+      case 'reconnect': {
+        const payload = message.payload as ReconnectPayload
+        serverHint = payload?.hint ?? null
+        break
+      }
+
+      case 'error': {
+        const payload = message.payload as ErrorPayload
+        if (payload?.reconnect) {
+          serverHint = payload.reconnect
+        }
+        break
+      }
+
       default:
         // Unknown message type - ignore in production
         break
@@ -122,6 +178,7 @@ export function useWebSocket() {
 
   const handleClose = (event: CloseEvent) => {
     connectionStatus.value = 'disconnected'
+    api.setConnectionId(null)
     stopPingInterval()
 
     // Only attempt reconnect if not a clean close
@@ -138,12 +195,23 @@ export function useWebSocket() {
   }
 
   const attemptReconnect = (token: string) => {
-    if (reconnectAttempts.value >= MAX_RECONNECT_ATTEMPTS) {
+    if (reconnectTimeout) {
+      clearTimeout(reconnectTimeout)
+    }
+
+    // The server asked us to come back at a particular time (e.g. after a deploy); this doesn't
+    // count as a failed attempt
+    if (serverHint) {
+      const hint = serverHint
+      serverHint = null
+      reconnectTimeout = setTimeout(() => {
+        connect(token, hint.alternateUrl)
+      }, hint.retryAfterMs)
       return
     }
 
-    if (reconnectTimeout) {
-      clearTimeout(reconnectTimeout)
+    if (reconnectAttempts.value >= MAX_RECONNECT_ATTEMPTS) {
+      return
     }
 
     reconnectTimeout = setTimeout(() => {
@@ -164,9 +232,11 @@ export function useWebSocket() {
       socket.value.close(1000, 'Client disconnect')
       socket.value = null
     }
+    api.setConnectionId(null)
     connectionStatus.value = 'disconnected'
     reconnectAttempts.value = 0
     reconnectDelay = INITIAL_RECONNECT_DELAY
+    serverHint = null
   }
 
   const sendMessage = (message: WSMessage) => {
@@ -54,7 +54,7 @@ export const useNotesStore = defineStore('notes', {
         }
 
         // Remove deleted notes
-        for (const id of response.deletedNoteIDs) {
+        for (const id of response.deletedNoteIds) {
           const index = this.notes.findIndex(n => n.id === id)
           if (index !== -1) {
             this.notes.splice(index, 1)
@@ -330,18 +330,24 @@ export const useNotesStore = defineStore('notes', {
       }
 
       try {
-        const response = await api.syncNotes({
+        let response = await api.syncNotes({
           changes: pendingNotes.map(n => this.noteToDTO(n)),
           deletedIDs: [...this.pendingDeletions],
           lastSync: this.lastSyncDate ?? undefined
         })
 
-        for (const dto of response.notes) {
-          this.upsertFromDTO(dto)
-        }
-        for (const id of response.deletedNoteIDs) {
-          const index = this.notes.findIndex(n => n.id === id)
-          if (index !== -1) this.notes.splice(index, 1)
+        // Large syncs arrive in pages; keep fetching until the server has sent everything
+        for (;;) {
+          for (const dto of response.notes) {
+            this.upsertFromDTO(dto)
+          }
+          for (const id of response.deletedNoteIds) {
+            const index = this.notes.findIndex(n => n.id === id)
+            if (index !== -1) this.notes.splice(index, 1)
+          }
+
+          if (!response.hasMore || !response.batchToken) break
+          response = await api.syncNotes({ changes: [], deletedIDs: [], batchToken: response.batchToken })
         }
 
         this.pendingDeletions = []
@@ -41,12 +41,15 @@ export interface SyncRequest {
   changes: NoteDTO[]
   deletedIDs: string[]
   lastSync?: string
+  batchToken?: string
 }
 
 export interface SyncResponse {
   notes: NoteDTO[]
-  deletedNoteIDs: string[]
+  deletedNoteIds: string[]
   serverTimestamp: string
+  batchToken?: string
+  hasMore?: boolean
 }
 
 export interface User {
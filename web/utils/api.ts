@@ -3,6 +3,7 @@ import type { AuthRequest, AuthResponse, ChangePasswordRequest, NoteDTO, Refresh
 class ApiClient {
   private baseUrl: string = ''
   private token: string | null = null
+  private connectionId: string | null = null
 
   configure(baseUrl: string) {
     this.baseUrl = baseUrl
@@ -12,6 +13,11 @@ class ApiClient {
     this.token = token
   }
 
+  // WebSocket connection ID, sent so the server doesn't echo our own changes back to us
+  setConnectionId(connectionId: string | null) {
+    this.connectionId = connectionId
+  }
+
   private async request<T>(
     method: string,
     path: string,
@@ -19,13 +25,18 @@ class ApiClient {
   ): Promise<T> {
     const headers: Record<string, string> = {
       'Content-Type': 'application/json',
-      'Accept': 'application/json'
+      'Accept': 'application/json',
+      'X-API-Version': '2'
     }
 
     if (this.token) {
       headers['Authorization'] = `Bearer ${this.token}`
     }
 
+    if (this.connectionId) {
+      headers['X-Connection-ID'] = this.connectionId
+    }
+
     const response = await fetch(`${this.baseUrl}${path}`, {
       method,
       headers,